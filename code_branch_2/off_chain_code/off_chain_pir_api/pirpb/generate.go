@@ -0,0 +1,5 @@
+// Package pirpb holds the protobuf messages and gRPC stubs of the
+// off-chain PIR server's gRPC API (pir.proto).
+package pirpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative pir.proto
@@ -0,0 +1,726 @@
+// gRPC API of the off-chain PIR server. It carries the same calls as the
+// JSON /invoke endpoint, but ciphertexts travel as raw bytes (no Base64)
+// over HTTP/2, like the Fabric gateway path the off-chain baseline is
+// benchmarked against.
+//
+// Regenerate pir.pb.go and pir_grpc.pb.go with `go generate` in this
+// directory (protoc, protoc-gen-go and protoc-gen-go-grpc on PATH).
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v5.29.3
+// source: pir.proto
+
+package pirpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type InitLedgerRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Dataset       string                 `protobuf:"bytes,1,opt,name=dataset,proto3" json:"dataset,omitempty"` // empty → "default"
+	NumRecords    int32                  `protobuf:"varint,2,opt,name=num_records,json=numRecords,proto3" json:"num_records,omitempty"`
+	MaxJsonLength int32                  `protobuf:"varint,3,opt,name=max_json_length,json=maxJsonLength,proto3" json:"max_json_length,omitempty"`
+	LogN          int32                  `protobuf:"varint,4,opt,name=log_n,json=logN,proto3" json:"log_n,omitempty"` // 0 → auto-select
+	LogQi         []int32                `protobuf:"varint,5,rep,packed,name=log_qi,json=logQi,proto3" json:"log_qi,omitempty"`
+	LogPi         []int32                `protobuf:"varint,6,rep,packed,name=log_pi,json=logPi,proto3" json:"log_pi,omitempty"`
+	T             uint64                 `protobuf:"varint,7,opt,name=t,proto3" json:"t,omitempty"`             // 0 → 65537
+	Reserve       int32                  `protobuf:"varint,8,opt,name=reserve,proto3" json:"reserve,omitempty"` // zero windows held for future appends
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InitLedgerRequest) Reset() {
+	*x = InitLedgerRequest{}
+	mi := &file_pir_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InitLedgerRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InitLedgerRequest) ProtoMessage() {}
+
+func (x *InitLedgerRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pir_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InitLedgerRequest.ProtoReflect.Descriptor instead.
+func (*InitLedgerRequest) Descriptor() ([]byte, []int) {
+	return file_pir_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *InitLedgerRequest) GetDataset() string {
+	if x != nil {
+		return x.Dataset
+	}
+	return ""
+}
+
+func (x *InitLedgerRequest) GetNumRecords() int32 {
+	if x != nil {
+		return x.NumRecords
+	}
+	return 0
+}
+
+func (x *InitLedgerRequest) GetMaxJsonLength() int32 {
+	if x != nil {
+		return x.MaxJsonLength
+	}
+	return 0
+}
+
+func (x *InitLedgerRequest) GetLogN() int32 {
+	if x != nil {
+		return x.LogN
+	}
+	return 0
+}
+
+func (x *InitLedgerRequest) GetLogQi() []int32 {
+	if x != nil {
+		return x.LogQi
+	}
+	return nil
+}
+
+func (x *InitLedgerRequest) GetLogPi() []int32 {
+	if x != nil {
+		return x.LogPi
+	}
+	return nil
+}
+
+func (x *InitLedgerRequest) GetT() uint64 {
+	if x != nil {
+		return x.T
+	}
+	return 0
+}
+
+func (x *InitLedgerRequest) GetReserve() int32 {
+	if x != nil {
+		return x.Reserve
+	}
+	return 0
+}
+
+type InitLedgerResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	NRecords      int32                  `protobuf:"varint,1,opt,name=n_records,json=nRecords,proto3" json:"n_records,omitempty"` // reserved indices included
+	RecordS       int32                  `protobuf:"varint,2,opt,name=record_s,json=recordS,proto3" json:"record_s,omitempty"`
+	LogN          int32                  `protobuf:"varint,3,opt,name=log_n,json=logN,proto3" json:"log_n,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InitLedgerResponse) Reset() {
+	*x = InitLedgerResponse{}
+	mi := &file_pir_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InitLedgerResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InitLedgerResponse) ProtoMessage() {}
+
+func (x *InitLedgerResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pir_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InitLedgerResponse.ProtoReflect.Descriptor instead.
+func (*InitLedgerResponse) Descriptor() ([]byte, []int) {
+	return file_pir_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *InitLedgerResponse) GetNRecords() int32 {
+	if x != nil {
+		return x.NRecords
+	}
+	return 0
+}
+
+func (x *InitLedgerResponse) GetRecordS() int32 {
+	if x != nil {
+		return x.RecordS
+	}
+	return 0
+}
+
+func (x *InitLedgerResponse) GetLogN() int32 {
+	if x != nil {
+		return x.LogN
+	}
+	return 0
+}
+
+type GetMetadataRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Dataset       string                 `protobuf:"bytes,1,opt,name=dataset,proto3" json:"dataset,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetMetadataRequest) Reset() {
+	*x = GetMetadataRequest{}
+	mi := &file_pir_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetMetadataRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetMetadataRequest) ProtoMessage() {}
+
+func (x *GetMetadataRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pir_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetMetadataRequest.ProtoReflect.Descriptor instead.
+func (*GetMetadataRequest) Descriptor() ([]byte, []int) {
+	return file_pir_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetMetadataRequest) GetDataset() string {
+	if x != nil {
+		return x.Dataset
+	}
+	return ""
+}
+
+type Metadata struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	N             int32                  `protobuf:"varint,1,opt,name=n,proto3" json:"n,omitempty"`
+	RecordS       int32                  `protobuf:"varint,2,opt,name=record_s,json=recordS,proto3" json:"record_s,omitempty"`
+	LogN          int32                  `protobuf:"varint,3,opt,name=log_n,json=logN,proto3" json:"log_n,omitempty"`
+	RingN         int32                  `protobuf:"varint,4,opt,name=ring_n,json=ringN,proto3" json:"ring_n,omitempty"` // N = 2^log_n
+	T             uint64                 `protobuf:"varint,5,opt,name=t,proto3" json:"t,omitempty"`
+	LogQi         []int32                `protobuf:"varint,6,rep,packed,name=log_qi,json=logQi,proto3" json:"log_qi,omitempty"`
+	LogPi         []int32                `protobuf:"varint,7,rep,packed,name=log_pi,json=logPi,proto3" json:"log_pi,omitempty"`
+	ReservedFrom  int32                  `protobuf:"varint,8,opt,name=reserved_from,json=reservedFrom,proto3" json:"reserved_from,omitempty"` // 0 = none
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Metadata) Reset() {
+	*x = Metadata{}
+	mi := &file_pir_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Metadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Metadata) ProtoMessage() {}
+
+func (x *Metadata) ProtoReflect() protoreflect.Message {
+	mi := &file_pir_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Metadata.ProtoReflect.Descriptor instead.
+func (*Metadata) Descriptor() ([]byte, []int) {
+	return file_pir_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Metadata) GetN() int32 {
+	if x != nil {
+		return x.N
+	}
+	return 0
+}
+
+func (x *Metadata) GetRecordS() int32 {
+	if x != nil {
+		return x.RecordS
+	}
+	return 0
+}
+
+func (x *Metadata) GetLogN() int32 {
+	if x != nil {
+		return x.LogN
+	}
+	return 0
+}
+
+func (x *Metadata) GetRingN() int32 {
+	if x != nil {
+		return x.RingN
+	}
+	return 0
+}
+
+func (x *Metadata) GetT() uint64 {
+	if x != nil {
+		return x.T
+	}
+	return 0
+}
+
+func (x *Metadata) GetLogQi() []int32 {
+	if x != nil {
+		return x.LogQi
+	}
+	return nil
+}
+
+func (x *Metadata) GetLogPi() []int32 {
+	if x != nil {
+		return x.LogPi
+	}
+	return nil
+}
+
+func (x *Metadata) GetReservedFrom() int32 {
+	if x != nil {
+		return x.ReservedFrom
+	}
+	return 0
+}
+
+type PIRQueryRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Dataset       string                 `protobuf:"bytes,1,opt,name=dataset,proto3" json:"dataset,omitempty"`
+	CtQ           []byte                 `protobuf:"bytes,2,opt,name=ct_q,json=ctQ,proto3" json:"ct_q,omitempty"` // marshalled rlwe.Ciphertext
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PIRQueryRequest) Reset() {
+	*x = PIRQueryRequest{}
+	mi := &file_pir_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PIRQueryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PIRQueryRequest) ProtoMessage() {}
+
+func (x *PIRQueryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pir_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PIRQueryRequest.ProtoReflect.Descriptor instead.
+func (*PIRQueryRequest) Descriptor() ([]byte, []int) {
+	return file_pir_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *PIRQueryRequest) GetDataset() string {
+	if x != nil {
+		return x.Dataset
+	}
+	return ""
+}
+
+func (x *PIRQueryRequest) GetCtQ() []byte {
+	if x != nil {
+		return x.CtQ
+	}
+	return nil
+}
+
+type PIRQueryResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CtR           []byte                 `protobuf:"bytes,1,opt,name=ct_r,json=ctR,proto3" json:"ct_r,omitempty"` // marshalled rlwe.Ciphertext
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PIRQueryResponse) Reset() {
+	*x = PIRQueryResponse{}
+	mi := &file_pir_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PIRQueryResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PIRQueryResponse) ProtoMessage() {}
+
+func (x *PIRQueryResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pir_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PIRQueryResponse.ProtoReflect.Descriptor instead.
+func (*PIRQueryResponse) Descriptor() ([]byte, []int) {
+	return file_pir_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *PIRQueryResponse) GetCtR() []byte {
+	if x != nil {
+		return x.CtR
+	}
+	return nil
+}
+
+type PIRQueryTimedResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CtR           []byte                 `protobuf:"bytes,1,opt,name=ct_r,json=ctR,proto3" json:"ct_r,omitempty"`
+	EvalMs        float64                `protobuf:"fixed64,2,opt,name=eval_ms,json=evalMs,proto3" json:"eval_ms,omitempty"`
+	CpuMs         float64                `protobuf:"fixed64,3,opt,name=cpu_ms,json=cpuMs,proto3" json:"cpu_ms,omitempty"` // -1 where unsupported
+	AllocBytes    uint64                 `protobuf:"varint,4,opt,name=alloc_bytes,json=allocBytes,proto3" json:"alloc_bytes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PIRQueryTimedResponse) Reset() {
+	*x = PIRQueryTimedResponse{}
+	mi := &file_pir_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PIRQueryTimedResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PIRQueryTimedResponse) ProtoMessage() {}
+
+func (x *PIRQueryTimedResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_pir_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PIRQueryTimedResponse.ProtoReflect.Descriptor instead.
+func (*PIRQueryTimedResponse) Descriptor() ([]byte, []int) {
+	return file_pir_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *PIRQueryTimedResponse) GetCtR() []byte {
+	if x != nil {
+		return x.CtR
+	}
+	return nil
+}
+
+func (x *PIRQueryTimedResponse) GetEvalMs() float64 {
+	if x != nil {
+		return x.EvalMs
+	}
+	return 0
+}
+
+func (x *PIRQueryTimedResponse) GetCpuMs() float64 {
+	if x != nil {
+		return x.CpuMs
+	}
+	return 0
+}
+
+func (x *PIRQueryTimedResponse) GetAllocBytes() uint64 {
+	if x != nil {
+		return x.AllocBytes
+	}
+	return 0
+}
+
+// QueryChunk carries a piece of ct_q; dataset is read from the first one.
+type QueryChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Dataset       string                 `protobuf:"bytes,1,opt,name=dataset,proto3" json:"dataset,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryChunk) Reset() {
+	*x = QueryChunk{}
+	mi := &file_pir_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryChunk) ProtoMessage() {}
+
+func (x *QueryChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_pir_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryChunk.ProtoReflect.Descriptor instead.
+func (*QueryChunk) Descriptor() ([]byte, []int) {
+	return file_pir_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *QueryChunk) GetDataset() string {
+	if x != nil {
+		return x.Dataset
+	}
+	return ""
+}
+
+func (x *QueryChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+// ResultChunk carries a piece of ct_r; eval_ms is set on the first one.
+type ResultChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Data          []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	EvalMs        float64                `protobuf:"fixed64,2,opt,name=eval_ms,json=evalMs,proto3" json:"eval_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResultChunk) Reset() {
+	*x = ResultChunk{}
+	mi := &file_pir_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResultChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResultChunk) ProtoMessage() {}
+
+func (x *ResultChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_pir_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResultChunk.ProtoReflect.Descriptor instead.
+func (*ResultChunk) Descriptor() ([]byte, []int) {
+	return file_pir_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ResultChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *ResultChunk) GetEvalMs() float64 {
+	if x != nil {
+		return x.EvalMs
+	}
+	return 0
+}
+
+var File_pir_proto protoreflect.FileDescriptor
+
+const file_pir_proto_rawDesc = "" +
+	"\n" +
+	"\tpir.proto\x12\x06pir.v1\"\xe1\x01\n" +
+	"\x11InitLedgerRequest\x12\x18\n" +
+	"\adataset\x18\x01 \x01(\tR\adataset\x12\x1f\n" +
+	"\vnum_records\x18\x02 \x01(\x05R\n" +
+	"numRecords\x12&\n" +
+	"\x0fmax_json_length\x18\x03 \x01(\x05R\rmaxJsonLength\x12\x13\n" +
+	"\x05log_n\x18\x04 \x01(\x05R\x04logN\x12\x15\n" +
+	"\x06log_qi\x18\x05 \x03(\x05R\x05logQi\x12\x15\n" +
+	"\x06log_pi\x18\x06 \x03(\x05R\x05logPi\x12\f\n" +
+	"\x01t\x18\a \x01(\x04R\x01t\x12\x18\n" +
+	"\areserve\x18\b \x01(\x05R\areserve\"a\n" +
+	"\x12InitLedgerResponse\x12\x1b\n" +
+	"\tn_records\x18\x01 \x01(\x05R\bnRecords\x12\x19\n" +
+	"\brecord_s\x18\x02 \x01(\x05R\arecordS\x12\x13\n" +
+	"\x05log_n\x18\x03 \x01(\x05R\x04logN\".\n" +
+	"\x12GetMetadataRequest\x12\x18\n" +
+	"\adataset\x18\x01 \x01(\tR\adataset\"\xc0\x01\n" +
+	"\bMetadata\x12\f\n" +
+	"\x01n\x18\x01 \x01(\x05R\x01n\x12\x19\n" +
+	"\brecord_s\x18\x02 \x01(\x05R\arecordS\x12\x13\n" +
+	"\x05log_n\x18\x03 \x01(\x05R\x04logN\x12\x15\n" +
+	"\x06ring_n\x18\x04 \x01(\x05R\x05ringN\x12\f\n" +
+	"\x01t\x18\x05 \x01(\x04R\x01t\x12\x15\n" +
+	"\x06log_qi\x18\x06 \x03(\x05R\x05logQi\x12\x15\n" +
+	"\x06log_pi\x18\a \x03(\x05R\x05logPi\x12#\n" +
+	"\rreserved_from\x18\b \x01(\x05R\freservedFrom\">\n" +
+	"\x0fPIRQueryRequest\x12\x18\n" +
+	"\adataset\x18\x01 \x01(\tR\adataset\x12\x11\n" +
+	"\x04ct_q\x18\x02 \x01(\fR\x03ctQ\"%\n" +
+	"\x10PIRQueryResponse\x12\x11\n" +
+	"\x04ct_r\x18\x01 \x01(\fR\x03ctR\"{\n" +
+	"\x15PIRQueryTimedResponse\x12\x11\n" +
+	"\x04ct_r\x18\x01 \x01(\fR\x03ctR\x12\x17\n" +
+	"\aeval_ms\x18\x02 \x01(\x01R\x06evalMs\x12\x15\n" +
+	"\x06cpu_ms\x18\x03 \x01(\x01R\x05cpuMs\x12\x1f\n" +
+	"\valloc_bytes\x18\x04 \x01(\x04R\n" +
+	"allocBytes\":\n" +
+	"\n" +
+	"QueryChunk\x12\x18\n" +
+	"\adataset\x18\x01 \x01(\tR\adataset\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\":\n" +
+	"\vResultChunk\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data\x12\x17\n" +
+	"\aeval_ms\x18\x02 \x01(\x01R\x06evalMs2\xce\x02\n" +
+	"\x03PIR\x12C\n" +
+	"\n" +
+	"InitLedger\x12\x19.pir.v1.InitLedgerRequest\x1a\x1a.pir.v1.InitLedgerResponse\x12;\n" +
+	"\vGetMetadata\x12\x1a.pir.v1.GetMetadataRequest\x1a\x10.pir.v1.Metadata\x12=\n" +
+	"\bPIRQuery\x12\x17.pir.v1.PIRQueryRequest\x1a\x18.pir.v1.PIRQueryResponse\x12G\n" +
+	"\rPIRQueryTimed\x12\x17.pir.v1.PIRQueryRequest\x1a\x1d.pir.v1.PIRQueryTimedResponse\x12=\n" +
+	"\x0ePIRQueryStream\x12\x12.pir.v1.QueryChunk\x1a\x13.pir.v1.ResultChunk(\x010\x01B\x19Z\x17off-chain-pir-api/pirpbb\x06proto3"
+
+var (
+	file_pir_proto_rawDescOnce sync.Once
+	file_pir_proto_rawDescData []byte
+)
+
+func file_pir_proto_rawDescGZIP() []byte {
+	file_pir_proto_rawDescOnce.Do(func() {
+		file_pir_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_pir_proto_rawDesc), len(file_pir_proto_rawDesc)))
+	})
+	return file_pir_proto_rawDescData
+}
+
+var file_pir_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_pir_proto_goTypes = []any{
+	(*InitLedgerRequest)(nil),     // 0: pir.v1.InitLedgerRequest
+	(*InitLedgerResponse)(nil),    // 1: pir.v1.InitLedgerResponse
+	(*GetMetadataRequest)(nil),    // 2: pir.v1.GetMetadataRequest
+	(*Metadata)(nil),              // 3: pir.v1.Metadata
+	(*PIRQueryRequest)(nil),       // 4: pir.v1.PIRQueryRequest
+	(*PIRQueryResponse)(nil),      // 5: pir.v1.PIRQueryResponse
+	(*PIRQueryTimedResponse)(nil), // 6: pir.v1.PIRQueryTimedResponse
+	(*QueryChunk)(nil),            // 7: pir.v1.QueryChunk
+	(*ResultChunk)(nil),           // 8: pir.v1.ResultChunk
+}
+var file_pir_proto_depIdxs = []int32{
+	0, // 0: pir.v1.PIR.InitLedger:input_type -> pir.v1.InitLedgerRequest
+	2, // 1: pir.v1.PIR.GetMetadata:input_type -> pir.v1.GetMetadataRequest
+	4, // 2: pir.v1.PIR.PIRQuery:input_type -> pir.v1.PIRQueryRequest
+	4, // 3: pir.v1.PIR.PIRQueryTimed:input_type -> pir.v1.PIRQueryRequest
+	7, // 4: pir.v1.PIR.PIRQueryStream:input_type -> pir.v1.QueryChunk
+	1, // 5: pir.v1.PIR.InitLedger:output_type -> pir.v1.InitLedgerResponse
+	3, // 6: pir.v1.PIR.GetMetadata:output_type -> pir.v1.Metadata
+	5, // 7: pir.v1.PIR.PIRQuery:output_type -> pir.v1.PIRQueryResponse
+	6, // 8: pir.v1.PIR.PIRQueryTimed:output_type -> pir.v1.PIRQueryTimedResponse
+	8, // 9: pir.v1.PIR.PIRQueryStream:output_type -> pir.v1.ResultChunk
+	5, // [5:10] is the sub-list for method output_type
+	0, // [0:5] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_pir_proto_init() }
+func file_pir_proto_init() {
+	if File_pir_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pir_proto_rawDesc), len(file_pir_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_pir_proto_goTypes,
+		DependencyIndexes: file_pir_proto_depIdxs,
+		MessageInfos:      file_pir_proto_msgTypes,
+	}.Build()
+	File_pir_proto = out.File
+	file_pir_proto_goTypes = nil
+	file_pir_proto_depIdxs = nil
+}
@@ -0,0 +1,288 @@
+// gRPC API of the off-chain PIR server. It carries the same calls as the
+// JSON /invoke endpoint, but ciphertexts travel as raw bytes (no Base64)
+// over HTTP/2, like the Fabric gateway path the off-chain baseline is
+// benchmarked against.
+//
+// Regenerate pir.pb.go and pir_grpc.pb.go with `go generate` in this
+// directory (protoc, protoc-gen-go and protoc-gen-go-grpc on PATH).
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: pir.proto
+
+package pirpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	PIR_InitLedger_FullMethodName     = "/pir.v1.PIR/InitLedger"
+	PIR_GetMetadata_FullMethodName    = "/pir.v1.PIR/GetMetadata"
+	PIR_PIRQuery_FullMethodName       = "/pir.v1.PIR/PIRQuery"
+	PIR_PIRQueryTimed_FullMethodName  = "/pir.v1.PIR/PIRQueryTimed"
+	PIR_PIRQueryStream_FullMethodName = "/pir.v1.PIR/PIRQueryStream"
+)
+
+// PIRClient is the client API for PIR service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PIRClient interface {
+	// InitLedger builds the dataset's m_DB from synthetic records.
+	InitLedger(ctx context.Context, in *InitLedgerRequest, opts ...grpc.CallOption) (*InitLedgerResponse, error)
+	// GetMetadata returns the public parameters clients need for KeyGen.
+	GetMetadata(ctx context.Context, in *GetMetadataRequest, opts ...grpc.CallOption) (*Metadata, error)
+	// PIRQuery multiplies one ct_q with m_DB and returns ct_r.
+	PIRQuery(ctx context.Context, in *PIRQueryRequest, opts ...grpc.CallOption) (*PIRQueryResponse, error)
+	// PIRQueryTimed is PIRQuery plus the server-side evaluation cost.
+	PIRQueryTimed(ctx context.Context, in *PIRQueryRequest, opts ...grpc.CallOption) (*PIRQueryTimedResponse, error)
+	// PIRQueryStream sends ct_q and receives ct_r in chunks, so neither
+	// side hits the per-message size limit at large LogN.
+	PIRQueryStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[QueryChunk, ResultChunk], error)
+}
+
+type pIRClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPIRClient(cc grpc.ClientConnInterface) PIRClient {
+	return &pIRClient{cc}
+}
+
+func (c *pIRClient) InitLedger(ctx context.Context, in *InitLedgerRequest, opts ...grpc.CallOption) (*InitLedgerResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(InitLedgerResponse)
+	err := c.cc.Invoke(ctx, PIR_InitLedger_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pIRClient) GetMetadata(ctx context.Context, in *GetMetadataRequest, opts ...grpc.CallOption) (*Metadata, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Metadata)
+	err := c.cc.Invoke(ctx, PIR_GetMetadata_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pIRClient) PIRQuery(ctx context.Context, in *PIRQueryRequest, opts ...grpc.CallOption) (*PIRQueryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PIRQueryResponse)
+	err := c.cc.Invoke(ctx, PIR_PIRQuery_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pIRClient) PIRQueryTimed(ctx context.Context, in *PIRQueryRequest, opts ...grpc.CallOption) (*PIRQueryTimedResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PIRQueryTimedResponse)
+	err := c.cc.Invoke(ctx, PIR_PIRQueryTimed_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pIRClient) PIRQueryStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[QueryChunk, ResultChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &PIR_ServiceDesc.Streams[0], PIR_PIRQueryStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[QueryChunk, ResultChunk]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PIR_PIRQueryStreamClient = grpc.BidiStreamingClient[QueryChunk, ResultChunk]
+
+// PIRServer is the server API for PIR service.
+// All implementations must embed UnimplementedPIRServer
+// for forward compatibility.
+type PIRServer interface {
+	// InitLedger builds the dataset's m_DB from synthetic records.
+	InitLedger(context.Context, *InitLedgerRequest) (*InitLedgerResponse, error)
+	// GetMetadata returns the public parameters clients need for KeyGen.
+	GetMetadata(context.Context, *GetMetadataRequest) (*Metadata, error)
+	// PIRQuery multiplies one ct_q with m_DB and returns ct_r.
+	PIRQuery(context.Context, *PIRQueryRequest) (*PIRQueryResponse, error)
+	// PIRQueryTimed is PIRQuery plus the server-side evaluation cost.
+	PIRQueryTimed(context.Context, *PIRQueryRequest) (*PIRQueryTimedResponse, error)
+	// PIRQueryStream sends ct_q and receives ct_r in chunks, so neither
+	// side hits the per-message size limit at large LogN.
+	PIRQueryStream(grpc.BidiStreamingServer[QueryChunk, ResultChunk]) error
+	mustEmbedUnimplementedPIRServer()
+}
+
+// UnimplementedPIRServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPIRServer struct{}
+
+func (UnimplementedPIRServer) InitLedger(context.Context, *InitLedgerRequest) (*InitLedgerResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method InitLedger not implemented")
+}
+func (UnimplementedPIRServer) GetMetadata(context.Context, *GetMetadataRequest) (*Metadata, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMetadata not implemented")
+}
+func (UnimplementedPIRServer) PIRQuery(context.Context, *PIRQueryRequest) (*PIRQueryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PIRQuery not implemented")
+}
+func (UnimplementedPIRServer) PIRQueryTimed(context.Context, *PIRQueryRequest) (*PIRQueryTimedResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PIRQueryTimed not implemented")
+}
+func (UnimplementedPIRServer) PIRQueryStream(grpc.BidiStreamingServer[QueryChunk, ResultChunk]) error {
+	return status.Errorf(codes.Unimplemented, "method PIRQueryStream not implemented")
+}
+func (UnimplementedPIRServer) mustEmbedUnimplementedPIRServer() {}
+func (UnimplementedPIRServer) testEmbeddedByValue()             {}
+
+// UnsafePIRServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PIRServer will
+// result in compilation errors.
+type UnsafePIRServer interface {
+	mustEmbedUnimplementedPIRServer()
+}
+
+func RegisterPIRServer(s grpc.ServiceRegistrar, srv PIRServer) {
+	// If the following call pancis, it indicates UnimplementedPIRServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&PIR_ServiceDesc, srv)
+}
+
+func _PIR_InitLedger_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InitLedgerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PIRServer).InitLedger(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PIR_InitLedger_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PIRServer).InitLedger(ctx, req.(*InitLedgerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PIR_GetMetadata_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMetadataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PIRServer).GetMetadata(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PIR_GetMetadata_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PIRServer).GetMetadata(ctx, req.(*GetMetadataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PIR_PIRQuery_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PIRQueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PIRServer).PIRQuery(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PIR_PIRQuery_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PIRServer).PIRQuery(ctx, req.(*PIRQueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PIR_PIRQueryTimed_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PIRQueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PIRServer).PIRQueryTimed(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PIR_PIRQueryTimed_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PIRServer).PIRQueryTimed(ctx, req.(*PIRQueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PIR_PIRQueryStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PIRServer).PIRQueryStream(&grpc.GenericServerStream[QueryChunk, ResultChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PIR_PIRQueryStreamServer = grpc.BidiStreamingServer[QueryChunk, ResultChunk]
+
+// PIR_ServiceDesc is the grpc.ServiceDesc for PIR service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PIR_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "pir.v1.PIR",
+	HandlerType: (*PIRServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "InitLedger",
+			Handler:    _PIR_InitLedger_Handler,
+		},
+		{
+			MethodName: "GetMetadata",
+			Handler:    _PIR_GetMetadata_Handler,
+		},
+		{
+			MethodName: "PIRQuery",
+			Handler:    _PIR_PIRQuery_Handler,
+		},
+		{
+			MethodName: "PIRQueryTimed",
+			Handler:    _PIR_PIRQueryTimed_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PIRQueryStream",
+			Handler:       _PIR_PIRQueryStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "pir.proto",
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
+	"os"
 
 	"off-chain-pir-client/internal/cpir"
 	"off-chain-pir-client/internal/utils"
+
+	shared "pir_shared/utils"
 )
 
 /********* main demo **********************************************/
@@ -13,28 +15,56 @@ func main() {
 	// --- Set parameters --- Please follow the Feasible Parameters table in the README.md
 	const dbSize = 128        // set the total number of records in the DB: 100, 256, or 512 (necessary param)
 	const maxJSONlength = 256 // set the max JSON length: 64, 128, 224, 256, 384, or 512 (necessary param)
-	const logN = ""           // set the HE parameter LogN: 13, 14, or 15
+	const logN = ""           // set the HE parameter LogN: 13, 14, 15 (or 16), or "" to auto-select
 	const logQi = ""          // set the HE parameter logQi as JSON array, or "" to use default (optional param)
 	const logPi = ""          // set the HE parameter logPi as JSON array, or "" to use default (optional param)
 	const t = ""              // set the HE parameter plaintext modulus t, or 0 to use default (optional param)
 	const targetIndex = 13    // set the index of the record to be retrieved: 0..dbSize-1 (necessary param)
+	const packing = ""        // record packing: "2b" packs two bytes per slot, "" or "1b" one
+	const streamQuery = false // true: send ct_q and receive ct_r as raw bytes over /pir/stream instead of Base64 in /invoke JSON
+
+	// PIR_RECORD=<file>: save this session's calls as a replay trace
+	// (sizes and timing only) for "go run ./cmd/replay -trace"
+	if path := os.Getenv("PIR_RECORD"); path != "" {
+		rec := shared.NewTraceRecorder()
+		utils.OnCall = rec.Record
+		defer func() {
+			if err := shared.SaveTrace(path, rec.Trace()); err != nil {
+				panic(err)
+			}
+		}()
+	}
+
+	// 0) Compatibility probe and feature handshake; stops here when this
+	// client cannot talk to the server (older servers without Probe →
+	// GetCapabilities, or the legacy set)
+	probeStr, probeErr := utils.Call("Probe")
+	var capsStr string
+	var capsErr error
+	if probeErr != nil {
+		capsStr, capsErr = utils.Call("GetCapabilities")
+	}
+	probe, err := cpir.ParseProbe([]byte(probeStr), probeErr, []byte(capsStr), capsErr)
+	if err != nil {
+		panic(fmt.Errorf("Probe: %w", err))
+	}
+	caps := probe.Capabilities
+	fmt.Printf("Capabilities: v%d features=%v packing=%v max_shards=%d\n",
+		caps.Version, caps.Names, caps.Packing, caps.MaxShards)
 
 	fmt.Println("\n--> Submit Transaction: InitLedger")
-	// 1)  Client 1: Init ledger with sample data
-	utils.Call("InitLedger",
-		fmt.Sprintf("%d", dbSize),
-		fmt.Sprintf("%d", maxJSONlength),
-		logN,
-		logQi,
-		logPi,
-		t,
-		//
-	)
+	// 1)  Client 1: Init ledger with sample data; on "capacity exceeded"
+	// retry with the advisor's next layout instead of aborting
+	plan, err := utils.InitLedgerNegotiated(dbSize, maxJSONlength, logN, logQi, logPi, t, packing, caps)
+	if err != nil {
+		panic(fmt.Errorf("InitLedger failed: %w", err))
+	}
+	fmt.Println("InitLedger:", plan)
 
 	// 2) Client 2: Discovers metadata parameters  (single JSON)
 	metaStr, _ := utils.Call("GetMetadata")
-	var meta cpir.Metadata
-	if err := json.Unmarshal([]byte(metaStr), &meta); err != nil {
+	meta, _, err := cpir.ParseMetadata([]byte(metaStr))
+	if err != nil {
 		panic(fmt.Errorf("failed to parse metadata: %w", err))
 	}
 
@@ -47,27 +77,56 @@ func main() {
 	fmt.Printf("t         : %d\n", meta.T)
 	fmt.Printf("logQi     : %v\n", meta.LogQi)
 	fmt.Printf("logPi     : %v\n", meta.LogPi)
+	fmt.Printf("packing   : %s\n", meta.PackingMode())
 	fmt.Println("---------------------")
 
 	// 3) Client 2: KeyGen using discovered metadata
+	cpir.SeedFromEnv() // PIR_RESEARCH_SEED: reproducible keys and queries
 	params, sk, pk, err := cpir.GenKeysFromMetadata(meta)
 	if err != nil {
 		panic(fmt.Errorf("GenKeysFromLiteral failed: %w", err))
 	}
 	fmt.Printf("KeyGen done: skID=%p  pkID=%p\n", sk, pk)
+	if pool := cpir.MaskPoolFromEnv(params, pk); pool != nil { // PIR_MASK_POOL=<n> warms masks while we set up
+		defer pool.Close()
+	}
 
 	// Sanity check: fetch a public record (no encryption)
 	j, _ := utils.Call("PublicQuery", "record013")
 	fmt.Println("PublicQuery: record013 =", j)
 
+	// Cross-check the selector window against the server's packing
+	// (skipped on servers without DescribeSelector)
+	if layout, err := utils.Call("DescribeSelector", fmt.Sprintf("%d", targetIndex)); err == nil {
+		if err := cpir.CheckSelector(meta, targetIndex, []byte(layout)); err != nil {
+			panic(err)
+		}
+		fmt.Println("DescribeSelector:", layout)
+	}
+
 	// 4) Client 2: CPIR: Encrypt → Evaluate → Decrypt
 	serverDbSize := meta.NRecords
 	slotsPerRec := meta.RecordS
 
+	if streamQuery {
+		ctQ, err := cpir.EncryptQuery(params, pk, targetIndex, serverDbSize, slotsPerRec)
+		if err != nil {
+			panic(err)
+		}
+		raw, err := utils.StreamQuery(ctQ)
+		if err != nil {
+			panic(fmt.Errorf("/pir/stream: %w", err))
+		}
+		dec, _ := cpir.DecryptResultRawPacked(params, sk, raw, targetIndex, serverDbSize, slotsPerRec, meta.Packing)
+		fmt.Printf("len_ct_bytes=%d len_res_bytes=%d\n", ctQ.BinarySize(), len(raw))
+		fmt.Println("PIR result =", dec.JSONString)
+		return
+	}
+
 	encQueryB64, lenCtBytes, _ := cpir.EncryptQueryBase64(params, pk, targetIndex, serverDbSize, slotsPerRec)
 	fmt.Printf("len_ct_bytes=%d\n", lenCtBytes)
 
 	encResB64, _ := utils.Call("PIRQuery", encQueryB64)
-	dec, _ := cpir.DecryptResult(params, sk, encResB64, targetIndex, serverDbSize, slotsPerRec)
+	dec, _ := cpir.DecryptResultPacked(params, sk, encResB64, targetIndex, serverDbSize, slotsPerRec, meta.Packing)
 	fmt.Println("PIR result =", dec.JSONString)
 }
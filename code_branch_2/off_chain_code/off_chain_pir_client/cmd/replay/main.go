@@ -0,0 +1,88 @@
+// cmd/replay/main.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"off-chain-pir-client/internal/cpir"
+	"off-chain-pir-client/internal/utils"
+
+	shared "pir_shared/utils"
+)
+
+// replay re-issues a recorded (PIR_RECORD in cmd/client) or synthetic
+// query session against the off-chain server on its original schedule,
+// sped up by -speedup, and writes one latency row per call. The server is
+// chosen as for every client (PIR_SERVER_URL, PIR_DATASET, PIR_TOKEN, …),
+// but retries and the circuit breaker are off so every call is measured
+// as sent. The same trace replays against Fabric with "pirctl replay".
+//
+//	go run ./cmd/replay -synth 200 -rate 5 -save trace.json
+//	go run ./cmd/replay -trace trace.json -speedup 4
+
+func main() {
+	var rc shared.ReplayCommand
+	rc.Register(flag.CommandLine, "replay_offchain.csv")
+	flag.Parse()
+
+	opts := utils.OptionsFromEnv()
+	opts.Retries, opts.BreakerFailures = -1, -1
+	if err := utils.Configure(opts); err != nil {
+		fail(err)
+	}
+	cpir.Debug = false
+
+	probeStr, probeErr := utils.Call("Probe")
+	var capsStr string
+	var capsErr error
+	if probeErr != nil {
+		capsStr, capsErr = utils.Call("GetCapabilities")
+	}
+	if _, err := cpir.ParseProbe([]byte(probeStr), probeErr, []byte(capsStr), capsErr); err != nil {
+		fail(fmt.Errorf("Probe: %w", err))
+	}
+	metaStr, err := utils.Call("GetMetadata")
+	if err != nil {
+		fail(fmt.Errorf("GetMetadata: %w", err))
+	}
+	meta, _, err := cpir.ParseMetadata([]byte(metaStr))
+	if err != nil {
+		fail(err)
+	}
+	params, _, pk, err := cpir.GenKeysFromMetadata(meta)
+	if err != nil {
+		fail(err)
+	}
+	ctQ, _, err := cpir.EncryptQueryBase64(params, pk, 0, meta.NRecords, meta.RecordS)
+	if err != nil {
+		fail(fmt.Errorf("encrypt ct_q: %w", err))
+	}
+
+	trace, err := rc.Trace(len(ctQ))
+	if err != nil {
+		fail(err)
+	}
+	fmt.Printf("replaying %d calls (%s) at %gx against %s\n",
+		len(trace.Events), trace.Source, rc.Options.Speedup, utils.BaseURL())
+	sum, warn, err := rc.Run(trace, ctQ, func(method string, args ...string) error {
+		_, err := utils.Call(method, args...)
+		return err
+	})
+	if err != nil {
+		fail(err)
+	}
+	if warn != "" {
+		fmt.Printf("[WARN] %s\n", warn)
+	}
+	fmt.Printf("%s\nwrote %s\n", sum, rc.OutPath)
+	if sum.Errors > 0 {
+		os.Exit(1)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "[ERR] %v\n", err)
+	os.Exit(2)
+}
@@ -2,17 +2,31 @@ module off-chain-pir-client
 
 go 1.24.1
 
-require github.com/tuneinsight/lattigo/v6 v6.1.1
+require (
+	github.com/tuneinsight/lattigo/v6 v6.1.1
+	google.golang.org/grpc v1.75.0
+	off-chain-pir-api v0.0.0-00010101000000-000000000000
+	pir_shared v0.0.0-00010101000000-000000000000
+)
 
 require (
 	github.com/ALTree/bigfloat v0.0.0-20220102081255-38c8b72a9924 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/google/go-cmp v0.5.8 // indirect
+	github.com/google/go-cmp v0.7.0 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/testify v1.8.0 // indirect
-	golang.org/x/crypto v0.18.0 // indirect
+	golang.org/x/crypto v0.39.0 // indirect
 	golang.org/x/exp v0.0.0-20230321023759-10a507213a29 // indirect
-	golang.org/x/sys v0.16.0 // indirect
+	golang.org/x/net v0.41.0 // indirect
+	golang.org/x/sys v0.33.0 // indirect
+	golang.org/x/text v0.26.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250707201910-8d1bb00bc6a7 // indirect
+	google.golang.org/protobuf v1.36.6 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace (
+	off-chain-pir-api => ../off_chain_pir_api
+	pir_shared => ../../pir_shared
+)
@@ -4,7 +4,6 @@ package main
 import (
 	"encoding/base64"
 	"encoding/csv"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -18,16 +17,6 @@ import (
 	"github.com/tuneinsight/lattigo/v6/core/rlwe"
 )
 
-type metaResp struct {
-	NRecords int    `json:"n"`
-	RecordS  int    `json:"record_s"`
-	LogN     int    `json:"logN"`
-	N        int    `json:"N"`
-	T        uint64 `json:"t"`
-	LogQi    []int  `json:"logQi"`
-	LogPi    []int  `json:"logPi"`
-}
-
 type channelCfg struct {
 	Name         string
 	DBSize       int
@@ -51,6 +40,7 @@ var (
 
 func main() {
 	flag.Parse()
+	cpir.SeedFromEnv() // PIR_RESEARCH_SEED: reproducible keys and queries
 	if err := os.MkdirAll(*outDir, 0o755); err != nil {
 		fmt.Fprintf(os.Stderr, "[ERR] cannot create out dir: %v\n", err)
 		os.Exit(1)
@@ -81,17 +71,14 @@ func runOne(cfg channelCfg, outDir string) error {
 	if err != nil {
 		return fmt.Errorf("GetMetadata: %w", err)
 	}
-	var meta metaResp
-	if err := json.Unmarshal([]byte(metaStr), &meta); err != nil {
-		return fmt.Errorf("parse metadata: %w", err)
+	meta, _, err := cpir.ParseMetadata([]byte(metaStr))
+	if err != nil {
+		return err
 	}
 	metadataBytes := len([]byte(metaStr))
 
 	// 3) KeyGen
-	params, sk, pk, err := cpir.GenKeysFromMetadata(cpir.Metadata{
-		NRecords: meta.NRecords, RecordS: meta.RecordS,
-		LogN: meta.LogN, N: meta.N, T: meta.T, LogQi: meta.LogQi, LogPi: meta.LogPi,
-	})
+	params, sk, pk, err := cpir.GenKeysFromMetadata(meta)
 	if err != nil {
 		return fmt.Errorf("GenKeysFromMetadata: %w", err)
 	}
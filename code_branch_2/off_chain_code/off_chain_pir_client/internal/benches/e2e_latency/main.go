@@ -2,6 +2,7 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/csv"
 	"encoding/json"
 	"errors"
@@ -12,6 +13,9 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
+
 	"off-chain-pir-client/internal/cpir"
 	"off-chain-pir-client/internal/utils"
 )
@@ -19,11 +23,21 @@ import (
 /*
 Figure: End-to-end single-query latency by stage; ct×pt path.
 Stages:
-  - keygen_ms     : GenKeysFromMetadata
+  - params_ms     : bgv.Parameters for the metadata (cpir.ParamsFromMetadata)
+  - keygen_ms     : key pair sampling only (cpir.GenKeyPair)
   - enc_ms        : selector build + encode + encrypt
   - eval_ms       : server-side MulNew(ct, m_DB) (if server returns it), else -1
   - dec_ms        : decrypt + decode + window extract
 
+With -session the keys, encoder and encryptor are built once per channel
+(cpir.Session; keygen_ms is recorded for epoch 0 only), and with -pregen
+every epoch's selector is encrypted before the loop, so enc_ms is the
+cost of handing out a cached ct_q.
+
+The parameters come from cpir.ParamCache: params_ms is the construction
+on epoch 0 and a cache hit afterwards. With -param-cache=false the cache
+is purged before every epoch, so every params_ms is a full construction.
+
 CSV columns: epoch,stage,latency_ms
 Filename   : e2elatency_<logN>_<record_s>.csv
 
@@ -34,16 +48,6 @@ Notes:
   can plot eval_rtt_ms separately if desired.
 */
 
-type metaResp struct {
-	NRecords int    `json:"n"`
-	RecordS  int    `json:"record_s"`
-	LogN     int    `json:"logN"`
-	N        int    `json:"N"`
-	T        uint64 `json:"t"`
-	LogQi    []int  `json:"logQi"`
-	LogPi    []int  `json:"logPi"`
-}
-
 type pirTimedResp struct {
 	B64    string  `json:"b64"`
 	EvalMS float64 `json:"eval_ms"`
@@ -69,6 +73,10 @@ var configs = []channelCfg{
 var (
 	epochs      = flag.Int("epochs", 20, "number of epochs per channel")
 	serverDebug = flag.Bool("debug", false, "print per-epoch debug info")
+	useSession  = flag.Bool("session", false, "reuse one cpir.Session (keys, encoder, encryptor) for all epochs of a channel")
+	pregen      = flag.Bool("pregen", false, "with -session, encrypt every epoch's selector before the loop")
+	paramCache  = flag.Bool("param-cache", true, "reuse the bgv.Parameters of earlier epochs (cpir.ParamCache); false rebuilds them every epoch")
+	useGRPC     = flag.Bool("grpc", false, "send ct_q / receive ct_r as raw bytes over the gRPC API (PIR_GRPC_ADDR) instead of Base64 in /invoke JSON")
 
 	// New folder structure for CSV output
 	outDir = filepath.Join("plots", "e2elatency", "data")
@@ -76,6 +84,7 @@ var (
 
 func main() {
 	flag.Parse()
+	cpir.SeedFromEnv() // PIR_RESEARCH_SEED: reproducible keys and queries
 
 	// Ensure output directory exists
 	if err := os.MkdirAll(outDir, 0o755); err != nil {
@@ -114,9 +123,9 @@ func runChannel(cfg channelCfg, epochs int, verbose bool) error {
 	if err != nil {
 		return fmt.Errorf("GetMetadata failed: %w", err)
 	}
-	var meta metaResp
-	if err := json.Unmarshal([]byte(metaStr), &meta); err != nil {
-		return fmt.Errorf("parse metadata: %w", err)
+	meta, _, err := cpir.ParseMetadata([]byte(metaStr))
+	if err != nil {
+		return err
 	}
 	if verbose {
 		fmt.Printf("[META] n=%d s=%d logN=%d N=%d T=%d logQi=%v logPi=%v\n",
@@ -135,24 +144,35 @@ func runChannel(cfg channelCfg, epochs int, verbose bool) error {
 	defer w.Flush()
 	_ = w.Write([]string{"epoch", "stage", "latency_ms"})
 
+	if *useSession {
+		return sessionEpochs(w, epochs, cfg, meta, outName)
+	}
+
 	// --- Benchmark loop ---
 	for e := 0; e < epochs; e++ {
 		if verbose {
 			fmt.Printf("[RUN] epoch=%d\n", e)
 		}
 
-		// KeyGen
-		t0 := time.Now()
-		params, sk, pk, err := cpir.GenKeysFromMetadata(cpir.Metadata{
-			NRecords: meta.NRecords, RecordS: meta.RecordS,
-			LogN: meta.LogN, N: meta.N, T: meta.T, LogQi: meta.LogQi, LogPi: meta.LogPi,
-		})
+		// Params
+		params, err := timedParams(w, e, meta)
 		if err != nil {
-			return fmt.Errorf("GenKeysFromMetadata: %w", err)
+			return err
 		}
+
+		// KeyGen
+		t0 := time.Now()
+		sk, pk := cpir.GenKeyPair(params)
 		keygenMS := msSince(t0)
 		_ = w.Write([]string{itoa(e), "keygen_ms", fmt.Sprintf("%.3f", keygenMS)})
 
+		if *useGRPC {
+			if err := grpcEpoch(w, e, cfg, meta, params, sk, pk); err != nil {
+				return err
+			}
+			continue
+		}
+
 		// Enc
 		t1 := time.Now()
 		queryB64, _, err := cpir.EncryptQueryBase64(params, pk, cfg.TargetIndex, meta.NRecords, meta.RecordS)
@@ -175,7 +195,7 @@ func runChannel(cfg channelCfg, epochs int, verbose bool) error {
 
 		// Dec
 		t3 := time.Now()
-		if _, err := cpir.DecryptResult(params, sk, respB64, cfg.TargetIndex, meta.NRecords, meta.RecordS); err != nil {
+		if _, err := cpir.DecryptResultPacked(params, sk, respB64, cfg.TargetIndex, meta.NRecords, meta.RecordS, meta.Packing); err != nil {
 			return fmt.Errorf("DecryptResult: %w", err)
 		}
 		decMS := msSince(t3)
@@ -191,6 +211,128 @@ func runChannel(cfg channelCfg, epochs int, verbose bool) error {
 	return nil
 }
 
+// timedParams builds (or fetches from cpir.ParamCache) the parameters of
+// meta and writes params_ms.
+func timedParams(w *csv.Writer, e int, meta cpir.Metadata) (bgv.Parameters, error) {
+	if !*paramCache {
+		cpir.ParamCache.Purge()
+	}
+	t := time.Now()
+	params, err := cpir.ParamsFromMetadata(meta)
+	if err != nil {
+		return params, fmt.Errorf("ParamsFromMetadata: %w", err)
+	}
+	_ = w.Write([]string{itoa(e), "params_ms", fmt.Sprintf("%.3f", msSince(t))})
+	return params, nil
+}
+
+// grpcEpoch is the enc / eval / dec part of one epoch over the gRPC API;
+// the CSV rows are the same as over REST.
+func grpcEpoch(w *csv.Writer, e int, cfg channelCfg, meta cpir.Metadata, params bgv.Parameters, sk *rlwe.SecretKey, pk *rlwe.PublicKey) error {
+	t1 := time.Now()
+	ctQ, err := cpir.EncryptQuery(params, pk, cfg.TargetIndex, meta.NRecords, meta.RecordS)
+	if err != nil {
+		return fmt.Errorf("EncryptQuery: %w", err)
+	}
+	raw, err := ctQ.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("marshal ct_q: %w", err)
+	}
+	_ = w.Write([]string{itoa(e), "enc_ms", fmt.Sprintf("%.3f", msSince(t1))})
+
+	ctR, evalMS, err := utils.GRPCQueryTimed(raw)
+	if err != nil {
+		return fmt.Errorf("gRPC PIRQueryTimed: %w", err)
+	}
+	_ = w.Write([]string{itoa(e), "eval_ms", fmt.Sprintf("%.3f", evalMS)})
+
+	t3 := time.Now()
+	if _, err := cpir.DecryptResultRawPacked(params, sk, ctR, cfg.TargetIndex, meta.NRecords, meta.RecordS, meta.Packing); err != nil {
+		return fmt.Errorf("DecryptResultRaw: %w", err)
+	}
+	_ = w.Write([]string{itoa(e), "dec_ms", fmt.Sprintf("%.3f", msSince(t3))})
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("csv write: %w", err)
+	}
+	return nil
+}
+
+// sessionEpochs is the benchmark loop of -session: one cpir.Session for
+// all epochs, params_ms and keygen_ms once (NewSession finds the
+// parameters in the cache, so keygen_ms is keys, encoder and encryptor).
+func sessionEpochs(w *csv.Writer, epochs int, cfg channelCfg, meta cpir.Metadata, outName string) error {
+	if _, err := timedParams(w, 0, meta); err != nil {
+		return err
+	}
+	t0 := time.Now()
+	sess, err := cpir.NewSession(meta)
+	if err != nil {
+		return fmt.Errorf("NewSession: %w", err)
+	}
+	_ = w.Write([]string{itoa(0), "keygen_ms", fmt.Sprintf("%.3f", msSince(t0))})
+	if *pregen {
+		indices := make([]int, epochs)
+		for e := range indices {
+			indices[e] = cfg.TargetIndex
+		}
+		t := time.Now()
+		if err := sess.Pregenerate(indices...); err != nil {
+			return fmt.Errorf("Pregenerate: %w", err)
+		}
+		_ = w.Write([]string{itoa(0), "pregen_ms", fmt.Sprintf("%.3f", msSince(t))})
+	}
+
+	for e := 0; e < epochs; e++ {
+		t1 := time.Now()
+		ctQ, err := sess.EncryptQuery(cfg.TargetIndex)
+		if err != nil {
+			return fmt.Errorf("EncryptQuery: %w", err)
+		}
+		raw, err := ctQ.MarshalBinary()
+		if err != nil {
+			return fmt.Errorf("marshal ct_q: %w", err)
+		}
+		queryB64 := base64.StdEncoding.EncodeToString(raw)
+		_ = w.Write([]string{itoa(e), "enc_ms", fmt.Sprintf("%.3f", msSince(t1))})
+
+		var dec func() error
+		if *useGRPC {
+			ctR, evalMS, err := utils.GRPCQueryTimed(raw)
+			if err != nil {
+				return fmt.Errorf("gRPC PIRQueryTimed: %w", err)
+			}
+			_ = w.Write([]string{itoa(e), "eval_ms", fmt.Sprintf("%.3f", evalMS)})
+			dec = func() error { _, err := sess.DecryptResultRaw(ctR, cfg.TargetIndex); return err }
+		} else {
+			evalMS, rttMS, respB64, err := callPIRWithEvalMS(queryB64)
+			if err != nil {
+				return fmt.Errorf("PIRQuery: %w", err)
+			}
+			if evalMS >= 0 {
+				_ = w.Write([]string{itoa(e), "eval_ms", fmt.Sprintf("%.3f", evalMS)})
+			} else {
+				_ = w.Write([]string{itoa(e), "eval_rtt_ms", fmt.Sprintf("%.3f", rttMS)})
+			}
+			dec = func() error { _, err := sess.DecryptResult(respB64, cfg.TargetIndex); return err }
+		}
+
+		t3 := time.Now()
+		if err := dec(); err != nil {
+			return fmt.Errorf("DecryptResult: %w", err)
+		}
+		_ = w.Write([]string{itoa(e), "dec_ms", fmt.Sprintf("%.3f", msSince(t3))})
+
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return fmt.Errorf("csv write: %w", err)
+		}
+	}
+	fmt.Printf("[OK] wrote %s\n", outName)
+	return nil
+}
+
 func callPIRWithEvalMS(encQueryB64 string) (evalMS float64, rttMS float64, resB64 string, err error) {
 	resp, callErr := utils.Call("PIRQueryTimed", encQueryB64)
 	if callErr == nil {
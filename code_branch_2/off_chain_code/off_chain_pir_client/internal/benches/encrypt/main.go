@@ -0,0 +1,138 @@
+// internal/benches/encrypt/main.go
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"off-chain-pir-client/internal/cpir"
+
+	"pir_shared/utils"
+
+	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
+)
+
+/*
+Client-side cost of EncryptQueryBase64, offline (no server), with and
+without a warm mask pool (utils.MaskPool, PIR_MASK_POOL in the client).
+Modes:
+  - cold : plain public-key encryption (no pool)
+  - warm : pool refilled before every query, so each call takes a mask;
+           the latency a client sees when queries are spaced out
+  - burst: back-to-back queries on one pool; after the first -pool calls
+           the workers cannot keep up and calls fall back to cold
+
+CSV columns: epoch,mode,enc_ms,pool_hit
+Filename   : encrypt_<logN>.csv
+*/
+
+var (
+	epochs  = flag.Int("epochs", 20, "queries per mode and LogN")
+	poolSz  = flag.Int("pool", 4, "mask pool size")
+	workers = flag.Int("workers", 1, "mask pool workers")
+	recordS = flag.Int("record_s", 128, "selector window in slots")
+	index   = flag.Int("index", 13, "selected record")
+
+	outDir = filepath.Join("plots", "encrypt", "data")
+)
+
+func main() {
+	flag.Parse()
+	cpir.SeedFromEnv() // PIR_RESEARCH_SEED: reproducible keys and queries
+	cpir.Debug = false // keep selector dumps out of the timings
+
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERR] cannot create output dir %s: %v\n", outDir, err)
+		os.Exit(1)
+	}
+	for _, logN := range []int{13, 14, 15} {
+		if err := runOne(logN); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERR] logN=%d: %v\n", logN, err)
+		}
+	}
+}
+
+func runOne(logN int) error {
+	params, err := utils.BuildParamsFromHint(utils.BGVParamHint{LogN: logN})
+	if err != nil {
+		return err
+	}
+	_, pk := bgv.NewKeyGenerator(params).GenKeyPairNew()
+	slots := utils.RoundRecordS(*recordS)
+	dbSize := params.MaxSlots() / slots
+
+	outName := filepath.Join(outDir, fmt.Sprintf("encrypt_%d.csv", logN))
+	f, err := os.Create(outName)
+	if err != nil {
+		return fmt.Errorf("create csv: %w", err)
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	defer w.Flush()
+	_ = w.Write([]string{"epoch", "mode", "enc_ms", "pool_hit"})
+
+	encrypt := func(pool *utils.MaskPool) (float64, bool, error) {
+		var before utils.MaskPoolStats
+		if pool != nil {
+			before = pool.Stats()
+		}
+		t := time.Now()
+		if _, _, err := cpir.EncryptQueryBase64(params, pk, *index, dbSize, slots); err != nil {
+			return 0, false, err
+		}
+		ms := float64(time.Since(t).Microseconds()) / 1000
+		return ms, pool != nil && pool.Stats().Hits > before.Hits, nil
+	}
+	sum := map[string]float64{}
+	record := func(e int, mode string, pool *utils.MaskPool) error {
+		ms, hit, err := encrypt(pool)
+		if err != nil {
+			return fmt.Errorf("%s epoch %d: %w", mode, e, err)
+		}
+		sum[mode] += ms
+		_ = w.Write([]string{strconv.Itoa(e), mode, fmt.Sprintf("%.3f", ms), strconv.FormatBool(hit)})
+		return nil
+	}
+
+	cpir.UseMaskPool(nil)
+	for e := 0; e < *epochs; e++ {
+		if err := record(e, "cold", nil); err != nil {
+			return err
+		}
+	}
+
+	pool := utils.NewMaskPool(params, pk, utils.MaskPoolOptions{Size: *poolSz, Workers: *workers})
+	defer pool.Close()
+	cpir.UseMaskPool(pool)
+	defer cpir.UseMaskPool(nil)
+	for e := 0; e < *epochs; e++ {
+		waitFull(pool)
+		if err := record(e, "warm", pool); err != nil {
+			return err
+		}
+	}
+	waitFull(pool)
+	for e := 0; e < *epochs; e++ {
+		if err := record(e, "burst", pool); err != nil {
+			return err
+		}
+	}
+
+	st := pool.Stats()
+	n := float64(*epochs)
+	fmt.Printf("[OK] logN=%d cold=%.3fms warm=%.3fms burst=%.3fms (pool=%d workers=%d hits=%d misses=%d) -> %s\n",
+		logN, sum["cold"]/n, sum["warm"]/n, sum["burst"]/n, *poolSz, *workers, st.Hits, st.Misses, outName)
+	return nil
+}
+
+// waitFull blocks until the workers have topped the pool up.
+func waitFull(pool *utils.MaskPool) {
+	for pool.Stats().Ready < *poolSz {
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,128 @@
+// internal/benches/evalpool/main.go
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+
+	"off-chain-pir-client/internal/cpir"
+
+	"pir_shared/utils"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
+)
+
+/*
+Server-side cost of one PIR evaluation (ct_q × m_DB), offline (no
+server), with a fresh bgv.NewEvaluator per query as the servers used to
+build, and with an evaluator from utils.EvaluatorPool as they do now.
+Modes:
+  - fresh : bgv.NewEvaluator + MulNew on every query
+  - pooled: EvaluatorPool.MulNew; the first call builds the evaluator,
+            the rest reuse its buffers
+Both columns count the evaluator's construction, since that is what a
+query paid for.
+
+CSV columns: epoch,mode,eval_ms,alloc_bytes,allocs
+Filename   : evalpool_<logN>.csv
+*/
+
+var (
+	epochs  = flag.Int("epochs", 50, "queries per mode and LogN")
+	recordS = flag.Int("record_s", 128, "selector window in slots")
+	index   = flag.Int("index", 13, "selected record")
+
+	outDir = filepath.Join("plots", "evalpool", "data")
+)
+
+func main() {
+	flag.Parse()
+	cpir.SeedFromEnv() // PIR_RESEARCH_SEED: reproducible keys and queries
+	cpir.Debug = false // keep selector dumps out of the timings
+
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERR] cannot create output dir %s: %v\n", outDir, err)
+		os.Exit(1)
+	}
+	for _, logN := range []int{13, 14, 15} {
+		if err := runOne(logN); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERR] logN=%d: %v\n", logN, err)
+		}
+	}
+}
+
+func runOne(logN int) error {
+	params, err := utils.BuildParamsFromHint(utils.BGVParamHint{LogN: logN})
+	if err != nil {
+		return err
+	}
+	_, pk := bgv.NewKeyGenerator(params).GenKeyPairNew()
+	slots := utils.RoundRecordS(*recordS)
+	dbSize := params.MaxSlots() / slots
+
+	// m_DB: any full plaintext does, the product costs the same
+	vec := make([]uint64, params.MaxSlots())
+	for i := range vec {
+		vec[i] = uint64(i) % params.PlaintextModulus()
+	}
+	mDB := bgv.NewPlaintext(params, params.MaxLevel())
+	if err := bgv.NewEncoder(params).Encode(vec, mDB); err != nil {
+		return fmt.Errorf("encode m_DB: %w", err)
+	}
+	ctQ, err := cpir.EncryptQuery(params, pk, *index, dbSize, slots)
+	if err != nil {
+		return err
+	}
+
+	outName := filepath.Join(outDir, fmt.Sprintf("evalpool_%d.csv", logN))
+	f, err := os.Create(outName)
+	if err != nil {
+		return fmt.Errorf("create csv: %w", err)
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	defer w.Flush()
+	_ = w.Write([]string{"epoch", "mode", "eval_ms", "alloc_bytes", "allocs"})
+
+	pool := utils.NewEvaluatorPool(params)
+	modes := []struct {
+		name string
+		mul  func() (*rlwe.Ciphertext, error)
+	}{
+		{"fresh", func() (*rlwe.Ciphertext, error) { return bgv.NewEvaluator(params, nil).MulNew(ctQ, mDB) }},
+		{"pooled", func() (*rlwe.Ciphertext, error) { return pool.MulNew(ctQ, mDB) }},
+	}
+
+	for _, m := range modes {
+		runtime.GC()
+		var ms0, ms1 runtime.MemStats
+		runtime.ReadMemStats(&ms0)
+		var wall float64
+		var alloc uint64
+		for e := 0; e < *epochs; e++ {
+			u, err := utils.MeasureEval(func() error {
+				_, err := m.mul()
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("%s epoch %d: %w", m.name, e, err)
+			}
+			wall += u.WallMS
+			alloc += u.AllocBytes
+			_ = w.Write([]string{strconv.Itoa(e), m.name, fmt.Sprintf("%.3f", u.WallMS),
+				strconv.FormatUint(u.AllocBytes, 10), strconv.FormatUint(u.Allocs, 10)})
+		}
+		runtime.ReadMemStats(&ms1)
+		n := float64(*epochs)
+		fmt.Printf("[OK] logN=%d %-6s eval=%.3fms alloc=%.1fKiB/query gc_cycles=%d\n",
+			logN, m.name, wall/n, float64(alloc)/n/1024, ms1.NumGC-ms0.NumGC)
+	}
+	fmt.Printf("[OK] wrote %s\n", outName)
+	return nil
+}
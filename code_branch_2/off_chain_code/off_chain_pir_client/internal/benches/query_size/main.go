@@ -0,0 +1,184 @@
+// internal/benches/query_size/main.go
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"pir_shared/utils"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/ring/ringqp"
+	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
+	"github.com/tuneinsight/lattigo/v6/utils/sampling"
+)
+
+// Upload cost of ct_q, offline (no server): for each LogN, encrypt the same
+// selector with the public key and with the secret key, and measure the
+// wire size with and without seeded compression, as binary and as Base64.
+//
+// Seeded compression sends (c0, seed) instead of (c0, c1): c1 is uniform,
+// so the server can re-expand it from a 32-byte PRNG seed. This is only
+// possible for secret-key encryption; a public-key ct_q has no seedable
+// component and its seeded rows are omitted. The baseline (ratio 1.0) is
+// what the clients send today: public key, unseeded, Base64.
+
+const seedBytes = 32
+
+var (
+	outCSV  = flag.String("out", "plots/query_size/data/query_size.csv", "output CSV path")
+	recordS = flag.Int("record_s", 128, "selector window in slots")
+	index   = flag.Int("index", 13, "selected record")
+)
+
+type row struct {
+	logN      int
+	enc       string // pk | sk
+	seeded    bool
+	transport string // binary | base64
+	bytes     int
+}
+
+func main() {
+	flag.Parse()
+
+	if err := os.MkdirAll(filepath.Dir(*outCSV), 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "mkdir: %v\n", err)
+		os.Exit(1)
+	}
+
+	var rows []row
+	for _, logN := range []int{13, 14, 15, 16} {
+		r, err := runOne(logN)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERR] logN=%d: %v\n", logN, err)
+			continue
+		}
+		rows = append(rows, r...)
+	}
+
+	f, err := os.Create(*outCSV)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "create csv: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	defer w.Flush()
+
+	_ = w.Write([]string{"logN", "encryption", "seeded", "transport", "ct_q_bytes", "ratio_vs_baseline"})
+	fmt.Printf("%-5s %-4s %-6s %-9s %12s %8s\n", "logN", "enc", "seeded", "transport", "ct_q_bytes", "ratio")
+	for _, r := range rows {
+		base := baseline(rows, r.logN)
+		ratio := float64(r.bytes) / float64(base)
+		_ = w.Write([]string{
+			strconv.Itoa(r.logN), r.enc, strconv.FormatBool(r.seeded), r.transport,
+			strconv.Itoa(r.bytes), fmt.Sprintf("%.3f", ratio),
+		})
+		fmt.Printf("%-5d %-4s %-6v %-9s %12d %8.3f\n", r.logN, r.enc, r.seeded, r.transport, r.bytes, ratio)
+	}
+	if err := w.Error(); err != nil {
+		fmt.Fprintf(os.Stderr, "csv write: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("[OK] wrote %s\n", *outCSV)
+}
+
+func runOne(logN int) ([]row, error) {
+	params, err := utils.BuildParamsFromHint(utils.BGVParamHint{LogN: logN})
+	if err != nil {
+		return nil, err
+	}
+	ic := utils.IndexContract{NRecords: params.MaxSlots() / utils.RoundRecordS(*recordS), RecordS: *recordS, Slots: params.MaxSlots()}
+	start, end, err := ic.Window(*index)
+	if err != nil {
+		return nil, err
+	}
+	vec := make([]uint64, params.MaxSlots())
+	for i := start; i < end; i++ {
+		vec[i] = 1
+	}
+	pt := bgv.NewPlaintext(params, params.MaxLevel())
+	if err := bgv.NewEncoder(params).Encode(vec, pt); err != nil {
+		return nil, err
+	}
+
+	sk, pk := bgv.NewKeyGenerator(params).GenKeyPairNew()
+
+	// public key: what EncryptQueryBase64 sends
+	ctPk, err := bgv.NewEncryptor(params, pk).EncryptNew(pt)
+	if err != nil {
+		return nil, err
+	}
+	pkBin, err := ctPk.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	// secret key, c1 drawn from a keyed PRNG so it can be sent as its seed
+	seed := make([]byte, seedBytes)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	prng, err := sampling.NewKeyedPRNG(seed)
+	if err != nil {
+		return nil, err
+	}
+	ctSk, err := rlwe.NewEncryptor(params, sk).WithPRNG(prng).EncryptNew(pt)
+	if err != nil {
+		return nil, err
+	}
+	skBin, err := ctSk.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSeed(params, seed, ctSk); err != nil {
+		return nil, err
+	}
+	c1Bin, err := ctSk.Value[1].MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	seededLen := len(skBin) - len(c1Bin) + seedBytes
+
+	var rows []row
+	add := func(enc string, seeded bool, n int) {
+		rows = append(rows,
+			row{logN, enc, seeded, "binary", n},
+			row{logN, enc, seeded, "base64", base64.StdEncoding.EncodedLen(n)})
+	}
+	add("pk", false, len(pkBin))
+	add("sk", false, len(skBin))
+	add("sk", true, seededLen)
+	return rows, nil
+}
+
+// checkSeed re-expands c1 from seed as the server would and compares it
+// with the ciphertext's c1, so the seeded size is one the server can use.
+func checkSeed(params bgv.Parameters, seed []byte, ct *rlwe.Ciphertext) error {
+	prng, err := sampling.NewKeyedPRNG(seed)
+	if err != nil {
+		return err
+	}
+	c1 := params.RingQ().AtLevel(ct.Level()).NewPoly()
+	ringqp.NewUniformSampler(prng, *params.RingQP()).AtLevel(ct.Level(), -1).Read(ringqp.Poly{Q: c1})
+	if !c1.Equal(&ct.Value[1]) {
+		return fmt.Errorf("c1 does not re-expand from its seed")
+	}
+	return nil
+}
+
+func baseline(rows []row, logN int) int {
+	for _, r := range rows {
+		if r.logN == logN && r.enc == "pk" && !r.seeded && r.transport == "base64" {
+			return r.bytes
+		}
+	}
+	return 1
+}
@@ -3,30 +3,21 @@ package main
 
 import (
 	"encoding/csv"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
 
+	"off-chain-pir-client/internal/cpir"
 	"off-chain-pir-client/internal/utils"
 )
 
-type metaResp struct {
-	NRecords int    `json:"n"`
-	RecordS  int    `json:"record_s"`
-	LogN     int    `json:"logN"`
-	N        int    `json:"N"`
-	T        uint64 `json:"t"`
-	LogQi    []int  `json:"logQi"`
-	LogPi    []int  `json:"logPi"`
-}
-
 var outCSV = flag.String("out", "plots/scaling_util/data/scaling_util.csv", "output CSV path")
 
 func main() {
 	flag.Parse()
+	cpir.SeedFromEnv() // PIR_RESEARCH_SEED: reproducible keys and queries
 
 	// Ensure output directory exists
 	if err := os.MkdirAll(filepath.Dir(*outCSV), 0o755); err != nil {
@@ -76,9 +67,9 @@ func main() {
 				fmt.Fprintf(os.Stderr, "[WARN] GetMetadata: %v\n", err)
 				continue
 			}
-			var m metaResp
-			if err := json.Unmarshal([]byte(metaStr), &m); err != nil {
-				fmt.Fprintf(os.Stderr, "[WARN] parse metadata: %v\n", err)
+			m, _, err := cpir.ParseMetadata([]byte(metaStr))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[WARN] %v\n", err)
 				continue
 			}
 
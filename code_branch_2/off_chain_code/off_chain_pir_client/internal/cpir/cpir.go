@@ -5,9 +5,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"strconv"
 
 	"github.com/tuneinsight/lattigo/v6/core/rlwe"
 	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
+
+	"pir_shared/utils"
 )
 
 /********************************************************************
@@ -15,15 +19,89 @@ import (
  ********************************************************************/
 var Debug = true
 
-// Metadata mirrors the server's GetMetadata response.
-type Metadata struct {
-	NRecords int    `json:"n"`
-	RecordS  int    `json:"record_s"`
-	LogN     int    `json:"logN"`
-	N        int    `json:"N"`
-	T        uint64 `json:"t"`
-	LogQi    []int  `json:"logQi"`
-	LogPi    []int  `json:"logPi"`
+// Metadata mirrors the server's GetMetadata response (n = db size,
+// record_s = slots per record). Shared with both server flavours.
+type Metadata = utils.Metadata
+
+// ParseMetadata decodes a GetMetadata / GetMetadataTimed response.
+// execMS is the server-side time, or -1 if the server did not report it.
+func ParseMetadata(raw []byte) (meta Metadata, execMS float64, err error) {
+	return utils.ParseMetadata(raw)
+}
+
+// Capabilities is the server feature handshake (see GetCapabilities).
+type Capabilities = utils.Capabilities
+
+// IndexContract maps record index i ↔ "record%03d" ↔ slot window i; the
+// selector and the decrypted window are both taken from it.
+type IndexContract = utils.IndexContract
+
+// CheckSelector compares a DescribeSelector response with the window this
+// client derives from meta for index; a mismatch means the two sides
+// disagree on packing and the PIR result would be the wrong record.
+func CheckSelector(meta Metadata, index int, raw []byte) error {
+	var got utils.SelectorLayout
+	if err := json.Unmarshal(raw, &got); err != nil {
+		return fmt.Errorf("parse DescribeSelector: %w", err)
+	}
+	want, err := utils.NewIndexContract(meta).Describe(index)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("selector mismatch for index %d: server %+v, client %+v", index, got, want)
+	}
+	return nil
+}
+
+// ParseCapabilities decodes GetCapabilities output; a callErr saying the
+// server does not know GetCapabilities (utils.IsUnknownFunction) means it
+// predates the handshake and yields the legacy feature set. Any other
+// callErr is returned.
+func ParseCapabilities(raw []byte, callErr error) (Capabilities, error) {
+	return utils.ParseCapabilities(raw, callErr)
+}
+
+// Probe is the compatibility probe (see utils.Probe).
+type Probe = utils.Probe
+
+// heLibrary is the HE library this client builds ct_q with (he.Engine Name).
+const heLibrary = "lattigo/v6"
+
+// ParseProbe decodes Probe output; a callErr saying the server does not
+// know Probe means it predates Probe and the probe is rebuilt from its
+// GetCapabilities answer (capsRaw, capsErr). It returns an error naming the mismatch when this
+// client cannot talk to the server: another protocol version or another
+// HE library.
+func ParseProbe(raw []byte, callErr error, capsRaw []byte, capsErr error) (Probe, error) {
+	p, err := utils.ParseProbe(raw, callErr, capsRaw, capsErr)
+	if err != nil {
+		return Probe{}, err
+	}
+	if err := p.Check(heLibrary); err != nil {
+		return Probe{}, err
+	}
+	return p, nil
+}
+
+// SeedResearchRandomness makes key generation and query encryption
+// reproducible from seed (see utils.SeedRandomness) until restore is
+// called, so noise-growth and correctness runs compare across machines.
+// RESEARCH ONLY: a query encrypted meanwhile hides its index from nobody
+// who knows the seed. Never use it against a real server.
+func SeedResearchRandomness(seed string) (restore func()) {
+	fmt.Println("[WARN] research mode: encryption randomness is seeded, queries are NOT private")
+	return utils.SeedRandomness(utils.NewSeededReader([]byte(seed)))
+}
+
+// SeedFromEnv calls SeedResearchRandomness with $PIR_RESEARCH_SEED when it
+// is set; otherwise restore is a no-op.
+func SeedFromEnv() (restore func()) {
+	seed, ok := os.LookupEnv(utils.ResearchSeedEnv)
+	if !ok {
+		return func() {}
+	}
+	return SeedResearchRandomness(seed)
 }
 
 // ---------- 1. Key & Parameter helpers ----------
@@ -40,17 +118,40 @@ type Metadata struct {
 //     65537 is the textbook choice.
 //
 // GenKeysFromMetadata builds the ParametersLiteral from metadata, then generates keys.
+// The parameters come from ParamCache (ParamsFromMetadata); the keys are
+// fresh on every call (GenKeyPair).
 func GenKeysFromMetadata(meta Metadata) (bgv.Parameters, *rlwe.SecretKey, *rlwe.PublicKey, error) {
-	lit := bgv.ParametersLiteral{
+	params, err := ParamsFromMetadata(meta)
+	if err != nil {
+		return params, nil, nil, err
+	}
+	sk, pk := GenKeyPair(params)
+	return params, sk, pk, nil
+}
+
+// ParamCache holds the bgv.Parameters built for metadata, most recently
+// used first. Timed benches read and purge it to tell parameter
+// construction from key sampling.
+var ParamCache = utils.NewParamCache(utils.DefaultParamCacheSize)
+
+// MetadataLiteral is the ParametersLiteral of meta.
+func MetadataLiteral(meta Metadata) bgv.ParametersLiteral {
+	return bgv.ParametersLiteral{
 		LogN:             meta.LogN,
 		LogQ:             meta.LogQi,
 		LogP:             meta.LogPi,
 		PlaintextModulus: meta.T,
 	}
-	params, err := bgv.NewParametersFromLiteral(lit)
-	if err != nil {
-		return params, nil, nil, err
-	}
+}
+
+// ParamsFromMetadata returns meta's parameters from ParamCache, building
+// them on a miss.
+func ParamsFromMetadata(meta Metadata) (bgv.Parameters, error) {
+	return ParamCache.Params(MetadataLiteral(meta))
+}
+
+// GenKeys samples a fresh key pair under params.
+func GenKeyPair(params bgv.Parameters) (*rlwe.SecretKey, *rlwe.PublicKey) {
 	kgen := bgv.NewKeyGenerator(params)
 	sk, pk := kgen.GenKeyPairNew()
 
@@ -60,39 +161,86 @@ func GenKeysFromMetadata(meta Metadata) (bgv.Parameters, *rlwe.SecretKey, *rlwe.
 			params.MaxLevel(), len(params.Q()))
 		fmt.Printf("       MaxSlots     : %d\n", params.MaxSlots())
 	}
-	return params, sk, pk, nil
+	return sk, pk
 }
 
 // ---------- 2. Encrypt PIR query ----------
 
+// maskPool, when set with UseMaskPool, serves EncryptQueryBase64 calls
+// under its params and public key from precomputed encryptions of zero.
+var maskPool *utils.MaskPool
+
+// UseMaskPool routes EncryptQueryBase64 through pool (nil: plain
+// encryption). The caller owns the pool and closes it.
+func UseMaskPool(pool *utils.MaskPool) { maskPool = pool }
+
+// MaskPoolFromEnv starts a pool for params and pk sized by PIR_MASK_POOL
+// (masks kept ready; unset or 0 → no pool, returns nil) and
+// PIR_MASK_WORKERS (background encryptors, default 1), and selects it
+// with UseMaskPool.
+func MaskPoolFromEnv(params bgv.Parameters, pk *rlwe.PublicKey) *utils.MaskPool {
+	size, _ := strconv.Atoi(os.Getenv("PIR_MASK_POOL"))
+	if size <= 0 {
+		return nil
+	}
+	workers, _ := strconv.Atoi(os.Getenv("PIR_MASK_WORKERS"))
+	pool := utils.NewMaskPool(params, pk, utils.MaskPoolOptions{Size: size, Workers: workers})
+	UseMaskPool(pool)
+	return pool
+}
+
 // EncryptQueryBase64 creates a one-hot vector for index i and returns
 // the ciphertext as Base64 (ready to send to chaincode).
 func EncryptQueryBase64(params bgv.Parameters, pk *rlwe.PublicKey, index, dbSize int, slotsPerRec int) (string, int, error) {
-	if index < 0 || index >= dbSize {
-		return "", 0, fmt.Errorf("index %d out of range 0..%d", index, dbSize-1)
+	ct, err := EncryptQuery(params, pk, index, dbSize, slotsPerRec)
+	if err != nil {
+		return "", 0, err
 	}
-	slots := params.MaxSlots() // ≤ 8192 in  2¹³ setup
-	fmt.Printf("       slots length  : %d\n", slots)
 
-	if dbSize*slotsPerRec > slots {
-		return "", 0, fmt.Errorf("dbSize (%d) exceeds slot capacity (%d)", dbSize, slots)
+	ctBytes, _ := ct.MarshalBinary()
+	b64 := base64.StdEncoding.EncodeToString(ctBytes)
+
+	if Debug {
+		fmt.Printf("       Ciphertext   : byteLen=%d  level=%d  degree=%d\n",
+			len(ctBytes), ct.Level(), ct.Degree())
+		// Show first 48 chars of Base64 for sanity
+		head := b64
+		if len(head) > 48 {
+			head = head[:48] + "..."
+		}
+		fmt.Printf("       EncQueryB64  : %s\n", head)
 	}
 
+	return b64, len(ctBytes), nil
+}
+
+// EncryptQuery is EncryptQueryBase64 without the serialisation: the ct_q
+// for StreamQuery, which writes it to the connection directly.
+func EncryptQuery(params bgv.Parameters, pk *rlwe.PublicKey, index, dbSize int, slotsPerRec int) (*rlwe.Ciphertext, error) {
+	// the window is shard-relative: the server multiplies ct_q with every
+	// m_DB shard
+	ic := IndexContract{NRecords: dbSize, RecordS: slotsPerRec, Slots: params.MaxSlots()}
+	if err := ic.ValidateSharded(); err != nil {
+		return nil, err
+	}
+	w, err := ic.Describe(index)
+	if err != nil {
+		return nil, err
+	}
+	startSlot, endSlot := w.StartSlot, w.EndSlot
+	slots := params.MaxSlots() // ≤ 8192 in  2¹³ setup
+	fmt.Printf("       slots length  : %d\n", slots)
+
 	encoder := bgv.NewEncoder(params)
-	encryptor := bgv.NewEncryptor(params, pk)
 
 	// 1. Build multi-hot vector of full slot length (padding zeros automatically OK)
 	vec := make([]uint64, slots)
-	startSlot := index * slotsPerRec // <── shift
-	if startSlot+slotsPerRec > slots {
-		return "", 0, fmt.Errorf("index out of range")
-	}
-	for i := 0; i < slotsPerRec; i++ { // <── 64 ones
-		vec[startSlot+i] = 1
+	for i := startSlot; i < endSlot; i++ { // <── record_s ones
+		vec[i] = 1
 	}
 	if Debug {
-		fmt.Printf("[DBG] ENC Active slots [%d:%d]:\n", startSlot, startSlot+slotsPerRec-1)
-		fmt.Printf("[DBG] SelectorVec = %v\n", vec[startSlot:startSlot+slotsPerRec])
+		fmt.Printf("[DBG] ENC Active slots [%d:%d]:\n", startSlot, endSlot-1)
+		fmt.Printf("[DBG] SelectorVec = %v\n", vec[startSlot:endSlot])
 	}
 	/*
 		if Debug {
@@ -110,31 +258,24 @@ func EncryptQueryBase64(params bgv.Parameters, pk *rlwe.PublicKey, index, dbSize
 	pt := bgv.NewPlaintext(params, params.MaxLevel()) // len(Q)-1
 	//fmt.Printf("       Plaintext: %v\n", pt)
 	if err := encoder.Encode(vec, pt); err != nil {
-		return "", 0, err
+		return nil, err
 	}
 
-	ct, err := encryptor.EncryptNew(pt)
+	var ct *rlwe.Ciphertext
+	if pool := maskPool; pool.Matches(params, pk) {
+		ct, err = pool.Encrypt(pt)
+	} else {
+		ct, err = bgv.NewEncryptor(params, pk).EncryptNew(pt)
+	}
 	if err != nil {
-		return "", 0, err
+		return nil, err
 	}
 
-	ctBytes, _ := ct.MarshalBinary()
-	b64 := base64.StdEncoding.EncodeToString(ctBytes)
-
 	if Debug {
 		fmt.Printf("[DBG] EncryptQuery  : index=%d  dbSize=%d  slots=%d\n",
 			index, dbSize, slots)
-		fmt.Printf("       Ciphertext   : byteLen=%d  level=%d  degree=%d\n",
-			len(ctBytes), ct.Level(), ct.Degree())
-		// Show first 48 chars of Base64 for sanity
-		head := b64
-		if len(head) > 48 {
-			head = head[:48] + "..."
-		}
-		fmt.Printf("       EncQueryB64  : %s\n", head)
 	}
-
-	return b64, len(ctBytes), nil
+	return ct, nil
 }
 
 // ---------- 3. Decrypt result ----------
@@ -144,10 +285,13 @@ func EncryptQueryBase64(params bgv.Parameters, pk *rlwe.PublicKey, index, dbSize
 type Decoded struct {
 	IntValue   uint64 // if single slot record
 	JSONString string // if record was multi-slot JSON
+	Empty      bool   // all-zero window (reserved index); JSONString is utils.EmptyRecord
 }
 
 // DecryptResult decrypts the ciphertext (base64) and extracts either
-// a single-slot integer or a multi-slot JSON string.
+// a single-slot integer or a multi-slot JSON string. For a sharded m_DB
+// the response holds one ciphertext per shard (utils.EncodeShardResults)
+// and only the shard holding index is decrypted.
 //
 //   - index           : record index originally queried (0-based)
 //   - dbSize          : total number of records in the DB
@@ -157,16 +301,55 @@ type Decoded struct {
 // ---------- 3. Decrypt result (multi-slot ready) -----------------
 func DecryptResult(params bgv.Parameters, sk *rlwe.SecretKey, encResBase64 string,
 	index, dbSize, slotsPerRecord int) (Decoded, error) {
+	return DecryptResultPacked(params, sk, encResBase64, index, dbSize, slotsPerRecord, utils.Packing1B)
+}
+
+// DecryptResultPacked is DecryptResult for a dataset packed under packing
+// (Metadata.Packing): under "2b" slotsPerRecord is the halved record_s and
+// every slot yields two bytes.
+func DecryptResultPacked(params bgv.Parameters, sk *rlwe.SecretKey, encResBase64 string,
+	index, dbSize, slotsPerRecord int, packing string) (Decoded, error) {
 
 	var out Decoded
 
 	/* 1) Deserialse ------------------------------------------------ */
-	raw, err := base64.StdEncoding.DecodeString(encResBase64)
+	ic := IndexContract{NRecords: dbSize, RecordS: slotsPerRecord, Slots: params.MaxSlots(), Packing: packing}
+	if err := ic.ValidateSharded(); err != nil {
+		return out, err
+	}
+	w, err := ic.Describe(index)
+	if err != nil {
+		return out, err
+	}
+	results, err := utils.DecodeShardResults(encResBase64)
+	if err != nil {
+		return out, err
+	}
+	if len(results) != ic.Shards() {
+		return out, fmt.Errorf("%d result ciphertexts for %d shard(s)", len(results), ic.Shards())
+	}
+	raw, err := base64.StdEncoding.DecodeString(results[w.Shard])
 	if err != nil {
 		return out, err
 	}
-	ct := rlwe.NewCiphertext(params, 1)
-	if err = ct.UnmarshalBinary(raw); err != nil {
+	return DecryptResultRawPacked(params, sk, raw, index, dbSize, slotsPerRecord, packing)
+}
+
+// DecryptResultRaw is DecryptResult for the raw ct_r bytes, as
+// StreamQuery returns them: the result of index's shard, the only one
+// of a single-plaintext m_DB.
+func DecryptResultRaw(params bgv.Parameters, sk *rlwe.SecretKey, raw []byte,
+	index, dbSize, slotsPerRecord int) (Decoded, error) {
+	return DecryptResultRawPacked(params, sk, raw, index, dbSize, slotsPerRecord, utils.Packing1B)
+}
+
+// DecryptResultRawPacked is DecryptResultRaw under packing.
+func DecryptResultRawPacked(params bgv.Parameters, sk *rlwe.SecretKey, raw []byte,
+	index, dbSize, slotsPerRecord int, packing string) (Decoded, error) {
+
+	var out Decoded
+	ct, err := utils.UnmarshalCiphertext(params, raw, 1)
+	if err != nil {
 		return out, err
 	}
 
@@ -177,22 +360,36 @@ func DecryptResult(params bgv.Parameters, sk *rlwe.SecretKey, encResBase64 strin
 	if err = bgv.NewEncoder(params).Decode(pt, plainvec); err != nil {
 		return out, err
 	}
+	return decodeWindow(plainvec, index, dbSize, slotsPerRecord, packing)
+}
+
+// decodeWindow extracts index's record from the decoded shard result
+// plainvec.
+func decodeWindow(plainvec []uint64, index, dbSize, slotsPerRecord int, packing string) (Decoded, error) {
+	var out Decoded
 
 	/* 3) Extracting requested CTI record -------------------------------------- */
-	if len(plainvec) < dbSize*slotsPerRecord {
+	ic := IndexContract{NRecords: dbSize, RecordS: slotsPerRecord, Slots: len(plainvec), Packing: packing}
+	if err := ic.ValidateSharded(); err != nil {
 		return out, errors.New("decoded vector shorter than expected")
 	}
+	w, err := ic.Describe(index) // [left border, end) within the shard
+	if err != nil {
+		return out, err
+	}
+	start, end := w.StartSlot, w.EndSlot
 
-	start := index * slotsPerRecord // ← left border
-	end := start + slotsPerRecord   // ← WITHOUT end
-
-	// Collecting zero bytes
+	// Collecting bytes up to the padding
 	var buf []byte
-	for _, v := range plainvec[start:end] {
-		if v == 0 {
-			break
-		} // meet padding → stop
-		buf = append(buf, byte(v))
+	if utils.BytesPerSlot(packing) == 2 {
+		buf = utils.TrimPadding(ic.UnpackWindow(plainvec[start:end]))
+	} else {
+		for _, v := range plainvec[start:end] {
+			if v == 0 {
+				break
+			} // meet padding → stop
+			buf = append(buf, byte(v))
+		}
 	}
 
 	/* 4) Prints / checks -------------------------------------------- */
@@ -209,6 +406,10 @@ func DecryptResult(params bgv.Parameters, sk *rlwe.SecretKey, encResBase64 strin
 		return out, nil
 	}
 
+	if len(buf) == 0 {
+		out.JSONString, out.Empty = utils.EmptyRecord, true
+		return out, nil
+	}
 	if !json.Valid(buf) {
 		return out, errors.New("decoded payload is not valid JSON")
 	}
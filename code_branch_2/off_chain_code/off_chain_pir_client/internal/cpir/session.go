@@ -0,0 +1,175 @@
+package cpir
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sync"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
+
+	"pir_shared/utils"
+)
+
+// ---------- 4. Query session ----------
+
+// GenKeysFromMetadata and EncryptQueryBase64 rebuild the keys, the encoder
+// and the encryptor on every query, and in e2e_latency key generation and
+// encoder construction cost more than the encryption itself. A Session
+// builds them once per dataset and reuses them, with the selector buffer
+// and the plaintext, for every query and decryption.
+//
+// Pregenerate encrypts the selectors of indices ahead of time, so a later
+// EncryptQueryBase64 of one of them only serialises. Each pre-generated
+// ct_q is handed out once: sending the same ciphertext twice would tell
+// the server that two queries are for the same record.
+
+// Session is a client's keys and HE state for one dataset. Its methods are
+// safe for concurrent use; encryption and decryption are serialised.
+type Session struct {
+	Meta   Metadata
+	Params bgv.Parameters
+	SK     *rlwe.SecretKey
+	PK     *rlwe.PublicKey
+
+	mu        sync.Mutex
+	ic        IndexContract
+	encoder   *bgv.Encoder
+	encryptor *rlwe.Encryptor
+	decryptor *rlwe.Decryptor
+	vec       []uint64
+	pt        *rlwe.Plaintext
+	ready     map[int][]*rlwe.Ciphertext // Pregenerate'd, not yet handed out
+}
+
+// NewSession generates keys for meta (GenKeysFromMetadata) and the
+// encoder, encryptor and decryptor every query of the session reuses.
+func NewSession(meta Metadata) (*Session, error) {
+	params, sk, pk, err := GenKeysFromMetadata(meta)
+	if err != nil {
+		return nil, err
+	}
+	ic := utils.NewIndexContract(meta)
+	ic.Slots = params.MaxSlots()
+	if err := ic.ValidateSharded(); err != nil {
+		return nil, err
+	}
+	return &Session{
+		Meta: meta, Params: params, SK: sk, PK: pk,
+		ic:        ic,
+		encoder:   bgv.NewEncoder(params),
+		encryptor: bgv.NewEncryptor(params, pk),
+		decryptor: bgv.NewDecryptor(params, sk),
+		vec:       make([]uint64, params.MaxSlots()),
+		pt:        bgv.NewPlaintext(params, params.MaxLevel()),
+		ready:     make(map[int][]*rlwe.Ciphertext),
+	}, nil
+}
+
+// Pregenerate encrypts one selector for every entry of indices (an index
+// listed twice gets two).
+func (s *Session) Pregenerate(indices ...int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, index := range indices {
+		ct, err := s.encrypt(index)
+		if err != nil {
+			return fmt.Errorf("index %d: %w", index, err)
+		}
+		s.ready[index] = append(s.ready[index], ct)
+	}
+	return nil
+}
+
+// Pregenerated is the number of selectors of index not yet handed out.
+func (s *Session) Pregenerated(index int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.ready[index])
+}
+
+// EncryptQuery returns a pre-generated selector of index, or encrypts a
+// new one.
+func (s *Session) EncryptQuery(index int) (*rlwe.Ciphertext, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cts := s.ready[index]; len(cts) > 0 {
+		s.ready[index] = cts[1:]
+		if len(cts) == 1 {
+			delete(s.ready, index)
+		}
+		return cts[0], nil
+	}
+	return s.encrypt(index)
+}
+
+// EncryptQueryBase64 is the package-level EncryptQueryBase64 under the
+// session's keys: the ct_q as Base64 and its size in bytes.
+func (s *Session) EncryptQueryBase64(index int) (string, int, error) {
+	ct, err := s.EncryptQuery(index)
+	if err != nil {
+		return "", 0, err
+	}
+	raw, err := ct.MarshalBinary()
+	if err != nil {
+		return "", 0, err
+	}
+	return base64.StdEncoding.EncodeToString(raw), len(raw), nil
+}
+
+// encrypt builds and encrypts index's selector; s.mu is held.
+func (s *Session) encrypt(index int) (*rlwe.Ciphertext, error) {
+	w, err := s.ic.Describe(index)
+	if err != nil {
+		return nil, err
+	}
+	clear(s.vec)
+	for i := w.StartSlot; i < w.EndSlot; i++ {
+		s.vec[i] = 1
+	}
+	if err := s.encoder.Encode(s.vec, s.pt); err != nil {
+		return nil, err
+	}
+	if pool := maskPool; pool.Matches(s.Params, s.PK) {
+		return pool.Encrypt(s.pt)
+	}
+	return s.encryptor.EncryptNew(s.pt)
+}
+
+// DecryptResult is DecryptResultPacked under the session's keys and
+// metadata.
+func (s *Session) DecryptResult(encResBase64 string, index int) (Decoded, error) {
+	w, err := s.ic.Describe(index)
+	if err != nil {
+		return Decoded{}, err
+	}
+	results, err := utils.DecodeShardResults(encResBase64)
+	if err != nil {
+		return Decoded{}, err
+	}
+	if len(results) != s.ic.Shards() {
+		return Decoded{}, fmt.Errorf("%d result ciphertexts for %d shard(s)", len(results), s.ic.Shards())
+	}
+	raw, err := base64.StdEncoding.DecodeString(results[w.Shard])
+	if err != nil {
+		return Decoded{}, err
+	}
+	return s.DecryptResultRaw(raw, index)
+}
+
+// DecryptResultRaw is DecryptResultRawPacked under the session's keys and
+// metadata.
+func (s *Session) DecryptResultRaw(raw []byte, index int) (Decoded, error) {
+	ct, err := utils.UnmarshalCiphertext(s.Params, raw, 1)
+	if err != nil {
+		return Decoded{}, err
+	}
+	plainvec := make([]uint64, s.Params.MaxSlots())
+	s.mu.Lock()
+	err = s.encoder.Decode(s.decryptor.DecryptNew(ct), plainvec)
+	s.mu.Unlock()
+	if err != nil {
+		return Decoded{}, err
+	}
+	return decodeWindow(plainvec, index, s.Meta.NRecords, s.Meta.RecordS, s.Meta.Packing)
+}
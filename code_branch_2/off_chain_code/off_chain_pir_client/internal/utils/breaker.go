@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/********* CIRCUIT BREAKER ****************************************/
+
+// ErrCircuitOpen is returned without contacting the server while the
+// breaker is open (too many consecutive timeouts / overload responses).
+var ErrCircuitOpen = errors.New("circuit open: server saturated, failing fast")
+
+// breaker opens after `failures` consecutive failed calls and stays open
+// for `cooldown`; the first call after that is let through as a probe
+// (half-open) and either closes the breaker or re-opens it.
+type breaker struct {
+	mtx       sync.Mutex
+	failures  int // threshold; <=0 disables the breaker
+	cooldown  time.Duration
+	streak    int
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *breaker) allow() error {
+	if b == nil || b.failures <= 0 {
+		return nil
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.streak < b.failures {
+		return nil
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return fmt.Errorf("%w (retry after %s)", ErrCircuitOpen, time.Until(b.openUntil).Round(time.Millisecond))
+	}
+	b.probing = true
+	return nil
+}
+
+func (b *breaker) record(ok bool) {
+	if b == nil || b.failures <= 0 {
+		return
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.probing = false
+	if ok {
+		b.streak = 0
+		return
+	}
+	b.streak++
+	if b.streak >= b.failures {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"off-chain-pir-api/pirpb"
+)
+
+/********* gRPC helpers *******************************************/
+
+// The server's gRPC API (grpc_addr) carries ct_q and ct_r as raw bytes,
+// like the Fabric gateway path of the on-chain client, so benchmarks of
+// the two compare the PIR work rather than JSON vs protobuf. GRPCAddr
+// (PIR_GRPC_ADDR, host:port) selects it; TLS follows the scheme of the
+// REST URL, with the same CA and verification settings. Dataset and Token
+// apply as for Call, and so do the timeout and breaker; nothing is
+// retried.
+
+// grpcMaxMsg matches the server's unary message limit.
+const grpcMaxMsg = 64 << 20
+
+// grpcChunk is the ct_q piece size GRPCStreamQuery sends.
+const grpcChunk = 64 << 10
+
+var (
+	grpcAddr string
+	grpcTLS  *tls.Config // nil → plaintext
+
+	grpcMtx  sync.Mutex
+	grpcConn *grpc.ClientConn
+)
+
+// GRPCAddr returns the configured gRPC address ("" when unset).
+func GRPCAddr() string { return grpcAddr }
+
+// GRPCClient returns the stub of the shared connection, dialled on first
+// use.
+func GRPCClient() (pirpb.PIRClient, error) {
+	grpcMtx.Lock()
+	defer grpcMtx.Unlock()
+	if grpcAddr == "" {
+		return nil, fmt.Errorf("no gRPC address configured (PIR_GRPC_ADDR)")
+	}
+	if grpcConn == nil {
+		creds := insecure.NewCredentials()
+		if grpcTLS != nil {
+			creds = credentials.NewTLS(grpcTLS)
+		}
+		conn, err := grpc.NewClient(grpcAddr,
+			grpc.WithTransportCredentials(creds),
+			grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(grpcMaxMsg), grpc.MaxCallSendMsgSize(grpcMaxMsg)))
+		if err != nil {
+			return nil, fmt.Errorf("gRPC dial %s: %w", grpcAddr, err)
+		}
+		grpcConn = conn
+	}
+	return pirpb.NewPIRClient(grpcConn), nil
+}
+
+// grpcContext adds the call timeout and the bearer token.
+func grpcContext() (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	if Token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+Token)
+	}
+	if callTimeout > 0 {
+		return context.WithTimeout(ctx, callTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// grpcTransient reports gRPC failures that count against the breaker,
+// like 429 / 5xx and timeouts over REST.
+func grpcTransient(err error) bool {
+	switch status.Code(err) {
+	case codes.OK, codes.InvalidArgument, codes.NotFound, codes.PermissionDenied,
+		codes.Unauthenticated, codes.FailedPrecondition:
+		return false
+	}
+	return true
+}
+
+// GRPCQueryTimed runs one PIRQueryTimed over gRPC with the marshalled
+// ct_q and returns ct_r and the server's evaluation time.
+func GRPCQueryTimed(ctQ []byte) (ctR []byte, evalMS float64, err error) {
+	pc, err := GRPCClient()
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := circuit.allow(); err != nil {
+		return nil, 0, err
+	}
+	ctx, cancel := grpcContext()
+	defer cancel()
+	resp, err := pc.PIRQueryTimed(ctx, &pirpb.PIRQueryRequest{Dataset: Dataset, CtQ: ctQ})
+	circuit.record(!grpcTransient(err))
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.CtR, resp.EvalMs, nil
+}
+
+// GRPCStreamQuery is StreamQuery over the PIRQueryStream RPC: ct_q is
+// serialised straight into QueryChunks and the ct_r chunks are joined.
+func GRPCStreamQuery(ctQ io.WriterTo) (ctR []byte, evalMS float64, err error) {
+	pc, err := GRPCClient()
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := circuit.allow(); err != nil {
+		return nil, 0, err
+	}
+	ctx, cancel := grpcContext()
+	defer cancel()
+	ctR, evalMS, err = grpcStreamOnce(ctx, pc, ctQ)
+	circuit.record(!grpcTransient(err))
+	return ctR, evalMS, err
+}
+
+func grpcStreamOnce(ctx context.Context, pc pirpb.PIRClient, ctQ io.WriterTo) ([]byte, float64, error) {
+	stream, err := pc.PIRQueryStream(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	bw := bufio.NewWriterSize(&chunkSender{stream: stream}, grpcChunk)
+	if _, err := ctQ.WriteTo(bw); err != nil {
+		return nil, 0, err
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, 0, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, 0, err
+	}
+
+	var out []byte
+	var evalMS float64
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return out, evalMS, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if chunk.EvalMs != 0 {
+			evalMS = chunk.EvalMs
+		}
+		out = append(out, chunk.Data...)
+	}
+}
+
+// chunkSender sends each Write as one QueryChunk; the first names the
+// dataset.
+type chunkSender struct {
+	stream pirpb.PIR_PIRQueryStreamClient
+	sent   bool
+}
+
+func (c *chunkSender) Write(p []byte) (int, error) {
+	chunk := &pirpb.QueryChunk{Data: p}
+	if !c.sent {
+		chunk.Dataset, c.sent = Dataset, true
+	}
+	if err := c.stream.Send(chunk); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+/********* Streaming PIR ******************************************/
+
+// StreamQuery runs one PIR query over POST /pir/stream: ct_q (e.g. an
+// *rlwe.Ciphertext from cpir.EncryptQuery) is serialised straight into the
+// chunked request body and the raw ct_r is returned, with no Base64 or
+// JSON on either side (cpir.DecryptResultRaw decodes it). The body cannot
+// be replayed, so the call is not retried; the timeout and breaker apply
+// as for Call.
+func StreamQuery(ctQ io.WriterTo) ([]byte, error) {
+	if err := circuit.allow(); err != nil {
+		return nil, err
+	}
+	out, transient, err := streamOnce(ctQ)
+	circuit.record(!transient)
+	return out, err
+}
+
+func streamOnce(ctQ io.WriterTo) (out []byte, transient bool, err error) {
+	ctx := context.Background()
+	if callTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, callTimeout)
+		defer cancel()
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		bw := bufio.NewWriter(pw)
+		_, err := ctQ.WriteTo(bw)
+		if err == nil {
+			err = bw.Flush()
+		}
+		pw.CloseWithError(err)
+	}()
+	defer pr.Close()
+
+	target := baseURL + "/pir/stream"
+	if Dataset != "" {
+		target += "?dataset=" + url.QueryEscape(Dataset)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, pr)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if Token != "" {
+		req.Header.Set("Authorization", "Bearer "+Token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	transient = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	if resp.StatusCode != http.StatusOK {
+		var wrap struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&wrap) == nil && wrap.Error != "" {
+			return nil, transient, fmt.Errorf("%s", wrap.Error)
+		}
+		return nil, transient, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	out, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+	return out, false, nil
+}
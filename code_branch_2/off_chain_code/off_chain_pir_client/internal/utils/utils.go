@@ -2,34 +2,314 @@ package utils
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	shared "pir_shared/utils"
 )
 
 /********* REST helpers *******************************************/
+
+// Dataset and Token select the server-side dataset and the bearer token
+// presented for its ACL. Both default to the PIR_DATASET / PIR_TOKEN
+// environment variables; empty values keep the pre-ACL behaviour.
+var (
+	Dataset = os.Getenv("PIR_DATASET")
+	Token   = os.Getenv("PIR_TOKEN")
+)
+
+// ClientOptions selects the server Call talks to and how. Zero values
+// select the defaults (plain HTTP to localhost:8080, Default* limits).
+type ClientOptions struct {
+	BaseURL            string // e.g. https://pir.example.org:8443 (no /invoke)
+	CACert             string // PEM bundle trusted in addition to the system roots
+	InsecureSkipVerify bool   // accept any server certificate (testing only)
+	Proxy              string // proxy URL; empty → HTTP(S)_PROXY / NO_PROXY
+	GRPCAddr           string // host:port of the server's gRPC API (grpc.go); empty → REST only
+
+	// Failure handling. Timeout bounds every call except InitLedger*
+	// (0 → DefaultTimeout, <0 → none); Retries applies to idempotent
+	// methods only; BreakerFailures consecutive timeouts / 429 / 5xx open
+	// the circuit for BreakerCooldown (0 → defaults, <0 → disabled).
+	Timeout         time.Duration
+	Retries         int
+	BreakerFailures int
+	BreakerCooldown time.Duration
+}
+
+const (
+	defaultBaseURL = "http://localhost:8080"
+
+	DefaultTimeout         = 2 * time.Minute
+	DefaultRetries         = 2
+	DefaultBreakerFailures = 5
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+var (
+	baseURL = defaultBaseURL
+	client  = &http.Client{Transport: newTransport(nil, nil)}
+
+	callTimeout = DefaultTimeout
+	retries     = DefaultRetries
+	circuit     = &breaker{failures: DefaultBreakerFailures, cooldown: DefaultBreakerCooldown}
+)
+
+// OnCall, when set, is told about every Call before it is sent: the
+// method and the length of its longest argument (e.g. a TraceRecorder's
+// Record, for cmd/replay). Set it before any concurrent Call.
+var OnCall func(method string, argBytes int)
+
+// nonIdempotent methods change server state and are never retried.
+var nonIdempotent = map[string]bool{"InitLedger": true, "InitLedgerAsync": true}
+
+func init() {
+	if err := Configure(OptionsFromEnv()); err != nil {
+		fmt.Fprintf(os.Stderr, "[WARN] PIR client config ignored: %v\n", err)
+	}
+}
+
+// OptionsFromEnv reads ClientOptions from the PIR_* environment variables
+// Call starts with.
+func OptionsFromEnv() ClientOptions {
+	return ClientOptions{
+		BaseURL:            os.Getenv("PIR_SERVER_URL"),
+		CACert:             os.Getenv("PIR_CA_CERT"),
+		InsecureSkipVerify: os.Getenv("PIR_INSECURE_SKIP_VERIFY") == "1",
+		Proxy:              os.Getenv("PIR_PROXY"),
+		GRPCAddr:           os.Getenv("PIR_GRPC_ADDR"),
+		Timeout:            envDuration("PIR_TIMEOUT"),
+		Retries:            envInt("PIR_RETRIES"),
+		BreakerFailures:    envInt("PIR_BREAKER_FAILURES"),
+		BreakerCooldown:    envDuration("PIR_BREAKER_COOLDOWN"),
+	}
+}
+
+// Configure replaces the shared HTTP client used by Call and drops the
+// gRPC connection (grpc.go). It is meant to run once at startup (flags /
+// env), before any concurrent Call.
+func Configure(opts ClientOptions) error {
+	u := defaultBaseURL
+	if opts.BaseURL != "" {
+		parsed, err := url.Parse(opts.BaseURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid server URL %q (want http[s]://host[:port])", opts.BaseURL)
+		}
+		u = strings.TrimRight(opts.BaseURL, "/")
+	}
+
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: opts.InsecureSkipVerify}
+	if opts.CACert != "" {
+		pem, err := os.ReadFile(opts.CACert)
+		if err != nil {
+			return fmt.Errorf("read CA cert: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", opts.CACert)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if opts.Proxy != "" {
+		p, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL %q: %w", opts.Proxy, err)
+		}
+		proxy = http.ProxyURL(p)
+	}
+
+	baseURL = u
+	client = &http.Client{Transport: newTransport(tlsCfg, proxy)}
+
+	grpcMtx.Lock()
+	if grpcConn != nil {
+		grpcConn.Close()
+		grpcConn = nil
+	}
+	grpcAddr, grpcTLS = opts.GRPCAddr, nil
+	if strings.HasPrefix(u, "https://") {
+		grpcTLS = tlsCfg
+	}
+	grpcMtx.Unlock()
+
+	callTimeout = pick(opts.Timeout, DefaultTimeout)
+	retries = pick(opts.Retries, DefaultRetries)
+	circuit = &breaker{
+		failures: pick(opts.BreakerFailures, DefaultBreakerFailures),
+		cooldown: pick(opts.BreakerCooldown, DefaultBreakerCooldown),
+	}
+	return nil
+}
+
+// pick maps the ClientOptions convention (0 → default, <0 → off) onto v.
+func pick[T int | time.Duration](v, def T) T {
+	switch {
+	case v == 0:
+		return def
+	case v < 0:
+		return 0
+	}
+	return v
+}
+
+func envInt(key string) int {
+	v, _ := strconv.Atoi(os.Getenv(key))
+	return v
+}
+
+func envDuration(key string) time.Duration {
+	v, _ := time.ParseDuration(os.Getenv(key))
+	return v
+}
+
+// newTransport keeps connections alive across calls; PIR queries are
+// large POSTs, so reusing the TCP/TLS session matters for remote servers.
+func newTransport(tlsCfg *tls.Config, proxy func(*http.Request) (*url.URL, error)) *http.Transport {
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
+	return &http.Transport{
+		Proxy:               proxy,
+		TLSClientConfig:     tlsCfg,
+		MaxIdleConns:        16,
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		ForceAttemptHTTP2:   true,
+	}
+}
+
+// BaseURL returns the server URL Call currently targets.
+func BaseURL() string { return baseURL }
+
+// Call invokes method with the configured timeout, retry and breaker
+// policy.
 func Call(method string, args ...string) (string, error) {
+	timeout := callTimeout
+	if nonIdempotent[method] {
+		timeout = 0
+	}
+	return CallTimeout(timeout, method, args...)
+}
+
+// InitLedgerNegotiated calls InitLedger with the advisor's cheapest
+// layout for n records of maxJSON bytes and, while the server answers
+// "capacity exceeded", retries with the next one (shared.NegotiateInit).
+// logN, if set, is the smallest ring tried; logQi, logPi and t are passed
+// through. Only layouts under packing (empty: "1b") are tried; it goes to
+// the server as InitLedger's packing argument. It returns the layout the
+// server accepted.
+func InitLedgerNegotiated(n, maxJSON int, logN, logQi, logPi, t, packing string, caps shared.Capabilities) (shared.InitPlan, error) {
+	if want, err := strconv.Atoi(logN); err == nil {
+		caps.LogN = slices.DeleteFunc(slices.Clone(caps.LogN), func(l int) bool { return l < want })
+	}
+	caps, err := caps.WithPacking(packing)
+	if err != nil {
+		return shared.InitPlan{}, err
+	}
+	return shared.NegotiateInit(n, maxJSON, caps, func(p shared.InitPlan) error {
+		args := []string{fmt.Sprint(n), fmt.Sprint(maxJSON), fmt.Sprint(p.LogN), logQi, logPi, t}
+		if p.Packing != shared.Packing1B {
+			args = append(args, "", p.Packing) // reserve, packing
+		}
+		_, err := Call("InitLedger", args...)
+		return err
+	})
+}
+
+// CallTimeout is Call with an explicit per-attempt timeout (0 → none).
+// Idempotent methods are retried with exponential backoff on transport
+// errors, timeouts, 429 and 5xx; application errors are returned as is.
+func CallTimeout(timeout time.Duration, method string, args ...string) (string, error) {
+	if OnCall != nil {
+		longest := 0
+		for _, a := range args {
+			longest = max(longest, len(a))
+		}
+		OnCall(method, longest)
+	}
+	attempts := 1
+	if !nonIdempotent[method] {
+		attempts += retries
+	}
+	var err error
+	for a := 0; a < attempts; a++ {
+		if a > 0 {
+			time.Sleep(time.Duration(100<<a) * time.Millisecond)
+		}
+		if err = circuit.allow(); err != nil {
+			return "", err
+		}
+		var out string
+		var transient bool
+		out, transient, err = callOnce(timeout, method, args)
+		circuit.record(!transient)
+		if !transient {
+			return out, err
+		}
+	}
+	return "", fmt.Errorf("%s: giving up after %d attempt(s): %w", method, attempts, err)
+}
+
+// callOnce performs one HTTP round trip. transient reports failures worth
+// retrying and counting against the breaker.
+func callOnce(timeout time.Duration, method string, args []string) (out string, transient bool, err error) {
 	reqBody, _ := json.Marshal(map[string]interface{}{
-		"method": method, "args": args,
+		"method": method, "args": args, "dataset": Dataset,
 	})
-	resp, err := http.Post("http://localhost:8080/invoke",
-		"application/json", bytes.NewBuffer(reqBody))
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/invoke", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return "", false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if Token != "" {
+		req.Header.Set("Authorization", "Bearer "+Token)
+	}
+	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return "", true, err
 	}
 	defer resp.Body.Close()
-	all, _ := io.ReadAll(resp.Body)
+	all, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", true, err
+	}
+	transient = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
 
 	var wrap struct {
 		Response string `json:"response"`
 		Error    string `json:"error"`
 	}
 	if err := json.Unmarshal(all, &wrap); err != nil {
-		return "", err
+		if transient {
+			return "", true, fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+		return "", false, err
 	}
 	if wrap.Error != "" {
-		return "", fmt.Errorf("%s", wrap.Error)
+		return "", transient, fmt.Errorf("%s", wrap.Error)
 	}
-	return wrap.Response, nil
+	return wrap.Response, false, nil
 }
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"pir_shared/utils"
+)
+
+/********* DATASET ACLs ********************************************/
+
+// Permissions checked by /invoke. InitLedger needs permInit, everything
+// else that touches a dataset needs permQuery.
+const (
+	permQuery = "query"
+	permInit  = "init"
+)
+
+// datasetACL lists the identities allowed to query / re-init one dataset.
+// A dataset without an ACL is open to everyone (pre-ACL behaviour);
+// creating one is not (Server.checkCreate).
+type datasetACL struct {
+	Query []string `json:"query"`
+	Init  []string `json:"init"`
+}
+
+func (a datasetACL) allows(id, perm string) bool {
+	switch perm {
+	case permInit:
+		return slices.Contains(a.Init, id)
+	default:
+		return slices.Contains(a.Query, id)
+	}
+}
+
+// accessControl maps client bearer tokens to identities and holds the
+// per-dataset ACLs. ACLs are keyed by name and survive /admin/reset, so a
+// dataset can be locked down before it is first initialized.
+type accessControl struct {
+	mtx        sync.RWMutex
+	identities map[string]string // token → identity
+	seeded     map[string]bool   // identities installed by the config file
+	acls       map[string]datasetACL
+}
+
+func newAccessControl() *accessControl {
+	return &accessControl{
+		identities: map[string]string{},
+		seeded:     map[string]bool{},
+		acls:       map[string]datasetACL{},
+	}
+}
+
+// seedIdentities installs the identities from the config file (id → token),
+// replacing any earlier token of the same id. Identities an earlier config
+// installed and ids no longer lists are removed, so a reload revokes their
+// tokens; identities registered through /admin/identities are kept.
+func (ac *accessControl) seedIdentities(ids map[string]string) {
+	ac.mtx.Lock()
+	defer ac.mtx.Unlock()
+	for tok, id := range ac.identities {
+		if _, ok := ids[id]; ok || ac.seeded[id] {
+			delete(ac.identities, tok)
+		}
+	}
+	ac.seeded = make(map[string]bool, len(ids))
+	for id, tok := range ids {
+		ac.identities[tok] = id
+		ac.seeded[id] = true
+	}
+}
+
+// identify resolves the caller from "Authorization: Bearer <token>".
+// Unknown or missing tokens yield "".
+func (ac *accessControl) identify(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return ""
+	}
+	ac.mtx.RLock()
+	defer ac.mtx.RUnlock()
+	return ac.identities[token]
+}
+
+// check returns nil when id may perform perm on dataset.
+func (ac *accessControl) check(dataset, id, perm string) error {
+	ac.mtx.RLock()
+	acl, ok := ac.acls[dataset]
+	ac.mtx.RUnlock()
+	if !ok {
+		return nil
+	}
+	if id == "" {
+		return fmt.Errorf("dataset %q requires an authenticated identity", dataset)
+	}
+	if !acl.allows(id, perm) {
+		return fmt.Errorf("identity %q lacks %s permission on dataset %q", id, perm, dataset)
+	}
+	return nil
+}
+
+/********* ADMIN ENDPOINTS (ACL) ***********************************/
+
+func (s *Server) registerACLAdmin(mux *http.ServeMux) {
+	mux.HandleFunc("POST /admin/identities", s.requireAdmin(s.adminAddIdentity))
+	mux.HandleFunc("GET /admin/identities", s.requireAdmin(s.adminListIdentities))
+	mux.HandleFunc("GET /admin/dataset/{name}/acl", s.requireAdmin(s.adminGetACL))
+	mux.HandleFunc("PUT /admin/dataset/{name}/acl", s.requireAdmin(s.adminPutACL))
+	mux.HandleFunc("DELETE /admin/dataset/{name}/acl", s.requireAdmin(s.adminDeleteACL))
+}
+
+// adminAddIdentity registers (or re-keys) a client: {"id": "...", "token": "..."}.
+func (s *Server) adminAddIdentity(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteErr(w, fmt.Errorf("decode identity: %w", err))
+		return
+	}
+	if req.ID == "" || req.Token == "" {
+		utils.WriteErr(w, fmt.Errorf("id and token are required"))
+		return
+	}
+	if req.Token == s.config().AdminToken {
+		utils.WriteErr(w, fmt.Errorf("client token must differ from the admin token"))
+		return
+	}
+
+	ac := s.access
+	ac.mtx.Lock()
+	for tok, id := range ac.identities {
+		if id == req.ID {
+			delete(ac.identities, tok) // one token per identity
+		}
+	}
+	ac.identities[req.Token] = req.ID
+	delete(ac.seeded, req.ID) // registered at runtime now, kept on reload
+	ac.mtx.Unlock()
+	log.Printf("[ADMIN] identity %q registered", req.ID)
+
+	utils.WriteOK(w, "identity registered")
+}
+
+func (s *Server) adminListIdentities(w http.ResponseWriter, r *http.Request) {
+	ac := s.access
+	ac.mtx.RLock()
+	ids := make([]string, 0, len(ac.identities))
+	for _, id := range ac.identities {
+		ids = append(ids, id)
+	}
+	ac.mtx.RUnlock()
+	slices.Sort(ids)
+
+	out, _ := json.Marshal(map[string][]string{"identities": ids})
+	utils.WriteOK(w, string(out))
+}
+
+func (s *Server) adminGetACL(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	s.access.mtx.RLock()
+	acl, ok := s.access.acls[name]
+	s.access.mtx.RUnlock()
+	if !ok {
+		utils.WriteErrStatus(w, http.StatusNotFound, fmt.Errorf("dataset %q has no ACL (open)", name))
+		return
+	}
+	out, _ := json.Marshal(acl)
+	utils.WriteOK(w, string(out))
+}
+
+// adminPutACL replaces the ACL of a dataset: {"query": [...], "init": [...]}.
+func (s *Server) adminPutACL(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !datasetNameRe.MatchString(name) {
+		utils.WriteErr(w, fmt.Errorf("invalid dataset name %q", name))
+		return
+	}
+	var acl datasetACL
+	if err := json.NewDecoder(r.Body).Decode(&acl); err != nil {
+		utils.WriteErr(w, fmt.Errorf("decode ACL: %w", err))
+		return
+	}
+
+	s.access.mtx.Lock()
+	s.access.acls[name] = acl
+	s.access.mtx.Unlock()
+	log.Printf("[ADMIN] ACL for %q: query=%v init=%v", name, acl.Query, acl.Init)
+
+	utils.WriteOK(w, "acl updated")
+}
+
+// adminDeleteACL removes the ACL, re-opening the dataset to everyone.
+func (s *Server) adminDeleteACL(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	s.access.mtx.Lock()
+	delete(s.access.acls, name)
+	s.access.mtx.Unlock()
+	log.Printf("[ADMIN] ACL for %q removed", name)
+
+	utils.WriteOK(w, "acl removed")
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pir_shared/blobstore"
+	"pir_shared/utils"
+)
+
+/********* DATASETS ************************************************/
+
+// defaultDataset is used by /invoke requests that do not name a dataset,
+// so existing clients keep talking to a single database.
+const defaultDataset = "default"
+
+// datasetNameRe keeps names safe to use as snapshot file names.
+var datasetNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Server owns the named datasets (one LedgerState each) and the admin API.
+type Server struct {
+	mtx      sync.RWMutex
+	datasets map[string]*LedgerState
+
+	// Reloadable settings (see config.go)
+	cfgPath string
+	cfg     atomic.Pointer[serverConfig]
+	cert    atomic.Pointer[tls.Certificate]
+	workers atomic.Pointer[chan struct{}]
+	limiter *rateLimiter
+
+	access *accessControl // client identities + per-dataset ACLs
+
+	replica replica // warm standby state (replica.go)
+}
+
+func newServer(cfgPath string) (*Server, error) {
+	s := &Server{
+		datasets: map[string]*LedgerState{},
+		cfgPath:  cfgPath,
+		limiter:  newRateLimiter(),
+		access:   newAccessControl(),
+	}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// dataset looks up name, creating an empty LedgerState when create is set.
+func (s *Server) dataset(name string, create bool) (*LedgerState, bool) {
+	s.mtx.RLock()
+	ls, ok := s.datasets[name]
+	s.mtx.RUnlock()
+	if ok || !create {
+		return ls, ok
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if ls, ok = s.datasets[name]; !ok {
+		ls = &LedgerState{}
+		s.datasets[name] = ls
+	}
+	return ls, true
+}
+
+// checkCreate returns nil when id may create dataset name, which does not
+// exist yet: an identity with init permission in its ACL (set beforehand
+// through PUT /admin/dataset/{name}/acl). A server without an admin_token
+// cannot grant that, so there anyone may create the default dataset.
+// The admin token passes without asking.
+func (s *Server) checkCreate(name, id string) error {
+	if name == defaultDataset && s.config().AdminToken == "" {
+		return nil
+	}
+	s.access.mtx.RLock()
+	acl, ok := s.access.acls[name]
+	s.access.mtx.RUnlock()
+	if ok && id != "" && acl.allows(id, permInit) {
+		return nil
+	}
+	return fmt.Errorf("creating dataset %q requires the admin token or an identity with %s permission in its ACL", name, permInit)
+}
+
+// lockInit returns dataset name with its initMu held, creating it first
+// when create returns nil. Synchronous inits of one dataset run one at a
+// time under initMu, and so does dropIfUninitialized after a failed one,
+// so a failed init cannot drop a dataset another caller is still
+// building. The caller unlocks initMu after dropIfUninitialized.
+func (s *Server) lockInit(name string, create func() error) (*LedgerState, error) {
+	for {
+		ls, ok := s.dataset(name, false)
+		if !ok {
+			if err := create(); err != nil {
+				return nil, err
+			}
+			ls, _ = s.dataset(name, true)
+		}
+		ls.initMu.Lock()
+		if cur, ok := s.dataset(name, false); ok && cur == ls {
+			return ls, nil
+		}
+		ls.initMu.Unlock() // dropped or reset while we waited
+	}
+}
+
+// dropIfUninitialized removes ls, the dataset name, when it holds no m_DB
+// and no rebuild is running. The caller holds ls.initMu (lockInit).
+func (s *Server) dropIfUninitialized(name string, ls *LedgerState) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if cur, ok := s.datasets[name]; ok && cur == ls {
+		ls.mtx.RLock()
+		empty := ls.m_DB == nil && ls.rebuild.State != "running"
+		ls.mtx.RUnlock()
+		if empty {
+			delete(s.datasets, name)
+		}
+	}
+}
+
+func (s *Server) datasetNames() []string {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	names := make([]string, 0, len(s.datasets))
+	for name := range s.datasets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/********* ADMIN API ***********************************************/
+
+// registerAdmin mounts the admin endpoints on mux. All of them require
+// "Authorization: Bearer <admin_token>".
+func (s *Server) registerAdmin(mux *http.ServeMux) {
+	mux.HandleFunc("POST /admin/reset", s.requireAdmin(s.adminReset))
+	mux.HandleFunc("POST /admin/snapshot", s.requireAdmin(s.adminSnapshot))
+	mux.HandleFunc("GET /admin/datasets", s.requireAdmin(s.adminDatasets))
+	mux.HandleFunc("GET /admin/dataset/{name}/stats", s.requireAdmin(s.adminDatasetStats))
+	mux.HandleFunc("POST /admin/reload", s.requireAdmin(s.adminReload))
+	s.registerACLAdmin(mux)
+	s.registerReplicaAdmin(mux)
+}
+
+// isAdmin reports whether r carries the admin bearer token.
+func (s *Server) isAdmin(r *http.Request) bool {
+	admin := s.config().AdminToken
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && admin != "" && subtle.ConstantTimeCompare([]byte(token), []byte(admin)) == 1
+}
+
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config().AdminToken == "" {
+			utils.WriteErrStatus(w, http.StatusForbidden, fmt.Errorf("admin API disabled (no admin_token / PIR_ADMIN_TOKEN)"))
+			return
+		}
+		if !s.isAdmin(r) {
+			utils.WriteErrStatus(w, http.StatusUnauthorized, fmt.Errorf("invalid admin token"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// adminRequest is the optional body of POST /admin/reset and /admin/snapshot.
+// An empty dataset means "all datasets".
+type adminRequest struct {
+	Dataset string `json:"dataset"`
+}
+
+func decodeAdminRequest(r *http.Request) (adminRequest, error) {
+	var req adminRequest
+	if r.ContentLength == 0 {
+		req.Dataset = r.URL.Query().Get("dataset")
+		return req, nil
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, fmt.Errorf("decode admin request: %w", err)
+	}
+	return req, nil
+}
+
+// adminReset drops the in-memory state of one dataset (or of all of them).
+// The next InitLedger for that name starts from scratch.
+func (s *Server) adminReset(w http.ResponseWriter, r *http.Request) {
+	req, err := decodeAdminRequest(r)
+	if err != nil {
+		utils.WriteErr(w, err)
+		return
+	}
+
+	s.mtx.Lock()
+	var dropped []string
+	if req.Dataset == "" {
+		for name := range s.datasets {
+			dropped = append(dropped, name)
+		}
+		s.datasets = map[string]*LedgerState{}
+	} else if _, ok := s.datasets[req.Dataset]; ok {
+		delete(s.datasets, req.Dataset)
+		dropped = append(dropped, req.Dataset)
+	}
+	s.mtx.Unlock()
+
+	if req.Dataset != "" && len(dropped) == 0 {
+		utils.WriteErrStatus(w, http.StatusNotFound, fmt.Errorf("unknown dataset %q", req.Dataset))
+		return
+	}
+	sort.Strings(dropped)
+	log.Printf("[ADMIN] reset datasets %v", dropped)
+
+	out, _ := json.Marshal(map[string][]string{"reset": dropped})
+	utils.WriteOK(w, string(out))
+}
+
+// snapshotFile is the on-disk layout written by POST /admin/snapshot.
+type snapshotFile struct {
+	Dataset   string         `json:"dataset"`
+	TakenAt   time.Time      `json:"taken_at"`
+	Metadata  utils.Metadata `json:"metadata"`
+	Records   []string       `json:"records"`            // raw JSON records as stored under "record%03d"
+	MDBBase64 string         `json:"m_db_b64,omitempty"` // marshalled rlwe.Plaintext per shard, concatenated
+	MDBRef    *blobstore.Ref `json:"m_db_ref,omitempty"` // set instead when m_DB went to the blob store
+	Shards    int            `json:"shards,omitempty"`   // m_DB plaintexts (absent: 1)
+}
+
+// adminSnapshot writes <snapshotDir>/<dataset>-<unix>.json for one or all
+// initialized datasets and returns the written paths.
+func (s *Server) adminSnapshot(w http.ResponseWriter, r *http.Request) {
+	req, err := decodeAdminRequest(r)
+	if err != nil {
+		utils.WriteErr(w, err)
+		return
+	}
+
+	dir := s.config().SnapshotDir
+	names := []string{req.Dataset}
+	if req.Dataset == "" {
+		names = s.datasetNames()
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		utils.WriteErr(w, fmt.Errorf("create snapshot dir: %w", err))
+		return
+	}
+
+	var paths []string
+	for _, name := range names {
+		ls, ok := s.dataset(name, false)
+		if !ok {
+			utils.WriteErrStatus(w, http.StatusNotFound, fmt.Errorf("unknown dataset %q", name))
+			return
+		}
+		snap, mdb, err := ls.snapshot(name)
+		if err != nil {
+			if req.Dataset == "" {
+				continue // skip datasets that were created but never initialized
+			}
+			utils.WriteErr(w, err)
+			return
+		}
+		if err := s.attachMDB(r.Context(), &snap, mdb); err != nil {
+			utils.WriteErr(w, fmt.Errorf("snapshot %q: %w", name, err))
+			return
+		}
+		data, err := json.Marshal(snap)
+		if err != nil {
+			utils.WriteErr(w, fmt.Errorf("marshal snapshot %q: %w", name, err))
+			return
+		}
+		path := filepath.Join(dir, fmt.Sprintf("%s-%d.json", name, snap.TakenAt.Unix()))
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			utils.WriteErr(w, fmt.Errorf("write snapshot: %w", err))
+			return
+		}
+		paths = append(paths, path)
+	}
+	log.Printf("[ADMIN] snapshot written: %v", paths)
+
+	out, _ := json.Marshal(map[string][]string{"snapshots": paths})
+	utils.WriteOK(w, string(out))
+}
+
+// attachMDB stores mdb in snap: inline as Base64, or in the configured blob
+// store (content-addressed ref in the file) when larger than the threshold.
+func (s *Server) attachMDB(ctx context.Context, snap *snapshotFile, mdb []byte) error {
+	cfg := s.config()
+	if cfg.BlobStore == "" {
+		snap.MDBBase64 = base64.StdEncoding.EncodeToString(mdb)
+		return nil
+	}
+	store, err := blobstore.Open(cfg.BlobStore)
+	if err != nil {
+		return err
+	}
+	ref, ok, err := blobstore.Offload(ctx, store, mdb, cfg.BlobThreshold)
+	if err != nil {
+		return fmt.Errorf("offload m_DB: %w", err)
+	}
+	if !ok {
+		snap.MDBBase64 = base64.StdEncoding.EncodeToString(mdb)
+		return nil
+	}
+	log.Printf("[ADMIN] snapshot %s: m_DB (%d bytes) → %s", snap.Dataset, ref.Size, ref.URI)
+	snap.MDBRef = &ref
+	return nil
+}
+
+func (s *Server) adminDatasets(w http.ResponseWriter, r *http.Request) {
+	out, _ := json.Marshal(map[string][]string{"datasets": s.datasetNames()})
+	utils.WriteOK(w, string(out))
+}
+
+func (s *Server) adminDatasetStats(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	ls, ok := s.dataset(name, false)
+	if !ok {
+		utils.WriteErrStatus(w, http.StatusNotFound, fmt.Errorf("unknown dataset %q", name))
+		return
+	}
+	out, err := json.Marshal(ls.stats(name))
+	if err != nil {
+		utils.WriteErr(w, fmt.Errorf("marshal stats: %w", err))
+		return
+	}
+	utils.WriteOK(w, string(out))
+}
+
+/********* PER-DATASET VIEWS ***************************************/
+
+// datasetStats is returned by GET /admin/dataset/{name}/stats.
+type datasetStats struct {
+	Name          string    `json:"name"`
+	Initialized   bool      `json:"initialized"`
+	InitializedAt time.Time `json:"initialized_at,omitempty"`
+	NRecords      int       `json:"n"`
+	RecordS       int       `json:"record_s"`
+	LogN          int       `json:"logN,omitempty"`
+	N             int       `json:"N,omitempty"`
+	Utilization   float64   `json:"slot_utilization"` // n*record_s / N
+	MDBBytes      int       `json:"m_db_bytes"`
+	Shards        int       `json:"shards,omitempty"` // m_DB plaintexts
+	Queries       uint64    `json:"pir_queries"`
+
+	Rebuild rebuildStatus        `json:"rebuild"`
+	Usage   []utils.ClientUsage  `json:"usage_by_client"` // PIR evaluation cost
+	Access  utils.AccessSnapshot `json:"access"`          // PIR queries per day, public reads per key
+}
+
+func (ls *LedgerState) stats(name string) datasetStats {
+	ls.mtx.RLock()
+	defer ls.mtx.RUnlock()
+
+	st := datasetStats{
+		Name:     name,
+		NRecords: ls.nRecords,
+		RecordS:  ls.slotsPerRec,
+		Queries:  ls.queries.Load(),
+		Rebuild:  ls.rebuild,
+		Usage:    ls.usage.Snapshot(),
+		Access:   ls.access.Snapshot(),
+	}
+	if st.Rebuild.State == "" {
+		st.Rebuild.State = "idle"
+	}
+	if ls.m_DB == nil {
+		return st
+	}
+	st.Initialized = true
+	st.InitializedAt = ls.initAt
+	st.LogN = ls.params.LogN()
+	st.N = ls.params.N()
+	st.Utilization = float64(ls.nRecords*ls.slotsPerRec) / float64(st.N)
+	st.MDBBytes = ls.mdbBytes()
+	st.Shards = len(ls.m_DB)
+	return st
+}
+
+// snapshot returns the dataset's snapshot file (without m_DB) and the
+// marshalled m_DB, which attachMDB places.
+func (ls *LedgerState) snapshot(name string) (snapshotFile, []byte, error) {
+	ls.mtx.RLock()
+	defer ls.mtx.RUnlock()
+
+	if ls.m_DB == nil {
+		return snapshotFile{}, nil, fmt.Errorf("dataset %q not initialized", name)
+	}
+	var mdb []byte
+	for k, pt := range ls.m_DB {
+		raw, err := pt.MarshalBinary()
+		if err != nil {
+			return snapshotFile{}, nil, fmt.Errorf("marshal m_DB shard %d: %w", k, err)
+		}
+		mdb = append(mdb, raw...)
+	}
+	shards := 0
+	if len(ls.m_DB) > 1 {
+		shards = len(ls.m_DB)
+	}
+	recs := make([]string, len(ls.records))
+	for i, r := range ls.records {
+		recs[i] = string(r)
+	}
+	return snapshotFile{
+		Dataset: name,
+		TakenAt: time.Now(),
+		Metadata: utils.Metadata{
+			NRecords: ls.nRecords,
+			RecordS:  ls.slotsPerRec,
+			LogN:     ls.params.LogN(),
+			N:        ls.params.N(),
+			T:        ls.params.PlaintextModulus(),
+			LogQi:    ls.params.LogQi(),
+			LogPi:    ls.params.LogPi(),
+
+			ReservedFrom: ls.reservedFrom,
+			Packing:      ls.packing,
+			SlotCount:    ls.params.MaxSlots(),
+		},
+		Records: recs,
+		Shards:  shards,
+	}, mdb, nil
+}
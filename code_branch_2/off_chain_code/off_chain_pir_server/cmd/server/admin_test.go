@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"pir_shared/utils"
+)
+
+func testServer(adminToken string) *Server {
+	s := &Server{datasets: map[string]*LedgerState{}, limiter: newRateLimiter(), access: newAccessControl()}
+	s.cfg.Store(&serverConfig{AdminToken: adminToken})
+	s.access.identities = map[string]string{"tok-alice": "alice", "tok-bob": "bob"}
+	s.access.acls["owned"] = datasetACL{Init: []string{"alice"}, Query: []string{"bob"}}
+	return s
+}
+
+func TestCheckCreate(t *testing.T) {
+	cases := []struct {
+		name    string
+		admin   string // server admin_token
+		dataset string
+		id      string
+		allowed bool
+	}{
+		{"anonymous, new name", "adm", "scratch", "", false},
+		{"anonymous, default", "adm", defaultDataset, "", false},
+		{"anonymous, default, no admin token", "", defaultDataset, "", true},
+		{"anonymous, new name, no admin token", "", "scratch", "", false},
+		{"init permission", "adm", "owned", "alice", true},
+		{"query permission only", "adm", "owned", "bob", false},
+		{"unknown identity", "adm", "owned", "mallory", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := testServer(c.admin).checkCreate(c.dataset, c.id)
+			if (err == nil) != c.allowed {
+				t.Errorf("checkCreate(%q, %q) = %v, allowed want %v", c.dataset, c.id, err, c.allowed)
+			}
+		})
+	}
+}
+
+// TestInvokeACL runs /invoke requests through the ACL checks: query and
+// init permission are separate, unknown and missing tokens count as no
+// identity, the admin token passes every check, and a named dataset
+// without an ACL is only created by the admin.
+func TestInvokeACL(t *testing.T) {
+	initArgs := []string{"4", "128", "13"}
+	cases := []struct {
+		name     string
+		token    string
+		dataset  string
+		method   string
+		existing bool // dataset exists before the call
+		status   int
+	}{
+		{"query permission queries", "tok-bob", "owned", "GetRebuildStatus", true, http.StatusOK},
+		{"init permission only cannot query", "tok-alice", "owned", "GetRebuildStatus", true, http.StatusForbidden},
+		{"query permission cannot init", "tok-bob", "owned", "InitLedger", true, http.StatusForbidden},
+		{"init permission inits", "tok-alice", "owned", "InitLedger", true, http.StatusOK},
+		{"init permission creates", "tok-alice", "owned", "InitLedger", false, http.StatusOK},
+		{"query permission cannot create", "tok-bob", "owned", "InitLedger", false, http.StatusForbidden},
+		{"unknown identity", "tok-mallory", "owned", "GetRebuildStatus", true, http.StatusForbidden},
+		{"anonymous", "", "owned", "GetRebuildStatus", true, http.StatusForbidden},
+		{"admin queries", "adm", "owned", "GetRebuildStatus", true, http.StatusOK},
+		{"admin inits", "adm", "owned", "InitLedger", true, http.StatusOK},
+		{"dataset without ACL is open", "", "open", "GetRebuildStatus", true, http.StatusOK},
+		{"no ACL, anonymous create", "", "scratch", "InitLedger", false, http.StatusForbidden},
+		{"no ACL, identity create", "tok-alice", "scratch", "InitLedger", false, http.StatusForbidden},
+		{"no ACL, admin create", "adm", "scratch", "InitLedger", false, http.StatusOK},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := testServer("adm")
+			if c.existing {
+				s.dataset(c.dataset, true)
+			}
+			req := request{Method: c.method, Dataset: c.dataset}
+			if c.method == "InitLedger" {
+				req.Args = initArgs
+			}
+			body, _ := json.Marshal(req)
+			r := httptest.NewRequest(http.MethodPost, "/invoke", bytes.NewReader(body))
+			if c.token != "" {
+				r.Header.Set("Authorization", "Bearer "+c.token)
+			}
+			w := httptest.NewRecorder()
+			s.invoke(w, r)
+
+			if w.Code != c.status {
+				t.Fatalf("%s on %q: status %d (%s), want %d", c.method, c.dataset, w.Code, strings.TrimSpace(w.Body.String()), c.status)
+			}
+			_, exists := s.dataset(c.dataset, false)
+			if c.status == http.StatusForbidden && exists != c.existing {
+				t.Errorf("refused %s left dataset %q existing=%v, want %v", c.method, c.dataset, exists, c.existing)
+			}
+			if c.method == "InitLedger" && c.status == http.StatusOK && !exists {
+				t.Errorf("InitLedger succeeded but dataset %q does not exist", c.dataset)
+			}
+		})
+	}
+}
+
+// TestAdminACLGrantsCreate checks the ACL endpoints take only the admin
+// token, and an ACL put there lets its init identity create the dataset.
+func TestAdminACLGrantsCreate(t *testing.T) {
+	s := testServer("adm")
+	mux := http.NewServeMux()
+	s.registerAdmin(mux)
+	mux.HandleFunc("POST /invoke", s.invoke)
+	call := func(method, path, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+	create := `{"method":"InitLedger","dataset":"scratch","args":["4","128","13"]}`
+	acl := `{"init":["alice"],"query":["bob"]}`
+
+	if w := call(http.MethodPost, "/invoke", "tok-alice", create); w.Code != http.StatusForbidden {
+		t.Fatalf("create without an ACL: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	for _, tok := range []string{"", "tok-alice"} {
+		if w := call(http.MethodPut, "/admin/dataset/scratch/acl", tok, acl); w.Code != http.StatusUnauthorized {
+			t.Errorf("PUT acl with token %q: status %d, want %d", tok, w.Code, http.StatusUnauthorized)
+		}
+	}
+	if w := call(http.MethodPut, "/admin/dataset/scratch/acl", "adm", acl); w.Code != http.StatusOK {
+		t.Fatalf("PUT acl as admin: status %d (%s)", w.Code, w.Body)
+	}
+	if w := call(http.MethodPost, "/invoke", "tok-alice", create); w.Code != http.StatusOK {
+		t.Fatalf("create with init permission: status %d (%s)", w.Code, w.Body)
+	}
+	query := `{"method":"GetRebuildStatus","dataset":"scratch"}`
+	if w := call(http.MethodPost, "/invoke", "tok-bob", query); w.Code != http.StatusOK {
+		t.Errorf("query with query permission: status %d (%s)", w.Code, w.Body)
+	}
+	if w := call(http.MethodDelete, "/admin/dataset/scratch/acl", "adm", ""); w.Code != http.StatusOK {
+		t.Fatalf("DELETE acl as admin: status %d (%s)", w.Code, w.Body)
+	}
+	if w := call(http.MethodPost, "/invoke", "", query); w.Code != http.StatusOK {
+		t.Errorf("anonymous query once the ACL is removed: status %d (%s)", w.Code, w.Body)
+	}
+}
+
+// TestFailedInitKeepsDatasetInProgress checks a failed init waits for an
+// init of the same dataset that is still running, and does not drop it.
+func TestFailedInitKeepsDatasetInProgress(t *testing.T) {
+	s := testServer("adm")
+	allow := func() error { return nil }
+
+	building, err := s.lockInit("scratch", allow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := make(chan *LedgerState)
+	go func() {
+		ls, _ := s.lockInit("scratch", allow)
+		s.dropIfUninitialized("scratch", ls) // its init failed
+		ls.initMu.Unlock()
+		failed <- ls
+	}()
+
+	select {
+	case <-failed:
+		t.Fatal("second init ran while the first held the dataset")
+	case <-time.After(50 * time.Millisecond):
+	}
+	building.rebuild.State = "running" // the first init is still building
+	building.initMu.Unlock()
+	if ls := <-failed; ls != building {
+		t.Error("second init got another LedgerState")
+	}
+	if ls, ok := s.dataset("scratch", false); !ok || ls != building {
+		t.Error("failed init dropped the dataset another caller is building")
+	}
+}
+
+// TestLockInitAfterDrop checks a caller waiting on a dataset that is then
+// dropped gets a fresh one in the map rather than the orphan.
+func TestLockInitAfterDrop(t *testing.T) {
+	s := testServer("adm")
+	allow := func() error { return nil }
+
+	first, _ := s.lockInit("scratch", allow)
+	got := make(chan *LedgerState)
+	go func() {
+		ls, _ := s.lockInit("scratch", allow)
+		got <- ls
+		ls.initMu.Unlock()
+	}()
+	time.Sleep(20 * time.Millisecond)
+	s.dropIfUninitialized("scratch", first)
+	first.initMu.Unlock()
+
+	ls := <-got
+	if ls == first {
+		t.Fatal("lockInit returned a dropped dataset")
+	}
+	if cur, ok := s.dataset("scratch", false); !ok || cur != ls {
+		t.Error("lockInit returned a dataset that is not in the map")
+	}
+}
+
+// TestInitDuringRebuild checks a synchronous init is refused while an
+// InitLedgerAsync rebuild runs, so the rebuild's install cannot replace
+// it, and goes through once the rebuild is done.
+func TestInitDuringRebuild(t *testing.T) {
+	ls := &LedgerState{}
+	if err := ls.startRebuild(initArgs{n: 8, maxJSON: 128, logN: 13, t: 65537, packing: utils.Packing1B}); err != nil {
+		t.Fatalf("startRebuild: %v", err)
+	}
+	sync := initArgs{n: 4, maxJSON: 128, logN: 13, t: 65537, packing: utils.Packing1B}
+	if err := ls.initLedger(sync); err == nil || !strings.Contains(err.Error(), "rebuild running") {
+		t.Errorf("InitLedger during a rebuild: %v, want it refused", err)
+	}
+
+	deadline := time.Now().Add(30 * time.Second)
+	for ls.rebuildStatus().State == "running" {
+		if time.Now().After(deadline) {
+			t.Fatal("rebuild did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := ls.rebuildStatus(); st.State != "done" {
+		t.Fatalf("rebuild %s: %s", st.State, st.Error)
+	}
+	if len(ls.records) != 8 {
+		t.Errorf("after the rebuild: %d records, want 8", len(ls.records))
+	}
+	if err := ls.initLedger(sync); err != nil {
+		t.Fatalf("InitLedger after the rebuild: %v", err)
+	}
+	if len(ls.records) != 4 {
+		t.Errorf("after InitLedger: %d records, want 4", len(ls.records))
+	}
+}
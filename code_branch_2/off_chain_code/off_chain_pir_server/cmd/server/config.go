@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"pir_shared/blobstore"
+	"pir_shared/utils"
+)
+
+/********* CONFIG **************************************************/
+
+// defaultBlobThreshold is the m_DB size above which snapshots go to the
+// blob store, when one is configured.
+const defaultBlobThreshold = 1 << 20
+
+// serverConfig is the reloadable part of the server setup. It is read from
+// a JSON file (PIR_CONFIG) and can be re-read on SIGHUP or POST
+// /admin/reload; datasets and their m_DB stay in memory across reloads.
+type serverConfig struct {
+	Addr        string `json:"addr"`         // listen address; not reloadable
+	TLSCert     string `json:"tls_cert"`     // PEM paths; both empty → plain HTTP
+	TLSKey      string `json:"tls_key"`      //
+	AdminToken  string `json:"admin_token"`  // bearer token for /admin/*
+	SnapshotDir string `json:"snapshot_dir"` // POST /admin/snapshot target
+	GRPCAddr    string `json:"grpc_addr"`    // gRPC API listen address (grpc.go); empty = off, not reloadable
+
+	// BlobStore (a blobstore.Open URI) takes snapshot m_DBs larger than
+	// BlobThreshold bytes (0 → defaultBlobThreshold); the snapshot file
+	// then keeps only the content-addressed ref. Empty keeps them inline.
+	BlobStore     string `json:"blob_store"`
+	BlobThreshold int    `json:"blob_threshold"`
+
+	RateLimitRPS float64 `json:"rate_limit_rps"` // per-caller /invoke rate; 0 = unlimited
+	RateBurst    int     `json:"rate_burst"`     // bucket size; defaults to ceil(rps)
+	Workers      int     `json:"workers"`        // concurrent PIR evaluations; 0 = unlimited
+
+	// MaxShards lets InitLedger spread a dataset over up to that many m_DB
+	// plaintexts (0 → 1), applying to the next init; EvalWorkers sizes the
+	// pool the shard and batch products run on (shards.go; 0 →
+	// GOMAXPROCS, not reloadable).
+	MaxShards   int `json:"max_shards"`
+	EvalWorkers int `json:"eval_workers"`
+
+	// ReplicaOf (a primary's base URL) starts the server as its warm
+	// standby (replica.go), pulling snapshots every ReplicaInterval seconds
+	// with ReplicaToken, the primary's admin token. Not reloadable.
+	ReplicaOf       string `json:"replica_of"`
+	ReplicaToken    string `json:"replica_token"`
+	ReplicaInterval int    `json:"replica_interval"`
+
+	// Identities seeds the ACL identity table (id → token). Entries from the
+	// file win over identities registered at runtime under the same id;
+	// an entry dropped from the file loses its token on the next reload.
+	Identities map[string]string `json:"identities"`
+}
+
+// loadConfig reads path (if non-empty) and applies the environment
+// overrides kept for backwards compatibility.
+func loadConfig(path string) (*serverConfig, error) {
+	cfg := &serverConfig{Addr: ":8080", SnapshotDir: "snapshots"}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read config: %w", err)
+		}
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("parse config %s: %w", path, err)
+		}
+	}
+	if v := os.Getenv("PIR_ADMIN_TOKEN"); v != "" {
+		cfg.AdminToken = v
+	}
+	if v := os.Getenv("PIR_SNAPSHOT_DIR"); v != "" {
+		cfg.SnapshotDir = v
+	}
+	if v := os.Getenv("PIR_BLOB_STORE"); v != "" {
+		cfg.BlobStore = v
+	}
+	if v := os.Getenv("PIR_GRPC_ADDR"); v != "" {
+		cfg.GRPCAddr = v
+	}
+	if v := os.Getenv("PIR_REPLICA_OF"); v != "" {
+		cfg.ReplicaOf = v
+	}
+	if v := os.Getenv("PIR_REPLICA_TOKEN"); v != "" {
+		cfg.ReplicaToken = v
+	}
+
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return nil, fmt.Errorf("tls_cert and tls_key must be set together")
+	}
+	if cfg.RateLimitRPS < 0 || cfg.RateBurst < 0 || cfg.Workers < 0 {
+		return nil, fmt.Errorf("rate_limit_rps, rate_burst and workers must be >= 0")
+	}
+	if cfg.MaxShards < 0 || cfg.EvalWorkers < 0 {
+		return nil, fmt.Errorf("max_shards and eval_workers must be >= 0")
+	}
+	if cfg.ReplicaInterval < 0 {
+		return nil, fmt.Errorf("replica_interval must be >= 0")
+	}
+	if cfg.ReplicaOf != "" && cfg.ReplicaToken == "" {
+		return nil, fmt.Errorf("replica_of needs replica_token (the primary's admin token)")
+	}
+	cfg.ReplicaOf = strings.TrimSuffix(cfg.ReplicaOf, "/")
+	if cfg.BlobThreshold < 0 {
+		return nil, fmt.Errorf("blob_threshold must be >= 0")
+	}
+	if cfg.BlobThreshold == 0 {
+		cfg.BlobThreshold = defaultBlobThreshold
+	}
+	if cfg.BlobStore != "" {
+		if _, err := blobstore.Open(cfg.BlobStore); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.RateLimitRPS > 0 && cfg.RateBurst == 0 {
+		cfg.RateBurst = int(math.Ceil(cfg.RateLimitRPS))
+	}
+	for id, tok := range cfg.Identities {
+		if tok == "" || tok == cfg.AdminToken {
+			return nil, fmt.Errorf("identity %q: token must be non-empty and differ from admin_token", id)
+		}
+	}
+	return cfg, nil
+}
+
+// config returns the active configuration.
+func (s *Server) config() *serverConfig { return s.cfg.Load() }
+
+// applyConfig validates and swaps in cfg: TLS certificate, limiter,
+// worker pool and identities. Nothing dataset-related is touched.
+func (s *Server) applyConfig(cfg *serverConfig) error {
+	if cfg.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return fmt.Errorf("load TLS keypair: %w", err)
+		}
+		s.cert.Store(&cert)
+	}
+	if old := s.cfg.Load(); old != nil {
+		if old.Addr != cfg.Addr {
+			log.Printf("[CONFIG] addr change %s → %s needs a restart; keeping %s", old.Addr, cfg.Addr, old.Addr)
+			cfg.Addr = old.Addr
+		}
+		if old.GRPCAddr != cfg.GRPCAddr {
+			log.Printf("[CONFIG] grpc_addr change %q → %q needs a restart; keeping %q", old.GRPCAddr, cfg.GRPCAddr, old.GRPCAddr)
+			cfg.GRPCAddr = old.GRPCAddr
+		}
+		if old.ReplicaOf != cfg.ReplicaOf {
+			log.Printf("[CONFIG] replica_of change needs a restart (or POST /admin/promote); keeping %q", old.ReplicaOf)
+			cfg.ReplicaOf = old.ReplicaOf
+		}
+		if old.EvalWorkers != cfg.EvalWorkers {
+			log.Printf("[CONFIG] eval_workers change %d → %d needs a restart; keeping %d", old.EvalWorkers, cfg.EvalWorkers, old.EvalWorkers)
+			cfg.EvalWorkers = old.EvalWorkers
+		}
+		if (old.TLSCert == "") != (cfg.TLSCert == "") {
+			return fmt.Errorf("switching between HTTP and HTTPS needs a restart")
+		}
+	}
+
+	s.limiter.configure(cfg.RateLimitRPS, cfg.RateBurst)
+	s.setWorkers(cfg.Workers)
+	maxShards.Store(int32(cfg.MaxShards))
+	s.access.seedIdentities(cfg.Identities)
+	s.cfg.Store(cfg)
+
+	log.Printf("[CONFIG] applied: tls=%v rate=%.1f/s burst=%d workers=%d max_shards=%d identities=%d",
+		cfg.TLSCert != "", cfg.RateLimitRPS, cfg.RateBurst, cfg.Workers, planOptions().MaxShards, len(cfg.Identities))
+	return nil
+}
+
+// reload re-reads the config file and applies it; a bad file keeps the
+// previous configuration.
+func (s *Server) reload() error {
+	cfg, err := loadConfig(s.cfgPath)
+	if err != nil {
+		return err
+	}
+	return s.applyConfig(cfg)
+}
+
+// watchSIGHUP reloads the configuration whenever the process gets SIGHUP.
+func (s *Server) watchSIGHUP() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			if err := s.reload(); err != nil {
+				log.Printf("[CONFIG] SIGHUP reload failed: %v", err)
+			}
+		}
+	}()
+}
+
+func (s *Server) adminReload(w http.ResponseWriter, r *http.Request) {
+	if err := s.reload(); err != nil {
+		utils.WriteErr(w, fmt.Errorf("reload: %w", err))
+		return
+	}
+	utils.WriteOK(w, "config reloaded")
+}
+
+// listenAndServe serves handler with the current config. Certificates are
+// fetched per handshake so a reload rotates them for new connections.
+func (s *Server) listenAndServe(handler http.Handler) error {
+	cfg := s.config()
+	srv := &http.Server{Addr: cfg.Addr, Handler: handler}
+	if cfg.TLSCert == "" {
+		log.Printf("REST chaincode listening on %s", cfg.Addr)
+		return srv.ListenAndServe()
+	}
+	srv.TLSConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return s.cert.Load(), nil
+		},
+	}
+	log.Printf("REST chaincode listening on %s (TLS)", cfg.Addr)
+	return srv.ListenAndServeTLS("", "")
+}
+
+/********* WORKER POOL *********************************************/
+
+// setWorkers resizes the PIR evaluation pool. In-flight evaluations keep
+// the slot of the pool they started in.
+func (s *Server) setWorkers(n int) {
+	if n == 0 {
+		s.workers.Store(nil)
+		return
+	}
+	pool := make(chan struct{}, n)
+	s.workers.Store(&pool)
+}
+
+// acquireWorker blocks until an evaluation slot is free and returns its
+// release func.
+func (s *Server) acquireWorker() func() {
+	pool := s.workers.Load()
+	if pool == nil {
+		return func() {}
+	}
+	*pool <- struct{}{}
+	return func() { <-*pool }
+}
+
+/********* RATE LIMITING *******************************************/
+
+// rateLimiter is a per-caller token bucket; callers are identities when
+// known, remote IPs otherwise.
+type rateLimiter struct {
+	mtx     sync.Mutex
+	rps     float64
+	burst   float64
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: map[string]*bucket{}}
+}
+
+func (rl *rateLimiter) configure(rps float64, burst int) {
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+	rl.rps, rl.burst = rps, float64(burst)
+	rl.buckets = map[string]*bucket{} // new limits start with full buckets
+}
+
+func (rl *rateLimiter) allow(key string) bool {
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+	if rl.rps == 0 {
+		return true
+	}
+	now := time.Now()
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &bucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = b
+	}
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rps)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// callerKey identifies r for rate limiting.
+func callerKey(id string, r *http.Request) string {
+	if id != "" {
+		return "id:" + id
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "ip:" + r.RemoteAddr
+	}
+	return "ip:" + host
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"off-chain-pir-api/pirpb"
+	"pir_shared/utils"
+)
+
+/********* gRPC API ************************************************/
+
+// The gRPC service (off_chain_pir_api/pirpb/pir.proto) serves InitLedger,
+// GetMetadata and the PIR queries next to /invoke, with ciphertexts as
+// raw protobuf bytes over HTTP/2 — the transport the Fabric gateway path
+// uses, so benchmarks compare the HE work rather than JSON and Base64.
+// It listens on grpc_addr with the same TLS certificate; the bearer token
+// goes in the "authorization" metadata, and tokens, rate limits, ACLs,
+// the worker pool and standby mode apply as on /invoke.
+
+// grpcMaxMsg bounds one unary message: a LogN=16 ct_q with a long
+// modulus chain is a few MB, past gRPC's 4 MB default. PIRQueryStream
+// has no such limit.
+const grpcMaxMsg = 64 << 20
+
+// grpcChunk is the ct_r piece size PIRQueryStream sends.
+const grpcChunk = 64 << 10
+
+type grpcServer struct {
+	pirpb.UnimplementedPIRServer
+	s *Server
+}
+
+// serveGRPC listens on cfg.GRPCAddr until the listener fails.
+func (s *Server) serveGRPC() error {
+	cfg := s.config()
+	opts := []grpc.ServerOption{grpc.MaxRecvMsgSize(grpcMaxMsg), grpc.MaxSendMsgSize(grpcMaxMsg)}
+	if cfg.TLSCert != "" {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(&tls.Config{
+			MinVersion: tls.VersionTLS12,
+			GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return s.cert.Load(), nil
+			},
+		})))
+	}
+	lis, err := net.Listen("tcp", cfg.GRPCAddr)
+	if err != nil {
+		return fmt.Errorf("gRPC listen: %w", err)
+	}
+	gs := grpc.NewServer(opts...)
+	pirpb.RegisterPIRServer(gs, &grpcServer{s: s})
+	log.Printf("gRPC PIR service listening on %s (tls=%v)", cfg.GRPCAddr, cfg.TLSCert != "")
+	return gs.Serve(lis)
+}
+
+// grpcCaller is who sent a gRPC call.
+type grpcCaller struct {
+	admin bool
+	id    string
+}
+
+// admit runs the rate limit, standby, ACL and dataset checks of /invoke
+// for one call and returns the dataset's state; with create the dataset
+// may be created (checkCreate) and comes back with its initMu held
+// (lockInit). The token and peer address go through a header-only
+// *http.Request, so callers resolve exactly as they do over REST.
+func (g *grpcServer) admit(ctx context.Context, dataset, perm string, create bool) (*LedgerState, grpcCaller, error) {
+	if dataset == "" {
+		dataset = defaultDataset
+	}
+	if !datasetNameRe.MatchString(dataset) {
+		return nil, grpcCaller{}, status.Errorf(codes.InvalidArgument, "invalid dataset name %q (want [A-Za-z0-9_-]{1,64})", dataset)
+	}
+	r := &http.Request{Header: http.Header{}}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, v := range md.Get("authorization") {
+			r.Header.Add("Authorization", v)
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+	c := grpcCaller{admin: g.s.isAdmin(r), id: g.s.access.identify(r)}
+
+	if !c.admin && !g.s.limiter.allow(callerKey(c.id, r)) {
+		return nil, c, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+	if create && g.s.isStandby() {
+		return nil, c, status.Errorf(codes.Unavailable, "standby replica of %s: POST /admin/promote first", g.s.config().ReplicaOf)
+	}
+	if !c.admin {
+		if err := g.s.access.check(dataset, c.id, perm); err != nil {
+			return nil, c, status.Error(codes.PermissionDenied, err.Error())
+		}
+	}
+	if create {
+		ls, err := g.s.lockInit(dataset, func() error {
+			if c.admin {
+				return nil
+			}
+			return g.s.checkCreate(dataset, c.id)
+		})
+		if err != nil {
+			return nil, c, status.Error(codes.PermissionDenied, err.Error())
+		}
+		return ls, c, nil
+	}
+	ls, ok := g.s.dataset(dataset, false)
+	if !ok {
+		return nil, c, status.Errorf(codes.NotFound, "unknown dataset %q", dataset)
+	}
+	return ls, c, nil
+}
+
+func (g *grpcServer) InitLedger(ctx context.Context, req *pirpb.InitLedgerRequest) (*pirpb.InitLedgerResponse, error) {
+	ls, _, err := g.admit(ctx, req.Dataset, permInit, true)
+	if err != nil {
+		return nil, err
+	}
+	name := req.Dataset
+	if name == "" {
+		name = defaultDataset
+	}
+	defer ls.initMu.Unlock()
+	defer g.s.dropIfUninitialized(name, ls)
+
+	// same validation and defaults as the /invoke arguments
+	logQi, _ := json.Marshal(req.LogQi)
+	logPi, _ := json.Marshal(req.LogPi)
+	a := []string{strconv.Itoa(int(req.NumRecords)), strconv.Itoa(int(req.MaxJsonLength)), "", "", "", "", strconv.Itoa(int(req.Reserve))}
+	if req.LogN > 0 {
+		a[2] = strconv.Itoa(int(req.LogN))
+	}
+	if len(req.LogQi) > 0 {
+		a[3] = string(logQi)
+	}
+	if len(req.LogPi) > 0 {
+		a[4] = string(logPi)
+	}
+	if req.T > 0 {
+		a[5] = strconv.FormatUint(req.T, 10)
+	}
+	args, err := parseInitArgs(a)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := ls.initLedger(args); err != nil {
+		log.Printf("[ERROR] gRPC InitLedger: %v", err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	ls.mtx.RLock()
+	defer ls.mtx.RUnlock()
+	return &pirpb.InitLedgerResponse{
+		NRecords: int32(ls.nRecords),
+		RecordS:  int32(ls.slotsPerRec),
+		LogN:     int32(ls.params.LogN()),
+	}, nil
+}
+
+func (g *grpcServer) GetMetadata(ctx context.Context, req *pirpb.GetMetadataRequest) (*pirpb.Metadata, error) {
+	ls, _, err := g.admit(ctx, req.Dataset, permQuery, false)
+	if err != nil {
+		return nil, err
+	}
+	ls.mtx.RLock()
+	defer ls.mtx.RUnlock()
+	if ls.m_DB == nil {
+		return nil, status.Error(codes.FailedPrecondition, "PIR database not initialized")
+	}
+	if ls.packing != utils.Packing1B {
+		// pirpb.Metadata has no packing field to decode the results with
+		return nil, status.Errorf(codes.FailedPrecondition, "dataset is packed %s: use GetMetadata on /invoke", ls.packing)
+	}
+	return &pirpb.Metadata{
+		N:            int32(ls.nRecords),
+		RecordS:      int32(ls.slotsPerRec),
+		LogN:         int32(ls.params.LogN()),
+		RingN:        int32(ls.params.N()),
+		T:            ls.params.PlaintextModulus(),
+		LogQi:        int32s(ls.params.LogQi()),
+		LogPi:        int32s(ls.params.LogPi()),
+		ReservedFrom: int32(ls.reservedFrom),
+	}, nil
+}
+
+func (g *grpcServer) PIRQuery(ctx context.Context, req *pirpb.PIRQueryRequest) (*pirpb.PIRQueryResponse, error) {
+	ctR, _, err := g.query(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &pirpb.PIRQueryResponse{CtR: ctR}, nil
+}
+
+func (g *grpcServer) PIRQueryTimed(ctx context.Context, req *pirpb.PIRQueryRequest) (*pirpb.PIRQueryTimedResponse, error) {
+	ctR, usage, err := g.query(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &pirpb.PIRQueryTimedResponse{CtR: ctR, EvalMs: usage.WallMS, CpuMs: usage.CPUMS, AllocBytes: usage.AllocBytes}, nil
+}
+
+func (g *grpcServer) query(ctx context.Context, req *pirpb.PIRQueryRequest) ([]byte, utils.EvalUsage, error) {
+	ls, c, err := g.admit(ctx, req.Dataset, permQuery, false)
+	if err != nil {
+		return nil, utils.EvalUsage{}, err
+	}
+	defer g.s.acquireWorker()()
+
+	ls.mtx.RLock()
+	defer ls.mtx.RUnlock()
+	ctRes, usage, err := ls.evalRaw(c.id, bytes.NewReader(req.CtQ))
+	if err != nil {
+		return nil, usage, err
+	}
+	out, err := ctRes.MarshalBinary()
+	if err != nil {
+		return nil, usage, status.Errorf(codes.Internal, "failed to marshal result ciphertext: %v", err)
+	}
+	log.Printf("[EVAL_GRPC] %d bytes in, %d bytes out, eval %.3f ms (LogN=%d)",
+		len(req.CtQ), len(out), usage.WallMS, ls.params.LogN())
+	return out, usage, nil
+}
+
+func (g *grpcServer) PIRQueryStream(stream pirpb.PIR_PIRQueryStreamServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	ls, c, err := g.admit(stream.Context(), first.Dataset, permQuery, false)
+	if err != nil {
+		return err
+	}
+	defer g.s.acquireWorker()()
+
+	// feed the chunks to ReadQuery as one byte stream
+	pr, pw := io.Pipe()
+	go func() {
+		if _, err := pw.Write(first.Data); err != nil {
+			return
+		}
+		for {
+			chunk, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				pw.Close()
+				return
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := pw.Write(chunk.Data); err != nil {
+				return
+			}
+		}
+	}()
+	defer pr.Close()
+
+	ls.mtx.RLock()
+	defer ls.mtx.RUnlock()
+	ctRes, usage, err := ls.evalRaw(c.id, pr)
+	if err != nil {
+		return err
+	}
+	cw := &chunkSender{stream: stream, evalMS: usage.WallMS}
+	bw := bufio.NewWriterSize(cw, grpcChunk)
+	if _, err := ctRes.WriteTo(bw); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// evalRaw reads a raw ct_q from r and multiplies it with m_DB; ls.mtx
+// must be read-locked.
+func (ls *LedgerState) evalRaw(caller string, r io.Reader) (*rlwe.Ciphertext, utils.EvalUsage, error) {
+	ls.queries.Add(1)
+	if ls.m_DB == nil {
+		return nil, utils.EvalUsage{}, status.Error(codes.FailedPrecondition, "PIR database not initialized")
+	}
+	// panic-safe, shape-checked
+	ctQuery, _, err := utils.ReadQuery(ls.params, r)
+	if err != nil {
+		return nil, utils.EvalUsage{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if len(ls.m_DB) > 1 {
+		return nil, utils.EvalUsage{}, status.Errorf(codes.FailedPrecondition,
+			"dataset is sharded over %d plaintexts: use PIRQuery on /invoke", len(ls.m_DB))
+	}
+	ctRes, usage, err := ls.evalSingle(ctQuery)
+	if err != nil {
+		return nil, usage, status.Errorf(codes.Internal, "PIR evaluation failed: %v", err)
+	}
+	ls.usage.Add(caller, usage)
+	ls.access.AddQuery(time.Now())
+	return ctRes, usage, nil
+}
+
+// chunkSender is the io.Writer PIRQueryStream serialises ct_r into; each
+// Write is one ResultChunk, the first carrying eval_ms.
+type chunkSender struct {
+	stream pirpb.PIR_PIRQueryStreamServer
+	evalMS float64
+	sent   bool
+}
+
+func (c *chunkSender) Write(p []byte) (int, error) {
+	chunk := &pirpb.ResultChunk{Data: p}
+	if !c.sent {
+		chunk.EvalMs, c.sent = c.evalMS, true
+	}
+	if err := c.stream.Send(chunk); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func int32s(v []int) []int32 {
+	out := make([]int32, len(v))
+	for i, x := range v {
+		out[i] = int32(x)
+	}
+	return out
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
+
+	"pir_shared/utils"
+)
+
+/********* CHAINCODE EMULATION *************************************/
+
+// The chaincode functions an experiment uses besides the queries, under
+// the same names, arguments and response shapes, so a client written
+// against this server runs unchanged on the peer:
+//
+//	PIRQuerySubmit, PublicQuerySubmit,     audit records, kept in memory
+//	GetAuditRecord, GetAuditPayload        (the last maxAuditRecords)
+//	GetHistoryForKey, GetHistoryPage       one entry per install of the last historyDepth datasets
+//	GetStorageReport                       the keys the chaincode would hold
+//	PIRQueryAuto                           a ct_q encrypted here once per install
+//
+// Every install (InitLedger, LoadRecordsFromJSON, InitLedgerAsync, a
+// replicated snapshot) stands for one transaction with its own tx id and
+// bumps m_DB_version. Nothing here is persisted: a restart begins a new
+// history and audit log.
+
+const (
+	maxAuditRecords = 4096
+	historyDepth    = 8
+	historyLimit    = 100
+	historyMaxValue = 4096
+)
+
+// install is one installed dataset as the ledger history sees it: the
+// world-state keys it wrote, without m_DB itself.
+type install struct {
+	txID         string
+	at           time.Time
+	nRecords     int
+	slotsPerRec  int
+	reservedFrom int
+	records      [][]byte // shared with the dbState, never modified
+	mdbBytes     int
+	digest       string
+}
+
+func newInstall(st *dbState, txID string, at time.Time) install {
+	return install{txID: txID, at: at, nRecords: st.nRecords, slotsPerRec: st.slotsPerRec,
+		reservedFrom: st.reservedFrom, records: st.records, mdbBytes: st.mdbBytes(), digest: st.digest}
+}
+
+// newTxID returns a Fabric-style tx id: 64 random hex digits.
+func newTxID() string {
+	var b [32]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// mdbDigest is the chaincode's m_DB_sha256 of st: the SHA-256 over the
+// marshalled shards in order.
+func mdbDigest(st *dbState) (string, error) {
+	shards := make([][]byte, len(st.m_DB))
+	for k, pt := range st.m_DB {
+		raw, err := pt.MarshalBinary()
+		if err != nil {
+			return "", fmt.Errorf("marshal m_DB shard %d: %w", k, err)
+		}
+		shards[k] = raw
+	}
+	return utils.MDBHash(shards), nil
+}
+
+// recordInstall adds st to the history; called by install under ls.mtx.
+func (ls *LedgerState) recordInstall(st *dbState, at time.Time) {
+	ls.version++
+	ls.history = append(ls.history, newInstall(st, newTxID(), at))
+	if len(ls.history) > historyDepth {
+		ls.history = ls.history[len(ls.history)-historyDepth:]
+	}
+	ls.autoQuery = ""
+}
+
+/********* AUDIT ***************************************************/
+
+// auditRecord has the JSON fields of the chaincode's AuditRecord that an
+// off-chain query can fill; the payload is always inline.
+type auditRecord struct {
+	TxID        string `json:"tx_id"`
+	Fn          string `json:"fn"`
+	Key         string `json:"key,omitempty"`
+	ClientMSP   string `json:"client_msp"`
+	ClientID    string `json:"client_id"`
+	Timestamp   int64  `json:"timestamp"`
+	QuerySHA256 string `json:"query_sha256,omitempty"`
+	QueryBytes  int    `json:"query_bytes,omitempty"`
+	MDBSHA256   string `json:"mdb_sha256"`
+	MDBVersion  int    `json:"mdb_version"`
+	Payload     string `json:"payload,omitempty"`
+}
+
+// offChainMSP stands in for the caller's MSP ID, which only a peer knows.
+const offChainMSP = "offchain"
+
+// newAudit is an audit record of fn by caller against the live dataset;
+// ls.mtx must be held.
+func (ls *LedgerState) newAudit(fn, caller string, at time.Time) auditRecord {
+	return auditRecord{
+		TxID:       newTxID(),
+		Fn:         fn,
+		ClientMSP:  offChainMSP,
+		ClientID:   caller,
+		Timestamp:  at.Unix(),
+		MDBSHA256:  ls.digest,
+		MDBVersion: ls.version,
+	}
+}
+
+// putAudit keeps rec, dropping the oldest record past maxAuditRecords.
+func (ls *LedgerState) putAudit(rec auditRecord) {
+	ls.auditMu.Lock()
+	defer ls.auditMu.Unlock()
+	if ls.audits == nil {
+		ls.audits = map[string]auditRecord{}
+	}
+	ls.audits[rec.TxID] = rec
+	ls.auditOrder = append(ls.auditOrder, rec.TxID)
+	if len(ls.auditOrder) > maxAuditRecords {
+		delete(ls.audits, ls.auditOrder[0])
+		ls.auditOrder = ls.auditOrder[1:]
+	}
+}
+
+func (ls *LedgerState) loadAudit(txID string) (auditRecord, error) {
+	ls.auditMu.Lock()
+	defer ls.auditMu.Unlock()
+	rec, ok := ls.audits[txID]
+	if !ok {
+		return rec, fmt.Errorf("no audit record for tx %s (this server keeps the last %d)", txID, maxAuditRecords)
+	}
+	return rec, nil
+}
+
+// pirQuerySubmit is PIRQuery plus the audit record; like the chaincode's
+// it returns ct_r.
+func (ls *LedgerState) pirQuerySubmit(caller, encQueryB64 string) (string, error) {
+	out, err := ls.pirQuery(caller, encQueryB64)
+	if err != nil {
+		return "", fmt.Errorf("PIRQuerySubmit: %w", err)
+	}
+	ls.mtx.RLock()
+	rec := ls.newAudit("PIRQuerySubmit", caller, time.Now())
+	ls.mtx.RUnlock()
+	rec.QuerySHA256 = utils.RecordHash([]byte(encQueryB64))
+	rec.QueryBytes = len(encQueryB64)
+	rec.Payload = encQueryB64
+	ls.putAudit(rec)
+	return out, nil
+}
+
+// publicQuerySubmit is PublicQuery plus the audit record; it returns a
+// utils.PublicReceipt.
+func (ls *LedgerState) publicQuerySubmit(caller, key string) (string, error) {
+	ls.mtx.RLock()
+	rec, err := ls.readPublic(key)
+	now := time.Now()
+	audit := ls.newAudit("PublicQuerySubmit", caller, now)
+	ls.mtx.RUnlock()
+	if err != nil {
+		return "", fmt.Errorf("PublicQuerySubmit: %w", err)
+	}
+	audit.Key = key
+	ls.putAudit(audit)
+
+	receipt := utils.PublicReceipt{
+		Record:    json.RawMessage(rec),
+		AuditKey:  "audit:public:" + audit.TxID,
+		TxID:      audit.TxID,
+		Timestamp: now.UTC().Format(time.RFC3339Nano),
+	}
+	if !json.Valid(receipt.Record) {
+		receipt.Record, _ = json.Marshal(rec)
+	}
+	out, _ := json.Marshal(receipt)
+	return string(out), nil
+}
+
+// getAuditPayload mirrors the chaincode's AuditPayload.
+func (ls *LedgerState) getAuditPayload(txID string) (string, error) {
+	start := time.Now()
+	rec, err := ls.loadAudit(txID)
+	if err != nil {
+		return "", fmt.Errorf("GetAuditPayload: %w", err)
+	}
+	return utils.MarshalTimed(struct {
+		TxID        string `json:"tx_id"`
+		EncQueryB64 string `json:"enc_query_b64"`
+		Verified    bool   `json:"verified"`
+	}{txID, rec.Payload, utils.RecordHash([]byte(rec.Payload)) == rec.QuerySHA256 && len(rec.Payload) == rec.QueryBytes}, start)
+}
+
+/********* KEY HISTORY *********************************************/
+
+// keyValue is what st stores under key (a params or record key), and
+// whether it stores it at all.
+func keyValue(in install, key string) ([]byte, bool) {
+	switch key {
+	case "n":
+		return []byte(strconv.Itoa(in.nRecords)), true
+	case "record_s":
+		return []byte(strconv.Itoa(in.slotsPerRec)), true
+	case "reserved_from":
+		return []byte(strconv.Itoa(in.reservedFrom)), in.reservedFrom > 0
+	}
+	ic := utils.IndexContract{NRecords: in.nRecords, RecordS: in.slotsPerRec, ReservedFrom: in.reservedFrom}
+	if idx, err := ic.Index(key); err == nil && idx < len(in.records) {
+		return in.records[idx], true
+	}
+	return nil, false
+}
+
+// historyPage is GetHistoryPage over the installs: newest first, an entry
+// where key's value was written (or, for a key the previous install held,
+// deleted).
+func (ls *LedgerState) historyPage(key string, limit int, resume string, maxValue int, ndjson bool) (string, error) {
+	ls.mtx.RLock()
+	installs := append([]install(nil), ls.history...)
+	ls.mtx.RUnlock()
+
+	var entries []utils.HistoryEntry
+	for i := len(installs) - 1; i >= 0; i-- {
+		in := installs[i]
+		// m_DB's value is withheld, as for the chaincode's internal keys;
+		// its SHA-256 is m_DB_sha256 for a single shard
+		if key == "m_DB" {
+			e := utils.HistoryEntry{TxID: in.txID, Timestamp: in.at.UTC().Format(time.RFC3339),
+				ValueLength: in.mdbBytes, ValueSHA256: in.digest, Truncated: true}
+			entries = append(entries, e)
+			continue
+		}
+		value, ok := keyValue(in, key)
+		switch {
+		case ok:
+			entries = append(entries, utils.NewHistoryEntry(in.txID, false, in.at, value, maxValue))
+		case i > 0:
+			if _, was := keyValue(installs[i-1], key); was {
+				entries = append(entries, utils.NewHistoryEntry(in.txID, true, in.at, nil, maxValue))
+			}
+		}
+	}
+
+	page := utils.HistoryPage{Key: key, Entries: []utils.HistoryEntry{}}
+	if resume != "" {
+		i := 0
+		for i < len(entries) && entries[i].TxID != resume {
+			i++
+		}
+		if i == len(entries) {
+			return "", fmt.Errorf("resume token %q is not in the history of %s", resume, key)
+		}
+		entries = entries[i+1:]
+	}
+	if len(entries) > limit {
+		entries, page.More = entries[:limit], true
+		page.Next = entries[limit-1].TxID
+	}
+	if !ndjson {
+		page.Entries = append(page.Entries, entries...)
+		raw, err := json.Marshal(page)
+		if err != nil {
+			return "", fmt.Errorf("error marshaling history: %v", err)
+		}
+		return string(raw), nil
+	}
+	var out bytes.Buffer
+	for _, e := range entries {
+		line, _ := json.Marshal(e)
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	page.Entries = nil
+	trailer, _ := json.Marshal(page)
+	out.Write(trailer)
+	return out.String(), nil
+}
+
+// getHistoryPage parses GetHistoryPage's arguments as the chaincode does.
+func (ls *LedgerState) getHistoryPage(args []string) (string, error) {
+	if len(args) != 5 {
+		return "", fmt.Errorf("GetHistoryPage: need key, limit, resume, maxValue, format")
+	}
+	key, limitStr, resume, maxValueStr, format := args[0], args[1], args[2], args[3], args[4]
+	limit, maxValue := historyLimit, historyMaxValue
+	var err error
+	if limitStr != "" {
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit <= 0 {
+			return "", fmt.Errorf("GetHistoryPage: limit must be a positive integer, got %q", limitStr)
+		}
+	}
+	if maxValueStr != "" {
+		if maxValue, err = strconv.Atoi(maxValueStr); err != nil || maxValue < 0 {
+			return "", fmt.Errorf("GetHistoryPage: max value must be a non-negative integer, got %q", maxValueStr)
+		}
+	}
+	switch format {
+	case "", "json":
+		return ls.historyPage(key, limit, resume, maxValue, false)
+	case "ndjson":
+		return ls.historyPage(key, limit, resume, maxValue, true)
+	}
+	return "", fmt.Errorf("GetHistoryPage: unknown format %q (json, ndjson)", format)
+}
+
+/********* STORAGE REPORT ******************************************/
+
+// storageFamily and storageReport have the JSON shape of the chaincode's
+// GetStorageReport; only the families this server has keys for are
+// non-empty.
+type storageFamily struct {
+	Family     string `json:"family"`
+	Internal   bool   `json:"internal"`
+	Keys       int    `json:"keys"`
+	KeyBytes   int    `json:"key_bytes"`
+	ValueBytes int    `json:"value_bytes"`
+}
+
+type storageReport struct {
+	Families   []storageFamily `json:"families"`
+	Keys       int             `json:"keys"`
+	TotalBytes int             `json:"total_bytes"`
+}
+
+func (r *storageReport) add(f *storageFamily, key string, valueBytes int) {
+	f.Keys++
+	f.KeyBytes += len(key)
+	f.ValueBytes += valueBytes
+	r.Keys++
+	r.TotalBytes += len(key) + valueBytes
+}
+
+// storageReport counts the keys the chaincode would hold for the live
+// dataset and the audit log.
+func (ls *LedgerState) storageReport() (string, error) {
+	start := time.Now()
+	ls.mtx.RLock()
+	if ls.m_DB == nil {
+		ls.mtx.RUnlock()
+		return "", fmt.Errorf("GetStorageReport: m_DB not initialized")
+	}
+	var r storageReport
+	params := storageFamily{Family: "params"}
+	mdb := storageFamily{Family: "m_DB", Internal: true}
+	records := storageFamily{Family: "records"}
+	audit := storageFamily{Family: "audit", Internal: true}
+	live := newInstall(&ls.dbState, "", ls.initAt)
+	for _, key := range []string{"n", "record_s", "reserved_from"} {
+		if v, ok := keyValue(live, key); ok {
+			r.add(&params, key, len(v))
+		}
+	}
+	for k, pt := range ls.m_DB {
+		key := "m_DB"
+		if k > 0 {
+			key = fmt.Sprintf("m_DB_%03d", k)
+		}
+		r.add(&mdb, key, pt.BinarySize())
+	}
+	if len(ls.m_DB) > 1 {
+		r.add(&mdb, "m_DB_shards", len(strconv.Itoa(len(ls.m_DB))))
+	}
+	r.add(&mdb, "m_DB_sha256", len(ls.digest))
+	r.add(&mdb, "m_DB_version", len(strconv.Itoa(ls.version)))
+	for i, rec := range ls.records {
+		r.add(&records, utils.RecordKey(i), len(rec))
+	}
+	ls.mtx.RUnlock()
+
+	ls.auditMu.Lock()
+	for _, id := range ls.auditOrder {
+		raw, _ := json.Marshal(ls.audits[id])
+		r.add(&audit, "audit:"+id, len(raw))
+	}
+	ls.auditMu.Unlock()
+
+	r.Families = []storageFamily{params, mdb, records, audit}
+	return utils.MarshalTimed(r, start)
+}
+
+/********* PIR QUERY AUTO ******************************************/
+
+// pirQueryAuto evaluates a ct_q of record 0, which the chaincode bakes in
+// per LogN. Here it is encrypted under a throw-away key on the first call
+// after each install and reused until the next.
+func (ls *LedgerState) pirQueryAuto(caller string) (string, error) {
+	start := time.Now()
+	ls.mtx.Lock()
+	if ls.m_DB == nil {
+		ls.mtx.Unlock()
+		return "", fmt.Errorf("[PIR_AUTO]: not initialized - call InitLedger first")
+	}
+	if ls.autoQuery == "" {
+		q, err := encryptSelector(ls.params, ls.contract(), 0)
+		if err != nil {
+			ls.mtx.Unlock()
+			return "", fmt.Errorf("[PIR_AUTO]: %w", err)
+		}
+		ls.autoQuery = q
+	}
+	q := ls.autoQuery
+	ls.mtx.Unlock()
+
+	ls.mtx.RLock()
+	ctQuery, _, err := utils.DecodeQuery(ls.params, q)
+	if err != nil {
+		ls.mtx.RUnlock()
+		return "", fmt.Errorf("[PIR_AUTO]: %w", err)
+	}
+	res, usage, err := ls.evalShards([]*rlwe.Ciphertext{ctQuery})
+	if err == nil {
+		ls.queries.Add(1)
+		ls.usage.Add(caller, usage)
+		ls.access.AddQuery(time.Now())
+	}
+	ls.mtx.RUnlock()
+	if err != nil {
+		return "", fmt.Errorf("[PIR_AUTO]: PIR evaluation failed: %w", err)
+	}
+	out, err := encodeShards(res[0], usage.Shards)
+	if err != nil {
+		return "", fmt.Errorf("[PIR_AUTO]: %w", err)
+	}
+	return utils.MarshalTimedUsage(out, start, &usage)
+}
+
+// encryptSelector is a Base64 ct_q of index under ic, encrypted under a
+// fresh key pair of params.
+func encryptSelector(params bgv.Parameters, ic utils.IndexContract, index int) (string, error) {
+	sel, err := ic.Selector(index)
+	if err != nil {
+		return "", err
+	}
+	pt := bgv.NewPlaintext(params, params.MaxLevel())
+	if err := bgv.NewEncoder(params).Encode(sel, pt); err != nil {
+		return "", err
+	}
+	_, pk := rlwe.NewKeyGenerator(params).GenKeyPairNew()
+	ct, err := rlwe.NewEncryptor(params, pk).EncryptNew(pt)
+	if err != nil {
+		return "", err
+	}
+	raw, err := ct.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+/********* PUBLIC READ *********************************************/
+
+// errNotInitialized is a read before the first install.
+var errNotInitialized = errors.New("m_DB not initialized")
+
+// readPublic is record key for PublicQuery and PublicQuerySubmit; ls.mtx
+// must be held. Errors wrap errNotInitialized, utils.ErrInvalidKey or
+// utils.ErrRecordNotFound.
+func (ls *LedgerState) readPublic(key string) (string, error) {
+	if ls.m_DB == nil {
+		return "", errNotInitialized
+	}
+	ic := utils.IndexContract{NRecords: ls.nRecords, RecordS: ls.slotsPerRec, ReservedFrom: ls.reservedFrom}
+	idx, err := ic.Index(key)
+	if err != nil {
+		return "", err
+	}
+	ls.access.AddPublicRead(key, time.Now())
+	if ic.IsReserved(idx) {
+		return utils.EmptyRecord, nil
+	}
+	if idx >= len(ls.records) {
+		return "", fmt.Errorf("%w: %s", utils.ErrRecordNotFound, key)
+	}
+	return string(ls.records[idx]), nil
+}
@@ -1,20 +1,23 @@
 package main
 
 import (
-	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tuneinsight/lattigo/v6/core/rlwe"
 	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
 
-	"off-chain-pir-server/internal/gen_records"
-	"off-chain-pir-server/internal/utils"
+	"pir_shared/gen_records"
+	"pir_shared/utils"
 )
 
 /********* МОДЕЛИ *************************************************/
@@ -24,97 +27,238 @@ type pirTimedResp struct {
 	B64    string  `json:"b64"`
 }
 
+// capabilities is what GetCapabilities advertises for this server.
+func capabilities() utils.Capabilities {
+	feats, shards := utils.FeatReserved|utils.FeatBatchQuery, planOptions().MaxShards
+	if shards > 1 {
+		feats |= utils.FeatShards
+	}
+	c := utils.NewCapabilities(feats|utils.FeatPacking2B, utils.PackingModes, shards, []int{13, 14, 15, 16})
+	c.HE = "lattigo/v6" // m_DB and ct_q are Lattigo v6 BGV objects
+	return c
+}
+
 type request struct {
-	Method string   `json:"method"`
-	Args   []string `json:"args"`
+	Method  string   `json:"method"`
+	Args    []string `json:"args"`
+	Dataset string   `json:"dataset,omitempty"` // empty → defaultDataset
+
+	caller string // resolved client identity ("" = anonymous), for accounting
 }
 
 /********* Ledger's World State ***********************/
-type LedgerState struct {
-	mtx sync.RWMutex
+// dbState is one built database. initLedger builds a new one off-lock and
+// swaps it into LedgerState in a single assignment.
+type dbState struct {
 	// Cryptographic context
-	params bgv.Parameters  // in-memory BGV params
-	m_DB   *rlwe.Plaintext // in-memory plaintext poly
+	params bgv.Parameters       // in-memory BGV params
+	m_DB   []*rlwe.Plaintext    // in-memory plaintext polys, one per shard
+	evals  *utils.EvaluatorPool // evaluators of params, reused across queries
 
 	// Database meta
-	nRecords    int      // world state: "n"
-	slotsPerRec int      // world state: "record_s"
-	records     [][]byte // world state: "record%03d" keys
+	nRecords     int      // world state: "n", reserved indices included
+	slotsPerRec  int      // world state: "record_s"
+	reservedFrom int      // world state: "reserved_from" (0 = none)
+	packing      string   // "packing": utils.Packing1B or utils.Packing2B
+	records      [][]byte // world state: "record%03d" keys, live indices only
+	digest       string   // world state: "m_DB_sha256", set by install
+}
+
+// contract is the IndexContract of st.
+func (st *dbState) contract() utils.IndexContract {
+	return utils.IndexContract{NRecords: st.nRecords, RecordS: st.slotsPerRec, Slots: st.params.MaxSlots(), ReservedFrom: st.reservedFrom, Packing: st.packing}
+}
+
+type LedgerState struct {
+	mtx    sync.RWMutex
+	initMu sync.Mutex // held by synchronous inits (Server.lockInit)
+	dbState
+
+	// Operational counters (admin stats)
+	initAt  time.Time
+	queries atomic.Uint64
+	usage   utils.UsageTotals // PIR evaluation cost per client identity
+	access  utils.AccessStats // PIR queries per day, public reads per key
+
+	rebuild rebuildStatus // last InitLedgerAsync run
+
+	// Chaincode emulation (ledger.go)
+	version    int       // m_DB_version: installs so far
+	history    []install // oldest first, at most historyDepth
+	autoQuery  string    // PIRQueryAuto's ct_q for the live dataset
+	auditMu    sync.Mutex
+	audits     map[string]auditRecord // by tx id
+	auditOrder []string               // tx ids, oldest first
 }
 
 /********* ХЭНДЛЕР INVOKE ******************************************/
-func (ls *LedgerState) invoke(w http.ResponseWriter, r *http.Request) {
+func (s *Server) invoke(w http.ResponseWriter, r *http.Request) {
 	var req request
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		utils.WriteErr(w, err)
 		return
 	}
+	if req.Dataset == "" {
+		req.Dataset = defaultDataset
+	}
+	if !datasetNameRe.MatchString(req.Dataset) {
+		utils.WriteErr(w, fmt.Errorf("invalid dataset name %q (want [A-Za-z0-9_-]{1,64})", req.Dataset))
+		return
+	}
 
-	switch req.Method {
-	case "InitLedger":
-		if len(req.Args) < 2 {
-			utils.WriteErr(w, fmt.Errorf("InitLedger requires at least 2 arguments: numRecords, maxJsonLength; optionally: logN, logQi(json), logPi(json), t"))
-			return
-		}
+	creates := req.Method == "InitLedger" || req.Method == "InitLedgerAsync" || req.Method == "LoadRecordsFromJSON"
+	admin, id := s.isAdmin(r), s.access.identify(r)
+	req.caller = id
+	if !admin && !s.limiter.allow(callerKey(id, r)) {
+		utils.WriteErrStatus(w, http.StatusTooManyRequests, fmt.Errorf("rate limit exceeded"))
+		return
+	}
 
-		n, err1 := strconv.Atoi(req.Args[0])
-		maxJSON, err2 := strconv.Atoi(req.Args[1])
-		if err1 != nil || err2 != nil || n <= 0 || maxJSON <= 0 {
-			utils.WriteErr(w, fmt.Errorf("numRecords and maxJsonLength must be positive integers"))
+	// A warm standby takes its datasets from the primary only.
+	if creates && s.isStandby() {
+		utils.WriteErrStatus(w, http.StatusServiceUnavailable, fmt.Errorf("standby replica of %s: POST /admin/promote first", s.config().ReplicaOf))
+		return
+	}
+
+	// Per-dataset ACLs; the admin token passes every check.
+	if req.Method != "GetCapabilities" && req.Method != "Probe" && !admin {
+		perm := permQuery
+		if creates {
+			perm = permInit
+		}
+		if err := s.access.check(req.Dataset, id, perm); err != nil {
+			utils.WriteErrStatus(w, http.StatusForbidden, err)
 			return
 		}
+	}
 
-		// optional: logN (empty/0 means: auto-select)
-		var logN int
-		if len(req.Args) >= 3 && req.Args[2] != "" {
-			if v, err := strconv.Atoi(req.Args[2]); err == nil {
-				logN = v
+	// InitLedger* and LoadRecordsFromJSON may create a dataset (checkCreate)
+	// and run one at a time per dataset; every other method needs an
+	// existing one.
+	var ls *LedgerState
+	ok := true
+	if creates {
+		var err error
+		ls, err = s.lockInit(req.Dataset, func() error {
+			if admin {
+				return nil
 			}
+			return s.checkCreate(req.Dataset, id)
+		})
+		if err != nil {
+			utils.WriteErrStatus(w, http.StatusForbidden, err)
+			return
 		}
+		defer ls.initMu.Unlock()
+	} else {
+		ls, ok = s.dataset(req.Dataset, false)
+	}
+	if !ok && (req.Method == "GetCapabilities" || req.Method == "Probe") {
+		ls, ok = &LedgerState{}, true // answered before the first InitLedger too, without a params hash
+	}
+	if !ok {
+		utils.WriteErr(w, fmt.Errorf("unknown dataset %q", req.Dataset))
+		return
+	}
+	switch req.Method {
+	case "PIRQuery", "PIRQueryTimed", "PIRQueryBatch", "PIRQuerySubmit", "PIRQueryAuto":
+		defer s.acquireWorker()()
+	}
+	ls.dispatch(w, req)
+	if creates {
+		s.dropIfUninitialized(req.Dataset, ls) // failed init must not leave an empty dataset behind
+	}
+}
 
-		// optional: logQi, logPi as JSON arrays of ints
-		var logQi, logPi []int
-		if len(req.Args) >= 4 && req.Args[3] != "" {
-			if err := json.Unmarshal([]byte(req.Args[3]), &logQi); err != nil {
-				utils.WriteErr(w, fmt.Errorf("invalid logQi JSON: %w", err))
-				return
-			}
+func (ls *LedgerState) dispatch(w http.ResponseWriter, req request) {
+	switch req.Method {
+	case "InitLedger":
+		args, err := parseInitArgs(req.Args)
+		if err != nil {
+			utils.WriteErr(w, err)
+			return
 		}
-		if len(req.Args) >= 5 && req.Args[4] != "" {
-			if err := json.Unmarshal([]byte(req.Args[4]), &logPi); err != nil {
-				utils.WriteErr(w, fmt.Errorf("invalid logPi JSON: %w", err))
-				return
-			}
+		if err := ls.initLedger(args); err != nil {
+			log.Printf("[ERROR] InitLedger: %v", err)
+			utils.WriteErr(w, err)
+			return
 		}
 
-		// optional: t (plaintext modulus)
-		var t uint64 = 65537
-		if len(req.Args) >= 6 && req.Args[5] != "" {
-			if parsedT, err := strconv.ParseUint(req.Args[5], 10, 64); err == nil && parsedT > 0 {
-				t = parsedT
-			}
+		utils.WriteOK(w, fmt.Sprintf(
+			"ledger initialized with %d records (%d reserved), LogN=%d, slotsPerRec=%d",
+			len(ls.records), ls.nRecords-len(ls.records), ls.params.LogN(), ls.slotsPerRec,
+		))
+
+	case "LoadRecordsFromJSON":
+		// records(json), then the InitLedger arguments from maxJsonLength on
+		args, rejections, err := parseLoadArgs(req.Args)
+		if err != nil {
+			utils.WriteErr(w, err)
+			return
 		}
+		if err := ls.initLedger(args); err != nil {
+			log.Printf("[ERROR] LoadRecordsFromJSON: %v", err)
+			utils.WriteErr(w, err)
+			return
+		}
+		out, err := json.Marshal(utils.InitStatus{
+			LogN: ls.params.LogN(), NRecords: len(args.records) + len(rejections),
+			Staged: len(args.records), Rejected: len(rejections), Rejections: rejections,
+		})
+		if err != nil {
+			utils.WriteErr(w, err)
+			return
+		}
+		utils.WriteOK(w, string(out))
 
-		if err := ls.initLedger(n, maxJSON, logN, logQi, logPi, t); err != nil {
-			log.Printf("[ERROR] InitLedger: %v", err)
+	case "InitLedgerAsync":
+		// same arguments as InitLedger; returns immediately, poll GetRebuildStatus
+		args, err := parseInitArgs(req.Args)
+		if err != nil {
+			utils.WriteErr(w, err)
+			return
+		}
+		if err := ls.startRebuild(args); err != nil {
 			utils.WriteErr(w, err)
 			return
 		}
+		utils.WriteOK(w, "rebuild started")
 
-		utils.WriteOK(w, fmt.Sprintf(
-			"ledger initialized with %d records, LogN=%d, slotsPerRec=%d",
-			ls.nRecords, ls.params.LogN(), ls.slotsPerRec,
-		))
+	case "GetRebuildStatus":
+		out, err := json.Marshal(ls.rebuildStatus())
+		if err != nil {
+			utils.WriteErr(w, err)
+			return
+		}
+		utils.WriteOK(w, string(out))
 
 	case "GetMetadata":
 		ls.getMetadata(w)
 
+	case "DescribeSelector":
+		if len(req.Args) != 1 {
+			utils.WriteErr(w, fmt.Errorf("arg 0 = record index"))
+			return
+		}
+		ls.describeSelector(w, req.Args[0])
+
+	case "GetCapabilities":
+		out, err := json.Marshal(capabilities())
+		if err != nil {
+			utils.WriteErr(w, err)
+			return
+		}
+		utils.WriteOK(w, string(out))
+
+	case "Probe":
+		ls.probe(w)
+
 	case "PIRQuery":
 		if len(req.Args) != 1 {
 			utils.WriteErr(w, fmt.Errorf("need encQueryB64"))
 			return
 		}
-		outB64, err := ls.pirQuery(req.Args[0])
+		outB64, err := ls.pirQuery(req.caller, req.Args[0])
 		if err != nil {
 			utils.WriteErr(w, err)
 			return
@@ -126,13 +270,25 @@ func (ls *LedgerState) invoke(w http.ResponseWriter, r *http.Request) {
 			utils.WriteErr(w, fmt.Errorf("need encQueryB64"))
 			return
 		}
-		outJSON, err := ls.pirQueryTimed(req.Args[0])
+		outJSON, err := ls.pirQueryTimed(req.caller, req.Args[0])
 		if err != nil {
 			utils.WriteErr(w, err)
 			return
 		}
 		utils.WriteOK(w, outJSON)
 
+	case "PIRQueryBatch":
+		if len(req.Args) != 1 {
+			utils.WriteErr(w, fmt.Errorf("need encQueriesJSON"))
+			return
+		}
+		out, err := ls.pirQueryBatch(req.caller, req.Args[0])
+		if err != nil {
+			utils.WriteErr(w, err)
+			return
+		}
+		utils.WriteOK(w, out)
+
 	// helper cases
 	case "PublicQuery":
 		if len(req.Args) != 1 {
@@ -141,131 +297,227 @@ func (ls *LedgerState) invoke(w http.ResponseWriter, r *http.Request) {
 		}
 		ls.publicQuery(w, req.Args[0])
 
+	case "GetUsageStats":
+		// the per-identity costs stay on GET /admin/dataset/{name}/stats
+		out, err := json.Marshal(utils.UsageStats{Clients: []utils.ClientUsage{}, Access: ls.access.Snapshot()})
+		if err != nil {
+			utils.WriteErr(w, err)
+			return
+		}
+		utils.WriteOK(w, string(out))
+
 	case "GetMDBSize":
-		// returns the serialized size (bytes) of plaintext m_DB
+		// returns the serialized size (bytes) of plaintext m_DB, all shards
 		ls.mtx.RLock()
 		if ls.m_DB == nil {
 			ls.mtx.RUnlock()
 			utils.WriteErr(w, fmt.Errorf("m_DB not initialized"))
 			return
 		}
-		pt := ls.m_DB
+		size := ls.mdbBytes()
 		ls.mtx.RUnlock()
+		utils.WriteOK(w, fmt.Sprintf("%d", size))
+
+	// chaincode emulation (ledger.go)
+	case "PIRQuerySubmit":
+		if len(req.Args) != 1 {
+			utils.WriteErr(w, fmt.Errorf("need encQueryB64"))
+			return
+		}
+		writeResult(w)(ls.pirQuerySubmit(req.caller, req.Args[0]))
 
-		data, err := pt.MarshalBinary()
+	case "PublicQuerySubmit":
+		if len(req.Args) != 1 {
+			utils.WriteErr(w, fmt.Errorf("arg 0 = key (e.g., record000)"))
+			return
+		}
+		writeResult(w)(ls.publicQuerySubmit(req.caller, req.Args[0]))
+
+	case "GetAuditRecord":
+		if len(req.Args) != 1 {
+			utils.WriteErr(w, fmt.Errorf("arg 0 = tx id"))
+			return
+		}
+		start := time.Now()
+		rec, err := ls.loadAudit(req.Args[0])
 		if err != nil {
-			utils.WriteErr(w, fmt.Errorf("marshal m_DB: %w", err))
+			utils.WriteErr(w, fmt.Errorf("GetAuditRecord: %w", err))
 			return
 		}
-		utils.WriteOK(w, fmt.Sprintf("%d", len(data)))
+		writeResult(w)(utils.MarshalTimed(rec, start))
+
+	case "GetAuditPayload":
+		if len(req.Args) != 1 {
+			utils.WriteErr(w, fmt.Errorf("arg 0 = tx id"))
+			return
+		}
+		writeResult(w)(ls.getAuditPayload(req.Args[0]))
+
+	case "GetHistoryForKey":
+		if len(req.Args) != 1 {
+			utils.WriteErr(w, fmt.Errorf("arg 0 = key"))
+			return
+		}
+		writeResult(w)(ls.historyPage(req.Args[0], historyLimit, "", historyMaxValue, false))
+
+	case "GetHistoryPage":
+		writeResult(w)(ls.getHistoryPage(req.Args))
+
+	case "GetStorageReport":
+		writeResult(w)(ls.storageReport())
+
+	case "PIRQueryAuto":
+		writeResult(w)(ls.pirQueryAuto(req.caller))
 
 	default:
 		utils.WriteErr(w, fmt.Errorf("unknown method"))
 	}
 }
 
-func (ls *LedgerState) initLedger(n, maxJSON, logN int, logQi, logPi []int, t uint64) error {
-	ls.mtx.Lock()
-	defer ls.mtx.Unlock()
+// writeResult returns a writer of a method's (result, error) pair, so a
+// case can pass the method's return values straight through.
+func writeResult(w http.ResponseWriter) func(string, error) {
+	return func(out string, err error) {
+		if err != nil {
+			utils.WriteErr(w, err)
+			return
+		}
+		utils.WriteOK(w, out)
+	}
+}
+
+// initLedger rebuilds the dataset. The new m_DB is built in separate
+// buffers without holding ls.mtx, so queries keep hitting the old one
+// until install swaps it in. It is refused while an InitLedgerAsync
+// rebuild runs, whose later install would replace this one; the caller
+// holds initMu, so no rebuild starts until it returns.
+func (ls *LedgerState) initLedger(args initArgs) error {
+	if rb := ls.rebuildStatus(); rb.State == "running" {
+		return fmt.Errorf("rebuild running since %s - wait for GetRebuildStatus to report done or failed", rb.StartedAt.Format(time.RFC3339))
+	}
+	st, err := buildDB(args)
+	if err != nil {
+		return err
+	}
+	ls.install(st)
+	return nil
+}
+
+// buildDB encodes args.records, or args.n synthetic records when there
+// are none, into a fresh dbState, followed by args.reserve zero windows
+// (indices n..n+reserve-1) held for future appends.
+func buildDB(args initArgs) (*dbState, error) {
+	st := &dbState{}
+	n, reserve, maxJSON, logN := args.n, args.reserve, args.maxJSON, args.logN
 
 	// ---- Fallback: choose smallest feasible logN if not provided or <= 0
-	// s_guess = ceil(maxJSON/8)*8 (1 byte/slot packing)
-	sGuess := ((maxJSON + 7) / 8) * 8
+	// s_guess = ceil(maxJSON/8)*8 slots at 1 byte/slot, half that at 2;
+	// reserved windows count towards capacity
+	sGuess := utils.PackedSlots(maxJSON, args.packing)
 	if logN <= 0 {
-		chosen, err := utils.ChooseLogN(n, sGuess)
+		plan, err := utils.PlanLogN(n+reserve, sGuess, planOptions())
 		if err != nil {
-			return fmt.Errorf("auto-select logN failed: %w", err)
+			return nil, fmt.Errorf("auto-select logN failed: %w", err)
 		}
-		logN = chosen
-		log.Printf("[INFO] Auto-selected LogN=%d using n=%d and s_guess=%d", logN, n, sGuess)
+		logN = plan.LogN
+		log.Printf("[INFO] Auto-selected LogN=%d (shards=%d) using n=%d and s_guess=%d", logN, plan.Shards, n, sGuess)
 	}
 
 	// 1) ---- Build BGV params from hint (defaults applied inside utils)
 	hint := utils.BGVParamHint{
 		LogN:  logN,
-		LogQi: logQi,
-		LogPi: logPi,
-		T:     t,
+		LogQi: args.logQi,
+		LogPi: args.logPi,
+		T:     args.t,
 	}
 	p, err := utils.BuildParamsFromHint(hint)
 	if err != nil {
-		return fmt.Errorf("failed to set params: %w", err)
+		return nil, fmt.Errorf("failed to set params: %w", err)
 	}
-	ls.params = p
+	st.params = p
+	st.evals = utils.EvaluatorPoolFor(p)
 	log.Printf("[INFO] Params: LogN=%d N=%d |Q|=%d |P|=%d T=%d",
 		p.LogN(), p.N(), len(p.Q()), len(p.P()), p.PlaintextModulus())
 
-	// 2) ---- Generate synthetic records (uses logN to pick template)
-	gen, err := gen_records.GenerateRecords(n, logN, maxJSON)
-	if err != nil {
-		return err
+	// 2) ---- User records, else synthetic ones (uses logN to pick template)
+	recs := args.records
+	if recs == nil {
+		gen, err := gen_records.GenerateRecords(n, logN, maxJSON)
+		if err != nil {
+			return nil, err
+		}
+		recs = gen
+	}
+	if err := utils.CheckGenerated(n, recs); err != nil {
+		return nil, err
 	}
-	ls.records = gen
-	ls.nRecords = len(ls.records)
+	st.records = recs
+	st.nRecords = len(st.records) + reserve
+	st.reservedFrom = utils.ReservedFromLive(len(st.records), st.nRecords)
+	st.packing = args.packing
 
 	// 3) ---- Compute slots per record from actual JSON lengths
-	ls.slotsPerRec = utils.CalcSlotsPerRec(ls.records)
+	st.slotsPerRec = utils.CalcSlotsPerRecPacked(st.records, st.packing)
 
 	// 4) ---- Final capacity check with actual s
-	required := ls.nRecords * ls.slotsPerRec
-	if required > ls.params.MaxSlots() {
-		return fmt.Errorf("capacity exceeded: required=%d (n=%d × s=%d) > N=%d; try larger logN or smaller records",
-			required, ls.nRecords, ls.slotsPerRec, ls.params.MaxSlots())
+	if err := utils.CheckCapacity(st.nRecords, st.slotsPerRec, utils.LogSlots(st.params.MaxSlots()), planOptions().MaxShards); err != nil {
+		return nil, err
+	}
+	ic := st.contract()
+	if err := ic.ValidateSharded(); err != nil {
+		return nil, err
 	}
 
-	// 5) ---- Pack records into plaintext vector
-	packed := make([]uint64, ls.params.MaxSlots())
-	for recIdx, recBytes := range ls.records {
-		start := recIdx * ls.slotsPerRec
-		end := start + ls.slotsPerRec
-		if end > len(packed) {
-			break
-		}
-		for i := 0; i < len(recBytes) && i < ls.slotsPerRec; i++ {
-			packed[start+i] = uint64(recBytes[i])
-		}
+	// 5) ---- Pack records into plaintext vectors (slot window i ↔ record
+	// i within its shard, reserved windows stay zero)
+	packed, err := ic.PackShards(st.records)
+	if err != nil {
+		return nil, err
+	}
+	for recIdx := range st.records {
+		w, _ := ic.Describe(recIdx)
 
 		// Debug for first 3 and last 3 records only
-		if recIdx < 3 || recIdx >= len(ls.records)-3 {
-			log.Printf("[DBG] Packed record[%d]: slots [%d:%d) → first 16 values: %v",
-				recIdx, start, end, packed[start:start+16])
+		if recIdx < 3 || recIdx >= len(st.records)-3 {
+			log.Printf("[DBG] Packed record[%d]: shard %d slots [%d:%d) → first 16 values: %v",
+				recIdx, w.Shard, w.StartSlot, w.EndSlot, packed[w.Shard][w.StartSlot:min(w.EndSlot, w.StartSlot+16)])
 		}
 	}
 
 	// Utilization summary
-	filled := 0
-	for _, v := range packed {
-		if v != 0 {
-			filled++
+	filled, total := 0, 0
+	for _, shard := range packed {
+		for _, v := range shard {
+			if v != 0 {
+				filled++
+			}
 		}
+		total += len(shard)
 	}
-	allocStart := 0
-	allocEnd := ls.nRecords * ls.slotsPerRec
-	if allocEnd > len(packed) {
-		allocEnd = len(packed)
-	}
-	allocated := allocEnd - allocStart
-	empty := len(packed) - allocated
-	util := float64(filled) / float64(len(packed)) * 100
+	allocated := min(st.nRecords*ic.Stride(), total)
+	util := float64(filled) / float64(total) * 100
+	log.Printf("[INFO] Shards = %d of %d slots", len(packed), ic.Slots)
 	log.Printf("[INFO] Active slots (data) = %d", filled)
-	log.Printf("[INFO] Allocated range = [%d:%d) (Allocated slots = %d)", allocStart, allocEnd, allocated)
-	log.Printf("[INFO] Empty slots = %d", empty)
+	log.Printf("[INFO] Allocated slots = %d", allocated)
+	log.Printf("[INFO] Empty slots = %d", total-allocated)
 	log.Printf("[INFO] Utilization (data/full) = %.2f%%", util)
 
-	// 6) ---- Encode m_DB as plaintext polynomial
-	enc := bgv.NewEncoder(ls.params)
-	pt := bgv.NewPlaintext(ls.params, ls.params.MaxLevel())
-	if err := enc.Encode(packed, pt); err != nil {
-		return fmt.Errorf("failed to encode database: %w", err)
+	// 6) ---- Encode each shard as a plaintext polynomial
+	enc := bgv.NewEncoder(st.params)
+	for k, vec := range packed {
+		pt := bgv.NewPlaintext(st.params, st.params.MaxLevel())
+		if err := enc.Encode(vec, pt); err != nil {
+			return nil, fmt.Errorf("failed to encode database shard %d: %w", k, err)
+		}
+		st.m_DB = append(st.m_DB, pt)
 	}
-	ls.m_DB = pt
 
 	// Meta parity (debug)
 	log.Printf("[META] n=%d, record_s=%d, LogN=%d, N=%d, T=%d, LogQi=%v, LogPi=%v",
-		ls.nRecords, ls.slotsPerRec, ls.params.LogN(), ls.params.N(),
-		ls.params.PlaintextModulus(), ls.params.LogQi(), ls.params.LogPi())
+		st.nRecords, st.slotsPerRec, st.params.LogN(), st.params.N(),
+		st.params.PlaintextModulus(), st.params.LogQi(), st.params.LogPi())
 
-	return nil
+	return st, nil
 }
 
 // pirQuery performs the core PIR evaluation step inside the chaincode.
@@ -279,6 +531,25 @@ func (ls *LedgerState) initLedger(n, maxJSON, logN int, logQi, logPi []int, t ui
 
 // --- Methods moved out of invoke ----------------------------------
 
+// probe answers Probe: the capabilities and, once the dataset is
+// initialised, the hash of its params (utils.Probe).
+func (ls *LedgerState) probe(w http.ResponseWriter) {
+	ls.mtx.RLock()
+	var params *utils.Metadata
+	if ls.m_DB != nil {
+		params = &utils.Metadata{LogN: ls.params.LogN(), N: ls.params.N(), T: ls.params.PlaintextModulus(),
+			LogQi: ls.params.LogQi(), LogPi: ls.params.LogPi()}
+	}
+	ls.mtx.RUnlock()
+
+	out, err := json.Marshal(utils.NewProbe(capabilities(), params))
+	if err != nil {
+		utils.WriteErr(w, err)
+		return
+	}
+	utils.WriteOK(w, string(out))
+}
+
 func (ls *LedgerState) getMetadata(w http.ResponseWriter) {
 	ls.mtx.RLock()
 	defer ls.mtx.RUnlock()
@@ -291,6 +562,9 @@ func (ls *LedgerState) getMetadata(w http.ResponseWriter) {
 		T        uint64 `json:"t"`
 		LogQi    []int  `json:"logQi"`
 		LogPi    []int  `json:"logPi"`
+		Reserved int    `json:"reserved_from,omitempty"`
+		Packing  string `json:"packing,omitempty"`
+		Slots    int    `json:"slots"`
 	}{
 		NRecords: ls.nRecords,
 		RecordS:  ls.slotsPerRec,
@@ -299,6 +573,11 @@ func (ls *LedgerState) getMetadata(w http.ResponseWriter) {
 		T:        ls.params.PlaintextModulus(),
 		LogQi:    ls.params.LogQi(),
 		LogPi:    ls.params.LogPi(),
+		Reserved: ls.reservedFrom,
+		Slots:    ls.params.MaxSlots(),
+	}
+	if ls.packing != utils.Packing1B {
+		meta.Packing = ls.packing
 	}
 
 	out, err := json.Marshal(meta)
@@ -309,128 +588,212 @@ func (ls *LedgerState) getMetadata(w http.ResponseWriter) {
 	utils.WriteOK(w, string(out))
 }
 
-func (ls *LedgerState) pirQuery(encQueryB64 string) (string, error) {
+func (ls *LedgerState) pirQuery(caller, encQueryB64 string) (string, error) {
 	ls.mtx.RLock()
 	defer ls.mtx.RUnlock()
+	ls.queries.Add(1)
 
 	if ls.m_DB == nil {
 		return "", fmt.Errorf("PIR database not initialized")
 	}
 
-	// 1. Decode Base64 query into ciphertext
-	encBytes, err := base64.StdEncoding.DecodeString(encQueryB64)
+	// 1. Decode Base64 query into ciphertext (panic-safe, shape-checked)
+	ctQuery, encBytes, err := utils.DecodeQuery(ls.params, encQueryB64)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode base64 query: %w", err)
-	}
-
-	ctQuery := rlwe.NewCiphertext(ls.params, 1, ls.params.MaxLevel())
-	if err := ctQuery.UnmarshalBinary(encBytes); err != nil {
-		return "", fmt.Errorf("failed to unmarshal query ciphertext: %w", err)
+		return "", err
 	}
 
 	// Debug print: input ciphertext size in bytes
 	log.Printf("[EVAL] Query ciphertext size = %d bytes", len(encBytes))
 
-	// 2. Perform homomorphic multiplication (ciphertext × plaintext)
-	eval := bgv.NewEvaluator(ls.params, nil)
-
-	start := time.Now()
-	ctRes, err := eval.MulNew(ctQuery, ls.m_DB)
+	// 2. Perform homomorphic multiplication (ciphertext × each plaintext
+	// shard, on the evaluation pool)
+	res, usage, err := ls.evalShards([]*rlwe.Ciphertext{ctQuery})
 	if err != nil {
 		return "", fmt.Errorf("PIR evaluation failed: %w", err)
 	}
-	evalDuration := time.Since(start)
+	ls.usage.Add(caller, usage)
+	ls.access.AddQuery(time.Now())
 
 	// Debug: print timing and ring info
-	log.Printf("[EVAL] PIR evaluation completed in %.3f ms (LogN=%d, ring slots=%d)",
-		float64(evalDuration.Nanoseconds())/1e6, ls.params.LogN(), ls.params.MaxSlots())
+	log.Printf("[EVAL] PIR evaluation completed in %.3f ms, cpu %.3f ms (LogN=%d, ring slots=%d, shards=%d)",
+		usage.WallMS, usage.CPUMS, ls.params.LogN(), ls.params.MaxSlots(), len(ls.m_DB))
 
-	// 3. Serialize result back to Base64
-	outBytes, err := ctRes.MarshalBinary()
+	// 3. Serialize the results back to Base64, one per shard
+	out, err := encodeShards(res[0], usage.Shards)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal result ciphertext: %w", err)
+		return "", err
 	}
 
 	// Debug: output ciphertext size
-	log.Printf("[EVAL] Result ciphertext size = %d bytes", len(outBytes))
+	log.Printf("[EVAL] Result size = %d bytes (Base64)", len(out))
 
-	return base64.StdEncoding.EncodeToString(outBytes), nil
+	return out, nil
 }
 
-// pirQueryTimed runs PIR evaluation and returns timing + ciphertext.
-// pirQueryTimed performs the same PIR evaluation as pirQuery()
-// but returns a JSON object with the Base64 ciphertext and internal Eval time in ms.
-func (ls *LedgerState) pirQueryTimed(encQueryB64 string) (string, error) {
+// pirQueryBatch evaluates a JSON array of Base64 ct_q (utils/batch.go)
+// against m_DB, every query × shard product at once on the evaluation
+// pool, and returns the JSON array of their responses, in request order.
+func (ls *LedgerState) pirQueryBatch(caller, encQueriesJSON string) (string, error) {
+	queries, err := utils.ParseBatchQueries(encQueriesJSON, utils.MaxBatchQueries)
+	if err != nil {
+		return "", err
+	}
 	ls.mtx.RLock()
 	defer ls.mtx.RUnlock()
+	ls.queries.Add(uint64(len(queries)))
 
 	if ls.m_DB == nil {
 		return "", fmt.Errorf("PIR database not initialized")
 	}
 
-	// Decode input ciphertext
-	encBytes, err := base64.StdEncoding.DecodeString(encQueryB64)
+	// Decode every ct_q before evaluating any (panic-safe, shape-checked)
+	cts := make([]*rlwe.Ciphertext, len(queries))
+	for i, q := range queries {
+		if cts[i], _, err = utils.DecodeQuery(ls.params, q); err != nil {
+			return "", fmt.Errorf("query %d: %w", i, err)
+		}
+	}
+
+	res, usage, err := ls.evalShards(cts)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode base64 query: %w", err)
+		return "", fmt.Errorf("PIR evaluation failed: %w", err)
+	}
+	ls.usage.AddQueries(caller, len(cts), usage)
+	for range cts {
+		ls.access.AddQuery(time.Now())
+	}
+	log.Printf("[EVAL_BATCH] %d queries evaluated in %.3f ms, cpu %.3f ms (LogN=%d, shards=%d)",
+		len(cts), usage.WallMS, usage.CPUMS, ls.params.LogN(), len(ls.m_DB))
+
+	results := make([]string, len(res))
+	for i, r := range res {
+		if results[i], err = encodeShards(r, nil); err != nil {
+			return "", err
+		}
 	}
+	return utils.EncodeBatchResults(results), nil
+}
 
-	ctQuery := rlwe.NewCiphertext(ls.params, 1, ls.params.MaxLevel())
-	if err := ctQuery.UnmarshalBinary(encBytes); err != nil {
-		return "", fmt.Errorf("failed to unmarshal ciphertext: %w", err)
+// pirQueryTimed runs PIR evaluation and returns timing + ciphertext.
+// pirQueryTimed performs the same PIR evaluation as pirQuery()
+// but returns a JSON object with the response, the internal Eval time in
+// ms and the usage, per-shard timings included.
+func (ls *LedgerState) pirQueryTimed(caller, encQueryB64 string) (string, error) {
+	ls.mtx.RLock()
+	defer ls.mtx.RUnlock()
+	ls.queries.Add(1)
+
+	if ls.m_DB == nil {
+		return "", fmt.Errorf("PIR database not initialized")
 	}
 
-	// Perform homomorphic multiplication (ct × pt)
-	eval := bgv.NewEvaluator(ls.params, nil)
-	start := time.Now()
-	ctRes, err := eval.MulNew(ctQuery, ls.m_DB)
+	// Decode input ciphertext (panic-safe, shape-checked)
+	ctQuery, encBytes, err := utils.DecodeQuery(ls.params, encQueryB64)
 	if err != nil {
-		return "", fmt.Errorf("PIR evaluation failed: %w", err)
+		return "", err
 	}
-	evalMS := float64(time.Since(start).Nanoseconds()) / 1e6 // ms
 
-	// Serialize result
-	outBytes, err := ctRes.MarshalBinary()
+	// Perform homomorphic multiplication (ct × each shard)
+	res, usage, err := ls.evalShards([]*rlwe.Ciphertext{ctQuery})
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal result ciphertext: %w", err)
+		return "", fmt.Errorf("PIR evaluation failed: %w", err)
 	}
+	ls.usage.Add(caller, usage)
+	ls.access.AddQuery(time.Now())
+	evalMS := usage.WallMS // ms
 
-	outB64 := base64.StdEncoding.EncodeToString(outBytes)
+	// Serialize result (fills in usage.Shards[k].ResultBytes)
+	outB64, err := encodeShards(res[0], usage.Shards)
+	if err != nil {
+		return "", err
+	}
+	usage.QueryBytes = len(encBytes)
 
 	// Compose JSON
 	payload := map[string]interface{}{
 		"b64":     outB64,
 		"eval_ms": evalMS,
+		"usage":   usage,
 	}
 	outJSON, err := json.Marshal(payload)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal PIRQueryTimed response: %w", err)
 	}
 
-	log.Printf("[EVAL_TIMED] Eval completed in %.3f ms (LogN=%d, N=%d)", evalMS, ls.params.LogN(), ls.params.N())
+	log.Printf("[EVAL_TIMED] Eval completed in %.3f ms (LogN=%d, N=%d, shards=%d)", evalMS, ls.params.LogN(), ls.params.N(), len(ls.m_DB))
 
 	return string(outJSON), nil
 }
 
+// publicQuery serves record key in the clear. Only canonical record keys
+// of the current dataset are served: a malformed key is a 400
+// (utils.ErrInvalidKey), an index past the end a 404
+// (utils.ErrRecordNotFound).
 func (ls *LedgerState) publicQuery(w http.ResponseWriter, key string) {
-	idx, err := strconv.Atoi(key[len(key)-3:])
-	if err != nil || idx < 0 {
-		utils.WriteErr(w, fmt.Errorf("invalid record index from key %q", key))
+	ls.mtx.RLock()
+	rec, err := ls.readPublic(key)
+	ls.mtx.RUnlock()
+	switch {
+	case errors.Is(err, errNotInitialized):
+		utils.WriteErrStatus(w, http.StatusServiceUnavailable, err)
+	case errors.Is(err, utils.ErrRecordNotFound):
+		utils.WriteErrStatus(w, http.StatusNotFound, err)
+	case err != nil:
+		utils.WriteErr(w, err) // utils.ErrInvalidKey: 400
+	default:
+		utils.WriteOK(w, rec)
+	}
+}
+
+// describeSelector mirrors the chaincode's DescribeSelector.
+func (ls *LedgerState) describeSelector(w http.ResponseWriter, indexStr string) {
+	idx, err := strconv.Atoi(indexStr)
+	if err != nil {
+		utils.WriteErr(w, fmt.Errorf("index must be an integer: %w", err))
 		return
 	}
 
 	ls.mtx.RLock()
-	defer ls.mtx.RUnlock()
-	if idx >= len(ls.records) {
-		utils.WriteErr(w, fmt.Errorf("not found"))
+	if ls.m_DB == nil {
+		ls.mtx.RUnlock()
+		utils.WriteErr(w, fmt.Errorf("m_DB not initialized"))
 		return
 	}
-	utils.WriteOK(w, string(ls.records[idx]))
+	ic := ls.contract()
+	ls.mtx.RUnlock()
+
+	layout, err := ic.Describe(idx)
+	if err != nil {
+		utils.WriteErr(w, err)
+		return
+	}
+	out, err := json.Marshal(layout)
+	if err != nil {
+		utils.WriteErr(w, err)
+		return
+	}
+	utils.WriteOK(w, string(out))
 }
 
 /********* MAIN ***************************************************/
 func main() {
-	ls := &LedgerState{}
-	http.HandleFunc("/invoke", ls.invoke)
-	log.Println("REST chaincode listening on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	cfgPath := flag.String("config", os.Getenv("PIR_CONFIG"), "JSON config file (reloaded on SIGHUP / POST /admin/reload)")
+	flag.Parse()
+
+	srv, err := newServer(*cfgPath)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	srv.watchSIGHUP()
+	shardPool.start(srv.config().EvalWorkers)
+	srv.startReplica()
+	if srv.config().GRPCAddr != "" {
+		go func() { log.Fatal(srv.serveGRPC()) }()
+	}
+
+	http.HandleFunc("/invoke", srv.invoke)
+	http.HandleFunc("POST /pir/stream", srv.stream)
+	srv.registerAdmin(http.DefaultServeMux)
+	log.Fatal(srv.listenAndServe(http.DefaultServeMux))
 }
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"pir_shared/utils"
+)
+
+/********* INIT ARGUMENTS ******************************************/
+
+// initArgs are the parsed InitLedger / InitLedgerAsync arguments.
+type initArgs struct {
+	n, maxJSON, logN int
+	logQi, logPi     []int
+	t                uint64
+	reserve          int    // zero windows after the n records
+	packing          string // utils.Packing1B or utils.Packing2B
+
+	// records are LoadRecordsFromJSON's sanitized records (n of them);
+	// nil generates n synthetic ones.
+	records [][]byte
+}
+
+// parseInitArgs reads numRecords, maxJsonLength and the optional
+// logN, logQi(json), logPi(json), t, reserve, packing.
+func parseInitArgs(a []string) (initArgs, error) {
+	if len(a) < 2 {
+		return initArgs{}, fmt.Errorf("InitLedger requires at least 2 arguments: numRecords, maxJsonLength; optionally: logN, logQi(json), logPi(json), t, reserve, packing")
+	}
+
+	n, err1 := strconv.Atoi(a[0])
+	maxJSON, err2 := strconv.Atoi(a[1])
+	if err1 != nil || err2 != nil || n <= 0 || maxJSON <= 0 {
+		return initArgs{}, fmt.Errorf("numRecords and maxJsonLength must be positive integers")
+	}
+	args := initArgs{n: n, maxJSON: maxJSON, t: 65537, packing: utils.Packing1B}
+
+	// optional: logN (empty/0 means: auto-select)
+	if len(a) >= 3 && a[2] != "" {
+		if v, err := strconv.Atoi(a[2]); err == nil {
+			args.logN = v
+		}
+	}
+
+	// optional: logQi, logPi as JSON arrays of ints
+	if len(a) >= 4 && a[3] != "" {
+		if err := json.Unmarshal([]byte(a[3]), &args.logQi); err != nil {
+			return initArgs{}, fmt.Errorf("invalid logQi JSON: %w", err)
+		}
+	}
+	if len(a) >= 5 && a[4] != "" {
+		if err := json.Unmarshal([]byte(a[4]), &args.logPi); err != nil {
+			return initArgs{}, fmt.Errorf("invalid logPi JSON: %w", err)
+		}
+	}
+
+	// optional: t (plaintext modulus)
+	if len(a) >= 6 && a[5] != "" {
+		if parsedT, err := strconv.ParseUint(a[5], 10, 64); err == nil && parsedT > 0 {
+			args.t = parsedT
+		}
+	}
+
+	// optional: reserve (indices held empty for future appends)
+	if len(a) >= 7 && a[6] != "" {
+		v, err := strconv.Atoi(a[6])
+		if err != nil || v < 0 {
+			return initArgs{}, fmt.Errorf("reserve must be a non-negative integer")
+		}
+		args.reserve = v
+	}
+
+	// optional: packing ("1b" one byte per slot, "2b" two)
+	if len(a) >= 8 && a[7] != "" {
+		p, err := utils.ParsePacking(a[7])
+		if err != nil {
+			return initArgs{}, err
+		}
+		args.packing = p
+	}
+	if err := utils.CheckPacking(args.packing, utils.SchemeBGV, args.t); err != nil {
+		return initArgs{}, err
+	}
+	return args, nil
+}
+
+// parseLoadArgs reads LoadRecordsFromJSON's arguments: a JSON array of
+// records, then InitLedger's from maxJsonLength on. Each record goes
+// through utils.DefaultSanitize capped at maxJsonLength, like the
+// chaincode's; rejected ones are returned and left out of the dataset.
+func parseLoadArgs(a []string) (initArgs, []utils.Rejection, error) {
+	if len(a) < 2 {
+		return initArgs{}, nil, fmt.Errorf("LoadRecordsFromJSON requires at least 2 arguments: records(json), maxJsonLength; optionally: logN, logQi(json), logPi(json), t, reserve, packing")
+	}
+	var recs []json.RawMessage
+	if err := json.Unmarshal([]byte(a[0]), &recs); err != nil {
+		return initArgs{}, nil, fmt.Errorf("records must be a JSON array: %w", err)
+	}
+	if len(recs) == 0 {
+		return initArgs{}, nil, fmt.Errorf("no records")
+	}
+	maxJSON, err := strconv.Atoi(a[1])
+	if err != nil || maxJSON <= 0 {
+		return initArgs{}, nil, fmt.Errorf("maxJsonLength must be a positive integer")
+	}
+
+	raw := make([][]byte, len(recs))
+	for i, rec := range recs {
+		raw[i] = rec
+	}
+	opt := utils.DefaultSanitize
+	opt.MaxRecordLen = maxJSON
+	clean, rejections := utils.SanitizeRecords(raw, opt)
+	if len(clean) == 0 {
+		return initArgs{}, rejections, fmt.Errorf("all %d records rejected (first: record %d: %s)",
+			len(recs), rejections[0].Index, rejections[0].Reason)
+	}
+
+	args, err := parseInitArgs(append([]string{strconv.Itoa(len(clean))}, a[1:]...))
+	if err != nil {
+		return initArgs{}, rejections, err
+	}
+	args.records = clean
+	return args, rejections, nil
+}
+
+/********* SWAP ****************************************************/
+
+// install makes st the live database. Only this assignment (and the
+// history entry, ledger.go) runs under the write lock; in-flight queries
+// finish on the previous m_DB. st's digest is computed before.
+func (ls *LedgerState) install(st *dbState) {
+	digest, err := mdbDigest(st)
+	if err != nil {
+		log.Printf("[ERROR] m_DB digest: %v", err)
+	}
+	st.digest = digest
+
+	ls.mtx.Lock()
+	ls.dbState = *st
+	ls.initAt = time.Now()
+	ls.recordInstall(st, ls.initAt)
+	ls.mtx.Unlock()
+}
+
+/********* BACKGROUND REBUILD **************************************/
+
+// rebuildStatus reports the last InitLedgerAsync run of a dataset.
+type rebuildStatus struct {
+	State      string    `json:"state"` // idle | running | done | failed
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// startRebuild builds a new m_DB in a goroutine and swaps it in when
+// ready. At most one rebuild per dataset runs at a time, and synchronous
+// inits are refused meanwhile (initLedger).
+func (ls *LedgerState) startRebuild(args initArgs) error {
+	ls.mtx.Lock()
+	if ls.rebuild.State == "running" {
+		ls.mtx.Unlock()
+		return fmt.Errorf("rebuild already running since %s", ls.rebuild.StartedAt.Format(time.RFC3339))
+	}
+	ls.rebuild = rebuildStatus{State: "running", StartedAt: time.Now()}
+	ls.mtx.Unlock()
+
+	go func() {
+		st, err := buildDB(args)
+		if err == nil {
+			ls.install(st)
+		}
+
+		ls.mtx.Lock()
+		defer ls.mtx.Unlock()
+		ls.rebuild.FinishedAt = time.Now()
+		if err != nil {
+			log.Printf("[ERROR] InitLedgerAsync: %v", err)
+			ls.rebuild.State, ls.rebuild.Error = "failed", err.Error()
+			return
+		}
+		ls.rebuild.State = "done"
+		log.Printf("[INFO] InitLedgerAsync: swapped in %d records after %s",
+			len(ls.records), ls.rebuild.FinishedAt.Sub(ls.rebuild.StartedAt).Round(time.Millisecond))
+	}()
+	return nil
+}
+
+func (ls *LedgerState) rebuildStatus() rebuildStatus {
+	ls.mtx.RLock()
+	defer ls.mtx.RUnlock()
+	st := ls.rebuild
+	if st.State == "" {
+		st.State = "idle"
+	}
+	return st
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+
+	"pir_shared/blobstore"
+	"pir_shared/utils"
+)
+
+/********* WARM STANDBY ********************************************/
+
+// A server started with replica_of is a warm standby of that primary: it
+// polls the primary's GET /admin/replica/datasets every replica_interval,
+// pulls the snapshot of each dataset whose version (the primary's
+// install time) changed from GET /admin/replica/dataset/{name}, installs
+// it as InitLedgerAsync would, and drops datasets the primary no longer
+// has. Queries are served from the pulled m_DB; InitLedger* and
+// LoadRecordsFromJSON are refused until POST /admin/promote makes it a
+// primary, so failover costs one promote call rather than a re-init.
+// m_DB travels inline, or as a blob store ref when the primary offloads
+// it, in which case the standby needs the same blob_store.
+
+// defaultReplicaInterval is the poll period when replica_interval is 0.
+const defaultReplicaInterval = 30 * time.Second
+
+func (c *serverConfig) replicaInterval() time.Duration {
+	if c.ReplicaInterval == 0 {
+		return defaultReplicaInterval
+	}
+	return time.Duration(c.ReplicaInterval) * time.Second
+}
+
+// replicaVersions is the body of GET /admin/replica/datasets: dataset name
+// → version, for initialized datasets.
+type replicaVersions map[string]int64
+
+// replicaStatus is returned by GET /admin/replica.
+type replicaStatus struct {
+	Role      string           `json:"role"` // primary | standby
+	Primary   string           `json:"primary,omitempty"`
+	LastSync  time.Time        `json:"last_sync,omitempty"`
+	LastError string           `json:"last_error,omitempty"`
+	Applied   map[string]int64 `json:"applied,omitempty"` // dataset → primary version installed here
+}
+
+// replica is the standby side of a Server; the zero value is a primary.
+type replica struct {
+	mtx     sync.Mutex
+	standby bool
+	status  replicaStatus
+	stop    chan struct{}
+}
+
+func (s *Server) registerReplicaAdmin(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/replica", s.requireAdmin(s.adminReplicaStatus))
+	mux.HandleFunc("GET /admin/replica/datasets", s.requireAdmin(s.adminReplicaVersions))
+	mux.HandleFunc("GET /admin/replica/dataset/{name}", s.requireAdmin(s.adminReplicaSnapshot))
+	mux.HandleFunc("POST /admin/promote", s.requireAdmin(s.adminPromote))
+}
+
+// isStandby reports whether the server still follows a primary.
+func (s *Server) isStandby() bool {
+	s.replica.mtx.Lock()
+	defer s.replica.mtx.Unlock()
+	return s.replica.standby
+}
+
+// startReplica begins following cfg.ReplicaOf, if set. It is called once
+// at startup; replica_of changes on reload need a restart.
+func (s *Server) startReplica() {
+	cfg := s.config()
+	if cfg.ReplicaOf == "" {
+		return
+	}
+	s.replica.mtx.Lock()
+	s.replica.standby = true
+	s.replica.stop = make(chan struct{})
+	s.replica.status = replicaStatus{Role: "standby", Primary: cfg.ReplicaOf, Applied: map[string]int64{}}
+	stop := s.replica.stop
+	s.replica.mtx.Unlock()
+
+	log.Printf("[REPLICA] standby of %s, polling every %s", cfg.ReplicaOf, cfg.replicaInterval())
+	go func() {
+		for {
+			err := s.syncFromPrimary(context.Background())
+			s.replica.mtx.Lock()
+			if s.replica.standby {
+				s.replica.status.LastError = ""
+				if err != nil {
+					log.Printf("[REPLICA] sync: %v", err)
+					s.replica.status.LastError = err.Error()
+				}
+			}
+			s.replica.mtx.Unlock()
+
+			select {
+			case <-stop:
+				return
+			case <-time.After(s.config().replicaInterval()):
+			}
+		}
+	}()
+}
+
+// syncFromPrimary runs one poll: pull changed datasets, drop removed ones.
+func (s *Server) syncFromPrimary(ctx context.Context) error {
+	var versions replicaVersions
+	if err := s.fetchPrimary(ctx, "/admin/replica/datasets", &versions); err != nil {
+		return err
+	}
+
+	s.replica.mtx.Lock()
+	applied := make(map[string]int64, len(s.replica.status.Applied))
+	for name, v := range s.replica.status.Applied {
+		applied[name] = v
+	}
+	s.replica.mtx.Unlock()
+
+	for name, v := range versions {
+		if applied[name] == v || !datasetNameRe.MatchString(name) {
+			continue
+		}
+		var snap snapshotFile
+		if err := s.fetchPrimary(ctx, "/admin/replica/dataset/"+url.PathEscape(name), &snap); err != nil {
+			return fmt.Errorf("dataset %q: %w", name, err)
+		}
+		st, err := s.restoreSnapshot(ctx, snap)
+		if err != nil {
+			return fmt.Errorf("dataset %q: %w", name, err)
+		}
+		if !s.applyReplica(name, v, st) {
+			return nil // promoted meanwhile
+		}
+		log.Printf("[REPLICA] installed %s version %d (%d records, LogN=%d)",
+			name, v, len(st.records), st.params.LogN())
+	}
+
+	s.replica.mtx.Lock()
+	defer s.replica.mtx.Unlock()
+	if !s.replica.standby {
+		return nil
+	}
+	for name := range s.replica.status.Applied {
+		if _, ok := versions[name]; ok {
+			continue
+		}
+		s.mtx.Lock()
+		delete(s.datasets, name)
+		s.mtx.Unlock()
+		delete(s.replica.status.Applied, name)
+		log.Printf("[REPLICA] dropped %s (gone on the primary)", name)
+	}
+	s.replica.status.LastSync = time.Now()
+	return nil
+}
+
+// applyReplica installs st as dataset name unless the server was promoted
+// while it was being pulled.
+func (s *Server) applyReplica(name string, version int64, st *dbState) bool {
+	s.replica.mtx.Lock()
+	defer s.replica.mtx.Unlock()
+	if !s.replica.standby {
+		return false
+	}
+	ls, _ := s.dataset(name, true)
+	ls.install(st)
+	s.replica.status.Applied[name] = version
+	return true
+}
+
+// fetchPrimary GETs path from the primary with the replica token and
+// decodes the {response} body into out.
+func (s *Server) fetchPrimary(ctx context.Context, path string, out any) error {
+	cfg := s.config()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.ReplicaOf+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.ReplicaToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var wrap struct {
+		Response string `json:"response"`
+		Error    string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &wrap); err != nil {
+		return fmt.Errorf("GET %s: HTTP %d: %w", path, resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || wrap.Error != "" {
+		return fmt.Errorf("GET %s: HTTP %d: %s", path, resp.StatusCode, wrap.Error)
+	}
+	return json.Unmarshal([]byte(wrap.Response), out)
+}
+
+// restoreSnapshot rebuilds a dbState from a snapshot without re-encoding
+// the records: params from the metadata, m_DB unmarshalled as is.
+func (s *Server) restoreSnapshot(ctx context.Context, snap snapshotFile) (*dbState, error) {
+	params, err := utils.BuildParamsFromMetadata(snap.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("params: %w", err)
+	}
+
+	var mdb []byte
+	switch {
+	case snap.MDBRef != nil:
+		cfg := s.config()
+		if cfg.BlobStore == "" {
+			return nil, fmt.Errorf("m_DB is in blob store %s but no blob_store is configured", snap.MDBRef.URI)
+		}
+		store, err := blobstore.Open(cfg.BlobStore)
+		if err != nil {
+			return nil, err
+		}
+		if mdb, err = store.Get(ctx, *snap.MDBRef); err != nil {
+			return nil, fmt.Errorf("fetch m_DB: %w", err)
+		}
+	default:
+		if mdb, err = base64.StdEncoding.DecodeString(snap.MDBBase64); err != nil {
+			return nil, fmt.Errorf("decode m_DB: %w", err)
+		}
+	}
+	// the shards are marshalled back to back, all of the same size
+	shards := max(snap.Shards, 1)
+	if len(mdb)%shards != 0 {
+		return nil, fmt.Errorf("m_DB: %d bytes do not split into %d shards", len(mdb), shards)
+	}
+	size := len(mdb) / shards
+	pts := make([]*rlwe.Plaintext, shards)
+	for k := range pts {
+		if pts[k], err = utils.UnmarshalPlaintext(params, mdb[k*size:(k+1)*size]); err != nil {
+			return nil, fmt.Errorf("m_DB shard %d: %w", k, err)
+		}
+	}
+
+	st := &dbState{
+		params:       params,
+		m_DB:         pts,
+		evals:        utils.EvaluatorPoolFor(params),
+		nRecords:     snap.Metadata.NRecords,
+		slotsPerRec:  snap.Metadata.RecordS,
+		reservedFrom: snap.Metadata.ReservedFrom,
+		packing:      snap.Metadata.PackingMode(),
+		records:      make([][]byte, len(snap.Records)),
+	}
+	for i, r := range snap.Records {
+		st.records[i] = []byte(r)
+	}
+	ic := st.contract()
+	if err := ic.ValidateSharded(); err != nil {
+		return nil, err
+	}
+	if ic.Shards() != len(pts) {
+		return nil, fmt.Errorf("m_DB has %d shards, the metadata %d", len(pts), ic.Shards())
+	}
+	return st, nil
+}
+
+/********* ADMIN ENDPOINTS *****************************************/
+
+func (s *Server) adminReplicaStatus(w http.ResponseWriter, r *http.Request) {
+	s.replica.mtx.Lock()
+	st := s.replica.status
+	if st.Role == "" {
+		st.Role = "primary"
+	}
+	out, err := json.Marshal(st)
+	s.replica.mtx.Unlock()
+	if err != nil {
+		utils.WriteErr(w, fmt.Errorf("marshal replica status: %w", err))
+		return
+	}
+	utils.WriteOK(w, string(out))
+}
+
+// adminReplicaVersions lists the initialized datasets and their versions,
+// for standbys to poll.
+func (s *Server) adminReplicaVersions(w http.ResponseWriter, r *http.Request) {
+	versions := replicaVersions{}
+	for _, name := range s.datasetNames() {
+		ls, ok := s.dataset(name, false)
+		if !ok {
+			continue
+		}
+		ls.mtx.RLock()
+		if ls.m_DB != nil {
+			versions[name] = ls.initAt.UnixNano()
+		}
+		ls.mtx.RUnlock()
+	}
+	out, _ := json.Marshal(versions)
+	utils.WriteOK(w, string(out))
+}
+
+// adminReplicaSnapshot returns the snapshot of one dataset, as POST
+// /admin/snapshot would write it.
+func (s *Server) adminReplicaSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	ls, ok := s.dataset(name, false)
+	if !ok {
+		utils.WriteErrStatus(w, http.StatusNotFound, fmt.Errorf("unknown dataset %q", name))
+		return
+	}
+	snap, mdb, err := ls.snapshot(name)
+	if err != nil {
+		utils.WriteErr(w, err)
+		return
+	}
+	if err := s.attachMDB(r.Context(), &snap, mdb); err != nil {
+		utils.WriteErr(w, fmt.Errorf("snapshot %q: %w", name, err))
+		return
+	}
+	out, err := json.Marshal(snap)
+	if err != nil {
+		utils.WriteErr(w, fmt.Errorf("marshal snapshot %q: %w", name, err))
+		return
+	}
+	utils.WriteOK(w, string(out))
+}
+
+// adminPromote stops following the primary and accepts writes. The
+// datasets pulled so far stay live; promoting a primary is a no-op.
+func (s *Server) adminPromote(w http.ResponseWriter, r *http.Request) {
+	s.replica.mtx.Lock()
+	was := s.replica.standby
+	if was {
+		s.replica.standby = false
+		close(s.replica.stop)
+		s.replica.status.Role = "primary"
+	}
+	applied := len(s.replica.status.Applied)
+	s.replica.mtx.Unlock()
+
+	if !was {
+		utils.WriteOK(w, "already primary")
+		return
+	}
+	log.Printf("[REPLICA] promoted to primary with %d datasets", applied)
+	utils.WriteOK(w, fmt.Sprintf("promoted to primary with %d datasets", applied))
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+
+	"pir_shared/utils"
+)
+
+/********* SHARDED m_DB AND THE EVALUATION POOL ********************/
+
+// A dataset larger than one plaintext is packed over several m_DB shards
+// (utils.IndexContract.PackShards, at most max_shards of them). Each
+// shard of a query, and each query of a batch, is one ct×pt product
+// independent of the others, so they run on a fixed pool of eval_workers
+// goroutines (0 → GOMAXPROCS) instead of one after the other on the
+// request's goroutine. Every product takes its own pooled evaluator.
+// PIRQueryTimed reports each shard's compute time, queueing delay and
+// worker in usage.shards; the raw-byte paths (/pir/stream, gRPC) carry a
+// single ct_r and refuse sharded datasets.
+
+// maxShards is the shard limit of the next InitLedger (config max_shards,
+// 0 → 1).
+var maxShards atomic.Int32
+
+// planOptions restricts logN auto-selection to layouts buildDB can pack
+// (logN=16 allowed as the large-ring fallback).
+func planOptions() utils.PlanOptions {
+	return utils.PlanOptions{MaxShards: max(int(maxShards.Load()), 1), AllowLogN16: true}
+}
+
+// shardPool evaluates the products of every PIR query.
+var shardPool = &evalPool{}
+
+// evalTask is one ct×pt product.
+type evalTask struct {
+	run     func() error
+	started time.Time
+	ended   time.Time
+	worker  int
+	usage   utils.EvalUsage
+	err     error
+	wg      *sync.WaitGroup
+}
+
+type evalPool struct {
+	once    sync.Once
+	workers int
+	tasks   chan *evalTask
+}
+
+// start launches the workers (n <= 0 → GOMAXPROCS). Only the first call
+// counts: eval_workers is not reloadable.
+func (p *evalPool) start(n int) {
+	p.once.Do(func() {
+		if n <= 0 {
+			n = runtime.GOMAXPROCS(0)
+		}
+		p.workers, p.tasks = n, make(chan *evalTask, 4*n)
+		for w := 0; w < n; w++ {
+			go p.worker(w)
+		}
+		log.Printf("[EVAL] %d evaluation workers", n)
+	})
+}
+
+func (p *evalPool) worker(w int) {
+	for t := range p.tasks {
+		t.worker, t.started = w, time.Now()
+		t.usage, t.err = utils.MeasureEval(t.run)
+		t.ended = time.Now()
+		t.wg.Done()
+	}
+}
+
+// run evaluates every fns[k] on the pool and waits for all of them. The
+// usage covers the whole set: WallMS from the first start to the last
+// end, QueueMS before the first start, CPU and allocations summed. The
+// error is that of the lowest failing task.
+func (p *evalPool) run(fns []func() error) (utils.EvalUsage, []utils.ShardUsage, error) {
+	p.start(0)
+
+	var wg sync.WaitGroup
+	wg.Add(len(fns))
+	tasks := make([]*evalTask, len(fns))
+	submitted := time.Now()
+	for k, fn := range fns {
+		tasks[k] = &evalTask{run: fn, wg: &wg}
+		p.tasks <- tasks[k]
+	}
+	wg.Wait()
+
+	var usage utils.EvalUsage
+	shards := make([]utils.ShardUsage, len(tasks))
+	var first, last time.Time
+	var err error
+	for k, t := range tasks {
+		if k == 0 || t.started.Before(first) {
+			first = t.started
+		}
+		if t.ended.After(last) {
+			last = t.ended
+		}
+		switch {
+		case usage.CPUMS < 0:
+		case t.usage.CPUMS < 0:
+			usage.CPUMS = -1
+		default:
+			usage.CPUMS += t.usage.CPUMS
+		}
+		usage.AllocBytes += t.usage.AllocBytes
+		usage.Allocs += t.usage.Allocs
+		shards[k] = utils.ShardUsage{Shard: k, EvalMS: t.usage.WallMS, QueueMS: msOf(t.started.Sub(submitted)), Worker: t.worker}
+		if t.err != nil && err == nil {
+			err = fmt.Errorf("shard %d: %w", k, t.err)
+		}
+	}
+	usage.WallMS, usage.QueueMS = msOf(last.Sub(first)), msOf(first.Sub(submitted))
+	return usage, shards, err
+}
+
+func msOf(d time.Duration) float64 { return float64(d.Nanoseconds()) / 1e6 }
+
+// evalShards multiplies every ct_q with every m_DB shard on shardPool.
+// res[q][k] is query q × shard k; usage.Shards lists the products query
+// by query, with Shard the shard number. ls.mtx must be read-locked.
+func (ls *LedgerState) evalShards(cts []*rlwe.Ciphertext) ([][]*rlwe.Ciphertext, utils.EvalUsage, error) {
+	res := make([][]*rlwe.Ciphertext, len(cts))
+	fns := make([]func() error, 0, len(cts)*len(ls.m_DB))
+	for q, ct := range cts {
+		res[q] = make([]*rlwe.Ciphertext, len(ls.m_DB))
+		for k, pt := range ls.m_DB {
+			fns = append(fns, func() (err error) {
+				eval := ls.evals.Get()
+				defer ls.evals.Put(eval)
+				res[q][k], err = eval.MulNew(ct, pt)
+				return err
+			})
+		}
+	}
+	usage, shards, err := shardPool.run(fns)
+	for i := range shards {
+		shards[i].Shard = i % len(ls.m_DB)
+	}
+	usage.LogN, usage.Shards = ls.params.LogN(), shards
+	return res, usage, err
+}
+
+// evalSingle is evalShards for one ct_q against a single-plaintext m_DB,
+// for the paths that return one raw ct_r.
+func (ls *LedgerState) evalSingle(ct *rlwe.Ciphertext) (*rlwe.Ciphertext, utils.EvalUsage, error) {
+	if len(ls.m_DB) > 1 {
+		return nil, utils.EvalUsage{}, fmt.Errorf("dataset is sharded over %d plaintexts: use PIRQuery on /invoke", len(ls.m_DB))
+	}
+	res, usage, err := ls.evalShards([]*rlwe.Ciphertext{ct})
+	if err != nil {
+		return nil, usage, err
+	}
+	return res[0][0], usage, nil
+}
+
+// encodeShards marshals one query's shard results into the PIRQuery
+// response (utils.EncodeShardResults) and fills in their sizes in
+// shards, which holds the usage entries of the same products.
+func encodeShards(res []*rlwe.Ciphertext, shards []utils.ShardUsage) (string, error) {
+	out := make([]string, len(res))
+	for k, ct := range res {
+		raw, err := ct.MarshalBinary()
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal result ciphertext: %w", err)
+		}
+		if k < len(shards) {
+			shards[k].ResultBytes = len(raw)
+		}
+		out[k] = base64.StdEncoding.EncodeToString(raw)
+	}
+	return utils.EncodeShardResults(out), nil
+}
+
+// mdbBytes is the serialized size of all m_DB shards.
+func (st *dbState) mdbBytes() int {
+	n := 0
+	for _, pt := range st.m_DB {
+		n += pt.BinarySize()
+	}
+	return n
+}
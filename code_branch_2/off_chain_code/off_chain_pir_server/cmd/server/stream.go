@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"pir_shared/utils"
+)
+
+/********* STREAMING PIR ******************************************/
+
+// /invoke carries ct_q as Base64 inside a JSON body that is decoded whole:
+// at LogN=15 a ~1.4 MB string, its decoded bytes and the ciphertext are
+// all live at once, and ct_r goes back the same way. POST /pir/stream
+// takes the raw ct_q as the request body (chunked transfer encoding
+// welcome), parses the ciphertext straight off the connection
+// (utils.ReadQuery) and writes the raw ct_r back as it is serialised.
+// The dataset is the ?dataset= parameter; tokens, rate limits, ACLs and
+// the worker pool apply as to an /invoke PIRQuery. Errors before the
+// first result byte are the usual JSON {error}; the evaluation time is in
+// the X-PIR-Eval-MS header.
+
+func (s *Server) stream(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("dataset")
+	if name == "" {
+		name = defaultDataset
+	}
+	if !datasetNameRe.MatchString(name) {
+		utils.WriteErr(w, fmt.Errorf("invalid dataset name %q (want [A-Za-z0-9_-]{1,64})", name))
+		return
+	}
+	admin, id := s.isAdmin(r), s.access.identify(r)
+	if !admin && !s.limiter.allow(callerKey(id, r)) {
+		utils.WriteErrStatus(w, http.StatusTooManyRequests, fmt.Errorf("rate limit exceeded"))
+		return
+	}
+	if !admin {
+		if err := s.access.check(name, id, permQuery); err != nil {
+			utils.WriteErrStatus(w, http.StatusForbidden, err)
+			return
+		}
+	}
+	ls, ok := s.dataset(name, false)
+	if !ok {
+		utils.WriteErr(w, fmt.Errorf("unknown dataset %q", name))
+		return
+	}
+	defer s.acquireWorker()()
+	ls.pirStream(w, r.Body, id)
+}
+
+// pirStream is pirQuery over raw bytes: ct_q is read from body and ct_r
+// written to w.
+func (ls *LedgerState) pirStream(w http.ResponseWriter, body io.Reader, caller string) {
+	ls.mtx.RLock()
+	defer ls.mtx.RUnlock()
+	ls.queries.Add(1)
+
+	if ls.m_DB == nil {
+		utils.WriteErrStatus(w, http.StatusServiceUnavailable, fmt.Errorf("PIR database not initialized"))
+		return
+	}
+
+	// 1. Read the ciphertext off the connection (panic-safe, shape-checked)
+	ctQuery, n, err := utils.ReadQuery(ls.params, body)
+	if err != nil {
+		utils.WriteErr(w, err)
+		return
+	}
+
+	// 2. Perform homomorphic multiplication (ciphertext × plaintext); one
+	// raw ct_r, so a single-shard m_DB only
+	if len(ls.m_DB) > 1 {
+		utils.WriteErr(w, fmt.Errorf("dataset is sharded over %d plaintexts: use PIRQuery on /invoke", len(ls.m_DB)))
+		return
+	}
+	ctRes, usage, err := ls.evalSingle(ctQuery)
+	if err != nil {
+		utils.WriteErrStatus(w, http.StatusInternalServerError, fmt.Errorf("PIR evaluation failed: %w", err))
+		return
+	}
+	ls.usage.Add(caller, usage)
+	ls.access.AddQuery(time.Now())
+
+	// 3. Serialise the result into the response as it is written; with no
+	// Content-Length net/http sends it chunked
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-PIR-Eval-MS", strconv.FormatFloat(usage.WallMS, 'f', 3, 64))
+	bw := bufio.NewWriter(w)
+	out, err := ctRes.WriteTo(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		// the status line is out; the client sees a short body
+		log.Printf("[EVAL_STREAM] writing result: %v", err)
+		return
+	}
+	log.Printf("[EVAL_STREAM] %d bytes in, %d bytes out, eval %.3f ms (LogN=%d)",
+		n, out, usage.WallMS, ls.params.LogN())
+}
@@ -23,6 +23,10 @@ echo ""
 echo "3. Running PIRQueryAuto benchmark..."
 ./benchmark_pirqueryauto.sh
 
+echo ""
+echo "4. Running PublicQuery vs PIRQuery privacy overhead benchmark..."
+./benchmark_privacy_overhead.sh
+
 echo ""
 echo "=== ALL BENCHMARKS COMPLETE ==="
 echo "Results in: $RESULTS_DIR"
@@ -35,5 +39,9 @@ for function in InitLedger GetMetadata PIRQueryAuto; do
         echo "$function: ${avg}ms average" >> "$RESULTS_DIR/benchmark_summary.txt"
     fi
 done
+if [ -f "$RESULTS_DIR/privacy_overhead.md" ]; then
+    echo "" >> "$RESULTS_DIR/benchmark_summary.txt"
+    cat "$RESULTS_DIR/privacy_overhead.md" >> "$RESULTS_DIR/benchmark_summary.txt"
+fi
 
 cat "$RESULTS_DIR/benchmark_summary.txt"
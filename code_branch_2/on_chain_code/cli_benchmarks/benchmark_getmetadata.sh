@@ -81,7 +81,7 @@ for ((i=1; i<=EPOCHS; i++)); do
     # client timing + capture response
     start_client=$(date +%s%3N)
     response=$(./fabric-docker.sh chaincode query "peer0.org1.example.com" "channel-mini" "on_chain_pir" \
-        '{"Args":["GetMetadata"]}' 2>&1)
+        '{"Args":["GetMetadataTimed"]}' 2>&1)
     end_client=$(date +%s%3N)
     client_duration=$((end_client - start_client))
 
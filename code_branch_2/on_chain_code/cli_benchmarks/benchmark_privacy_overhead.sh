@@ -0,0 +1,136 @@
+#!/bin/bash
+set -u
+
+# Privacy overhead: per dataset configuration, time a plaintext key lookup
+# (PublicQueryTimed) against a PIR query over the same dataset
+# (PIRQueryAuto) and report PIR/plaintext ratios for latency and response
+# size. Writes per-epoch rows to privacy_overhead_raw.csv and the ratio
+# table to privacy_overhead.csv / privacy_overhead.md.
+#
+# CONFIGS is a space-separated list of n:maxJSON[:logN] (logN "" = auto).
+
+EPOCHS=${EPOCHS:-20}
+CONFIGS=${CONFIGS:-"64:128 128:128 256:128 64:256 128:256"}
+LOOKUP_INDEX=${LOOKUP_INDEX:-0}
+RESULTS_DIR="${BENCHMARK_RESULTS_DIR:-benchmark_results_$(date +%Y%m%d_%H%M%S)}"
+mkdir -p "$RESULTS_DIR"
+
+RAW_CSV="$RESULTS_DIR/privacy_overhead_raw.csv"
+TABLE_CSV="$RESULTS_DIR/privacy_overhead.csv"
+TABLE_MD="$RESULTS_DIR/privacy_overhead.md"
+
+echo "=== Benchmarking PublicQuery vs PIRQuery (privacy overhead) ==="
+echo "Results directory: $RESULTS_DIR"
+echo "Configurations: $CONFIGS"
+
+# ---- CSV headers ----
+echo "n,max_json,logn,epoch,function,execution_time_ms,client_duration_ms,response_bytes" > "$RAW_CSV"
+echo "n,max_json,logn,public_server_ms,pir_server_ms,server_ratio,public_client_ms,pir_client_ms,client_ratio,public_bytes,pir_bytes,size_ratio" > "$TABLE_CSV"
+
+# ---- helpers ----
+
+# Print the last raw JSON line of a peer CLI response, or the unescaped
+# payload:"{...}" of a wrapped one.
+extract_json() {
+    local response="$1"
+    local clean json_line
+    clean=$(printf "%s" "$response" \
+        | sed -E 's/\x1b\[[0-9;]*m//g' \
+        | tr -d '\r')
+
+    json_line=$(printf "%s\n" "$clean" | awk '/^\{/{buf=$0} END{print buf}')
+    if [ -z "$json_line" ]; then
+        json_line=$(printf "%s" "$clean" \
+            | sed -n 's/.*payload:"\({.*}\)".*/\1/p' \
+            | sed 's/\\"/"/g')
+    fi
+    [ -n "$json_line" ] || return 1
+    printf "%s" "$json_line"
+}
+
+# execution_time_ms of a TimedResponse (jq, regex fallback)
+extract_execution_time() {
+    local json="$1" t
+    if command -v jq >/dev/null 2>&1; then
+        t=$(printf "%s" "$json" | jq -r '.execution_time_ms // empty' 2>/dev/null)
+    else
+        t=$(printf "%s" "$json" | grep -oE '"execution_time_ms":[0-9.]+' | head -1 | cut -d: -f2)
+    fi
+    [ -n "$t" ] || return 1
+    printf "%s" "$t"
+}
+
+# One query: appends a raw row and prints "server_ms,client_ms,bytes".
+run_query() {
+    local cfg="$1" epoch="$2" name="$3" args="$4"
+    local start end response json t
+    start=$(date +%s%3N)
+    response=$(./fabric-docker.sh chaincode query "peer0.org1.example.com" "channel-mini" "on_chain_pir" \
+        "$args" 2>&1)
+    end=$(date +%s%3N)
+
+    json=$(extract_json "$response") || json=""
+    t=$(extract_execution_time "$json") || t=""
+    if [ -z "$t" ]; then
+        echo "$cfg,$epoch,$name,FAILED,$((end - start))," >> "$RAW_CSV"
+        return 1
+    fi
+    echo "$cfg,$epoch,$name,$t,$((end - start)),${#json}" >> "$RAW_CSV"
+    printf "%s,%s,%s" "$t" "$((end - start))" "${#json}"
+}
+
+# Mean of column col (1-based) over raw rows matching cfg and function.
+mean_of() {
+    local cfg="$1" name="$2" col="$3"
+    awk -F',' -v c="$cfg" -v f="$name" -v k="$col" \
+        '$1","$2","$3 == c && $5 == f && $6 != "FAILED" {sum+=$k; n++} END{if(n) printf "%.2f", sum/n}' "$RAW_CSV"
+}
+
+ratio() {
+    awk -v a="$1" -v b="$2" 'BEGIN{if (a != "" && b != "" && a > 0) printf "%.1f", b/a; else printf "NA"}'
+}
+
+for config in $CONFIGS; do
+    IFS=':' read -r n max_json logn <<< "$config"
+    logn=${logn:-}
+    cfg="$n,$max_json,${logn:-auto}"
+    key=$(printf "record%03d" "$LOOKUP_INDEX")
+
+    echo ""
+    echo "--- n=$n max_json=$max_json logN=${logn:-auto} ---"
+
+    # --- Fresh dataset for this configuration ---
+    ./fabric-docker.sh chaincode invoke "peer0.org1.example.com" "channel-mini" "on_chain_pir" \
+        "{\"Args\":[\"InitLedger\",\"$n\",\"$max_json\",\"$logn\",\"\",\"\",\"\"]}" "" >/dev/null 2>&1
+    sleep 3
+    ./fabric-docker.sh chaincode invoke "peer0.org1.example.com" "channel-mini" "on_chain_pir" \
+        '{"Args":["GenerateDataset"]}' "" >/dev/null 2>&1
+    sleep 3
+
+    for ((i=1; i<=EPOCHS; i++)); do
+        echo -n "Epoch $i: "
+        pub=$(run_query "$cfg" "$i" PublicQuery "{\"Args\":[\"PublicQueryTimed\",\"$key\"]}") || pub="FAILED"
+        pir=$(run_query "$cfg" "$i" PIRQuery '{"Args":["PIRQueryAuto"]}') || pir="FAILED"
+        echo "PublicQuery: ${pub}  PIRQuery: ${pir}  (server_ms,client_ms,bytes)"
+        sleep 1
+    done
+
+    pub_s=$(mean_of "$cfg" PublicQuery 6); pir_s=$(mean_of "$cfg" PIRQuery 6)
+    pub_c=$(mean_of "$cfg" PublicQuery 7); pir_c=$(mean_of "$cfg" PIRQuery 7)
+    pub_b=$(mean_of "$cfg" PublicQuery 8); pir_b=$(mean_of "$cfg" PIRQuery 8)
+    echo "$cfg,$pub_s,$pir_s,$(ratio "$pub_s" "$pir_s"),$pub_c,$pir_c,$(ratio "$pub_c" "$pir_c"),$pub_b,$pir_b,$(ratio "$pub_b" "$pir_b")" >> "$TABLE_CSV"
+done
+
+# ---- overhead table (as used in the paper) ----
+{
+    echo "| n | max_json | logN | PublicQuery server (ms) | PIRQuery server (ms) | × | PublicQuery client (ms) | PIRQuery client (ms) | × | PublicQuery size (B) | PIRQuery size (B) | × |"
+    echo "|---|---|---|---|---|---|---|---|---|---|---|---|"
+    awk -F',' 'NR>1 {printf "| %s |", $1; for (i=2; i<=NF; i++) printf " %s |", $i; printf "\n"}' "$TABLE_CSV"
+} > "$TABLE_MD"
+
+echo ""
+echo "=== PRIVACY OVERHEAD (PIRQuery / PublicQuery, means over $EPOCHS epochs) ==="
+cat "$TABLE_MD"
+
+echo ""
+echo "Benchmark complete! Results in: $RESULTS_DIR"
@@ -3,132 +3,177 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
+	"strconv"
 	"time"
 
+	"on-chain-pir-client/internal/config"
 	"on-chain-pir-client/internal/cpir"
 	"on-chain-pir-client/internal/fabgw"
+	"pir_shared/gen_records"
+	"pir_shared/utils"
 
 	"github.com/hyperledger/fabric-gateway/pkg/client"
-	"github.com/hyperledger/fabric-gateway/pkg/hash"
 )
 
 // ----------------------------------------------------------
 // Configuration
 // ----------------------------------------------------------
 
-var (
-	// compile-time constants are fine here
-	mspID         = "Org1MSP"
-	peerEndpoint  = "localhost:7041"
-	gatewayPeer   = "peer0.org1.example.com"
-	channelName   = "channel-mini"
-	chaincodeName = "on_chain_pir"
-
-	// to be filled at runtime in init()
-	cryptoPath  string
-	certPath    string
-	keyDir      string
-	tlsCertPath string
-)
+// The channel, orgs and dataset come from internal/config: the fablo
+// layout under $HOME and the sample dataset by default, overridden by a
+// JSON/YAML file (-config, $PIR_CLIENT_CONFIG), PIR_* variables and flags,
+// e.g. "client -peer localhost:7061 -n 256 -index 42".
 
-func init() {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		log.Fatalf("cannot resolve home dir: %v", err)
-	}
-
-	// Build paths at runtime (no ~)
-	cryptoPath = filepath.Join(
-		home,
-		"fablo_test", "fablo-target", "fabric-config", "crypto-config",
-		"peerOrganizations", "org1.example.com",
-	)
-	certPath = filepath.Join(cryptoPath, "users", "User1@org1.example.com", "msp", "signcerts")
-	keyDir = filepath.Join(cryptoPath, "users", "User1@org1.example.com", "msp", "keystore")
-	tlsCertPath = filepath.Join(cryptoPath, "peers", "peer0.org1.example.com", "tls", "ca.crt")
+var timeouts = fabgw.Timeouts{
+	Evaluate:     5 * time.Second,
+	Endorse:      15 * time.Second,
+	Submit:       5 * time.Second,
+	CommitStatus: 1 * time.Minute,
 }
 
 func main() {
-	log.Println("MSP:", mspID)
-	log.Println("cryptoPath:", cryptoPath)
-	log.Println("certPath:", certPath)
-	log.Println("keyDir:", keyDir)
-	log.Println("tlsCertPath:", tlsCertPath)
-	log.Println("peerEndpoint:", peerEndpoint)
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:], config.All)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	flag.Parse()
+	channelName, chaincodeName := cfg.Channel, cfg.Chaincode
+	// org1 runs every client role; org2 co-endorses the audited query of
+	// step 8 (skipped when its crypto material is not there)
+	org1, org2 := cfg.Org, cfg.Endorser
+
+	log.Println("MSP:", org1.MSPID)
+	log.Println("cryptoPath:", org1.CryptoPath)
+	log.Println("mspDir:", org1.MSPDir())
+	log.Println("tlsCertPath:", org1.TLSCACert())
+	log.Println("peerEndpoint:", org1.PeerEndpoint)
 
 	// 1) Fabric Gateway connection (TLS + identity + signer)
-	conn, err := fabgw.NewConnection(peerEndpoint, tlsCertPath, gatewayPeer)
-	fabgw.Must(err, "dial gateway")
-	defer conn.Close()
-
-	id, err := fabgw.NewIdentityFromDir(mspID, certPath)
-	fabgw.Must(err, "load identity")
-
-	sign, err := fabgw.NewSignerFromKeyDir(keyDir)
-	fabgw.Must(err, "load signer")
-
-	gw, err := client.Connect(
-		id,
-		client.WithSign(sign),
-		client.WithHash(hash.SHA256),
-		client.WithClientConnection(conn),
-		client.WithEvaluateTimeout(5*time.Second),
-		client.WithEndorseTimeout(15*time.Second),
-		client.WithSubmitTimeout(5*time.Second),
-		client.WithCommitStatusTimeout(1*time.Minute),
-	)
+	gw, closeGW, err := org1.Connect(timeouts)
 	fabgw.Must(err, "connect gateway")
-	defer gw.Close()
+	defer closeGW()
 
 	network := gw.GetNetwork(channelName)
 	contract := network.GetContract(chaincodeName)
 
-	// --- Set parameters --- Please follow the Feasible Parameters table in the README.md
-	const dbSize = 64         // set the total number of records in the DB: 100, 256, or 512 (necessary param)
-	const maxJSONlength = 128 // set the max JSON length: 64, 128, 224, 256, 384, or 512 (necessary param)
-	const logN = ""           // set the HE parameter LogN: 13, 14, or 15
-	const logQi = ""          // set the HE parameter logQi as JSON array, or "" to use default (optional param)
-	const logPi = ""          // set the HE parameter logPi as JSON array, or "" to use default (optional param)
-	const t = ""              // set the HE parameter plaintext modulus t, or 0 to use default (optional param)
-	const targetIndex = 13    // set the index of the record to be retrieved: 0..dbSize-1 (necessary param)
+	// PIR_RECORD=<file>: save the evaluate calls of clients 2 and 3 as a
+	// replay trace (sizes and timing only) for "pirctl replay -trace"
+	var ev cpir.Evaluator = contract
+	if path := os.Getenv("PIR_RECORD"); path != "" {
+		rec := utils.NewTraceRecorder()
+		ev = cpir.RecordingEvaluator{Evaluator: contract, Recorder: rec}
+		defer func() { fabgw.Must(utils.SaveTrace(path, rec.Trace()), "save trace") }()
+	}
+
+	// PIR_EXPORT=<file>: append the records of steps 5 and 8 with their
+	// receipts (tx, block, m_DB version and hash, timestamps) as JSON Lines
+	var export *cpir.ExportWriter
+	if path := os.Getenv("PIR_EXPORT"); path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		fabgw.Must(err, "open export file")
+		defer f.Close()
+		export = cpir.NewExportWriter(f)
+	}
+
+	// 0) Compatibility probe and feature handshake; stops here when this
+	// client cannot talk to the chaincode (older chaincode without Probe →
+	// GetCapabilities, or the legacy set)
+	fmt.Println("\n--> Evaluate Transaction: Probe")
+	probe, err := cpir.ProbeServer(contract)
+	fabgw.Must(err, "Probe failed")
+	caps := probe.Capabilities
+	fmt.Printf("*** protocol v%d features=%v packing=%v max_shards=%d he=%s\n",
+		caps.Version, caps.Names, caps.Packing, caps.MaxShards, caps.HE)
+
+	// --- Set parameters --- -n, -max-json, ... or the config's dataset
+	// section (config.Dataset). Please follow the Feasible Parameters table
+	// in the README.md
+	ds := cfg.Dataset
+	dbSize, maxJSONlength := ds.NRecords, ds.MaxJSON           // records in the DB, max JSON length (necessary params)
+	logN, logQi, logPi, t := ds.LogN, ds.LogQi, ds.LogPi, ds.T // HE parameters, "" for auto / default
+	targetIndex := ds.Index                                    // record to be retrieved: 0..dbSize-1
+	chunkSize, localEncode := ds.ChunkSize, ds.LocalEncode     // chunked upload (InitBegin/...), or PutMDB of a local m_DB
+	recordsFile := ds.RecordsFile                              // real records for LoadRecordsFromJSON; "" → synthetic records
+	heScheme, packing := ds.Scheme, ds.Packing                 // SetScheme / SetPacking; "" for BGV, 1b
 
 	// 1) Client 1: Init ledger with sample data (pick params that fit logN=13 capacity)
-	fmt.Println("\n--> Submit Transaction: InitLedger")
-	// pass: n, maxJSON, logN="", logQi="[]", logPi="[]", t=""
-	_, err = contract.SubmitTransaction("InitLedger",
-		fmt.Sprintf("%d", dbSize),
-		fmt.Sprintf("%d", maxJSONlength),
-		logN,
-		logQi,
-		logPi,
-		t)
-	//_, err = contract.SubmitTransaction("InitLedger", "32", "224", "", "[]", "[]", "")
-	fabgw.Must(err, "InitLedger failed")
-
-	fmt.Println("*** InitLedger committed")
+	switch {
+	case recordsFile != "":
+		fmt.Println("\n--> Submit Transaction: LoadRecordsFromJSON")
+		records, err := readRecords(recordsFile)
+		fabgw.Must(err, "read records")
+		rejected, err := fabgw.LoadRecords(contract, fabgw.InitParams{
+			MaxJSON: maxJSONlength, LogN: logN, LogQi: logQi, LogPi: logPi, T: t,
+		}, records)
+		fabgw.Must(err, "LoadRecordsFromJSON failed")
+		for _, r := range rejected {
+			fmt.Printf("*** record %d rejected: %s\n", r.Index, r.Reason)
+		}
+		fmt.Printf("*** LoadRecordsFromJSON committed: %d records\n", len(records)-len(rejected))
+	case localEncode:
+		fmt.Println("\n--> Encode m_DB locally, Submit Transactions: PutMDB")
+		fabgw.Must(putLocalDB(contract, dbSize, maxJSONlength, logN), "PutMDB failed")
+		fmt.Println("*** PutMDB committed")
+	case chunkSize > 0:
+		fmt.Println("\n--> Submit Transactions: InitBegin / InitAddRecords / InitCommit")
+		rejected, err := fabgw.InitChunked(contract, fabgw.InitParams{
+			NRecords: dbSize, MaxJSON: maxJSONlength, LogN: logN, LogQi: logQi, LogPi: logPi, T: t, Scheme: heScheme, Packing: packing,
+		}, func(logN int) ([][]byte, error) {
+			return gen_records.GenerateRecords(dbSize, logN, maxJSONlength)
+		}, chunkSize)
+		fabgw.Must(err, "chunked init failed")
+		for _, r := range rejected {
+			fmt.Printf("*** record %d rejected: %s\n", r.Index, r.Reason)
+		}
+		fmt.Println("*** InitCommit committed")
+	default:
+		// InitLedger only fixes the params; GenerateDataset generates and
+		// packs the records. On "capacity exceeded" both are retried with
+		// the advisor's next layout (larger logN, more shards).
+		fmt.Println("\n--> Submit Transactions: InitLedger / GenerateDataset")
+		plan, err := fabgw.InitNegotiated(contract, fabgw.InitParams{
+			NRecords: dbSize, MaxJSON: maxJSONlength, LogN: logN, LogQi: logQi, LogPi: logPi, T: t, Scheme: heScheme, Packing: packing, Seed: ds.Seed,
+		}, caps)
+		fabgw.Must(err, "InitLedger failed")
+
+		fmt.Println("*** GenerateDataset committed:", plan)
+		if ds.Seed != "" {
+			report, err := fabgw.VerifyMDBHash(contract, "", true)
+			fabgw.Must(err, "VerifyMDBHash failed")
+			fmt.Printf("*** m_DB_sha256 %s (%s, seed %q, regenerated on the gateway peer: match=%v)\n", report.Committed, report.Alg, report.Seed, report.Match)
+		}
+	}
 
 	// 2) Client 2: Discovers metadata parameters
 	fmt.Println("\n--> Evaluate Transaction: GetMetadata")
-	metaRaw, err := contract.EvaluateTransaction("GetMetadata")
+	metaRaw, err := ev.EvaluateTransaction("GetMetadata")
 	fabgw.Must(err, "GetMetadata failed")
 
-	var wrap struct {
-		Metadata        cpir.Metadata `json:"metadata"`
-		ExecutionTimeMS float64       `json:"execution_time_ms"`
-	}
-	if err := json.Unmarshal(metaRaw, &wrap); err != nil {
-		fabgw.Must(err, "failed to parse GetMetadata wrapper JSON")
-	}
+	meta, serverMS, err := cpir.ParseMetadata(metaRaw)
+	fabgw.Must(err, "failed to parse GetMetadata JSON")
 
-	meta := wrap.Metadata
-	fmt.Printf("*** n=%d  s=%d  logN=%d  N=%d  t=%d  logQi=%v  logPi=%v (server %.3f ms)\n",
-		meta.NRecords, meta.RecordS, meta.LogN, meta.N, meta.T, meta.LogQi, meta.LogPi, wrap.ExecutionTimeMS)
+	fmt.Printf("*** n=%d  s=%d  logN=%d  N=%d  t=%d  logQi=%v  logPi=%v  scheme=%s  packing=%s (server %.3f ms)\n",
+		meta.NRecords, meta.RecordS, meta.LogN, meta.N, meta.T, meta.LogQi, meta.LogPi, meta.HEScheme(), meta.PackingMode(), serverMS)
+
+	// BFV / CKKS datasets: one PIR round trip through the he engine; the
+	// BGV walkthrough below (window table, timed and audited queries) is
+	// written against lattigo's bgv package
+	if meta.HEScheme() != utils.SchemeBGV {
+		fmt.Printf("\n--> %s dataset: PIRQuery for index %d\n", meta.HEScheme(), targetIndex)
+		pc := cpir.NewClient(ev)
+		pc.FullDownloadMaxBytes = -1
+		rec, err := pc.Fetch(targetIndex)
+		fabgw.Must(err, "PIRQuery failed")
+		fmt.Printf("*** Retrieved record %d (%d bytes received): %s\n", rec.Index, rec.Bytes, rec.JSONString)
+		return
+	}
 
 	// 3) Client 2: Build HE params/keys from server metadata (parity with off-chain)
+	cpir.SeedFromEnv() // PIR_RESEARCH_SEED; after the gateway handshake, so TLS is not seeded
 	params, sk, pk, err := cpir.GenKeysFromMetadata(meta)
 	fabgw.Must(err, "GenKeysFromMetadata failed")
 
@@ -138,11 +183,34 @@ func main() {
 	fmt.Printf("*** serverDbSize = %d\n", serverDbSize)
 	fmt.Printf("*** slotsPerRec = %d\n", slotsPerRec)
 
-	// Optional sanity read
-	fmt.Println("\n--> Evaluate Transaction: PublicQuery(record013)")
-	qRes, err := contract.EvaluateTransaction("PublicQuery", "record013")
-	fabgw.Must(err, "PublicQuery failed")
-	fmt.Println("*** record013 =", string(qRes))
+	// Optional sanity read (PutMDB stores no records)
+	if !localEncode {
+		key := utils.RecordKey(targetIndex)
+		fmt.Printf("\n--> Evaluate Transaction: PublicQuery(%s)\n", key)
+		qRes, err := contract.EvaluateTransaction("PublicQuery", key)
+		fabgw.Must(err, "PublicQuery failed")
+		fmt.Println("***", key, "=", string(qRes))
+	}
+
+	// Cross-check the selector window against the chaincode's packing
+	fmt.Println("\n--> Evaluate Transaction: DescribeSelector")
+	layoutRaw, err := contract.EvaluateTransaction("DescribeSelector", fmt.Sprintf("%d", targetIndex))
+	fabgw.Must(err, "DescribeSelector failed")
+	fabgw.Must(cpir.CheckSelector(meta, targetIndex, layoutRaw), "selector layout mismatch")
+	fmt.Println("*** selector =", string(layoutRaw))
+
+	// Encode the selector ahead of time from the published window table,
+	// so the query below only encrypts
+	if caps.Has(utils.FeatWindowTable) {
+		fmt.Println("\n--> Evaluate Transaction: GetWindowTable")
+		tableRaw, err := contract.EvaluateTransaction("GetWindowTable", "false")
+		fabgw.Must(err, "GetWindowTable failed")
+		table, err := cpir.ParseWindowTable(tableRaw)
+		fabgw.Must(err, "bad window table")
+		fabgw.Must(cpir.UseWindowTable(params, table), "window table does not match metadata")
+		fabgw.Must(cpir.PrecomputeSelector(targetIndex), "PrecomputeSelector failed")
+		fmt.Printf("*** layout_id=%.12s version=%d: selector %d precomputed\n", table.LayoutID, table.Version, targetIndex)
+	}
 
 	// 4) Client 2: CPIR: encrypt → evaluate → decrypt
 	fmt.Println("\n--> Encrypting PIR query for index", targetIndex)
@@ -150,15 +218,124 @@ func main() {
 	fabgw.Must(err, "EncryptQueryBase64 failed")
 
 	fmt.Println("\n--> Evaluate Transaction: PIRQuery")
-	encResB64Bytes, err := contract.EvaluateTransaction("PIRQuery", encQueryB64)
+	encResB64Bytes, err := ev.EvaluateTransaction("PIRQuery", encQueryB64)
 	fabgw.Must(err, "PIRQuery failed")
 
 	encResB64 := string(encResB64Bytes)
 	fmt.Printf("*** Encrypted response (B64 len=%d)\n", len(encResB64))
 
 	fmt.Println("\n--> Decrypting PIR result")
-	decoded, err := cpir.DecryptResult(params, sk, encResB64, targetIndex, serverDbSize, slotsPerRec)
+	decoded, err := cpir.DecryptResultPacked(params, sk, encResB64, targetIndex, serverDbSize, slotsPerRec, meta.Packing)
 	fabgw.Must(err, "DecryptResult failed")
 	fmt.Println("*** PIR JSON =", decoded.JSONString)
 
+	// 5) Client 2: same record through cpir.Client, which ships small
+	// datasets whole instead of running HE-PIR
+	fmt.Println("\n--> cpir.Client.Fetch", targetIndex)
+	fc := cpir.NewClient(ev)
+	fc.OnRecord(func(rec *cpir.Record) error { // post-processing hook, e.g. enrichment
+		rec.Annotate("json_bytes", len(rec.JSONString))
+		return nil
+	})
+	rec, receipt, err := fc.FetchReceipt(targetIndex)
+	fabgw.Must(err, "Fetch failed")
+	fmt.Printf("*** path=%s received=%d bytes JSON = %s annotations=%v\n", rec.Path, rec.Bytes, rec.JSONString, rec.Annotations)
+	if export != nil {
+		fabgw.Must(export.Write(rec, receipt), "export record")
+	}
+
+	// 6) Client 2: hybrid client - the first Fetch downloads the dataset,
+	// later ones only run PIR over records changed since (GetDelta)
+	fmt.Println("\n--> cpir.Client{Hybrid}.Fetch", targetIndex, "x2")
+	hc := &cpir.Client{Contract: ev, Hybrid: true}
+	for i := 0; i < 2; i++ {
+		rec, err := hc.Fetch(targetIndex)
+		if err != nil {
+			fmt.Println("*** hybrid fetch failed:", err) // e.g. dataset above PIR_FULL_DOWNLOAD_MAX_BYTES
+			break
+		}
+		fmt.Printf("*** path=%s received=%d bytes\n", rec.Path, rec.Bytes)
+	}
+
+	// 7) Client 3: cold start with the metadata saved in step 2 - ct_q
+	// goes out with MetaAndQuery, one round trip for metadata and record
+	fmt.Println("\n--> cpir.Client{MetaHint}.Fetch", targetIndex, "(Evaluate Transaction: MetaAndQuery)")
+	cc := &cpir.Client{Contract: ev, FullDownloadMaxBytes: -1, MetaHint: &meta}
+	rec, err = cc.Fetch(targetIndex)
+	fabgw.Must(err, "cold Fetch failed")
+	fmt.Printf("*** path=%s received=%d bytes JSON = %s\n", rec.Path, rec.Bytes, rec.JSONString)
+
+	// 8) Two roles in one run: the query of step 4 is evaluated at org1,
+	// and its audit record is submitted through org2's gateway and
+	// endorsed by org1 and org2, both checking they reproduce org1's ct_r
+	if _, err := os.Stat(org2.MSPDir()); err != nil {
+		fmt.Println("\n*** skipping the two-org audited query:", err)
+		return
+	}
+	fmt.Println("\n--> Evaluate PIRQuery at", org1.MSPID, "/ Submit PIRQuerySubmit via", org2.MSPID, "endorsed by both")
+	gw2, closeGW2, err := org2.Connect(timeouts)
+	fabgw.Must(err, "connect org2 gateway")
+	defer closeGW2()
+	requested := time.Now().UTC()
+	flow, err := fabgw.AuditedQueryFlow(contract, org1.MSPID,
+		gw2.GetNetwork(channelName).GetContract(chaincodeName),
+		[]string{org1.MSPID, org2.MSPID}, encQueryB64, nil)
+	fabgw.Must(err, "audited query flow failed")
+	auditRaw, err := contract.EvaluateTransaction("GetAuditRecord", flow.TxID)
+	fabgw.Must(err, "GetAuditRecord failed")
+	fmt.Printf("*** flow=%s tx=%s block=%d ct_r matches step 4: %v\n*** audit record = %s\n",
+		flow.Flow.ID, flow.TxID, flow.Block, flow.EncResB64 == encResB64, auditRaw)
+	if export != nil {
+		receipt, err := cpir.ParseAuditReceipt(auditRaw)
+		fabgw.Must(err, "audit receipt")
+		receipt.Block, receipt.RequestedAt, receipt.ReceivedAt = flow.Block, requested, time.Now().UTC()
+		decoded, err := cpir.DecryptResultPacked(params, sk, flow.EncResB64, targetIndex, serverDbSize, slotsPerRec, meta.Packing)
+		fabgw.Must(err, "DecryptResult of the audited query failed")
+		audited := cpir.Record{Index: targetIndex, JSONString: decoded.JSONString, Path: cpir.PathPIR, Bytes: len(flow.EncResB64)}
+		fabgw.Must(export.Write(audited, receipt), "export record")
+		fmt.Printf("*** exported %d records to %s\n", export.Count(), os.Getenv("PIR_EXPORT"))
+	}
+}
+
+// putLocalDB generates the sample records, encodes m_DB with the same
+// packing as the chaincode and installs it with PutMDB.
+func putLocalDB(contract *client.Contract, n, maxJSON int, logNStr string) error {
+	logN, err := strconv.Atoi(logNStr)
+	if err != nil {
+		plan, err := utils.PlanLogN(n, utils.RoundRecordS(maxJSON), utils.PlanOptions{MaxShards: 1, AllowLogN16: true})
+		if err != nil {
+			return err
+		}
+		logN = plan.LogN
+	}
+	params, err := utils.BuildParamsFromHint(utils.BGVParamHint{LogN: logN})
+	if err != nil {
+		return err
+	}
+	records, err := gen_records.GenerateRecords(n, logN, maxJSON)
+	if err != nil {
+		return err
+	}
+	mdb, meta, err := cpir.EncodeDB(params, records)
+	if err != nil {
+		return err
+	}
+	return fabgw.PutMDB(contract, mdb, meta, 0)
+}
+
+// readRecords reads a JSON array of record objects from path.
+func readRecords(path string) ([][]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var arr []json.RawMessage
+	if err := json.Unmarshal(raw, &arr); err != nil {
+		return nil, fmt.Errorf("%s: want a JSON array of records: %w", path, err)
+	}
+	records := make([][]byte, len(arr))
+	for i, r := range arr {
+		records[i] = r
+	}
+	return records, nil
 }
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"on-chain-pir-client/internal/cpir"
+	"pir_shared/he"
+	"pir_shared/utils"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/********* CONFORMANCE *********************************************/
+
+// The conformance suite only evaluates (no transaction is submitted), so
+// it is safe against a production channel. Checks run in order and later
+// ones are skipped when what they need (metadata, keys) failed earlier:
+//
+//	capabilities  Probe (or GetCapabilities) speaks a known protocol version and HE library
+//	metadata      GetMetadata is self-consistent, matches the Probe params hash and builds params
+//	capacity      n × stride fits N slots × max_shards (IndexContract)
+//	selector/*    DescribeSelector matches the client window (first/middle/last)
+//	roundtrip/*   ct_q → PIRQuery → decrypt equals PublicQuery (first/middle/last)
+//	error/*       malformed input is rejected with an error, not a hang or crash
+//	liveness      GetMetadata still answers after the error checks
+
+// checkResult is one line of the report.
+type checkResult struct {
+	Name   string  `json:"name"`
+	Status string  `json:"status"` // PASS, FAIL, SKIP
+	Detail string  `json:"detail,omitempty"`
+	MS     float64 `json:"ms"`
+}
+
+// conformanceReport is what -json writes.
+type conformanceReport struct {
+	Suite   string        `json:"suite"` // "conformance" or "demo"
+	Target  string        `json:"target"`
+	Started time.Time     `json:"started"`
+	Passed  int           `json:"passed"`
+	Failed  int           `json:"failed"`
+	Skipped int           `json:"skipped"`
+	Checks  []checkResult `json:"checks"`
+}
+
+// errSkip marks a check that could not run.
+type errSkip string
+
+func (e errSkip) Error() string { return string(e) }
+
+type conformance struct {
+	contract cpir.Evaluator
+	report   conformanceReport
+
+	caps  cpir.Capabilities
+	probe cpir.Probe
+	meta  *cpir.Metadata
+}
+
+func runConformance(args []string) int {
+	fs := flag.NewFlagSet("conformance", flag.ExitOnError)
+	var t target
+	t.register(fs, args)
+	jsonOut := fs.String("json", "", "also write the report as JSON to this file")
+	fs.Parse(args)
+
+	contract, closeFn, err := t.connect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connect: %v\n", err)
+		return 2
+	}
+	defer closeFn()
+
+	c := &conformance{contract: contract}
+	c.report.Suite = "conformance"
+	c.report.Target = fmt.Sprintf("%s %s/%s", t.Org.PeerEndpoint, t.Channel, t.Chaincode)
+	c.run()
+	return c.finish(*jsonOut)
+}
+
+// finish prints the report, writes it to jsonOut if set, and returns the
+// exit code: 1 when a check failed.
+func (c *conformance) finish(jsonOut string) int {
+	c.print(os.Stdout)
+	if jsonOut != "" {
+		raw, _ := json.MarshalIndent(c.report, "", "  ")
+		if err := os.WriteFile(jsonOut, raw, 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "write report: %v\n", err)
+			return 2
+		}
+	}
+	if c.report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// run executes the whole suite.
+func (c *conformance) run() {
+	c.report.Started = time.Now().UTC()
+	c.check("capabilities", c.checkCapabilities)
+	c.check("metadata", c.checkMetadata)
+	c.check("capacity", c.checkCapacity)
+
+	indices := c.probeIndices()
+	for _, p := range indices {
+		idx := p.index
+		c.check("selector/"+p.name, func() (string, error) { return c.checkSelector(idx) })
+	}
+	for _, p := range indices {
+		idx := p.index
+		c.check("roundtrip/"+p.name, func() (string, error) { return c.checkRoundTrip(idx) })
+	}
+	c.checkErrors()
+	c.check("liveness", func() (string, error) {
+		_, err := c.contract.EvaluateTransaction("GetMetadata")
+		return "", err
+	})
+}
+
+// check runs fn and appends its outcome.
+func (c *conformance) check(name string, fn func() (string, error)) {
+	start := time.Now()
+	detail, err := fn()
+	res := checkResult{Name: name, Status: "PASS", Detail: detail, MS: float64(time.Since(start).Microseconds()) / 1e3}
+	var skip errSkip
+	switch {
+	case errors.As(err, &skip):
+		res.Status, res.Detail = "SKIP", skip.Error()
+		c.report.Skipped++
+	case err != nil:
+		res.Status, res.Detail = "FAIL", err.Error()
+		c.report.Failed++
+	default:
+		c.report.Passed++
+	}
+	c.report.Checks = append(c.report.Checks, res)
+}
+
+func (c *conformance) print(f *os.File) {
+	w := tabwriter.NewWriter(f, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s: %s\n\n", c.report.Suite, c.report.Target)
+	for _, r := range c.report.Checks {
+		fmt.Fprintf(w, "%s\t%s\t%.1f ms\t%s\n", r.Status, r.Name, r.MS, r.Detail)
+	}
+	fmt.Fprintf(w, "\n%d passed, %d failed, %d skipped\n", c.report.Passed, c.report.Failed, c.report.Skipped)
+	w.Flush()
+}
+
+func (c *conformance) checkCapabilities() (string, error) {
+	probe, err := cpir.ProbeServer(c.contract)
+	if err != nil {
+		return "", err
+	}
+	c.caps, c.probe = probe.Capabilities, probe
+	caps := probe.Capabilities
+	detail := fmt.Sprintf("v%d features=%v", caps.Version, caps.Names)
+	if caps.HE != "" {
+		detail += " he=" + caps.HE
+	}
+	if probe.ParamsHash != "" {
+		detail += fmt.Sprintf(" params=%.12s", probe.ParamsHash)
+	}
+	if probe.Legacy {
+		detail += " (legacy server, no Probe)"
+	}
+	return detail, nil
+}
+
+func (c *conformance) checkMetadata() (string, error) {
+	raw, err := c.contract.EvaluateTransaction("GetMetadata")
+	if err != nil {
+		return "", err
+	}
+	meta, _, err := cpir.ParseMetadata(raw)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case meta.NRecords <= 0 || meta.RecordS <= 0:
+		return "", fmt.Errorf("n=%d record_s=%d must be positive", meta.NRecords, meta.RecordS)
+	case meta.LogN <= 0 || meta.N != 1<<meta.LogN:
+		return "", fmt.Errorf("N=%d is not 2^logN (logN=%d)", meta.N, meta.LogN)
+	case meta.T <= 1:
+		return "", fmt.Errorf("plaintext modulus t=%d", meta.T)
+	}
+	params, err := he.Default.NewParams(utils.HintFromMetadata(meta))
+	if err != nil {
+		return "", fmt.Errorf("params: %w", err)
+	}
+	if params.MaxSlots() != meta.Slots() {
+		return "", fmt.Errorf("%s params have %d slots, metadata %d (N=%d)", meta.HEScheme(), params.MaxSlots(), meta.Slots(), meta.N)
+	}
+	if len(meta.LogQi) > 0 && fmt.Sprint(params.LogQi()) != fmt.Sprint(meta.LogQi) {
+		return "", fmt.Errorf("logQi %v builds %v", meta.LogQi, params.LogQi())
+	}
+	if err := c.probe.CheckParams(meta); err != nil {
+		return "", err
+	}
+	if c.caps.Has(utils.FeatTimed) {
+		raw, err := c.contract.EvaluateTransaction("GetMetadataTimed")
+		if err != nil {
+			return "", fmt.Errorf("GetMetadataTimed: %w", err)
+		}
+		timed, ms, err := cpir.ParseMetadata(raw)
+		if err != nil || ms < 0 || timed.NRecords != meta.NRecords || timed.RecordS != meta.RecordS {
+			return "", fmt.Errorf("GetMetadataTimed disagrees with GetMetadata (err=%v, ms=%.3f)", err, ms)
+		}
+	}
+	c.meta = &meta
+	return fmt.Sprintf("n=%d record_s=%d logN=%d t=%d logQi=%v logPi=%v scheme=%s",
+		meta.NRecords, meta.RecordS, meta.LogN, meta.T, meta.LogQi, meta.LogPi, meta.HEScheme()), nil
+}
+
+func (c *conformance) checkCapacity() (string, error) {
+	if c.meta == nil {
+		return "", errSkip("no metadata")
+	}
+	ic := utils.NewIndexContract(*c.meta)
+	shards := c.caps.MaxShards
+	if err := utils.CheckCapacity(ic.NRecords, ic.RecordS, utils.LogSlots(c.meta.Slots()), shards); err != nil {
+		return "", err
+	}
+	if shards <= 1 {
+		if err := ic.Validate(); err != nil {
+			return "", err
+		}
+	}
+	used, avail := ic.NRecords*ic.Stride(), ic.Shards()*ic.Slots
+	return fmt.Sprintf("%d × %d = %d of %d slots in %d shard(s) (%.1f%%), max_shards=%d",
+		ic.NRecords, ic.Stride(), used, avail, ic.Shards(), 100*float64(used)/float64(avail), shards), nil
+}
+
+type probe struct {
+	name  string
+	index int
+}
+
+// probeIndices are the first, middle and last record (deduplicated for
+// tiny datasets).
+func (c *conformance) probeIndices() []probe {
+	if c.meta == nil {
+		return []probe{{"first", 0}}
+	}
+	n := c.meta.NRecords
+	out := []probe{{"first", 0}}
+	if n > 2 {
+		out = append(out, probe{"middle", n / 2})
+	}
+	if n > 1 {
+		out = append(out, probe{"last", n - 1})
+	}
+	return out
+}
+
+func (c *conformance) checkSelector(index int) (string, error) {
+	if c.meta == nil {
+		return "", errSkip("no metadata")
+	}
+	raw, err := c.contract.EvaluateTransaction("DescribeSelector", strconv.Itoa(index))
+	if err != nil {
+		return "", err
+	}
+	if err := cpir.CheckSelector(*c.meta, index, raw); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("index %d → %s", index, raw), nil
+}
+
+func (c *conformance) checkRoundTrip(index int) (string, error) {
+	if c.meta == nil {
+		return "", errSkip("no metadata")
+	}
+	keys, err := cpir.GenSchemeKeys(*c.meta)
+	if err != nil {
+		return "", err
+	}
+	ctQ, _, err := keys.EncryptQuery(*c.meta, index)
+	if err != nil {
+		return "", err
+	}
+	ctR, err := c.contract.EvaluateTransaction("PIRQuery", ctQ)
+	if err != nil {
+		return "", fmt.Errorf("PIRQuery: %w", err)
+	}
+	dec, err := keys.DecryptResult(*c.meta, string(ctR), index)
+	if err != nil {
+		return "", fmt.Errorf("decrypt: %w", err)
+	}
+
+	want, err := c.contract.EvaluateTransaction("PublicQuery", utils.RecordKey(index))
+	if err != nil {
+		// PutMDB datasets keep no records; fall back to a shape check
+		if !json.Valid([]byte(dec.JSONString)) {
+			return "", fmt.Errorf("decrypted record is not JSON: %q", dec.JSONString)
+		}
+		return fmt.Sprintf("index %d: %d bytes, valid JSON (no public record to compare)", index, len(dec.JSONString)), nil
+	}
+	if dec.JSONString != string(utils.TrimPadding(want)) {
+		return "", fmt.Errorf("PIR returned %q, PublicQuery %q", dec.JSONString, want)
+	}
+	return fmt.Sprintf("index %d: %d bytes, matches PublicQuery", index, len(dec.JSONString)), nil
+}
+
+// checkErrors sends malformed input and expects each call to fail with a
+// chaincode error. A transport status (Unavailable, DeadlineExceeded)
+// means the peer hung or the chaincode died, and fails the check.
+func (c *conformance) checkErrors() {
+	n := 0
+	if c.meta != nil {
+		n = c.meta.NRecords
+	}
+	cases := []struct {
+		name string
+		fn   string
+		args func() ([]string, error)
+	}{
+		{"selector_negative", "DescribeSelector", fixed("-1")},
+		{"selector_past_end", "DescribeSelector", fixed(strconv.Itoa(n))},
+		{"selector_not_int", "DescribeSelector", fixed("x")},
+		{"public_empty_key", "PublicQuery", fixed("")},
+		{"public_state_key", "PublicQuery", fixed("m_DB")},
+		{"public_past_end", "PublicQuery", fixed(utils.RecordKey(n))},
+		{"query_empty", "PIRQuery", fixed("")},
+		{"query_not_base64", "PIRQuery", fixed("!!not-base64!!")},
+		{"query_truncated", "PIRQuery", c.malformedQuery(true)},
+		{"query_garbage", "PIRQuery", c.malformedQuery(false)},
+	}
+	for _, tc := range cases {
+		tc := tc
+		c.check("error/"+tc.name, func() (string, error) {
+			args, err := tc.args()
+			if err != nil {
+				return "", err
+			}
+			_, err = c.contract.EvaluateTransaction(tc.fn, args...)
+			if err == nil {
+				return "", fmt.Errorf("%s(%s) succeeded", tc.fn, abbrev(args))
+			}
+			st, _ := status.FromError(err)
+			switch st.Code() {
+			case codes.Unavailable, codes.DeadlineExceeded, codes.Canceled:
+				return "", fmt.Errorf("%s: %s", st.Code(), st.Message())
+			}
+			return fmt.Sprintf("%s: %s", st.Code(), firstLine(st.Message())), nil
+		})
+	}
+}
+
+func fixed(args ...string) func() ([]string, error) {
+	return func() ([]string, error) { return args, nil }
+}
+
+// malformedQuery returns a ct_q one byte short of the committed size
+// (truncated) or exactly the right size but random (garbage).
+func (c *conformance) malformedQuery(truncated bool) func() ([]string, error) {
+	return func() ([]string, error) {
+		if c.meta == nil {
+			return nil, errSkip("no metadata")
+		}
+		keys, err := cpir.GenSchemeKeys(*c.meta)
+		if err != nil {
+			return nil, err
+		}
+		ctQ, size, err := keys.EncryptQuery(*c.meta, 0)
+		if err != nil {
+			return nil, err
+		}
+		raw, _ := base64.StdEncoding.DecodeString(ctQ)
+		if truncated {
+			return []string{base64.StdEncoding.EncodeToString(raw[:size-1])}, nil
+		}
+		garbage := make([]byte, size)
+		rand.Read(garbage)
+		return []string{base64.StdEncoding.EncodeToString(garbage)}, nil
+	}
+}
+
+func abbrev(args []string) string {
+	s := strings.Join(args, ",")
+	if len(s) > 24 {
+		return s[:24] + "…"
+	}
+	return s
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	if len(s) > 120 {
+		s = s[:120] + "…"
+	}
+	return s
+}
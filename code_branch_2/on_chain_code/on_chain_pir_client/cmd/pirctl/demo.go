@@ -0,0 +1,197 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+
+	"on-chain-pir-client/internal/cpir"
+	"on-chain-pir-client/internal/fabgw"
+	"pir_shared/utils"
+)
+
+/********* DEMO ****************************************************/
+
+// The demo runs what cmd/client walks through, as checks with the
+// conformance report, so a demo that "works" is an acceptance test
+// (exit status 1 on any failure). Unlike conformance it submits
+// transactions: it re-initialises the dataset unless -skip-init.
+//
+//	init       InitLedger + SetScheme/SetPacking + GenerateDataset (fabgw.InitNegotiated)
+//	metadata   GetMetadata builds params
+//	public     PublicQuery of -index
+//	pir        ct_q → PIRQuery → decrypt
+//	match      the PIR record byte-equals the public read
+//	isolation  every other window of the pir result decrypts to zeros
+//	audit      PIRQuerySubmit commits, GetAuditRecord has its entry and
+//	           the audited ct_r decrypts to the same record
+
+type demo struct {
+	*conformance
+	submit   *client.Contract
+	initArgs fabgw.InitParams
+	index    int
+
+	keys   cpir.SchemeKeys
+	ctQ    string
+	ctR    string
+	public []byte
+	record string
+}
+
+func runDemo(args []string) int {
+	fs := flag.NewFlagSet("demo", flag.ExitOnError)
+	var t target
+	t.register(fs, args)
+	n := fs.Int("n", 128, "records to generate")
+	maxJSON := fs.Int("max-json", 128, "max JSON length of a generated record")
+	logN := fs.String("logn", "", "smallest LogN tried (empty: auto)")
+	scheme := fs.String("scheme", "", "HE scheme passed to SetScheme (empty: bgv)")
+	packing := fs.String("packing", "", "record packing passed to SetPacking (empty: 1b)")
+	index := fs.Int("index", 13, "record to retrieve")
+	skipInit := fs.Bool("skip-init", false, "use the committed dataset instead of initialising one")
+	jsonOut := fs.String("json", "", "also write the report as JSON to this file")
+	fs.Parse(args)
+
+	contract, closeFn, err := t.connect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connect: %v\n", err)
+		return 2
+	}
+	defer closeFn()
+
+	d := &demo{
+		conformance: &conformance{contract: contract},
+		submit:      contract,
+		initArgs:    fabgw.InitParams{NRecords: *n, MaxJSON: *maxJSON, LogN: *logN, Scheme: *scheme, Packing: *packing},
+		index:       *index,
+	}
+	d.report.Suite = "demo"
+	d.report.Target = fmt.Sprintf("%s %s/%s", t.Org.PeerEndpoint, t.Channel, t.Chaincode)
+	d.run(*skipInit)
+	return d.finish(*jsonOut)
+}
+
+// run executes the demo; every step needs the ones before it.
+func (d *demo) run(skipInit bool) {
+	d.check("capabilities", d.checkCapabilities)
+	if skipInit {
+		d.check("init", func() (string, error) { return "", errSkip("-skip-init") })
+	} else {
+		d.check("init", d.runInit)
+	}
+	d.check("metadata", d.checkMetadata)
+	d.check("public", d.runPublic)
+	d.check("pir", d.runPIR)
+	d.check("match", d.checkMatch)
+	d.check("isolation", d.checkIsolation)
+	d.check("audit", d.runAudit)
+}
+
+func (d *demo) runInit() (string, error) {
+	plan, err := fabgw.InitNegotiated(d.submit, d.initArgs, d.caps)
+	if err != nil {
+		return "", err
+	}
+	return plan.String(), nil
+}
+
+func (d *demo) runPublic() (string, error) {
+	if d.meta == nil {
+		return "", errSkip("no metadata")
+	}
+	if d.index < 0 || d.index >= d.meta.Live() {
+		return "", fmt.Errorf("index %d is not a live record (n=%d, %d live)", d.index, d.meta.NRecords, d.meta.Live())
+	}
+	raw, err := d.contract.EvaluateTransaction("PublicQuery", utils.RecordKey(d.index))
+	if err != nil {
+		return "", fmt.Errorf("PublicQuery: %w", err)
+	}
+	d.public = utils.TrimPadding(raw)
+	return fmt.Sprintf("%s: %d bytes", utils.RecordKey(d.index), len(d.public)), nil
+}
+
+func (d *demo) runPIR() (string, error) {
+	if d.meta == nil {
+		return "", errSkip("no metadata")
+	}
+	keys, err := cpir.GenSchemeKeys(*d.meta)
+	if err != nil {
+		return "", err
+	}
+	ctQ, size, err := keys.EncryptQuery(*d.meta, d.index)
+	if err != nil {
+		return "", err
+	}
+	ctR, err := d.contract.EvaluateTransaction("PIRQuery", ctQ)
+	if err != nil {
+		return "", fmt.Errorf("PIRQuery: %w", err)
+	}
+	dec, err := keys.DecryptResult(*d.meta, string(ctR), d.index)
+	if err != nil {
+		return "", fmt.Errorf("decrypt: %w", err)
+	}
+	d.keys, d.ctQ, d.ctR, d.record = keys, ctQ, string(ctR), dec.JSONString
+	return fmt.Sprintf("index %d: ct_q %d bytes, ct_r %d bytes (Base64), %d-byte record",
+		d.index, size, len(ctR), len(dec.JSONString)), nil
+}
+
+func (d *demo) checkMatch() (string, error) {
+	if d.public == nil || d.ctQ == "" {
+		return "", errSkip("no public read or PIR record")
+	}
+	if d.record != string(d.public) {
+		return "", fmt.Errorf("PIR returned %q, PublicQuery %q", d.record, d.public)
+	}
+	return fmt.Sprintf("%d bytes equal", len(d.public)), nil
+}
+
+// checkIsolation decrypts the pir result against the wrong windows: a
+// selector or packer that leaks neighbouring records fails here even
+// when the queried record decodes.
+func (d *demo) checkIsolation() (string, error) {
+	if d.ctR == "" {
+		return "", errSkip("no ct_r")
+	}
+	rep, err := d.keys.CheckIsolation(*d.meta, d.ctR, d.index)
+	if err != nil {
+		return "", err
+	}
+	if err := rep.Err(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d other windows of shard %d all zero", rep.Probed, rep.Shard), nil
+}
+
+// runAudit submits the ct_q of runPIR as an audited query and checks the
+// chaincode recorded it and answered it the same way.
+func (d *demo) runAudit() (string, error) {
+	if d.ctQ == "" {
+		return "", errSkip("no ct_q")
+	}
+	ctR, txID, err := fabgw.SubmitAuditedQuery(d.submit, d.ctQ, nil)
+	if err != nil {
+		return "", err
+	}
+	raw, err := d.contract.EvaluateTransaction("GetAuditRecord", txID)
+	if err != nil {
+		return "", fmt.Errorf("GetAuditRecord %s: %w", txID, err)
+	}
+	receipt, err := cpir.ParseAuditReceipt(raw)
+	if err != nil {
+		return "", err
+	}
+	if receipt.TxID != txID {
+		return "", fmt.Errorf("audit record of %s is for tx %s", txID, receipt.TxID)
+	}
+	dec, err := d.keys.DecryptResult(*d.meta, ctR, d.index)
+	if err != nil {
+		return "", fmt.Errorf("decrypt audited ct_r: %w", err)
+	}
+	if dec.JSONString != d.record {
+		return "", fmt.Errorf("audited query returned %q, PIRQuery %q", dec.JSONString, d.record)
+	}
+	return fmt.Sprintf("tx %s: audit record (m_DB v%d %.12s), same record", txID, receipt.DBVersion, receipt.MDBSHA256), nil
+}
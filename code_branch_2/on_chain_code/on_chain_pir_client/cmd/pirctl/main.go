@@ -0,0 +1,142 @@
+// cmd/pirctl/main.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"on-chain-pir-client/internal/config"
+	"on-chain-pir-client/internal/cpir"
+	"on-chain-pir-client/internal/fabgw"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// pirctl is the operator tool for a deployed on_chain_pir chaincode.
+//
+//	pirctl conformance [flags]   read-only conformance suite, pass/fail report
+//	pirctl demo [flags]          end-to-end demo run with acceptance assertions
+//	pirctl replay [flags]        replay a query session, latency trace CSV
+//	pirctl sizing [flags]        peers / vCPUs needed for query rates under an SLO
+//	pirctl multichannel [flags]  PIR on several channels of one peer, cross-channel interference
+//	pirctl params table [flags]  feasible (logN, record_s, max n) combinations, CSV/JSON
+//	pirctl init|metadata|public-query|pir-query|decrypt|bench [flags]
+//	                             one step of cmd/client's walk-through (ops.go)
+//
+// Connection flags default to the fablo test network the client in
+// cmd/client talks to, or to the client configuration file. The
+// single-step commands also reach the off-chain server (-endpoint).
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: pirctl <command> [flags]\n\ncommands:\n")
+	fmt.Fprintf(os.Stderr, "  conformance   check a live deployment (metadata, capacity, selector, round trip, errors)\n")
+	fmt.Fprintf(os.Stderr, "  demo          init, metadata, public read, PIR and audited query, asserting they agree\n")
+	fmt.Fprintf(os.Stderr, "  replay        replay a recorded or synthetic query session and record latencies\n")
+	fmt.Fprintf(os.Stderr, "  sizing        recommend endorsing peers / vCPUs for expected query rates and a latency SLO\n")
+	fmt.Fprintf(os.Stderr, "  multichannel  query channel-mini/mid/rich alone, interleaved and at once; report interference\n")
+	fmt.Fprintf(os.Stderr, "  params table  tabulate feasible logN / record_s / max n combinations (CSV or JSON)\n")
+	fmt.Fprintf(os.Stderr, "  init          initialise a dataset (-n, -max-json, -logn, -scheme, -packing, ...)\n")
+	fmt.Fprintf(os.Stderr, "  metadata      print the dataset's metadata\n")
+	fmt.Fprintf(os.Stderr, "  public-query  read record -index without PIR\n")
+	fmt.Fprintf(os.Stderr, "  pir-query     retrieve record -index with PIR (-save keeps the key and ct_r)\n")
+	fmt.Fprintf(os.Stderr, "  decrypt       decrypt the ct_r saved by pir-query -save\n")
+	fmt.Fprintf(os.Stderr, "  bench         time -count PIR round trips (encrypt, evaluate, decrypt)\n")
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "conformance":
+		os.Exit(runConformance(os.Args[2:]))
+	case "demo":
+		os.Exit(runDemo(os.Args[2:]))
+	case "replay":
+		os.Exit(runReplay(os.Args[2:]))
+	case "sizing":
+		os.Exit(runSizing(os.Args[2:]))
+	case "multichannel":
+		os.Exit(runMultiChannel(os.Args[2:]))
+	case "params":
+		os.Exit(runParams(os.Args[2:]))
+	case "init":
+		os.Exit(runInit(os.Args[2:]))
+	case "metadata":
+		os.Exit(runMetadata(os.Args[2:]))
+	case "public-query":
+		os.Exit(runPublicQuery(os.Args[2:]))
+	case "pir-query":
+		os.Exit(runPIRQuery(os.Args[2:]))
+	case "decrypt":
+		os.Exit(runDecrypt(os.Args[2:]))
+	case "bench":
+		os.Exit(runBench(os.Args[2:]))
+	default:
+		usage()
+	}
+}
+
+// target is where a pirctl command connects: the target part of the
+// client configuration (internal/config; -config, PIR_* variables, flags).
+type target struct {
+	*config.Config
+	// endpoint (an off-chain server base URL) replaces the gateway;
+	// token and dataset are sent with every call to it.
+	endpoint, token, dataset string
+}
+
+// register adds -config and the connection flags to fs; args are the ones
+// fs will parse, for -config.
+func (t *target) register(fs *flag.FlagSet, args []string) {
+	t.load(fs, args, config.Target)
+}
+
+// registerOps is register for the single-step commands: the dataset flags
+// too, and -endpoint.
+func (t *target) registerOps(fs *flag.FlagSet, args []string) {
+	t.load(fs, args, config.All)
+	fs.StringVar(&t.endpoint, "endpoint", os.Getenv("PIR_SERVER_URL"), "off-chain server base URL to use instead of the gateway")
+	fs.StringVar(&t.token, "token", os.Getenv("PIR_TOKEN"), "bearer token for -endpoint")
+	fs.StringVar(&t.dataset, "dataset", os.Getenv("PIR_DATASET"), "dataset on -endpoint (empty: default)")
+}
+
+func (t *target) load(fs *flag.FlagSet, args []string, groups config.Groups) {
+	cfg, err := config.Load(fs, args, groups)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	t.Config = cfg
+}
+
+// open returns the evaluator of -endpoint, or else of the gateway, whose
+// contract is also returned for submitting (nil off-chain).
+func (t *target) open() (ev cpir.Evaluator, contract *client.Contract, close func(), err error) {
+	if t.endpoint != "" {
+		return cpir.HTTPEvaluator{BaseURL: t.endpoint, Token: t.token, Dataset: t.dataset}, nil, func() {}, nil
+	}
+	contract, close, err = t.connect()
+	return contract, contract, close, err
+}
+
+// name describes the target in reports.
+func (t *target) name() string {
+	if t.endpoint != "" {
+		return t.endpoint
+	}
+	return fmt.Sprintf("%s %s/%s", t.Org.PeerEndpoint, t.Channel, t.Chaincode)
+}
+
+// connect opens the gateway; close releases it.
+func (t *target) connect() (contract *client.Contract, close func(), err error) {
+	gw, close, err := t.Org.Connect(fabgw.Timeouts{Evaluate: 30 * time.Second})
+	if err != nil {
+		return nil, nil, err
+	}
+	return gw.GetNetwork(t.Channel).GetContract(t.Chaincode), close, nil
+}
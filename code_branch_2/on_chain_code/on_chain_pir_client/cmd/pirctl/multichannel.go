@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"on-chain-pir-client/internal/cpir"
+	"on-chain-pir-client/internal/fabgw"
+	"pir_shared/utils"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+/********* MULTI-CHANNEL BENCHMARK *********************************/
+
+// multichannel drives PIRQuery on several channels of the same peer
+// (channel-mini/mid/rich) through one gateway connection and measures
+// what the per-channel benches cannot: how much the channels slow each
+// other down. Every channel runs three phases of -count queries:
+//
+//	solo         the channel alone, one query at a time (the baseline)
+//	interleaved  one query per channel in turn, from one goroutine
+//	concurrent   every channel at once, -concurrency queries in flight each
+//
+// Interference is a phase's p50 over the solo p50 of the same channel.
+// Each channel's ct_q is encrypted once under its own metadata, and its
+// first response is decrypted to check the channel answers correctly.
+
+// channelBench is one channel under test.
+type channelBench struct {
+	name     string
+	contract *client.Contract
+	meta     cpir.Metadata
+	index    int
+	ctQ      string
+}
+
+// phaseResult is one channel's calls in one phase.
+type phaseResult struct {
+	Phase        string              `json:"phase"`
+	Channel      string              `json:"channel"`
+	Summary      utils.ReplaySummary `json:"summary"`
+	Interference float64             `json:"interference"` // p50 / solo p50
+	results      []utils.ReplayResult
+}
+
+func runMultiChannel(args []string) int {
+	fs := flag.NewFlagSet("multichannel", flag.ExitOnError)
+	var t target
+	t.register(fs, args)
+	channels := fs.String("channels", "channel-mini,channel-mid,channel-rich", "comma-separated channels running the chaincode")
+	count := fs.Int("count", 20, "queries per channel and phase")
+	inflight := fs.Int("concurrency", 1, "queries in flight per channel in the concurrent phase")
+	index := fs.Int("index", 13, "record to query (modulo each channel's n)")
+	csvPath := fs.String("csv", "multichannel.csv", "per-call CSV (empty: none)")
+	asJSON := fs.Bool("json", false, "print the summaries as JSON")
+	fs.Parse(args)
+
+	gw, closeFn, err := t.Org.Connect(fabgw.Timeouts{Evaluate: 60 * time.Second})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connect: %v\n", err)
+		return 2
+	}
+	defer closeFn()
+
+	cpir.Debug = false
+	var benches []*channelBench
+	for _, name := range strings.Split(*channels, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		b, err := prepareChannel(gw.GetNetwork(name).GetContract(t.Chaincode), name, *index)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			return 2
+		}
+		fmt.Printf("%-14s %s, index %d, ct_q %d bytes (Base64)\n", name, metaSummary(b.meta), b.index, len(b.ctQ))
+		benches = append(benches, b)
+	}
+	if len(benches) == 0 {
+		fmt.Fprintln(os.Stderr, "-channels: no channel given")
+		return 2
+	}
+
+	var all []*phaseResult
+	solo := make(map[string]float64)
+	for _, b := range benches {
+		r := soloPhase(b, *count)
+		solo[b.name] = r.Summary.P50
+		all = append(all, r)
+	}
+	all = append(all, interleavedPhase(benches, *count)...)
+	all = append(all, concurrentPhase(benches, *count, max(*inflight, 1))...)
+	failed := false
+	for _, r := range all {
+		if base := solo[r.Channel]; base > 0 {
+			r.Interference = r.Summary.P50 / base
+		}
+		failed = failed || r.Summary.Errors > 0
+	}
+
+	if *csvPath != "" {
+		if err := writeMultiChannelCSV(*csvPath, all); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+	}
+	if *asJSON {
+		out, _ := json.MarshalIndent(all, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		fmt.Printf("\n%-12s %-14s %6s %6s %9s %9s %9s %8s %8s\n", "phase", "channel", "calls", "errors", "p50_ms", "p90_ms", "p99_ms", "rate/s", "x solo")
+		for _, r := range all {
+			s := r.Summary
+			fmt.Printf("%-12s %-14s %6d %6d %9.1f %9.1f %9.1f %8.2f %8.2f\n",
+				r.Phase, r.Channel, s.Calls, s.Errors, s.P50, s.P90, s.P99, s.RatePerSec, r.Interference)
+		}
+	}
+	if *csvPath != "" {
+		fmt.Printf("wrote %s\n", *csvPath)
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// prepareChannel reads the channel's metadata, encrypts its ct_q and
+// checks one round trip.
+func prepareChannel(contract *client.Contract, name string, index int) (*channelBench, error) {
+	meta, err := getMetadata(contract)
+	if err != nil {
+		return nil, fmt.Errorf("GetMetadata: %w", err)
+	}
+	if meta.NRecords <= 0 {
+		return nil, fmt.Errorf("no dataset")
+	}
+	b := &channelBench{name: name, contract: contract, meta: meta, index: index % meta.NRecords}
+	keys, err := cpir.GenSchemeKeys(meta)
+	if err != nil {
+		return nil, err
+	}
+	if b.ctQ, _, err = keys.EncryptQuery(meta, b.index); err != nil {
+		return nil, fmt.Errorf("encrypt: %w", err)
+	}
+	ctR, err := contract.EvaluateTransaction("PIRQuery", b.ctQ)
+	if err != nil {
+		return nil, fmt.Errorf("PIRQuery: %w", err)
+	}
+	if _, err := keys.DecryptResult(meta, string(ctR), b.index); err != nil {
+		return nil, fmt.Errorf("decrypt: %w", err)
+	}
+	return b, nil
+}
+
+// query times one PIRQuery of b; start is the phase start.
+func (b *channelBench) query(seq int, start time.Time) utils.ReplayResult {
+	sent := time.Now()
+	_, err := b.contract.EvaluateTransaction("PIRQuery", b.ctQ)
+	return utils.ReplayResult{
+		Seq: seq, Method: "PIRQuery", QueryBytes: len(b.ctQ),
+		ScheduledMS: msSince(start, sent), LatencyMS: msSince(sent, time.Now()), Err: err,
+	}
+}
+
+func newPhase(phase, channel string, results []utils.ReplayResult) *phaseResult {
+	return &phaseResult{Phase: phase, Channel: channel, Summary: utils.Summarize(results), results: results}
+}
+
+func soloPhase(b *channelBench, count int) *phaseResult {
+	start := time.Now()
+	results := make([]utils.ReplayResult, count)
+	for i := range results {
+		results[i] = b.query(i, start)
+	}
+	return newPhase("solo", b.name, results)
+}
+
+func interleavedPhase(benches []*channelBench, count int) []*phaseResult {
+	start := time.Now()
+	results := make([][]utils.ReplayResult, len(benches))
+	for i := 0; i < count; i++ {
+		for c, b := range benches {
+			results[c] = append(results[c], b.query(i, start))
+		}
+	}
+	out := make([]*phaseResult, len(benches))
+	for c, b := range benches {
+		out[c] = newPhase("interleaved", b.name, results[c])
+	}
+	return out
+}
+
+func concurrentPhase(benches []*channelBench, count, inflight int) []*phaseResult {
+	start := time.Now()
+	results := make([][]utils.ReplayResult, len(benches))
+	var wg sync.WaitGroup
+	for c, b := range benches {
+		results[c] = make([]utils.ReplayResult, count)
+		next := make(chan int)
+		for w := 0; w < inflight; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range next {
+					results[c][i] = b.query(i, start)
+				}
+			}()
+		}
+		go func() {
+			for i := 0; i < count; i++ {
+				next <- i
+			}
+			close(next)
+		}()
+	}
+	wg.Wait()
+	out := make([]*phaseResult, len(benches))
+	for c, b := range benches {
+		out[c] = newPhase("concurrent", b.name, results[c])
+	}
+	return out
+}
+
+// writeMultiChannelCSV writes one row per call:
+// phase,channel,seq,start_ms,latency_ms,ok,error.
+func writeMultiChannelCSV(path string, phases []*phaseResult) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	_ = w.Write([]string{"phase", "channel", "seq", "start_ms", "latency_ms", "ok", "error"})
+	for _, p := range phases {
+		for _, r := range p.results {
+			msg := ""
+			if r.Err != nil {
+				msg = r.Err.Error()
+			}
+			_ = w.Write([]string{
+				p.Phase, p.Channel, strconv.Itoa(r.Seq),
+				fmt.Sprintf("%.3f", r.ScheduledMS), fmt.Sprintf("%.3f", r.LatencyMS),
+				strconv.FormatBool(r.Err == nil), msg,
+			})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
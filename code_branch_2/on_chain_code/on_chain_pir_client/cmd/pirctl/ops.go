@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"on-chain-pir-client/internal/config"
+	"on-chain-pir-client/internal/cpir"
+	"on-chain-pir-client/internal/fabgw"
+	"pir_shared/utils"
+)
+
+/********* SINGLE STEPS ********************************************/
+
+// The walk-through of cmd/client, one command per step, so an experiment
+// is a command line rather than an edit of its constants:
+//
+//	init          InitLedger (+ SetScheme/SetPacking + GenerateDataset on Fabric)
+//	metadata      GetMetadata
+//	public-query  PublicQuery of -index (-private: GetPrivateRecord)
+//	pir-query     ct_q → PIRQuery → decrypt of -index; -save writes a session
+//	decrypt       decrypt the ct_r of a saved session
+//	bench         -count PIR round trips with encrypt / evaluate / decrypt times
+//
+// Every command takes the connection and dataset flags of internal/config
+// (-index, -logn, ...) and -endpoint, which sends the calls to the
+// off-chain server's /invoke instead of the gateway.
+
+// session is what pir-query -save writes: enough to decrypt ct_r offline.
+type session struct {
+	Meta   cpir.Metadata `json:"meta"`
+	Index  int           `json:"index"`
+	SKB64  string        `json:"sk_b64"` // SchemeKeys.MarshalSecretKey
+	CtRB64 string        `json:"ct_r_b64"`
+}
+
+func runInit(args []string) int {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	var t target
+	t.registerOps(fs, args)
+	fs.Parse(args)
+
+	ev, contract, closeFn, err := t.open()
+	if err != nil {
+		return fail("connect", err)
+	}
+	defer closeFn()
+	probe, err := cpir.ProbeServer(ev)
+	if err != nil {
+		return fail("Probe", err)
+	}
+	d := t.Dataset
+	var plan utils.InitPlan
+	if contract != nil {
+		plan, err = fabgw.InitNegotiated(contract, fabgw.InitParams{
+			NRecords: d.NRecords, MaxJSON: d.MaxJSON, LogN: d.LogN, LogQi: d.LogQi, LogPi: d.LogPi, T: d.T, Scheme: d.Scheme, Packing: d.Packing, Seed: d.Seed,
+		}, probe.Capabilities)
+	} else {
+		plan, err = initOffChain(ev, d, probe.Capabilities)
+	}
+	if err != nil {
+		return fail("init", err)
+	}
+	fmt.Printf("%s: %d records of <= %d bytes: %s\n", t.name(), d.NRecords, d.MaxJSON, plan)
+	return 0
+}
+
+// initOffChain is fabgw.InitNegotiated against the off-chain server, whose
+// InitLedger takes the packing mode and has no scheme choice.
+func initOffChain(ev cpir.Evaluator, d config.Dataset, caps utils.Capabilities) (utils.InitPlan, error) {
+	if d.Scheme != "" && d.Scheme != utils.SchemeBGV {
+		return utils.InitPlan{}, fmt.Errorf("the off-chain server only builds %s datasets, not %s", utils.SchemeBGV, d.Scheme)
+	}
+	if want, err := strconv.Atoi(d.LogN); err == nil {
+		caps.LogN = slices.DeleteFunc(slices.Clone(caps.LogN), func(logN int) bool { return logN < want })
+	}
+	caps, err := caps.WithPacking(d.Packing)
+	if err != nil {
+		return utils.InitPlan{}, err
+	}
+	return utils.NegotiateInit(d.NRecords, d.MaxJSON, caps, func(p utils.InitPlan) error {
+		args := []string{strconv.Itoa(d.NRecords), strconv.Itoa(d.MaxJSON), strconv.Itoa(p.LogN), d.LogQi, d.LogPi, d.T}
+		if p.Packing != utils.Packing1B {
+			args = append(args, "", p.Packing) // reserve, packing
+		}
+		_, err := ev.EvaluateTransaction("InitLedger", args...)
+		return err
+	})
+}
+
+func runMetadata(args []string) int {
+	fs := flag.NewFlagSet("metadata", flag.ExitOnError)
+	var t target
+	t.registerOps(fs, args)
+	fs.Parse(args)
+
+	ev, _, closeFn, err := t.open()
+	if err != nil {
+		return fail("connect", err)
+	}
+	defer closeFn()
+	meta, err := getMetadata(ev)
+	if err != nil {
+		return fail("GetMetadata", err)
+	}
+	out, _ := json.MarshalIndent(meta, "", "  ")
+	fmt.Printf("%s\n%s\n", out, metaSummary(meta))
+	return 0
+}
+
+func runPublicQuery(args []string) int {
+	fs := flag.NewFlagSet("public-query", flag.ExitOnError)
+	var t target
+	t.registerOps(fs, args)
+	private := fs.Bool("private", false, "read a private dataset's record with GetPrivateRecord")
+	fs.Parse(args)
+
+	ev, _, closeFn, err := t.open()
+	if err != nil {
+		return fail("connect", err)
+	}
+	defer closeFn()
+	key := utils.RecordKey(t.Dataset.Index)
+	fn := "PublicQuery"
+	if *private {
+		fn = "GetPrivateRecord"
+	}
+	raw, err := ev.EvaluateTransaction(fn, key)
+	if err != nil {
+		return fail(fn, err)
+	}
+	// GetPrivateRecord answers in the timed envelope; a padded record is
+	// not valid JSON and arrives as a JSON string
+	if res, _, ok := utils.UnwrapTimed(raw); ok {
+		var padded string
+		if len(res) > 0 && res[0] == '"' && json.Unmarshal(res, &padded) == nil {
+			res = []byte(padded)
+		}
+		raw = res
+	}
+	fmt.Printf("%s = %s\n", key, utils.TrimPadding(raw))
+	return 0
+}
+
+func runPIRQuery(args []string) int {
+	fs := flag.NewFlagSet("pir-query", flag.ExitOnError)
+	var t target
+	t.registerOps(fs, args)
+	save := fs.String("save", "", "write the key, metadata and ct_r to this session file (for decrypt)")
+	fs.Parse(args)
+
+	ev, _, closeFn, err := t.open()
+	if err != nil {
+		return fail("connect", err)
+	}
+	defer closeFn()
+	meta, err := getMetadata(ev)
+	if err != nil {
+		return fail("GetMetadata", err)
+	}
+	cpir.Debug = false
+	index := t.Dataset.Index
+	keys, err := cpir.GenSchemeKeys(meta)
+	if err != nil {
+		return fail("keys", err)
+	}
+	ctQ, size, err := keys.EncryptQuery(meta, index)
+	if err != nil {
+		return fail("encrypt", err)
+	}
+	ctR, err := ev.EvaluateTransaction("PIRQuery", ctQ)
+	if err != nil {
+		return fail("PIRQuery", err)
+	}
+	dec, err := keys.DecryptResult(meta, string(ctR), index)
+	if err != nil {
+		return fail("decrypt", err)
+	}
+	fmt.Printf("%s: ct_q %d bytes, ct_r %d bytes (Base64)\n%s = %s\n",
+		metaSummary(meta), size, len(ctR), utils.RecordKey(index), dec.JSONString)
+
+	if *save != "" {
+		sk, err := keys.MarshalSecretKey()
+		if err != nil {
+			return fail("secret key", err)
+		}
+		raw, _ := json.Marshal(session{Meta: meta, Index: index, SKB64: base64.StdEncoding.EncodeToString(sk), CtRB64: string(ctR)})
+		if err := os.WriteFile(*save, raw, 0o600); err != nil {
+			return fail("save", err)
+		}
+		fmt.Printf("session saved to %s (holds the secret key)\n", *save)
+	}
+	return 0
+}
+
+func runDecrypt(args []string) int {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	in := fs.String("in", "", "session file written by pir-query -save")
+	fs.Parse(args)
+	if *in == "" {
+		fmt.Fprintln(os.Stderr, "decrypt: -in is required")
+		return 2
+	}
+
+	raw, err := os.ReadFile(*in)
+	if err != nil {
+		return fail("read session", err)
+	}
+	var s session
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return fail("parse session", err)
+	}
+	sk, err := base64.StdEncoding.DecodeString(s.SKB64)
+	if err != nil {
+		return fail("secret key", err)
+	}
+	keys, err := cpir.LoadSchemeKeys(s.Meta, sk)
+	if err != nil {
+		return fail("keys", err)
+	}
+	cpir.Debug = false
+	dec, err := keys.DecryptResult(s.Meta, s.CtRB64, s.Index)
+	if err != nil {
+		return fail("decrypt", err)
+	}
+	fmt.Printf("%s = %s\n", utils.RecordKey(s.Index), dec.JSONString)
+	return 0
+}
+
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	var t target
+	t.registerOps(fs, args)
+	count := fs.Int("count", 10, "PIR round trips")
+	fs.Parse(args)
+
+	ev, _, closeFn, err := t.open()
+	if err != nil {
+		return fail("connect", err)
+	}
+	defer closeFn()
+	meta, err := getMetadata(ev)
+	if err != nil {
+		return fail("GetMetadata", err)
+	}
+	cpir.Debug = false
+	keys, err := cpir.GenSchemeKeys(meta)
+	if err != nil {
+		return fail("keys", err)
+	}
+
+	index := t.Dataset.Index
+	results := make([]utils.ReplayResult, 0, *count)
+	var encMS, decMS float64
+	begin := time.Now()
+	for i := 0; i < *count; i++ {
+		start := time.Now()
+		ctQ, _, err := keys.EncryptQuery(meta, index)
+		if err != nil {
+			return fail("encrypt", err)
+		}
+		evalStart := time.Now()
+		ctR, err := ev.EvaluateTransaction("PIRQuery", ctQ)
+		decStart := time.Now()
+		if err == nil {
+			_, err = keys.DecryptResult(meta, string(ctR), index)
+		}
+		encMS += msSince(start, evalStart)
+		decMS += msSince(decStart, time.Now())
+		results = append(results, utils.ReplayResult{
+			Seq: i, Method: "PIRQuery", QueryBytes: len(ctQ), ScheduledMS: msSince(begin, start),
+			LatencyMS: msSince(evalStart, decStart), Err: err,
+		})
+	}
+	sum := utils.Summarize(results)
+	fmt.Printf("%s, index %d against %s\nPIRQuery %s\nencrypt %.1f ms, decrypt %.1f ms (mean)\n",
+		metaSummary(meta), index, t.name(), sum, encMS/float64(*count), decMS/float64(*count))
+	if sum.Errors > 0 {
+		return 1
+	}
+	return 0
+}
+
+// getMetadata reads and parses GetMetadata.
+func getMetadata(ev cpir.Evaluator) (cpir.Metadata, error) {
+	raw, err := ev.EvaluateTransaction("GetMetadata")
+	if err != nil {
+		return cpir.Metadata{}, err
+	}
+	meta, _, err := cpir.ParseMetadata(raw)
+	return meta, err
+}
+
+func metaSummary(m cpir.Metadata) string {
+	return fmt.Sprintf("n=%d record_s=%d logN=%d t=%d scheme=%s packing=%s",
+		m.NRecords, m.RecordS, m.LogN, m.T, m.HEScheme(), m.PackingMode())
+}
+
+func msSince(from, to time.Time) float64 { return float64(to.Sub(from).Microseconds()) / 1e3 }
+
+// fail reports err of step and returns the exit status of a failed call.
+func fail(step string, err error) int {
+	fmt.Fprintf(os.Stderr, "%s: %v\n", step, err)
+	return 2
+}
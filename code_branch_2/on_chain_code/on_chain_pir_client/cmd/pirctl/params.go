@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"pir_shared/utils"
+)
+
+/********* PARAMETER TABLE *****************************************/
+
+// params table prints utils.ParamTable: the feasible (logN, record_s,
+// max n) combinations as the chaincode's capacity checks and ChooseLogN
+// compute them, with the estimated eval cost, ciphertext sizes and init
+// memory of each. It needs no network, and regenerating the table after a
+// change to the planner keeps the README's parameter guidance in step.
+
+func runParams(args []string) int {
+	if len(args) == 0 || args[0] != "table" {
+		fmt.Fprintln(os.Stderr, "usage: pirctl params table [flags]")
+		return 2
+	}
+	fs := flag.NewFlagSet("params table", flag.ExitOnError)
+	sizes := fs.String("record-bytes", "32,64,128,256,512,1024", "comma-separated record sizes (max JSON length) to tabulate")
+	packing := fs.String("packing", strings.Join(utils.PackingModes, ","), "comma-separated packing modes")
+	var opt utils.ParamTableOptions
+	fs.IntVar(&opt.MaxShards, "max-shards", 1, "plaintext shards a dataset may span")
+	fs.BoolVar(&opt.AllowLogN16, "logn16", false, "add single-shard logN=16 rows")
+	fs.IntVar(&opt.Levels, "levels", len(utils.DefaultLogQi), "Q moduli (len(logQi)) for the size and memory estimates")
+	format := fs.String("format", "csv", "csv or json")
+	out := fs.String("o", "", "write the table to this file instead of stdout")
+	fs.Parse(args[1:])
+
+	for _, s := range strings.Split(*sizes, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "-record-bytes: %v\n", err)
+			return 2
+		}
+		opt.RecordBytes = append(opt.RecordBytes, n)
+	}
+	for _, p := range strings.Split(*packing, ",") {
+		opt.Packing = append(opt.Packing, strings.TrimSpace(p))
+	}
+
+	log.SetOutput(io.Discard) // ChooseLogN logs every plan it makes
+	rows, err := utils.ParamTable(opt)
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "params: %v\n", err)
+		return 1
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+		defer f.Close()
+		w = f
+	}
+	switch *format {
+	case "csv":
+		err = utils.WriteParamTableCSV(w, rows)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(rows)
+	default:
+		fmt.Fprintf(os.Stderr, "-format: want csv or json, got %q\n", *format)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
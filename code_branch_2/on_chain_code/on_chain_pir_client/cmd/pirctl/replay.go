@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"on-chain-pir-client/internal/cpir"
+	"pir_shared/utils"
+)
+
+/********* REPLAY **************************************************/
+
+// replay re-issues a recorded (or synthetic) query session against the
+// gateway peer on its original schedule, sped up by -speedup, and writes
+// one latency row per call (see utils.Replay). Only evaluate
+// transactions are sent. The ct_q is encrypted once for record 0 under
+// the deployed metadata and reused by every query event.
+
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	var t target
+	t.register(fs, args)
+	var rc utils.ReplayCommand
+	rc.Register(fs, "replay_fabric.csv")
+	fs.Parse(args)
+
+	contract, closeFn, err := t.connect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connect: %v\n", err)
+		return 2
+	}
+	defer closeFn()
+
+	if _, err := cpir.ProbeServer(contract); err != nil {
+		fmt.Fprintf(os.Stderr, "Probe: %v\n", err)
+		return 2
+	}
+	metaRaw, err := contract.EvaluateTransaction("GetMetadata")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "GetMetadata: %v\n", err)
+		return 2
+	}
+	meta, _, err := cpir.ParseMetadata(metaRaw)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	params, _, pk, err := cpir.GenKeysFromMetadata(meta)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	cpir.Debug = false
+	ctQ, _, err := cpir.EncryptQueryBase64(params, pk, 0, meta.NRecords, meta.RecordS)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "encrypt ct_q: %v\n", err)
+		return 2
+	}
+
+	trace, err := rc.Trace(len(ctQ))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	fmt.Printf("replaying %d calls (%s) at %gx against %s/%s\n",
+		len(trace.Events), trace.Source, rc.Options.Speedup, t.Channel, t.Chaincode)
+	sum, warn, err := rc.Run(trace, ctQ, func(method string, args ...string) error {
+		_, err := contract.EvaluateTransaction(method, args...)
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	if warn != "" {
+		fmt.Printf("[WARN] %s\n", warn)
+	}
+	fmt.Printf("%s\nwrote %s\n", sum, rc.OutPath)
+	if sum.Errors > 0 {
+		return 1
+	}
+	return 0
+}
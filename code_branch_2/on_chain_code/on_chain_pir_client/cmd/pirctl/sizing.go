@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"pir_shared/utils"
+)
+
+/********* SIZING **************************************************/
+
+// sizing recommends how many endorsing peers (and vCPUs) serve the
+// expected query rates of co-located channels within a latency SLO
+// (utils.SizePeers). Per-query costs come from the benches' calibration
+// files (-calib: the tx_costs projection CSV, the chaincode "bench" CSV
+// or a saved GetPerfStats JSON), from the deployed peer's GetPerfStats
+// with -live, and otherwise from utils.EvalCostMS.
+
+func runSizing(args []string) int {
+	fs := flag.NewFlagSet("sizing", flag.ExitOnError)
+	var t target
+	t.register(fs, args)
+	channels := fs.String("channels", "mini:13:20,mid:14:10,rich:15:5", "expected load, name:logN:queries_per_sec[:cost_ms],...")
+	calib := fs.String("calib", "", "comma-separated calibration files (.csv from the benches, .json from GetPerfStats)")
+	live := fs.Bool("live", false, "also calibrate from the connected peer's GetPerfStats")
+	var opt utils.SizingOptions
+	fs.Float64Var(&opt.SLOMS, "slo", 200, "latency SLO per query, ms")
+	fs.Float64Var(&opt.Quantile, "quantile", 0.99, "fraction of queries the SLO must hold for")
+	fs.IntVar(&opt.VCPUsPerPeer, "vcpus", 2, "vCPUs per endorsing peer")
+	fs.Float64Var(&opt.MaxUtil, "util", 0.7, "highest acceptable CPU utilisation")
+	fs.IntVar(&opt.MinPeers, "min-peers", 1, "lower bound on endorsing peers")
+	asJSON := fs.Bool("json", false, "print the plan as JSON")
+	fs.Parse(args)
+
+	loads, err := parseLoads(*channels)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-channels: %v\n", err)
+		return 2
+	}
+	var cal utils.Calibration
+	for _, path := range strings.Split(*calib, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		if err := loadCalibration(&cal, path); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			return 2
+		}
+	}
+	if *live {
+		contract, closeFn, err := t.connect()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "connect: %v\n", err)
+			return 2
+		}
+		raw, err := contract.EvaluateTransaction("GetPerfStats")
+		closeFn()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "GetPerfStats: %v\n", err)
+			return 2
+		}
+		if err := cal.LoadPerfStats(raw); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+	}
+
+	plan, err := utils.SizePeers(loads, cal, opt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sizing: %v\n", err)
+		return 1
+	}
+	if *asJSON {
+		out, _ := json.MarshalIndent(plan, "", "  ")
+		fmt.Println(string(out))
+		return 0
+	}
+	fmt.Printf("%-8s %5s %8s %9s %8s %12s  %s\n", "channel", "logN", "qps", "cost_ms", "erlangs", "latency_ms", "cost from")
+	for _, c := range plan.Channels {
+		fmt.Printf("%-8s %5d %8.1f %9.2f %8.3f %12.2f  %s\n",
+			c.Name, c.LogN, c.RatePerSec, c.CostMS, c.Erlangs, c.LatencyMS, c.CostSource)
+	}
+	fmt.Printf("\n%d vCPUs on %d peer(s) of %d vCPUs: utilisation %.0f%%, P(wait) %.2f, p%g wait %.1f ms (SLO %.0f ms)\n",
+		plan.VCPUs, plan.Peers, max(opt.VCPUsPerPeer, 1), 100*plan.Utilization, plan.WaitProb,
+		100*plan.Quantile, plan.WaitMS, opt.SLOMS)
+	return 0
+}
+
+// parseLoads parses -channels.
+func parseLoads(spec string) ([]utils.ChannelLoad, error) {
+	var loads []utils.ChannelLoad
+	for _, item := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) < 3 || len(parts) > 4 {
+			return nil, fmt.Errorf("%q: want name:logN:queries_per_sec[:cost_ms]", item)
+		}
+		l := utils.ChannelLoad{Name: parts[0]}
+		var err error
+		if l.LogN, err = strconv.Atoi(parts[1]); err != nil {
+			return nil, fmt.Errorf("%q: logN: %w", item, err)
+		}
+		if l.RatePerSec, err = strconv.ParseFloat(parts[2], 64); err != nil {
+			return nil, fmt.Errorf("%q: rate: %w", item, err)
+		}
+		if len(parts) == 4 {
+			if l.CostMS, err = strconv.ParseFloat(parts[3], 64); err != nil {
+				return nil, fmt.Errorf("%q: cost: %w", item, err)
+			}
+		}
+		loads = append(loads, l)
+	}
+	return loads, nil
+}
+
+// loadCalibration adds one calibration file to cal.
+func loadCalibration(cal *utils.Calibration, path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(path, ".json") {
+		return cal.LoadPerfStats(raw)
+	}
+	return cal.LoadCSV(bytes.NewReader(raw))
+}
@@ -6,6 +6,8 @@ require (
 	github.com/hyperledger/fabric-gateway v1.8.0
 	github.com/tuneinsight/lattigo/v6 v6.1.1
 	google.golang.org/grpc v1.75.0
+	gopkg.in/yaml.v3 v3.0.1
+	pir_shared v0.0.0-00010101000000-000000000000
 )
 
 require (
@@ -13,10 +15,10 @@ require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/google/go-cmp v0.7.0 // indirect
 	github.com/hyperledger/fabric-protos-go-apiv2 v0.3.7 // indirect
-	github.com/kr/text v0.2.0 // indirect
 	github.com/miekg/pkcs11 v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/testify v1.10.0 // indirect
+	github.com/tuneinsight/lattigo/v5 v5.0.7 // indirect
 	golang.org/x/crypto v0.40.0 // indirect
 	golang.org/x/exp v0.0.0-20230321023759-10a507213a29 // indirect
 	golang.org/x/net v0.41.0 // indirect
@@ -24,5 +26,6 @@ require (
 	golang.org/x/text v0.27.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20250707201910-8d1bb00bc6a7 // indirect
 	google.golang.org/protobuf v1.36.6 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace pir_shared => ../../pir_shared
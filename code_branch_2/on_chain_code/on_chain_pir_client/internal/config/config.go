@@ -0,0 +1,180 @@
+// Package config is the configuration of the on-chain clients: the
+// channel, chaincode and organizations they connect to and the dataset
+// parameters cmd/client initializes. Values are layered, later ones
+// winning:
+//
+//	defaults     the fablo test network (Defaults)
+//	file         JSON, or YAML for .yaml/.yml; -config, else $PIR_CLIENT_CONFIG
+//	environment  PIR_<FLAG>, e.g. PIR_PEER, PIR_MAX_JSON
+//	flags        -peer, -max-json, ...
+//
+// so one binary targets another channel, org or parameter set without
+// recompiling. A file may set any subset of the fields.
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"on-chain-pir-client/internal/fabgw"
+)
+
+// EnvFile names the configuration file when -config is not given.
+const EnvFile = "PIR_CLIENT_CONFIG"
+
+// Config is one client run's target and dataset.
+type Config struct {
+	Channel   string    `json:"channel" yaml:"channel"`
+	Chaincode string    `json:"chaincode" yaml:"chaincode"`
+	Org       fabgw.Org `json:"org" yaml:"org"` // runs every client role
+	// Endorser co-endorses cmd/client's two-org audited query; file only.
+	Endorser fabgw.Org `json:"endorser" yaml:"endorser"`
+	Dataset  Dataset   `json:"dataset" yaml:"dataset"`
+}
+
+// Dataset is what cmd/client initializes and retrieves. The HE fields are
+// strings as InitLedger takes them: empty leaves the choice to the
+// chaincode (see fabgw.InitParams).
+type Dataset struct {
+	NRecords    int    `json:"n" yaml:"n"`
+	MaxJSON     int    `json:"max_json" yaml:"max_json"`
+	LogN        string `json:"logn" yaml:"logn"`
+	LogQi       string `json:"logqi" yaml:"logqi"` // JSON array
+	LogPi       string `json:"logpi" yaml:"logpi"` // JSON array
+	T           string `json:"t" yaml:"t"`
+	Scheme      string `json:"scheme" yaml:"scheme"`   // SetScheme: bgv, bfv, ckks
+	Packing     string `json:"packing" yaml:"packing"` // SetPacking: 1b, 2b
+	Seed        string `json:"seed" yaml:"seed"`       // InitLedgerSeeded
+	Index       int    `json:"index" yaml:"index"`     // record to retrieve
+	ChunkSize   int    `json:"chunk_size" yaml:"chunk_size"`
+	LocalEncode bool   `json:"local_encode" yaml:"local_encode"`
+	RecordsFile string `json:"records_file" yaml:"records_file"`
+}
+
+// Groups select the flags Load registers.
+type Groups int
+
+const (
+	Target Groups = 1 << iota // channel, chaincode, org
+	Data                      // dataset
+	All    = Target | Data
+)
+
+// Defaults is the fablo test network under the home directory (org1
+// running the clients, org2 endorsing) and cmd/client's sample dataset.
+func Defaults() Config {
+	home, _ := os.UserHomeDir()
+	return Config{
+		Channel:   "channel-mini",
+		Chaincode: "on_chain_pir",
+		Org:       fabgw.FabloOrg(home, 1),
+		Endorser:  fabgw.FabloOrg(home, 2),
+		Dataset:   Dataset{NRecords: 64, MaxJSON: 128, Index: 13},
+	}
+}
+
+// Load builds the configuration of a command from Defaults, the file
+// named by -config in args or $PIR_CLIENT_CONFIG, and the PIR_*
+// environment. It registers -config and the flags of groups on fs with
+// the loaded values as defaults, so fs.Parse(args) applies the command
+// line last.
+func Load(fs *flag.FlagSet, args []string, groups Groups) (*Config, error) {
+	cfg := Defaults()
+	path := configArg(args)
+	if path == "" {
+		path = os.Getenv(EnvFile)
+	}
+	if path != "" {
+		if err := cfg.LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	fs.String("config", path, "JSON or YAML client configuration (default $"+EnvFile+")")
+
+	var names []string
+	reg := func(name string) string { names = append(names, name); return name }
+	if groups&Target != 0 {
+		fs.StringVar(&cfg.Channel, reg("channel"), cfg.Channel, "channel name")
+		fs.StringVar(&cfg.Chaincode, reg("chaincode"), cfg.Chaincode, "chaincode name")
+		fs.StringVar(&cfg.Org.MSPID, reg("msp"), cfg.Org.MSPID, "client MSP ID")
+		fs.StringVar(&cfg.Org.PeerEndpoint, reg("peer"), cfg.Org.PeerEndpoint, "gateway peer endpoint")
+		fs.StringVar(&cfg.Org.GatewayPeer, reg("peer-name"), cfg.Org.GatewayPeer, "gateway peer TLS server name")
+		fs.StringVar(&cfg.Org.CryptoPath, reg("crypto"), cfg.Org.CryptoPath, "organization crypto-config directory")
+		fs.StringVar(&cfg.Org.User, reg("user"), cfg.Org.User, "user whose MSP signs")
+	}
+	if groups&Data != 0 {
+		d := &cfg.Dataset
+		fs.IntVar(&d.NRecords, reg("n"), d.NRecords, "records to generate")
+		fs.IntVar(&d.MaxJSON, reg("max-json"), d.MaxJSON, "max JSON length of a record")
+		fs.StringVar(&d.LogN, reg("logn"), d.LogN, "HE LogN (empty: auto)")
+		fs.StringVar(&d.LogQi, reg("logqi"), d.LogQi, "HE logQi as a JSON array (empty: default)")
+		fs.StringVar(&d.LogPi, reg("logpi"), d.LogPi, "HE logPi as a JSON array (empty: default)")
+		fs.StringVar(&d.T, reg("t"), d.T, "HE plaintext modulus (empty: default)")
+		fs.StringVar(&d.Scheme, reg("scheme"), d.Scheme, "HE scheme passed to SetScheme (empty: bgv)")
+		fs.StringVar(&d.Packing, reg("packing"), d.Packing, "record packing passed to SetPacking (empty: 1b)")
+		fs.StringVar(&d.Seed, reg("seed"), d.Seed, "seed of the synthetic records; every endorser generates the same dataset (InitLedgerSeeded)")
+		fs.IntVar(&d.Index, reg("index"), d.Index, "record to retrieve")
+		fs.IntVar(&d.ChunkSize, reg("chunk-size"), d.ChunkSize, ">0: upload records in chunks of this size (InitBegin/InitAddRecords/InitCommit)")
+		fs.BoolVar(&d.LocalEncode, reg("local-encode"), d.LocalEncode, "encode m_DB locally and upload it with PutMDB")
+		fs.StringVar(&d.RecordsFile, reg("records-file"), d.RecordsFile, "JSON array of records for LoadRecordsFromJSON (empty: synthetic)")
+	}
+
+	for _, name := range names {
+		env := EnvName(name)
+		if v, ok := os.LookupEnv(env); ok {
+			if err := fs.Set(name, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", env, err)
+			}
+		}
+	}
+	return &cfg, nil
+}
+
+// EnvName is the environment variable overriding flag name.
+func EnvName(flagName string) string {
+	return "PIR_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// LoadFile overlays the fields set in the JSON or YAML file at path.
+func (c *Config) LoadFile(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, c)
+	default:
+		err = json.Unmarshal(raw, c)
+	}
+	if err != nil {
+		return fmt.Errorf("parse config %s: %w", path, err)
+	}
+	return nil
+}
+
+// configArg is the value of -config (or --config) in args, "" if absent.
+func configArg(args []string) string {
+	for i, a := range args {
+		if a == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if !strings.HasPrefix(a, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return value
+		}
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
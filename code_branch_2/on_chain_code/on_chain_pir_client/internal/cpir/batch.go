@@ -0,0 +1,71 @@
+package cpir
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"pir_shared/utils"
+)
+
+// ---------- 8. Batch PIR ----------
+
+// FetchBatch retrieves the records at indices, in order. On the PIR path
+// of a BGV dataset whose chaincode advertises utils.FeatBatchQuery, their
+// ct_q go to PIRQueryBatch, utils.MaxBatchQueries per call; otherwise it
+// is Fetch per index. Either way the OnRecord hooks run on each record.
+func (c *Client) FetchBatch(indices []int) ([]Record, error) {
+	path, err := c.Path()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Record, 0, len(indices))
+	if path != PathPIR || c.meta.HEScheme() != utils.SchemeBGV || !c.caps.Has(utils.FeatBatchQuery) {
+		for _, index := range indices {
+			rec, err := c.Fetch(index)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, rec)
+		}
+		return out, nil
+	}
+
+	for _, index := range indices {
+		if index < 0 || index >= c.meta.NRecords {
+			return nil, fmt.Errorf("index %d out of range 0..%d", index, c.meta.NRecords-1)
+		}
+	}
+	if err := c.bgvKeys(); err != nil {
+		return nil, err
+	}
+	for lo := 0; lo < len(indices); lo += utils.MaxBatchQueries {
+		chunk := indices[lo:min(lo+utils.MaxBatchQueries, len(indices))]
+		queries := make([]string, len(chunk))
+		for k, index := range chunk {
+			if queries[k], _, err = EncryptQueryBase64(c.params, c.pk, index, c.meta.NRecords, c.meta.RecordS); err != nil {
+				return nil, err
+			}
+		}
+		arg, _ := json.Marshal(queries)
+		res, err := c.Contract.EvaluateTransaction("PIRQueryBatch", string(arg))
+		if err != nil {
+			return nil, fmt.Errorf("PIRQueryBatch: %w", err)
+		}
+		results, err := utils.DecodeBatchResults(string(res), len(chunk))
+		if err != nil {
+			return nil, err
+		}
+		for k, index := range chunk {
+			decoded, err := DecryptResultPacked(c.params, c.sk, results[k], index, c.meta.NRecords, c.meta.RecordS, c.meta.Packing)
+			if err != nil {
+				return nil, err
+			}
+			rec := Record{Index: index, JSONString: decoded.JSONString, Path: PathPIR, Bytes: len(results[k])}
+			if err := c.runHooks(&rec); err != nil {
+				return nil, err
+			}
+			out = append(out, rec)
+		}
+	}
+	return out, nil
+}
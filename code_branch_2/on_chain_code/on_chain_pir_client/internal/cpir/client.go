@@ -0,0 +1,475 @@
+package cpir
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
+
+	"pir_shared/he"
+	"pir_shared/utils"
+)
+
+// ---------- 5. Retrieval client (full download / delta / HE-PIR) ----------
+
+// Evaluator is the part of a Fabric Gateway *client.Contract the Client
+// needs; it keeps this package free of gateway dependencies.
+type Evaluator interface {
+	EvaluateTransaction(name string, args ...string) ([]byte, error)
+}
+
+// RecordingEvaluator passes calls through to Evaluator and notes each in
+// Recorder (method and length of its longest argument), to capture a
+// session for pirctl replay.
+type RecordingEvaluator struct {
+	Evaluator
+	Recorder *utils.TraceRecorder
+}
+
+func (r RecordingEvaluator) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	longest := 0
+	for _, a := range args {
+		longest = max(longest, len(a))
+	}
+	r.Recorder.Record(name, longest)
+	return r.Evaluator.EvaluateTransaction(name, args...)
+}
+
+// DatasetEvaluator selects dataset Name on a chaincode hosting several:
+// it passes "ds:<Name>" ahead of every call's arguments. An empty Name is
+// the default dataset.
+type DatasetEvaluator struct {
+	Evaluator
+	Name string
+}
+
+func (d DatasetEvaluator) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	if d.Name == "" {
+		return d.Evaluator.EvaluateTransaction(name, args...)
+	}
+	return d.Evaluator.EvaluateTransaction(name, append([]string{"ds:" + d.Name}, args...)...)
+}
+
+// Path is the retrieval path a Client used.
+type Path string
+
+const (
+	PathFullDownload Path = "full_download" // whole padded DB via GetFullDatasetChunk
+	PathPIR          Path = "pir"           // ct_q → PIRQuery → decrypt
+	PathLocal        Path = "local_cache"   // Hybrid: nothing changed since the local copy
+	PathDeltaPIR     Path = "delta_pir"     // Hybrid: ct_q → PIRQueryDelta over changed records
+)
+
+// Record is one retrieved record and how it was fetched.
+type Record struct {
+	Index      int
+	JSONString string
+	Path       Path
+	Bytes      int // bytes received from the chaincode for this call
+
+	// Annotations is what RecordHooks attached (nil when none did).
+	Annotations map[string]any
+}
+
+// Client retrieves records by index, picking the cheaper path per
+// dataset: when n*record_s is at most FullDownloadMaxBytes and the server
+// advertises utils.FeatFullDownload, it downloads the whole padded DB once
+// and serves later indices from memory; otherwise it runs HE-PIR.
+//
+// A Client given MetaHint, metadata saved from an earlier session, starts
+// PIR without asking for metadata first: its first Fetch builds ct_q from
+// the hint and sends it with MetaAndQuery, which returns the current
+// metadata and capabilities together with the response, one round trip
+// instead of three (Probe, GetMetadata, PIRQuery). If the
+// dataset changed since the hint, the response is dropped and Fetch
+// queries again under the returned metadata.
+//
+// With Hybrid set (and a server advertising utils.FeatDeltaPIR) the local
+// copy is kept whatever the dataset size: each Fetch asks GetDelta which
+// records changed since the copy's version and, if any did, runs PIR over
+// just those (PIRQueryDelta). The query is sent even when the wanted
+// record did not change, for a random position, so the server cannot tell
+// whether the client's record is among the changed ones.
+type Client struct {
+	Contract Evaluator
+	Hybrid   bool
+	// FullDownloadMaxBytes is the n*record_s threshold for full download
+	// (0 → utils.DefaultFullDownloadBytes, <0 → always PIR).
+	FullDownloadMaxBytes int
+	// ChunkRecords is the GetFullDatasetChunk count per call (0 → 256).
+	ChunkRecords int
+	// MetaHint is the metadata the first Fetch assumes (nil: ask first).
+	// Each MetaAndQuery call replaces it with the current metadata, so it
+	// can be saved for the next session.
+	MetaHint *Metadata
+
+	meta    *Metadata
+	caps    Capabilities
+	params  bgv.Parameters
+	sk      *rlwe.SecretKey
+	pk      *rlwe.PublicKey
+	scheme  *SchemeKeys  // keys of a non-BGV dataset (fetchScheme)
+	dataset [][]byte     // full-download cache, padded windows
+	version int          // m_DB_version of dataset
+	hooks   []RecordHook // OnRecord post-processing
+}
+
+// NewClient returns a Client with the default thresholds.
+func NewClient(contract Evaluator) *Client {
+	return &Client{Contract: contract}
+}
+
+// Refresh drops cached metadata, keys and downloaded records, e.g. after
+// the dataset was republished. MetaHint is kept.
+func (c *Client) Refresh() {
+	c.meta, c.sk, c.pk, c.scheme, c.dataset = nil, nil, nil, nil, nil
+}
+
+// Path reports which path Fetch will use for the current dataset. For a
+// Hybrid client it is PathFullDownload; Fetch reports the actual path.
+func (c *Client) Path() (Path, error) {
+	meta, err := c.metadata()
+	if err != nil {
+		return "", err
+	}
+	if c.hybrid() {
+		return PathFullDownload, nil
+	}
+	if c.fitsFullDownload(meta) && c.caps.Has(utils.FeatFullDownload) {
+		return PathFullDownload, nil
+	}
+	return PathPIR, nil
+}
+
+// fitsFullDownload reports whether meta's dataset is under the
+// full-download threshold.
+func (c *Client) fitsFullDownload(meta Metadata) bool {
+	limit := c.FullDownloadMaxBytes
+	if limit == 0 {
+		limit = utils.DefaultFullDownloadBytes
+	}
+	return limit > 0 && meta.NRecords*meta.RecordS <= limit
+}
+
+// Fetch retrieves record index over the path chosen by Path and runs the
+// OnRecord hooks on it.
+func (c *Client) Fetch(index int) (Record, error) {
+	rec, err := c.fetch(index)
+	if err != nil {
+		return Record{}, err
+	}
+	if err := c.runHooks(&rec); err != nil {
+		return Record{}, err
+	}
+	return rec, nil
+}
+
+func (c *Client) fetch(index int) (Record, error) {
+	if c.meta == nil && c.MetaHint != nil && !c.Hybrid && !c.fitsFullDownload(*c.MetaHint) &&
+		c.MetaHint.HEScheme() == utils.SchemeBGV {
+		if rec, ok, err := c.fetchCold(index); err != nil || ok {
+			return rec, err
+		}
+	}
+	path, err := c.Path()
+	if err != nil {
+		return Record{}, err
+	}
+	if c.hybrid() && c.dataset != nil {
+		return c.fetchHybrid(index) // checks index against the current n
+	}
+	if index < 0 || index >= c.meta.NRecords {
+		return Record{}, fmt.Errorf("index %d out of range 0..%d", index, c.meta.NRecords-1)
+	}
+	if path == PathFullDownload {
+		return c.fetchFull(index)
+	}
+	return c.fetchPIR(index)
+}
+
+func (c *Client) hybrid() bool {
+	return c.Hybrid && c.caps.Has(utils.FeatFullDownload|utils.FeatDeltaPIR)
+}
+
+func (c *Client) metadata() (Metadata, error) {
+	if c.meta != nil {
+		return *c.meta, nil
+	}
+	probe, err := ProbeServer(c.Contract)
+	if err != nil {
+		return Metadata{}, err
+	}
+	raw, err := c.Contract.EvaluateTransaction("GetMetadata")
+	if err != nil {
+		return Metadata{}, fmt.Errorf("GetMetadata: %w", err)
+	}
+	meta, _, err := ParseMetadata(raw)
+	if err != nil {
+		return Metadata{}, err
+	}
+	if err := probe.CheckParams(meta); err != nil {
+		return Metadata{}, err
+	}
+	c.meta, c.caps = &meta, probe.Capabilities
+	return meta, nil
+}
+
+func (c *Client) fetchFull(index int) (Record, error) {
+	received := 0
+	if c.dataset == nil {
+		version := 0
+		per := c.ChunkRecords
+		if per <= 0 {
+			per = 256
+		}
+		var all [][]byte
+		for len(all) < c.meta.NRecords {
+			raw, err := c.Contract.EvaluateTransaction("GetFullDatasetChunk",
+				strconv.Itoa(len(all)), strconv.Itoa(per))
+			if err != nil {
+				return Record{}, fmt.Errorf("GetFullDatasetChunk: %w", err)
+			}
+			received += len(raw)
+			if res, _, ok := utils.UnwrapTimed(raw); ok {
+				raw = res
+			}
+			var chunk utils.DatasetChunk
+			if err := json.Unmarshal(raw, &chunk); err != nil {
+				return Record{}, fmt.Errorf("parse GetFullDatasetChunk: %w", err)
+			}
+			if chunk.Offset != len(all) || chunk.NRecords != c.meta.NRecords || chunk.RecordS != c.meta.RecordS ||
+				len(chunk.Records) == 0 || (len(all) > 0 && chunk.Version != version) {
+				return Record{}, fmt.Errorf("GetFullDatasetChunk: chunk at %d (n=%d, record_s=%d, version %d) does not match metadata",
+					chunk.Offset, chunk.NRecords, chunk.RecordS, chunk.Version)
+			}
+			version = chunk.Version
+			all = append(all, chunk.Records...)
+		}
+		c.dataset, c.version = all, version
+		if Debug {
+			fmt.Printf("[DBG] Full download: %d records, %d bytes received\n", len(all), received)
+		}
+	}
+
+	return c.localRecord(index, PathFullDownload, received)
+}
+
+func (c *Client) fetchPIR(index int) (Record, error) {
+	if c.meta.HEScheme() != utils.SchemeBGV {
+		return c.fetchScheme(index)
+	}
+	if err := c.bgvKeys(); err != nil {
+		return Record{}, err
+	}
+	encQueryB64, _, err := EncryptQueryBase64(c.params, c.pk, index, c.meta.NRecords, c.meta.RecordS)
+	if err != nil {
+		return Record{}, err
+	}
+	res, err := c.Contract.EvaluateTransaction("PIRQuery", encQueryB64)
+	if err != nil {
+		return Record{}, fmt.Errorf("PIRQuery: %w", err)
+	}
+	decoded, err := DecryptResultPacked(c.params, c.sk, string(res), index, c.meta.NRecords, c.meta.RecordS, c.meta.Packing)
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{Index: index, JSONString: decoded.JSONString, Path: PathPIR, Bytes: len(res)}, nil
+}
+
+// bgvKeys generates the BGV keys of c.meta unless the cached ones fit.
+func (c *Client) bgvKeys() error {
+	if c.sk != nil && c.params.LogN() == c.meta.LogN {
+		return nil
+	}
+	params, sk, pk, err := GenKeysFromMetadata(*c.meta)
+	if err != nil {
+		return err
+	}
+	c.params, c.sk, c.pk = params, sk, pk
+	return nil
+}
+
+// fetchScheme is fetchPIR for a dataset built under BFV or CKKS, through
+// the he engine.
+func (c *Client) fetchScheme(index int) (Record, error) {
+	if c.scheme == nil || c.scheme.Params.LogN() != c.meta.LogN || he.SchemeOf(c.scheme.Params) != c.meta.HEScheme() {
+		keys, err := GenSchemeKeys(*c.meta)
+		if err != nil {
+			return Record{}, err
+		}
+		c.scheme = &keys
+	}
+	encQueryB64, _, err := c.scheme.EncryptQuery(*c.meta, index)
+	if err != nil {
+		return Record{}, err
+	}
+	res, err := c.Contract.EvaluateTransaction("PIRQuery", encQueryB64)
+	if err != nil {
+		return Record{}, fmt.Errorf("PIRQuery: %w", err)
+	}
+	decoded, err := c.scheme.DecryptResult(*c.meta, string(res), index)
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{Index: index, JSONString: decoded.JSONString, Path: PathPIR, Bytes: len(res)}, nil
+}
+
+// fetchCold runs PIR for index under c.MetaHint in one MetaAndQuery call
+// and caches the metadata it returns. ok is false, and Fetch goes on as
+// without a hint, when the server has no MetaAndQuery (or the call failed)
+// or when the dataset no longer matches the hint.
+func (c *Client) fetchCold(index int) (rec Record, ok bool, err error) {
+	hint := *c.MetaHint
+	if index < 0 || index >= hint.NRecords {
+		return Record{}, false, nil
+	}
+	params, sk, pk, err := GenKeysFromMetadata(hint)
+	if err != nil {
+		return Record{}, false, err
+	}
+	encQueryB64, _, err := EncryptQueryBase64(params, pk, index, hint.NRecords, hint.RecordS)
+	if err != nil {
+		return Record{}, false, err
+	}
+	raw, err := c.Contract.EvaluateTransaction("MetaAndQuery", encQueryB64)
+	if err != nil {
+		if Debug {
+			fmt.Printf("[DBG] MetaAndQuery failed, falling back to GetMetadata: %v\n", err)
+		}
+		return Record{}, false, nil
+	}
+	var mq utils.MetaQuery
+	if err := json.Unmarshal(raw, &mq); err != nil {
+		return Record{}, false, fmt.Errorf("parse MetaAndQuery: %w", err)
+	}
+	if err := (Probe{Capabilities: mq.Capabilities}).Check(he.Default.Name()); err != nil {
+		return Record{}, false, err
+	}
+	meta := mq.Metadata
+	c.meta, c.caps, c.MetaHint = &meta, mq.Capabilities, &meta
+	if mq.Result == "" || !meta.Equal(hint) {
+		if Debug {
+			fmt.Printf("[DBG] MetaAndQuery: hint is stale (%s), querying again\n", mq.QueryError)
+		}
+		return Record{}, false, nil
+	}
+	c.params, c.sk, c.pk = params, sk, pk
+	decoded, err := DecryptResultPacked(params, sk, mq.Result, index, meta.NRecords, meta.RecordS, meta.Packing)
+	if err != nil {
+		return Record{}, false, err
+	}
+	return Record{Index: index, JSONString: decoded.JSONString, Path: PathPIR, Bytes: len(raw)}, true, nil
+}
+
+// coverIntN picks fetchHybrid's cover position (seeded by
+// SeedResearchRandomness).
+var coverIntN = rand.IntN
+
+// fetchHybrid serves index from the local copy, patched through
+// PIRQueryDelta when records changed since c.version.
+func (c *Client) fetchHybrid(index int) (Record, error) {
+	raw, err := c.Contract.EvaluateTransaction("GetDelta", strconv.Itoa(c.version))
+	if err != nil {
+		return Record{}, fmt.Errorf("GetDelta: %w", err)
+	}
+	received := len(raw)
+	if res, _, ok := utils.UnwrapTimed(raw); ok {
+		raw = res
+	}
+	var info utils.DeltaInfo
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return Record{}, fmt.Errorf("parse GetDelta: %w", err)
+	}
+	if info.Full || info.RecordS != c.meta.RecordS {
+		// cannot patch the copy: start over from a fresh download
+		c.Refresh()
+		return c.fetch(index)
+	}
+	if index < 0 || index >= info.N {
+		return Record{}, fmt.Errorf("index %d out of range 0..%d", index, info.N-1)
+	}
+	if len(info.Changed) == 0 {
+		return c.localRecord(index, PathLocal, received)
+	}
+
+	pos := -1
+	for k, i := range info.Changed {
+		if i == index {
+			pos = k
+		}
+	}
+	query := pos
+	if query < 0 {
+		query = coverIntN(len(info.Changed))
+	}
+	params, err := utils.DeltaParams(info, *c.meta)
+	if err != nil {
+		return Record{}, err
+	}
+	if c.sk == nil || c.params.LogN() != params.LogN() {
+		c.sk, c.pk = bgv.NewKeyGenerator(params).GenKeyPairNew()
+		c.params = params
+	}
+	encQueryB64, _, err := EncryptQueryBase64(c.params, c.pk, query, len(info.Changed), info.RecordS)
+	if err != nil {
+		return Record{}, err
+	}
+	res, err := c.Contract.EvaluateTransaction("PIRQueryDelta", strconv.Itoa(c.version), encQueryB64)
+	if err != nil {
+		return Record{}, fmt.Errorf("PIRQueryDelta: %w", err)
+	}
+	received += len(res)
+	if pos < 0 {
+		return c.localRecord(index, PathDeltaPIR, received)
+	}
+	decoded, err := DecryptResultPacked(c.params, c.sk, string(res), query, len(info.Changed), info.RecordS, info.Packing)
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{Index: index, JSONString: decoded.JSONString, Path: PathDeltaPIR, Bytes: received}, nil
+}
+
+// localRecord reads index from the downloaded copy.
+func (c *Client) localRecord(index int, path Path, received int) (Record, error) {
+	if index >= len(c.dataset) {
+		return Record{}, fmt.Errorf("index %d not in the local copy (%d records)", index, len(c.dataset))
+	}
+	rec := utils.TrimPadding(c.dataset[index])
+	if len(rec) == 0 {
+		rec = []byte(utils.EmptyRecord) // reserved index
+	}
+	if !json.Valid(rec) {
+		return Record{}, errors.New("cached record is not valid JSON")
+	}
+	return Record{Index: index, JSONString: string(rec), Path: path, Bytes: received}, nil
+}
+
+// ChangesSince reads the change feed (GetChangesSince) after m_DB_version
+// since up to the current version, following More. A mirror applies the
+// changes in order and resumes from the returned feed's Until; a
+// ChangeReset means it must download the dataset again.
+func ChangesSince(ev Evaluator, since int) (utils.ChangeFeed, error) {
+	feed := utils.ChangeFeed{Since: since, Until: since, Changes: []utils.RecordChange{}}
+	for {
+		raw, err := ev.EvaluateTransaction("GetChangesSince", strconv.Itoa(feed.Until))
+		if err != nil {
+			return feed, fmt.Errorf("GetChangesSince: %w", err)
+		}
+		if res, _, ok := utils.UnwrapTimed(raw); ok {
+			raw = res
+		}
+		var page utils.ChangeFeed
+		if err := json.Unmarshal(raw, &page); err != nil {
+			return feed, fmt.Errorf("parse GetChangesSince: %w", err)
+		}
+		feed.Changes = append(feed.Changes, page.Changes...)
+		feed.Until = page.Until
+		if !page.More || page.Until <= page.Since {
+			return feed, nil
+		}
+	}
+}
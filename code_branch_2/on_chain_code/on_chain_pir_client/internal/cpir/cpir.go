@@ -1,13 +1,21 @@
 package cpir
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"sync"
 
 	"github.com/tuneinsight/lattigo/v6/core/rlwe"
 	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
+
+	"pir_shared/he"
+	"pir_shared/utils"
 )
 
 /********************************************************************
@@ -15,14 +23,98 @@ import (
  ********************************************************************/
 var Debug = true
 
-type Metadata struct {
-	NRecords int    `json:"n"`        // db size
-	RecordS  int    `json:"record_s"` // slots per record
-	LogN     int    `json:"logN"`
-	N        int    `json:"N"`
-	T        uint64 `json:"t"`
-	LogQi    []int  `json:"logQi"`
-	LogPi    []int  `json:"logPi"`
+// Metadata mirrors the server's GetMetadata response (n = db size,
+// record_s = slots per record). Shared with both server flavours.
+type Metadata = utils.Metadata
+
+// ParseMetadata decodes a GetMetadata / GetMetadataTimed response.
+// execMS is the server-side time, or -1 if the server did not report it.
+func ParseMetadata(raw []byte) (meta Metadata, execMS float64, err error) {
+	return utils.ParseMetadata(raw)
+}
+
+// Capabilities is the server feature handshake (see GetCapabilities).
+type Capabilities = utils.Capabilities
+
+// IndexContract maps record index i ↔ "record%03d" ↔ slot window i; the
+// selector and the decrypted window are both taken from it.
+type IndexContract = utils.IndexContract
+
+// CheckSelector compares a DescribeSelector response with the window this
+// client derives from meta for index; a mismatch means the two sides
+// disagree on packing and the PIR result would be the wrong record.
+func CheckSelector(meta Metadata, index int, raw []byte) error {
+	var got utils.SelectorLayout
+	if err := json.Unmarshal(raw, &got); err != nil {
+		return fmt.Errorf("parse DescribeSelector: %w", err)
+	}
+	want, err := utils.NewIndexContract(meta).Describe(index)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("selector mismatch for index %d: server %+v, client %+v", index, got, want)
+	}
+	return nil
+}
+
+// ParseCapabilities decodes GetCapabilities output; a callErr saying the
+// server does not know GetCapabilities (utils.IsUnknownFunction) means it
+// predates the handshake and yields the legacy feature set. Any other
+// callErr is returned.
+func ParseCapabilities(raw []byte, callErr error) (Capabilities, error) {
+	return utils.ParseCapabilities(raw, callErr)
+}
+
+// Probe is the compatibility probe (see utils.Probe).
+type Probe = utils.Probe
+
+// ProbeServer calls Probe on ev, falling back to GetCapabilities for
+// chaincode that predates it, and returns an error naming the mismatch
+// when this client cannot talk to the server: another protocol version or
+// another HE library (he.Default). Clients call it on connect.
+func ProbeServer(ev Evaluator) (Probe, error) {
+	raw, callErr := ev.EvaluateTransaction("Probe")
+	var capsRaw []byte
+	var capsErr error
+	if callErr != nil {
+		capsRaw, capsErr = ev.EvaluateTransaction("GetCapabilities")
+	}
+	p, err := utils.ParseProbe(raw, callErr, capsRaw, capsErr)
+	if err != nil {
+		return Probe{}, err
+	}
+	if err := p.Check(he.Default.Name()); err != nil {
+		return Probe{}, err
+	}
+	return p, nil
+}
+
+// SeedResearchRandomness makes key generation and query encryption
+// reproducible from seed (see utils.SeedRandomness) until restore is
+// called, so noise-growth and correctness runs compare across machines.
+// The hybrid client's cover index is drawn from the seed as well.
+// RESEARCH ONLY: a query encrypted meanwhile hides its index from nobody
+// who knows the seed. Never use it against a real server.
+func SeedResearchRandomness(seed string) (restore func()) {
+	fmt.Println("[WARN] research mode: encryption randomness is seeded, queries are NOT private")
+	restoreCrand := utils.SeedRandomness(utils.NewSeededReader([]byte(seed)))
+	prevCover := coverIntN
+	coverIntN = rand.New(rand.NewChaCha8(sha256.Sum256([]byte("cover:" + seed)))).IntN
+	return func() {
+		restoreCrand()
+		coverIntN = prevCover
+	}
+}
+
+// SeedFromEnv calls SeedResearchRandomness with $PIR_RESEARCH_SEED when it
+// is set; otherwise restore is a no-op.
+func SeedFromEnv() (restore func()) {
+	seed, ok := os.LookupEnv(utils.ResearchSeedEnv)
+	if !ok {
+		return func() {}
+	}
+	return SeedResearchRandomness(seed)
 }
 
 // ---------- 1. Key & Parameter helpers ----------
@@ -37,17 +129,45 @@ type Metadata struct {
 //     but must be non-empty if you later add relinearisation)
 //   - PlaintextModulus: an NTT-friendly prime (T ≡ 1 mod 2·N)
 //     65537 is the textbook choice.
+//
+// Datasets built under another scheme (Metadata.Scheme) need
+// GenSchemeKeys instead. The parameters come from ParamCache
+// (ParamsFromMetadata); the keys are fresh on every call (GenKeyPair).
 func GenKeysFromMetadata(meta Metadata) (bgv.Parameters, *rlwe.SecretKey, *rlwe.PublicKey, error) {
-	lit := bgv.ParametersLiteral{
+	if s := meta.HEScheme(); s != utils.SchemeBGV {
+		return bgv.Parameters{}, nil, nil, fmt.Errorf("dataset is %s, not BGV: use GenSchemeKeys", s)
+	}
+	params, err := ParamsFromMetadata(meta)
+	if err != nil {
+		return params, nil, nil, err
+	}
+	sk, pk := GenKeyPair(params)
+	return params, sk, pk, nil
+}
+
+// ParamCache holds the bgv.Parameters built for metadata, most recently
+// used first. Timed benches read and purge it to tell parameter
+// construction from key sampling.
+var ParamCache = utils.NewParamCache(utils.DefaultParamCacheSize)
+
+// MetadataLiteral is the ParametersLiteral of meta.
+func MetadataLiteral(meta Metadata) bgv.ParametersLiteral {
+	return bgv.ParametersLiteral{
 		LogN:             meta.LogN,
 		LogQ:             meta.LogQi,
 		LogP:             meta.LogPi,
 		PlaintextModulus: meta.T,
 	}
-	params, err := bgv.NewParametersFromLiteral(lit)
-	if err != nil {
-		return params, nil, nil, err
-	}
+}
+
+// ParamsFromMetadata returns meta's parameters from ParamCache, building
+// them on a miss.
+func ParamsFromMetadata(meta Metadata) (bgv.Parameters, error) {
+	return ParamCache.Params(MetadataLiteral(meta))
+}
+
+// GenKeyPair samples a fresh key pair under params.
+func GenKeyPair(params bgv.Parameters) (*rlwe.SecretKey, *rlwe.PublicKey) {
 	kgen := bgv.NewKeyGenerator(params)
 	sk, pk := kgen.GenKeyPairNew()
 
@@ -57,7 +177,7 @@ func GenKeysFromMetadata(meta Metadata) (bgv.Parameters, *rlwe.SecretKey, *rlwe.
 			params.MaxLevel(), len(params.Q()))
 		fmt.Printf("       MaxSlots     : %d\n", params.MaxSlots())
 	}
-	return params, sk, pk, nil
+	return sk, pk
 }
 
 // legacy
@@ -99,36 +219,29 @@ func GenKeys() (bgv.Parameters, *rlwe.SecretKey, *rlwe.PublicKey, error) {
 // EncryptQueryBase64 creates a one-hot vector for index i and returns
 // the ciphertext as Base64 (ready to send to chaincode).
 func EncryptQueryBase64(params bgv.Parameters, pk *rlwe.PublicKey, index, dbSize int, slotsPerRec int) (string, int, error) {
-	if index < 0 || index >= dbSize {
-		return "", 0, fmt.Errorf("index %d out of range 0..%d", index, dbSize-1)
+	ic := IndexContract{NRecords: dbSize, RecordS: slotsPerRec, Slots: params.MaxSlots()}
+	if err := ic.ValidateSharded(); err != nil {
+		return "", 0, err
+	}
+	// the window within index's m_DB shard; the server applies the
+	// selector to every shard
+	w, err := ic.Describe(index)
+	if err != nil {
+		return "", 0, err
 	}
+	startSlot, endSlot := w.StartSlot, w.EndSlot
 	slots := params.MaxSlots() // ≤ 8192 in our 2¹³ setup
 	fmt.Printf("       slots length  : %d\n", slots)
 
-	if dbSize*slotsPerRec > slots {
-		return "", 0, fmt.Errorf("dbSize (%d) exceeds slot capacity (%d)", dbSize, slots)
-	}
-
-	encoder := bgv.NewEncoder(params)
 	encryptor := bgv.NewEncryptor(params, pk)
 
-	// 1. Build multi-hot vector of full slot length (padding zeros automatically OK)
-	vec := make([]uint64, slots)
-	startSlot := index * slotsPerRec // <── shift
-	if startSlot+slotsPerRec > slots {
-		return "", 0, fmt.Errorf("index out of range")
-	}
-	for i := 0; i < slotsPerRec; i++ { // <── 64 ones
-		vec[startSlot+i] = 1
-	}
-	if Debug {
-		fmt.Printf("[DBG] ENC Active slots [%d:%d]:\n", startSlot, startSlot+slotsPerRec-1)
-		fmt.Printf("[DBG] SelectorVec = %v\n", vec[startSlot:startSlot+slotsPerRec])
-	}
-
-	// 2. Encode at *max level* for best noise budget
-	pt := bgv.NewPlaintext(params, params.MaxLevel()) // len(Q)-1
-	if err := encoder.Encode(vec, pt); err != nil {
+	// 1.+2. Selector plaintext: precomputed (PrecomputeSelector) or encoded now
+	pt := cachedSelector(params, ic, index)
+	if pt != nil {
+		if Debug {
+			fmt.Printf("[DBG] ENC Active slots [%d:%d]: precomputed selector\n", startSlot, endSlot-1)
+		}
+	} else if pt, err = encodeSelector(params, startSlot, endSlot); err != nil {
 		return "", 0, err
 	}
 
@@ -156,6 +269,111 @@ func EncryptQueryBase64(params bgv.Parameters, pk *rlwe.PublicKey, index, dbSize
 	return b64, len(ctBytes), nil
 }
 
+// encodeSelector encodes the multi-hot selector of slots [startSlot,
+// endSlot) at max level.
+func encodeSelector(params bgv.Parameters, startSlot, endSlot int) (*rlwe.Plaintext, error) {
+	// 1. Build multi-hot vector of full slot length (padding zeros automatically OK)
+	vec := make([]uint64, params.MaxSlots())
+	for i := startSlot; i < endSlot; i++ { // <── record_s ones
+		vec[i] = 1
+	}
+	if Debug {
+		fmt.Printf("[DBG] ENC Active slots [%d:%d]:\n", startSlot, endSlot-1)
+		fmt.Printf("[DBG] SelectorVec = %v\n", vec[startSlot:endSlot])
+	}
+
+	// 2. Encode at *max level* for best noise budget
+	pt := bgv.NewPlaintext(params, params.MaxLevel()) // len(Q)-1
+	if err := bgv.NewEncoder(params).Encode(vec, pt); err != nil {
+		return nil, err
+	}
+	return pt, nil
+}
+
+// WindowTable is the GetWindowTable response (see utils.WindowTable).
+type WindowTable = utils.WindowTable
+
+// ParseWindowTable decodes a GetWindowTable response and checks it
+// against its own generator fields.
+func ParseWindowTable(raw []byte) (WindowTable, error) {
+	var t WindowTable
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return WindowTable{}, fmt.Errorf("parse window table: %w", err)
+	}
+	if err := t.Check(); err != nil {
+		return WindowTable{}, err
+	}
+	return t, nil
+}
+
+// selectors holds the selector plaintexts PrecomputeSelector encoded for
+// one layout; EncryptQueryBase64 takes them from here instead of encoding.
+var selectors struct {
+	sync.Mutex
+	params bgv.Parameters
+	table  WindowTable
+	pts    map[int]*rlwe.Plaintext
+}
+
+// UseWindowTable sets the params and window table PrecomputeSelector
+// encodes under. Cached selectors survive when table has the same
+// layout_id as before (e.g. a newer m_DB_version) and are dropped otherwise.
+func UseWindowTable(params bgv.Parameters, table WindowTable) error {
+	if err := table.Check(); err != nil {
+		return err
+	}
+	if table.LogN != params.LogN() || table.T != params.PlaintextModulus() ||
+		table.Slots != params.MaxSlots() || !slices.Equal(table.LogQi, params.LogQi()) {
+		return fmt.Errorf("window table (logN=%d t=%d logQi=%v) does not match params (logN=%d t=%d logQi=%v)",
+			table.LogN, table.T, table.LogQi, params.LogN(), params.PlaintextModulus(), params.LogQi())
+	}
+	selectors.Lock()
+	defer selectors.Unlock()
+	if selectors.table.LayoutID != table.LayoutID || !selectors.params.Equal(&params) {
+		selectors.pts = map[int]*rlwe.Plaintext{}
+	}
+	selectors.params, selectors.table = params, table
+	return nil
+}
+
+// PrecomputeSelector encodes the selector plaintext of index under the
+// table set by UseWindowTable and caches it, so that EncryptQueryBase64
+// for index only encrypts. Call it ahead of time for frequently accessed
+// records.
+func PrecomputeSelector(index int) error {
+	selectors.Lock()
+	defer selectors.Unlock()
+	if selectors.pts == nil {
+		return errors.New("PrecomputeSelector: no window table (call UseWindowTable first)")
+	}
+	if _, ok := selectors.pts[index]; ok {
+		return nil
+	}
+	w, err := selectors.table.Contract().Describe(index)
+	if err != nil {
+		return fmt.Errorf("PrecomputeSelector: %w", err)
+	}
+	pt, err := encodeSelector(selectors.params, w.StartSlot, w.EndSlot)
+	if err != nil {
+		return fmt.Errorf("PrecomputeSelector: %w", err)
+	}
+	selectors.pts[index] = pt
+	return nil
+}
+
+// cachedSelector returns the precomputed selector of index if it was
+// encoded for the same params and layout, else nil.
+func cachedSelector(params bgv.Parameters, ic IndexContract, index int) *rlwe.Plaintext {
+	selectors.Lock()
+	defer selectors.Unlock()
+	tc := selectors.table.Contract()
+	tc.Packing = ic.Packing // selectors cover whole slots under any packing
+	if selectors.pts == nil || tc != ic || !selectors.params.Equal(&params) {
+		return nil
+	}
+	return selectors.pts[index]
+}
+
 // ---------- 3. Decrypt result ----------
 
 // DecryptResult decodes the Base64 ciphertext returned by chaincode,
@@ -163,10 +381,13 @@ func EncryptQueryBase64(params bgv.Parameters, pk *rlwe.PublicKey, index, dbSize
 type Decoded struct {
 	IntValue   uint64 // if single slot record
 	JSONString string // if record was multi-slot JSON
+	Empty      bool   // all-zero window (reserved index); JSONString is utils.EmptyRecord
 }
 
 // DecryptResult decrypts the ciphertext (base64) and extracts either
-// a single-slot integer or a multi-slot JSON string.
+// a single-slot integer or a multi-slot JSON string. For a sharded m_DB
+// the response holds one ciphertext per shard (utils.EncodeShardResults)
+// and only the shard holding index is decrypted.
 //
 //   - index           : record index originally queried (0-based)
 //   - dbSize          : total number of records in the DB
@@ -174,16 +395,32 @@ type Decoded struct {
 //     several slots (e.g. JSON bytes)
 func DecryptResult(params bgv.Parameters, sk *rlwe.SecretKey, encResBase64 string,
 	index, dbSize, slotsPerRecord int) (Decoded, error) {
+	return decryptResult(params, sk, encResBase64, index, dbSize, slotsPerRecord, utils.Packing1B, 1)
+}
+
+// DecryptResultPacked is DecryptResult for a dataset packed under packing
+// (Metadata.Packing): under "2b" slotsPerRecord is the halved record_s and
+// every slot yields two bytes.
+func DecryptResultPacked(params bgv.Parameters, sk *rlwe.SecretKey, encResBase64 string,
+	index, dbSize, slotsPerRecord int, packing string) (Decoded, error) {
+	return decryptResult(params, sk, encResBase64, index, dbSize, slotsPerRecord, packing, 1)
+}
+
+// decryptResult is DecryptResultPacked for result ciphertexts of the
+// given degree (2 for PIRQuery2D).
+func decryptResult(params bgv.Parameters, sk *rlwe.SecretKey, encResBase64 string,
+	index, dbSize, slotsPerRecord int, packing string, degree int) (Decoded, error) {
 
 	var out Decoded
 
-	/* 1) Deserialse ------------------------------------------------ */
-	raw, err := base64.StdEncoding.DecodeString(encResBase64)
+	/* 1) Pick the shard, deserialise ------------------------------- */
+	ic := IndexContract{NRecords: dbSize, RecordS: slotsPerRecord, Slots: params.MaxSlots(), Packing: packing}
+	w, raw, err := shardResult(ic, index, encResBase64)
 	if err != nil {
 		return out, err
 	}
-	ct := rlwe.NewCiphertext(params, 1)
-	if err = ct.UnmarshalBinary(raw); err != nil {
+	ct, err := utils.UnmarshalCiphertext(params, raw, degree)
+	if err != nil {
 		return out, err
 	}
 
@@ -194,22 +431,60 @@ func DecryptResult(params bgv.Parameters, sk *rlwe.SecretKey, encResBase64 strin
 	if err = bgv.NewEncoder(params).Decode(pt, plainvec); err != nil {
 		return out, err
 	}
+	if LeakCheck && degree == 1 {
+		if err := checkLeaks(ic, index, plainvec); err != nil {
+			return out, err
+		}
+	}
+	return decodeWindow(plainvec, w.StartSlot, w.EndSlot, slotsPerRecord, packing)
+}
+
+// shardResult returns index's window and the raw result ciphertext of
+// its shard from a PIRQuery response.
+func shardResult(ic IndexContract, index int, encResBase64 string) (utils.SelectorLayout, []byte, error) {
+	if err := ic.ValidateSharded(); err != nil {
+		return utils.SelectorLayout{}, nil, err
+	}
+	w, err := ic.Describe(index)
+	if err != nil {
+		return w, nil, err
+	}
+	results, err := utils.DecodeShardResults(encResBase64)
+	if err != nil {
+		return w, nil, err
+	}
+	if len(results) != ic.Shards() {
+		return w, nil, fmt.Errorf("%d result ciphertexts for %d shard(s)", len(results), ic.Shards())
+	}
+	raw, err := base64.StdEncoding.DecodeString(results[w.Shard])
+	if err != nil {
+		return w, nil, err
+	}
+	return w, raw, nil
+}
+
+// decodeWindow extracts the record in slots [start, end) of a decoded
+// result: the integer of a single-slot record, else the JSON bytes, one
+// or two per slot under packing, up to the first zero.
+func decodeWindow(plainvec []uint64, start, end, slotsPerRecord int, packing string) (Decoded, error) {
+	var out Decoded
 
 	/* 3) Extracting requested CTI record -------------------------------------- */
-	if len(plainvec) < dbSize*slotsPerRecord {
+	if end > len(plainvec) {
 		return out, errors.New("decoded vector shorter than expected")
 	}
 
-	start := index * slotsPerRecord // ← left border
-	end := start + slotsPerRecord   // ← WITHOUT end
-
-	// Collecting zero bytes
+	// Collecting bytes up to the padding
 	var buf []byte
-	for _, v := range plainvec[start:end] {
-		if v == 0 {
-			break
-		} // meet padding → stop
-		buf = append(buf, byte(v))
+	if utils.BytesPerSlot(packing) == 2 {
+		buf = utils.TrimPadding(IndexContract{RecordS: slotsPerRecord, Packing: packing}.UnpackWindow(plainvec[start:end]))
+	} else {
+		for _, v := range plainvec[start:end] {
+			if v == 0 {
+				break
+			} // meet padding → stop
+			buf = append(buf, byte(v))
+		}
 	}
 
 	/* 4) Prints / checks -------------------------------------------- */
@@ -226,9 +501,56 @@ func DecryptResult(params bgv.Parameters, sk *rlwe.SecretKey, encResBase64 strin
 		return out, nil
 	}
 
+	if len(buf) == 0 {
+		out.JSONString, out.Empty = utils.EmptyRecord, true
+		return out, nil
+	}
 	if !json.Valid(buf) {
 		return out, errors.New("decoded payload is not valid JSON")
 	}
 	out.JSONString = string(buf)
 	return out, nil
 }
+
+// ---------- 4. Data-owner m_DB encode (PutMDB) ----------
+
+// EncodeDB packs records exactly as the chaincode does (IndexContract.Pack)
+// and encodes them at the max level of params. It returns the marshalled
+// plaintext and the utils.MDBUpload metadata PutMDB verifies it against.
+func EncodeDB(params bgv.Parameters, records [][]byte) ([]byte, utils.MDBUpload, error) {
+	ic := IndexContract{NRecords: len(records), RecordS: utils.CalcSlotsPerRec(records), Slots: params.MaxSlots()}
+	if err := ic.Validate(); err != nil {
+		return nil, utils.MDBUpload{}, err
+	}
+	packed, err := ic.Pack(records)
+	if err != nil {
+		return nil, utils.MDBUpload{}, err
+	}
+	pt := bgv.NewPlaintext(params, params.MaxLevel())
+	if err := bgv.NewEncoder(params).Encode(packed, pt); err != nil {
+		return nil, utils.MDBUpload{}, fmt.Errorf("encode DB: %w", err)
+	}
+	raw, err := pt.MarshalBinary()
+	if err != nil {
+		return nil, utils.MDBUpload{}, fmt.Errorf("marshal DB: %w", err)
+	}
+
+	meta := utils.MDBUpload{
+		LogN:         params.LogN(),
+		LogQi:        params.LogQi(),
+		LogPi:        params.LogPi(),
+		T:            params.PlaintextModulus(),
+		NRecords:     ic.NRecords,
+		RecordS:      ic.RecordS,
+		SHA256:       utils.RecordHash(raw),
+		RecordHashes: make([]string, len(records)),
+	}
+	for i, rec := range records {
+		meta.RecordHashes[i] = utils.RecordHash(rec)
+	}
+	if Debug {
+		fmt.Printf("[DBG] EncodeDB: n=%d record_s=%d LogN=%d → %d bytes\n",
+			meta.NRecords, meta.RecordS, meta.LogN, len(raw))
+	}
+	return raw, meta, nil
+}
@@ -0,0 +1,131 @@
+package cpir
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"pir_shared/utils"
+)
+
+// ---------- 10. Provenance export ----------
+
+// Receipt is the provenance of one retrieved record: when it was asked for
+// and received and, as far as the retrieval path reveals it, which
+// database state answered. An evaluate-only Fetch leaves no transaction,
+// so TxID, Block and the m_DB fields come from an audited query
+// (PIRQuerySubmit / PublicQuerySubmit, then GetAuditRecord); a Hybrid
+// client knows DBVersion of its local copy. Zero fields are omitted.
+type Receipt struct {
+	TxID        string    `json:"tx_id,omitempty"`
+	Block       uint64    `json:"block,omitempty"`        // block the audit tx committed in
+	DBVersion   int       `json:"db_version,omitempty"`   // m_DB_version
+	MDBSHA256   string    `json:"mdb_sha256,omitempty"`   // hex, as committed under m_DB_sha256
+	MDBAlg      string    `json:"mdb_alg,omitempty"`      // its m_DB_digest_alg; empty: sha256
+	CommittedAt string    `json:"committed_at,omitempty"` // tx timestamp, RFC 3339, UTC
+	RequestedAt time.Time `json:"requested_at"`
+	ReceivedAt  time.Time `json:"received_at"`
+}
+
+// ParseAuditReceipt fills the transaction and m_DB fields of a Receipt
+// from a GetAuditRecord result. Block is not in the audit record; the
+// submitter has it from the commit status.
+func ParseAuditReceipt(raw []byte) (Receipt, error) {
+	if res, _, ok := utils.UnwrapTimed(raw); ok {
+		raw = res
+	}
+	var a struct {
+		TxID       string `json:"tx_id"`
+		Timestamp  int64  `json:"timestamp"`
+		MDBSHA256  string `json:"mdb_sha256"`
+		MDBAlg     string `json:"mdb_digest_alg"`
+		MDBVersion int    `json:"mdb_version"`
+	}
+	if err := json.Unmarshal(raw, &a); err != nil {
+		return Receipt{}, fmt.Errorf("parse audit record: %w", err)
+	}
+	if a.TxID == "" {
+		return Receipt{}, fmt.Errorf("parse audit record: no tx_id")
+	}
+	r := Receipt{TxID: a.TxID, DBVersion: a.MDBVersion, MDBSHA256: a.MDBSHA256, MDBAlg: a.MDBAlg}
+	if a.Timestamp > 0 {
+		r.CommittedAt = time.Unix(a.Timestamp, 0).UTC().Format(time.RFC3339)
+	}
+	return r, nil
+}
+
+// FetchReceipt is Fetch that also returns the record's Receipt, with the
+// request timestamps and, for a Hybrid client, the version of the copy
+// the record came from.
+func (c *Client) FetchReceipt(index int) (Record, Receipt, error) {
+	r := Receipt{RequestedAt: time.Now().UTC()}
+	rec, err := c.Fetch(index)
+	if err != nil {
+		return Record{}, Receipt{}, err
+	}
+	r.ReceivedAt = time.Now().UTC()
+	if c.hybrid() && c.dataset != nil {
+		r.DBVersion = c.version
+	}
+	return rec, r, nil
+}
+
+// ExportEntry is one line of an ExportWriter: the decrypted record, as
+// JSON (a JSON string when it is not JSON), with its Receipt.
+type ExportEntry struct {
+	Index       int             `json:"index"`
+	Record      json.RawMessage `json:"record"`
+	Path        Path            `json:"path"`
+	Bytes       int             `json:"bytes"` // received from the chaincode
+	Annotations map[string]any  `json:"annotations,omitempty"`
+	Receipt     Receipt         `json:"receipt"`
+}
+
+// ExportWriter writes retrieved records as JSON Lines, one ExportEntry per
+// record, so what was retrieved, and under which database state, can be
+// archived next to the results (e.g. for artifact evaluation). It is safe
+// for concurrent use; it does not buffer, so wrap w in a bufio.Writer for
+// large exports.
+type ExportWriter struct {
+	mtx sync.Mutex
+	enc *json.Encoder
+	n   int
+}
+
+// NewExportWriter returns an ExportWriter appending to w.
+func NewExportWriter(w io.Writer) *ExportWriter {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &ExportWriter{enc: enc}
+}
+
+// Write appends rec and its receipt as one line.
+func (x *ExportWriter) Write(rec Record, receipt Receipt) error {
+	e := ExportEntry{
+		Index:       rec.Index,
+		Record:      json.RawMessage(rec.JSONString),
+		Path:        rec.Path,
+		Bytes:       rec.Bytes,
+		Annotations: rec.Annotations,
+		Receipt:     receipt,
+	}
+	if !json.Valid(e.Record) {
+		e.Record, _ = json.Marshal(rec.JSONString)
+	}
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	if err := x.enc.Encode(e); err != nil {
+		return fmt.Errorf("export record %d: %w", rec.Index, err)
+	}
+	x.n++
+	return nil
+}
+
+// Count returns the number of lines written.
+func (x *ExportWriter) Count() int {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	return x.n
+}
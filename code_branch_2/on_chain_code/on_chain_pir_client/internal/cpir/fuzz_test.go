@@ -0,0 +1,33 @@
+package cpir
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
+
+	"pir_shared/utils"
+)
+
+// FuzzDecryptResult fuzzes the ct_r handling in DecryptResult (see the
+// Makefile's fuzz target; go test runs its seeds): resp is what the peer
+// returned for PIRQuery, index a record of a 64 x 128 layout.
+func FuzzDecryptResult(f *testing.F) {
+	p, err := utils.BuildParamsFromHint(utils.BGVParamHint{LogN: utils.MinLogN})
+	if err != nil {
+		f.Fatal(err)
+	}
+	sk := bgv.NewKeyGenerator(p).GenSecretKeyNew()
+	raw, err := rlwe.NewCiphertext(p, 1, p.MaxLevel()).MarshalBinary()
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(uint8(0), base64.StdEncoding.EncodeToString(raw))
+	f.Add(uint8(63), utils.EncodeShardResults([]string{base64.StdEncoding.EncodeToString(raw)}))
+	f.Add(uint8(5), base64.StdEncoding.EncodeToString(raw[:len(raw)/2]))
+	f.Add(uint8(1), "")
+	f.Fuzz(func(t *testing.T, index uint8, resp string) {
+		_, _ = DecryptResult(p, sk, resp, int(index)%64, 64, 128)
+	})
+}
@@ -0,0 +1,61 @@
+package cpir
+
+import (
+	"errors"
+	"fmt"
+
+	"pir_shared/utils"
+)
+
+// ---------- 9. Record hooks ----------
+
+// RecordHook post-processes a record Fetch or FetchBatch retrieved, before
+// it is returned: enrichment (reputation lookups, local correlation) goes
+// into rec.Annotations, and an error rejects the record, failing the call
+// with ErrRecordRejected. Hooks see reserved indices too, as
+// utils.EmptyRecord.
+type RecordHook func(rec *Record) error
+
+// ErrRecordRejected wraps the error of the hook that rejected a record.
+var ErrRecordRejected = errors.New("record rejected")
+
+// OnRecord appends h to the hooks run, in registration order, on every
+// retrieved record. Register hooks before fetching concurrently.
+func (c *Client) OnRecord(h RecordHook) {
+	c.hooks = append(c.hooks, h)
+}
+
+// runHooks applies the hooks to rec.
+func (c *Client) runHooks(rec *Record) error {
+	for _, h := range c.hooks {
+		if err := h(rec); err != nil {
+			return fmt.Errorf("%w: index %d: %w", ErrRecordRejected, rec.Index, err)
+		}
+	}
+	return nil
+}
+
+// Annotate sets rec.Annotations[key], allocating the map on first use.
+func (rec *Record) Annotate(key string, value any) {
+	if rec.Annotations == nil {
+		rec.Annotations = map[string]any{}
+	}
+	rec.Annotations[key] = value
+}
+
+// SanitizedHook is a built-in hook rejecting records that do not pass
+// utils.SanitizeRecord under opt, e.g. utils.DefaultSanitize: not a JSON
+// object, malformed hashes, longer than opt.MaxRecordLen. It checks the
+// record the server returned against the rules a LoadRecordsFromJSON
+// import enforces; the record itself is left as is.
+func SanitizedHook(opt utils.SanitizeOptions) RecordHook {
+	return func(rec *Record) error {
+		if rec.JSONString == utils.EmptyRecord {
+			return nil
+		}
+		if _, err := utils.SanitizeRecord([]byte(rec.JSONString), opt); err != nil {
+			return err
+		}
+		return nil
+	}
+}
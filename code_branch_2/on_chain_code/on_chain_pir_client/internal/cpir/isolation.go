@@ -0,0 +1,83 @@
+package cpir
+
+import (
+	"fmt"
+	"os"
+
+	"pir_shared/utils"
+)
+
+// ---------- 11. Selector isolation ----------
+
+// The selector of index is 1 on index's window and 0 elsewhere, so the
+// shard result ct_q × m_DB holds that record and nothing else: decrypted
+// against any other window it is all zero. CheckIsolation decrypts a
+// response the way a client would for every wrong index of the shard and
+// counts the non-zero slots outside index's window. Any is a leak of
+// neighbouring records, e.g. a packer and a selector that disagree on
+// the stride. It is a sanity check of the server and the packing, not of
+// the HE: the client can always decrypt its own result. With LeakCheck
+// set every decryption runs the same scan (utils.FindLeaks) and fails on
+// a leak.
+
+// LeakCheck makes every single-query decryption fail when the shard
+// result has a non-zero slot outside the queried window. It scans all N
+// slots, so it is off unless PIR_LEAK_CHECK=1.
+var LeakCheck = os.Getenv("PIR_LEAK_CHECK") == "1"
+
+// IsolationReport is CheckIsolation's result.
+type IsolationReport struct {
+	Index       int   `json:"index"`
+	Shard       int   `json:"shard"`
+	Probed      int   `json:"probed"`           // wrong windows decrypted
+	LeakedSlots int   `json:"leaked_slots"`     // non-zero slots outside index's window
+	Leaked      []int `json:"leaked,omitempty"` // wrong indices whose window is not all zero
+	// Leaks are the non-zero slots, with the record each falls in.
+	Leaks []utils.Leak `json:"leaks,omitempty"`
+}
+
+// Err is nil for an isolated result.
+func (r IsolationReport) Err() error {
+	if r.LeakedSlots == 0 {
+		return nil
+	}
+	return fmt.Errorf("result of index %d is not isolated: %d non-zero slots outside its window (records %v of shard %d)",
+		r.Index, r.LeakedSlots, r.Leaked, r.Shard)
+}
+
+// CheckIsolation decrypts the PIRQuery response to the query of index
+// against every other window of index's shard. The error is for a
+// response that cannot be decrypted; a leak is reported in the result
+// (IsolationReport.Err).
+func (k SchemeKeys) CheckIsolation(meta Metadata, encResBase64 string, index int) (IsolationReport, error) {
+	w, plainvec, err := k.decryptShard(meta, encResBase64, index)
+	if err != nil {
+		return IsolationReport{}, err
+	}
+	ic := IndexContract{NRecords: meta.NRecords, RecordS: meta.RecordS, Slots: k.Params.MaxSlots(), Packing: meta.Packing}
+	leaks, err := ic.FindLeaks(index, plainvec)
+	if err != nil {
+		return IsolationReport{}, err
+	}
+	rep := IsolationReport{Index: index, Shard: w.Shard, LeakedSlots: len(leaks.Leaks), Leaked: leaks.Records(), Leaks: leaks.Leaks}
+	for j := 0; j < ic.NRecords; j++ {
+		if wrong, _ := ic.Describe(j); j != index && wrong.Shard == w.Shard {
+			rep.Probed++
+		}
+	}
+	if Debug {
+		fmt.Printf("[DBG] Isolation     : index=%d shard=%d probed=%d leaked_slots=%d leaked=%v\n",
+			index, w.Shard, rep.Probed, rep.LeakedSlots, rep.Leaked)
+	}
+	return rep, nil
+}
+
+// checkLeaks is the LeakCheck scan of the decrypted shard result of
+// index.
+func checkLeaks(ic IndexContract, index int, plainvec []uint64) error {
+	rep, err := ic.FindLeaks(index, plainvec)
+	if err != nil {
+		return err
+	}
+	return rep.Err()
+}
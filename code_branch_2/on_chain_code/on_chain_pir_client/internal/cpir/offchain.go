@@ -0,0 +1,67 @@
+package cpir
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ---------- 12. Off-chain server evaluator ----------
+
+// The off-chain server answers the chaincode's methods on POST /invoke
+// with the same arguments, so everything written against Evaluator (the
+// Client, pirctl) runs against it unchanged. InitLedger there takes every
+// parameter in one call (off_chain_pir_server) instead of the chaincode's
+// InitLedger + SetScheme/SetPacking + GenerateDataset.
+
+// HTTPEvaluator evaluates on the off-chain server at BaseURL (no
+// /invoke). Token is sent as a bearer token and Dataset selects a dataset
+// other than the default; Client nil is http.DefaultClient.
+type HTTPEvaluator struct {
+	BaseURL string
+	Token   string
+	Dataset string
+	Client  *http.Client
+}
+
+func (h HTTPEvaluator) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	if args == nil {
+		args = []string{}
+	}
+	body, _ := json.Marshal(map[string]any{"method": name, "args": args, "dataset": h.Dataset})
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(h.BaseURL, "/")+"/invoke", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+	hc := h.Client
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var wrap struct {
+		Response string `json:"response"`
+		Error    string `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &wrap); err != nil {
+		return nil, fmt.Errorf("%s: HTTP %d: %w", name, resp.StatusCode, err)
+	}
+	if wrap.Error != "" {
+		return nil, fmt.Errorf("%s", wrap.Error)
+	}
+	return []byte(wrap.Response), nil
+}
@@ -0,0 +1,67 @@
+package cpir
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
+
+	"pir_shared/utils"
+)
+
+// ---------- 6. Square-root (2D) queries ----------
+
+// EncryptQuery2D encrypts the row and column selectors of index's grid
+// cell (utils.IndexContract.Describe2D) for PIRQuery2D. size is the bytes
+// of both ciphertexts. params must satisfy utils.Check2D.
+func EncryptQuery2D(params bgv.Parameters, pk *rlwe.PublicKey, index, dbSize, slotsPerRec int) (rowB64, colB64 string, size int, err error) {
+	if err := utils.Check2D(params.LogQi()); err != nil {
+		return "", "", 0, err
+	}
+	ic := IndexContract{NRecords: dbSize, RecordS: slotsPerRec, Slots: params.MaxSlots()}
+	cell, err := ic.Describe2D(index)
+	if err != nil {
+		return "", "", 0, err
+	}
+	rowVec, err := ic.RowSelector(cell.Row)
+	if err != nil {
+		return "", "", 0, err
+	}
+	colVec, err := ic.ColSelector(cell.Col)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	encoder, encryptor := bgv.NewEncoder(params), bgv.NewEncryptor(params, pk)
+	out := make([]string, 2)
+	for k, vec := range [][]uint64{rowVec, colVec} {
+		pt := bgv.NewPlaintext(params, params.MaxLevel())
+		if err := encoder.Encode(vec, pt); err != nil {
+			return "", "", 0, err
+		}
+		ct, err := encryptor.EncryptNew(pt)
+		if err != nil {
+			return "", "", 0, err
+		}
+		raw, err := ct.MarshalBinary()
+		if err != nil {
+			return "", "", 0, err
+		}
+		out[k], size = base64.StdEncoding.EncodeToString(raw), size+len(raw)
+	}
+
+	if Debug {
+		rows, cols := ic.Grid()
+		fmt.Printf("[DBG] EncryptQuery2D: index=%d shard=%d row=%d/%d col=%d/%d bytes=%d\n",
+			index, cell.Shard, cell.Row, rows, cell.Col, cols, size)
+	}
+	return out[0], out[1], size, nil
+}
+
+// DecryptResult2D is DecryptResult for a PIRQuery2D response, whose
+// result ciphertexts are degree 2.
+func DecryptResult2D(params bgv.Parameters, sk *rlwe.SecretKey, encResBase64 string,
+	index, dbSize, slotsPerRecord int) (Decoded, error) {
+	return decryptResult(params, sk, encResBase64, index, dbSize, slotsPerRecord, utils.Packing1B, 2)
+}
@@ -0,0 +1,135 @@
+package cpir
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+
+	"pir_shared/he"
+	"pir_shared/utils"
+)
+
+// ---------- 7. BFV and CKKS datasets ----------
+
+// SchemeKeys are a key pair for a dataset built under any scheme
+// (Metadata.Scheme, chaincode SetScheme), held as pir_shared/he handles.
+// The query and result have the BGV path's shape; under CKKS the
+// selector is encoded as reals in N/2 slots and the result is rounded back
+// to bytes (he.Engine Decode).
+type SchemeKeys struct {
+	Params he.Params
+	sk     he.SecretKey
+	pk     he.PublicKey
+}
+
+// GenSchemeKeys builds meta's params under meta's scheme and a fresh key
+// pair.
+func GenSchemeKeys(meta Metadata) (SchemeKeys, error) {
+	params, err := he.Default.NewParams(utils.HintFromMetadata(meta))
+	if err != nil {
+		return SchemeKeys{}, err
+	}
+	sk, pk, err := he.Default.GenKeyPair(params)
+	if err != nil {
+		return SchemeKeys{}, err
+	}
+	if Debug {
+		fmt.Printf("[DBG] KeyGen done   : scheme=%s  MaxSlots=%d  MaxLevel=%d\n",
+			he.SchemeOf(params), params.MaxSlots(), params.MaxLevel())
+	}
+	return SchemeKeys{Params: params, sk: sk, pk: pk}, nil
+}
+
+// MarshalSecretKey serializes k's secret key, to decrypt a saved result
+// later with LoadSchemeKeys.
+func (k SchemeKeys) MarshalSecretKey() ([]byte, error) {
+	return k.sk.MarshalBinary()
+}
+
+// LoadSchemeKeys rebuilds meta's params and the secret key of
+// MarshalSecretKey. Without the public key the keys decrypt but do not
+// encrypt.
+func LoadSchemeKeys(meta Metadata, skRaw []byte) (SchemeKeys, error) {
+	params, err := he.Default.NewParams(utils.HintFromMetadata(meta))
+	if err != nil {
+		return SchemeKeys{}, err
+	}
+	pp, ok := params.(rlwe.ParameterProvider)
+	if !ok {
+		return SchemeKeys{}, fmt.Errorf("%s: cannot load a secret key for %T", he.Default.Name(), params)
+	}
+	sk := rlwe.NewSecretKey(pp)
+	if err := sk.UnmarshalBinary(skRaw); err != nil {
+		return SchemeKeys{}, fmt.Errorf("secret key: %w", err)
+	}
+	return SchemeKeys{Params: params, sk: sk}, nil
+}
+
+// EncryptQuery is EncryptQueryBase64 under k's scheme.
+func (k SchemeKeys) EncryptQuery(meta Metadata, index int) (string, int, error) {
+	ic := IndexContract{NRecords: meta.NRecords, RecordS: meta.RecordS, Slots: k.Params.MaxSlots()}
+	if err := ic.ValidateSharded(); err != nil {
+		return "", 0, err
+	}
+	w, err := ic.Describe(index)
+	if err != nil {
+		return "", 0, err
+	}
+	vec := make([]uint64, k.Params.MaxSlots())
+	for i := w.StartSlot; i < w.EndSlot; i++ {
+		vec[i] = 1
+	}
+	pt, err := he.Default.Encode(k.Params, vec)
+	if err != nil {
+		return "", 0, err
+	}
+	ct, err := he.Default.Encrypt(k.Params, k.pk, pt)
+	if err != nil {
+		return "", 0, err
+	}
+	raw, err := ct.MarshalBinary()
+	if err != nil {
+		return "", 0, err
+	}
+	if Debug {
+		fmt.Printf("[DBG] EncryptQuery  : scheme=%s index=%d shard=%d slots [%d:%d) bytes=%d\n",
+			he.SchemeOf(k.Params), index, w.Shard, w.StartSlot, w.EndSlot, len(raw))
+	}
+	return base64.StdEncoding.EncodeToString(raw), len(raw), nil
+}
+
+// DecryptResult is the package's DecryptResult under k's scheme.
+func (k SchemeKeys) DecryptResult(meta Metadata, encResBase64 string, index int) (Decoded, error) {
+	w, plainvec, err := k.decryptShard(meta, encResBase64, index)
+	if err != nil {
+		return Decoded{}, err
+	}
+	if LeakCheck {
+		ic := IndexContract{NRecords: meta.NRecords, RecordS: meta.RecordS, Slots: k.Params.MaxSlots(), Packing: meta.Packing}
+		if err := checkLeaks(ic, index, plainvec); err != nil {
+			return Decoded{}, err
+		}
+	}
+	return decodeWindow(plainvec, w.StartSlot, w.EndSlot, meta.RecordS, meta.Packing)
+}
+
+// decryptShard decrypts the result ciphertext of index's shard to all of
+// its slots and returns them with index's window.
+func (k SchemeKeys) decryptShard(meta Metadata, encResBase64 string, index int) (utils.SelectorLayout, []uint64, error) {
+	ic := IndexContract{NRecords: meta.NRecords, RecordS: meta.RecordS, Slots: k.Params.MaxSlots(), Packing: meta.Packing}
+	w, raw, err := shardResult(ic, index, encResBase64)
+	if err != nil {
+		return w, nil, err
+	}
+	ct, err := he.Default.UnmarshalCiphertext(k.Params, raw, 1)
+	if err != nil {
+		return w, nil, err
+	}
+	pt, err := he.Default.Decrypt(k.Params, k.sk, ct)
+	if err != nil {
+		return w, nil, err
+	}
+	plainvec, err := he.Default.Decode(k.Params, pt)
+	return w, plainvec, err
+}
@@ -0,0 +1,129 @@
+// internal/fabgw/audit.go
+package fabgw
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+
+	"pir_shared/blobstore"
+	"pir_shared/utils"
+)
+
+// AuditBlobThreshold is the ct_q size above which SubmitAuditedQuery puts
+// the payload into the caller's blob store instead of the audit collection.
+const AuditBlobThreshold = 64 * 1024
+
+// SubmitAuditedQuery runs PIRQuerySubmit with ct_q in the transient map, so
+// the query stays out of the block (the chaincode keeps its hash on-chain
+// and the payload in the audit collection). It waits for the commit and
+// returns ct_r (Base64) and the transaction ID, which keys the audit record
+// (GetAuditRecord / GetAuditPayload). With a non-nil store, ct_q above
+// AuditBlobThreshold is put there first and the chaincode records only its
+// content-addressed ref.
+func SubmitAuditedQuery(contract *client.Contract, encQueryB64 string, store blobstore.Store) (string, string, error) {
+	return SubmitAuditedQueryWith(contract, encQueryB64, AuditOptions{Store: store})
+}
+
+// AuditOptions are the optional parts of an audited query.
+type AuditOptions struct {
+	Store blobstore.Store // offload ct_q above AuditBlobThreshold (nil: never)
+	// EndorsingOrgs are the MSP IDs whose peers must endorse (empty: the
+	// gateway picks endorsers from the chaincode's policy).
+	EndorsingOrgs []string
+	Flow          *utils.AuditFlow // sent under "auditFlow"
+}
+
+// SubmitAuditedQueryWith is SubmitAuditedQuery with AuditOptions.
+func SubmitAuditedQueryWith(contract *client.Contract, encQueryB64 string, opts AuditOptions) (string, string, error) {
+	res, txID, _, err := submitAudited(contract, encQueryB64, opts)
+	return res, txID, err
+}
+
+// submitAudited is SubmitAuditedQueryWith, also returning the block the
+// transaction committed in.
+func submitAudited(contract *client.Contract, encQueryB64 string, opts AuditOptions) (string, string, uint64, error) {
+	transient := map[string][]byte{"encQueryB64": []byte(encQueryB64)}
+	ref, ok, err := blobstore.Offload(context.Background(), opts.Store, []byte(encQueryB64), AuditBlobThreshold)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("PIRQuerySubmit payload: %w", err)
+	}
+	if ok {
+		transient["auditRef"], _ = json.Marshal(ref)
+	}
+	if opts.Flow != nil {
+		transient["auditFlow"], _ = json.Marshal(opts.Flow)
+	}
+	popts := []client.ProposalOption{client.WithArguments(""), client.WithTransient(transient)}
+	if len(opts.EndorsingOrgs) > 0 {
+		popts = append(popts, client.WithEndorsingOrganizations(opts.EndorsingOrgs...))
+	}
+	proposal, err := contract.NewProposal("PIRQuerySubmit", popts...)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("PIRQuerySubmit proposal: %w", err)
+	}
+	txn, err := proposal.Endorse()
+	if err != nil {
+		return "", "", 0, fmt.Errorf("PIRQuerySubmit endorse: %w", err)
+	}
+	commit, err := txn.Submit()
+	if err != nil {
+		return "", "", 0, fmt.Errorf("PIRQuerySubmit submit: %w", err)
+	}
+	status, err := commit.Status()
+	if err != nil {
+		return "", "", 0, fmt.Errorf("PIRQuerySubmit commit status: %w", err)
+	}
+	if !status.Successful {
+		return "", "", 0, fmt.Errorf("PIRQuerySubmit %s not committed: %s", txn.TransactionID(), status.Code)
+	}
+	return string(txn.Result()), txn.TransactionID(), status.BlockNumber, nil
+}
+
+// FlowResult is what AuditedQueryFlow returns.
+type FlowResult struct {
+	Flow      utils.AuditFlow
+	EncResB64 string // ct_r of the evaluation
+	TxID      string // PIRQuerySubmit transaction keying the audit record
+	Block     uint64 // block TxID committed in
+}
+
+// AuditedQueryFlow runs the two-role scenario in one process: evaluator, a
+// contract on evalMSP's gateway, evaluates ct_q with PIRQuery on evalMSP's
+// peers only; submitter, a contract on any org's gateway, then submits
+// PIRQuerySubmit for the same ct_q endorsed by every org in endorsers,
+// carrying a utils.AuditFlow that makes each endorser check it reproduces
+// the evaluated ct_r. The audit record (GetAuditRecord TxID) holds the flow.
+func AuditedQueryFlow(evaluator *client.Contract, evalMSP string, submitter *client.Contract,
+	endorsers []string, encQueryB64 string, store blobstore.Store) (FlowResult, error) {
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return FlowResult{}, err
+	}
+	res, err := evaluator.Evaluate("PIRQuery",
+		client.WithArguments(encQueryB64),
+		client.WithEndorsingOrganizations(evalMSP))
+	if err != nil {
+		return FlowResult{}, fmt.Errorf("PIRQuery at %s: %w", evalMSP, err)
+	}
+	flow := utils.AuditFlow{
+		ID:           hex.EncodeToString(id),
+		EvalMSP:      evalMSP,
+		QuerySHA256:  blobstore.Key([]byte(encQueryB64)),
+		ResultSHA256: blobstore.Key(res),
+	}
+	result, txID, block, err := submitAudited(submitter, encQueryB64,
+		AuditOptions{Store: store, EndorsingOrgs: endorsers, Flow: &flow})
+	if err != nil {
+		return FlowResult{}, fmt.Errorf("audit flow %s: %w", flow.ID, err)
+	}
+	if result != string(res) {
+		return FlowResult{}, fmt.Errorf("audit flow %s: committed ct_r differs from the evaluation at %s", flow.ID, evalMSP)
+	}
+	return FlowResult{Flow: flow, EncResB64: string(res), TxID: txID, Block: block}, nil
+}
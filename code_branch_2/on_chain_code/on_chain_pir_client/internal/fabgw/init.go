@@ -0,0 +1,259 @@
+// internal/fabgw/init.go
+package fabgw
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"google.golang.org/grpc/status"
+
+	"pir_shared/utils"
+)
+
+// DefaultChunkSize is the number of records per InitAddRecords transaction.
+const DefaultChunkSize = 32
+
+// InitParams are the InitLedger / InitBegin arguments. Empty strings select
+// the chaincode defaults (auto-selected LogN, default moduli, t=65537).
+type InitParams struct {
+	NRecords int
+	MaxJSON  int
+	LogN     string
+	LogQi    string // JSON array
+	LogPi    string // JSON array
+	T        string
+	// Scheme, if set, is passed to SetScheme right after InitLedger /
+	// InitBegin ("bgv", "bfv" or "ckks"); empty keeps BGV.
+	Scheme string
+	// Packing, if set, is passed to SetPacking after SetScheme ("1b" or
+	// "2b"); empty keeps one byte per slot.
+	Packing string
+	// Seed, if set, initialises through InitLedgerSeeded: the synthetic
+	// records come from it and the init steps answer without timings, so
+	// endorsers of several orgs agree. InitNegotiated only.
+	Seed string
+}
+
+// setOptions submits SetScheme and SetPacking when p selects a scheme or
+// a packing mode.
+func (p InitParams) setOptions(contract *client.Contract) error {
+	if p.Scheme != "" {
+		if _, err := contract.SubmitTransaction("SetScheme", p.Scheme); err != nil {
+			return fmt.Errorf("SetScheme: %w", withDetails(err))
+		}
+	}
+	if p.Packing != "" {
+		if _, err := contract.SubmitTransaction("SetPacking", p.Packing); err != nil {
+			return fmt.Errorf("SetPacking: %w", withDetails(err))
+		}
+	}
+	return nil
+}
+
+func (p InitParams) args() []string {
+	return []string{fmt.Sprint(p.NRecords), fmt.Sprint(p.MaxJSON), p.LogN, p.LogQi, p.LogPi, p.T}
+}
+
+// InitNegotiated runs InitLedger + GenerateDataset with the advisor's
+// cheapest layout for p.NRecords records of p.MaxJSON bytes and, while
+// the chaincode answers "capacity exceeded", retries with the next one
+// (utils.NegotiateInit). p.LogN, if set, is the smallest ring tried.
+// InitLedger only takes logN: shards are the chaincode's (caps), and only
+// layouts under p.Packing (empty: "1b") are tried. It returns the
+// committed layout.
+func InitNegotiated(contract *client.Contract, p InitParams, caps utils.Capabilities) (utils.InitPlan, error) {
+	if want, err := strconv.Atoi(p.LogN); err == nil {
+		caps.LogN = slices.DeleteFunc(slices.Clone(caps.LogN), func(logN int) bool { return logN < want })
+	}
+	caps, err := caps.WithPacking(p.Packing)
+	if err != nil {
+		return utils.InitPlan{}, err
+	}
+	return utils.NegotiateInit(p.NRecords, p.MaxJSON, caps, func(plan utils.InitPlan) error {
+		p.LogN = fmt.Sprint(plan.LogN)
+		fn, args := "InitLedger", p.args()
+		if p.Seed != "" {
+			fn, args = "InitLedgerSeeded", append(args, p.Seed)
+		}
+		if _, err := contract.SubmitTransaction(fn, args...); err != nil {
+			return fmt.Errorf("%s: %w", fn, withDetails(err))
+		}
+		if err := p.setOptions(contract); err != nil {
+			return err
+		}
+		if _, err := contract.SubmitTransaction("GenerateDataset"); err != nil {
+			return fmt.Errorf("GenerateDataset: %w", withDetails(err))
+		}
+		return nil
+	})
+}
+
+// LoadRecords replaces the dataset with records in one LoadRecordsFromJSON
+// transaction; p.NRecords is ignored. The records the chaincode's sanitize
+// pipeline rejected are returned with their index in records. The dataset
+// is packed in that transaction, so it is BGV and "1b" whatever p.Scheme
+// and p.Packing say.
+func LoadRecords(contract *client.Contract, p InitParams, records [][]byte) ([]utils.Rejection, error) {
+	if s, err := utils.ParseScheme(p.Scheme); err != nil || s != utils.SchemeBGV {
+		return nil, fmt.Errorf("LoadRecordsFromJSON packs BGV datasets only, not %q", p.Scheme)
+	}
+	if m, err := utils.ParsePacking(p.Packing); err != nil || m != utils.Packing1B {
+		return nil, fmt.Errorf("LoadRecordsFromJSON packs one byte per slot only, not %q", p.Packing)
+	}
+	arr := make([]json.RawMessage, len(records))
+	for i, r := range records {
+		arr[i] = r
+	}
+	recordsJSON, err := json.Marshal(arr)
+	if err != nil {
+		return nil, fmt.Errorf("marshal records: %w", err)
+	}
+	args := append([]string{string(recordsJSON)}, p.args()[1:]...)
+	raw, err := contract.SubmitTransaction("LoadRecordsFromJSON", args...)
+	if err != nil {
+		return nil, fmt.Errorf("LoadRecordsFromJSON: %w", withDetails(err))
+	}
+	var status struct {
+		Result utils.InitStatus `json:"result"`
+	}
+	if err := json.Unmarshal(raw, &status); err != nil {
+		return nil, fmt.Errorf("parse LoadRecordsFromJSON response: %w", err)
+	}
+	return status.Result.Rejections, nil
+}
+
+// VerifyMDBHash evaluates VerifyMDBHash on the gateway peer: the
+// committed m_DB hash against the stored shards, expected (if set) and,
+// with regenerate, the m_DB the peer generates from dataset_spec.
+func VerifyMDBHash(contract *client.Contract, expected string, regenerate bool) (utils.MDBHashReport, error) {
+	raw, err := contract.EvaluateTransaction("VerifyMDBHash", expected, strconv.FormatBool(regenerate))
+	if err != nil {
+		return utils.MDBHashReport{}, fmt.Errorf("VerifyMDBHash: %w", withDetails(err))
+	}
+	var resp struct {
+		Result utils.MDBHashReport `json:"result"`
+	}
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return utils.MDBHashReport{}, fmt.Errorf("parse VerifyMDBHash response: %w", err)
+	}
+	return resp.Result, nil
+}
+
+// withDetails appends the peer messages the gateway attaches to endorse
+// and submit errors (the chaincode's own error text) to err.
+func withDetails(err error) error {
+	var msgs []string
+	for _, d := range status.Convert(err).Details() {
+		if m, ok := d.(interface{ GetMessage() string }); ok {
+			msgs = append(msgs, m.GetMessage())
+		}
+	}
+	if len(msgs) == 0 {
+		return err
+	}
+	return fmt.Errorf("%w: %s", err, strings.Join(msgs, "; "))
+}
+
+// RecordSource produces the records to upload once the chaincode has fixed
+// LogN (e.g. gen_records.GenerateRecords).
+type RecordSource func(logN int) ([][]byte, error)
+
+// InitChunked loads a dataset over several transactions: InitBegin, one
+// InitAddRecords per chunkSize records (<=0 → DefaultChunkSize) and
+// InitCommit. Every step is submitted and committed before the next. The
+// records the chaincode's sanitize pipeline rejected are returned with
+// their index in records.
+func InitChunked(contract *client.Contract, p InitParams, records RecordSource, chunkSize int) ([]utils.Rejection, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	raw, err := contract.SubmitTransaction("InitBegin", p.args()...)
+	if err != nil {
+		return nil, fmt.Errorf("InitBegin: %w", err)
+	}
+	if err := p.setOptions(contract); err != nil {
+		return nil, err
+	}
+	var begin struct {
+		Result utils.InitStatus `json:"result"`
+	}
+	if err := json.Unmarshal(raw, &begin); err != nil {
+		return nil, fmt.Errorf("parse InitBegin response: %w", err)
+	}
+
+	recs, err := records(begin.Result.LogN)
+	if err != nil {
+		return nil, fmt.Errorf("generate records: %w", err)
+	}
+	if err := utils.CheckGenerated(begin.Result.NRecords, recs); err != nil {
+		return nil, err
+	}
+
+	var rejected []utils.Rejection
+	for off := 0; off < len(recs); off += chunkSize {
+		end := min(off+chunkSize, len(recs))
+		chunk := utils.InitChunk{Offset: off, Records: make([]json.RawMessage, 0, end-off)}
+		for _, r := range recs[off:end] {
+			chunk.Records = append(chunk.Records, r)
+		}
+		arg, err := json.Marshal(chunk)
+		if err != nil {
+			return nil, fmt.Errorf("marshal chunk at %d: %w", off, err)
+		}
+		res, err := contract.SubmitTransaction("InitAddRecords", string(arg))
+		if err != nil {
+			return nil, fmt.Errorf("InitAddRecords at %d: %w", off, err)
+		}
+		var status struct {
+			Result utils.InitStatus `json:"result"`
+		}
+		if err := json.Unmarshal(res, &status); err != nil {
+			return nil, fmt.Errorf("parse InitAddRecords response: %w", err)
+		}
+		for _, r := range status.Result.Rejections {
+			rejected = append(rejected, utils.Rejection{Index: off + r.Index, Reason: r.Reason})
+		}
+	}
+
+	if _, err := contract.SubmitTransaction("InitCommit"); err != nil {
+		return rejected, fmt.Errorf("InitCommit: %w", err)
+	}
+	return rejected, nil
+}
+
+// DefaultMDBChunkBytes is the raw m_DB bytes per PutMDB transaction.
+const DefaultMDBChunkBytes = 512 * 1024
+
+// PutMDB uploads a client-encoded m_DB (cpir.EncodeDB) in chunkBytes
+// pieces (<=0 → DefaultMDBChunkBytes). The last PutMDB carries meta and
+// installs the database.
+func PutMDB(contract *client.Contract, mdb []byte, meta utils.MDBUpload, chunkBytes int) error {
+	if chunkBytes <= 0 {
+		chunkBytes = DefaultMDBChunkBytes
+	}
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("marshal m_DB meta: %w", err)
+	}
+
+	total := max(1, (len(mdb)+chunkBytes-1)/chunkBytes)
+	for seq := 0; seq < total; seq++ {
+		part := mdb[seq*chunkBytes : min((seq+1)*chunkBytes, len(mdb))]
+		last := ""
+		if seq == total-1 {
+			last = string(metaJSON)
+		}
+		_, err := contract.SubmitTransaction("PutMDB",
+			fmt.Sprint(seq), fmt.Sprint(total), base64.StdEncoding.EncodeToString(part), last)
+		if err != nil {
+			return fmt.Errorf("PutMDB chunk %d/%d: %w", seq+1, total, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// internal/fabgw/org.go
+package fabgw
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/hash"
+	"google.golang.org/grpc"
+)
+
+// Org is one organization's gateway peer and the user that signs for it,
+// laid out as in a cryptogen / fablo crypto-config tree:
+// CryptoPath/peers/GatewayPeer/tls/ca.crt and CryptoPath/users/User/msp.
+type Org struct {
+	MSPID        string `json:"msp_id" yaml:"msp_id"`               // e.g. Org1MSP
+	PeerEndpoint string `json:"peer_endpoint" yaml:"peer_endpoint"` // e.g. localhost:7041
+	GatewayPeer  string `json:"gateway_peer" yaml:"gateway_peer"`   // TLS server name, e.g. peer0.org1.example.com
+	CryptoPath   string `json:"crypto_path" yaml:"crypto_path"`     // .../peerOrganizations/org1.example.com
+	User         string `json:"user" yaml:"user"`                   // e.g. User1@org1.example.com
+}
+
+// FabloOrg is organization i (1, 2, …) of the fablo test network under
+// home: Org<i>MSP, peer0.org<i>.example.com on localhost:70<i*2>1.
+func FabloOrg(home string, i int) Org {
+	domain := fmt.Sprintf("org%d.example.com", i)
+	return Org{
+		MSPID:        fmt.Sprintf("Org%dMSP", i),
+		PeerEndpoint: fmt.Sprintf("localhost:70%d1", 2*i+2),
+		GatewayPeer:  "peer0." + domain,
+		CryptoPath: filepath.Join(home, "fablo_test", "fablo-target", "fabric-config", "crypto-config",
+			"peerOrganizations", domain),
+		User: "User1@" + domain,
+	}
+}
+
+// TLSCACert is the gateway peer's TLS CA certificate.
+func (o Org) TLSCACert() string {
+	return filepath.Join(o.CryptoPath, "peers", o.GatewayPeer, "tls", "ca.crt")
+}
+
+// MSPDir is the signing user's MSP directory (signcerts, keystore).
+func (o Org) MSPDir() string {
+	return filepath.Join(o.CryptoPath, "users", o.User, "msp")
+}
+
+// Timeouts bound the gateway calls (zero: the gateway defaults).
+type Timeouts struct {
+	Evaluate, Endorse, Submit, CommitStatus time.Duration
+}
+
+// Connect dials o's gateway peer as o's user; close releases the gateway
+// and the connection.
+func (o Org) Connect(t Timeouts) (gw *client.Gateway, close func(), err error) {
+	conn, err := NewConnection(o.PeerEndpoint, o.TLSCACert(), o.GatewayPeer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", o.MSPID, err)
+	}
+	gw, err = o.connect(conn, t)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("%s: %w", o.MSPID, err)
+	}
+	return gw, func() { gw.Close(); conn.Close() }, nil
+}
+
+func (o Org) connect(conn *grpc.ClientConn, t Timeouts) (*client.Gateway, error) {
+	id, err := NewIdentityFromDir(o.MSPID, filepath.Join(o.MSPDir(), "signcerts"))
+	if err != nil {
+		return nil, err
+	}
+	sign, err := NewSignerFromKeyDir(filepath.Join(o.MSPDir(), "keystore"))
+	if err != nil {
+		return nil, err
+	}
+	opts := []client.ConnectOption{
+		client.WithSign(sign),
+		client.WithHash(hash.SHA256),
+		client.WithClientConnection(conn),
+	}
+	for _, d := range []struct {
+		v   time.Duration
+		opt func(time.Duration) client.ConnectOption
+	}{
+		{t.Evaluate, client.WithEvaluateTimeout},
+		{t.Endorse, client.WithEndorseTimeout},
+		{t.Submit, client.WithSubmitTimeout},
+		{t.CommitStatus, client.WithCommitStatusTimeout},
+	} {
+		if d.v > 0 {
+			opts = append(opts, d.opt(d.v))
+		}
+	}
+	return client.Connect(id, opts...)
+}
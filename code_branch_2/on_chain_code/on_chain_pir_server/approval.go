@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/utils"
+)
+
+/**************  FOUR-EYES PUBLICATION ********************************/
+
+// Shared threat intel should not change on one org's say-so. ProposePublish
+// records the digest of the current staging area together with the
+// proposer's MSP; ApprovePublish, called by a member of a different MSP
+// with the digest it reviewed (GetStaged), publishes exactly those ops.
+// Staging more ops or discarding them invalidates the proposal, since the
+// digest no longer matches.
+//
+// With approval.required in the runtime config (SetConfig) the dataset
+// changes only this way: the single-step Publish, AddCTIRecord,
+// AddRecord, UpdateRecord, DeleteRecord and ApplyRecordBatch are refused
+// (checkDirectWrite), and the init functions and PutMDB only build a
+// dataset that holds no records yet (checkInitWrite). The requirement lives on
+// the ledger, so every peer enforces the same one, and lifting it needs
+// two MSPs as well (approveConfigChange).
+
+const (
+	proposalKey       = "publish_proposal"
+	configProposalKey = "config_proposal"
+)
+
+// checkDirectWrite refuses fn, a function that changes the dataset
+// outside the staging area, while approval is required.
+func checkDirectWrite(ctx contractapi.TransactionContextInterface, fn string) error {
+	if configOf(ctx).Approval.Required {
+		return fmt.Errorf("%s: approval required - stage the change with StageRecordOps, then ProposePublish and ApprovePublish", fn)
+	}
+	return nil
+}
+
+// checkInitWrite refuses fn, an init function, while approval is required
+// and the dataset holds records: it would replace them on one org's say.
+func checkInitWrite(ctx contractapi.TransactionContextInterface, fn string) error {
+	if !configOf(ctx).Approval.Required {
+		return nil
+	}
+	nRaw, err := ctx.GetStub().GetState("n")
+	if err != nil {
+		return fmt.Errorf("%s: %w", fn, err)
+	}
+	if nRaw != nil {
+		return fmt.Errorf("%s: approval required and the dataset holds records - change it through StageRecordOps, ProposePublish and ApprovePublish", fn)
+	}
+	return nil
+}
+
+// ConfigProposal is a pending SetConfig that lifts approval.required,
+// stored under configProposalKey.
+type ConfigProposal struct {
+	Digest      string `json:"digest"` // SHA-256 of the proposed configJSON
+	ProposerMSP string `json:"proposer_msp"`
+	TxID        string `json:"tx_id"`
+}
+
+// approveConfigChange records configJSON as proposed by the caller's MSP
+// and returns the proposal, or returns nil when another MSP proposed the
+// same configJSON and the change may go ahead.
+func approveConfigChange(ctx contractapi.TransactionContextInterface, configJSON string) (*ConfigProposal, error) {
+	msp, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("caller MSP: %w", err)
+	}
+	sum := sha256.Sum256([]byte(configJSON))
+	digest := hex.EncodeToString(sum[:])
+
+	raw, err := ctx.GetStub().GetState(configProposalKey)
+	if err != nil {
+		return nil, err
+	}
+	if raw != nil {
+		var p ConfigProposal
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return nil, fmt.Errorf("corrupt %s: %w", configProposalKey, err)
+		}
+		if p.Digest == digest && p.ProposerMSP != msp {
+			dbg("[CC][APPROVAL] %s approved %s's config change", msp, p.ProposerMSP)
+			return nil, ctx.GetStub().DelState(configProposalKey)
+		}
+	}
+
+	p := ConfigProposal{Digest: digest, ProposerMSP: msp, TxID: ctx.GetStub().GetTxID()}
+	raw, _ = json.Marshal(p)
+	if err := ctx.GetStub().PutState(configProposalKey, raw); err != nil {
+		return nil, err
+	}
+	dbg("[CC][APPROVAL] %s proposed lifting approval.required (digest %s)", msp, digest)
+	return &p, nil
+}
+
+// PublishProposal is the pending proposal stored under proposalKey.
+type PublishProposal struct {
+	Digest      string `json:"digest"` // stagedDigest at proposal time
+	Ops         int    `json:"ops"`
+	ProposerMSP string `json:"proposer_msp"`
+	ProposerID  string `json:"proposer_id"`
+	TxID        string `json:"tx_id"`
+}
+
+// ApprovedPublishEvent is the payload of the "DatasetPublished" event when
+// the publication went through ApprovePublish.
+type ApprovedPublishEvent struct {
+	PublishEvent
+	ProposerMSP string `json:"proposer_msp"`
+	ApproverMSP string `json:"approver_msp"`
+}
+
+func loadProposal(ctx contractapi.TransactionContextInterface) (*PublishProposal, error) {
+	raw, err := ctx.GetStub().GetState(proposalKey)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("no publication proposed - call ProposePublish first")
+	}
+	var p PublishProposal
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, fmt.Errorf("corrupt %s: %w", proposalKey, err)
+	}
+	return &p, nil
+}
+
+// ProposePublish proposes publishing the current staging area, replacing
+// any earlier proposal.
+func (cc *PIRChainCode) ProposePublish(ctx contractapi.TransactionContextInterface) (string, error) {
+	staged, err := loadStaged(ctx)
+	if err != nil {
+		return "", fmt.Errorf("ProposePublish: %w", err)
+	}
+	if len(staged) == 0 {
+		return "", fmt.Errorf("ProposePublish: nothing staged")
+	}
+	msp, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("ProposePublish: caller MSP: %w", err)
+	}
+
+	p := PublishProposal{
+		Digest:      stagedDigest(staged),
+		Ops:         len(staged),
+		ProposerMSP: msp,
+		ProposerID:  clientID(ctx),
+		TxID:        ctx.GetStub().GetTxID(),
+	}
+	raw, _ := json.Marshal(p)
+	if err := ctx.GetStub().PutState(proposalKey, raw); err != nil {
+		return "", err
+	}
+	if err := ctx.GetStub().SetEvent("PublishProposed", raw); err != nil {
+		return "", err
+	}
+
+	dbg("[CC][APPROVAL] %s proposed %d ops (digest %s)", msp, p.Ops, p.Digest)
+	return utils.MarshalUntimed(raw)
+}
+
+// GetProposal returns the pending proposal.
+func (cc *PIRChainCode) GetProposal(ctx contractapi.TransactionContextInterface) (string, error) {
+	start := time.Now()
+	p, err := loadProposal(ctx)
+	if err != nil {
+		return "", fmt.Errorf("GetProposal: %w", err)
+	}
+	return utils.MarshalTimed(p, start)
+}
+
+// ApprovePublish approves the pending proposal for digest and publishes the
+// staged ops. The caller's MSP must differ from the proposer's, and digest
+// must match both the proposal and the current staging area.
+func (cc *PIRChainCode) ApprovePublish(ctx contractapi.TransactionContextInterface, digest string) (string, error) {
+	dbg("\n/**************  APPROVE PUBLISH START *************************************/")
+	start := time.Now()
+
+	p, err := loadProposal(ctx)
+	if err != nil {
+		return "", fmt.Errorf("ApprovePublish: %w", err)
+	}
+	msp, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("ApprovePublish: caller MSP: %w", err)
+	}
+	if msp == p.ProposerMSP {
+		return "", fmt.Errorf("ApprovePublish: approval must come from an org other than the proposer (%s)", p.ProposerMSP)
+	}
+	if digest != p.Digest {
+		return "", fmt.Errorf("ApprovePublish: digest %s does not match the proposal (%s)", digest, p.Digest)
+	}
+	staged, err := loadStaged(ctx)
+	if err != nil {
+		return "", fmt.Errorf("ApprovePublish: %w", err)
+	}
+	if cur := stagedDigest(staged); cur != p.Digest {
+		return "", fmt.Errorf("ApprovePublish: staging area changed since the proposal (digest %s) - propose again", cur)
+	}
+
+	pub, err := cc.publishStaged(ctx)
+	if err != nil {
+		return "", fmt.Errorf("ApprovePublish: %w", err)
+	}
+	if err := ctx.GetStub().DelState(proposalKey); err != nil {
+		return "", err
+	}
+	raw, _ := json.Marshal(ApprovedPublishEvent{PublishEvent: pub, ProposerMSP: p.ProposerMSP, ApproverMSP: msp})
+	if err := ctx.GetStub().SetEvent("DatasetPublished", raw); err != nil {
+		return "", err
+	}
+
+	dbg("[CC][APPROVAL] %s approved %s's proposal: version %d, %d ops in %.3f ms",
+		msp, p.ProposerMSP, pub.Version, pub.Ops, msOf(time.Since(start)))
+	dbg("/**************  APPROVE PUBLISH END ***************************************/")
+	return utils.MarshalUntimed(raw)
+}
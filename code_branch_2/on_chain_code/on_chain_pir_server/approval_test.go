@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"pir_shared/utils"
+)
+
+// untimedResult parses out as a TimedResponse of a state-writing
+// transaction into result, failing unless execution_time_ms is 0.
+func untimedResult(t *testing.T, fn, out string, result any) {
+	t.Helper()
+	var resp utils.TimedResponse
+	if err := json.Unmarshal([]byte(out), &resp); err != nil {
+		t.Fatalf("%s: parse response: %v", fn, err)
+	}
+	if resp.ExecutionTimeMS != 0 {
+		t.Errorf("%s answered with execution_time_ms %g, want 0 so endorsers agree", fn, resp.ExecutionTimeMS)
+	}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		t.Fatalf("%s: parse result: %v", fn, err)
+	}
+}
+
+// TestApprovePublish checks the four-eyes flow: Publish is refused while
+// approval is required, the proposer's own MSP cannot approve, a digest
+// other than the proposal's is refused, and a second MSP's approval
+// publishes the staged ops.
+func TestApprovePublish(t *testing.T) {
+	cc, ctx, _ := newTestLedger(t, "")
+	setTestConfig(t, ctx, runtimeConfig{Approval: approvalConfig{Required: true}})
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		t.Fatalf("loadMetadata: %v", err)
+	}
+	live := meta.Live()
+
+	ctx.SetClientIdentity(mockIdentity{msp: "Org1MSP"})
+	rec := `{"md5":"0cc175b9c0f1b6a831c399e269772661","malware_family":"FourEyes","threat_level":"low"}`
+	out, err := cc.StageRecordOps(ctx, fmt.Sprintf(`[{"op":"add","index":%d,"record":%s}]`, live, rec))
+	if err != nil {
+		t.Fatalf("StageRecordOps: %v", err)
+	}
+	var staged StagedSummary
+	untimedResult(t, "StageRecordOps", out, &staged)
+	if _, err := cc.Publish(ctx); err == nil || !strings.Contains(err.Error(), "approval required") {
+		t.Errorf("Publish while approval is required: %v, want it refused", err)
+	}
+
+	if out, err = cc.ProposePublish(ctx); err != nil {
+		t.Fatalf("ProposePublish: %v", err)
+	}
+	var p PublishProposal
+	untimedResult(t, "ProposePublish", out, &p)
+	if p.Digest != staged.Digest || p.ProposerMSP != "Org1MSP" {
+		t.Fatalf("proposal %+v, want digest %s from Org1MSP", p, staged.Digest)
+	}
+
+	if _, err := cc.ApprovePublish(ctx, p.Digest); err == nil || !strings.Contains(err.Error(), "other than the proposer") {
+		t.Errorf("ApprovePublish by the proposer's MSP: %v, want it refused", err)
+	}
+	ctx.SetClientIdentity(mockIdentity{msp: "Org2MSP"})
+	if _, err := cc.ApprovePublish(ctx, strings.Repeat("0", 64)); err == nil || !strings.Contains(err.Error(), "does not match the proposal") {
+		t.Errorf("ApprovePublish with another digest: %v, want it refused", err)
+	}
+	if meta, _ = cc.loadMetadata(ctx); meta.Live() != live {
+		t.Fatalf("refused approvals changed the dataset: %d live records, want %d", meta.Live(), live)
+	}
+
+	if out, err = cc.ApprovePublish(ctx, p.Digest); err != nil {
+		t.Fatalf("ApprovePublish: %v", err)
+	}
+	var ev ApprovedPublishEvent
+	untimedResult(t, "ApprovePublish", out, &ev)
+	if ev.ProposerMSP != "Org1MSP" || ev.ApproverMSP != "Org2MSP" || ev.Ops != 1 {
+		t.Errorf("event %+v, want 1 op proposed by Org1MSP and approved by Org2MSP", ev)
+	}
+	if meta, _ = cc.loadMetadata(ctx); meta.Live() != live+1 {
+		t.Errorf("after approval: %d live records, want %d", meta.Live(), live+1)
+	}
+	if raw, _ := ctx.GetStub().GetState(proposalKey); raw != nil {
+		t.Error("proposal left behind after approval")
+	}
+}
+
+// TestSetConfigLiftApproval checks a config that lifts approval.required
+// stays a proposal while only one MSP asks for it, and applies once a
+// second MSP sends the same config.
+func TestSetConfigLiftApproval(t *testing.T) {
+	cc, ctx, _ := newTestLedger(t, "")
+	setTestConfig(t, ctx, runtimeConfig{Approval: approvalConfig{Required: true}})
+	const lift = `{}`
+
+	ctx.SetClientIdentity(mockIdentity{msp: "Org1MSP"})
+	for i := 0; i < 2; i++ {
+		out, err := cc.SetConfig(ctx, lift)
+		if err != nil {
+			t.Fatalf("SetConfig: %v", err)
+		}
+		var p ConfigProposal
+		untimedResult(t, "SetConfig", out, &p)
+		if p.ProposerMSP != "Org1MSP" || p.Digest == "" {
+			t.Errorf("SetConfig by Org1MSP #%d = %+v, want a pending proposal", i+1, p)
+		}
+		if !configOf(ctx).Approval.Required {
+			t.Fatalf("SetConfig by Org1MSP #%d lifted approval.required on its own", i+1)
+		}
+	}
+	if err := checkDirectWrite(ctx, "AddRecord"); err == nil {
+		t.Error("direct writes allowed while the lift is only proposed")
+	}
+
+	ctx.SetClientIdentity(mockIdentity{msp: "Org2MSP"})
+	out, err := cc.SetConfig(ctx, lift)
+	if err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+	var c runtimeConfig
+	untimedResult(t, "SetConfig", out, &c)
+	if c.Approval.Required || configOf(ctx).Approval.Required {
+		t.Error("same config from a second MSP did not lift approval.required")
+	}
+	if raw, _ := ctx.GetStub().GetState(configProposalKey); raw != nil {
+		t.Error("config proposal left behind after approval")
+	}
+}
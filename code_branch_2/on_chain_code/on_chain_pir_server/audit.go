@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/blobstore"
+	"pir_shared/utils"
+)
+
+/**************  AUDITED QUERIES **************************************/
+
+// PIRQuerySubmit is PIRQuery as a submitted transaction: besides returning
+// ct_r it commits an AuditRecord under auditKey(txID) binding the caller,
+// the query and the m_DB it ran against. ct_q is several hundred KB, so the
+// public record only keeps its SHA-256; the payload itself goes to the
+// private data collection named by PIR_AUDIT_COLLECTION, which only its
+// member orgs store. Without a collection the payload is kept inline in the
+// public record. A client that already put ct_q into an external blob store
+// (S3, IPFS, ...; see pir_shared/blobstore) passes its ref under
+// auditRefTransientKey instead: the chaincode checks the ref's hash against
+// ct_q and records only the ref.
+//
+// A client that evaluated the same ct_q earlier (PIRQuery at one org) and
+// now has the audit endorsed by several orgs passes a utils.AuditFlow under
+// auditFlowTransientKey: the transaction then fails unless this ct_q and
+// this endorser's ct_r hash to the values the flow reports, and the flow
+// is kept in the record.
+//
+// Passing ct_q as an argument would still put it in every block (proposal
+// args are part of the transaction), so clients should send it in the
+// transient map under auditTransientKey and leave the argument empty.
+//
+// An audit record proves that one retrieval happened, so replaying its
+// ct_q could pass one audited query off as many. Under the runtime config's
+// audit.freshness policy the chaincode indexes every audited ct_q hash
+// under auditQueryPrefix and flags (or rejects) a ct_q seen before. A
+// client encrypts each query with fresh randomness, so an honest repeat of
+// the same index never hashes the same.
+
+const (
+	auditPrefix           = "audit:"
+	auditPublicPrefix     = "audit:public:"
+	auditPayloadKey       = "audit_payload:"
+	auditTransientKey     = "encQueryB64"
+	auditRefTransientKey  = "auditRef"
+	auditFlowTransientKey = "auditFlow"
+	auditQueryPrefix      = "audit:query:" // + ct_q sha256 → first audit tx
+)
+
+// audit.freshness policies (auditConfig).
+const (
+	freshnessOff    = "off"
+	freshnessFlag   = "flag"
+	freshnessReject = "reject"
+)
+
+var auditCollection = os.Getenv("PIR_AUDIT_COLLECTION")
+
+func auditKey(txID string) string {
+	return auditPrefix + txID
+}
+
+// AuditRecord is the public audit entry of one PIRQuerySubmit or
+// PublicQuerySubmit.
+type AuditRecord struct {
+	TxID         string `json:"tx_id"`
+	Fn           string `json:"fn"`
+	Key          string `json:"key,omitempty"` // record read by PublicQuerySubmit
+	ClientMSP    string `json:"client_msp"`
+	ClientID     string `json:"client_id"`
+	Timestamp    int64  `json:"timestamp"` // tx timestamp, Unix seconds
+	QuerySHA256  string `json:"query_sha256"`
+	QueryBytes   int    `json:"query_bytes"`              // Base64 length of ct_q
+	MDBSHA256    string `json:"mdb_sha256"`               // m_DB digest, under MDBDigestAlg
+	MDBDigestAlg string `json:"mdb_digest_alg,omitempty"` // absent: sha256
+	MDBVersion   int    `json:"mdb_version"`
+	// Collection holds the payload under auditPayloadKey+TxID, Blob points
+	// to it in an external store; with neither it is inline in Payload.
+	Collection string         `json:"collection,omitempty"`
+	Blob       *blobstore.Ref `json:"blob,omitempty"`
+	Payload    string         `json:"payload,omitempty"`
+	// Flow links the record to an earlier evaluation (auditFlowTransientKey).
+	Flow *utils.AuditFlow `json:"flow,omitempty"`
+	// Freshness is the audit.freshness policy the record was written under
+	// (absent: no check); ReusedFrom is then the earlier audit tx of the
+	// same ct_q, if any.
+	Freshness  string `json:"freshness,omitempty"`
+	ReusedFrom string `json:"reused_from,omitempty"`
+}
+
+// AuditPayload is GetAuditPayload's result.
+type AuditPayload struct {
+	TxID        string `json:"tx_id"`
+	EncQueryB64 string `json:"enc_query_b64"`
+	Verified    bool   `json:"verified"` // payload hashes to the record's QuerySHA256
+	// Blob is set (and the payload empty) for externally stored payloads;
+	// blobstore's Get verifies them against the same hash.
+	Blob *blobstore.Ref `json:"blob,omitempty"`
+}
+
+func querySHA256(encQueryB64 string) string {
+	return blobstore.Key([]byte(encQueryB64))
+}
+
+// mdbDigest is the committed m_DB digest and its algorithm ID ("" for a
+// digest written before m_DB_digest_alg existed, which is SHA-256). Both
+// are read from world state: persistDB hashes m_DB once per write, so an
+// audited query never re-hashes it.
+func mdbDigest(ctx contractapi.TransactionContextInterface) (sum, alg string, err error) {
+	raw, err := ctx.GetStub().GetState("m_DB_sha256")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read m_DB_sha256 from ledger: %w", err)
+	}
+	rawAlg, err := ctx.GetStub().GetState("m_DB_digest_alg")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read m_DB_digest_alg from ledger: %w", err)
+	}
+	return string(raw), string(rawAlg), nil
+}
+
+// PIRQuerySubmit evaluates ct_q (from the transient map, or encQueryB64)
+// like PIRQuery and records the audit entry. Returns ct_r (Base64).
+func (cc *PIRChainCode) PIRQuerySubmit(ctx contractapi.TransactionContextInterface, encQueryB64 string) (string, error) {
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", fmt.Errorf("PIRQuerySubmit: transient map: %w", err)
+	}
+	if encQueryB64 == "" {
+		encQueryB64 = string(transient[auditTransientKey])
+	}
+	var blob *blobstore.Ref
+	if raw := transient[auditRefTransientKey]; raw != nil {
+		if err := json.Unmarshal(raw, &blob); err != nil {
+			return "", fmt.Errorf("PIRQuerySubmit: invalid %s: %w", auditRefTransientKey, err)
+		}
+	}
+	var flow *utils.AuditFlow
+	if raw := transient[auditFlowTransientKey]; raw != nil {
+		if err := json.Unmarshal(raw, &flow); err != nil {
+			return "", fmt.Errorf("PIRQuerySubmit: invalid %s: %w", auditFlowTransientKey, err)
+		}
+		if flow.QuerySHA256 != querySHA256(encQueryB64) {
+			return "", fmt.Errorf("PIRQuerySubmit: flow %s was evaluated for another ct_q (sha256 %s)", flow.ID, flow.QuerySHA256)
+		}
+	}
+	fresh, err := checkFreshness(ctx, querySHA256(encQueryB64))
+	if err != nil {
+		return "", fmt.Errorf("PIRQuerySubmit: %w", err)
+	}
+	out, _, err := cc.evalQuery(ctx, "PIRQuerySubmit", encQueryB64, cc.ensureDB)
+	if err != nil {
+		return "", err
+	}
+	if flow != nil && flow.ResultSHA256 != blobstore.Key([]byte(out)) {
+		return "", fmt.Errorf("PIRQuerySubmit: flow %s: ct_r evaluated at %s does not match this peer's (m_DB changed since?)", flow.ID, flow.EvalMSP)
+	}
+	if err := putAudit(ctx, "PIRQuerySubmit", encQueryB64, blob, flow, fresh); err != nil {
+		return "", fmt.Errorf("PIRQuerySubmit: %w", err)
+	}
+	return out, nil
+}
+
+// freshness is the outcome of the selector reuse check of one ct_q.
+type freshness struct {
+	policy  string // audit.freshness; "" when off
+	firstTx string // earlier audit tx of the same ct_q
+}
+
+// checkFreshness looks querySum up in the ct_q index under the configured
+// policy and fails when the policy rejects a reused ct_q.
+func checkFreshness(ctx contractapi.TransactionContextInterface, querySum string) (freshness, error) {
+	policy := configOf(ctx).Audit.Freshness
+	if policy == "" || policy == freshnessOff {
+		return freshness{}, nil
+	}
+	first, err := ctx.GetStub().GetState(auditQueryPrefix + querySum)
+	if err != nil {
+		return freshness{}, err
+	}
+	f := freshness{policy: policy, firstTx: string(first)}
+	if f.firstTx != "" && policy == freshnessReject {
+		return f, fmt.Errorf("ct_q %.16s was already audited in tx %s; encrypt a fresh query", querySum, f.firstTx)
+	}
+	return f, nil
+}
+
+// newAuditRecord is the part of an AuditRecord every audited function
+// fills alike: the transaction, the caller and the m_DB it was answered
+// from.
+func newAuditRecord(ctx contractapi.TransactionContextInterface, fn string) (AuditRecord, error) {
+	stub := ctx.GetStub()
+	msp, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return AuditRecord{}, fmt.Errorf("caller MSP: %w", err)
+	}
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return AuditRecord{}, fmt.Errorf("tx timestamp: %w", err)
+	}
+	mdbSum, mdbAlg, err := mdbDigest(ctx)
+	if err != nil {
+		return AuditRecord{}, err
+	}
+	version, err := dbVersion(ctx)
+	if err != nil {
+		return AuditRecord{}, err
+	}
+	return AuditRecord{
+		TxID:         stub.GetTxID(),
+		Fn:           fn,
+		ClientMSP:    msp,
+		ClientID:     clientID(ctx),
+		Timestamp:    ts.GetSeconds(),
+		MDBSHA256:    mdbSum,
+		MDBDigestAlg: mdbAlg,
+		MDBVersion:   version,
+	}, nil
+}
+
+// putAudit writes the audit record of this transaction and places the
+// payload: blob (already stored by the client), auditCollection, or inline.
+// A ct_q checked for freshness for the first time is indexed.
+func putAudit(ctx contractapi.TransactionContextInterface, fn, encQueryB64 string, blob *blobstore.Ref, flow *utils.AuditFlow, fresh freshness) error {
+	stub := ctx.GetStub()
+	rec, err := newAuditRecord(ctx, fn)
+	if err != nil {
+		return err
+	}
+	rec.QuerySHA256 = querySHA256(encQueryB64)
+	rec.QueryBytes = len(encQueryB64)
+	rec.Flow = flow
+	rec.Freshness, rec.ReusedFrom = fresh.policy, fresh.firstTx
+
+	if fresh.policy != "" && fresh.firstTx == "" {
+		if err := stub.PutState(auditQueryPrefix+rec.QuerySHA256, []byte(rec.TxID)); err != nil {
+			return err
+		}
+	}
+	switch {
+	case blob != nil:
+		if blob.SHA256 != rec.QuerySHA256 || blob.Size != rec.QueryBytes {
+			return fmt.Errorf("%s %s does not match ct_q (sha256 %s, %d bytes)",
+				auditRefTransientKey, blob.URI, rec.QuerySHA256, rec.QueryBytes)
+		}
+		rec.Blob = blob
+	case auditCollection != "":
+		if err := stub.PutPrivateData(auditCollection, auditPayloadKey+rec.TxID, []byte(encQueryB64)); err != nil {
+			return fmt.Errorf("audit payload to collection %q: %w", auditCollection, err)
+		}
+		rec.Collection = auditCollection
+	default:
+		rec.Payload = encQueryB64
+	}
+
+	raw, _ := json.Marshal(rec)
+	if err := stub.PutState(auditKey(rec.TxID), raw); err != nil {
+		return err
+	}
+	dbg("[CC][AUDIT] %s by %s: ct_q %s (%d bytes), collection=%q blob=%v reused_from=%q", rec.TxID, rec.ClientMSP, rec.QuerySHA256, rec.QueryBytes, rec.Collection, rec.Blob != nil, rec.ReusedFrom)
+	return nil
+}
+
+// PublicQuerySubmit is PublicQuery as a submitted transaction: it
+// commits an AuditRecord of the caller and the key read under
+// auditPublicPrefix+txID and returns a utils.PublicReceipt, so the caller
+// can cite the audit entry without deriving its key. The record is not
+// private, so there is no payload to place.
+func (cc *PIRChainCode) PublicQuerySubmit(ctx contractapi.TransactionContextInterface, key string) (string, error) {
+	rec, err := cc.publicQuery(ctx, "PublicQuerySubmit", key)
+	if err != nil {
+		return "", err
+	}
+	audit, err := newAuditRecord(ctx, "PublicQuerySubmit")
+	if err != nil {
+		return "", fmt.Errorf("PublicQuerySubmit: %w", err)
+	}
+	audit.Key = key
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return "", fmt.Errorf("PublicQuerySubmit: tx timestamp: %w", err)
+	}
+	raw, _ := json.Marshal(audit)
+	entryKey := auditPublicPrefix + audit.TxID
+	if err := ctx.GetStub().PutState(entryKey, raw); err != nil {
+		return "", err
+	}
+	dbg("[CC][AUDIT] %s by %s: public read of %s", audit.TxID, audit.ClientMSP, key)
+
+	receipt := utils.PublicReceipt{
+		Record:    json.RawMessage(rec),
+		AuditKey:  entryKey,
+		TxID:      audit.TxID,
+		Timestamp: ts.AsTime().UTC().Format(time.RFC3339Nano),
+	}
+	if !json.Valid(receipt.Record) {
+		receipt.Record, _ = json.Marshal(rec)
+	}
+	out, _ := json.Marshal(receipt)
+	return string(out), nil
+}
+
+// loadAudit reads the audit record of txID, PIRQuerySubmit's or
+// PublicQuerySubmit's.
+func loadAudit(ctx contractapi.TransactionContextInterface, txID string) (AuditRecord, error) {
+	var rec AuditRecord
+	raw, err := ctx.GetStub().GetState(auditKey(txID))
+	if err == nil && raw == nil {
+		raw, err = ctx.GetStub().GetState(auditPublicPrefix + txID)
+	}
+	if err != nil {
+		return rec, err
+	}
+	if raw == nil {
+		return rec, fmt.Errorf("no audit record for tx %s", txID)
+	}
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return rec, fmt.Errorf("audit record %s: %w", txID, err)
+	}
+	return rec, nil
+}
+
+// GetAuditRecord returns the public audit record of txID (inline payloads
+// included).
+func (cc *PIRChainCode) GetAuditRecord(ctx contractapi.TransactionContextInterface, txID string) (string, error) {
+	start := time.Now()
+	rec, err := loadAudit(ctx, txID)
+	if err != nil {
+		return "", fmt.Errorf("GetAuditRecord: %w", err)
+	}
+	return utils.MarshalTimed(rec, start)
+}
+
+// GetAuditPayload returns the ct_q of txID and whether it matches the
+// hash in the public record. For offloaded payloads it only succeeds on
+// peers of the collection's member orgs.
+func (cc *PIRChainCode) GetAuditPayload(ctx contractapi.TransactionContextInterface, txID string) (string, error) {
+	start := time.Now()
+	rec, err := loadAudit(ctx, txID)
+	if err != nil {
+		return "", fmt.Errorf("GetAuditPayload: %w", err)
+	}
+	if rec.Blob != nil {
+		return utils.MarshalTimed(AuditPayload{TxID: txID, Blob: rec.Blob}, start)
+	}
+	payload := rec.Payload
+	if rec.Collection != "" {
+		raw, err := ctx.GetStub().GetPrivateData(rec.Collection, auditPayloadKey+txID)
+		if err != nil {
+			return "", fmt.Errorf("GetAuditPayload: collection %q: %w", rec.Collection, err)
+		}
+		if raw == nil {
+			return "", fmt.Errorf("GetAuditPayload: payload of %s not on this peer (collection %q, or purged)", txID, rec.Collection)
+		}
+		payload = string(raw)
+	}
+	return utils.MarshalTimed(AuditPayload{
+		TxID:        txID,
+		EncQueryB64: payload,
+		Verified:    querySHA256(payload) == rec.QuerySHA256 && len(payload) == rec.QueryBytes,
+	}, start)
+}
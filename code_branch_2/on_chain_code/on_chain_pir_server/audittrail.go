@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/utils"
+)
+
+/**************  AUDIT TRAIL *******************************************/
+
+// GetAuditRecord needs the tx id of an entry; GetAuditTrail lists them.
+// It scans the audit:<txid> and audit:public:<txid> entries in key order
+// (the audit:query: freshness index is skipped) and keeps those inside a
+// time window and, optionally, of one MSP. A page holds at most pageSize
+// matching entries; its bookmark is the key of the last one, and the next
+// scan starts right after it, so a bookmark stays valid while new entries
+// are committed. One call reads at most auditTrailScanLimit keys, index
+// and filtered-out entries included: a narrow filter over a long history
+// returns short (even empty) pages with a bookmark rather than scanning it
+// all in one transaction. Entries are listed without their inline payload:
+// ct_q is hundreds of KB and GetAuditPayload returns it. Key order is tx
+// id order, not time order; Timestamp sorts a page.
+
+var (
+	auditTrailLimit     = envInt("PIR_AUDIT_TRAIL_LIMIT", 100) // entries per page by default
+	auditTrailScanLimit = envInt("PIR_AUDIT_TRAIL_SCAN_LIMIT", 10*auditTrailLimit)
+)
+
+// AuditTrailPage is GetAuditTrail's result. Bookmark is empty on the last
+// page; a page with fewer records than asked for may still have one.
+type AuditTrailPage struct {
+	Records  []AuditRecord `json:"records"`
+	Bookmark string        `json:"bookmark"`
+	Scanned  int           `json:"scanned"` // audit entries read for this page, filtered out included
+}
+
+// GetAuditTrail returns the page of audit entries after bookmark ("" for
+// the first) with startTime <= timestamp < endTime and, unless clientMSP
+// is empty, that MSP. Times are Unix seconds or RFC 3339; an empty bound
+// is open. pageSizeStr "" is PIR_AUDIT_TRAIL_LIMIT.
+func (cc *PIRChainCode) GetAuditTrail(ctx contractapi.TransactionContextInterface,
+	startTime, endTime, clientMSP, pageSizeStr, bookmark string) (string, error) {
+	start := time.Now()
+
+	from, err := parseAuditTime(startTime, 0)
+	if err != nil {
+		return "", fmt.Errorf("GetAuditTrail: start time: %w", err)
+	}
+	to, err := parseAuditTime(endTime, 1<<62)
+	if err != nil {
+		return "", fmt.Errorf("GetAuditTrail: end time: %w", err)
+	}
+	pageSize := auditTrailLimit
+	if pageSizeStr != "" {
+		if pageSize, err = strconv.Atoi(pageSizeStr); err != nil || pageSize <= 0 {
+			return "", fmt.Errorf("GetAuditTrail: page size must be a positive integer, got %q", pageSizeStr)
+		}
+	}
+	if bookmark != "" && !strings.HasPrefix(bookmark, auditPrefix) {
+		return "", fmt.Errorf("GetAuditTrail: bookmark %q is not an audit key", bookmark)
+	}
+
+	startKey := auditPrefix
+	if bookmark != "" {
+		startKey = bookmark + "\x00"
+	}
+	iter, err := ctx.GetStub().GetStateByRange(startKey, auditPrefix+string(utf8.MaxRune))
+	if err != nil {
+		return "", fmt.Errorf("GetAuditTrail: %w", err)
+	}
+	defer iter.Close()
+
+	page := AuditTrailPage{Records: []AuditRecord{}}
+	more, read := false, 0
+	for iter.HasNext() {
+		if read == auditTrailScanLimit {
+			more = true
+			break
+		}
+		kv, err := iter.Next()
+		if err != nil {
+			return "", fmt.Errorf("GetAuditTrail: %w", err)
+		}
+		read++
+		if strings.HasPrefix(kv.Key, auditQueryPrefix) {
+			page.Bookmark = kv.Key // a run of index keys must not stall the next page
+			continue
+		}
+		if len(page.Records) == pageSize {
+			more = true
+			break
+		}
+		page.Scanned++
+		page.Bookmark = kv.Key
+		var rec AuditRecord
+		if err := json.Unmarshal(kv.Value, &rec); err != nil {
+			return "", fmt.Errorf("GetAuditTrail: audit entry %s: %w", kv.Key, err)
+		}
+		if rec.Timestamp < from || rec.Timestamp >= to || (clientMSP != "" && rec.ClientMSP != clientMSP) {
+			continue
+		}
+		rec.Payload = ""
+		page.Records = append(page.Records, rec)
+	}
+	if !more {
+		page.Bookmark = ""
+	}
+
+	dbg("[CC][AUDIT] trail [%d, %d) msp=%q: %d of %d entries, bookmark %q",
+		from, to, clientMSP, len(page.Records), page.Scanned, page.Bookmark)
+	return utils.MarshalTimed(page, start)
+}
+
+// parseAuditTime reads a GetAuditTrail time bound as Unix seconds; s is
+// Unix seconds or RFC 3339, "" is def.
+func parseAuditTime(s string, def int64) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return def, nil
+	}
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return v, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is neither Unix seconds nor RFC 3339", s)
+	}
+	return t.Unix(), nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// TestAuditTrailScanLimit checks a filter that matches few entries of a
+// long history is answered over several pages, none reading more than
+// auditTrailScanLimit keys, and that paging also gets past a run of
+// freshness-index keys longer than the limit.
+func TestAuditTrailScanLimit(t *testing.T) {
+	logCfg.Store(&logConfig{})
+	cc, ctx := new(PIRChainCode), mockCtx()
+	defer func(n int) { auditTrailScanLimit = n }(auditTrailScanLimit)
+	auditTrailScanLimit = 10
+
+	put := func(key string, v any) {
+		raw, _ := json.Marshal(v)
+		if err := ctx.GetStub().PutState(key, raw); err != nil {
+			t.Fatalf("PutState(%s): %v", key, err)
+		}
+	}
+	want := map[string]bool{}
+	for i := 0; i < 40; i++ {
+		rec := AuditRecord{TxID: fmt.Sprintf("%064x", i), ClientMSP: "Org2MSP", Timestamp: int64(i)}
+		if i == 3 || i == 37 {
+			rec.ClientMSP = "Org1MSP"
+			want[rec.TxID] = true
+		}
+		put(auditKey(rec.TxID), rec)
+	}
+	for i := 0; i < 25; i++ {
+		put(auditQueryPrefix+fmt.Sprintf("%064x", i), fmt.Sprintf("%064x", i))
+	}
+
+	got, bookmark, pages := map[string]bool{}, "", 0
+	for {
+		out, err := cc.GetAuditTrail(ctx, "", "", "Org1MSP", "5", bookmark)
+		if err != nil {
+			t.Fatalf("GetAuditTrail: %v", err)
+		}
+		var resp struct {
+			Result AuditTrailPage `json:"result"`
+		}
+		if err := json.Unmarshal([]byte(out), &resp); err != nil {
+			t.Fatalf("parse response: %v", err)
+		}
+		page := resp.Result
+		if page.Scanned > auditTrailScanLimit {
+			t.Errorf("page %d scanned %d entries, limit %d", pages, page.Scanned, auditTrailScanLimit)
+		}
+		for _, rec := range page.Records {
+			got[rec.TxID] = true
+		}
+		pages++
+		if page.Bookmark == "" {
+			break
+		}
+		if page.Bookmark == bookmark {
+			t.Fatalf("page %d did not advance past bookmark %s", pages, bookmark)
+		}
+		if pages > 20 {
+			t.Fatal("paging did not end")
+		}
+		bookmark = page.Bookmark
+	}
+	if len(got) != len(want) {
+		t.Errorf("%d matching entries over %d pages, want %d", len(got), pages, len(want))
+	}
+	for id := range want {
+		if !got[id] {
+			t.Errorf("entry %s missing", id)
+		}
+	}
+	if least := (40 + 25) / auditTrailScanLimit; pages < least {
+		t.Errorf("%d pages, want at least %d under a scan limit of %d", pages, least, auditTrailScanLimit)
+	}
+}
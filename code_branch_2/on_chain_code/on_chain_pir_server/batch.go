@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/utils"
+)
+
+/**************  BATCH PIR *********************************************/
+
+// maxBatchQueries caps the ct_q of one PIRQueryBatch call
+// (PIR_MAX_BATCH_QUERIES, <=0 disables the cap). The watchdog budget
+// covers the whole batch, so a batch it cannot finish is refused up front.
+var maxBatchQueries = envInt("PIR_MAX_BATCH_QUERIES", utils.MaxBatchQueries)
+
+// PIRQueryBatch evaluates a JSON array of Base64 ct_q against m_DB in one
+// transaction (utils/batch.go) and returns a JSON array with each ct_q's
+// PIRQuery response, in request order. All products share the worker
+// pool, so the batch also runs in parallel across queries.
+func (cc *PIRChainCode) PIRQueryBatch(ctx contractapi.TransactionContextInterface, encQueriesJSON string) (string, error) {
+	out, _, err := cc.pirQueryBatch(ctx, encQueriesJSON)
+	return out, err
+}
+
+// PIRQueryBatchTimed is PIRQueryBatch wrapped in the {result,
+// execution_time_ms} envelope, plus the batch's resource usage.
+func (cc *PIRChainCode) PIRQueryBatchTimed(ctx contractapi.TransactionContextInterface, encQueriesJSON string) (string, error) {
+	start := time.Now()
+	result, usage, err := cc.pirQueryBatch(ctx, encQueriesJSON)
+	if err != nil {
+		return "", err
+	}
+	return utils.MarshalTimedUsage(result, start, &usage)
+}
+
+func (cc *PIRChainCode) pirQueryBatch(ctx contractapi.TransactionContextInterface, encQueriesJSON string) (string, utils.EvalUsage, error) {
+	queries, err := utils.ParseBatchQueries(encQueriesJSON, maxBatchQueries)
+	if err != nil {
+		return "", utils.EvalUsage{}, fmt.Errorf("PIRQueryBatch: %w", err)
+	}
+	selectors := make([][]string, len(queries))
+	for i, q := range queries {
+		selectors[i] = []string{q}
+	}
+	results, usage, err := cc.evalSelectors(ctx, "PIRQueryBatch", selectors, cc.ensureDB)
+	if err != nil {
+		return "", usage, err
+	}
+	return utils.EncodeBatchResults(results), usage, nil
+}
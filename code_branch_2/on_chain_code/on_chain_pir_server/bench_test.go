@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/gen_records"
+	"pir_shared/he"
+	"pir_shared/utils"
+)
+
+/**************  STEP BENCHMARKS ***************************************/
+
+// The Benchmark* functions time the chaincode's internal steps one by one
+// against a shimtest.MockStub, so a change to packing, encoding or
+// evaluation (NTT pre-transform, buffer pooling) can be measured without
+// a Fabric network:
+//
+//	Pack       IndexContract.Pack of the generated records
+//	Encode     he.Default.Encode of the packed slots into m_DB
+//	Marshal    m_DB MarshalBinary
+//	Persist    persistDB into the mock stub (marshal, digest, PutState, deltas)
+//	Unmarshal  he.DecodeQuery of ct_q (Base64 → ciphertext, shape check)
+//	Mul        he.Default.MulPlain, ct_q × m_DB (Lattigo MulNew)
+//	Result     ct_r MarshalBinary + Base64
+//	PIRQuery   the whole PIRQuery transaction against the mock stub
+//
+// Each runs once per ring size of -bench.logN, e.g.
+//
+//	go test -run '^$' -bench 'Mul|PIRQuery' -benchmem -bench.logN 13,14
+
+var (
+	benchLogNs   = flag.String("bench.logN", "13,14,15", "comma-separated ring sizes of the step benchmarks")
+	benchN       = flag.Int("bench.n", 64, "records of the step benchmarks")
+	benchMaxJSON = flag.Int("bench.max_json", 128, "max JSON length per record of the step benchmarks")
+)
+
+// mockIdentity is the caller the mock stub reports to clientID.
+// mockIdentity is a client of msp, BenchMSP when empty.
+type mockIdentity struct{ msp string }
+
+func (mockIdentity) GetID() (string, error) { return "bench", nil }
+func (m mockIdentity) GetMSPID() (string, error) {
+	if m.msp == "" {
+		return "BenchMSP", nil
+	}
+	return m.msp, nil
+}
+func (mockIdentity) GetAttributeValue(string) (string, bool, error) {
+	return "", false, nil
+}
+func (mockIdentity) AssertAttributeValue(name, _ string) error {
+	return fmt.Errorf("attribute %s not found", name)
+}
+func (mockIdentity) GetX509Certificate() (*x509.Certificate, error) { return nil, nil }
+
+// mockCtx returns a transaction context on a fresh mock stub with an
+// open transaction.
+func mockCtx() *contractapi.TransactionContext {
+	stub := shimtest.NewMockStub("on_chain_pir", nil)
+	stub.MockTransactionStart("bench")
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(mockIdentity{})
+	return ctx
+}
+
+// benchFixture is one dataset and query at one logN: the inputs of every
+// step, built once from the earlier steps, and a committed dataset on a
+// mock stub, the state PIRQuery runs against.
+type benchFixture struct {
+	p        he.Params
+	ic       utils.IndexContract
+	records  [][]byte
+	packed   []uint64
+	mDB      he.Plaintext
+	ctQ      he.Ciphertext
+	queryB64 string
+	ctR      he.Ciphertext
+	cc       *PIRChainCode
+	ctx      *contractapi.TransactionContext
+}
+
+var benchFixtures sync.Map // logN → *benchFixture
+
+func newBenchFixture(logN, n, maxJSON int) (*benchFixture, error) {
+	f := &benchFixture{cc: new(PIRChainCode), ctx: mockCtx()}
+	var err error
+	if f.p, err = he.Default.NewParams(utils.BGVParamHint{LogN: logN}); err != nil {
+		return nil, err
+	}
+	if f.records, err = gen_records.GenerateRecords(n, logN, maxJSON); err != nil {
+		return nil, err
+	}
+	f.ic = utils.IndexContract{NRecords: n, RecordS: utils.CalcSlotsPerRec(f.records), Slots: f.p.MaxSlots()}
+	if err := f.ic.Validate(); err != nil {
+		return nil, err
+	}
+	if f.packed, err = f.ic.Pack(f.records); err != nil {
+		return nil, err
+	}
+	if f.mDB, err = he.Default.Encode(f.p, f.packed); err != nil {
+		return nil, err
+	}
+	_, pk, err := he.Default.GenKeyPair(f.p)
+	if err != nil {
+		return nil, err
+	}
+	start, end, _ := f.ic.Window(n / 2)
+	sel := make([]uint64, f.p.MaxSlots())
+	for i := start; i < end; i++ {
+		sel[i] = 1
+	}
+	selPt, err := he.Default.Encode(f.p, sel)
+	if err != nil {
+		return nil, err
+	}
+	if f.ctQ, err = he.Default.Encrypt(f.p, pk, selPt); err != nil {
+		return nil, err
+	}
+	qBytes, err := f.ctQ.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	f.queryB64 = base64.StdEncoding.EncodeToString(qBytes)
+	if f.ctR, err = he.Default.MulPlain(f.p, f.ctQ, f.mDB); err != nil {
+		return nil, err
+	}
+
+	p := f.p
+	pm, _ := json.Marshal(bgvParamsMeta{LogN: p.LogN(), N: p.N(), LogQi: p.LogQi(), LogPi: p.LogPi(), T: p.PlaintextModulus()})
+	spec := datasetSpec{N: n, MaxJSON: maxJSON}
+	specRaw, _ := json.Marshal(spec)
+	f.ctx.GetStub().PutState("bgv_params", pm)
+	f.ctx.GetStub().PutState("dataset_spec", specRaw)
+	params, err := f.cc.ensureParams(f.ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.cc.storeAndPack(f.ctx, params, spec, f.records); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// benchSteps runs step as one sub-benchmark per -bench.logN ring size.
+func benchSteps(b *testing.B, step func(b *testing.B, f *benchFixture)) {
+	logCfg.Store(&logConfig{}) // no debug or sampled logs in the timings
+	for _, s := range strings.Split(*benchLogNs, ",") {
+		logN, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			b.Fatalf("invalid -bench.logN %q", s)
+		}
+		f, ok := benchFixtures.Load(logN)
+		if !ok {
+			nf, err := newBenchFixture(logN, *benchN, *benchMaxJSON)
+			if err != nil {
+				b.Fatalf("logN=%d: %v", logN, err)
+			}
+			f, _ = benchFixtures.LoadOrStore(logN, nf)
+		}
+		b.Run(fmt.Sprintf("logN=%d", logN), func(b *testing.B) {
+			b.ReportAllocs()
+			step(b, f.(*benchFixture))
+		})
+	}
+}
+
+func BenchmarkPack(b *testing.B) {
+	benchSteps(b, func(b *testing.B, f *benchFixture) {
+		for b.Loop() {
+			if _, err := f.ic.Pack(f.records); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkEncode(b *testing.B) {
+	benchSteps(b, func(b *testing.B, f *benchFixture) {
+		for b.Loop() {
+			if _, err := he.Default.Encode(f.p, f.packed); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	benchSteps(b, func(b *testing.B, f *benchFixture) {
+		for b.Loop() {
+			if _, err := f.mDB.MarshalBinary(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkPersist(b *testing.B) {
+	benchSteps(b, func(b *testing.B, f *benchFixture) {
+		ctx := mockCtx()
+		for b.Loop() {
+			if err := f.cc.persistDB(ctx, []he.Plaintext{f.mDB}, f.ic.NRecords, f.ic.RecordS, 0, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	benchSteps(b, func(b *testing.B, f *benchFixture) {
+		for b.Loop() {
+			if _, _, err := he.DecodeQuery(he.Default, f.p, f.queryB64); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkMul(b *testing.B) {
+	benchSteps(b, func(b *testing.B, f *benchFixture) {
+		for b.Loop() {
+			if _, err := he.Default.MulPlain(f.p, f.ctQ, f.mDB); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkResult(b *testing.B) {
+	benchSteps(b, func(b *testing.B, f *benchFixture) {
+		for b.Loop() {
+			out, err := f.ctR.MarshalBinary()
+			if err != nil {
+				b.Fatal(err)
+			}
+			_ = base64.StdEncoding.EncodeToString(out)
+		}
+	})
+}
+
+func BenchmarkPIRQuery(b *testing.B) {
+	benchSteps(b, func(b *testing.B, f *benchFixture) {
+		for b.Loop() {
+			if _, err := f.cc.PIRQuery(f.ctx, f.queryB64); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/utils"
+)
+
+/**************  CHANGE FEED ******************************************/
+
+// Every mutation API ends in persistDB, which appends the record changes
+// of the new m_DB_version to the change log under changeKey(version): the
+// index, op, txid and tx timestamp of each one. GetChangesSince pages
+// through it, so a mirror server or a delta-PIR client syncs by asking
+// for the versions after the last one it applied instead of diffing
+// metadata. Chaincode cannot see block numbers, so the cursor is
+// m_DB_version; the txid locates the block (qscc GetBlockByTxID).
+
+// maxChangeVersions bounds the versions one GetChangesSince call reads
+// (PIR_MAX_CHANGE_VERSIONS); the rest is left for the next call (More).
+var maxChangeVersions = envInt("PIR_MAX_CHANGE_VERSIONS", 256)
+
+func changeKey(version int) string {
+	return fmt.Sprintf("mdb_changes%06d", version)
+}
+
+// putChanges logs changes as the record changes of version; nil changes
+// is a full rewrite, logged as one ChangeReset.
+func putChanges(ctx contractapi.TransactionContextInterface, version int, changes []utils.RecordChange) error {
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return fmt.Errorf("tx timestamp: %w", err)
+	}
+	if changes == nil {
+		changes = []utils.RecordChange{{Index: -1, Op: utils.ChangeReset}}
+	}
+	logged := make([]utils.RecordChange, len(changes))
+	for k, c := range changes {
+		c.Version, c.TxID, c.Timestamp = version, ctx.GetStub().GetTxID(), ts.GetSeconds()
+		logged[k] = c
+	}
+	raw, _ := json.Marshal(logged)
+	return ctx.GetStub().PutState(changeKey(version), raw)
+}
+
+// GetChangesSince returns the utils.ChangeFeed of the m_DB_versions after
+// since, at most maxChangeVersions of them. Versions written before the
+// change log existed read as a ChangeReset. Evaluate only.
+func (cc *PIRChainCode) GetChangesSince(ctx contractapi.TransactionContextInterface, sinceStr string) (string, error) {
+	start := time.Now()
+	since, err := strconv.Atoi(sinceStr)
+	if err != nil {
+		return "", fmt.Errorf("GetChangesSince: invalid version %q", sinceStr)
+	}
+	version, err := dbVersion(ctx)
+	if err != nil {
+		return "", fmt.Errorf("GetChangesSince: %w", err)
+	}
+	if since < 0 || since > version {
+		return "", fmt.Errorf("GetChangesSince: version %d out of range 0..%d", since, version)
+	}
+
+	feed := utils.ChangeFeed{Since: since, Until: min(version, since+maxChangeVersions), Changes: []utils.RecordChange{}}
+	feed.More = feed.Until < version
+	for v := since + 1; v <= feed.Until; v++ {
+		raw, err := ctx.GetStub().GetState(changeKey(v))
+		if err != nil {
+			return "", fmt.Errorf("GetChangesSince: %w", err)
+		}
+		var changes []utils.RecordChange
+		if raw == nil || json.Unmarshal(raw, &changes) != nil {
+			changes = []utils.RecordChange{{Version: v, Index: -1, Op: utils.ChangeReset}}
+		}
+		feed.Changes = append(feed.Changes, changes...)
+	}
+	dbg("[CC][CHANGES] versions %d..%d: %d changes (more=%v)", since+1, feed.Until, len(feed.Changes), feed.More)
+	return utils.MarshalTimed(feed, start)
+}
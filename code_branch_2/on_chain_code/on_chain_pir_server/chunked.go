@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/utils"
+)
+
+/**************  CHUNKED INIT *****************************************/
+
+// Large datasets do not fit in one InitLedger/GenerateDataset transaction
+// (endorsement timeout, write-set size). InitBegin announces the dataset,
+// InitAddRecords stages client-supplied records under stageKey(i) in as
+// many transactions as needed, and InitCommit moves them to RecordKey(i)
+// and packs/encodes m_DB exactly like GenerateDataset. Staged records are
+// feed data, so InitAddRecords runs them through utils.SanitizeRecords
+// first; rejected records are reported back and counted, not staged.
+
+const (
+	stageKeyPrefix   = "init_stage"
+	stageCountKey    = "init_staged"
+	rejectedCountKey = "init_rejected"
+)
+
+// sanitizeOpts is utils.DefaultSanitize, adjustable through the chaincode
+// container's environment: PIR_SANITIZE=0 disables normalization and
+// PIR_SANITIZE_MAX_FIELD sets the field clamp (<=0 disables it). Records
+// longer than the dataset's maxJSON are always rejected.
+var sanitizeOpts = func() utils.SanitizeOptions {
+	opt := utils.DefaultSanitize
+	if envInt("PIR_SANITIZE", 1) == 0 {
+		opt = utils.SanitizeOptions{}
+	}
+	opt.MaxFieldLen = envInt("PIR_SANITIZE_MAX_FIELD", opt.MaxFieldLen)
+	return opt
+}()
+
+func stageKey(i int) string {
+	return fmt.Sprintf("%s%03d", stageKeyPrefix, i)
+}
+
+// stagedCount returns the accepted and rejected record counts of the open
+// session.
+func stagedCount(ctx contractapi.TransactionContextInterface) (staged, rejected int, err error) {
+	raw, err := ctx.GetStub().GetState(stageCountKey)
+	if err != nil {
+		return 0, 0, err
+	}
+	if raw == nil {
+		return 0, 0, fmt.Errorf("no chunked init in progress - call InitBegin first")
+	}
+	if staged, err = strconv.Atoi(string(raw)); err != nil {
+		return 0, 0, err
+	}
+	if raw, err = ctx.GetStub().GetState(rejectedCountKey); err != nil {
+		return 0, 0, err
+	}
+	rejected, _ = strconv.Atoi(string(raw)) // absent → 0
+	return staged, rejected, nil
+}
+
+// clearStage deletes staged records left by an earlier, unfinished session.
+func clearStage(ctx contractapi.TransactionContextInterface) error {
+	raw, err := ctx.GetStub().GetState(stageCountKey)
+	if err != nil || raw == nil {
+		return err
+	}
+	collection, err := recordCollection(ctx)
+	if err != nil {
+		return err
+	}
+	staged, _ := strconv.Atoi(string(raw))
+	for i := 0; i < staged; i++ {
+		if err := delRecord(ctx, collection, stageKey(i)); err != nil {
+			return err
+		}
+	}
+	if err := ctx.GetStub().DelState(rejectedCountKey); err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(stageCountKey)
+}
+
+// InitBegin takes the InitLedger arguments, commits params and dataset_spec,
+// and opens a staging session for numRecords records (dropping any
+// unfinished one).
+func (cc *PIRChainCode) InitBegin(ctx contractapi.TransactionContextInterface,
+	numRecordsStr, maxJsonLengthStr, logNStr, logQiJSON, logPiJSON, tStr string) (string, error) {
+
+	dbg("\n/**************  INIT BEGIN ***********************************************/")
+	if err := checkInitWrite(ctx, "InitBegin"); err != nil {
+		return "", err
+	}
+
+	pm, _, err := cc.beginDataset(ctx, "InitBegin", numRecordsStr, maxJsonLengthStr, logNStr, logQiJSON, logPiJSON, tStr)
+	if err != nil {
+		return "", err
+	}
+	if err := ctx.GetStub().PutState(stageCountKey, []byte("0")); err != nil {
+		return "", err
+	}
+	// GetState does not see this transaction's writes; n was validated above.
+	n, _ := strconv.Atoi(numRecordsStr)
+
+	dbg("[CC][CHUNK] Session open: n=%d LogN=%d", n, pm.LogN)
+	return utils.MarshalUntimed(utils.InitStatus{LogN: pm.LogN, NRecords: n})
+}
+
+// InitAddRecords sanitizes one utils.InitChunk and stages the accepted
+// records; the result lists the rejected ones with their reasons.
+func (cc *PIRChainCode) InitAddRecords(ctx contractapi.TransactionContextInterface, chunkJSON string) (string, error) {
+	chunkJSON, err := recordArg(ctx, chunkJSON)
+	if err != nil {
+		return "", fmt.Errorf("InitAddRecords: %w", err)
+	}
+	var chunk utils.InitChunk
+	if err := json.Unmarshal([]byte(chunkJSON), &chunk); err != nil {
+		return "", fmt.Errorf("InitAddRecords: invalid chunk JSON: %w", err)
+	}
+	staged, rejected, err := stagedCount(ctx)
+	if err != nil {
+		return "", fmt.Errorf("InitAddRecords: %w", err)
+	}
+	spec, err := loadSpec(ctx)
+	if err != nil {
+		return "", fmt.Errorf("InitAddRecords: %w", err)
+	}
+	if chunk.Offset != staged+rejected {
+		return "", fmt.Errorf("InitAddRecords: chunk offset %d, expected %d", chunk.Offset, staged+rejected)
+	}
+	if len(chunk.Records) == 0 || staged+rejected+len(chunk.Records) > spec.N {
+		return "", fmt.Errorf("InitAddRecords: %d records after %d staged + %d rejected do not fit n=%d",
+			len(chunk.Records), staged, rejected, spec.N)
+	}
+
+	raw := make([][]byte, len(chunk.Records))
+	for i, rec := range chunk.Records {
+		raw[i] = rec
+	}
+	opt, err := specSanitizeOpts(spec)
+	if err != nil {
+		return "", fmt.Errorf("InitAddRecords: %w", err)
+	}
+	opt.MaxRecordLen = spec.MaxJSON
+	clean, rejections := utils.SanitizeRecords(raw, opt)
+
+	for i, rec := range clean {
+		if err := putRecord(ctx, spec.Collection, stageKey(staged+i), rec); err != nil {
+			return "", err
+		}
+	}
+	staged += len(clean)
+	rejected += len(rejections)
+	if err := ctx.GetStub().PutState(stageCountKey, []byte(strconv.Itoa(staged))); err != nil {
+		return "", err
+	}
+	if err := ctx.GetStub().PutState(rejectedCountKey, []byte(strconv.Itoa(rejected))); err != nil {
+		return "", err
+	}
+
+	dbg("[CC][CHUNK] Staged %d/%d records (%d rejected)", staged, spec.N, rejected)
+	p, err := cc.ensureParams(ctx)
+	if err != nil {
+		return "", fmt.Errorf("InitAddRecords: %w", err)
+	}
+	return utils.MarshalUntimed(utils.InitStatus{
+		LogN: p.LogN(), NRecords: spec.N, Staged: staged, Rejected: rejected, Rejections: rejections,
+	})
+}
+
+// InitCommit requires every announced record to be staged or rejected, then
+// stores and packs the staged ones (storeAndPack) and closes the session.
+// Rejected records shrink the dataset: GetMetadata reports the staged count.
+func (cc *PIRChainCode) InitCommit(ctx contractapi.TransactionContextInterface) (string, error) {
+	dbg("\n/**************  INIT COMMIT START ****************************************/")
+	start := time.Now()
+
+	if err := checkInitWrite(ctx, "InitCommit"); err != nil {
+		return "", err
+	}
+
+	p, err := cc.ensureParams(ctx)
+	if err != nil {
+		return "", fmt.Errorf("InitCommit: %w", err)
+	}
+	spec, err := loadSpec(ctx)
+	if err != nil {
+		return "", fmt.Errorf("InitCommit: %w", err)
+	}
+	staged, rejected, err := stagedCount(ctx)
+	if err != nil {
+		return "", fmt.Errorf("InitCommit: %w", err)
+	}
+	if staged+rejected != spec.N || staged == 0 {
+		return "", fmt.Errorf("InitCommit: %d staged + %d rejected of %d records", staged, rejected, spec.N)
+	}
+
+	records := make([][]byte, staged)
+	for i := range records {
+		rec, err := getRecord(ctx, spec.Collection, stageKey(i))
+		if err != nil || rec == nil {
+			return "", fmt.Errorf("InitCommit: staged record %d missing", i)
+		}
+		records[i] = rec
+	}
+	if err := cc.storeAndPack(ctx, p, spec, records); err != nil {
+		return "", fmt.Errorf("InitCommit: %w", err)
+	}
+	if err := clearStage(ctx); err != nil {
+		return "", fmt.Errorf("InitCommit: %w", err)
+	}
+
+	dbg("[CC][CHUNK] Committed %d records in %.3f ms", staged, msOf(time.Since(start)))
+	dbg("/**************  INIT COMMIT END ******************************************/")
+	return utils.MarshalUntimed("success")
+}
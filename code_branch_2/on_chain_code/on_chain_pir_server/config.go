@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/utils"
+)
+
+/**************  RUNTIME CONFIG ***************************************/
+
+// Settings that change without a chaincode upgrade live on the ledger
+// under configKey (SetConfig), one config per dataset. beforeTransaction
+// reads the key at the start of every transaction, on every endorser
+// alike so read sets still match, and attaches the parsed config to the
+// transaction's stub (configOf); parsing is cached per dataset and redone
+// only when the key's bytes change. A new config takes effect on each
+// peer with the next transaction after its block commits. Debug logging
+// has no transaction to ask and follows the config of the latest one.
+
+const configKey = "chaincode_config"
+
+// runtimeConfig is the JSON stored under configKey.
+type runtimeConfig struct {
+	Log       logConfig       `json:"log"`
+	Audit     auditConfig     `json:"audit"`
+	Integrity integrityConfig `json:"integrity"`
+	Result    resultConfig    `json:"result"`
+	Approval  approvalConfig  `json:"approval"`
+}
+
+// logConfig controls debug output. dbg follows Debug alone; the hot query
+// functions (PIRQuery, PIRQueryDelta, PIRQuerySubmit, MetaAndQuery,
+// PublicQuery, GetMetadata) log 1 in Functions[fn] calls, SampleEvery if fn is not
+// listed; 0 logs none. Their errors are logged regardless.
+type logConfig struct {
+	Debug       bool           `json:"debug"`
+	SampleEvery int            `json:"sample_every"`
+	Functions   map[string]int `json:"functions,omitempty"`
+}
+
+// auditConfig controls PIRQuerySubmit's selector reuse check (audit.go).
+// Freshness "flag" records in each audit record whether the exact ct_q
+// was audited before, "reject" fails such a transaction; "" or "off"
+// checks nothing.
+type auditConfig struct {
+	Freshness string `json:"freshness,omitempty"`
+}
+
+// integrityConfig selects the m_DB digest algorithm (utils.DigestSHA256
+// or utils.DigestBLAKE3; "" is SHA-256). It applies to the next m_DB
+// write; the digest already committed keeps its algorithm, recorded under
+// m_DB_digest_alg.
+type integrityConfig struct {
+	Digest string `json:"digest,omitempty"`
+}
+
+// resultConfig controls the ct_r the query functions return. With
+// ModSwitch the server modulus-switches every result ciphertext to level 0
+// before marshalling it (he.Engine.ModSwitch): ct_r shrinks to
+// 1/len(logQi) of its size, and the clients decrypt it as before. Applies
+// to BGV datasets with more than one Q modulus; others are returned at
+// full level.
+type resultConfig struct {
+	ModSwitch bool `json:"mod_switch,omitempty"`
+}
+
+// approvalConfig controls who may change the dataset (approval.go). With
+// Required, every change goes through the staging area and a
+// ProposePublish/ApprovePublish pair from two different MSPs; functions
+// that write the dataset directly are refused once it holds records, and
+// turning Required off takes the same two MSPs (SetConfig).
+type approvalConfig struct {
+	Required bool `json:"required,omitempty"`
+}
+
+// defaultConfig applies until the first SetConfig. PIR_DEBUG=0 in the
+// container's environment starts a peer quiet.
+var defaultConfig = runtimeConfig{Log: logConfig{Debug: envInt("PIR_DEBUG", 1) != 0, SampleEvery: 1}}
+
+// cfgCache is the parsed config of each dataset (datasetOf) with the
+// configKey bytes it was parsed from.
+var cfgCache = struct {
+	sync.Mutex
+	m map[string]cachedConfig
+}{m: map[string]cachedConfig{}}
+
+type cachedConfig struct {
+	raw []byte
+	cfg *runtimeConfig
+}
+
+// logCfg is the log section of the latest transaction's config (dbg,
+// sampledLog).
+var logCfg atomic.Pointer[logConfig]
+
+func init() { logCfg.Store(&defaultConfig.Log) }
+
+// configOf returns the config of ctx's transaction: the one
+// beforeTransaction attached to its stub, else (a stub that did not go
+// through datasetChaincode) the dataset's configKey read now.
+func configOf(ctx contractapi.TransactionContextInterface) *runtimeConfig {
+	if ds, ok := ctx.GetStub().(*datasetStub); ok && ds.cfg != nil {
+		return ds.cfg
+	}
+	c, err := loadConfig(ctx)
+	if err != nil {
+		fmt.Printf("[CC][ERROR] %s: %v - using defaults\n", configKey, err)
+		return &defaultConfig
+	}
+	return c
+}
+
+// loadConfig reads and parses the dataset's configKey, defaults when it is
+// unset or invalid.
+func loadConfig(ctx contractapi.TransactionContextInterface) (*runtimeConfig, error) {
+	raw, err := ctx.GetStub().GetState(configKey)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", configKey, err)
+	}
+	name := datasetOf(ctx)
+	cfgCache.Lock()
+	defer cfgCache.Unlock()
+	if c, ok := cfgCache.m[name]; ok && bytes.Equal(raw, c.raw) {
+		return c.cfg, nil
+	}
+	next := defaultConfig
+	if raw != nil {
+		if next, err = parseConfig(raw); err != nil {
+			// SetConfig validated it; keep the dataset serving on defaults.
+			fmt.Printf("[CC][ERROR] %s: %v - using defaults\n", configKey, err)
+			next = defaultConfig
+		}
+	}
+	cfgCache.m[name] = cachedConfig{raw: raw, cfg: &next}
+	return &next, nil
+}
+
+func parseConfig(raw []byte) (runtimeConfig, error) {
+	var c runtimeConfig
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return runtimeConfig{}, fmt.Errorf("invalid config JSON: %w", err)
+	}
+	if c.Log.SampleEvery < 0 {
+		return runtimeConfig{}, fmt.Errorf("log.sample_every must be >= 0, got %d", c.Log.SampleEvery)
+	}
+	switch c.Audit.Freshness {
+	case "", freshnessOff, freshnessFlag, freshnessReject:
+	default:
+		return runtimeConfig{}, fmt.Errorf("audit.freshness must be %q, %q or %q, got %q",
+			freshnessOff, freshnessFlag, freshnessReject, c.Audit.Freshness)
+	}
+	if _, err := utils.ParseDigestAlg(c.Integrity.Digest); err != nil {
+		return runtimeConfig{}, fmt.Errorf("integrity.digest: %w", err)
+	}
+	for fn, every := range c.Log.Functions {
+		if every < 0 {
+			return runtimeConfig{}, fmt.Errorf("log.functions[%q] must be >= 0, got %d", fn, every)
+		}
+	}
+	return c, nil
+}
+
+// beforeTransaction is the contract's BeforeTransaction hook.
+func beforeTransaction(ctx contractapi.TransactionContextInterface) error {
+	c, err := loadConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if ds, ok := ctx.GetStub().(*datasetStub); ok {
+		ds.cfg = c
+	}
+	logCfg.Store(&c.Log)
+	return nil
+}
+
+// SetConfig replaces the runtime config with configJSON (see
+// runtimeConfig); "" deletes it, restoring the defaults. While the
+// current config requires approval, a config that does not is only
+// proposed: SetConfig returns the pending ConfigProposal, and the same
+// configJSON from a second MSP applies it.
+func (cc *PIRChainCode) SetConfig(ctx contractapi.TransactionContextInterface, configJSON string) (string, error) {
+	if configOf(ctx).Approval.Required {
+		lifts := configJSON == ""
+		if !lifts {
+			c, err := parseConfig([]byte(configJSON))
+			if err != nil {
+				return "", fmt.Errorf("SetConfig: %w", err)
+			}
+			lifts = !c.Approval.Required
+		}
+		if lifts {
+			p, err := approveConfigChange(ctx, configJSON)
+			if err != nil {
+				return "", fmt.Errorf("SetConfig: %w", err)
+			}
+			if p != nil {
+				return utils.MarshalUntimed(p)
+			}
+		}
+	}
+	if configJSON == "" {
+		if err := ctx.GetStub().DelState(configKey); err != nil {
+			return "", fmt.Errorf("SetConfig: %w", err)
+		}
+		return utils.MarshalUntimed(defaultConfig)
+	}
+	c, err := parseConfig([]byte(configJSON))
+	if err != nil {
+		return "", fmt.Errorf("SetConfig: %w", err)
+	}
+	raw, _ := json.Marshal(c)
+	if err := ctx.GetStub().PutState(configKey, raw); err != nil {
+		return "", fmt.Errorf("SetConfig: %w", err)
+	}
+	dbg("[CC][CONFIG] %s", raw)
+	return utils.MarshalUntimed(c)
+}
+
+// GetConfig returns the config the dataset runs with on this peer.
+func (cc *PIRChainCode) GetConfig(ctx contractapi.TransactionContextInterface) (string, error) {
+	out, err := json.Marshal(configOf(ctx))
+	if err != nil {
+		return "", fmt.Errorf("GetConfig: %w", err)
+	}
+	return string(out), nil
+}
+
+/**************  DEBUG LOGGING ****************************************/
+
+func dbg(format string, a ...interface{}) {
+	if logCfg.Load().Debug {
+		fmt.Printf(format+"\n", a...)
+	}
+}
+
+// callLog is the debug log of one call of a hot function, sampled per
+// function name (see logConfig).
+type callLog struct {
+	fn string
+	on bool
+}
+
+var sampleCounts = struct {
+	sync.Mutex
+	n map[string]uint64
+}{n: map[string]uint64{}}
+
+func sampledLog(fn string) callLog {
+	c := logCfg.Load()
+	every, ok := c.Functions[fn]
+	if !ok {
+		every = c.SampleEvery
+	}
+	if !c.Debug || every <= 0 {
+		return callLog{fn: fn}
+	}
+	sampleCounts.Lock()
+	k := sampleCounts.n[fn]
+	sampleCounts.n[fn]++
+	sampleCounts.Unlock()
+	return callLog{fn: fn, on: k%uint64(every) == 0}
+}
+
+func (l callLog) dbg(format string, a ...interface{}) {
+	if l.on {
+		fmt.Printf(format+"\n", a...)
+	}
+}
+
+// fail logs a non-nil err whether or not the call was sampled.
+func (l callLog) fail(err error) {
+	if err != nil {
+		fmt.Printf("[CC][ERROR][%s] %v\n", l.fn, err)
+	}
+}
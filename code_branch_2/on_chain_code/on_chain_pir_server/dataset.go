@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+/**************  DATASETS **********************************************/
+
+// One chaincode instance hosts several PIR datasets on a channel, like the
+// off-chain server's /invoke "dataset" field. A transaction selects one by
+// passing "ds:<name>" as its first argument, before the function's own:
+//
+//	peer chaincode query ... '{"Args":["PIRQuery","ds:clinics","<ct_q>"]}'
+//
+// Every world-state, private-data and history key of that transaction is
+// then stored under "ds:<name>:" ("ds:clinics:m_DB", "ds:clinics:record000",
+// ...), so InitLedger, PIRQuery, GetChangesSince and the rest work on the
+// named dataset unchanged. Without the argument a transaction works on the
+// default dataset, whose keys keep their unprefixed names; range scans of
+// the default dataset skip the "ds:" keys. The in-memory params and m_DB
+// are cached per dataset (PIRChainCode.cache).
+
+const datasetArgPrefix = "ds:"
+
+var datasetNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// datasetChaincode routes every transaction through a datasetStub.
+type datasetChaincode struct {
+	inner shim.Chaincode
+}
+
+func (d datasetChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	ds, err := newDatasetStub(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return d.inner.Init(ds)
+}
+
+func (d datasetChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	ds, err := newDatasetStub(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return d.inner.Invoke(ds)
+}
+
+// datasetStub is the stub of one transaction with its dataset argument
+// removed and its keys moved under the dataset's prefix.
+type datasetStub struct {
+	shim.ChaincodeStubInterface
+	name   string         // "" for the default dataset
+	prefix string         // "ds:<name>:", "" for the default dataset
+	args   [][]byte       // function name and parameters, without "ds:<name>"
+	cfg    *runtimeConfig // the dataset's config, set by beforeTransaction
+}
+
+// newDatasetStub strips an optional "ds:<name>" first argument from stub.
+func newDatasetStub(stub shim.ChaincodeStubInterface) (*datasetStub, error) {
+	args := stub.GetArgs()
+	ds := &datasetStub{ChaincodeStubInterface: stub, args: args}
+	if len(args) < 2 || !bytes.HasPrefix(args[1], []byte(datasetArgPrefix)) {
+		return ds, nil
+	}
+	name := string(args[1][len(datasetArgPrefix):])
+	if !datasetNameRe.MatchString(name) {
+		return nil, fmt.Errorf("invalid dataset name %q (want 1-64 of [A-Za-z0-9_-])", name)
+	}
+	ds.name, ds.prefix = name, datasetArgPrefix+name+":"
+	ds.args = append([][]byte{args[0]}, args[2:]...)
+	return ds, nil
+}
+
+// datasetOf returns the dataset the transaction works on, "" for the
+// default one.
+func datasetOf(ctx contractapi.TransactionContextInterface) string {
+	if ds, ok := ctx.GetStub().(*datasetStub); ok {
+		return ds.name
+	}
+	return ""
+}
+
+func (s *datasetStub) GetArgs() [][]byte { return s.args }
+
+func (s *datasetStub) GetStringArgs() []string {
+	out := make([]string, len(s.args))
+	for i, a := range s.args {
+		out[i] = string(a)
+	}
+	return out
+}
+
+func (s *datasetStub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", nil
+	}
+	return args[0], args[1:]
+}
+
+func (s *datasetStub) GetArgsSlice() ([]byte, error) {
+	return bytes.Join(s.args, nil), nil
+}
+
+// key maps a dataset key to its world-state key. The default dataset may
+// not reach into a named one.
+func (s *datasetStub) key(key string) (string, error) {
+	if s.prefix == "" && strings.HasPrefix(key, datasetArgPrefix) {
+		return "", fmt.Errorf("key %q is reserved for named datasets", key)
+	}
+	return s.prefix + key, nil
+}
+
+func (s *datasetStub) GetState(key string) ([]byte, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.ChaincodeStubInterface.GetState(k)
+}
+
+func (s *datasetStub) PutState(key string, value []byte) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	return s.ChaincodeStubInterface.PutState(k, value)
+}
+
+func (s *datasetStub) DelState(key string) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	return s.ChaincodeStubInterface.DelState(k)
+}
+
+func (s *datasetStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.ChaincodeStubInterface.GetHistoryForKey(k)
+}
+
+func (s *datasetStub) GetPrivateData(collection, key string) ([]byte, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.ChaincodeStubInterface.GetPrivateData(collection, k)
+}
+
+func (s *datasetStub) GetPrivateDataHash(collection, key string) ([]byte, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.ChaincodeStubInterface.GetPrivateDataHash(collection, k)
+}
+
+func (s *datasetStub) PutPrivateData(collection, key string, value []byte) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	return s.ChaincodeStubInterface.PutPrivateData(collection, k, value)
+}
+
+func (s *datasetStub) DelPrivateData(collection, key string) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	return s.ChaincodeStubInterface.DelPrivateData(collection, k)
+}
+
+// GetStateByRange scans the dataset's keys only, returned without the
+// prefix; "" bounds stay open within the dataset.
+func (s *datasetStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	start, end := s.prefix+startKey, s.prefix+endKey
+	if endKey == "" && s.prefix != "" {
+		end = s.prefix + string(utf8.MaxRune)
+	}
+	iter, err := s.ChaincodeStubInterface.GetStateByRange(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return &datasetIter{StateQueryIteratorInterface: iter, prefix: s.prefix}, nil
+}
+
+// datasetIter strips the dataset prefix from a range scan and, for the
+// default dataset, skips the named datasets' keys.
+type datasetIter struct {
+	shim.StateQueryIteratorInterface
+	prefix string
+	next   *queryresult.KV
+	err    error
+}
+
+func (it *datasetIter) HasNext() bool {
+	for it.next == nil && it.err == nil && it.StateQueryIteratorInterface.HasNext() {
+		kv, err := it.StateQueryIteratorInterface.Next()
+		if err != nil {
+			it.err = err
+			break
+		}
+		if it.prefix == "" && strings.HasPrefix(kv.Key, datasetArgPrefix) {
+			continue
+		}
+		kv.Key = strings.TrimPrefix(kv.Key, it.prefix)
+		it.next = kv
+	}
+	return it.next != nil || it.err != nil
+}
+
+func (it *datasetIter) Next() (*queryresult.KV, error) {
+	if !it.HasNext() {
+		return nil, fmt.Errorf("no more keys in range")
+	}
+	kv, err := it.next, it.err
+	it.next, it.err = nil, nil
+	return kv, err
+}
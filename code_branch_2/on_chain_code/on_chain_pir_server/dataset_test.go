@@ -0,0 +1,215 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+// keyStub is a MockStub with the transaction arguments given and the
+// keys of the calls MockStub does not implement recorded.
+type keyStub struct {
+	*shimtest.MockStub
+	args [][]byte
+	keys []string // keys of GetHistoryForKey, GetPrivateDataHash and DelPrivateData
+}
+
+func newKeyStub(args ...string) *keyStub {
+	s := &keyStub{MockStub: mockCtx().GetStub().(*shimtest.MockStub)}
+	for _, a := range args {
+		s.args = append(s.args, []byte(a))
+	}
+	return s
+}
+
+func (s *keyStub) GetArgs() [][]byte { return s.args }
+
+func (s *keyStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	s.keys = append(s.keys, key)
+	return nil, nil
+}
+
+func (s *keyStub) GetPrivateDataHash(_, key string) ([]byte, error) {
+	s.keys = append(s.keys, key)
+	return nil, nil
+}
+
+func (s *keyStub) DelPrivateData(_, key string) error {
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+func TestDatasetStubArgs(t *testing.T) {
+	cases := []struct {
+		name     string
+		args     []string
+		dataset  string
+		wantArgs []string
+		wantErr  string
+	}{
+		{"default", []string{"PIRQuery", "q"}, "", []string{"PIRQuery", "q"}, ""},
+		{"no parameters", []string{"GetMetadata"}, "", []string{"GetMetadata"}, ""},
+		{"named", []string{"PIRQuery", "ds:clinics", "q"}, "clinics", []string{"PIRQuery", "q"}, ""},
+		{"named, no parameters", []string{"GetMetadata", "ds:clinics"}, "clinics", []string{"GetMetadata"}, ""},
+		{"dataset only as first argument", []string{"PIRQuery", "q", "ds:clinics"}, "", []string{"PIRQuery", "q", "ds:clinics"}, ""},
+		{"empty name", []string{"PIRQuery", "ds:", "q"}, "", nil, "invalid dataset name"},
+		{"invalid name", []string{"PIRQuery", "ds:a:b", "q"}, "", nil, "invalid dataset name"},
+		{"name too long", []string{"PIRQuery", "ds:" + strings.Repeat("x", 65)}, "", nil, "invalid dataset name"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ds, err := newDatasetStub(newKeyStub(c.args...))
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("newDatasetStub(%q): %v, want an error containing %q", c.args, err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newDatasetStub(%q): %v", c.args, err)
+			}
+			if ds.name != c.dataset {
+				t.Errorf("dataset %q, want %q", ds.name, c.dataset)
+			}
+			if got := ds.GetStringArgs(); !reflect.DeepEqual(got, c.wantArgs) {
+				t.Errorf("GetStringArgs = %q, want %q", got, c.wantArgs)
+			}
+			if got := ds.GetArgs(); len(got) != len(c.wantArgs) {
+				t.Errorf("GetArgs has %d arguments, want %d", len(got), len(c.wantArgs))
+			}
+			fn, params := ds.GetFunctionAndParameters()
+			if fn != c.wantArgs[0] || !reflect.DeepEqual(params, c.wantArgs[1:]) {
+				t.Errorf("GetFunctionAndParameters = %q, %q, want %q, %q", fn, params, c.wantArgs[0], c.wantArgs[1:])
+			}
+			if slice, _ := ds.GetArgsSlice(); string(slice) != strings.Join(c.wantArgs, "") {
+				t.Errorf("GetArgsSlice = %q, want %q", slice, strings.Join(c.wantArgs, ""))
+			}
+		})
+	}
+}
+
+// TestDatasetStubKeys checks a named dataset's state, private-data and
+// history keys land under its prefix, and the default dataset keeps its
+// keys unprefixed but may not reach the "ds:" ones.
+func TestDatasetStubKeys(t *testing.T) {
+	under := newKeyStub()
+	named := &datasetStub{ChaincodeStubInterface: under, name: "a", prefix: "ds:a:"}
+	def := &datasetStub{ChaincodeStubInterface: under}
+
+	if err := named.PutState("m_DB", []byte("named")); err != nil {
+		t.Fatalf("PutState: %v", err)
+	}
+	if err := def.PutState("m_DB", []byte("default")); err != nil {
+		t.Fatalf("PutState: %v", err)
+	}
+	for key, want := range map[string]string{"ds:a:m_DB": "named", "m_DB": "default"} {
+		if got, _ := under.MockStub.GetState(key); string(got) != want {
+			t.Errorf("stored %s = %q, want %q", key, got, want)
+		}
+	}
+	if got, _ := named.GetState("m_DB"); string(got) != "named" {
+		t.Errorf("named GetState(m_DB) = %q, want %q", got, "named")
+	}
+	if err := named.DelState("m_DB"); err != nil {
+		t.Fatalf("DelState: %v", err)
+	}
+	if got, _ := under.MockStub.GetState("ds:a:m_DB"); got != nil {
+		t.Errorf("DelState left ds:a:m_DB = %q", got)
+	}
+	if got, _ := def.GetState("m_DB"); string(got) != "default" {
+		t.Errorf("named DelState removed the default dataset's m_DB (now %q)", got)
+	}
+
+	if err := named.PutPrivateData("cti", "record000", []byte("secret")); err != nil {
+		t.Fatalf("PutPrivateData: %v", err)
+	}
+	if got := under.PvtState["cti"]["ds:a:record000"]; string(got) != "secret" {
+		t.Errorf("private ds:a:record000 = %q, want %q", got, "secret")
+	}
+	if got, _ := named.GetPrivateData("cti", "record000"); string(got) != "secret" {
+		t.Errorf("named GetPrivateData = %q, want %q", got, "secret")
+	}
+	named.DelPrivateData("cti", "record000")
+	named.GetPrivateDataHash("cti", "record001")
+	named.GetHistoryForKey("record002")
+	def.GetHistoryForKey("record003")
+	if want := []string{"ds:a:record000", "ds:a:record001", "ds:a:record002", "record003"}; !reflect.DeepEqual(under.keys, want) {
+		t.Errorf("private-data and history keys %q, want %q", under.keys, want)
+	}
+
+	// the default dataset may not read or write a named one's keys
+	refused := map[string]func() error{
+		"GetState":           func() error { _, err := def.GetState("ds:a:m_DB"); return err },
+		"PutState":           func() error { return def.PutState("ds:a:m_DB", nil) },
+		"DelState":           func() error { return def.DelState("ds:a:m_DB") },
+		"GetPrivateData":     func() error { _, err := def.GetPrivateData("cti", "ds:a:x"); return err },
+		"GetPrivateDataHash": func() error { _, err := def.GetPrivateDataHash("cti", "ds:a:x"); return err },
+		"PutPrivateData":     func() error { return def.PutPrivateData("cti", "ds:a:x", nil) },
+		"DelPrivateData":     func() error { return def.DelPrivateData("cti", "ds:a:x") },
+		"GetHistoryForKey":   func() error { _, err := def.GetHistoryForKey("ds:a:m_DB"); return err },
+	}
+	for fn, call := range refused {
+		if err := call(); err == nil || !strings.Contains(err.Error(), "reserved for named datasets") {
+			t.Errorf("default dataset %s of a ds: key: %v, want it refused", fn, err)
+		}
+	}
+}
+
+// TestDatasetRangeScan checks range scans return a dataset's own keys
+// without the prefix and skip every other dataset's.
+func TestDatasetRangeScan(t *testing.T) {
+	under := newKeyStub()
+	for _, k := range []string{
+		"n", "record000", "record001",
+		"ds:a:n", "ds:a:record000", "ds:a:record001",
+		"ds:ab:record000", "ds:b:record000",
+	} {
+		if err := under.MockStub.PutState(k, []byte(k)); err != nil {
+			t.Fatalf("PutState(%s): %v", k, err)
+		}
+	}
+
+	cases := []struct {
+		name       string
+		prefix     string
+		start, end string
+		want       []string
+	}{
+		{"default, open", "", "", "", []string{"n", "record000", "record001"}},
+		{"default, bounded", "", "record", "record~", []string{"record000", "record001"}},
+		{"named, open", "ds:a:", "", "", []string{"n", "record000", "record001"}},
+		{"named, open end", "ds:a:", "record001", "", []string{"record001"}},
+		{"named, bounded", "ds:a:", "record", "record001", []string{"record000"}},
+		{"other named", "ds:b:", "", "", []string{"record000"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ds := &datasetStub{ChaincodeStubInterface: under, prefix: c.prefix}
+			iter, err := ds.GetStateByRange(c.start, c.end)
+			if err != nil {
+				t.Fatalf("GetStateByRange: %v", err)
+			}
+			defer iter.Close()
+			var got []string
+			for iter.HasNext() {
+				kv, err := iter.Next()
+				if err != nil {
+					t.Fatalf("Next: %v", err)
+				}
+				if string(kv.Value) != c.prefix+kv.Key {
+					t.Errorf("key %q holds %q, want the value of %q", kv.Key, kv.Value, c.prefix+kv.Key)
+				}
+				got = append(got, kv.Key)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("keys %q, want %q", got, c.want)
+			}
+			if _, err := iter.Next(); err == nil {
+				t.Error("Next past the end succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/he"
+	"pir_shared/utils"
+)
+
+/**************  DELTA PIR ********************************************/
+
+// A client that bootstrapped a local copy with GetFullDatasetChunk only
+// needs the records changed since that copy's m_DB_version. persistDB
+// stores a utils.VersionDelta per version under deltaKey(v); GetDelta folds
+// them into the (public) list of changed indices, and PIRQueryDelta runs
+// PIR over a plaintext holding just those records, so steady-state query
+// cost follows churn instead of n. Deltas spanning more than
+// maxDeltaVersions versions (PIR_MAX_DELTA_VERSIONS) are reported as Full.
+
+var maxDeltaVersions = envInt("PIR_MAX_DELTA_VERSIONS", 256)
+
+func deltaKey(version int) string {
+	return fmt.Sprintf("mdb_delta%06d", version)
+}
+
+// putVersionDelta records what version changed; changed == nil marks a
+// full rewrite.
+func putVersionDelta(ctx contractapi.TransactionContextInterface, version, n, recordS int, changed []int) error {
+	raw, _ := json.Marshal(utils.VersionDelta{N: n, RecordS: recordS, Full: changed == nil, Changed: changed})
+	return ctx.GetStub().PutState(deltaKey(version), raw)
+}
+
+// deltaSince folds the version deltas after since into one DeltaInfo.
+// Versions written before deltas were tracked count as full rewrites.
+func (cc *PIRChainCode) deltaSince(ctx contractapi.TransactionContextInterface, since int) (utils.DeltaInfo, error) {
+	version, err := dbVersion(ctx)
+	if err != nil {
+		return utils.DeltaInfo{}, err
+	}
+	if since < 0 || since > version {
+		return utils.DeltaInfo{}, fmt.Errorf("version %d out of range 0..%d", since, version)
+	}
+	info := utils.DeltaInfo{Since: since, Version: version, Changed: []int{}}
+	if version-since > maxDeltaVersions {
+		info.Full = true
+	}
+
+	set := map[int]bool{}
+	recordS := -1
+	for v := since + 1; v <= version && !info.Full; v++ {
+		raw, err := ctx.GetStub().GetState(deltaKey(v))
+		if err != nil {
+			return utils.DeltaInfo{}, err
+		}
+		var d utils.VersionDelta
+		if raw == nil || json.Unmarshal(raw, &d) != nil || d.Full || (recordS >= 0 && d.RecordS != recordS) {
+			info.Full = true
+			break
+		}
+		recordS = d.RecordS
+		for _, i := range d.Changed {
+			set[i] = true
+		}
+	}
+
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		return utils.DeltaInfo{}, err
+	}
+	info.N, info.RecordS, info.Packing = meta.NRecords, meta.RecordS, meta.Packing
+	if recordS >= 0 && recordS != meta.RecordS {
+		info.Full = true
+	}
+	if info.Full {
+		return info, nil
+	}
+	for i := range set {
+		if i < info.N {
+			info.Changed = append(info.Changed, i)
+		}
+	}
+	sort.Ints(info.Changed)
+	if len(info.Changed) > 0 {
+		info.LogN = utils.PlanDeltaLogN(len(info.Changed), info.RecordS, meta.LogN)
+	}
+	return info, nil
+}
+
+// GetDelta returns the utils.DeltaInfo between m_DB_version since and the
+// current version.
+func (cc *PIRChainCode) GetDelta(ctx contractapi.TransactionContextInterface, sinceStr string) (string, error) {
+	start := time.Now()
+	since, err := strconv.Atoi(sinceStr)
+	if err != nil {
+		return "", fmt.Errorf("GetDelta: invalid version %q", sinceStr)
+	}
+	info, err := cc.deltaSince(ctx, since)
+	if err != nil {
+		return "", fmt.Errorf("GetDelta: %w", err)
+	}
+	return utils.MarshalTimed(info, start)
+}
+
+// PIRQueryDelta evaluates ct_q against the delta plaintext for since:
+// window k holds record GetDelta(since).Changed[k]. The client encrypts
+// its query for n = len(Changed) under utils.DeltaHint, whose ring
+// shrinks with the delta.
+func (cc *PIRChainCode) PIRQueryDelta(ctx contractapi.TransactionContextInterface, sinceStr, encQueryB64 string) (string, error) {
+	since, err := strconv.Atoi(sinceStr)
+	if err != nil {
+		return "", fmt.Errorf("PIRQueryDelta: invalid version %q", sinceStr)
+	}
+	out, _, err := cc.evalQuery(ctx, "PIRQueryDelta", encQueryB64,
+		func(ctx contractapi.TransactionContextInterface) (he.Params, []he.Plaintext, error) {
+			p, pt, err := cc.ensureDelta(ctx, since)
+			return p, []he.Plaintext{pt}, err
+		})
+	return out, err
+}
+
+// ensureDelta builds (or reuses) the delta plaintext for since from the
+// windows of the committed m_DB.
+func (cc *PIRChainCode) ensureDelta(ctx contractapi.TransactionContextInterface, since int) (he.Params, he.Plaintext, error) {
+	info, err := cc.deltaSince(ctx, since)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Full || len(info.Changed) == 0 {
+		return nil, nil, fmt.Errorf("no delta since version %d (full=%v, %d changed)",
+			since, info.Full, len(info.Changed))
+	}
+	params, mDB, err := cc.ensureDB(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	dparams, err := he.Default.NewParams(utils.DeltaHint(info, meta))
+	if err != nil {
+		return nil, nil, fmt.Errorf("delta params: %w", err)
+	}
+
+	c := cc.cache(ctx)
+	c.mu.Lock()
+	key := fmt.Sprintf("%s/since=%d", c.dbKey, since)
+	if c.delta != nil && c.deltaKey == key {
+		defer c.mu.Unlock()
+		return dparams, c.delta, nil
+	}
+	c.mu.Unlock()
+
+	shards, err := decodeShards(params, mDB)
+	if err != nil {
+		return nil, nil, err
+	}
+	full := utils.IndexContract{NRecords: info.N, RecordS: info.RecordS, Slots: params.MaxSlots(), Packing: info.Packing}
+	records := make([][]byte, len(info.Changed))
+	for k, i := range info.Changed {
+		w, err := full.UnpackShards(shards, i, 1)
+		if err != nil {
+			return nil, nil, err
+		}
+		records[k] = w[0]
+	}
+	ic := utils.IndexContract{NRecords: len(records), RecordS: info.RecordS, Slots: dparams.MaxSlots(), Packing: info.Packing}
+	if err := ic.Validate(); err != nil {
+		return nil, nil, err
+	}
+	packed, err := ic.Pack(records)
+	if err != nil {
+		return nil, nil, err
+	}
+	pt, err := he.Default.Encode(dparams, packed)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encode delta: %w", err)
+	}
+
+	c.mu.Lock()
+	c.delta, c.deltaKey = pt, key
+	c.mu.Unlock()
+	dbg("[CC][DELTA] delta plaintext since v%d: %d of %d records, LogN=%d", since, len(records), info.N, dparams.LogN())
+	return dparams, pt, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/gen_records"
+	"pir_shared/utils"
+)
+
+/**************  DETERMINISTIC INIT ***********************************/
+
+// A transaction commits only when every endorser returns the same
+// read/write set and the same response. GenerateDataset already writes the
+// same m_DB on every peer (records, packing and encoding run in index and
+// shard order), and every transaction that writes state answers with
+// execution_time_ms 0 (utils.MarshalUntimed): the time it took differs
+// from peer to peer, so with endorsements from more than one org a timed
+// response fails with an endorsement mismatch. What is left is the
+// synthetic records: InitLedgerSeeded records an explicit seed in
+// dataset_spec and they are drawn from it (utils.SeededFakeHash).
+// VerifyMDBHash, run on a peer of each org, shows whether they hold (and
+// would regenerate) the same m_DB.
+
+// InitLedgerSeeded is InitLedger with seed, which must not be empty.
+// Every peer initialised with the same arguments generates the same
+// dataset in GenerateDataset.
+func (cc *PIRChainCode) InitLedgerSeeded(ctx contractapi.TransactionContextInterface,
+	numRecordsStr, maxJsonLengthStr, logNStr, logQiJSON, logPiJSON, tStr, seed string) (string, error) {
+
+	if seed == "" {
+		return "", fmt.Errorf("InitLedgerSeeded: seed must not be empty - use InitLedger for an unseeded dataset")
+	}
+	if err := checkInitWrite(ctx, "InitLedgerSeeded"); err != nil {
+		return "", err
+	}
+	pm, _, err := cc.beginDataset(ctx, "InitLedgerSeeded", numRecordsStr, maxJsonLengthStr, logNStr, logQiJSON, logPiJSON, tStr)
+	if err != nil {
+		return "", err
+	}
+
+	// beginDataset validated both; dataset_spec is rewritten rather than
+	// read back, which this transaction cannot do
+	n, _ := strconv.Atoi(numRecordsStr)
+	maxJSON, _ := strconv.Atoi(maxJsonLengthStr)
+	spec, _ := json.Marshal(datasetSpec{N: n, MaxJSON: maxJSON, Seed: seed})
+	if err := ctx.GetStub().PutState("dataset_spec", spec); err != nil {
+		return "", err
+	}
+
+	dbg("[CC][INIT] Seeded dataset (LogN=%d, seed=%q); run GenerateDataset next", pm.LogN, seed)
+	return utils.MarshalUntimed("success")
+}
+
+// VerifyMDBHash reports the committed m_DB_sha256 next to the hash, under
+// the committed m_DB_digest_alg, of the shards this peer stores and, with
+// regenerate "true", of the m_DB it would generate from dataset_spec
+// (GenerateDataset's path; only meaningful while the dataset is the
+// generated one). expectedHex, if not
+// empty, is checked as well. The report's match is false on any
+// difference. Evaluate-only; writes nothing.
+func (cc *PIRChainCode) VerifyMDBHash(ctx contractapi.TransactionContextInterface, expectedHex, regenerateStr string) (string, error) {
+	start := time.Now()
+
+	committed, rawAlg, err := mdbDigest(ctx)
+	if err != nil {
+		return "", fmt.Errorf("VerifyMDBHash: %w", err)
+	}
+	alg, err := utils.ParseDigestAlg(rawAlg)
+	if err != nil {
+		return "", fmt.Errorf("VerifyMDBHash: m_DB_digest_alg: %w", err)
+	}
+	report := utils.MDBHashReport{Committed: committed, Alg: alg, Expected: expectedHex}
+
+	if committed != "" {
+		shards, err := mdbShards(ctx)
+		if err != nil {
+			return "", fmt.Errorf("VerifyMDBHash: %w", err)
+		}
+		stored := make([][]byte, shards)
+		for k := range stored {
+			raw, err := ctx.GetStub().GetState(mdbKey(k))
+			if err != nil {
+				return "", fmt.Errorf("VerifyMDBHash: failed to read %s from ledger: %w", mdbKey(k), err)
+			}
+			if raw == nil {
+				return "", fmt.Errorf("VerifyMDBHash: %s not found in world state", mdbKey(k))
+			}
+			stored[k] = raw
+		}
+		report.Stored = utils.MDBDigest(alg, stored)
+	}
+
+	if regenerateStr == "true" {
+		spec, err := loadSpec(ctx)
+		if err != nil {
+			return "", fmt.Errorf("VerifyMDBHash: %w", err)
+		}
+		p, err := cc.ensureParams(ctx)
+		if err != nil {
+			return "", fmt.Errorf("VerifyMDBHash: %w", err)
+		}
+		records, err := gen_records.GenerateRecordsSeeded(spec.N, p.LogN(), spec.MaxJSON, spec.Seed)
+		if err != nil {
+			return "", fmt.Errorf("VerifyMDBHash: %w", err)
+		}
+		_, pts, err := packDB(p, spec, records)
+		if err != nil {
+			return "", fmt.Errorf("VerifyMDBHash: %w", err)
+		}
+		regenerated := make([][]byte, len(pts))
+		for k, pt := range pts {
+			if regenerated[k], err = pt.MarshalBinary(); err != nil {
+				return "", fmt.Errorf("VerifyMDBHash: marshal m_DB shard %d: %w", k, err)
+			}
+		}
+		report.Regenerated = utils.MDBDigest(alg, regenerated)
+		report.Seed = spec.Seed
+	}
+
+	report.Check()
+	dbg("[CC][VERIFY] m_DB_sha256 match=%v (%d mismatch(es))", report.Match, len(report.Mismatches))
+	return utils.MarshalTimed(report, start)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/utils"
+)
+
+/**************  FULL DOWNLOAD ****************************************/
+
+// For small datasets the cheapest "PIR" is shipping the whole padded DB:
+// the client learns every record and the server learns nothing about which
+// one it wanted. GetFullDatasetChunk serves the m_DB windows directly, so
+// the download matches a PIR result byte for byte and works for PutMDB
+// datasets that have no records in world state. It is refused above
+// maxFullDownload bytes (PIR_FULL_DOWNLOAD_MAX_BYTES, <=0 disables it);
+// one call returns at most maxFullChunkRecords (PIR_FULL_CHUNK_RECORDS).
+// A dataset with private records (private.go) serves the download to the
+// MSPs that may read them only.
+var (
+	maxFullDownload     = envInt("PIR_FULL_DOWNLOAD_MAX_BYTES", 4*utils.DefaultFullDownloadBytes)
+	maxFullChunkRecords = envInt("PIR_FULL_CHUNK_RECORDS", 256)
+)
+
+// GetFullDatasetChunk returns up to count records starting at offset as a
+// utils.DatasetChunk.
+func (cc *PIRChainCode) GetFullDatasetChunk(ctx contractapi.TransactionContextInterface, offsetStr, countStr string) (string, error) {
+	start := time.Now()
+
+	offset, err := strconv.Atoi(offsetStr)
+	if err != nil {
+		return "", fmt.Errorf("GetFullDatasetChunk: invalid offset %q", offsetStr)
+	}
+	count, err := strconv.Atoi(countStr)
+	if err != nil || count <= 0 {
+		return "", fmt.Errorf("GetFullDatasetChunk: invalid count %q", countStr)
+	}
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		return "", fmt.Errorf("GetFullDatasetChunk: %w", err)
+	}
+	spec, err := loadSpec(ctx)
+	if err != nil {
+		return "", fmt.Errorf("GetFullDatasetChunk: %w", err)
+	}
+	if spec.Collection != "" {
+		if err := checkRecordReader(ctx, spec); err != nil {
+			return "", fmt.Errorf("GetFullDatasetChunk: %w", err)
+		}
+	}
+	if size := meta.NRecords * meta.RecordS; maxFullDownload <= 0 || size > maxFullDownload {
+		return "", fmt.Errorf("GetFullDatasetChunk: dataset is %d bytes, full download limit is %d - use PIRQuery",
+			size, maxFullDownload)
+	}
+	if offset < 0 || offset >= meta.NRecords {
+		return "", fmt.Errorf("GetFullDatasetChunk: offset %d out of range 0..%d", offset, meta.NRecords-1)
+	}
+	count = min(count, maxFullChunkRecords, meta.NRecords-offset)
+
+	params, pts, err := cc.ensureDB(ctx)
+	if err != nil {
+		return "", fmt.Errorf("GetFullDatasetChunk: %w", err)
+	}
+	shards, err := decodeShards(params, pts)
+	if err != nil {
+		return "", fmt.Errorf("GetFullDatasetChunk: %w", err)
+	}
+	ic := utils.IndexContract{NRecords: meta.NRecords, RecordS: meta.RecordS, Slots: params.MaxSlots(), Packing: meta.Packing}
+	records, err := ic.UnpackShards(shards, offset, count)
+	if err != nil {
+		return "", fmt.Errorf("GetFullDatasetChunk: %w", err)
+	}
+
+	version, err := dbVersion(ctx)
+	if err != nil {
+		return "", fmt.Errorf("GetFullDatasetChunk: %w", err)
+	}
+
+	dbg("[CC][FULL] records [%d:%d) of %d (version %d)", offset, offset+count, meta.NRecords, version)
+	return utils.MarshalTimed(utils.DatasetChunk{
+		Version: version, Offset: offset, NRecords: meta.NRecords, RecordS: meta.RecordS, Records: records,
+	}, start)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/he"
+	"pir_shared/utils"
+)
+
+/**************  QUERY GUARD ******************************************/
+
+// Evaluate calls are not ordered or endorsed by anyone else, so they are the
+// cheapest way to burn an endorser's CPU. PIRQuery checks every ct_q against
+// these limits before unmarshalling or evaluating it. Both can be set
+// through the chaincode container's environment.
+var (
+	// maxQueryB64 caps the Base64 length of ct_q (PIR_MAX_QUERY_B64, bytes).
+	// The default admits a logN=16 query (~1.4 MB Base64); the exact size
+	// check below is the tighter bound for the committed params.
+	maxQueryB64 = envInt("PIR_MAX_QUERY_B64", 2*1024*1024)
+	// maxInflight caps concurrent PIR evaluations per client identity
+	// (PIR_MAX_INFLIGHT_PER_CLIENT, <=0 disables the cap).
+	maxInflight = envInt("PIR_MAX_INFLIGHT_PER_CLIENT", 2)
+)
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+// checkQuerySize rejects ct_q whose Base64 length exceeds maxQueryB64 or
+// does not match a degree-1 ciphertext at the max level of params.
+func checkQuerySize(params he.Params, encQueryB64 string) error {
+	if len(encQueryB64) > maxQueryB64 {
+		return fmt.Errorf("query too large: %d Base64 bytes > limit %d", len(encQueryB64), maxQueryB64)
+	}
+	want := base64.StdEncoding.EncodedLen(expectedQueryBytes(params))
+	if len(encQueryB64) != want {
+		// a ct_q for other params says which one differs
+		if raw, err := base64.StdEncoding.DecodeString(encQueryB64); err == nil {
+			if err := utils.CheckQueryShape(raw, utils.QueryShape(params.LogN(), params.MaxLevel())); err != nil {
+				return err
+			}
+		}
+		return fmt.Errorf("query size mismatch: %d Base64 bytes, want %d for LogN=%d level=%d",
+			len(encQueryB64), want, params.LogN(), params.MaxLevel())
+	}
+	return nil
+}
+
+var (
+	ctSizeMu sync.Mutex
+	ctSizes  = map[[2]int]int{}
+)
+
+// expectedQueryBytes is the marshalled size of the ct_q a client builds
+// with cpir.EncryptQueryBase64, cached per (LogN, level).
+func expectedQueryBytes(params he.Params) int {
+	k := [2]int{params.LogN(), params.MaxLevel()}
+	ctSizeMu.Lock()
+	defer ctSizeMu.Unlock()
+	if n, ok := ctSizes[k]; ok {
+		return n
+	}
+	n := he.Default.CiphertextBytes(params)
+	ctSizes[k] = n
+	return n
+}
+
+// ValidateQueryCiphertext reports whether encQueryB64 is a ct_q for the
+// committed params: the degree, level and ring dimension it declares next
+// to the ones PIRQuery expects (utils.QueryValidation). A mismatch is a
+// report, not a failed call; PIRQuery rejects the same ciphertext with
+// the report's error. The Base64 length is capped like PIRQuery's, and
+// nothing is decoded or evaluated. Evaluate-only.
+func (cc *PIRChainCode) ValidateQueryCiphertext(ctx contractapi.TransactionContextInterface, encQueryB64 string) (string, error) {
+	start := time.Now()
+
+	params, err := cc.ensureParams(ctx)
+	if err != nil {
+		return "", fmt.Errorf("ValidateQueryCiphertext: %w", err)
+	}
+	if len(encQueryB64) > maxQueryB64 {
+		return "", fmt.Errorf("ValidateQueryCiphertext: query too large: %d Base64 bytes > limit %d", len(encQueryB64), maxQueryB64)
+	}
+	raw, err := base64.StdEncoding.DecodeString(encQueryB64)
+	if err != nil {
+		return "", fmt.Errorf("ValidateQueryCiphertext: failed to decode base64 query: %w", err)
+	}
+	report := utils.ValidateQuery(raw, utils.QueryShape(params.LogN(), params.MaxLevel()))
+	dbg("[CC][GUARD] ValidateQueryCiphertext: valid=%v (%d bytes)", report.Valid, len(raw))
+	return utils.MarshalTimed(report, start)
+}
+
+/**************  MEMORY GUARD *****************************************/
+
+// memBudgetMB caps the estimated heap of a dataset init plus the first
+// query against it (PIR_MEM_BUDGET_MB, MiB, <=0 disables): set it to the
+// chaincode container's memory limit minus headroom, so an init that
+// would be OOM-killed half-way is refused before anything is allocated.
+var memBudgetMB = envInt("PIR_MEM_BUDGET_MB", 1024)
+
+// checkMemBudget estimates an init of n records of recordS slots under
+// hint (utils.EstimateInitMemory) against memBudgetMB.
+func checkMemBudget(n, recordS int, hint utils.BGVParamHint) error {
+	h, err := hint.Resolved()
+	if err != nil {
+		return err
+	}
+	est := utils.EstimateInitMemory(n, recordS, h.LogN, len(h.LogQi))
+	dbg("[CC][MEM] init estimate %d MiB (budget %d MiB, %d shard(s))", est.Total>>20, memBudgetMB, est.Shards)
+	return est.CheckBudget(int64(memBudgetMB) << 20)
+}
+
+// inflight counts running PIR evaluations per client identity.
+var inflight = struct {
+	sync.Mutex
+	n map[string]int
+}{n: map[string]int{}}
+
+// usageTotals is the per-identity PIR cost seen by this peer (GetUsageStats).
+var usageTotals utils.UsageTotals
+
+// perfStats is this peer's evaluation latency per parameter set (GetPerfStats).
+var perfStats utils.PerfStats
+
+// clientID is the caller's X.509 identity as reported by the shim.
+func clientID(ctx contractapi.TransactionContextInterface) string {
+	id, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return "unknown"
+	}
+	return id
+}
+
+// acquireEval reserves an evaluation slot for identity id; the returned
+// func releases it.
+func acquireEval(id string) (func(), error) {
+	if maxInflight <= 0 {
+		return func() {}, nil
+	}
+
+	inflight.Lock()
+	defer inflight.Unlock()
+	if inflight.n[id] >= maxInflight {
+		return nil, fmt.Errorf("too many concurrent PIR queries for this identity (limit %d)", maxInflight)
+	}
+	inflight.n[id]++
+	return func() {
+		inflight.Lock()
+		defer inflight.Unlock()
+		if inflight.n[id]--; inflight.n[id] <= 0 {
+			delete(inflight.n, id)
+		}
+	}, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/utils"
+)
+
+/**************  KEY HISTORY *******************************************/
+
+// Keys like m_DB are rewritten on every init and hold megabytes each time,
+// so a key's history is read a page at a time: at most limit entries and
+// historyMaxBytes of output, values longer than maxValue replaced by their
+// SHA-256. Entries are never held beyond the page being built. Internal
+// keys (internalKey: m_DB, its upload chunks, audit entries, staged
+// records, config) list their entries with every value withheld. The resume
+// token is the tx id of the last entry returned; the history is newest
+// first and only grows at the front, so a token stays valid while new
+// writes arrive. Diagnostic only: a peer that joined from a snapshot has
+// no history before it.
+
+var (
+	historyLimit    = envInt("PIR_HISTORY_LIMIT", 100)       // entries per page by default
+	historyMaxValue = envInt("PIR_HISTORY_MAX_VALUE", 4096)  // bytes of value shown by default
+	historyMaxBytes = envInt("PIR_HISTORY_MAX_BYTES", 4<<20) // output per page, any arguments
+)
+
+// GetHistoryForKey returns the first page of key's modification history
+// (useful when reInit) with the default limits, as a utils.HistoryPage.
+func (cc *PIRChainCode) GetHistoryForKey(ctx contractapi.TransactionContextInterface, key string) (string, error) {
+	return historyPage(ctx, key, historyLimit, "", historyMaxValue, false)
+}
+
+// GetHistoryPage returns the page of key's history after resume ("" for
+// the newest). limitStr and maxValueStr ("" for the defaults; maxValue 0
+// leaves every value out) bound the entries and the bytes of each value.
+// format "ndjson" writes one HistoryEntry per line and the page, without
+// entries, as the last line, so a client can process entries as they
+// are read; "" or "json" returns the HistoryPage as one document.
+func (cc *PIRChainCode) GetHistoryPage(ctx contractapi.TransactionContextInterface,
+	key, limitStr, resume, maxValueStr, format string) (string, error) {
+	limit, maxValue := historyLimit, historyMaxValue
+	var err error
+	if limitStr != "" {
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit <= 0 {
+			return "", fmt.Errorf("GetHistoryPage: limit must be a positive integer, got %q", limitStr)
+		}
+	}
+	if maxValueStr != "" {
+		if maxValue, err = strconv.Atoi(maxValueStr); err != nil || maxValue < 0 {
+			return "", fmt.Errorf("GetHistoryPage: max value must be a non-negative integer, got %q", maxValueStr)
+		}
+	}
+	switch format {
+	case "", "json":
+		return historyPage(ctx, key, limit, resume, maxValue, false)
+	case "ndjson":
+		return historyPage(ctx, key, limit, resume, maxValue, true)
+	}
+	return "", fmt.Errorf("GetHistoryPage: unknown format %q (json, ndjson)", format)
+}
+
+// historyPage reads one page of key's history; see GetHistoryPage.
+func historyPage(ctx contractapi.TransactionContextInterface, key string, limit int, resume string,
+	maxValue int, ndjson bool) (string, error) {
+	if internalKey(key) {
+		maxValue = 0
+	}
+	historyIter, err := ctx.GetStub().GetHistoryForKey(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to get history for key %s: %v", key, err)
+	}
+	defer historyIter.Close()
+
+	page := utils.HistoryPage{Key: key, Entries: []utils.HistoryEntry{}}
+	var out bytes.Buffer
+	size, skipping := 0, resume != ""
+	for historyIter.HasNext() {
+		mod, err := historyIter.Next()
+		if err != nil {
+			return "", fmt.Errorf("error iterating history: %v", err)
+		}
+		if skipping {
+			skipping = mod.TxId != resume
+			continue
+		}
+
+		entry := utils.NewHistoryEntry(mod.TxId, mod.IsDelete, mod.Timestamp.AsTime(), mod.Value, maxValue)
+		line, _ := json.Marshal(entry)
+		if len(page.Entries) == limit || (size > 0 && size+len(line) > historyMaxBytes) {
+			page.More = true
+			break
+		}
+		size += len(line) + 1
+		page.Next = entry.TxID
+		if ndjson {
+			out.Write(line)
+			out.WriteByte('\n')
+			entry = utils.HistoryEntry{} // only counted
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	if skipping {
+		return "", fmt.Errorf("resume token %q is not in the history of %s", resume, key)
+	}
+	if !page.More {
+		page.Next = ""
+	}
+	if ndjson {
+		page.Entries = nil
+		trailer, _ := json.Marshal(page)
+		out.Write(trailer)
+		return out.String(), nil
+	}
+	raw, err := json.Marshal(page)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling history: %v", err)
+	}
+	return string(raw), nil
+}
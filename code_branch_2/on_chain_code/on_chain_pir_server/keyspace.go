@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	"pir_shared/utils"
+)
+
+/**************  KEY NAMESPACE POLICY *********************************/
+
+// World state holds the public dataset next to internal state: m_DB and
+// its upload chunks (megabytes each), audit records and inline ct_q
+// payloads, staged records awaiting approval, and the runtime config.
+// The convenience read APIs must not hand the latter out, so every key
+// falls in a storage family (storage.go) and a family is public or
+// internal; keys of no family ("other") are internal, so a key added
+// later is protected until someone decides otherwise.
+//
+//	PublicQuery       record keys of the dataset only (publicKey)
+//	GetHistoryForKey  entries of internal keys without their values
+//	GetStorageReport  counts and sizes per family, never keys or values
+//
+// Internal state stays readable through its own functions (GetAuditRecord,
+// GetAuditPayload, GetFullDatasetChunk, ...), which apply their own checks.
+
+// internalKey reports whether key is internal under the policy.
+func internalKey(key string) bool {
+	return familyInternal(storageFamily(key))
+}
+
+// familyInternal reports whether the storage family is internal.
+func familyInternal(family string) bool {
+	for _, f := range storageFamilies {
+		if f.family == family {
+			return f.internal
+		}
+	}
+	return true // "other"
+}
+
+// publicKey checks that key may be read by PublicQuery: a canonical record
+// key of ic, outside every internal family.
+func publicKey(ic utils.IndexContract, key string) (int, error) {
+	idx, err := ic.Index(key)
+	if err != nil {
+		return 0, err
+	}
+	if internalKey(key) {
+		return 0, fmt.Errorf("%w %q: internal key", utils.ErrInvalidKey, key)
+	}
+	return idx, nil
+}
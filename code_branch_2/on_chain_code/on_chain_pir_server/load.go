@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/utils"
+)
+
+/**************  LOAD RECORDS *****************************************/
+
+// LoadRecordsFromJSON is InitLedger + GenerateDataset for user-provided
+// records: one transaction takes a JSON array of record objects, sanitizes
+// each like InitAddRecords (records longer than maxJsonLength are
+// rejected), stores the accepted ones under RecordKey(i) and packs them
+// into m_DB. Rejected records shrink the dataset, as with InitCommit. The
+// whole array travels in one proposal, so datasets that exceed the
+// endorsement limits go through InitBegin / InitAddRecords / InitCommit.
+
+// LoadRecordsFromJSON replaces the dataset with the records of recordsJSON
+// under params built from the remaining InitLedger arguments, and returns
+// a utils.InitStatus with the rejected records.
+func (cc *PIRChainCode) LoadRecordsFromJSON(ctx contractapi.TransactionContextInterface,
+	recordsJSON, maxJsonLengthStr, logNStr, logQiJSON, logPiJSON, tStr string) (string, error) {
+
+	dbg("\n/**************  LOAD RECORDS START ***************************************/")
+	start := time.Now()
+
+	if err := checkInitWrite(ctx, "LoadRecordsFromJSON"); err != nil {
+		return "", err
+	}
+
+	var recs []json.RawMessage
+	if err := json.Unmarshal([]byte(recordsJSON), &recs); err != nil {
+		return "", fmt.Errorf("LoadRecordsFromJSON: records must be a JSON array: %w", err)
+	}
+	if len(recs) == 0 {
+		return "", fmt.Errorf("LoadRecordsFromJSON: no records")
+	}
+	maxJSON, err := strconv.Atoi(maxJsonLengthStr)
+	if err != nil || maxJSON <= 0 {
+		return "", fmt.Errorf("LoadRecordsFromJSON: maxJsonLength must be a positive integer")
+	}
+
+	raw := make([][]byte, len(recs))
+	for i, rec := range recs {
+		raw[i] = rec
+	}
+	opt := sanitizeOpts
+	opt.MaxRecordLen = maxJSON
+	clean, rejections := utils.SanitizeRecords(raw, opt)
+	if len(clean) == 0 {
+		return "", fmt.Errorf("LoadRecordsFromJSON: all %d records rejected (first: record %d: %s)",
+			len(recs), rejections[0].Index, rejections[0].Reason)
+	}
+
+	// Params are planned for the accepted records only.
+	_, p, err := cc.beginDataset(ctx, "LoadRecordsFromJSON",
+		strconv.Itoa(len(clean)), maxJsonLengthStr, logNStr, logQiJSON, logPiJSON, tStr)
+	if err != nil {
+		return "", err
+	}
+	// GetState does not see beginDataset's dataset_spec; this is what it wrote.
+	spec := datasetSpec{N: len(clean), MaxJSON: maxJSON}
+	if err := cc.storeAndPack(ctx, p, spec, clean); err != nil {
+		return "", fmt.Errorf("LoadRecordsFromJSON: %w", err)
+	}
+
+	dbg("[CC][LOAD] Loaded %d of %d records (%d rejected) in %.3f ms (LogN=%d)",
+		len(clean), len(recs), len(rejections), msOf(time.Since(start)), p.LogN())
+	dbg("/**************  LOAD RECORDS END *****************************************/")
+	return utils.MarshalUntimed(utils.InitStatus{
+		LogN: p.LogN(), NRecords: len(recs), Staged: len(clean), Rejected: len(rejections), Rejections: rejections,
+	})
+}
@@ -6,226 +6,615 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
-	"on_chain_pir_server/internal/gen_records"
+	"errors"
 	"on_chain_pir_server/internal/precomputed" // <— add this
-	"on_chain_pir_server/internal/utils"
+	"pir_shared/gen_records"
+	"pir_shared/he"
+	"pir_shared/utils"
+	"sync"
 	"time"
 
 	"fmt"
 	"strconv"
 
+	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
-	"github.com/tuneinsight/lattigo/v6/core/rlwe"
-	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
 )
 
-/**************  GLOBAL DEBUG SWITCH  *********************************/
-var Debug = true
-
-func dbg(format string, a ...interface{}) {
-	if Debug {
-		fmt.Printf(format+"\n", a...)
-	}
-}
+// planOpts bounds automatic parameter selection. A dataset larger than one
+// plaintext is split into up to MaxShards m_DB shards (see mdbKey).
+var planOpts = utils.PlanOptions{MaxShards: 8, AllowLogN16: true}
 
 /**************  CHAINCODE STRUCT **************************************/
 type PIRChainCode struct {
 	contractapi.Contract
 
-	// Cryptographic context
-	Params bgv.Parameters  // in-memory BGV params
-	m_DB   *rlwe.Plaintext // in-memory plaintext poly
+	// n, record_s and the "record%03d" keys are read from world state on
+	// every call (loadMetadata, isInitialized): endorsers that did not run
+	// InitLedger, or restarted since, must answer the same way. Only
+	// GetHistoryForKey reads the history DB; it is diagnostic, and every
+	// PIR function works on a peer that joined from a ledger snapshot.
 
-	// Metadata (mirror world state keys)
-	NRecords    int // world state: "n"
-	SlotsPerRec int // world state: "record_s"
+	// In-memory params and m_DB, one set per dataset (see dataset.go).
+	cachesMu sync.Mutex
+	caches   map[string]*dsCache
+}
 
-	// Optional cache of JSON records (not required for PIR path)
-	Records [][]byte // world state: "record%03d" keys
+// dsCache is the cryptographic context of one dataset.
+type dsCache struct {
+	Params he.Params      // in-memory BGV params
+	m_DB   []he.Plaintext // in-memory plaintext polys, one per shard
+
+	// Lazy reload after a peer restart (see ensureParams); guards Params,
+	// m_DB and the committed "bgv_params" / "n"+"record_s"+"m_DB_sha256"
+	// they mirror. Read Params and m_DB only through ensureParams and
+	// ensureDB: in a restarted container they are zero until one of them
+	// runs, and only the init paths assign them directly.
+	mu        sync.Mutex
+	paramsRaw []byte
+	dbKey     string
+
+	// Last PIRQueryDelta plaintext, keyed by dbKey and the base version.
+	delta    he.Plaintext
+	deltaKey string
+
+	// PIR queries per day and public reads per key (GetUsageStats).
+	access utils.AccessStats
+}
 
-	initialized bool
+// cache returns the in-memory context of the transaction's dataset.
+func (cc *PIRChainCode) cache(ctx contractapi.TransactionContextInterface) *dsCache {
+	name := datasetOf(ctx)
+	cc.cachesMu.Lock()
+	defer cc.cachesMu.Unlock()
+	c := cc.caches[name]
+	if c == nil {
+		if cc.caches == nil {
+			cc.caches = map[string]*dsCache{}
+		}
+		c = new(dsCache)
+		cc.caches[name] = c
+	}
+	return c
+}
+
+// bgvParamsMeta is the JSON stored under the "bgv_params" key.
+type bgvParamsMeta struct {
+	LogN  int    `json:"logN"`
+	N     int    `json:"N"`
+	LogQi []int  `json:"logQi"`
+	LogPi []int  `json:"logPi"`
+	T     uint64 `json:"t"`
+	// Scheme is the HE scheme the params are built for (SetScheme);
+	// absent from datasets initialised before it, which are BGV.
+	Scheme string `json:"scheme,omitempty"`
+	// Packing is the record packing mode (SetPacking); absent from
+	// datasets packed one byte per slot.
+	Packing string `json:"packing,omitempty"`
 }
 
 /**************  INIT LEDGER *******************************************/
+
+// datasetSpec is stored under "dataset_spec" by InitLedger and consumed by
+// GenerateDataset.
+type datasetSpec struct {
+	N       int `json:"n"`
+	MaxJSON int `json:"max_json"`
+	// Pseudonymize lists the record fields replaced by keyed pseudonyms at
+	// ingestion (SetPseudonymFields); empty leaves records as submitted.
+	Pseudonymize []string `json:"pseudonymize,omitempty"`
+	// Reserve is the number of zero windows packed after the records
+	// (ReserveIndices) for later appends.
+	Reserve int `json:"reserve,omitempty"`
+	// Packing is the record packing mode storeAndPack packs with
+	// (SetPacking, which also records it in bgv_params); empty is "1b".
+	Packing string `json:"packing,omitempty"`
+	// Seed keys GenerateDataset's synthetic records (InitLedgerSeeded);
+	// a seeded dataset's init steps answer without timings.
+	Seed string `json:"seed,omitempty"`
+	// Collection is the private data collection holding the records
+	// (SetRecordCollection, private.go), read back by CollectionMSPs
+	// only; empty keeps them in world state.
+	Collection     string   `json:"collection,omitempty"`
+	CollectionMSPs []string `json:"collection_msps,omitempty"`
+}
+
+// InitLedger only establishes the BGV params and the dataset spec, and
+// clears any previous m_DB / n / record_s. It is cheap enough to run as the
+// lifecycle --init-required transaction; GenerateDataset does the heavy
+// generate → pack → encode step in a separate transaction.
 func (cc *PIRChainCode) InitLedger(ctx contractapi.TransactionContextInterface,
-	numRecordsStr, maxJsonLengthStr string) (string, error) {
+	numRecordsStr, maxJsonLengthStr, logNStr, logQiJSON, logPiJSON, tStr string) (string, error) {
 
 	dbg("\n/**************  INIT LEDGER START ****************************************/")
 	start := time.Now()
 
+	if err := checkInitWrite(ctx, "InitLedger"); err != nil {
+		return "", err
+	}
+
+	pm, _, err := cc.beginDataset(ctx, "InitLedger", numRecordsStr, maxJsonLengthStr, logNStr, logQiJSON, logPiJSON, tStr)
+	if err != nil {
+		return "", err
+	}
+
+	dbg("[CC][INIT] Completed in %.3f ms (LogN=%d); run GenerateDataset next",
+		float64(time.Since(start).Nanoseconds())/1e6, pm.LogN)
+	dbg("/**************  INIT LEDGER END ******************************************/")
+
+	// Return execution time as JSON
+	return utils.MarshalUntimed("success")
+}
+
+// beginDataset parses the InitLedger / InitBegin arguments, builds the
+// params, stores "bgv_params" + "dataset_spec" + "code_provenance" and
+// drops the previous m_DB / n / record_s and any staged chunks. fn
+// prefixes error messages. The params are returned as well because
+// ensureParams cannot read this transaction's "bgv_params" back.
+func (cc *PIRChainCode) beginDataset(ctx contractapi.TransactionContextInterface, fn string,
+	numRecordsStr, maxJsonLengthStr, logNStr, logQiJSON, logPiJSON, tStr string) (bgvParamsMeta, he.Params, error) {
+
 	n, err1 := strconv.Atoi(numRecordsStr)
 	maxJSON, err2 := strconv.Atoi(maxJsonLengthStr)
 	if err1 != nil || err2 != nil || n <= 0 || maxJSON <= 0 {
-		return "", fmt.Errorf("InitLedger: numRecords and maxJsonLength must be positive integers")
+		return bgvParamsMeta{}, nil, fmt.Errorf("%s: numRecords and maxJsonLength must be positive integers", fn)
 	}
 
-	// ---- Optional params: logN, logQi, logPi, t ----
+	// ---- Optional params: logN, logQi, logPi, t (empty → default) ----
 	var logN int
 	var logQi, logPi []int
 	var t uint64 = 65537
+	if logNStr != "" {
+		if v, err := strconv.Atoi(logNStr); err == nil {
+			logN = v
+		}
+	}
+	if logQiJSON != "" {
+		if err := json.Unmarshal([]byte(logQiJSON), &logQi); err != nil {
+			return bgvParamsMeta{}, nil, fmt.Errorf("%s: invalid logQi JSON: %w", fn, err)
+		}
+	}
+	if logPiJSON != "" {
+		if err := json.Unmarshal([]byte(logPiJSON), &logPi); err != nil {
+			return bgvParamsMeta{}, nil, fmt.Errorf("%s: invalid logPi JSON: %w", fn, err)
+		}
+	}
+	if tStr != "" {
+		if v, err := strconv.ParseUint(tStr, 10, 64); err == nil && v > 0 {
+			t = v
+		}
+	}
 
 	// ---- Fallback: auto-select logN if missing ----
-	sGuess := ((maxJSON + 7) / 8) * 8
+	sGuess := utils.RoundRecordS(maxJSON)
 	if logN <= 0 {
-		chosen, err := utils.ChooseLogN(n, sGuess)
+		plan, err := utils.PlanLogN(n, sGuess, planOpts)
 		if err != nil {
-			return "", fmt.Errorf("InitLedger: auto-select logN failed: %w", err)
+			return bgvParamsMeta{}, nil, fmt.Errorf("%s: auto-select logN failed: %w", fn, err)
 		}
-		logN = chosen
-		dbg("[INFO] Auto-selected LogN=%d using n=%d, s_guess=%d", logN, n, sGuess)
+		logN = plan.LogN
+		dbg("[INFO] Auto-selected LogN=%d (shards=%d) using n=%d, s_guess=%d", logN, plan.Shards, n, sGuess)
 	}
 
 	// ---- 1) Build params from hint ----
 	hint := utils.BGVParamHint{LogN: logN, LogQi: logQi, LogPi: logPi, T: t}
-	p, err := utils.BuildParamsFromHint(hint)
+	if err := checkMemBudget(n, sGuess, hint); err != nil {
+		return bgvParamsMeta{}, nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	p, err := he.Default.NewParams(hint)
 	if err != nil {
-		return "", fmt.Errorf("InitLedger: failed to set params: %w", err)
+		return bgvParamsMeta{}, nil, fmt.Errorf("%s: failed to set params: %w", fn, err)
 	}
-	cc.Params = p
-	dbg("[INFO] Params: LogN=%d N=%d |Q|=%d |P|=%d T=%d",
-		p.LogN(), p.N(), len(p.Q()), len(p.P()), p.PlaintextModulus())
+	dbg("[INFO] Params: LogN=%d N=%d |Q|=%d |P|=%d T=%d (%s, %s)",
+		p.LogN(), p.N(), len(p.LogQi()), len(p.LogPi()), p.PlaintextModulus(), he.Default.Name(), he.SchemeOf(p))
+
+	// ---- 2) Persist params + spec, drop the previous dataset ----
+	paramsMeta := bgvParamsMeta{
+		LogN:  p.LogN(),
+		N:     p.N(),
+		LogQi: p.LogQi(),
+		LogPi: p.LogPi(),
+		T:     p.PlaintextModulus(),
 
-	// ---- 2) Generate synthetic records ----
-	dbg("[CC][INIT] Generating synthetic records...")
-	records, err := gen_records.GenerateRecords(n, logN, maxJSON)
+		Scheme: he.SchemeOf(p),
+	}
+	pm, _ := json.Marshal(paramsMeta)
+	if err := ctx.GetStub().PutState("bgv_params", pm); err != nil {
+		return bgvParamsMeta{}, nil, err
+	}
+	spec, _ := json.Marshal(datasetSpec{N: n, MaxJSON: maxJSON})
+	if err := ctx.GetStub().PutState("dataset_spec", spec); err != nil {
+		return bgvParamsMeta{}, nil, err
+	}
+	if err := putCodeProvenance(ctx); err != nil {
+		return bgvParamsMeta{}, nil, err
+	}
+	for _, key := range []string{"m_DB_sha256", "m_DB_digest_alg", "n", "record_s", "reserved_from", "record_hashes", "records_root"} {
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return bgvParamsMeta{}, nil, err
+		}
+	}
+	if err := delShards(ctx, 0); err != nil {
+		return bgvParamsMeta{}, nil, err
+	}
+	if err := clearStage(ctx); err != nil {
+		return bgvParamsMeta{}, nil, err
+	}
+	c := cc.cache(ctx)
+	c.mu.Lock()
+	c.Params, c.m_DB, c.paramsRaw, c.dbKey = p, nil, pm, ""
+	c.mu.Unlock()
+
+	return paramsMeta, p, nil
+}
+
+/**************  GENERATE DATASET *************************************/
+
+// GenerateDataset generates synthetic records for the committed
+// dataset_spec under the committed params, stores them, and packs/encodes
+// m_DB. It is the fallback for benchmarks and demos; real data comes in
+// through LoadRecordsFromJSON or InitBegin / InitAddRecords / InitCommit.
+func (cc *PIRChainCode) GenerateDataset(ctx contractapi.TransactionContextInterface) (string, error) {
+	dbg("\n/**************  GENERATE DATASET START ***********************************/")
+	start := time.Now()
+
+	if err := checkInitWrite(ctx, "GenerateDataset"); err != nil {
+		return "", err
+	}
+
+	p, err := cc.ensureParams(ctx)
+	if err != nil {
+		return "", fmt.Errorf("GenerateDataset: %w", err)
+	}
+	spec, err := loadSpec(ctx)
+	if err != nil {
+		return "", fmt.Errorf("GenerateDataset: %w", err)
+	}
+	n, logN, maxJSON := spec.N, p.LogN(), spec.MaxJSON
+
+	// ---- Generate synthetic records ----
+	dbg("[CC][GEN] Generating synthetic records...")
+	records, err := gen_records.GenerateRecordsSeeded(n, logN, maxJSON, spec.Seed)
 	if err != nil {
 		return "", err
 	}
-	cc.Records = records
-	cc.NRecords = len(records)
+	if err := utils.CheckGenerated(n, records); err != nil {
+		return "", fmt.Errorf("GenerateDataset: %w", err)
+	}
+	if err := cc.storeAndPack(ctx, p, spec, records); err != nil {
+		return "", fmt.Errorf("GenerateDataset: %w", err)
+	}
 
-	// ---- 3) Store JSON records ----
-	dbg("[CC][INIT] Storing JSON records to world state...")
-	for i, rec := range cc.Records {
-		if err := ctx.GetStub().PutState(fmt.Sprintf("record%03d", i), rec); err != nil {
-			return "", err
+	elapsed := time.Since(start)
+	executionTime := float64(elapsed.Nanoseconds()) / 1e6
+	dbg("[CC][GEN] Completed in %.3f ms (LogN=%d, slots=%d)",
+		executionTime, p.LogN(), p.MaxSlots())
+	dbg("/**************  GENERATE DATASET END *************************************/")
+
+	// Return execution time as JSON
+	return utils.MarshalUntimed("success")
+}
+
+// loadSpec reads the dataset_spec written by InitLedger / InitBegin.
+func loadSpec(ctx contractapi.TransactionContextInterface) (datasetSpec, error) {
+	var spec datasetSpec
+	specRaw, err := ctx.GetStub().GetState("dataset_spec")
+	if err != nil || specRaw == nil {
+		return spec, fmt.Errorf("missing dataset_spec in world state - call InitLedger first")
+	}
+	if err := json.Unmarshal(specRaw, &spec); err != nil {
+		return spec, fmt.Errorf("failed to parse dataset_spec: %w", err)
+	}
+	return spec, nil
+}
+
+// storeAndPack stores records under RecordKey(i), packs and encodes them
+// into the m_DB shards under p, followed by spec's reserved zero windows,
+// and persists m_DB, n, record_s and reserved_from. GenerateDataset,
+// InitCommit and LoadRecordsFromJSON all end here, so all produce the
+// same layout.
+func (cc *PIRChainCode) storeAndPack(ctx contractapi.TransactionContextInterface, p he.Params,
+	spec datasetSpec, records [][]byte) error {
+	nRecords := len(records) + spec.Reserve
+
+	// ---- 1) Store JSON records and their commitment ----
+	dbg("[CC][PACK] Storing JSON records to world state...")
+	hashes := make([]string, nRecords)
+	for i, rec := range records {
+		if err := putRecord(ctx, spec.Collection, utils.RecordKey(i), rec); err != nil {
+			return err
 		}
+		hashes[i] = utils.RecordHash(rec)
+	}
+	if _, err := putCommitment(ctx, hashes); err != nil {
+		return err
 	}
 
-	// ---- 4) Compute slots per record ----
-	cc.SlotsPerRec = utils.CalcSlotsPerRec(cc.Records)
+	ic, pts, err := packDB(p, spec, records)
+	if err != nil {
+		return err
+	}
 
-	// ---- 5) Capacity check ----
-	required := cc.NRecords * cc.SlotsPerRec
-	if required > cc.Params.MaxSlots() {
-		return "", fmt.Errorf("capacity exceeded: required=%d > N=%d", required, cc.Params.MaxSlots())
+	// ---- 5) Persist to world state ----
+	dbg("[CC][PACK] Persisting to world state...")
+	if err := cc.persistDB(ctx, pts, ic.NRecords, ic.RecordS, ic.ReservedFrom, nil); err != nil {
+		return err
 	}
 
-	// ---- 6) Pack → encode into m_DB ----
-	dbg("[CC][INIT] Packing and encoding database...")
-	packed := make([]uint64, cc.Params.MaxSlots())
-	for recIdx, recBytes := range cc.Records {
-		start := recIdx * cc.SlotsPerRec
-		end := start + cc.SlotsPerRec
-		if end > len(packed) {
-			break
-		}
-		for j := 0; j < len(recBytes) && j < cc.SlotsPerRec; j++ {
-			packed[start+j] = uint64(recBytes[j])
-		}
-		if recIdx < 3 || recIdx >= len(cc.Records)-3 {
-			dbg("[DBG] Packed record[%d]: slots [%d:%d) → first 16 values: %v",
-				recIdx, start, end, packed[start:start+16])
+	// ---- Debug parity log ----
+	dbg("[CC][PACK][META] n=%d record_s=%d logN=%d N=%d T=%d logQi=%v logPi=%v",
+		ic.NRecords, ic.RecordS, p.LogN(), p.N(), p.PlaintextModulus(), p.LogQi(), p.LogPi())
+	return nil
+}
+
+// packDB packs records and spec's reserved windows into m_DB shards and
+// encodes them under p, without touching world state. Every step runs in
+// index and shard order, so equal inputs give byte-identical shards on
+// every endorser (VerifyMDBHash regenerates through it).
+func packDB(p he.Params, spec datasetSpec, records [][]byte) (utils.IndexContract, []he.Plaintext, error) {
+	nRecords := len(records) + spec.Reserve
+	reservedFrom := utils.ReservedFromLive(len(records), nRecords)
+
+	// ---- 2) Compute slots per record ----
+	slotsPerRec := utils.CalcSlotsPerRecPacked(records, spec.Packing)
+
+	// ---- 3) Capacity check ----
+	ic := utils.IndexContract{NRecords: nRecords, RecordS: slotsPerRec, Slots: p.MaxSlots(), ReservedFrom: reservedFrom, Packing: spec.Packing}
+	if err := utils.CheckCapacity(nRecords, slotsPerRec, utils.LogSlots(p.MaxSlots()), planOpts.MaxShards); err != nil {
+		return ic, nil, err
+	}
+	if err := ic.ValidateSharded(); err != nil {
+		return ic, nil, err
+	}
+
+	// ---- 4) Pack → encode into the m_DB shards (slot window i ↔
+	// "record%03d" i, reserved windows stay zero) ----
+	dbg("[CC][PACK] Packing and encoding database (%d shard(s))...", ic.Shards())
+	packed, err := ic.PackShards(records)
+	if err != nil {
+		return ic, nil, err
+	}
+	for recIdx := range records {
+		if recIdx < 3 || recIdx >= len(records)-3 {
+			w, _ := ic.Describe(recIdx)
+			dbg("[DBG] Packed record[%d]: shard %d slots [%d:%d) → first 16 values: %v",
+				recIdx, w.Shard, w.StartSlot, w.EndSlot, packed[w.Shard][w.StartSlot:w.StartSlot+16])
 		}
 	}
 
-	enc := bgv.NewEncoder(cc.Params)
-	pt := bgv.NewPlaintext(cc.Params, cc.Params.MaxLevel())
-	if err := enc.Encode(packed, pt); err != nil {
-		return "", fmt.Errorf("failed to encode DB: %v", err)
+	pts, err := encodeShards(p, packed)
+	return ic, pts, err
+}
+
+// dbCacheKey identifies the committed m_DB for the in-memory cache.
+func dbCacheKey(n, recordS int, sum string) string {
+	return fmt.Sprintf("%d/%d/%s", n, recordS, sum)
+}
+
+// dbVersion is the committed m_DB_version: how many times m_DB has been
+// written on this channel (0 before the first write). It survives re-inits.
+func dbVersion(ctx contractapi.TransactionContextInterface) (int, error) {
+	raw, err := ctx.GetStub().GetState("m_DB_version")
+	if err != nil {
+		return 0, err
 	}
-	cc.m_DB = pt
+	v, _ := strconv.Atoi(string(raw))
+	return v, nil
+}
 
-	// ---- 7) Persist to world state ----
-	dbg("[CC][INIT] Persisting to world state...")
-	ptBytes, _ := pt.MarshalBinary()
-	if err := ctx.GetStub().PutState("m_DB", ptBytes); err != nil {
-		return "", err
+// mdbKey is the world-state key of m_DB shard k. The first shard keeps
+// the "m_DB" key, so single-plaintext datasets are stored as before; the
+// others follow as "m_DB_001", "m_DB_002", ... "m_DB_shards" holds the
+// count when there is more than one.
+func mdbKey(k int) string {
+	if k == 0 {
+		return "m_DB"
 	}
-	ctx.GetStub().PutState("n", []byte(fmt.Sprintf("%d", cc.NRecords)))
-	ctx.GetStub().PutState("record_s", []byte(fmt.Sprintf("%d", cc.SlotsPerRec)))
+	return fmt.Sprintf("m_DB_%03d", k)
+}
 
-	paramsMeta := struct {
-		LogN  int    `json:"logN"`
-		N     int    `json:"N"`
-		LogQi []int  `json:"logQi"`
-		LogPi []int  `json:"logPi"`
-		T     uint64 `json:"t"`
-	}{
-		LogN:  p.LogN(),
-		N:     p.N(),
-		LogQi: p.LogQi(),
-		LogPi: p.LogPi(),
-		T:     p.PlaintextModulus(),
+// mdbShards is the committed number of m_DB shards (1 when unset).
+func mdbShards(ctx contractapi.TransactionContextInterface) (int, error) {
+	raw, err := ctx.GetStub().GetState("m_DB_shards")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read m_DB_shards from ledger: %w", err)
 	}
-	pm, _ := json.Marshal(paramsMeta)
-	ctx.GetStub().PutState("bgv_params", pm)
+	if raw == nil {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(string(raw))
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid m_DB_shards %q", raw)
+	}
+	return n, nil
+}
 
-	// ---- Debug parity log ----
-	dbg("[CC][INIT][META] n=%d record_s=%d logN=%d N=%d T=%d logQi=%v logPi=%v",
-		cc.NRecords, cc.SlotsPerRec, p.LogN(), p.N(), p.PlaintextModulus(), p.LogQi(), p.LogPi())
-	cc.initialized = true
+// delShards deletes the committed m_DB shards from shard from on.
+func delShards(ctx contractapi.TransactionContextInterface, from int) error {
+	n, err := mdbShards(ctx)
+	if err != nil {
+		return err
+	}
+	for k := from; k < n; k++ {
+		if err := ctx.GetStub().DelState(mdbKey(k)); err != nil {
+			return err
+		}
+	}
+	if from <= 1 {
+		return ctx.GetStub().DelState("m_DB_shards")
+	}
+	return nil
+}
 
-	elapsed := time.Since(start)
-	executionTime := float64(elapsed.Nanoseconds()) / 1e6
-	dbg("[CC][INIT] Completed in %.3f ms (LogN=%d, slots=%d)",
-		executionTime, cc.Params.LogN(), cc.Params.MaxSlots())
-	dbg("/**************  INIT LEDGER END ******************************************/")
+// encodeShards encodes every packed shard into a plaintext under p.
+func encodeShards(p he.Params, packed [][]uint64) ([]he.Plaintext, error) {
+	pts := make([]he.Plaintext, len(packed))
+	for k, slots := range packed {
+		pt, err := he.Default.Encode(p, slots)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode DB shard %d: %v", k, err)
+		}
+		pts[k] = pt
+	}
+	return pts, nil
+}
 
-	// Return execution time as JSON
-	result := map[string]interface{}{
-		"status":            "success",
-		"execution_time_ms": executionTime,
+// decodeShards decodes every m_DB shard back into its slots.
+func decodeShards(p he.Params, pts []he.Plaintext) ([][]uint64, error) {
+	shards := make([][]uint64, len(pts))
+	for k, pt := range pts {
+		slots, err := he.Default.Decode(p, pt)
+		if err != nil {
+			return nil, fmt.Errorf("decode m_DB shard %d: %w", k, err)
+		}
+		shards[k] = slots
 	}
-	resultJSON, _ := json.Marshal(result)
-	return string(resultJSON), nil
+	return shards, nil
+}
+
+// persistDB stores the m_DB shards, their digest (over the shards in
+// order; for one shard the digest of m_DB) under the configured
+// integrity.digest with its algorithm, n and record_s, bumps
+// m_DB_version, records what the new version changed (putVersionDelta and
+// the change feed, putChanges; nil changes means a full rewrite) and
+// caches pts as the current m_DB. Every m_DB write goes through here
+// (beginDataset deletes the digest with the shards), so the digest the
+// audit path reads (mdbDigest) is always that of the stored m_DB.
+func (cc *PIRChainCode) persistDB(ctx contractapi.TransactionContextInterface, pts []he.Plaintext,
+	nRecords, slotsPerRec, reservedFrom int, changes []utils.RecordChange) error {
+	if err := delShards(ctx, len(pts)); err != nil {
+		return err
+	}
+	alg, err := utils.ParseDigestAlg(configOf(ctx).Integrity.Digest)
+	if err != nil {
+		return fmt.Errorf("integrity.digest: %w", err)
+	}
+	h := utils.NewDigest(alg)
+	for k, pt := range pts {
+		ptBytes, err := pt.MarshalBinary()
+		if err != nil {
+			return fmt.Errorf("marshal m_DB shard %d: %w", k, err)
+		}
+		if err := ctx.GetStub().PutState(mdbKey(k), ptBytes); err != nil {
+			return err
+		}
+		h.Write(ptBytes)
+	}
+	if len(pts) > 1 {
+		if err := ctx.GetStub().PutState("m_DB_shards", []byte(strconv.Itoa(len(pts)))); err != nil {
+			return err
+		}
+	}
+	sum := h.Sum(nil)
+	for _, kv := range [][2]string{
+		{"m_DB_sha256", hex.EncodeToString(sum)},
+		{"m_DB_digest_alg", alg},
+		{"n", strconv.Itoa(nRecords)},
+		{"record_s", strconv.Itoa(slotsPerRec)},
+	} {
+		if err := ctx.GetStub().PutState(kv[0], []byte(kv[1])); err != nil {
+			return err
+		}
+	}
+	if reservedFrom > 0 {
+		err = ctx.GetStub().PutState("reserved_from", []byte(strconv.Itoa(reservedFrom)))
+	} else {
+		err = ctx.GetStub().DelState("reserved_from")
+	}
+	if err != nil {
+		return err
+	}
+	version, err := dbVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState("m_DB_version", []byte(strconv.Itoa(version+1))); err != nil {
+		return err
+	}
+	var changed []int
+	if changes != nil {
+		changed = utils.ChangedIndices(changes)
+	}
+	if err := putVersionDelta(ctx, version+1, nRecords, slotsPerRec, changed); err != nil {
+		return err
+	}
+	if err := putChanges(ctx, version+1, changes); err != nil {
+		return err
+	}
+
+	c := cc.cache(ctx)
+	c.mu.Lock()
+	c.m_DB, c.dbKey = pts, dbCacheKey(nRecords, slotsPerRec, hex.EncodeToString(sum))
+	c.mu.Unlock()
+	return nil
 }
 
 /**************  GET METADATA *******************************************/
-func (cc *PIRChainCode) GetMetadata(ctx contractapi.TransactionContextInterface) (string, error) {
+func (cc *PIRChainCode) GetMetadata(ctx contractapi.TransactionContextInterface) (_ string, err error) {
+	lg := sampledLog("GetMetadata")
+	defer func() { lg.fail(err) }()
+	lg.dbg("\n/**************  GET METADATA START ************************************/")
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	out, err := json.Marshal(meta)
+	if err != nil {
+		return "", fmt.Errorf("[CC][GETMETADATA]: failed to marshal metadata: %w", err)
+	}
+	lg.dbg("/**************  GET METADATA END **************************************/")
+	return string(out), nil
+}
 
-	dbg("\n/**************  GET METADATA START ************************************/")
+// GetMetadataTimed is GetMetadata wrapped in the {result, execution_time_ms} envelope.
+func (cc *PIRChainCode) GetMetadataTimed(ctx contractapi.TransactionContextInterface) (_ string, err error) {
+	lg := sampledLog("GetMetadata")
+	defer func() { lg.fail(err) }()
 	start := time.Now()
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	out, err := utils.MarshalTimed(meta, start)
+	lg.dbg("[CC][GETMETADATA] Completed in %.3f ms", float64(time.Since(start).Nanoseconds())/1e6)
+	return out, err
+}
+
+// loadMetadata assembles the public metadata blob from world state.
+func (cc *PIRChainCode) loadMetadata(ctx contractapi.TransactionContextInterface) (utils.Metadata, error) {
 	// --- Load n ---
 	nBytes, err := ctx.GetStub().GetState("n")
 	if err != nil || nBytes == nil {
-		return "", fmt.Errorf("[CC][GETMETADATA]: missing n in world state")
+		return utils.Metadata{}, fmt.Errorf("[CC][GETMETADATA]: missing n in world state")
 	}
 	n, _ := strconv.Atoi(string(nBytes))
 
 	// --- Load record_s ---
 	sBytes, err := ctx.GetStub().GetState("record_s")
 	if err != nil || sBytes == nil {
-		return "", fmt.Errorf("[CC][GETMETADATA]: missing record_s in world state")
+		return utils.Metadata{}, fmt.Errorf("[CC][GETMETADATA]: missing record_s in world state")
 	}
 	recordS, _ := strconv.Atoi(string(sBytes))
 
+	// --- Load reserved_from (absent: no reserved indices) ---
+	rBytes, err := ctx.GetStub().GetState("reserved_from")
+	if err != nil {
+		return utils.Metadata{}, fmt.Errorf("[CC][GETMETADATA]: failed to read reserved_from: %w", err)
+	}
+	reservedFrom, _ := strconv.Atoi(string(rBytes))
+
 	// --- Load bgv_params ---
 	paramsBytes, err := ctx.GetStub().GetState("bgv_params")
 	if err != nil || paramsBytes == nil {
-		return "", fmt.Errorf("[CC][GETMETADATA]: missing bgv_params in world state")
-	}
-	var paramsMeta struct {
-		LogN  int    `json:"logN"`
-		N     int    `json:"N"`
-		LogQi []int  `json:"logQi"`
-		LogPi []int  `json:"logPi"`
-		T     uint64 `json:"t"`
+		return utils.Metadata{}, fmt.Errorf("[CC][GETMETADATA]: missing bgv_params in world state")
 	}
+	var paramsMeta bgvParamsMeta
 	if err := json.Unmarshal(paramsBytes, &paramsMeta); err != nil {
-		return "", fmt.Errorf("[CC][GETMETADATA]: failed to parse bgv_params: %w", err)
+		return utils.Metadata{}, fmt.Errorf("[CC][GETMETADATA]: failed to parse bgv_params: %w", err)
 	}
 
 	// --- Construct metadata blob ---
-	meta := struct {
-		NRecords int    `json:"n"`
-		RecordS  int    `json:"record_s"`
-		LogN     int    `json:"logN"`
-		N        int    `json:"N"`
-		T        uint64 `json:"t"`
-		LogQi    []int  `json:"logQi"`
-		LogPi    []int  `json:"logPi"`
-	}{
+	meta := utils.Metadata{
 		NRecords: n,
 		RecordS:  recordS,
 		LogN:     paramsMeta.LogN,
@@ -233,146 +622,569 @@ func (cc *PIRChainCode) GetMetadata(ctx contractapi.TransactionContextInterface)
 		T:        paramsMeta.T,
 		LogQi:    paramsMeta.LogQi,
 		LogPi:    paramsMeta.LogPi,
-	}
 
-	out, err := json.Marshal(meta)
-	if err != nil {
-		return "", fmt.Errorf("[CC][GETMETADATA]: failed to marshal metadata: %w", err)
+		ReservedFrom: reservedFrom,
+		Scheme:       paramsMeta.Scheme,
+		Packing:      paramsMeta.Packing,
 	}
+	meta.SlotCount = meta.Slots() // the params' MaxSlots (utils.SlotsAt)
+	dbg("[CC][GETMETADATA] n=%d record_s=%d reserved_from=%d | LogN=%d N=%d T=%d | LogQi=%v LogPi=%v",
+		meta.NRecords, meta.RecordS, meta.ReservedFrom, meta.LogN, meta.N, meta.T, meta.LogQi, meta.LogPi)
+	return meta, nil
+}
 
-	dbg("[CC][GETMETADATA] n=%d record_s=%d | LogN=%d N=%d T=%d | LogQi=%v LogPi=%v",
-		meta.NRecords, meta.RecordS, meta.LogN, meta.N, meta.T, meta.LogQi, meta.LogPi)
+/**************  DESCRIBE SELECTOR **************************************/
 
-	elapsed := time.Since(start)
-	executionTime := float64(elapsed.Nanoseconds()) / 1e6
-	dbg("[CC][GETMETADATA] Completed in %.3f ms", executionTime)
-	dbg("/**************  GET METADATA END **************************************/")
+// DescribeSelector returns {start_slot, end_slot, shard} of record index
+// as packed by InitLedger, computed from the committed metadata, so clients
+// can cross-check the selector window they build locally.
+func (cc *PIRChainCode) DescribeSelector(ctx contractapi.TransactionContextInterface, indexStr string) (string, error) {
+	idx, err := strconv.Atoi(indexStr)
+	if err != nil {
+		return "", fmt.Errorf("DescribeSelector: index must be an integer: %w", err)
+	}
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	layout, err := utils.NewIndexContract(meta).Describe(idx)
+	if err != nil {
+		return "", fmt.Errorf("DescribeSelector: %w", err)
+	}
+	out, err := json.Marshal(layout)
+	if err != nil {
+		return "", fmt.Errorf("DescribeSelector: %w", err)
+	}
+	dbg("[CC][DESCRIBE] index=%d → shard=%d slots [%d:%d)", idx, layout.Shard, layout.StartSlot, layout.EndSlot)
+	return string(out), nil
+}
 
-	// Return metadata with execution time
-	result := map[string]interface{}{
-		"metadata":          json.RawMessage(out),
-		"execution_time_ms": executionTime,
+// GetWindowTable returns the utils.WindowTable of the committed dataset:
+// the generator of every selector window plus a layout_id under which
+// clients cache precomputed selector plaintexts (cpir.PrecomputeSelector).
+// expand = "true" also lists each index's window. Evaluate only.
+func (cc *PIRChainCode) GetWindowTable(ctx contractapi.TransactionContextInterface, expand string) (string, error) {
+	full := false
+	if expand != "" {
+		var err error
+		if full, err = strconv.ParseBool(expand); err != nil {
+			return "", fmt.Errorf("GetWindowTable: expand must be true or false: %w", err)
+		}
 	}
-	resultJSON, _ := json.Marshal(result)
-	return string(resultJSON), nil
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	version, err := dbVersion(ctx)
+	if err != nil {
+		return "", fmt.Errorf("GetWindowTable: %w", err)
+	}
+	table, err := utils.NewWindowTable(meta, version, full)
+	if err != nil {
+		return "", fmt.Errorf("GetWindowTable: %w", err)
+	}
+	out, err := json.Marshal(table)
+	if err != nil {
+		return "", fmt.Errorf("GetWindowTable: %w", err)
+	}
+	dbg("[CC][WINDOWS] layout_id=%.12s n=%d stride=%d version=%d expand=%v",
+		table.LayoutID, table.NRecords, table.Stride, version, full)
+	return string(out), nil
 }
 
 /**************  PUBLIC QUERY *******************************************/
 func (cc *PIRChainCode) PublicQuery(ctx contractapi.TransactionContextInterface, key string) (string, error) {
-	dbg("\n/**************  PUBLIC QUERY START ****************************************/")
+	return cc.publicQuery(ctx, "PublicQuery", key)
+}
+
+// publicQuery is the read behind PublicQuery and PublicQuerySubmit; fn
+// prefixes errors and selects the log sampling.
+func (cc *PIRChainCode) publicQuery(ctx contractapi.TransactionContextInterface, fn, key string) (_ string, err error) {
+	lg := sampledLog(fn)
+	defer func() { lg.fail(err) }()
+	lg.dbg("\n/**************  PUBLIC QUERY START ****************************************/")
 
 	if key == "" {
-		return "", fmt.Errorf("PublicQuery: key must not be empty")
+		return "", fmt.Errorf("%s: %w: key must not be empty", fn, utils.ErrInvalidKey)
 	}
 
-	if idx, ok := utils.ParseRecordIndex(key); ok {
-		dbg("[CC][PUBLIC] Retrieving key=%q (index=%d)", key, idx)
-	} else {
-		dbg("[CC][PUBLIC] Retrieving key=%q (index=unknown)", key)
+	// Only record keys of the committed dataset are public; m_DB, the
+	// audit entries and every other key stay behind their own functions
+	// (keyspace.go).
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", fn, err)
+	}
+	collection, err := recordCollection(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", fn, err)
 	}
+	if collection != "" {
+		return "", fmt.Errorf("%s: records of this dataset are private (collection %q) - use GetPrivateRecord", fn, collection)
+	}
+	ic := utils.NewIndexContract(meta)
+	idx, err := publicKey(ic, key)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", fn, err)
+	}
+	lg.dbg("[CC][PUBLIC] Retrieving key=%q (index=%d)", key, idx)
 
 	// --- Load record from world state ---
 	b, err := ctx.GetStub().GetState(key)
 	if err != nil {
-		return "", fmt.Errorf("PublicQuery: ledger read failed: %w", err)
+		return "", fmt.Errorf("%s: ledger read failed: %w", fn, err)
 	}
+	cc.cache(ctx).access.AddPublicRead(key, txTime(ctx))
 	if b == nil {
-		return "", fmt.Errorf("PublicQuery: record %s not found", key)
+		if ic.IsReserved(idx) {
+			return utils.EmptyRecord, nil
+		}
+		return "", fmt.Errorf("%s: %w: %s", fn, utils.ErrRecordNotFound, key)
 	}
 
-	dbg("/**************  PUBLIC QUERY END ******************************************/")
+	lg.dbg("/**************  PUBLIC QUERY END ******************************************/")
 	return string(b), nil
 }
 
+// PublicQueryTimed is PublicQuery wrapped in the {result, execution_time_ms} envelope.
+func (cc *PIRChainCode) PublicQueryTimed(ctx contractapi.TransactionContextInterface, key string) (string, error) {
+	start := time.Now()
+	rec, err := cc.PublicQuery(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return utils.MarshalTimed([]byte(rec), start)
+}
+
 /**************  PIR QUERY *********************************************/
 
-func (cc *PIRChainCode) PIRQuery(ctx contractapi.TransactionContextInterface, encQueryB64 string) (string, error) {
-	dbg("\n/**************  PIR QUERY START ****************************************/")
+// ensureParams returns the BGV parameters committed under "bgv_params".
+// The cached Params only survive in the container that ran InitLedger, so
+// after a peer restart (or on another endorser) they are rebuilt from
+// world state.
+// A changed key (re-init elsewhere) also invalidates the cached m_DB.
+func (cc *PIRChainCode) ensureParams(ctx contractapi.TransactionContextInterface) (he.Params, error) {
+	raw, err := ctx.GetStub().GetState("bgv_params")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bgv_params from ledger: %w", err)
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("bgv_params not found in world state - call InitLedger first")
+	}
+
+	c := cc.cache(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paramsRaw != nil && string(c.paramsRaw) == string(raw) {
+		return c.Params, nil
+	}
+
+	var pm bgvParamsMeta
+	if err := json.Unmarshal(raw, &pm); err != nil {
+		return nil, fmt.Errorf("failed to parse bgv_params: %w", err)
+	}
+	p, err := he.Default.NewParams(pm.hint())
+	if err != nil {
+		return nil, fmt.Errorf("failed to rebuild params from bgv_params: %w", err)
+	}
+	c.Params, c.paramsRaw, c.m_DB = p, raw, nil
+	dbg("[CC] params reloaded from world state (LogN=%d, |Q|=%d, T=%d, %s)", p.LogN(), len(p.LogQi()), p.PlaintextModulus(), he.SchemeOf(p))
+	return p, nil
+}
+
+// RebuildFromState drops every in-memory cache and rebuilds params and m_DB
+// from world state alone, so a peer that joined the channel from a ledger
+// snapshot (or just restarted) is ready before the first PIRQuery. Usage
+// stats are per-peer and start empty. Evaluate-only; writes nothing.
+func (cc *PIRChainCode) RebuildFromState(ctx contractapi.TransactionContextInterface) (string, error) {
 	start := time.Now()
+	c := cc.cache(ctx)
+	c.mu.Lock()
+	c.Params, c.m_DB, c.paramsRaw, c.dbKey = nil, nil, nil, ""
+	c.mu.Unlock()
 
-	if encQueryB64 == "" {
-		return "", fmt.Errorf("PIRQuery: empty encQueryB64")
+	params, _, err := cc.ensureDB(ctx)
+	if err != nil {
+		return "", fmt.Errorf("RebuildFromState: %w", err)
 	}
-	fmt.Printf("Received encQueryB64 length: %d\n", len(encQueryB64))
-	fmt.Printf("First 100 chars: %s\n", encQueryB64[:min(100, len(encQueryB64))])
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		return "", fmt.Errorf("RebuildFromState: %w", err)
+	}
+	dbg("[CC][REBUILD] caches rebuilt from world state: n=%d record_s=%d LogN=%d",
+		meta.NRecords, meta.RecordS, params.LogN())
+	return utils.MarshalTimed(meta, start)
+}
 
-	// Ensure m_DB is available (reload from ledger if needed)
-	if cc.m_DB == nil {
-		raw, err := ctx.GetStub().GetState("m_DB")
+// isInitialized reports whether InitLedger has been committed, judged from
+// world state rather than this container's memory.
+func (cc *PIRChainCode) isInitialized(ctx contractapi.TransactionContextInterface) (bool, error) {
+	for _, key := range []string{"n", "record_s", "bgv_params"} {
+		v, err := ctx.GetStub().GetState(key)
 		if err != nil {
-			return "", fmt.Errorf("PIRQuery: failed to read m_DB from ledger: %w", err)
+			return false, fmt.Errorf("failed to read %s from ledger: %w", key, err)
+		}
+		if v == nil {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// ensureDB is ensureParams plus the m_DB shards decoded under them.
+func (cc *PIRChainCode) ensureDB(ctx contractapi.TransactionContextInterface) (he.Params, []he.Plaintext, error) {
+	params, err := cc.ensureParams(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// m_DB may come from client-supplied records (InitCommit, PutMDB), so
+	// the cache is keyed on its digest as well as on n + record_s.
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	sum, err := ctx.GetStub().GetState("m_DB_sha256")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read m_DB_sha256 from ledger: %w", err)
+	}
+	key := dbCacheKey(meta.NRecords, meta.RecordS, string(sum))
+
+	c := cc.cache(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m_DB != nil && c.dbKey == key {
+		return params, c.m_DB, nil
+	}
+	shards, err := mdbShards(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	pts := make([]he.Plaintext, shards)
+	for k := range pts {
+		raw, err := ctx.GetStub().GetState(mdbKey(k))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s from ledger: %w", mdbKey(k), err)
 		}
 		if raw == nil {
-			return "", fmt.Errorf("PIRQuery: m_DB not found in world state")
+			return nil, nil, fmt.Errorf("%s not found in world state", mdbKey(k))
 		}
-		pt := bgv.NewPlaintext(cc.Params, cc.Params.MaxLevel())
-		if err := pt.UnmarshalBinary(raw); err != nil {
-			return "", fmt.Errorf("PIRQuery: failed to unmarshal m_DB: %w", err)
+		if pts[k], err = he.Default.UnmarshalPlaintext(params, raw); err != nil {
+			return nil, nil, fmt.Errorf("failed to unmarshal %s: %w", mdbKey(k), err)
 		}
-		cc.m_DB = pt
-		dbg("[CC] PIRQuery: m_DB reloaded (level=%d, N=%d)", cc.Params.MaxLevel(), cc.Params.N())
 	}
+	c.m_DB, c.dbKey = pts, key
+	dbg("[CC] PIRQuery: m_DB reloaded (%d shard(s), level=%d, N=%d)", shards, params.MaxLevel(), params.N())
+	return params, pts, nil
+}
 
-	// Decode Base64 → ciphertext
-	encBytes, err := base64.StdEncoding.DecodeString(encQueryB64)
+func (cc *PIRChainCode) PIRQuery(ctx contractapi.TransactionContextInterface, encQueryB64 string) (string, error) {
+	out, _, err := cc.pirQuery(ctx, encQueryB64)
+	return out, err
+}
+
+// pirQuery evaluates ct_q × m_DB and reports the evaluation's resource
+// usage, which is also charged to the caller in usageTotals.
+func (cc *PIRChainCode) pirQuery(ctx contractapi.TransactionContextInterface, encQueryB64 string) (string, utils.EvalUsage, error) {
+	return cc.evalQuery(ctx, "PIRQuery", encQueryB64, cc.ensureDB)
+}
+
+// evalQuery is pirQuery against the plaintexts returned by load (the m_DB
+// shards, or a delta plaintext for PIRQueryDelta); fn prefixes errors.
+// ct_q is multiplied with every shard and the result ciphertexts are
+// returned as utils.EncodeShardResults.
+func (cc *PIRChainCode) evalQuery(ctx contractapi.TransactionContextInterface, fn, encQueryB64 string,
+	load func(contractapi.TransactionContextInterface) (he.Params, []he.Plaintext, error)) (string, utils.EvalUsage, error) {
+	return cc.evalQueries(ctx, fn, []string{encQueryB64}, load)
+}
+
+// evalQueries is evalQuery for a selector given either as one ct_q or as
+// the row and column ciphertexts of a 2D query (utils/grid.go), which are
+// multiplied together before the shards.
+func (cc *PIRChainCode) evalQueries(ctx contractapi.TransactionContextInterface, fn string, encQueries []string,
+	load func(contractapi.TransactionContextInterface) (he.Params, []he.Plaintext, error)) (string, utils.EvalUsage, error) {
+	out, usage, err := cc.evalSelectors(ctx, fn, [][]string{encQueries}, load)
 	if err != nil {
-		return "", fmt.Errorf("PIRQuery: failed to decode base64 query: %w", err)
-	}
-	{
-		// hash + head hex for quick correlation with client logs
-		sum := sha256.Sum256(encBytes)
-		dbg("[CC][PIR] Decoded query: bytes=%d sha256=%s head32=%s",
-			len(encBytes), hex.EncodeToString(sum[:]), utils.HexHead(encBytes, 32))
+		return "", usage, err
 	}
+	return out[0], usage, nil
+}
+
+// evalSelectors is evalQueries for several selectors in one transaction
+// (PIRQueryBatch): every selector × shard product goes to the worker pool
+// at once, under one watchdog budget and one in-flight slot, and each
+// selector gets its own utils.EncodeShardResults response. usage.Shards
+// lists the products selector by selector.
+func (cc *PIRChainCode) evalSelectors(ctx contractapi.TransactionContextInterface, fn string, selectors [][]string,
+	load func(contractapi.TransactionContextInterface) (he.Params, []he.Plaintext, error)) (_ []string, usage utils.EvalUsage, err error) {
+	lg := sampledLog(fn)
+	defer func() { lg.fail(err) }()
+	lg.dbg("\n/**************  PIR QUERY START ****************************************/")
+	start := time.Now()
 
-	ctQuery := rlwe.NewCiphertext(cc.Params, 1, cc.Params.MaxLevel())
-	if err := ctQuery.UnmarshalBinary(encBytes); err != nil {
-		return "", fmt.Errorf("PIRQuery: failed to unmarshal query ciphertext: %w", err)
+	for _, sel := range selectors {
+		for _, q := range sel {
+			if q == "" {
+				return nil, usage, fmt.Errorf("%s: empty encQueryB64", fn)
+			}
+			lg.dbg("Received encQueryB64 length: %d", len(q))
+			lg.dbg("First 100 chars: %s", q[:min(100, len(q))])
+		}
 	}
-	dbg("[CC][PIR] Query ciphertext size = %d bytes", len(encBytes))
 
-	// Homomorphic evaluation: ct × pt
-	eval := bgv.NewEvaluator(cc.Params, nil)
-	homomorphicStart := time.Now()
-	ctRes, err := eval.MulNew(ctQuery, cc.m_DB)
+	// Ensure params and m_DB are available (reload from ledger if needed)
+	params, mDB, err := load(ctx)
 	if err != nil {
-		return "", fmt.Errorf("PIRQuery: PIR evaluation failed: %w", err)
+		return nil, usage, fmt.Errorf("%s: %w", fn, err)
+	}
+	for _, sel := range selectors {
+		if len(sel) == 2 {
+			if err := utils.Check2D(params.LogQi()); err != nil {
+				return nil, usage, fmt.Errorf("%s: %w", fn, err)
+			}
+		}
 	}
-	homomorphicElapsed := time.Since(homomorphicStart)
-	dbg("[CC][PIR] Homomorphic evaluation completed in %.3f ms", float64(homomorphicElapsed.Nanoseconds())/1e6)
 
-	// Marshal result → Base64
-	outBytes, err := ctRes.MarshalBinary()
+	// Size and concurrency guard (see guard.go) before any decoding work
+	for _, sel := range selectors {
+		for _, q := range sel {
+			if err := checkQuerySize(params, q); err != nil {
+				return nil, usage, fmt.Errorf("%s: %w", fn, err)
+			}
+		}
+	}
+	client := clientID(ctx)
+	release, err := acquireEval(client)
 	if err != nil {
-		return "", fmt.Errorf("PIRQuery: failed to marshal result ciphertext: %w", err)
+		return nil, usage, fmt.Errorf("%s: %w", fn, err)
+	}
+	defer func() { release() }()
+
+	// Decode Base64 → ciphertext (panic-safe, shape-checked; see he.DecodeQuery)
+	cts := make([][]he.Ciphertext, len(selectors))
+	queryBytes := 0
+	for i, sel := range selectors {
+		cts[i] = make([]he.Ciphertext, len(sel))
+		for k, q := range sel {
+			ct, encBytes, err := he.DecodeQuery(he.Default, params, q)
+			if err != nil {
+				return nil, usage, fmt.Errorf("%s: %w", fn, err)
+			}
+			// hash + head hex for quick correlation with client logs
+			sum := sha256.Sum256(encBytes)
+			lg.dbg("[CC][PIR] Decoded query: bytes=%d sha256=%s head32=%s",
+				len(encBytes), hex.EncodeToString(sum[:]), utils.HexHead(encBytes, 32))
+			cts[i][k], queryBytes = ct, queryBytes+len(encBytes)
+		}
+	}
+	lg.dbg("[CC][PIR] Query ciphertext size = %d bytes", queryBytes)
+
+	// Homomorphic evaluation: ct × pt, one task per selector and shard on
+	// the worker pool (see scheduler.go)
+	products := len(selectors) * len(mDB)
+	ctRes := make([]he.Ciphertext, products)
+	var shards []utils.ShardUsage
+	usage, err = evalWithDeadline(start, params.LogN(), products, func() (utils.EvalUsage, error) {
+		// a 2D query's selector is the product of its row and column
+		ctQuery, combine := make([]he.Ciphertext, len(cts)), utils.EvalUsage{}
+		for i, c := range cts {
+			ctQuery[i] = c[0]
+			if len(c) == 2 {
+				u, err := utils.MeasureEval(func() (err error) {
+					ctQuery[i], err = he.Default.Mul(params, c[0], c[1])
+					return err
+				})
+				combine.Merge(u)
+				if err != nil {
+					return combine, err
+				}
+			}
+		}
+		fns := make([]func() error, products)
+		for j := range fns {
+			q, pt := ctQuery[j/len(mDB)], mDB[j%len(mDB)]
+			fns[j] = func() (err error) {
+				ctRes[j], err = he.Default.MulPlain(params, q, pt)
+				return err
+			}
+		}
+		u, s, err := sched.run(fns)
+		shards = s
+		u.Merge(combine)
+		return u, err
+	})
+	perfKey := utils.PerfKey{LogN: params.LogN(), Shards: len(mDB)}
+	var timeout *EvalTimeoutError
+	if errors.As(err, &timeout) {
+		perfStats.AddTimeout(perfKey)
+		if timeout.done != nil {
+			// the abandoned evaluation keeps its in-flight slot until it returns
+			r := release
+			release = func() {}
+			go func() { <-timeout.done; r() }()
+		}
+		lg.dbg("[CC][PIR] evaluation aborted: %v", timeout)
+		return nil, usage, timeout
+	}
+	if err != nil {
+		return nil, usage, fmt.Errorf("%s: PIR evaluation failed: %w", fn, err)
+	}
+	usageTotals.AddQueries(client, len(selectors), usage)
+	perfStats.Add(perfKey, usage.WallMS/float64(len(selectors)))
+	observeProductCost(params.LogN(), shards)
+	queueStats.Add(perfKey, usage.QueueMS)
+	access := &cc.cache(ctx).access
+	for range selectors {
+		access.AddQuery(txTime(ctx))
+	}
+	lg.dbg("[CC][PIR] Homomorphic evaluation of %d selector(s) × %d shard(s) completed in %.3f ms after %.3f ms queued (cpu %.3f ms, alloc %d B)",
+		len(selectors), len(mDB), usage.WallMS, usage.QueueMS, usage.CPUMS, usage.AllocBytes)
+
+	// Optional modulus switch to level 0 (result.mod_switch, BGV only)
+	if configOf(ctx).Result.ModSwitch && he.SchemeOf(params) == utils.SchemeBGV && params.MaxLevel() > 0 {
+		for j := range ctRes {
+			if ctRes[j], err = he.Default.ModSwitch(params, ctRes[j], 0); err != nil {
+				return nil, usage, fmt.Errorf("%s: failed to switch result ciphertext to level 0: %w", fn, err)
+			}
+		}
+	}
+
+	// Marshal results → Base64, one shard list per selector
+	out := make([]string, len(selectors))
+	usage.LogN, usage.QueryBytes, usage.Shards = params.LogN(), queryBytes, shards
+	for i := range selectors {
+		results := make([]string, len(mDB))
+		for k := range mDB {
+			j := i*len(mDB) + k
+			outBytes, err := ctRes[j].MarshalBinary()
+			if err != nil {
+				return nil, usage, fmt.Errorf("%s: failed to marshal result ciphertext: %w", fn, err)
+			}
+			lg.dbg("[CC][PIR] Result ciphertext %d size = %d bytes at level %d (eval %.3f ms on worker %d, queued %.3f ms)",
+				j, len(outBytes), ctRes[j].Level(), shards[j].EvalMS, shards[j].Worker, shards[j].QueueMS)
+			results[k] = base64.StdEncoding.EncodeToString(outBytes)
+			usage.Shards[j].Shard, usage.Shards[j].ResultBytes = k, len(outBytes)
+		}
+		out[i] = utils.EncodeShardResults(results)
 	}
-	dbg("[CC][PIR] Result ciphertext size = %d bytes", len(outBytes))
 
 	elapsed := time.Since(start)
-	dbg("[CC][PIR] Total PIRQuery completed in %.3f ms (HE eval: %.3f ms)",
-		float64(elapsed.Nanoseconds())/1e6, float64(homomorphicElapsed.Nanoseconds())/1e6)
-	dbg("/**************  PIR QUERY END ******************************************/")
+	lg.dbg("[CC][PIR] Total %s completed in %.3f ms (HE eval: %.3f ms)",
+		fn, float64(elapsed.Nanoseconds())/1e6, usage.WallMS)
+	lg.dbg("/**************  PIR QUERY END ******************************************/")
+
+	return out, usage, nil
+}
+
+// PIRQueryTimed is PIRQuery wrapped in the {result, execution_time_ms} envelope,
+// plus the evaluation's resource usage under "usage".
+func (cc *PIRChainCode) PIRQueryTimed(ctx contractapi.TransactionContextInterface, encQueryB64 string) (string, error) {
+	start := time.Now()
+	result, usage, err := cc.pirQuery(ctx, encQueryB64)
+	if err != nil {
+		return "", err
+	}
+	return utils.MarshalTimedUsage(result, start, &usage)
+}
+
+/**************  METADATA + QUERY ***************************************/
+
+// MetaAndQuery serves a cold-start client in one evaluate: the metadata
+// and capabilities (GetMetadata, GetCapabilities) and, if encQueryB64 is
+// not empty, the PIRQuery response to it, as a utils.MetaQuery. The client
+// builds ct_q from metadata cached in an earlier session and decrypts only
+// if the returned metadata still matches it. A ct_q whose size does not fit
+// the committed params is reported under query_error instead of failing
+// the call, so the client still gets the metadata to rebuild it; overload
+// and evaluation errors fail the call as they do in PIRQuery.
+func (cc *PIRChainCode) MetaAndQuery(ctx contractapi.TransactionContextInterface, encQueryB64 string) (string, error) {
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		return "", fmt.Errorf("MetaAndQuery: %w", err)
+	}
+	out := utils.MetaQuery{Metadata: meta, Capabilities: capabilities()}
+	if encQueryB64 != "" {
+		params, err := cc.ensureParams(ctx)
+		if err != nil {
+			return "", fmt.Errorf("MetaAndQuery: %w", err)
+		}
+		if err := checkQuerySize(params, encQueryB64); err != nil {
+			out.QueryError = err.Error()
+		} else if out.Result, _, err = cc.evalQuery(ctx, "MetaAndQuery", encQueryB64, cc.ensureDB); err != nil {
+			return "", err
+		}
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return "", fmt.Errorf("MetaAndQuery: %w", err)
+	}
+	return string(b), nil
+}
 
-	return base64.StdEncoding.EncodeToString(outBytes), nil
+// GetUsageStats returns the PIR evaluation cost charged to each client
+// identity by this peer since it started, and the transaction's dataset's
+// access counters: PIR queries per UTC day (never per index, which the
+// peer cannot know) and PublicQuery reads per key (utils.AccessStats).
+// Evaluate only; not consensus data.
+func (cc *PIRChainCode) GetUsageStats(ctx contractapi.TransactionContextInterface) (string, error) {
+	out, err := json.Marshal(utils.UsageStats{
+		Clients: usageTotals.Snapshot(),
+		Access:  cc.cache(ctx).access.Snapshot(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("GetUsageStats: %w", err)
+	}
+	return string(out), nil
+}
+
+// txTime is the transaction's timestamp, or the peer's clock when the
+// proposal carries none.
+func txTime(ctx contractapi.TransactionContextInterface) time.Time {
+	if ts, err := ctx.GetStub().GetTxTimestamp(); err == nil && ts != nil {
+		return time.Unix(ts.GetSeconds(), int64(ts.GetNanos()))
+	}
+	return time.Now()
+}
+
+// GetPerfStats returns this peer's ct × pt evaluation latency per
+// (logN, shards) since it started: histogram, all-time and rolling
+// (last utils.PerfWindow) figures, and the drift between the two.
+// Evaluate only; not consensus data.
+func (cc *PIRChainCode) GetPerfStats(ctx contractapi.TransactionContextInterface) (string, error) {
+	out, err := json.Marshal(perfStats.Snapshot())
+	if err != nil {
+		return "", fmt.Errorf("GetPerfStats: %w", err)
+	}
+	return string(out), nil
 }
 
 // Evaluate-style (no ledger writes) - use this path if you're submitting through cli (peer query ...)
 func (cc *PIRChainCode) PIRQueryAuto(ctx contractapi.TransactionContextInterface) (string, error) {
-	// Option A: If you maintain an "initialized" flag:
 	dbg("\n/**************  PIR QUERY AUTO START ***********************************/")
 	start := time.Now()
 
-	if !cc.initialized {
+	ok, err := cc.isInitialized(ctx)
+	if err != nil {
+		return "", fmt.Errorf("[CC][PIR_AUTO]: %w", err)
+	}
+	if !ok {
 		return "", fmt.Errorf("[CC][PIR_AUTO]: chaincode not initialized - call InitLedger first")
 	}
 
-	logN := cc.Params.LogN()
+	params, err := cc.ensureParams(ctx)
+	if err != nil {
+		return "", fmt.Errorf("[CC][PIR_AUTO]: %w", err)
+	}
+	logN := params.LogN()
 	ctb64, ok := precomputed.B64ForLogN(logN)
 	if !ok {
 		return "", fmt.Errorf("[CC][PIR_AUTO]: no precomputed ct_q for LogN=%d", logN)
 	}
 	dbg("[CC][PIR_AUTO] using baked ct_q for LogN=%d (len=%d)", logN, len(ctb64))
-	result, _ := cc.PIRQuery(ctx, ctb64)
+	result, usage, err := cc.pirQuery(ctx, ctb64)
+	if err != nil {
+		return "", fmt.Errorf("[CC][PIR_AUTO]: %w", err)
+	}
 
 	elapsed := time.Since(start)
 	executionTime := float64(elapsed.Nanoseconds()) / 1e6
@@ -380,72 +1192,61 @@ func (cc *PIRChainCode) PIRQueryAuto(ctx contractapi.TransactionContextInterface
 	dbg("/**************  PIR QUERY AUTO END *************************************/")
 
 	// Return result with execution time
-	response := map[string]interface{}{
-		"encrypted_result":  result,
-		"execution_time_ms": executionTime,
-	}
-	responseJSON, _ := json.Marshal(response)
-	return string(responseJSON), nil
+	return utils.MarshalTimedUsage(result, start, &usage)
 }
 
-// GetStateSize(key) -> int
-func (cc *PIRChainCode) GetStateSize(ctx contractapi.TransactionContextInterface, key string) (int, error) {
-	val, err := ctx.GetStub().GetState(key)
+/**************  CAPABILITIES *****************************************/
+
+// capabilities lists what this chaincode build supports; extend it together
+// with the functions that implement each feature.
+func capabilities() utils.Capabilities {
+	c := utils.NewCapabilities(utils.FeatTimed|utils.FeatShards|utils.FeatFullDownload|utils.FeatDeltaPIR|utils.FeatMetaAndQuery|utils.FeatWindowTable|utils.FeatReserved|utils.FeatChangeFeed|utils.FeatQuery2D|utils.FeatSchemes|utils.FeatBatchQuery|utils.FeatPacking2B, utils.PackingModes, planOpts.MaxShards, []int{13, 14, 15, 16})
+	c.HE, c.Schemes = he.Default.Name(), he.Default.Schemes()
+	return c
+}
+
+// GetCapabilities returns the feature handshake (version, feature bitmask,
+// packing modes, shard limit) so clients can negotiate before querying.
+func (cc *PIRChainCode) GetCapabilities(ctx contractapi.TransactionContextInterface) (string, error) {
+	out, err := json.Marshal(capabilities())
 	if err != nil {
-		return 0, err
+		return "", fmt.Errorf("GetCapabilities: %w", err)
 	}
-	return len(val), nil
+	return string(out), nil
 }
 
-// GetHistoryForKey returns the full modification history of a key as JSON. (useful when reInit)
-func (cc *PIRChainCode) GetHistoryForKey(ctx contractapi.TransactionContextInterface, key string) (string, error) {
-	historyIter, err := ctx.GetStub().GetHistoryForKey(key)
+// Probe returns the capabilities and the hash of the committed params
+// (utils.Probe). Clients call it on connect and refuse to go on when
+// utils.Probe.Check fails, instead of failing later inside Lattigo.
+func (cc *PIRChainCode) Probe(ctx contractapi.TransactionContextInterface) (string, error) {
+	raw, err := ctx.GetStub().GetState("bgv_params")
 	if err != nil {
-		return "", fmt.Errorf("failed to get history for key %s: %v", key, err)
+		return "", fmt.Errorf("Probe: %w", err)
 	}
-	defer historyIter.Close()
-
-	var records []map[string]interface{}
-
-	for historyIter.HasNext() {
-		mod, err := historyIter.Next()
-		if err != nil {
-			return "", fmt.Errorf("error iterating history: %v", err)
-		}
-
-		record := map[string]interface{}{
-			"tx_id":        mod.TxId,
-			"is_delete":    mod.IsDelete,
-			"timestamp":    mod.Timestamp.AsTime().UTC().Format(time.RFC3339),
-			"value_length": len(mod.Value),
+	var params *utils.Metadata
+	if raw != nil {
+		var pm bgvParamsMeta
+		if err := json.Unmarshal(raw, &pm); err != nil {
+			return "", fmt.Errorf("Probe: failed to parse bgv_params: %w", err)
 		}
-
-		// Try to decode value as JSON, fallback to string
-		var decoded interface{}
-		if json.Unmarshal(mod.Value, &decoded) == nil {
-			record["value"] = decoded
-		} else {
-			record["value"] = string(mod.Value)
-		}
-
-		records = append(records, record)
+		params = &utils.Metadata{LogN: pm.LogN, N: pm.N, LogQi: pm.LogQi, LogPi: pm.LogPi, T: pm.T, Scheme: pm.Scheme, Packing: pm.Packing}
 	}
-
-	out, err := json.MarshalIndent(records, "", "  ")
+	out, err := json.Marshal(utils.NewProbe(capabilities(), params))
 	if err != nil {
-		return "", fmt.Errorf("error marshaling history: %v", err)
+		return "", fmt.Errorf("Probe: %w", err)
 	}
-
 	return string(out), nil
 }
 
 /**************  MAIN **************************************************/
 func main() {
-	cc, err := contractapi.NewChaincode(&PIRChainCode{})
+	cc, err := contractapi.NewChaincode(&PIRChainCode{
+		Contract: contractapi.Contract{BeforeTransaction: beforeTransaction},
+	})
 	if err != nil {
 		panic(fmt.Sprintf("create cc: %v", err))
 	}
-	if err := cc.Start(); err != nil {
+	if err := shim.Start(datasetChaincode{inner: cc}); err != nil {
 		panic(fmt.Sprintf("start cc: %v", err))
 	}
 }
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/he"
+	"pir_shared/utils"
+)
+
+// newTestLedger commits a generated dataset of 16 records on a fresh mock
+// stub (InitLedger, then GenerateDataset, one transaction each) and
+// returns the chaincode, a context in an open transaction and the params.
+func newTestLedger(t *testing.T, logQiJSON string) (*PIRChainCode, *contractapi.TransactionContext, he.Params) {
+	t.Helper()
+	logCfg.Store(&logConfig{})
+	cc, ctx := new(PIRChainCode), mockCtx()
+	stub := ctx.GetStub().(*shimtest.MockStub)
+
+	if _, err := cc.InitLedger(ctx, "16", "128", "13", logQiJSON, "", ""); err != nil {
+		t.Fatalf("InitLedger: %v", err)
+	}
+	stub.MockTransactionEnd("bench")
+	stub.MockTransactionStart("generate")
+	if _, err := cc.GenerateDataset(ctx); err != nil {
+		t.Fatalf("GenerateDataset: %v", err)
+	}
+	stub.MockTransactionEnd("generate")
+	stub.MockTransactionStart("query")
+
+	p, err := cc.ensureParams(ctx)
+	if err != nil {
+		t.Fatalf("ensureParams: %v", err)
+	}
+	return cc, ctx, p
+}
+
+// testQuery encrypts a selector of the first 8 slots under p and returns
+// it as Base64 at level (p.MaxLevel() for a well-formed ct_q).
+func testQuery(t *testing.T, p he.Params, level int) string {
+	t.Helper()
+	_, pk, err := he.Default.GenKeyPair(p)
+	if err != nil {
+		t.Fatalf("GenKeyPair: %v", err)
+	}
+	sel := make([]uint64, p.MaxSlots())
+	for i := 0; i < 8; i++ {
+		sel[i] = 1
+	}
+	pt, err := he.Default.Encode(p, sel)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	ct, err := he.Default.Encrypt(p, pk, pt)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if level < ct.Level() {
+		if ct, err = he.Default.ModSwitch(p, ct, level); err != nil {
+			t.Fatalf("ModSwitch: %v", err)
+		}
+	}
+	raw, err := ct.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// queryFns are the two entry points of the shared query core.
+var queryFns = []struct {
+	name string
+	call func(cc *PIRChainCode, ctx contractapi.TransactionContextInterface, q string) (string, error)
+}{
+	{"PIRQuery", (*PIRChainCode).PIRQuery},
+	{"PIRQuerySubmit", (*PIRChainCode).PIRQuerySubmit},
+}
+
+func TestPIRQuerySubmitMatchesPIRQuery(t *testing.T) {
+	cases := []struct {
+		name      string
+		logQiJSON string
+		modSwitch bool
+	}{
+		{"default params", "", false},
+		{"two Q moduli", "[54,54]", false},
+		{"two Q moduli, mod_switch", "[54,54]", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cc, ctx, p := newTestLedger(t, c.logQiJSON)
+			setTestConfig(t, ctx, runtimeConfig{Result: resultConfig{ModSwitch: c.modSwitch}})
+			q := testQuery(t, p, p.MaxLevel())
+
+			evaluated, err := cc.PIRQuery(ctx, q)
+			if err != nil {
+				t.Fatalf("PIRQuery: %v", err)
+			}
+			submitted, err := cc.PIRQuerySubmit(ctx, q)
+			if err != nil {
+				t.Fatalf("PIRQuerySubmit: %v", err)
+			}
+			if evaluated == "" || evaluated != submitted {
+				t.Errorf("ct_r differs: PIRQuery %d bytes, PIRQuerySubmit %d bytes", len(evaluated), len(submitted))
+			}
+			audit, err := ctx.GetStub().GetState(auditKey(ctx.GetStub().GetTxID()))
+			if err != nil || audit == nil {
+				t.Errorf("PIRQuerySubmit wrote no audit record (%v)", err)
+			}
+		})
+	}
+}
+
+func TestPIRQueryErrors(t *testing.T) {
+	cc, ctx, p := newTestLedger(t, "[54,54]")
+	good := testQuery(t, p, p.MaxLevel())
+
+	cases := []struct {
+		name    string
+		ledger  bool // against the committed dataset, else a fresh stub
+		query   string
+		wantErr string
+	}{
+		{"empty", true, "", "empty encQueryB64"},
+		{"bad Base64", true, "!!!!" + good[4:], "failed to decode base64 query"},
+		{"truncated", true, good[:len(good)/2], "query"},
+		{"wrong level", true, testQuery(t, p, 0), "level"},
+		{"uninitialized ledger", false, good, "InitLedger first"},
+	}
+	for _, fn := range queryFns {
+		for _, c := range cases {
+			t.Run(fn.name+"/"+c.name, func(t *testing.T) {
+				qcc, qctx := cc, contractapi.TransactionContextInterface(ctx)
+				if !c.ledger {
+					qcc, qctx = new(PIRChainCode), mockCtx()
+				}
+				out, err := fn.call(qcc, qctx, c.query)
+				if err == nil {
+					t.Fatalf("%s succeeded (%d bytes), want an error containing %q", fn.name, len(out), c.wantErr)
+				}
+				if !strings.Contains(err.Error(), c.wantErr) {
+					t.Errorf("%s: error %q, want it to contain %q", fn.name, err, c.wantErr)
+				}
+			})
+		}
+	}
+}
+
+// TestPersistDBDigest checks persistDB records the configured digest
+// algorithm next to m_DB_sha256 and refuses an unknown one instead of
+// storing a digest no reader can verify.
+func TestPersistDBDigest(t *testing.T) {
+	cc, ctx, p := newTestLedger(t, "")
+	pt, err := he.Default.Encode(p, make([]uint64, p.MaxSlots()))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	setTestConfig(t, ctx, runtimeConfig{Integrity: integrityConfig{Digest: utils.DigestBLAKE3}})
+	if err := cc.persistDB(ctx, []he.Plaintext{pt}, 16, 8, 0, nil); err != nil {
+		t.Fatalf("persistDB: %v", err)
+	}
+	if alg, _ := ctx.GetStub().GetState("m_DB_digest_alg"); string(alg) != utils.DigestBLAKE3 {
+		t.Errorf("m_DB_digest_alg = %q, want %q", alg, utils.DigestBLAKE3)
+	}
+
+	// SetConfig never stores an unknown algorithm; attach one directly.
+	bad := datasetCtx(ctx.GetStub().(*shimtest.MockStub), "")
+	bad.GetStub().(*datasetStub).cfg = &runtimeConfig{Integrity: integrityConfig{Digest: "md5"}}
+	if err := cc.persistDB(bad, []he.Plaintext{pt}, 16, 8, 0, nil); err == nil {
+		t.Error("persistDB accepted integrity.digest \"md5\"")
+	}
+}
+
+// setTestConfig stores c as the config of ctx's dataset and runs
+// beforeTransaction, as SetConfig and the next transaction would.
+func setTestConfig(t *testing.T, ctx contractapi.TransactionContextInterface, c runtimeConfig) {
+	t.Helper()
+	raw, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.GetStub().PutState(configKey, raw); err != nil {
+		t.Fatalf("PutState(%s): %v", configKey, err)
+	}
+	if err := beforeTransaction(ctx); err != nil {
+		t.Fatalf("beforeTransaction: %v", err)
+	}
+	logCfg.Store(&logConfig{})
+}
+
+// datasetCtx returns a context of one transaction on dataset name ("" the
+// default one) of stub, as datasetChaincode builds it.
+func datasetCtx(stub *shimtest.MockStub, name string) *contractapi.TransactionContext {
+	ds := &datasetStub{ChaincodeStubInterface: stub, name: name}
+	if name != "" {
+		ds.prefix = datasetArgPrefix + name + ":"
+	}
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(ds)
+	ctx.SetClientIdentity(mockIdentity{})
+	return ctx
+}
+
+// TestConfigPerDataset checks each dataset runs with its own config, also
+// after a transaction on another dataset: a query on b must not lift a's
+// approval requirement or change its digest.
+func TestConfigPerDataset(t *testing.T) {
+	logCfg.Store(&logConfig{})
+	cc, stub := new(PIRChainCode), mockCtx().GetStub().(*shimtest.MockStub)
+
+	a := datasetCtx(stub, "a")
+	if _, err := cc.InitLedger(a, "16", "128", "13", "", "", ""); err != nil {
+		t.Fatalf("InitLedger(a): %v", err)
+	}
+	if _, err := cc.GenerateDataset(a); err != nil {
+		t.Fatalf("GenerateDataset(a): %v", err)
+	}
+	setTestConfig(t, a, runtimeConfig{
+		Integrity: integrityConfig{Digest: utils.DigestBLAKE3},
+		Approval:  approvalConfig{Required: true},
+	})
+	b := datasetCtx(stub, "b")
+	if _, err := cc.InitLedger(b, "16", "128", "13", "", "", ""); err != nil {
+		t.Fatalf("InitLedger(b): %v", err)
+	}
+
+	// every later transaction gets a fresh stub; b's runs last
+	a, b = datasetCtx(stub, "a"), datasetCtx(stub, "b")
+	for _, ctx := range []contractapi.TransactionContextInterface{a, b} {
+		if err := beforeTransaction(ctx); err != nil {
+			t.Fatalf("beforeTransaction: %v", err)
+		}
+	}
+
+	if _, err := cc.AddRecord(a, `{"id":"x"}`); err == nil || !strings.Contains(err.Error(), "approval required") {
+		t.Errorf("AddRecord on a after a transaction on b: %v, want approval required", err)
+	}
+	if c := configOf(a); c.Integrity.Digest != utils.DigestBLAKE3 || !c.Approval.Required {
+		t.Errorf("config of a = %+v, want its own", *c)
+	}
+	if c := configOf(b); c.Integrity.Digest != "" || c.Approval.Required {
+		t.Errorf("config of b = %+v, want the defaults", *c)
+	}
+	if _, err := cc.GenerateDataset(b); err != nil {
+		t.Fatalf("GenerateDataset(b): %v", err)
+	}
+	if alg, _ := stub.GetState("ds:b:m_DB_digest_alg"); string(alg) != utils.DigestSHA256 {
+		t.Errorf("b committed m_DB under %q, want %q", alg, utils.DigestSHA256)
+	}
+}
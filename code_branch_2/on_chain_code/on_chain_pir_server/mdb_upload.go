@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/he"
+	"pir_shared/utils"
+)
+
+/**************  CLIENT-ENCODED m_DB UPLOAD ***************************/
+
+func mdbChunkKey(i int) string {
+	return fmt.Sprintf("mdb_chunk%03d", i)
+}
+
+// PutMDB installs an m_DB that a data owner packed and encoded locally, so
+// the endorsers never run the encode. The marshalled plaintext arrives in
+// `total` Base64 chunks, one transaction each, in order; chunks 0..total-2
+// are staged under mdbChunkKey and the last one (which must carry metaJSON,
+// a utils.MDBUpload) assembles, verifies and installs the database together
+// with its params. Records themselves are not uploaded, only their hashes
+// (putCommitment); records of the previous dataset are deleted, so
+// PublicQuery has nothing to return for such a dataset.
+func (cc *PIRChainCode) PutMDB(ctx contractapi.TransactionContextInterface,
+	seqStr, totalStr, chunkB64, metaJSON string) (string, error) {
+
+	if err := checkInitWrite(ctx, "PutMDB"); err != nil {
+		return "", err
+	}
+	seq, err1 := strconv.Atoi(seqStr)
+	total, err2 := strconv.Atoi(totalStr)
+	if err1 != nil || err2 != nil || total <= 0 || seq < 0 || seq >= total {
+		return "", fmt.Errorf("PutMDB: invalid chunk %q of %q", seqStr, totalStr)
+	}
+	chunk, err := base64.StdEncoding.DecodeString(chunkB64)
+	if err != nil {
+		return "", fmt.Errorf("PutMDB: chunk %d is not valid Base64: %w", seq, err)
+	}
+
+	if seq < total-1 {
+		if err := ctx.GetStub().PutState(mdbChunkKey(seq), chunk); err != nil {
+			return "", err
+		}
+		dbg("[CC][MDB] Staged chunk %d/%d (%d bytes)", seq+1, total, len(chunk))
+		return utils.MarshalUntimed(map[string]int{"received": seq + 1, "total": total})
+	}
+
+	dbg("\n/**************  PUT m_DB COMMIT START ************************************/")
+	var meta utils.MDBUpload
+	if err := json.Unmarshal([]byte(metaJSON), &meta); err != nil {
+		return "", fmt.Errorf("PutMDB: invalid meta JSON: %w", err)
+	}
+	hint := utils.BGVParamHint{LogN: meta.LogN, LogQi: meta.LogQi, LogPi: meta.LogPi, T: meta.T}
+	if err := checkMemBudget(meta.NRecords, meta.RecordS, hint); err != nil {
+		return "", fmt.Errorf("PutMDB: %w", err)
+	}
+
+	// ---- 1) Assemble and check the digest ----
+	var raw []byte
+	for i := 0; i < total-1; i++ {
+		part, err := ctx.GetStub().GetState(mdbChunkKey(i))
+		if err != nil || part == nil {
+			return "", fmt.Errorf("PutMDB: chunk %d missing - resend chunks in order", i)
+		}
+		raw = append(raw, part...)
+	}
+	raw = append(raw, chunk...)
+	sum := sha256.Sum256(raw)
+	if hex.EncodeToString(sum[:]) != meta.SHA256 {
+		return "", fmt.Errorf("PutMDB: m_DB digest mismatch (got %x)", sum)
+	}
+
+	// ---- 2) Params and layout ----
+	p, err := he.Default.NewParams(hint)
+	if err != nil {
+		return "", fmt.Errorf("PutMDB: %w", err)
+	}
+	// a client-encoded m_DB is a single plaintext (cpir.EncodeDB)
+	if err := utils.CheckCapacity(meta.NRecords, meta.RecordS, utils.LogSlots(p.MaxSlots()), 1); err != nil {
+		return "", fmt.Errorf("PutMDB: %w", err)
+	}
+	ic := utils.IndexContract{NRecords: meta.NRecords, RecordS: meta.RecordS, Slots: p.MaxSlots()}
+	if err := ic.Validate(); err != nil {
+		return "", fmt.Errorf("PutMDB: %w", err)
+	}
+	if len(meta.RecordHashes) != meta.NRecords {
+		return "", fmt.Errorf("PutMDB: %d record hashes for n=%d", len(meta.RecordHashes), meta.NRecords)
+	}
+
+	// ---- 3) The plaintext must be a max-level plaintext of p ----
+	pt, err := he.Default.UnmarshalPlaintext(p, raw)
+	if err != nil {
+		return "", fmt.Errorf("PutMDB: failed to unmarshal m_DB: %w", err)
+	}
+
+	// ---- 4) Persist: params, spec, hashes, m_DB ----
+	pm, _ := json.Marshal(bgvParamsMeta{
+		LogN:  p.LogN(),
+		N:     p.N(),
+		LogQi: p.LogQi(),
+		LogPi: p.LogPi(),
+		T:     p.PlaintextModulus(),
+	})
+	if err := ctx.GetStub().PutState("bgv_params", pm); err != nil {
+		return "", err
+	}
+	spec, _ := json.Marshal(datasetSpec{N: meta.NRecords, MaxJSON: meta.RecordS})
+	if err := ctx.GetStub().PutState("dataset_spec", spec); err != nil {
+		return "", err
+	}
+	if err := putCodeProvenance(ctx); err != nil {
+		return "", err
+	}
+	if _, err := putCommitment(ctx, meta.RecordHashes); err != nil {
+		return "", err
+	}
+	if err := clearStage(ctx); err != nil {
+		return "", fmt.Errorf("PutMDB: %w", err)
+	}
+	// GetState still reads the replaced dataset_spec: the old records are
+	// deleted from wherever it kept them.
+	if oldN, err := ctx.GetStub().GetState("n"); err == nil && oldN != nil {
+		collection, err := recordCollection(ctx)
+		if err != nil {
+			return "", fmt.Errorf("PutMDB: %w", err)
+		}
+		n, _ := strconv.Atoi(string(oldN))
+		for i := 0; i < n; i++ {
+			if err := delRecord(ctx, collection, utils.RecordKey(i)); err != nil {
+				return "", err
+			}
+		}
+	}
+	for i := 0; i < total-1; i++ {
+		if err := ctx.GetStub().DelState(mdbChunkKey(i)); err != nil {
+			return "", err
+		}
+	}
+
+	c := cc.cache(ctx)
+	c.mu.Lock()
+	c.Params, c.paramsRaw = p, pm
+	c.mu.Unlock()
+	if err := cc.persistDB(ctx, []he.Plaintext{pt}, meta.NRecords, meta.RecordS, 0, nil); err != nil {
+		return "", fmt.Errorf("PutMDB: %w", err)
+	}
+
+	dbg("[CC][MDB] Installed m_DB: n=%d record_s=%d LogN=%d (%d bytes in %d chunks)",
+		meta.NRecords, meta.RecordS, p.LogN(), len(raw), total)
+	dbg("/**************  PUT m_DB COMMIT END **************************************/")
+	return utils.MarshalUntimed("success")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/utils"
+)
+
+/**************  ORPHAN CLEANUP ***************************************/
+
+// A failed transaction commits nothing, so one InitLedger cannot leave
+// half a dataset behind. Orphans come from the multi-transaction flows:
+// a re-init with a smaller n, or an InitLedger / InitBegin whose
+// GenerateDataset / InitCommit never came, leaves record keys that no
+// committed metadata covers; an abandoned InitBegin session leaves staged
+// records; a dataset that shrank to fewer shards leaves m_DB shards.
+// CleanupOrphans finds them with one range scan and deletes them. PutMDB
+// upload chunks cannot be told apart from an upload in progress, so they
+// are counted but kept (a new PutMDB overwrites them).
+
+// OrphanReport is the result of CleanupOrphans.
+type OrphanReport struct {
+	DryRun       bool     `json:"dry_run"`
+	Keys         []string `json:"keys"` // deleted, or to be deleted with dry_run
+	Bytes        int      `json:"bytes"`
+	UploadChunks int      `json:"upload_chunks,omitempty"` // pending PutMDB chunks, kept
+}
+
+// CleanupOrphans deletes the keys no committed metadata, staging session
+// or shard count covers and lists them; dryRun "true" only lists them.
+func (cc *PIRChainCode) CleanupOrphans(ctx contractapi.TransactionContextInterface, dryRunStr string) (string, error) {
+	report := OrphanReport{Keys: []string{}}
+	if dryRunStr != "" {
+		var err error
+		if report.DryRun, err = strconv.ParseBool(dryRunStr); err != nil {
+			return "", fmt.Errorf("CleanupOrphans: dry run must be true or false: %w", err)
+		}
+	}
+	orphan, err := cc.orphanRule(ctx)
+	if err != nil {
+		return "", fmt.Errorf("CleanupOrphans: %w", err)
+	}
+
+	iter, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return "", fmt.Errorf("CleanupOrphans: %w", err)
+	}
+	defer iter.Close()
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return "", fmt.Errorf("CleanupOrphans: %w", err)
+		}
+		if strings.HasPrefix(kv.Key, "mdb_chunk") {
+			report.UploadChunks++
+			continue
+		}
+		if orphan(kv.Key) {
+			report.Keys = append(report.Keys, kv.Key)
+			report.Bytes += len(kv.Value)
+		}
+	}
+	if !report.DryRun {
+		for _, key := range report.Keys {
+			if err := ctx.GetStub().DelState(key); err != nil {
+				return "", fmt.Errorf("CleanupOrphans: %w", err)
+			}
+		}
+	}
+
+	dbg("[CC][ORPHANS] %d orphaned keys, %d bytes (dry run %v, %d upload chunks kept)",
+		len(report.Keys), report.Bytes, report.DryRun, report.UploadChunks)
+	return utils.MarshalUntimed(report)
+}
+
+// orphanRule returns the predicate CleanupOrphans applies to each key,
+// from the committed live record count, staging session and shard count.
+func (cc *PIRChainCode) orphanRule(ctx contractapi.TransactionContextInterface) (func(string) bool, error) {
+	live := 0 // no committed dataset: every record key is an orphan
+	nRaw, err := ctx.GetStub().GetState("n")
+	if err != nil {
+		return nil, err
+	}
+	if nRaw != nil {
+		meta, err := cc.loadMetadata(ctx)
+		if err != nil {
+			return nil, err
+		}
+		live = meta.Live()
+	}
+	staged := 0 // no open session: every staged record is an orphan
+	if raw, err := ctx.GetStub().GetState(stageCountKey); err != nil {
+		return nil, err
+	} else if raw != nil {
+		staged, _ = strconv.Atoi(string(raw))
+	}
+	shards, err := mdbShards(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(key string) bool {
+		switch storageFamily(key) {
+		case "records":
+			i, ok := utils.ParseRecordIndex(key)
+			return !ok || utils.RecordKey(i) != key || i < 0 || i >= live
+		case "init_staging":
+			if key == stageCountKey || key == rejectedCountKey {
+				return false
+			}
+			i, err := strconv.Atoi(strings.TrimPrefix(key, stageKeyPrefix))
+			return err != nil || stageKey(i) != key || i < 0 || i >= staged
+		case "m_DB":
+			var k int
+			if _, err := fmt.Sscanf(key, "m_DB_%03d", &k); err != nil || mdbKey(k) != key {
+				return false // m_DB itself, its digest, version and count
+			}
+			return k >= shards
+		}
+		return false
+	}, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/he"
+	"pir_shared/utils"
+)
+
+/**************  RECORD PACKING ***************************************/
+
+// Records are packed one byte per slot unless SetPacking picks "2b", two
+// bytes per slot (utils/packing.go): record_s halves and the same logN
+// holds twice the records, at the cost of slot values up to 65535, which
+// T must exceed. Like SetScheme it runs between InitLedger/InitBegin and
+// GenerateDataset/InitCommit; LoadRecordsFromJSON packs in the same
+// transaction and stays "1b". The mode goes into dataset_spec, which
+// storeAndPack packs with, and bgv_params, from which GetMetadata reports
+// it; appends, batches, deltas and full downloads follow the metadata.
+
+// SetPacking sets the packing mode ("1b" or "2b"; empty is 1b) of the
+// current dataset and returns the new dataset_spec. It is refused once
+// the dataset is packed.
+func (cc *PIRChainCode) SetPacking(ctx contractapi.TransactionContextInterface, packing string) (string, error) {
+	mode, err := utils.ParsePacking(packing)
+	if err != nil {
+		return "", fmt.Errorf("SetPacking: %w", err)
+	}
+	spec, err := loadSpec(ctx)
+	if err != nil {
+		return "", fmt.Errorf("SetPacking: %w", err)
+	}
+	nRaw, err := ctx.GetStub().GetState("n")
+	if err != nil {
+		return "", err
+	}
+	if nRaw != nil {
+		return "", fmt.Errorf("SetPacking: dataset already packed - call InitLedger or InitBegin first")
+	}
+	raw, err := ctx.GetStub().GetState("bgv_params")
+	if err != nil || raw == nil {
+		return "", fmt.Errorf("SetPacking: bgv_params not found in world state - call InitLedger first")
+	}
+	var pm bgvParamsMeta
+	if err := json.Unmarshal(raw, &pm); err != nil {
+		return "", fmt.Errorf("SetPacking: failed to parse bgv_params: %w", err)
+	}
+
+	p, err := cc.ensureParams(ctx)
+	if err != nil {
+		return "", fmt.Errorf("SetPacking: %w", err)
+	}
+	if err := utils.CheckPacking(mode, he.SchemeOf(p), p.PlaintextModulus()); err != nil {
+		return "", fmt.Errorf("SetPacking: %w", err)
+	}
+	logSlots := utils.LogSlots(p.MaxSlots())
+	if err := utils.CheckCapacity(spec.N+spec.Reserve, utils.PackedSlots(spec.MaxJSON, mode), logSlots, planOpts.MaxShards); err != nil {
+		return "", fmt.Errorf("SetPacking: %s: %w", mode, err)
+	}
+
+	// "1b" is stored as absent, like datasets that predate the mode
+	spec.Packing, pm.Packing = "", ""
+	if mode != utils.Packing1B {
+		spec.Packing, pm.Packing = mode, mode
+	}
+	specRaw, _ := json.Marshal(spec)
+	if err := ctx.GetStub().PutState("dataset_spec", specRaw); err != nil {
+		return "", err
+	}
+	raw, _ = json.Marshal(pm)
+	if err := ctx.GetStub().PutState("bgv_params", raw); err != nil {
+		return "", err
+	}
+	c := cc.cache(ctx)
+	c.mu.Lock()
+	c.paramsRaw = raw
+	c.mu.Unlock()
+
+	dbg("[CC][PACKING] %s: record_s=%d for max_json=%d on LogN=%d (T=%d)",
+		mode, utils.PackedSlots(spec.MaxJSON, mode), spec.MaxJSON, p.LogN(), p.PlaintextModulus())
+	return utils.MarshalUntimed(spec)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/utils"
+)
+
+/**************  PRIVATE RECORDS **************************************/
+
+// A dataset can keep its JSON records in a Fabric private data collection
+// instead of world state, for consortia whose CTI may only be read in the
+// clear by some orgs. SetRecordCollection, between InitLedger/InitBegin
+// and the first record, names the collection (from the chaincode's
+// collection config) and the MSPs allowed to read records back; it lives
+// in dataset_spec. Every record key, staged record and staged op of the
+// dataset is then written with PutPrivateData: only the collection's
+// member peers store them, the public write set carries their hashes.
+// m_DB, record_hashes and records_root stay in world state, so PIR
+// queries and commitment checks run on every peer as before.
+//
+// PublicQuery (and PublicQuerySubmit) refuse such a dataset;
+// GetPrivateRecord serves its records to the listed MSPs, on member
+// peers, and GetFullDatasetChunk applies the same MSP check. Functions
+// that take records (InitAddRecords, AddCTIRecord, ApplyRecordBatch,
+// StageRecordOps) read them from the transient map under
+// recordsTransientKey and refuse them as an argument, which every block
+// would carry. Functions that read records back (ApplyRecordBatch,
+// Publish, InitCommit) must be endorsed by member peers.
+//
+// m_DB encodes the records slot by slot: whoever reads the m_DB keys
+// from a peer's state database can still decode them. The collection
+// keeps the JSON records out of the public read APIs and out of the
+// blocks, not out of m_DB.
+
+// recordsTransientKey carries the record-bearing argument of a private
+// dataset's ingestion functions in the transient map.
+const recordsTransientKey = "records"
+
+// recordCollection returns the record collection of the committed
+// dataset_spec: "" for public records, or when no dataset_spec is
+// committed yet.
+func recordCollection(ctx contractapi.TransactionContextInterface) (string, error) {
+	raw, err := ctx.GetStub().GetState("dataset_spec")
+	if err != nil {
+		return "", fmt.Errorf("failed to read dataset_spec from ledger: %w", err)
+	}
+	if raw == nil {
+		return "", nil
+	}
+	var spec datasetSpec
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		return "", fmt.Errorf("failed to parse dataset_spec: %w", err)
+	}
+	return spec.Collection, nil
+}
+
+// putRecord, getRecord and delRecord access key in world state, or in
+// collection when it is not "".
+func putRecord(ctx contractapi.TransactionContextInterface, collection, key string, value []byte) error {
+	if collection != "" {
+		return ctx.GetStub().PutPrivateData(collection, key, value)
+	}
+	return ctx.GetStub().PutState(key, value)
+}
+
+func getRecord(ctx contractapi.TransactionContextInterface, collection, key string) ([]byte, error) {
+	if collection != "" {
+		return ctx.GetStub().GetPrivateData(collection, key)
+	}
+	return ctx.GetStub().GetState(key)
+}
+
+func delRecord(ctx contractapi.TransactionContextInterface, collection, key string) error {
+	if collection != "" {
+		return ctx.GetStub().DelPrivateData(collection, key)
+	}
+	return ctx.GetStub().DelState(key)
+}
+
+// recordArg returns arg, the record-bearing argument of an ingestion
+// function, or the transient map's recordsTransientKey value when arg is
+// empty. A private dataset refuses a non-empty arg.
+func recordArg(ctx contractapi.TransactionContextInterface, arg string) (string, error) {
+	collection, err := recordCollection(ctx)
+	if err != nil {
+		return "", err
+	}
+	if arg != "" {
+		if collection != "" {
+			return "", fmt.Errorf("records of this dataset are private (collection %q): pass them in the transient map under %q, not as an argument",
+				collection, recordsTransientKey)
+		}
+		return arg, nil
+	}
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", fmt.Errorf("transient map: %w", err)
+	}
+	return string(transient[recordsTransientKey]), nil
+}
+
+// checkRecordReader fails unless the caller's MSP is one spec lets read
+// its private records.
+func checkRecordReader(ctx contractapi.TransactionContextInterface, spec datasetSpec) error {
+	msp, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("caller MSP: %w", err)
+	}
+	for _, m := range spec.CollectionMSPs {
+		if m == msp {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s may not read the records of collection %q (readers: %v)", msp, spec.Collection, spec.CollectionMSPs)
+}
+
+// checkNoRecords fails once the dataset is packed or a chunked init has
+// staged records, for the dataset_spec settings every record must share.
+func checkNoRecords(ctx contractapi.TransactionContextInterface) error {
+	nRaw, err := ctx.GetStub().GetState("n")
+	if err != nil {
+		return err
+	}
+	if nRaw != nil {
+		return fmt.Errorf("dataset already holds records - call InitLedger or InitBegin first")
+	}
+	stagedRaw, err := ctx.GetStub().GetState(stageCountKey)
+	if err != nil {
+		return err
+	}
+	if stagedRaw != nil {
+		staged, rejected, err := stagedCount(ctx)
+		if err != nil {
+			return err
+		}
+		if staged+rejected > 0 {
+			return fmt.Errorf("%d records already staged", staged+rejected)
+		}
+	}
+	return nil
+}
+
+// SetRecordCollection keeps the current dataset's records in the private
+// data collection collection, readable through GetPrivateRecord by the
+// MSPs of mspsJSON (a non-empty JSON array of MSP IDs); collection ""
+// keeps them in world state. It returns the new dataset_spec and is
+// refused once the dataset holds or has staged records.
+func (cc *PIRChainCode) SetRecordCollection(ctx contractapi.TransactionContextInterface, collection, mspsJSON string) (string, error) {
+	var msps []string
+	if collection != "" {
+		if err := json.Unmarshal([]byte(mspsJSON), &msps); err != nil {
+			return "", fmt.Errorf("SetRecordCollection: invalid MSP list JSON: %w", err)
+		}
+		if len(msps) == 0 {
+			return "", fmt.Errorf("SetRecordCollection: at least one MSP must be allowed to read collection %q", collection)
+		}
+		for _, m := range msps {
+			if m == "" {
+				return "", fmt.Errorf("SetRecordCollection: empty MSP ID")
+			}
+		}
+	} else if mspsJSON != "" {
+		return "", fmt.Errorf("SetRecordCollection: MSP list given without a collection")
+	}
+
+	spec, err := loadSpec(ctx)
+	if err != nil {
+		return "", fmt.Errorf("SetRecordCollection: %w", err)
+	}
+	if err := checkNoRecords(ctx); err != nil {
+		return "", fmt.Errorf("SetRecordCollection: %w", err)
+	}
+
+	spec.Collection, spec.CollectionMSPs = collection, msps
+	raw, _ := json.Marshal(spec)
+	if err := ctx.GetStub().PutState("dataset_spec", raw); err != nil {
+		return "", err
+	}
+	dbg("[CC][PRIVATE] Records in collection %q, readable by %v", collection, msps)
+	return utils.MarshalUntimed(spec)
+}
+
+// GetPrivateRecord is PublicQueryTimed for a dataset whose records are in
+// a private data collection: the caller's MSP must be one of the readers
+// set by SetRecordCollection, and the endorsing peer a member of the
+// collection.
+func (cc *PIRChainCode) GetPrivateRecord(ctx contractapi.TransactionContextInterface, key string) (string, error) {
+	start := time.Now()
+	if key == "" {
+		return "", fmt.Errorf("GetPrivateRecord: %w: key must not be empty", utils.ErrInvalidKey)
+	}
+	spec, err := loadSpec(ctx)
+	if err != nil {
+		return "", fmt.Errorf("GetPrivateRecord: %w", err)
+	}
+	if spec.Collection == "" {
+		return "", fmt.Errorf("GetPrivateRecord: records of this dataset are public - use PublicQuery")
+	}
+	if err := checkRecordReader(ctx, spec); err != nil {
+		return "", fmt.Errorf("GetPrivateRecord: %w", err)
+	}
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		return "", fmt.Errorf("GetPrivateRecord: %w", err)
+	}
+	ic := utils.NewIndexContract(meta)
+	idx, err := publicKey(ic, key)
+	if err != nil {
+		return "", fmt.Errorf("GetPrivateRecord: %w", err)
+	}
+
+	b, err := ctx.GetStub().GetPrivateData(spec.Collection, key)
+	if err != nil {
+		return "", fmt.Errorf("GetPrivateRecord: collection %q: %w", spec.Collection, err)
+	}
+	cc.cache(ctx).access.AddPublicRead(key, txTime(ctx))
+	if b == nil {
+		if ic.IsReserved(idx) {
+			return utils.MarshalTimed([]byte(utils.EmptyRecord), start)
+		}
+		return "", fmt.Errorf("GetPrivateRecord: %w: %s not on this peer (not a member of collection %q?)",
+			utils.ErrRecordNotFound, key, spec.Collection)
+	}
+	dbg("[CC][PRIVATE] %s read from collection %q", key, spec.Collection)
+	return utils.MarshalTimed(b, start)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/he"
+)
+
+/**************  CODE PROVENANCE **************************************/
+
+// A channel's chaincode definition does not pin a package: every org
+// installs its own and approves only name/version/sequence. ccpack
+// (pir_shared/cmd/ccpack) builds a reproducible package and compiles the
+// digest of the source it packed into the binary (zz_provenance.go sets
+// codeSHA256). Every dataset init writes that digest to "code_provenance",
+// so endorsers running different code produce different write sets and
+// the init fails endorsement; GetCodeProvenance lets each org compare its
+// own peer against the committed value at any time.
+
+// codeSHA256 is the ccpack code digest, "" in builds not made by ccpack
+// (go run, run-dev.sh).
+var codeSHA256 string
+
+// codeProvenance is the JSON stored under "code_provenance".
+type codeProvenance struct {
+	CodeSHA256 string `json:"code_sha256"` // "" = not packaged with ccpack
+	HE         string `json:"he"`          // he.Engine Name
+	TxID       string `json:"tx_id"`       // init transaction that wrote it
+}
+
+func runningProvenance() codeProvenance {
+	return codeProvenance{CodeSHA256: codeSHA256, HE: he.Default.Name()}
+}
+
+// putCodeProvenance records this peer's code digest; called by every
+// dataset init (beginDataset, PutMDB).
+func putCodeProvenance(ctx contractapi.TransactionContextInterface) error {
+	p := runningProvenance()
+	p.TxID = ctx.GetStub().GetTxID()
+	raw, _ := json.Marshal(p)
+	if err := ctx.GetStub().PutState("code_provenance", raw); err != nil {
+		return err
+	}
+	dbg("[CC][PROVENANCE] code_sha256=%q he=%s", p.CodeSHA256, p.HE)
+	return nil
+}
+
+// GetCodeProvenance returns the committed "code_provenance" and this
+// peer's own, with match = same code digest and HE library. Evaluate it on
+// a peer of each org to check they run what was initialised.
+func (cc *PIRChainCode) GetCodeProvenance(ctx contractapi.TransactionContextInterface) (string, error) {
+	raw, err := ctx.GetStub().GetState("code_provenance")
+	if err != nil {
+		return "", fmt.Errorf("GetCodeProvenance: %w", err)
+	}
+	out := struct {
+		Recorded *codeProvenance `json:"recorded"` // null before the first init
+		Running  codeProvenance  `json:"running"`
+		Match    bool            `json:"match"`
+	}{Running: runningProvenance()}
+	if raw != nil {
+		out.Recorded = &codeProvenance{}
+		if err := json.Unmarshal(raw, out.Recorded); err != nil {
+			return "", fmt.Errorf("GetCodeProvenance: %w", err)
+		}
+		out.Match = out.Recorded.CodeSHA256 == out.Running.CodeSHA256 && out.Recorded.HE == out.Running.HE
+	}
+	b, _ := json.Marshal(out)
+	return string(b), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/utils"
+)
+
+/**************  PSEUDONYMIZATION *************************************/
+
+// A dataset can name record fields (reporter org, internal IDs) whose
+// values are replaced by utils.Pseudonym before records are staged or
+// packed, so public record reads do not reveal who contributed an
+// indicator. The list lives in dataset_spec and is set with
+// SetPseudonymFields between InitLedger/InitBegin and the first record;
+// every ingestion path (InitAddRecords, AddCTIRecord, ApplyRecordBatch,
+// StageRecordOps) then applies it. The HMAC key is PIR_PSEUDONYM_KEY in
+// the chaincode container's environment and never touches the ledger;
+// endorsing peers must share it or their write sets will differ.
+
+var pseudonymKey = os.Getenv("PIR_PSEUDONYM_KEY")
+
+// specSanitizeOpts returns sanitizeOpts with the pseudonymization of spec.
+func specSanitizeOpts(spec datasetSpec) (utils.SanitizeOptions, error) {
+	opt := sanitizeOpts
+	if len(spec.Pseudonymize) == 0 {
+		return opt, nil
+	}
+	if pseudonymKey == "" {
+		return opt, fmt.Errorf("dataset pseudonymizes %v but PIR_PSEUDONYM_KEY is not set", spec.Pseudonymize)
+	}
+	opt.PseudonymFields = spec.Pseudonymize
+	opt.PseudonymKey = []byte(pseudonymKey)
+	return opt, nil
+}
+
+// recordSanitizeOpts is specSanitizeOpts for the committed dataset_spec.
+func recordSanitizeOpts(ctx contractapi.TransactionContextInterface) (utils.SanitizeOptions, error) {
+	spec, err := loadSpec(ctx)
+	if err != nil {
+		return utils.SanitizeOptions{}, err
+	}
+	return specSanitizeOpts(spec)
+}
+
+// SetPseudonymFields sets the fields the current dataset pseudonymizes:
+// a JSON array of field names, "" for utils.DefaultPseudonymFields, "[]"
+// to turn the stage off. It is refused once the dataset holds records or
+// a chunked init has staged any, since earlier records would keep their
+// clear values.
+func (cc *PIRChainCode) SetPseudonymFields(ctx contractapi.TransactionContextInterface, fieldsJSON string) (string, error) {
+	fields := utils.DefaultPseudonymFields
+	if fieldsJSON != "" {
+		fields = nil
+		if err := json.Unmarshal([]byte(fieldsJSON), &fields); err != nil {
+			return "", fmt.Errorf("SetPseudonymFields: invalid fields JSON: %w", err)
+		}
+	}
+	if err := utils.CheckPseudonymFields(fields); err != nil {
+		return "", fmt.Errorf("SetPseudonymFields: %w", err)
+	}
+	if len(fields) > 0 && pseudonymKey == "" {
+		return "", fmt.Errorf("SetPseudonymFields: PIR_PSEUDONYM_KEY is not set")
+	}
+
+	spec, err := loadSpec(ctx)
+	if err != nil {
+		return "", fmt.Errorf("SetPseudonymFields: %w", err)
+	}
+	if err := checkNoRecords(ctx); err != nil {
+		return "", fmt.Errorf("SetPseudonymFields: %w", err)
+	}
+
+	spec.Pseudonymize = fields
+	raw, _ := json.Marshal(spec)
+	if err := ctx.GetStub().PutState("dataset_spec", raw); err != nil {
+		return "", err
+	}
+	dbg("[CC][PSEUDO] Pseudonymizing %d fields: %v", len(fields), fields)
+	return utils.MarshalUntimed(spec)
+}
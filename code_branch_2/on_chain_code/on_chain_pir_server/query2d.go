@@ -0,0 +1,18 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+/**************  2D QUERY **********************************************/
+
+// PIRQuery2D is PIRQuery for a square-root query (utils/grid.go): the
+// row and column selector ciphertexts of the target's grid cell
+// (cpir.EncryptQuery2D) are multiplied together and the product with
+// every m_DB shard, so the response has PIRQuery's shape with degree-2
+// ciphertexts (cpir.DecryptResult2D). The params need log Q of at least
+// utils.Min2DLogQ bits; the default single 54-bit modulus is refused.
+func (cc *PIRChainCode) PIRQuery2D(ctx contractapi.TransactionContextInterface, rowQueryB64, colQueryB64 string) (string, error) {
+	out, _, err := cc.evalQueries(ctx, "PIRQuery2D", []string{rowQueryB64, colQueryB64}, cc.ensureDB)
+	return out, err
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/gen_records"
+	"pir_shared/he"
+	"pir_shared/utils"
+)
+
+/**************  RECORD COMMITMENT ************************************/
+
+// "record_hashes" holds utils.RecordHash of every record in index order and
+// "records_root" their utils.MerkleRoot. Every path that (re)writes m_DB
+// refreshes both, so clients can check a decrypted record against the root.
+
+func putCommitment(ctx contractapi.TransactionContextInterface, hashes []string) (string, error) {
+	raw, _ := json.Marshal(hashes)
+	if err := ctx.GetStub().PutState("record_hashes", raw); err != nil {
+		return "", err
+	}
+	root := utils.MerkleRoot(hashes)
+	return root, ctx.GetStub().PutState("records_root", []byte(root))
+}
+
+// loadRecordHashes returns the committed hashes, rebuilding them from the
+// stored records for datasets created before the commitment existed.
+func loadRecordHashes(ctx contractapi.TransactionContextInterface, n int) ([]string, error) {
+	raw, err := ctx.GetStub().GetState("record_hashes")
+	if err != nil {
+		return nil, err
+	}
+	var hashes []string
+	if raw != nil {
+		if err := json.Unmarshal(raw, &hashes); err != nil {
+			return nil, fmt.Errorf("failed to parse record_hashes: %w", err)
+		}
+		return hashes, nil
+	}
+	collection, err := recordCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hashes = make([]string, n)
+	for i := range hashes {
+		rec, err := getRecord(ctx, collection, utils.RecordKey(i))
+		if err != nil || rec == nil {
+			return nil, fmt.Errorf("record %s missing, cannot rebuild record_hashes", utils.RecordKey(i))
+		}
+		hashes[i] = utils.RecordHash(rec)
+	}
+	return hashes, nil
+}
+
+// GetCommitment returns the record count and Merkle root of the dataset.
+func (cc *PIRChainCode) GetCommitment(ctx contractapi.TransactionContextInterface) (string, error) {
+	start := time.Now()
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	root, err := ctx.GetStub().GetState("records_root")
+	if err != nil || root == nil {
+		return "", fmt.Errorf("GetCommitment: records_root not found in world state")
+	}
+	out := map[string]interface{}{"n": meta.Live(), "root": string(root)}
+	if meta.ReservedFrom > 0 {
+		out["reserved"] = meta.NRecords - meta.ReservedFrom
+	}
+	return utils.MarshalTimed(out, start)
+}
+
+/**************  ADD CTI RECORD ***************************************/
+
+// CTIRecordAddedEvent is the payload of the "CTIRecordAdded" chaincode event.
+type CTIRecordAddedEvent struct {
+	Index   int    `json:"index"`
+	Key     string `json:"key"`
+	N       int    `json:"n"`
+	RecordS int    `json:"record_s"`
+	Hash    string `json:"hash"`
+	Root    string `json:"root"`
+}
+
+// AddCTIRecord writes one record at index (0..live-1 overwrites, live
+// appends; live is n, or reserved_from when indices are reserved, and an
+// append fills the first reserved window instead of growing n).
+// The record is sanitized, validated against the schema of the dataset's
+// logN and padded to record_s; then its m_DB slot window is rewritten, the
+// commitment refreshed and a "CTIRecordAdded" event emitted. record_s is
+// fixed, so a record that does not fit the window is rejected.
+func (cc *PIRChainCode) AddCTIRecord(ctx contractapi.TransactionContextInterface, indexStr, recordJSON string) (string, error) {
+	dbg("\n/**************  ADD CTI RECORD START *************************************/")
+	if err := checkDirectWrite(ctx, "AddCTIRecord"); err != nil {
+		return "", err
+	}
+
+	params, pts, err := cc.ensureDB(ctx)
+	if err != nil {
+		return "", fmt.Errorf("AddCTIRecord: %w", err)
+	}
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		return "", fmt.Errorf("AddCTIRecord: %w", err)
+	}
+	live := meta.Live()
+	index, err := strconv.Atoi(indexStr)
+	if err != nil || index < 0 || index > live {
+		return "", fmt.Errorf("AddCTIRecord: index %q out of range 0..%d", indexStr, live)
+	}
+	op := utils.ChangeUpdate
+	if index == live {
+		op = utils.ChangeAdd
+		live++
+	}
+	n := indexSpace(meta, live)
+	reservedFrom := utils.ReservedFromLive(live, n)
+
+	// ---- 1) Sanitize → schema → pad to record_s ----
+	if recordJSON, err = recordArg(ctx, recordJSON); err != nil {
+		return "", fmt.Errorf("AddCTIRecord: %w", err)
+	}
+	opt, err := recordSanitizeOpts(ctx)
+	if err != nil {
+		return "", fmt.Errorf("AddCTIRecord: %w", err)
+	}
+	rec, err := prepareRecord(opt, params.LogN(), utils.NewIndexContract(meta).RecordBytes(), index, []byte(recordJSON))
+	if err != nil {
+		return "", fmt.Errorf("AddCTIRecord: %w", err)
+	}
+
+	// ---- 2) Layout for the (possibly grown) dataset ----
+	if err := utils.CheckCapacity(n, meta.RecordS, utils.LogSlots(params.MaxSlots()), planOpts.MaxShards); err != nil {
+		return "", fmt.Errorf("AddCTIRecord: %w", err)
+	}
+	ic := utils.IndexContract{NRecords: n, RecordS: meta.RecordS, Slots: params.MaxSlots(), ReservedFrom: reservedFrom, Packing: meta.Packing}
+	if err := ic.ValidateSharded(); err != nil {
+		return "", fmt.Errorf("AddCTIRecord: %w", err)
+	}
+	win, err := ic.Describe(index)
+	if err != nil {
+		return "", fmt.Errorf("AddCTIRecord: %w", err)
+	}
+	winStart, winEnd := win.StartSlot, win.EndSlot
+
+	// ---- 3) Rewrite the slot window of its m_DB shard (an append past
+	// the last shard opens a new one) ----
+	var vec []uint64
+	if win.Shard < len(pts) {
+		if vec, err = he.Default.Decode(params, pts[win.Shard]); err != nil {
+			return "", fmt.Errorf("AddCTIRecord: failed to decode m_DB shard %d: %w", win.Shard, err)
+		}
+	} else {
+		vec = make([]uint64, params.MaxSlots())
+	}
+	clear(vec[winStart:winEnd])
+	ic.PackWindow(vec[winStart:winEnd], rec)
+	ptShard, err := he.Default.Encode(params, vec)
+	if err != nil {
+		return "", fmt.Errorf("AddCTIRecord: failed to encode m_DB shard %d: %w", win.Shard, err)
+	}
+	ptNew := make([]he.Plaintext, max(len(pts), win.Shard+1))
+	copy(ptNew, pts)
+	ptNew[win.Shard] = ptShard
+
+	// ---- 4) Persist record, m_DB and commitment ----
+	collection, err := recordCollection(ctx)
+	if err != nil {
+		return "", fmt.Errorf("AddCTIRecord: %w", err)
+	}
+	key := utils.RecordKey(index)
+	if err := putRecord(ctx, collection, key, rec); err != nil {
+		return "", err
+	}
+	hashes, err := loadRecordHashes(ctx, meta.Live())
+	if err != nil {
+		return "", fmt.Errorf("AddCTIRecord: %w", err)
+	}
+	if len(hashes) != meta.Live() {
+		return "", fmt.Errorf("AddCTIRecord: record_hashes has %d entries, %d live records", len(hashes), meta.Live())
+	}
+	hash := utils.RecordHash(rec)
+	if index == len(hashes) {
+		hashes = append(hashes, hash)
+	} else {
+		hashes[index] = hash
+	}
+	root, err := putCommitment(ctx, hashes)
+	if err != nil {
+		return "", err
+	}
+	if err := cc.persistDB(ctx, ptNew, n, meta.RecordS, reservedFrom, []utils.RecordChange{{Index: index, Op: op}}); err != nil {
+		return "", fmt.Errorf("AddCTIRecord: %w", err)
+	}
+
+	// ---- 5) Event ----
+	ev, _ := json.Marshal(CTIRecordAddedEvent{
+		Index: index, Key: key, N: n, RecordS: meta.RecordS, Hash: hash, Root: root,
+	})
+	if err := ctx.GetStub().SetEvent("CTIRecordAdded", ev); err != nil {
+		return "", err
+	}
+
+	dbg("[CC][ADD] %s written (n=%d, shard %d slots [%d:%d), root=%s)", key, n, win.Shard, winStart, winEnd, root)
+	dbg("/**************  ADD CTI RECORD END ***************************************/")
+	return utils.MarshalUntimed(ev)
+}
+
+/**************  SINGLE-RECORD EDITS **********************************/
+
+// AddRecord, UpdateRecord and DeleteRecord keep a dataset fresh one record
+// at a time, without a full InitLedger. Add and update rewrite only the
+// record's slot window in its m_DB shard (AddCTIRecord); a delete closes
+// the gap, so the windows from index on are re-packed (ApplyRecordBatch).
+// Each bumps m_DB_version and lands in the change feed.
+
+// AddRecord appends recordJSON at the first free index (n, or the first
+// reserved window) and returns the CTIRecordAdded event.
+func (cc *PIRChainCode) AddRecord(ctx contractapi.TransactionContextInterface, recordJSON string) (string, error) {
+	if err := checkDirectWrite(ctx, "AddRecord"); err != nil {
+		return "", err
+	}
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		return "", fmt.Errorf("AddRecord: %w", err)
+	}
+	return cc.AddCTIRecord(ctx, strconv.Itoa(meta.Live()), recordJSON)
+}
+
+// UpdateRecord overwrites live record index with recordJSON and returns
+// the CTIRecordAdded event.
+func (cc *PIRChainCode) UpdateRecord(ctx contractapi.TransactionContextInterface, indexStr, recordJSON string) (string, error) {
+	if err := checkDirectWrite(ctx, "UpdateRecord"); err != nil {
+		return "", err
+	}
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		return "", fmt.Errorf("UpdateRecord: %w", err)
+	}
+	index, err := strconv.Atoi(indexStr)
+	if err != nil || index < 0 || index >= meta.Live() {
+		return "", fmt.Errorf("UpdateRecord: index %q out of range 0..%d", indexStr, meta.Live()-1)
+	}
+	return cc.AddCTIRecord(ctx, indexStr, recordJSON)
+}
+
+// DeleteRecord removes live record index; the records after it move down
+// one index. It returns the RecordBatchApplied event.
+func (cc *PIRChainCode) DeleteRecord(ctx contractapi.TransactionContextInterface, indexStr string) (string, error) {
+	if err := checkDirectWrite(ctx, "DeleteRecord"); err != nil {
+		return "", err
+	}
+	index, err := strconv.Atoi(indexStr)
+	if err != nil {
+		return "", fmt.Errorf("DeleteRecord: invalid index %q", indexStr)
+	}
+	// ops built here, not a record argument, which a private dataset refuses
+	return cc.applyRecordOps(ctx, []RecordOp{{Op: "delete", Index: index}})
+}
+
+/**************  RECORD BATCH *****************************************/
+
+// RecordOp is one ApplyRecordBatch operation:
+//
+//	{"op":"add","record":{…}}              append at index n
+//	{"op":"update","index":i,"record":{…}} overwrite record i
+//	{"op":"delete","index":i}              remove record i; later records move down
+//
+// Indices refer to the dataset as left by the preceding operations.
+type RecordOp struct {
+	Op     string          `json:"op"`
+	Index  int             `json:"index"`
+	Record json.RawMessage `json:"record,omitempty"`
+}
+
+// RecordBatchEvent is the payload of the "RecordBatchApplied" event.
+type RecordBatchEvent struct {
+	Ops     int    `json:"ops"`
+	N       int    `json:"n"`
+	RecordS int    `json:"record_s"`
+	Root    string `json:"root"`
+}
+
+// prepareRecord is the AddCTIRecord input pipeline: sanitize (and
+// pseudonymize, see recordSanitizeOpts), validate against the logN schema,
+// pad to recordS.
+func prepareRecord(opt utils.SanitizeOptions, logN, recordS, index int, raw []byte) ([]byte, error) {
+	rec, err := utils.SanitizeRecord(raw, opt)
+	if err != nil {
+		return nil, err
+	}
+	return gen_records.PadRecord(logN, rec, recordS, index)
+}
+
+// prepareOps checks every op and runs its record through prepareRecord, so
+// that applyOps only has to check indices.
+func prepareOps(opt utils.SanitizeOptions, ops []RecordOp, logN, recordS int) ([]RecordOp, error) {
+	out := make([]RecordOp, len(ops))
+	for k, op := range ops {
+		switch op.Op {
+		case "add", "update":
+			rec, err := prepareRecord(opt, logN, recordS, op.Index, op.Record)
+			if err != nil {
+				return nil, fmt.Errorf("op %d (%s): %w", k, op.Op, err)
+			}
+			op.Record = rec
+		case "delete":
+			op.Record = nil
+		default:
+			return nil, fmt.Errorf("op %d: unknown op %q (want add, update or delete)", k, op.Op)
+		}
+		out[k] = op
+	}
+	return out, nil
+}
+
+// applyOps applies prepared ops in order to a copy of records.
+func applyOps(records [][]byte, ops []RecordOp) ([][]byte, error) {
+	out := append([][]byte(nil), records...)
+	for k, op := range ops {
+		if op.Op == "add" {
+			out = append(out, op.Record)
+			continue
+		}
+		if op.Index < 0 || op.Index >= len(out) {
+			return nil, fmt.Errorf("op %d (%s): index %d out of range 0..%d", k, op.Op, op.Index, len(out)-1)
+		}
+		if op.Op == "update" {
+			out[op.Index] = op.Record
+		} else {
+			out = append(out[:op.Index], out[op.Index+1:]...)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("batch would leave the dataset empty")
+	}
+	return out, nil
+}
+
+// indexSpace is the n a dataset of meta keeps once it holds live records:
+// reserved windows stay reserved (appends fill them, deletes free them
+// again); without a reservation n is the record count.
+func indexSpace(meta utils.Metadata, live int) int {
+	if meta.ReservedFrom > 0 {
+		return max(live, meta.NRecords)
+	}
+	return live
+}
+
+// loadRecords reads records 0..n-1 from world state (or the dataset's
+// record collection).
+func loadRecords(ctx contractapi.TransactionContextInterface, n int) ([][]byte, error) {
+	collection, err := recordCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	records := make([][]byte, n)
+	for i := range records {
+		rec, err := getRecord(ctx, collection, utils.RecordKey(i))
+		if err != nil || rec == nil {
+			return nil, fmt.Errorf("record %s missing (datasets uploaded with PutMDB cannot be edited in batches)", utils.RecordKey(i))
+		}
+		records[i] = rec
+	}
+	return records, nil
+}
+
+// ApplyRecordBatch applies a JSON array of RecordOp atomically: all ops
+// succeed or the transaction fails, and m_DB is re-encoded once. Only keys
+// whose record changed are rewritten; keys past the new n are deleted.
+// record_s stays fixed, as for AddCTIRecord.
+func (cc *PIRChainCode) ApplyRecordBatch(ctx contractapi.TransactionContextInterface, opsJSON string) (string, error) {
+	dbg("\n/**************  APPLY RECORD BATCH START *********************************/")
+	if err := checkDirectWrite(ctx, "ApplyRecordBatch"); err != nil {
+		return "", err
+	}
+
+	opsJSON, err := recordArg(ctx, opsJSON)
+	if err != nil {
+		return "", fmt.Errorf("ApplyRecordBatch: %w", err)
+	}
+	var ops []RecordOp
+	if err := json.Unmarshal([]byte(opsJSON), &ops); err != nil {
+		return "", fmt.Errorf("ApplyRecordBatch: invalid ops JSON: %w", err)
+	}
+	if len(ops) == 0 {
+		return "", fmt.Errorf("ApplyRecordBatch: empty batch")
+	}
+	return cc.applyRecordOps(ctx, ops)
+}
+
+// applyRecordOps is ApplyRecordBatch after the ops are parsed.
+func (cc *PIRChainCode) applyRecordOps(ctx contractapi.TransactionContextInterface, ops []RecordOp) (string, error) {
+	start := time.Now()
+	params, err := cc.ensureParams(ctx)
+	if err != nil {
+		return "", fmt.Errorf("ApplyRecordBatch: %w", err)
+	}
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		return "", fmt.Errorf("ApplyRecordBatch: %w", err)
+	}
+	old, err := loadRecords(ctx, meta.Live())
+	if err != nil {
+		return "", fmt.Errorf("ApplyRecordBatch: %w", err)
+	}
+	opt, err := recordSanitizeOpts(ctx)
+	if err != nil {
+		return "", fmt.Errorf("ApplyRecordBatch: %w", err)
+	}
+	if ops, err = prepareOps(opt, ops, params.LogN(), utils.NewIndexContract(meta).RecordBytes()); err != nil {
+		return "", fmt.Errorf("ApplyRecordBatch: %w", err)
+	}
+	records, err := applyOps(old, ops)
+	if err != nil {
+		return "", fmt.Errorf("ApplyRecordBatch: %w", err)
+	}
+
+	root, err := cc.repack(ctx, params, old, records, meta.RecordS, meta.Packing, indexSpace(meta, len(records)))
+	if err != nil {
+		return "", fmt.Errorf("ApplyRecordBatch: %w", err)
+	}
+
+	ev, _ := json.Marshal(RecordBatchEvent{Ops: len(ops), N: len(records), RecordS: meta.RecordS, Root: root})
+	if err := ctx.GetStub().SetEvent("RecordBatchApplied", ev); err != nil {
+		return "", err
+	}
+
+	dbg("[CC][BATCH] %d ops applied: n %d → %d in %.3f ms", len(ops), len(old), len(records), msOf(time.Since(start)))
+	dbg("/**************  APPLY RECORD BATCH END ***********************************/")
+	return utils.MarshalUntimed(ev)
+}
+
+// repack replaces the dataset old with records under a fixed recordS,
+// packing and n indices (the ones past the records reserved, see indexSpace): changed
+// keys are rewritten, surplus keys deleted, the m_DB shards packed and
+// encoded once, and the commitment refreshed. It returns the new root.
+func (cc *PIRChainCode) repack(ctx contractapi.TransactionContextInterface, params he.Params,
+	old, records [][]byte, recordS int, packing string, n int) (string, error) {
+
+	live := len(records)
+	if err := utils.CheckCapacity(n, recordS, utils.LogSlots(params.MaxSlots()), planOpts.MaxShards); err != nil {
+		return "", err
+	}
+	reservedFrom := utils.ReservedFromLive(live, n)
+	ic := utils.IndexContract{NRecords: n, RecordS: recordS, Slots: params.MaxSlots(), ReservedFrom: reservedFrom, Packing: packing}
+	if err := ic.ValidateSharded(); err != nil {
+		return "", err
+	}
+
+	collection, err := recordCollection(ctx)
+	if err != nil {
+		return "", err
+	}
+	hashes := make([]string, live)
+	changes := []utils.RecordChange{}
+	for i, rec := range records {
+		if i >= len(old) || string(old[i]) != string(rec) {
+			if err := putRecord(ctx, collection, utils.RecordKey(i), rec); err != nil {
+				return "", err
+			}
+			op := utils.ChangeUpdate
+			if i >= len(old) {
+				op = utils.ChangeAdd
+			}
+			changes = append(changes, utils.RecordChange{Index: i, Op: op})
+		}
+		hashes[i] = utils.RecordHash(rec)
+	}
+	for i := live; i < len(old); i++ {
+		if err := delRecord(ctx, collection, utils.RecordKey(i)); err != nil {
+			return "", err
+		}
+		changes = append(changes, utils.RecordChange{Index: i, Op: utils.ChangeDelete})
+	}
+
+	packed, err := ic.PackShards(records)
+	if err != nil {
+		return "", err
+	}
+	pts, err := encodeShards(params, packed)
+	if err != nil {
+		return "", err
+	}
+	root, err := putCommitment(ctx, hashes)
+	if err != nil {
+		return "", err
+	}
+	return root, cc.persistDB(ctx, pts, n, recordS, reservedFrom, changes)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+
+	"pir_shared/he"
+	"pir_shared/utils"
+)
+
+// TestAddRecordPacked2B checks a record written by AddRecord under 2b
+// packing comes back from PIRQuery as stored: two bytes per slot over the
+// window's RecordS slots, not one byte per slot.
+func TestAddRecordPacked2B(t *testing.T) {
+	logCfg.Store(&logConfig{})
+	cc, ctx := new(PIRChainCode), mockCtx()
+	stub := ctx.GetStub().(*shimtest.MockStub)
+
+	if _, err := cc.InitLedger(ctx, "16", "128", "13", "", "", ""); err != nil {
+		t.Fatalf("InitLedger: %v", err)
+	}
+	if _, err := cc.SetPacking(ctx, utils.Packing2B); err != nil {
+		t.Fatalf("SetPacking: %v", err)
+	}
+	if _, err := cc.GenerateDataset(ctx); err != nil {
+		t.Fatalf("GenerateDataset: %v", err)
+	}
+	stub.MockTransactionEnd("init")
+
+	stub.MockTransactionStart("add")
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		t.Fatalf("loadMetadata: %v", err)
+	}
+	index := meta.Live()
+	if _, err := cc.AddRecord(ctx, `{"md5":"0cc175b9c0f1b6a831c399e269772661","malware_family":"PackedTwoBytes","threat_level":"high"}`); err != nil {
+		t.Fatalf("AddRecord: %v", err)
+	}
+	stub.MockTransactionEnd("add")
+
+	stub.MockTransactionStart("query")
+	p, err := cc.ensureParams(ctx)
+	if err != nil {
+		t.Fatalf("ensureParams: %v", err)
+	}
+	if meta, err = cc.loadMetadata(ctx); err != nil {
+		t.Fatalf("loadMetadata: %v", err)
+	}
+	ic := utils.NewIndexContract(meta)
+	if ic.Packing != utils.Packing2B {
+		t.Fatalf("packing %q, want %q", ic.Packing, utils.Packing2B)
+	}
+	win, err := ic.Describe(index)
+	if err != nil {
+		t.Fatalf("Describe(%d): %v", index, err)
+	}
+
+	sk, pk, err := he.Default.GenKeyPair(p)
+	if err != nil {
+		t.Fatalf("GenKeyPair: %v", err)
+	}
+	sel := make([]uint64, p.MaxSlots())
+	for j := win.StartSlot; j < win.EndSlot; j++ {
+		sel[j] = 1
+	}
+	pt, err := he.Default.Encode(p, sel)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	ct, err := he.Default.Encrypt(p, pk, pt)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	raw, err := ct.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	resp, err := cc.PIRQuery(ctx, base64.StdEncoding.EncodeToString(raw))
+	if err != nil {
+		t.Fatalf("PIRQuery: %v", err)
+	}
+	results, err := utils.DecodeShardResults(resp)
+	if err != nil {
+		t.Fatalf("DecodeShardResults: %v", err)
+	}
+	if win.Shard >= len(results) {
+		t.Fatalf("record in shard %d, PIRQuery returned %d", win.Shard, len(results))
+	}
+	ctRaw, err := base64.StdEncoding.DecodeString(results[win.Shard])
+	if err != nil {
+		t.Fatalf("decode result: %v", err)
+	}
+	ctRes, err := he.Default.UnmarshalCiphertext(p, ctRaw, 1)
+	if err != nil {
+		t.Fatalf("UnmarshalCiphertext: %v", err)
+	}
+	ptRes, err := he.Default.Decrypt(p, sk, ctRes)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	slots, err := he.Default.Decode(p, ptRes)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	stored, err := stub.GetState(utils.RecordKey(index))
+	if err != nil || stored == nil {
+		t.Fatalf("stored record %s: %v", utils.RecordKey(index), err)
+	}
+	got := utils.TrimPadding(ic.UnpackWindow(slots[win.StartSlot:win.EndSlot]))
+	if want := utils.TrimPadding(stored); !bytes.Equal(got, want) {
+		t.Errorf("PIRQuery window = %q, want %q", got, want)
+	}
+	if !bytes.Contains(got, []byte("PackedTwoBytes")) {
+		t.Errorf("PIRQuery window %q lacks the added record", got)
+	}
+}
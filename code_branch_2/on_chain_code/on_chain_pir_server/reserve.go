@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/utils"
+)
+
+/**************  RESERVED INDICES *************************************/
+
+// A dataset can hold index windows empty for future appends, so a
+// consortium can plan growth without changing logN or the shard layout.
+// ReserveIndices runs between InitLedger/InitBegin and GenerateDataset/
+// InitCommit (InitLedger's arguments are fixed by the lifecycle
+// --init-required call); the reserved windows are packed as zeros after
+// the records, n counts them and GetMetadata reports the first one as
+// reserved_from. AddCTIRecord, ApplyRecordBatch and Publish append into
+// them; clients decode a reserved index to utils.EmptyRecord.
+
+// ReserveIndices sets the number of reserved windows of the current
+// dataset ("0" for none). The records and the reservation must fit the
+// committed logN together; it is refused once the dataset is packed.
+func (cc *PIRChainCode) ReserveIndices(ctx contractapi.TransactionContextInterface, countStr string) (string, error) {
+	count, err := strconv.Atoi(countStr)
+	if err != nil || count < 0 {
+		return "", fmt.Errorf("ReserveIndices: count must be a non-negative integer, got %q", countStr)
+	}
+	spec, err := loadSpec(ctx)
+	if err != nil {
+		return "", fmt.Errorf("ReserveIndices: %w", err)
+	}
+	nRaw, err := ctx.GetStub().GetState("n")
+	if err != nil {
+		return "", err
+	}
+	if nRaw != nil {
+		return "", fmt.Errorf("ReserveIndices: dataset already packed - call InitLedger or InitBegin first")
+	}
+	p, err := cc.ensureParams(ctx)
+	if err != nil {
+		return "", fmt.Errorf("ReserveIndices: %w", err)
+	}
+	if err := utils.CheckCapacity(spec.N+count, utils.PackedSlots(spec.MaxJSON, spec.Packing), utils.LogSlots(p.MaxSlots()), planOpts.MaxShards); err != nil {
+		return "", fmt.Errorf("ReserveIndices: %w", err)
+	}
+
+	spec.Reserve = count
+	raw, _ := json.Marshal(spec)
+	if err := ctx.GetStub().PutState("dataset_spec", raw); err != nil {
+		return "", err
+	}
+	dbg("[CC][RESERVE] %d records + %d reserved windows (reserved_from=%d)", spec.N, count, spec.N)
+	return utils.MarshalUntimed(spec)
+}
@@ -66,6 +66,14 @@ export CORE_CHAINCODE_LOGGING_SHIM="debug"
 export CORE_PEER_TLS_ENABLED=false
 export CORE_CHAINCODE_LOGLEVEL=debug
 export FABRIC_LOGGING_SPEC=debug
+# PIRQuerySubmit audit payloads go to this collection (fablo-config.json privateData)
+export PIR_AUDIT_COLLECTION="${PIR_AUDIT_COLLECTION:-auditPayloads}"
+# HMAC key for SetPseudonymFields; endorsing peers must share it
+export PIR_PSEUDONYM_KEY="${PIR_PSEUDONYM_KEY:-dev-pseudonym-key}"
+# Inits whose estimated heap exceeds this (MiB) are refused; <=0 disables
+export PIR_MEM_BUDGET_MB="${PIR_MEM_BUDGET_MB:-1024}"
+# Debug output until SetConfig says otherwise (0 = quiet)
+export PIR_DEBUG="${PIR_DEBUG:-1}"
 
 # ========== RUN GO CHAINCODE ==========
 echo "========================================"
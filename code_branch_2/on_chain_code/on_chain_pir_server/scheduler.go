@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/utils"
+)
+
+/**************  SHARD SCHEDULER **************************************/
+
+// Each shard of a query is one ct×pt product, independent of the others,
+// so a peer with several cores (a CCaaS container sized for the channel's
+// load) evaluates them on a pool of evalWorkers threads instead of one
+// after the other. A query's shards go to the workers with the shortest
+// queues; a worker whose own queue is empty steals from the back of the
+// longest one, so a burst of multi-shard queries spreads over every core.
+// Time a shard spends queued is reported apart from its compute time
+// (EvalUsage.QueueMS, ShardUsage.QueueMS) and kept in queueStats, so tail
+// latency under load can be told apart from slow evaluation and tuned
+// through PIR_EVAL_WORKERS.
+
+// evalWorkers is the size of the evaluation pool (PIR_EVAL_WORKERS,
+// <=0 → GOMAXPROCS, which follows the container's CPU quota).
+var evalWorkers = func() int {
+	if n := envInt("PIR_EVAL_WORKERS", 0); n > 0 {
+		return n
+	}
+	return runtime.GOMAXPROCS(0)
+}()
+
+// queueStats is the queueing delay of PIR queries per parameter set, from
+// submission to the start of the first shard (GetSchedulerStats).
+var queueStats utils.PerfStats
+
+var sched = newShardScheduler(evalWorkers)
+
+// shardTask is one shard evaluation of a query.
+type shardTask struct {
+	run     func() error
+	started time.Time
+	ended   time.Time
+	worker  int
+	usage   utils.EvalUsage
+	err     error
+	wg      *sync.WaitGroup
+}
+
+// WorkerStats is one evaluation worker in GetSchedulerStats.
+type WorkerStats struct {
+	Worker int     `json:"worker"`
+	Queued int     `json:"queued"` // tasks waiting in its queue now
+	Tasks  uint64  `json:"tasks"`  // shard evaluations run
+	Stolen uint64  `json:"stolen"` // of which taken from another worker's queue
+	BusyMS float64 `json:"busy_ms"`
+}
+
+type shardScheduler struct {
+	once   sync.Once
+	mu     sync.Mutex
+	wake   *sync.Cond
+	queues [][]*shardTask // per worker; the owner takes the front, thieves the back
+	busy   []bool
+	stats  []WorkerStats
+}
+
+func newShardScheduler(workers int) *shardScheduler {
+	s := &shardScheduler{
+		queues: make([][]*shardTask, workers),
+		busy:   make([]bool, workers),
+		stats:  make([]WorkerStats, workers),
+	}
+	s.wake = sync.NewCond(&s.mu)
+	for w := range s.stats {
+		s.stats[w].Worker = w
+	}
+	return s
+}
+
+// run evaluates every fns[k] on the pool and waits for all of them. The
+// usage covers the whole query: WallMS from the first shard's start to the
+// last one's end, QueueMS before the first start, CPU and allocations
+// summed over the shards. The error is that of the lowest failing shard.
+func (s *shardScheduler) run(fns []func() error) (utils.EvalUsage, []utils.ShardUsage, error) {
+	s.once.Do(func() {
+		for w := range s.queues {
+			go s.worker(w)
+		}
+	})
+
+	var wg sync.WaitGroup
+	tasks := make([]*shardTask, len(fns))
+	submitted := time.Now()
+	s.mu.Lock()
+	for k, fn := range fns {
+		tasks[k] = &shardTask{run: fn, wg: &wg}
+		w := s.shortestQueue()
+		s.queues[w] = append(s.queues[w], tasks[k])
+	}
+	wg.Add(len(tasks))
+	s.mu.Unlock()
+	s.wake.Broadcast()
+	wg.Wait()
+
+	var usage utils.EvalUsage
+	shards := make([]utils.ShardUsage, len(tasks))
+	var first, last time.Time
+	var err error
+	for k, t := range tasks {
+		if k == 0 || t.started.Before(first) {
+			first = t.started
+		}
+		if t.ended.After(last) {
+			last = t.ended
+		}
+		switch {
+		case usage.CPUMS < 0:
+		case t.usage.CPUMS < 0:
+			usage.CPUMS = -1
+		default:
+			usage.CPUMS += t.usage.CPUMS
+		}
+		usage.AllocBytes += t.usage.AllocBytes
+		usage.Allocs += t.usage.Allocs
+		shards[k] = utils.ShardUsage{Shard: k, EvalMS: t.usage.WallMS, QueueMS: msOf(t.started.Sub(submitted)), Worker: t.worker}
+		if t.err != nil && err == nil {
+			err = fmt.Errorf("shard %d: %w", k, t.err)
+		}
+	}
+	usage.WallMS, usage.QueueMS = msOf(last.Sub(first)), msOf(first.Sub(submitted))
+	return usage, shards, err
+}
+
+// shortestQueue is the worker with the least work queued, counting a
+// running evaluation as one task. Callers hold s.mu.
+func (s *shardScheduler) shortestQueue() int {
+	best, load := 0, -1
+	for w, q := range s.queues {
+		l := len(q)
+		if s.busy[w] {
+			l++
+		}
+		if load < 0 || l < load {
+			best, load = w, l
+		}
+	}
+	return best
+}
+
+// next blocks until worker w has a task: its own oldest, else the newest
+// of the longest other queue.
+func (s *shardScheduler) next(w int) (t *shardTask, stolen bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if q := s.queues[w]; len(q) > 0 {
+			t, s.queues[w] = q[0], q[1:]
+			break
+		}
+		victim := -1
+		for v, q := range s.queues {
+			if len(q) > 0 && (victim < 0 || len(q) > len(s.queues[victim])) {
+				victim = v
+			}
+		}
+		if victim >= 0 {
+			q := s.queues[victim]
+			t, s.queues[victim], stolen = q[len(q)-1], q[:len(q)-1], true
+			break
+		}
+		s.wake.Wait()
+	}
+	s.busy[w] = true
+	return t, stolen
+}
+
+func (s *shardScheduler) worker(w int) {
+	for {
+		t, stolen := s.next(w)
+		t.worker, t.started = w, time.Now()
+		t.usage, t.err = utils.MeasureEval(t.run)
+		t.ended = time.Now()
+
+		s.mu.Lock()
+		s.busy[w] = false
+		s.stats[w].Tasks++
+		if stolen {
+			s.stats[w].Stolen++
+		}
+		s.stats[w].BusyMS += t.usage.WallMS
+		s.mu.Unlock()
+		t.wg.Done()
+	}
+}
+
+// snapshot returns the per-worker counters.
+func (s *shardScheduler) snapshot() []WorkerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := append([]WorkerStats(nil), s.stats...)
+	for w := range out {
+		out[w].Queued = len(s.queues[w])
+	}
+	return out
+}
+
+// SchedulerStats is the GetSchedulerStats response.
+type SchedulerStats struct {
+	Workers []WorkerStats        `json:"workers"`
+	Queue   []utils.PerfSnapshot `json:"queue"` // queueing delay per (logN, shards)
+}
+
+// GetSchedulerStats returns this peer's evaluation workers and the
+// queueing delay of its PIR queries; compare with GetPerfStats, which
+// holds their compute time. Per-peer, in memory only, like GetPerfStats.
+func (cc *PIRChainCode) GetSchedulerStats(ctx contractapi.TransactionContextInterface) (string, error) {
+	out, err := json.Marshal(SchedulerStats{Workers: sched.snapshot(), Queue: queueStats.Snapshot()})
+	if err != nil {
+		return "", fmt.Errorf("GetSchedulerStats: %w", err)
+	}
+	return string(out), nil
+}
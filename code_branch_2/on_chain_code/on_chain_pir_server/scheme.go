@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/he"
+	"pir_shared/utils"
+)
+
+/**************  HE SCHEME ********************************************/
+
+// A dataset is built under BGV unless SetScheme picks BFV or CKKS on the
+// same ring (utils/scheme.go), so an experiment can compare exact
+// arithmetic modulo T with CKKS's approximate arithmetic at a scale on
+// one network. Like ReserveIndices it runs between InitLedger/InitBegin
+// and GenerateDataset/InitCommit, since InitLedger's arguments are fixed
+// by the lifecycle --init-required call; LoadRecordsFromJSON packs in the
+// same transaction and stays BGV. The scheme is kept in bgv_params and
+// reported by GetMetadata, where clients read it before building ct_q.
+// CKKS halves the slots per plaintext, so the records must still fit
+// planOpts.MaxShards shards of N/2.
+
+// hint is the params hint that rebuilds pm.
+func (pm bgvParamsMeta) hint() utils.BGVParamHint {
+	return utils.BGVParamHint{LogN: pm.LogN, LogQi: pm.LogQi, LogPi: pm.LogPi, T: pm.T, Scheme: pm.Scheme}
+}
+
+// SetScheme rebuilds the committed params under scheme ("bgv", "bfv" or
+// "ckks"; empty is bgv) and returns the new bgv_params. It is refused
+// once the dataset is packed.
+func (cc *PIRChainCode) SetScheme(ctx contractapi.TransactionContextInterface, scheme string) (string, error) {
+	name, err := utils.ParseScheme(scheme)
+	if err != nil {
+		return "", fmt.Errorf("SetScheme: %w", err)
+	}
+	spec, err := loadSpec(ctx)
+	if err != nil {
+		return "", fmt.Errorf("SetScheme: %w", err)
+	}
+	nRaw, err := ctx.GetStub().GetState("n")
+	if err != nil {
+		return "", err
+	}
+	if nRaw != nil {
+		return "", fmt.Errorf("SetScheme: dataset already packed - call InitLedger or InitBegin first")
+	}
+	raw, err := ctx.GetStub().GetState("bgv_params")
+	if err != nil || raw == nil {
+		return "", fmt.Errorf("SetScheme: bgv_params not found in world state - call InitLedger first")
+	}
+	var pm bgvParamsMeta
+	if err := json.Unmarshal(raw, &pm); err != nil {
+		return "", fmt.Errorf("SetScheme: failed to parse bgv_params: %w", err)
+	}
+
+	pm.Scheme = name
+	p, err := he.Default.NewParams(pm.hint())
+	if err != nil {
+		return "", fmt.Errorf("SetScheme: %w", err)
+	}
+	logSlots := utils.LogSlots(p.MaxSlots())
+	if err := utils.CheckPacking(spec.Packing, name, pm.T); err != nil {
+		return "", fmt.Errorf("SetScheme: %w", err)
+	}
+	if err := utils.CheckCapacity(spec.N+spec.Reserve, utils.PackedSlots(spec.MaxJSON, spec.Packing), logSlots, planOpts.MaxShards); err != nil {
+		return "", fmt.Errorf("SetScheme: %s: %w", name, err)
+	}
+
+	raw, _ = json.Marshal(pm)
+	if err := ctx.GetStub().PutState("bgv_params", raw); err != nil {
+		return "", err
+	}
+	c := cc.cache(ctx)
+	c.mu.Lock()
+	c.Params, c.m_DB, c.paramsRaw, c.dbKey = p, nil, raw, ""
+	c.mu.Unlock()
+
+	dbg("[CC][SCHEME] %s on LogN=%d (%d slots, T=%d)", name, p.LogN(), p.MaxSlots(), p.PlaintextModulus())
+	return utils.MarshalUntimed(pm)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/utils"
+)
+
+/**************  STAGING AREA *****************************************/
+
+// Data owners curate mutations with StageRecordOps: each RecordOp is
+// validated and prepared (prepareOps) and stored under stagingKey(i), but
+// m_DB is untouched. GetStaged lets reviewers inspect the pending ops and
+// their digest; Publish folds them into a new m_DB version in one
+// transaction (same path as ApplyRecordBatch); DiscardStaged drops them.
+// Consortia that need a second org's sign-off use ProposePublish /
+// ApprovePublish (approval.go) instead of Publish.
+
+const (
+	stagingPrefix   = "staging:"
+	stagingCountKey = "staging_count"
+)
+
+func stagingKey(i int) string {
+	return fmt.Sprintf("%s%06d", stagingPrefix, i)
+}
+
+func stagingCount(ctx contractapi.TransactionContextInterface) (int, error) {
+	raw, err := ctx.GetStub().GetState(stagingCountKey)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := strconv.Atoi(string(raw)) // absent → 0
+	return n, nil
+}
+
+// loadStaged returns the staged ops in order.
+func loadStaged(ctx contractapi.TransactionContextInterface) ([]RecordOp, error) {
+	n, err := stagingCount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	collection, err := recordCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ops := make([]RecordOp, n)
+	for i := range ops {
+		raw, err := getRecord(ctx, collection, stagingKey(i))
+		if err != nil || raw == nil {
+			return nil, fmt.Errorf("staged op %s missing", stagingKey(i))
+		}
+		if err := json.Unmarshal(raw, &ops[i]); err != nil {
+			return nil, fmt.Errorf("staged op %s: %w", stagingKey(i), err)
+		}
+	}
+	return ops, nil
+}
+
+// stagedDigest is the hex SHA-256 over the staged ops' canonical JSON, in
+// order. It identifies exactly what Publish would apply.
+func stagedDigest(ops []RecordOp) string {
+	h := sha256.New()
+	for _, op := range ops {
+		b, _ := json.Marshal(op)
+		h.Write(b)
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func clearStaging(ctx contractapi.TransactionContextInterface, n int) error {
+	collection, err := recordCollection(ctx)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if err := delRecord(ctx, collection, stagingKey(i)); err != nil {
+			return err
+		}
+	}
+	return ctx.GetStub().DelState(stagingCountKey)
+}
+
+// StagedSummary is the StageRecordOps / GetStaged / DiscardStaged result.
+type StagedSummary struct {
+	Count  int        `json:"count"`
+	Digest string     `json:"digest"`
+	Ops    []RecordOp `json:"ops,omitempty"` // GetStaged only
+}
+
+// StageRecordOps validates a JSON array of RecordOp and appends it to the
+// staging area. Indices are checked at Publish, against the dataset as it
+// is then.
+func (cc *PIRChainCode) StageRecordOps(ctx contractapi.TransactionContextInterface, opsJSON string) (string, error) {
+	opsJSON, err := recordArg(ctx, opsJSON)
+	if err != nil {
+		return "", fmt.Errorf("StageRecordOps: %w", err)
+	}
+	var ops []RecordOp
+	if err := json.Unmarshal([]byte(opsJSON), &ops); err != nil {
+		return "", fmt.Errorf("StageRecordOps: invalid ops JSON: %w", err)
+	}
+	if len(ops) == 0 {
+		return "", fmt.Errorf("StageRecordOps: empty batch")
+	}
+	params, err := cc.ensureParams(ctx)
+	if err != nil {
+		return "", fmt.Errorf("StageRecordOps: %w", err)
+	}
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		return "", fmt.Errorf("StageRecordOps: %w", err)
+	}
+	opt, err := recordSanitizeOpts(ctx)
+	if err != nil {
+		return "", fmt.Errorf("StageRecordOps: %w", err)
+	}
+	if ops, err = prepareOps(opt, ops, params.LogN(), utils.NewIndexContract(meta).RecordBytes()); err != nil {
+		return "", fmt.Errorf("StageRecordOps: %w", err)
+	}
+
+	staged, err := loadStaged(ctx)
+	if err != nil {
+		return "", fmt.Errorf("StageRecordOps: %w", err)
+	}
+	collection, err := recordCollection(ctx)
+	if err != nil {
+		return "", fmt.Errorf("StageRecordOps: %w", err)
+	}
+	for i, op := range ops {
+		raw, _ := json.Marshal(op)
+		if err := putRecord(ctx, collection, stagingKey(len(staged)+i), raw); err != nil {
+			return "", err
+		}
+	}
+	staged = append(staged, ops...)
+	if err := ctx.GetStub().PutState(stagingCountKey, []byte(strconv.Itoa(len(staged)))); err != nil {
+		return "", err
+	}
+
+	dbg("[CC][STAGE] %d ops staged (%d pending)", len(ops), len(staged))
+	return utils.MarshalUntimed(StagedSummary{Count: len(staged), Digest: stagedDigest(staged)})
+}
+
+// GetStaged returns the pending ops and their digest.
+func (cc *PIRChainCode) GetStaged(ctx contractapi.TransactionContextInterface) (string, error) {
+	start := time.Now()
+	staged, err := loadStaged(ctx)
+	if err != nil {
+		return "", fmt.Errorf("GetStaged: %w", err)
+	}
+	return utils.MarshalTimed(StagedSummary{Count: len(staged), Digest: stagedDigest(staged), Ops: staged}, start)
+}
+
+// DiscardStaged drops every pending op and any publication proposal.
+func (cc *PIRChainCode) DiscardStaged(ctx contractapi.TransactionContextInterface) (string, error) {
+	n, err := stagingCount(ctx)
+	if err != nil {
+		return "", fmt.Errorf("DiscardStaged: %w", err)
+	}
+	if err := clearStaging(ctx, n); err != nil {
+		return "", fmt.Errorf("DiscardStaged: %w", err)
+	}
+	if err := ctx.GetStub().DelState(proposalKey); err != nil {
+		return "", fmt.Errorf("DiscardStaged: %w", err)
+	}
+	return utils.MarshalUntimed(StagedSummary{Digest: stagedDigest(nil)})
+}
+
+// PublishEvent is the payload of the "DatasetPublished" event.
+type PublishEvent struct {
+	Version int    `json:"version"` // m_DB_version after publication
+	Ops     int    `json:"ops"`
+	Digest  string `json:"digest"` // stagedDigest of the applied ops
+	N       int    `json:"n"`
+	Root    string `json:"root"`
+}
+
+// Publish applies every staged op to the dataset, re-encodes m_DB once and
+// clears the staging area. It is refused while approval is required
+// (approvalConfig).
+func (cc *PIRChainCode) Publish(ctx contractapi.TransactionContextInterface) (string, error) {
+	dbg("\n/**************  PUBLISH START ********************************************/")
+	start := time.Now()
+
+	if configOf(ctx).Approval.Required {
+		return "", fmt.Errorf("Publish: approval required - use ProposePublish and ApprovePublish")
+	}
+
+	ev, err := cc.publishStaged(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Publish: %w", err)
+	}
+	raw, _ := json.Marshal(ev)
+	if err := ctx.GetStub().SetEvent("DatasetPublished", raw); err != nil {
+		return "", err
+	}
+
+	dbg("[CC][PUBLISH] version %d: %d ops, n=%d in %.3f ms", ev.Version, ev.Ops, ev.N, msOf(time.Since(start)))
+	dbg("/**************  PUBLISH END **********************************************/")
+	return utils.MarshalUntimed(raw)
+}
+
+func (cc *PIRChainCode) publishStaged(ctx contractapi.TransactionContextInterface) (PublishEvent, error) {
+	staged, err := loadStaged(ctx)
+	if err != nil {
+		return PublishEvent{}, err
+	}
+	if len(staged) == 0 {
+		return PublishEvent{}, fmt.Errorf("nothing staged")
+	}
+	params, err := cc.ensureParams(ctx)
+	if err != nil {
+		return PublishEvent{}, err
+	}
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		return PublishEvent{}, err
+	}
+	old, err := loadRecords(ctx, meta.Live())
+	if err != nil {
+		return PublishEvent{}, err
+	}
+	records, err := applyOps(old, staged)
+	if err != nil {
+		return PublishEvent{}, err
+	}
+	version, err := dbVersion(ctx)
+	if err != nil {
+		return PublishEvent{}, err
+	}
+	root, err := cc.repack(ctx, params, old, records, meta.RecordS, meta.Packing, indexSpace(meta, len(records)))
+	if err != nil {
+		return PublishEvent{}, err
+	}
+	if err := clearStaging(ctx, len(staged)); err != nil {
+		return PublishEvent{}, err
+	}
+	return PublishEvent{
+		Version: version + 1, Ops: len(staged), Digest: stagedDigest(staged), N: len(records), Root: root,
+	}, nil
+}
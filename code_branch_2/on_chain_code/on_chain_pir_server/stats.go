@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/gen_records"
+	"pir_shared/utils"
+)
+
+/**************  PUBLIC STATISTICS ************************************/
+
+// GetPublicStats lets consumers see the shape of the dataset (records per
+// threat level and malware family) without downloading it. Each record
+// falls in one bucket of each histogram, so adding or removing a record
+// moves the pair by at most 2 in L1 and Laplace noise of scale 2/epsilon
+// makes the response epsilon-DP. Buckets are the public value lists of
+// gen_records plus utils.OtherBucket, never values read from the records.
+//
+// The budget is spent once per record set. epsilon is dpEpsilon, not
+// the caller's to choose, and the noise is derived from PIR_DP_SECRET (set
+// in the chaincode container's environment, the same on every peer) and
+// records_root: repeating a call returns the same answer instead of a
+// fresh sample to average away. records_root is public, so noise seeded
+// from it alone could be recomputed and subtracted; without the secret the
+// call is refused. A private-collection dataset answers only the MSPs that
+// may read its records (checkRecordReader).
+
+const (
+	dpSensitivity = 2
+	dpEpsilon     = 1.0
+)
+
+var dpSecret = os.Getenv("PIR_DP_SECRET")
+
+// GetPublicStats returns dpEpsilon-DP counts of the stored records per
+// threat_level and malware_family.
+func (cc *PIRChainCode) GetPublicStats(ctx contractapi.TransactionContextInterface) (string, error) {
+	start := time.Now()
+
+	if dpSecret == "" {
+		return "", fmt.Errorf("GetPublicStats: PIR_DP_SECRET is not set on this peer - without it repeated calls would average the noise away")
+	}
+	spec, err := loadSpec(ctx)
+	if err != nil {
+		return "", fmt.Errorf("GetPublicStats: %w", err)
+	}
+	if spec.Collection != "" {
+		if err := checkRecordReader(ctx, spec); err != nil {
+			return "", fmt.Errorf("GetPublicStats: %w", err)
+		}
+	}
+	meta, err := cc.loadMetadata(ctx)
+	if err != nil {
+		return "", fmt.Errorf("GetPublicStats: %w", err)
+	}
+	records, err := loadRecords(ctx, meta.Live())
+	if err != nil {
+		return "", fmt.Errorf("GetPublicStats: %w", err)
+	}
+	root, err := ctx.GetStub().GetState("records_root")
+	if err != nil || root == nil {
+		return "", fmt.Errorf("GetPublicStats: records_root not found in world state")
+	}
+
+	levels := make([]string, len(records))
+	families := make([]string, len(records))
+	for i, rec := range records {
+		var r struct {
+			ThreatLevel   string `json:"threat_level"`
+			MalwareFamily string `json:"malware_family"`
+		}
+		if err := json.Unmarshal(rec, &r); err != nil {
+			return "", fmt.Errorf("GetPublicStats: record %d: %w", i, err)
+		}
+		levels[i], families[i] = r.ThreatLevel, r.MalwareFamily
+	}
+
+	seed := []byte(fmt.Sprintf("%s;records_root=%s", dpSecret, root))
+	scale := dpSensitivity / dpEpsilon
+	stats := utils.PublicStats{
+		NRecords:      len(records),
+		ThreatLevel:   utils.NoisyHistogram(levels, gen_records.ThreatLevels(), "threat_level:", seed, scale),
+		MalwareFamily: utils.NoisyHistogram(families, gen_records.MalwareFamilies(), "malware_family:", seed, scale),
+		DP: utils.DPParams{
+			Mechanism: "laplace", Epsilon: dpEpsilon, Sensitivity: dpSensitivity, Scale: scale, Seed: "secret+records_root",
+		},
+	}
+	dbg("[CC][STATS] eps=%g scale=%g over %d records", dpEpsilon, scale, len(records))
+	return utils.MarshalTimed(stats, start)
+}
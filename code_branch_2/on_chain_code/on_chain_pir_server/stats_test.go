@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"strings"
+	"testing"
+
+	"pir_shared/utils"
+)
+
+// TestPublicStatsBudget checks GetPublicStats is refused without
+// PIR_DP_SECRET, answers repeated calls with the same noise at dpEpsilon,
+// and refuses MSPs that may not read a private-collection dataset.
+func TestPublicStatsBudget(t *testing.T) {
+	cc, ctx, _ := newTestLedger(t, "")
+	defer func(s string) { dpSecret = s }(dpSecret)
+
+	dpSecret = ""
+	if _, err := cc.GetPublicStats(ctx); err == nil || !strings.Contains(err.Error(), "PIR_DP_SECRET") {
+		t.Errorf("GetPublicStats without a secret: %v, want it refused", err)
+	}
+
+	dpSecret = "test-secret"
+	stats := func() utils.PublicStats {
+		t.Helper()
+		out, err := cc.GetPublicStats(ctx)
+		if err != nil {
+			t.Fatalf("GetPublicStats: %v", err)
+		}
+		var resp struct {
+			Result utils.PublicStats `json:"result"`
+		}
+		if err := json.Unmarshal([]byte(out), &resp); err != nil {
+			t.Fatalf("parse response: %v", err)
+		}
+		return resp.Result
+	}
+	first := stats()
+	if first.DP.Epsilon != dpEpsilon {
+		t.Errorf("epsilon %g, want %g", first.DP.Epsilon, dpEpsilon)
+	}
+	for i := 0; i < 3; i++ {
+		again := stats()
+		if !maps.Equal(again.ThreatLevel, first.ThreatLevel) || !maps.Equal(again.MalwareFamily, first.MalwareFamily) {
+			t.Fatalf("call %d drew fresh noise: %v, first %v", i+2, again.ThreatLevel, first.ThreatLevel)
+		}
+	}
+
+	spec, err := loadSpec(ctx)
+	if err != nil {
+		t.Fatalf("loadSpec: %v", err)
+	}
+	spec.Collection, spec.CollectionMSPs = "ctiPrivate", []string{"Org1MSP"}
+	raw, _ := json.Marshal(spec)
+	if err := ctx.GetStub().PutState("dataset_spec", raw); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cc.GetPublicStats(ctx); err == nil || !strings.Contains(err.Error(), "may not read") {
+		t.Errorf("GetPublicStats by BenchMSP on a private dataset: %v, want it refused", err)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+	"pir_shared/utils"
+)
+
+/**************  STORAGE REPORT ***************************************/
+
+// GetStorageReport walks the world state once and sums keys and bytes per
+// key family, so operators can see what is consuming ledger space (e.g.
+// version deltas piling up after months of updates). Keys that match no
+// family are counted under "other" rather than dropped, so families added
+// later still show up in the totals.
+
+// StorageFamily is one row of the storage report.
+type StorageFamily struct {
+	Family     string `json:"family"`
+	Internal   bool   `json:"internal"` // see internalKey
+	Keys       int    `json:"keys"`
+	KeyBytes   int    `json:"key_bytes"`
+	ValueBytes int    `json:"value_bytes"`
+}
+
+// StorageReport is the result of GetStorageReport.
+type StorageReport struct {
+	Families   []StorageFamily `json:"families"`
+	Keys       int             `json:"keys"`
+	TotalBytes int             `json:"total_bytes"` // keys + values
+}
+
+// storageFamilies maps keys to families: exact keys first, then prefixes
+// in order (record_hashes must not fall under the record prefix, nor
+// init_staged under init_stage). Internal families are the key-namespace
+// policy of keyspace.go.
+var storageFamilies = []struct {
+	family   string
+	internal bool
+	exact    []string
+	prefixes []string
+}{
+	{family: "params", exact: []string{"bgv_params", "dataset_spec", "n", "record_s", "reserved_from"}},
+	{family: "m_DB", internal: true, exact: []string{"m_DB", "m_DB_sha256", "m_DB_digest_alg", "m_DB_version", "m_DB_shards"}, prefixes: []string{"m_DB_"}},
+	{family: "commitment", exact: []string{"record_hashes", "records_root"}},
+	{family: "init_staging", internal: true, exact: []string{stageCountKey, rejectedCountKey}, prefixes: []string{stageKeyPrefix}},
+	{family: "staging", internal: true, exact: []string{stagingCountKey, proposalKey}, prefixes: []string{stagingPrefix}},
+	{family: "m_DB_deltas", prefixes: []string{"mdb_delta"}},
+	{family: "change_log", prefixes: []string{"mdb_changes"}},
+	{family: "m_DB_upload", internal: true, prefixes: []string{"mdb_chunk"}},
+	{family: "audit", internal: true, prefixes: []string{auditPrefix, auditPayloadKey}},
+	{family: "records", prefixes: []string{utils.RecordKeyPrefix}},
+}
+
+func storageFamily(key string) string {
+	for _, f := range storageFamilies {
+		for _, k := range f.exact {
+			if key == k {
+				return f.family
+			}
+		}
+	}
+	for _, f := range storageFamilies {
+		for _, p := range f.prefixes {
+			if strings.HasPrefix(key, p) {
+				return f.family
+			}
+		}
+	}
+	return "other"
+}
+
+// GetStorageReport returns keys and bytes per key family of this
+// chaincode's world state (a full range scan; evaluate, don't submit).
+func (cc *PIRChainCode) GetStorageReport(ctx contractapi.TransactionContextInterface) (string, error) {
+	start := time.Now()
+	iter, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return "", fmt.Errorf("GetStorageReport: %w", err)
+	}
+	defer iter.Close()
+
+	rows := make(map[string]*StorageFamily)
+	var report StorageReport
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return "", fmt.Errorf("GetStorageReport: %w", err)
+		}
+		name := storageFamily(kv.Key)
+		row := rows[name]
+		if row == nil {
+			row = &StorageFamily{Family: name}
+			rows[name] = row
+		}
+		row.Keys++
+		row.KeyBytes += len(kv.Key)
+		row.ValueBytes += len(kv.Value)
+		report.Keys++
+		report.TotalBytes += len(kv.Key) + len(kv.Value)
+	}
+
+	// every family is listed, empty ones included, in a fixed order
+	for _, f := range storageFamilies {
+		report.Families = append(report.Families, familyRow(rows, f.family))
+	}
+	report.Families = append(report.Families, familyRow(rows, "other"))
+
+	dbg("[CC][STORAGE] %d keys, %d bytes", report.Keys, report.TotalBytes)
+	return utils.MarshalTimed(report, start)
+}
+
+func familyRow(rows map[string]*StorageFamily, name string) StorageFamily {
+	row := StorageFamily{Family: name}
+	if r := rows[name]; r != nil {
+		row = *r
+	}
+	row.Internal = familyInternal(name)
+	return row
+}
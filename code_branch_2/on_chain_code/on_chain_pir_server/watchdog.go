@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"pir_shared/utils"
+)
+
+/**************  EVALUATION WATCHDOG **********************************/
+
+// evalBudget is how long one transaction may spend before the PIR
+// evaluation must have finished (PIR_EVAL_TIMEOUT_MS). Keep it below the
+// peer's CORE_CHAINCODE_EXECUTETIMEOUT (30s by default) so the chaincode
+// answers with an EvalTimeoutError instead of being killed mid-transaction.
+var evalBudget = time.Duration(envInt("PIR_EVAL_TIMEOUT_MS", 25000)) * time.Millisecond
+
+// EvalTimeoutError is returned when the evaluation would not, or did not,
+// finish within evalBudget. Error() is JSON so clients can parse it.
+type EvalTimeoutError struct {
+	Code      string  `json:"code"` // always "eval_timeout"
+	LogN      int     `json:"logN"`
+	BudgetMS  float64 `json:"budget_ms"`
+	ElapsedMS float64 `json:"elapsed_ms"`
+	EstMS     float64 `json:"est_eval_ms,omitempty"` // set when rejected up front
+	Started   bool    `json:"started"`               // false: evaluation never ran
+
+	done <-chan struct{} // closed when an abandoned evaluation returns
+}
+
+func (e *EvalTimeoutError) Error() string {
+	b, _ := json.Marshal(e)
+	return string(b)
+}
+
+// productCost is the wall time (ms) of one ct×pt product on this peer by
+// logN, the mean shard EvalMS of the last finished evaluation at that
+// logN (observeProductCost).
+var productCost sync.Map // logN → float64
+
+// observeProductCost records the shard timings of a finished evaluation.
+func observeProductCost(logN int, shards []utils.ShardUsage) {
+	if len(shards) == 0 {
+		return
+	}
+	var sum float64
+	for _, s := range shards {
+		sum += s.EvalMS
+	}
+	productCost.Store(logN, sum/float64(len(shards)))
+}
+
+// estEvalMS is the expected evaluation time of products ct×pt products at
+// logN spread over the evalWorkers pool: rounds × the product cost
+// measured on this peer. Until one was measured it is utils.EvalCostMS,
+// a whole single-shard transaction, counted once: that figure already
+// includes the decode and marshal around one product, so multiplying it
+// by the rounds would reject queries that fit.
+func estEvalMS(logN, products int) float64 {
+	v, ok := productCost.Load(logN)
+	if !ok {
+		return utils.EvalCostMS[logN]
+	}
+	rounds := (products + evalWorkers - 1) / evalWorkers
+	return float64(rounds) * v.(float64)
+}
+
+// evalWithDeadline runs f unless the estimated cost of shards ct×pt
+// products at logN (estEvalMS) no longer fits in the budget left since
+// txStart, and stops waiting for it once the budget is spent. MulNew
+// cannot be interrupted, so an abandoned f keeps running in the
+// background; callers that hold resources for it can wait on the error's
+// done channel.
+func evalWithDeadline(txStart time.Time, logN, shards int, f func() (utils.EvalUsage, error)) (utils.EvalUsage, error) {
+	remaining := evalBudget - time.Since(txStart)
+	if est := estEvalMS(logN, shards); float64(remaining.Milliseconds()) < est {
+		return utils.EvalUsage{}, &EvalTimeoutError{
+			Code: "eval_timeout", LogN: logN, EstMS: est,
+			BudgetMS:  msOf(evalBudget),
+			ElapsedMS: msOf(time.Since(txStart)),
+		}
+	}
+
+	type result struct {
+		usage utils.EvalUsage
+		err   error
+	}
+	ch := make(chan result, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		u, err := f()
+		ch <- result{u, err}
+	}()
+
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+	select {
+	case r := <-ch:
+		return r.usage, r.err
+	case <-timer.C:
+		return utils.EvalUsage{}, &EvalTimeoutError{
+			Code: "eval_timeout", LogN: logN, Started: true,
+			BudgetMS:  msOf(evalBudget),
+			ElapsedMS: msOf(time.Since(txStart)),
+			done:      done,
+		}
+	}
+}
+
+func msOf(d time.Duration) float64 { return float64(d.Nanoseconds()) / 1e6 }
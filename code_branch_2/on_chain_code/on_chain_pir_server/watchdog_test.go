@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"pir_shared/utils"
+)
+
+func TestEstEvalMS(t *testing.T) {
+	const logN = 14
+	productCost.Delete(logN)
+	defer productCost.Delete(logN)
+
+	products := 4*evalWorkers + 1 // five rounds
+	if got, want := estEvalMS(logN, products), utils.EvalCostMS[logN]; got != want {
+		t.Errorf("before a measurement: est = %.2f ms, want EvalCostMS once (%.2f ms)", got, want)
+	}
+
+	observeProductCost(logN, []utils.ShardUsage{{EvalMS: 2}, {EvalMS: 4}})
+	if got, want := estEvalMS(logN, products), 5*3.0; got != want {
+		t.Errorf("after a measurement: est = %.2f ms, want 5 rounds × 3 ms = %.2f ms", got, want)
+	}
+}
+
+func TestEvalWithDeadlineRejectsUpFront(t *testing.T) {
+	const logN = 13
+	productCost.Store(logN, float64(evalBudget.Milliseconds()))
+	defer productCost.Delete(logN)
+
+	ran := false
+	_, err := evalWithDeadline(time.Now(), logN, 2*evalWorkers, func() (utils.EvalUsage, error) {
+		ran = true
+		return utils.EvalUsage{}, nil
+	})
+	timeout, ok := err.(*EvalTimeoutError)
+	if !ok || timeout.Started || ran {
+		t.Fatalf("err = %v, ran = %v; want an up-front EvalTimeoutError", err, ran)
+	}
+}
@@ -0,0 +1,98 @@
+// Package blobstore keeps artifacts too large for the ledger or a JSON
+// snapshot (ct_q audit payloads, m_DB snapshots) outside of it. Blobs are
+// content-addressed: a Ref carries the SHA-256 of the data plus where the
+// backend put it, the Ref is what goes on-ledger, and Get refuses data that
+// does not hash to the Ref.
+package blobstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Ref addresses one stored blob.
+type Ref struct {
+	SHA256 string `json:"sha256"` // hex SHA-256 of the blob
+	Size   int    `json:"size"`
+	URI    string `json:"uri"` // backend location: file:..., s3://..., ipfs://...
+}
+
+// Key returns the content address of data.
+func Key(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Store is a blob backend.
+type Store interface {
+	Put(ctx context.Context, data []byte) (Ref, error)
+	// Get returns the blob of ref, verified against ref.SHA256.
+	Get(ctx context.Context, ref Ref) ([]byte, error)
+}
+
+// Open returns the Store for uri:
+//
+//	file:///var/lib/pir/blobs  (or a plain path)
+//	s3://bucket/prefix?endpoint=https://s3.example.com&region=eu-west-1
+//	ipfs://127.0.0.1:5001      (kubo RPC API)
+//
+// S3 credentials come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN.
+func Open(uri string) (Store, error) {
+	if !strings.Contains(uri, "://") {
+		return NewFS(uri)
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("blob store %q: %w", uri, err)
+	}
+	switch u.Scheme {
+	case "file":
+		return NewFS(u.Path)
+	case "s3":
+		q := u.Query()
+		return &S3{
+			Endpoint:     q.Get("endpoint"),
+			Region:       q.Get("region"),
+			Bucket:       u.Host,
+			Prefix:       strings.Trim(u.Path, "/"),
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	case "ipfs":
+		return &IPFS{API: "http://" + u.Host}, nil
+	default:
+		return nil, fmt.Errorf("blob store %q: unsupported scheme %q", uri, u.Scheme)
+	}
+}
+
+// Offload puts data into store when it is larger than threshold bytes and
+// reports whether it did; smaller data (or a nil store) stays inline.
+func Offload(ctx context.Context, store Store, data []byte, threshold int) (Ref, bool, error) {
+	if store == nil || len(data) <= threshold {
+		return Ref{}, false, nil
+	}
+	ref, err := store.Put(ctx, data)
+	if err != nil {
+		return Ref{}, false, err
+	}
+	return ref, true, nil
+}
+
+// verify checks data against ref.
+func verify(ref Ref, data []byte) ([]byte, error) {
+	if got := Key(data); got != ref.SHA256 {
+		return nil, fmt.Errorf("blob %s: content hashes to %s", ref.URI, got)
+	}
+	return data, nil
+}
+
+func newRef(data []byte, uri string) Ref {
+	return Ref{SHA256: Key(data), Size: len(data), URI: uri}
+}
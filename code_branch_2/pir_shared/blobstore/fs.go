@@ -0,0 +1,69 @@
+package blobstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FS stores blobs under Dir/<sha256[:2]>/<sha256>.
+type FS struct {
+	Dir string
+}
+
+// NewFS returns an FS rooted at dir, creating it if needed.
+func NewFS(dir string) (*FS, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("blob store: empty directory")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("blob store: %w", err)
+	}
+	return &FS{Dir: dir}, nil
+}
+
+func (s *FS) path(key string) string {
+	return filepath.Join(s.Dir, key[:2], key)
+}
+
+// Put writes data unless a blob with the same content exists.
+func (s *FS) Put(_ context.Context, data []byte) (Ref, error) {
+	key := Key(data)
+	path := s.path(key)
+	ref := newRef(data, "file:"+path)
+	if _, err := os.Stat(path); err == nil {
+		return ref, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return Ref{}, fmt.Errorf("blob store: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".*")
+	if err != nil {
+		return Ref{}, fmt.Errorf("blob store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return Ref{}, fmt.Errorf("blob store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return Ref{}, fmt.Errorf("blob store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return Ref{}, fmt.Errorf("blob store: %w", err)
+	}
+	return ref, nil
+}
+
+// Get reads the blob of ref from Dir; ref.URI is not consulted.
+func (s *FS) Get(_ context.Context, ref Ref) ([]byte, error) {
+	if len(ref.SHA256) != 64 {
+		return nil, fmt.Errorf("blob store: invalid key %q", ref.SHA256)
+	}
+	data, err := os.ReadFile(s.path(ref.SHA256))
+	if err != nil {
+		return nil, fmt.Errorf("blob store: %w", err)
+	}
+	return verify(ref, data)
+}
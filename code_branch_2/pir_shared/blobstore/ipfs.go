@@ -0,0 +1,85 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// IPFS stores blobs through a kubo node's RPC API (/api/v0/add, /cat).
+// The CID is only known after the upload, so Get needs ref.URI
+// (ipfs://<cid>), not just the SHA-256.
+type IPFS struct {
+	API    string       // e.g. http://127.0.0.1:5001
+	Client *http.Client // nil → http.DefaultClient
+}
+
+// Put adds and pins data.
+func (s *IPFS) Put(ctx context.Context, data []byte) (Ref, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", Key(data))
+	if err != nil {
+		return Ref{}, err
+	}
+	fw.Write(data)
+	mw.Close()
+
+	out, err := s.call(ctx, "add?pin=true&cid-version=1", mw.FormDataContentType(), &body)
+	if err != nil {
+		return Ref{}, err
+	}
+	var res struct {
+		Hash string `json:"Hash"`
+	}
+	if err := json.Unmarshal(out, &res); err != nil || res.Hash == "" {
+		return Ref{}, fmt.Errorf("ipfs add: unexpected response %q", out)
+	}
+	return newRef(data, "ipfs://"+res.Hash), nil
+}
+
+// Get fetches the CID in ref.URI.
+func (s *IPFS) Get(ctx context.Context, ref Ref) ([]byte, error) {
+	cid, ok := strings.CutPrefix(ref.URI, "ipfs://")
+	if !ok || cid == "" {
+		return nil, fmt.Errorf("ipfs: ref %q has no ipfs:// URI", ref.URI)
+	}
+	data, err := s.call(ctx, "cat?arg="+url.QueryEscape(cid), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return verify(ref, data)
+}
+
+func (s *IPFS) call(ctx context.Context, cmd, contentType string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(s.API, "/")+"/api/v0/"+cmd, body)
+	if err != nil {
+		return nil, fmt.Errorf("ipfs %s: %w", cmd, err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ipfs %s: %w", cmd, err)
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("ipfs %s: %w", cmd, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("ipfs %s: %s: %s", cmd, resp.Status, bytes.TrimSpace(out))
+	}
+	return out, nil
+}
@@ -0,0 +1,146 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// S3 stores blobs as objects <Prefix>/<sha256> in Bucket of an
+// S3-compatible endpoint (AWS, MinIO, Ceph), using path-style URLs and
+// SigV4 signing so no SDK is needed.
+type S3 struct {
+	Endpoint     string // e.g. https://s3.eu-west-1.amazonaws.com; empty → AWS for Region
+	Region       string // empty → us-east-1
+	Bucket       string
+	Prefix       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Client       *http.Client // nil → http.DefaultClient
+}
+
+func (s *S3) region() string {
+	if s.Region == "" {
+		return "us-east-1"
+	}
+	return s.Region
+}
+
+func (s *S3) endpoint() string {
+	if s.Endpoint == "" {
+		return "https://s3." + s.region() + ".amazonaws.com"
+	}
+	return strings.TrimRight(s.Endpoint, "/")
+}
+
+func (s *S3) objectPath(key string) string {
+	if s.Prefix == "" {
+		return "/" + s.Bucket + "/" + key
+	}
+	return "/" + s.Bucket + "/" + s.Prefix + "/" + key
+}
+
+// Put uploads data; objects are immutable, so re-putting is harmless.
+func (s *S3) Put(ctx context.Context, data []byte) (Ref, error) {
+	key := Key(data)
+	path := s.objectPath(key)
+	if _, err := s.do(ctx, http.MethodPut, path, data); err != nil {
+		return Ref{}, err
+	}
+	return newRef(data, "s3://"+strings.TrimPrefix(path, "/")), nil
+}
+
+// Get downloads the object for ref.SHA256.
+func (s *S3) Get(ctx context.Context, ref Ref) ([]byte, error) {
+	data, err := s.do(ctx, http.MethodGet, s.objectPath(ref.SHA256), nil)
+	if err != nil {
+		return nil, err
+	}
+	return verify(ref, data)
+}
+
+func (s *S3) do(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.endpoint()+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s %s: %w", method, path, err)
+	}
+	s.sign(req, body, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s %s: %w", method, path, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(out))
+	}
+	return out, nil
+}
+
+// sign adds SigV4 headers for an unsigned-query request with body.
+func (s *S3) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := hexSHA256(body)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if s.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.SessionToken)
+		signed = append(signed, "x-amz-security-token")
+	}
+
+	var canonHeaders strings.Builder
+	for _, h := range signed {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		canonHeaders.WriteString(h + ":" + strings.TrimSpace(v) + "\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+	canonical := strings.Join([]string{
+		req.Method, req.URL.EscapedPath(), req.URL.RawQuery,
+		canonHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.region() + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonical))
+
+	k := hmacSHA256([]byte("AWS4"+s.SecretKey), day)
+	k = hmacSHA256(k, s.region())
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(k, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, sig))
+}
+
+func hexSHA256(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, msg string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(msg))
+	return m.Sum(nil)
+}
@@ -0,0 +1,418 @@
+// cmd/ccpack/main.go
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ccpack builds a Fabric chaincode package (the tar.gz of
+// `peer lifecycle chaincode package --lang golang`) that is byte for byte
+// reproducible, so every org can rebuild it from the same commit and get
+// the same package ID, and verifies a package (-verify) someone else built.
+//
+// The chaincode module is copied with its dependencies vendored (go mod
+// vendor resolves the pir_shared replace), hidden files dropped, entries
+// sorted and every tar/gzip header normalized (mtime 0, uid/gid 0, mode
+// 0644 or 0755, no gzip name/mtime).
+//
+// code_sha256 is the digest of the packed source, independent of tar and
+// gzip: the sha256 of the sha256sum-style manifest "<hex>  <path>\n" of
+// every file under src/ except zz_provenance.go, in byte order. ccpack
+// writes that file into the package so the chaincode knows its own digest
+// and records it under "code_provenance" at init (see provenance.go in the
+// chaincode). Without ccpack, in an unpacked package:
+//
+//	cd src && find . -type f ! -name zz_provenance.go | sed 's|^\./||' |
+//	    LC_ALL=C sort | xargs sha256sum | sha256sum
+//
+// The package ID hash also depends on the Go release that compressed it;
+// code_sha256 does not.
+//
+// With -tree, ccpack writes the staged source (vendor/ and zz_provenance.go
+// included) to a directory instead of packing it. fablo packages a
+// chaincode straight from a directory, and the module's own directory does
+// not build there: its pir_shared replace points outside it.
+
+const provenanceFile = "zz_provenance.go"
+
+var (
+	srcDir   = flag.String("src", "", "chaincode module directory")
+	label    = flag.String("label", "on_chain_pir_0.0.1", "package label")
+	ccPath   = flag.String("path", "", "metadata.json path (default: module path from go.mod)")
+	outFile  = flag.String("out", "", "output package (default: <label>.tar.gz)")
+	doVendor = flag.Bool("vendor", true, "vendor dependencies into the package")
+	doBuild  = flag.Bool("build", true, "check the packed tree builds with -mod=vendor")
+	verify   = flag.String("verify", "", "verify this package instead of building one")
+	treeDir  = flag.String("tree", "", "write the staged source tree to this directory (replacing it) instead of a package")
+)
+
+var epoch = time.Unix(0, 0)
+
+// packageMetadata is metadata.json of a chaincode package.
+type packageMetadata struct {
+	Path  string `json:"path"`
+	Type  string `json:"type"`
+	Label string `json:"label"`
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Parse()
+	if *verify != "" {
+		if err := verifyPackage(*verify); err != nil {
+			log.Fatalf("verify %s: %v", *verify, err)
+		}
+		return
+	}
+	if *srcDir == "" {
+		log.Fatal("-src is required")
+	}
+	out := *outFile
+	if out == "" {
+		out = *label + ".tar.gz"
+	}
+	if err := pack(*srcDir, out); err != nil {
+		log.Fatalf("ccpack: %v", err)
+	}
+}
+
+func pack(src, out string) error {
+	modPath, err := modulePath(src)
+	if err != nil {
+		return err
+	}
+	if *ccPath != "" {
+		modPath = *ccPath
+	}
+	stage, err := os.MkdirTemp("", "ccpack-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stage)
+
+	// ---- 1) Stage source + vendor ----
+	if err := copyTree(src, stage); err != nil {
+		return err
+	}
+	if *doVendor {
+		if err := goCmd(src, "mod", "vendor", "-o", filepath.Join(stage, "vendor")); err != nil {
+			return fmt.Errorf("go mod vendor: %w", err)
+		}
+	}
+	files, err := listFiles(stage)
+	if err != nil {
+		return err
+	}
+
+	// ---- 2) Code digest, compiled into the chaincode ----
+	codeSum, err := manifestDigest(stage, files)
+	if err != nil {
+		return err
+	}
+	gen := fmt.Sprintf("// Code generated by ccpack; DO NOT EDIT.\n\npackage main\n\nfunc init() { codeSHA256 = %q }\n", codeSum)
+	if err := os.WriteFile(filepath.Join(stage, provenanceFile), []byte(gen), 0o644); err != nil {
+		return err
+	}
+	files = append(files, provenanceFile)
+	sort.Strings(files)
+	if *doBuild {
+		if err := goCmd(stage, "build", "-mod=vendor", "-o", os.DevNull, "."); err != nil {
+			return fmt.Errorf("packed tree does not build: %w", err)
+		}
+	}
+	if *treeDir != "" {
+		if err := os.RemoveAll(*treeDir); err != nil {
+			return err
+		}
+		for _, f := range files {
+			src := filepath.Join(stage, filepath.FromSlash(f))
+			fi, err := os.Stat(src)
+			if err != nil {
+				return err
+			}
+			b, err := os.ReadFile(src)
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(*treeDir, filepath.FromSlash(f))
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(target, b, fi.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("tree:        %s (%d files)\n", *treeDir, len(files))
+		fmt.Printf("code_sha256: %s\n", codeSum)
+		return nil
+	}
+
+	// ---- 3) code.tar.gz, then the package around it ----
+	var code bytes.Buffer
+	if err := writeTarGz(&code, func(tw *tar.Writer) error {
+		for _, f := range files {
+			b, err := os.ReadFile(filepath.Join(stage, filepath.FromSlash(f)))
+			if err != nil {
+				return err
+			}
+			fi, err := os.Stat(filepath.Join(stage, filepath.FromSlash(f)))
+			if err != nil {
+				return err
+			}
+			if err := writeEntry(tw, "src/"+f, b, fi.Mode()&0o111 != 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	meta, _ := json.Marshal(packageMetadata{Path: modPath, Type: "golang", Label: *label})
+	var pkg bytes.Buffer
+	if err := writeTarGz(&pkg, func(tw *tar.Writer) error {
+		if err := writeEntry(tw, "metadata.json", meta, false); err != nil {
+			return err
+		}
+		return writeEntry(tw, "code.tar.gz", code.Bytes(), false)
+	}); err != nil {
+		return err
+	}
+	if err := os.WriteFile(out, pkg.Bytes(), 0o644); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(pkg.Bytes())
+	fmt.Printf("package:     %s (%d files, %d bytes)\n", out, len(files), pkg.Len())
+	fmt.Printf("package_id:  %s:%s\n", *label, hex.EncodeToString(sum[:]))
+	fmt.Printf("code_sha256: %s\n", codeSum)
+	return nil
+}
+
+// verifyPackage re-derives code_sha256 from the package contents and
+// checks it against the zz_provenance.go inside.
+func verifyPackage(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	outer, err := readTarGz(raw)
+	if err != nil {
+		return err
+	}
+	var meta packageMetadata
+	if err := json.Unmarshal(outer["metadata.json"], &meta); err != nil {
+		return fmt.Errorf("metadata.json: %w", err)
+	}
+	if outer["code.tar.gz"] == nil {
+		return fmt.Errorf("no code.tar.gz")
+	}
+	entries, err := readTarGz(outer["code.tar.gz"])
+	if err != nil {
+		return fmt.Errorf("code.tar.gz: %w", err)
+	}
+	gen, ok := entries["src/"+provenanceFile]
+	if !ok {
+		return fmt.Errorf("no src/%s - not built by ccpack", provenanceFile)
+	}
+	m := regexp.MustCompile(`codeSHA256 = "([0-9a-f]{64})"`).FindSubmatch(gen)
+	if m == nil {
+		return fmt.Errorf("src/%s: no code digest", provenanceFile)
+	}
+
+	var files []string
+	contents := map[string][]byte{}
+	for name, b := range entries {
+		rel, ok := strings.CutPrefix(name, "src/")
+		if !ok {
+			return fmt.Errorf("entry %q outside src/", name)
+		}
+		if rel != provenanceFile {
+			files = append(files, rel)
+			contents[rel] = b
+		}
+	}
+	sort.Strings(files)
+	got := manifestOf(files, func(f string) []byte { return contents[f] })
+	if got != string(m[1]) {
+		return fmt.Errorf("code digest %s, %s claims %s", got, provenanceFile, m[1])
+	}
+
+	sum := sha256.Sum256(raw)
+	fmt.Printf("label:       %s (path %s, type %s)\n", meta.Label, meta.Path, meta.Type)
+	fmt.Printf("package_id:  %s:%s\n", meta.Label, hex.EncodeToString(sum[:]))
+	fmt.Printf("code_sha256: %s (matches %s)\n", got, provenanceFile)
+	return nil
+}
+
+// modulePath reads the module line of src/go.mod.
+func modulePath(src string) (string, error) {
+	b, err := os.ReadFile(filepath.Join(src, "go.mod"))
+	if err != nil {
+		return "", fmt.Errorf("%s is not a Go module: %w", src, err)
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if f := strings.Fields(line); len(f) == 2 && f[0] == "module" {
+			return strings.Trim(f[1], `"`), nil
+		}
+	}
+	return "", fmt.Errorf("%s/go.mod has no module line", src)
+}
+
+// copyTree copies the regular files of src into dst, skipping hidden
+// files, vendor/ (re-vendored) and a stale zz_provenance.go.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, p)
+		if rel == "." {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") || (d.IsDir() && rel == "vendor") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() || rel == provenanceFile {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(target, b, fi.Mode().Perm())
+	})
+}
+
+// listFiles returns the regular files under root as sorted slash paths.
+func listFiles(root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+func manifestDigest(root string, files []string) (string, error) {
+	var readErr error
+	sum := manifestOf(files, func(f string) []byte {
+		b, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(f)))
+		if err != nil && readErr == nil {
+			readErr = err
+		}
+		return b
+	})
+	return sum, readErr
+}
+
+// manifestOf is the sha256 of "<sha256 hex>  <path>\n" over sorted files.
+func manifestOf(files []string, content func(string) []byte) string {
+	h := sha256.New()
+	for _, f := range files {
+		s := sha256.Sum256(content(f))
+		fmt.Fprintf(h, "%s  %s\n", hex.EncodeToString(s[:]), f)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeTarGz writes a gzip'd tar with a zero gzip header.
+func writeTarGz(w io.Writer, entries func(*tar.Writer) error) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := entries(tw); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeEntry(tw *tar.Writer, name string, b []byte, exec bool) error {
+	mode := int64(0o644)
+	if exec {
+		mode = 0o755
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     mode,
+		Size:     int64(len(b)),
+		ModTime:  epoch,
+		Format:   tar.FormatPAX,
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(b)
+	return err
+}
+
+// readTarGz returns the regular-file entries of a tar.gz by name.
+func readTarGz(raw []byte) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	out := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if _, dup := out[hdr.Name]; dup {
+			return nil, fmt.Errorf("duplicate entry %q", hdr.Name)
+		}
+		if out[hdr.Name], err = io.ReadAll(tr); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// goCmd runs the go tool in dir outside any workspace.
+func goCmd(dir string, args ...string) error {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=")
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	return cmd.Run()
+}
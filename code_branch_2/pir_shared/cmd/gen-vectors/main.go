@@ -0,0 +1,383 @@
+// cmd/gen-vectors/main.go
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"pir_shared/gen_records"
+	"pir_shared/utils"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
+)
+
+// gen-vectors writes the PIR test-vector suite that independent client
+// implementations check themselves against, and re-verifies a suite
+// (-verify) against this Go reference.
+//
+// One file per parameter set holds the BGV params (with the actual Q/P
+// moduli), a key pair, the records and the encoded m_DB they pack into,
+// and one case per selected index: selector slots and plaintext, ct_q,
+// the expected ct_r = ct_q × m_DB and its decrypted window and record.
+// Encryption is randomized, so an implementation is expected to:
+//
+//   - decrypt ct_q with sk and get selector_slots;
+//   - evaluate ct_q × m_DB and get ct_r byte for byte (the product with a
+//     plaintext is deterministic);
+//   - decrypt ct_r with sk, read result_window and trim it to record;
+//   - send its own ct_q for index to a server and decrypt the answer.
+//
+// -leakage instead runs utils.CheckPackingIsolation on the same records
+// for every LogN: each index's selector × packed shard, under every
+// packing mode, must hold that record and zeros only. It needs no keys or
+// server and exits non-zero on a leak, for CI.
+//
+// Binary fields are Base64 of Lattigo v6 MarshalBinary (the wire format of
+// PIRQuery and the off-chain /invoke); slot vectors are plain JSON arrays.
+
+const vectorsVersion = 1
+
+var (
+	outDir  = flag.String("out", "testvectors", "output directory")
+	logNs   = flag.String("logN", "13", "comma-separated LogN values")
+	nRecs   = flag.Int("n", 32, "records per vector file")
+	maxJSON = flag.Int("maxJSON", 128, "record size (gen_records maxJsonLength)")
+	verify  = flag.String("verify", "", "verify this vector file instead of generating")
+	leakage = flag.Bool("leakage", false, "check every packing mode for neighbour leakage instead of generating")
+)
+
+// VectorFile is one parameter set of the suite.
+type VectorFile struct {
+	Version     int          `json:"version"`
+	Params      VectorParams `json:"params"`
+	NRecords    int          `json:"n"`
+	RecordS     int          `json:"record_s"`
+	Stride      int          `json:"stride"`
+	Records     []string     `json:"records"`
+	SKB64       string       `json:"sk_b64"`
+	PKB64       string       `json:"pk_b64"`
+	MDBSlots    []uint64     `json:"m_db_slots"`
+	MDBB64      string       `json:"m_db_b64"` // rlwe.Plaintext at MaxLevel
+	MDBSHA256   string       `json:"m_db_sha256"`
+	Cases       []VectorCase `json:"cases"`
+	GeneratedBy string       `json:"generated_by"`
+}
+
+// VectorParams pins the BGV parameters down to the moduli.
+type VectorParams struct {
+	LogN  int      `json:"logN"`
+	N     int      `json:"N"`
+	LogQi []int    `json:"logQi"`
+	LogPi []int    `json:"logPi"`
+	Q     []uint64 `json:"Q"`
+	P     []uint64 `json:"P"`
+	T     uint64   `json:"t"`
+	Slots int      `json:"slots"`
+}
+
+// VectorCase is one PIR round trip for Index.
+type VectorCase struct {
+	Index         int      `json:"index"`
+	Window        [2]int   `json:"window"` // [start, end) slots of the record
+	SelectorSlots []uint64 `json:"selector_slots"`
+	SelectorB64   string   `json:"selector_pt_b64"`
+	CtQB64        string   `json:"ct_q_b64"`
+	CtRB64        string   `json:"ct_r_b64"`
+	ResultWindow  []uint64 `json:"result_window"`
+	Record        string   `json:"record"`
+}
+
+func main() {
+	flag.Parse()
+	if *verify != "" {
+		if err := verifyFile(*verify); err != nil {
+			log.Fatalf("verify %s: %v", *verify, err)
+		}
+		fmt.Printf("%s: OK\n", *verify)
+		return
+	}
+
+	var list []int
+	if err := json.Unmarshal([]byte("["+*logNs+"]"), &list); err != nil {
+		log.Fatalf("invalid -logN %q: %v", *logNs, err)
+	}
+	if *leakage {
+		for _, logN := range list {
+			if err := checkLeakage(logN, *nRecs, *maxJSON); err != nil {
+				log.Fatalf("logN=%d: %v", logN, err)
+			}
+			fmt.Printf("logN=%d: %d records, packing %v: no leakage\n", logN, *nRecs, utils.PackingModes)
+		}
+		return
+	}
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		log.Fatal(err)
+	}
+	for _, logN := range list {
+		vf, err := generate(logN, *nRecs, *maxJSON)
+		if err != nil {
+			log.Fatalf("logN=%d: %v", logN, err)
+		}
+		path := filepath.Join(*outDir, fmt.Sprintf("pir_logN%d.json", logN))
+		raw, _ := json.Marshal(vf)
+		if err := os.WriteFile(path, append(raw, '\n'), 0o644); err != nil {
+			log.Fatal(err)
+		}
+		if err := verifyFile(path); err != nil {
+			log.Fatalf("%s does not verify: %v", path, err)
+		}
+		fmt.Printf("wrote %s (%d cases, %d bytes)\n", path, len(vf.Cases), len(raw)+1)
+	}
+}
+
+// checkLeakage packs n generated records for logN under every packing
+// mode and checks the modelled result of every index.
+func checkLeakage(logN, n, maxJSON int) error {
+	params, err := utils.BuildParamsFromHint(utils.BGVParamHint{LogN: logN})
+	if err != nil {
+		return err
+	}
+	records, err := gen_records.GenerateRecords(n, logN, maxJSON)
+	if err != nil {
+		return err
+	}
+	return utils.CheckPackingIsolation(records, params.MaxSlots())
+}
+
+func generate(logN, n, maxJSON int) (*VectorFile, error) {
+	params, err := utils.BuildParamsFromHint(utils.BGVParamHint{LogN: logN})
+	if err != nil {
+		return nil, err
+	}
+	records, err := gen_records.GenerateRecords(n, logN, maxJSON)
+	if err != nil {
+		return nil, err
+	}
+	ic := utils.IndexContract{NRecords: len(records), RecordS: utils.CalcSlotsPerRec(records), Slots: params.MaxSlots()}
+	if err := ic.Validate(); err != nil {
+		return nil, err
+	}
+	packed, err := ic.Pack(records)
+	if err != nil {
+		return nil, err
+	}
+	mDB, err := encode(params, packed)
+	if err != nil {
+		return nil, err
+	}
+	mDBRaw, err := mDB.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	kgen := rlwe.NewKeyGenerator(params)
+	sk, pk := kgen.GenKeyPairNew()
+	skRaw, _ := sk.MarshalBinary()
+	pkRaw, _ := pk.MarshalBinary()
+
+	vf := &VectorFile{
+		Version: vectorsVersion,
+		Params: VectorParams{
+			LogN: params.LogN(), N: params.N(), LogQi: params.LogQi(), LogPi: params.LogPi(),
+			Q: params.Q(), P: params.P(), T: params.PlaintextModulus(), Slots: params.MaxSlots(),
+		},
+		NRecords:    ic.NRecords,
+		RecordS:     ic.RecordS,
+		Stride:      ic.Stride(),
+		SKB64:       b64(skRaw),
+		PKB64:       b64(pkRaw),
+		MDBSlots:    packed,
+		MDBB64:      b64(mDBRaw),
+		MDBSHA256:   utils.RecordHash(mDBRaw),
+		GeneratedBy: "pir_shared/cmd/gen-vectors",
+	}
+	for _, rec := range records {
+		vf.Records = append(vf.Records, string(rec))
+	}
+
+	enc := rlwe.NewEncryptor(params, pk)
+	eval := bgv.NewEvaluator(params, nil)
+	for _, idx := range []int{0, ic.NRecords / 2, ic.NRecords - 1} {
+		start, end, err := ic.Window(idx)
+		if err != nil {
+			return nil, err
+		}
+		sel := make([]uint64, params.MaxSlots())
+		for i := start; i < end; i++ {
+			sel[i] = 1
+		}
+		selPt, err := encode(params, sel)
+		if err != nil {
+			return nil, err
+		}
+		ctQ, err := enc.EncryptNew(selPt)
+		if err != nil {
+			return nil, err
+		}
+		ctR, err := eval.MulNew(ctQ, mDB)
+		if err != nil {
+			return nil, err
+		}
+		slots, err := decrypt(params, sk, ctR)
+		if err != nil {
+			return nil, err
+		}
+		selRaw, _ := selPt.MarshalBinary()
+		ctQRaw, _ := ctQ.MarshalBinary()
+		ctRRaw, _ := ctR.MarshalBinary()
+		vf.Cases = append(vf.Cases, VectorCase{
+			Index:         idx,
+			Window:        [2]int{start, end},
+			SelectorSlots: sel,
+			SelectorB64:   b64(selRaw),
+			CtQB64:        b64(ctQRaw),
+			CtRB64:        b64(ctRRaw),
+			ResultWindow:  slots[start:end],
+			Record:        string(utils.TrimPadding(windowBytes(slots[start:end]))),
+		})
+	}
+	return vf, nil
+}
+
+// verifyFile re-derives everything a client implementation is checked on
+// from the file's own keys and m_DB.
+func verifyFile(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var vf VectorFile
+	if err := json.Unmarshal(raw, &vf); err != nil {
+		return err
+	}
+	if vf.Version != vectorsVersion {
+		return fmt.Errorf("version %d, want %d", vf.Version, vectorsVersion)
+	}
+	params, err := utils.BuildParamsFromHint(utils.BGVParamHint{
+		LogN: vf.Params.LogN, LogQi: vf.Params.LogQi, LogPi: vf.Params.LogPi, T: vf.Params.T,
+	})
+	if err != nil {
+		return err
+	}
+	if fmt.Sprint(params.Q(), params.P()) != fmt.Sprint(vf.Params.Q, vf.Params.P) {
+		return fmt.Errorf("moduli mismatch: built Q=%v P=%v", params.Q(), params.P())
+	}
+
+	sk := rlwe.NewSecretKey(params)
+	if err := unb64(vf.SKB64, sk.UnmarshalBinary); err != nil {
+		return fmt.Errorf("sk: %w", err)
+	}
+	pk := rlwe.NewPublicKey(params)
+	if err := unb64(vf.PKB64, pk.UnmarshalBinary); err != nil {
+		return fmt.Errorf("pk: %w", err)
+	}
+
+	ic := utils.IndexContract{NRecords: vf.NRecords, RecordS: vf.RecordS, Slots: params.MaxSlots()}
+	records := make([][]byte, len(vf.Records))
+	for i, r := range vf.Records {
+		records[i] = []byte(r)
+	}
+	packed, err := ic.Pack(records)
+	if err != nil {
+		return err
+	}
+	if fmt.Sprint(packed) != fmt.Sprint(vf.MDBSlots) {
+		return fmt.Errorf("m_db_slots do not match the packed records")
+	}
+	mDBRaw, err := base64.StdEncoding.DecodeString(vf.MDBB64)
+	if err != nil {
+		return fmt.Errorf("m_DB: %w", err)
+	}
+	if utils.RecordHash(mDBRaw) != vf.MDBSHA256 {
+		return fmt.Errorf("m_DB does not hash to m_db_sha256")
+	}
+	mDB, err := utils.UnmarshalPlaintext(params, mDBRaw)
+	if err != nil {
+		return fmt.Errorf("m_DB: %w", err)
+	}
+	if want, _ := encode(params, packed); !mDB.Equal(want) {
+		return fmt.Errorf("m_DB is not the encoding of m_db_slots")
+	}
+
+	eval := bgv.NewEvaluator(params, nil)
+	for _, c := range vf.Cases {
+		start, end, err := ic.Window(c.Index)
+		if err != nil {
+			return err
+		}
+		if c.Window != [2]int{start, end} {
+			return fmt.Errorf("case %d: window %v, want [%d %d]", c.Index, c.Window, start, end)
+		}
+		ctQ, _, err := utils.DecodeQuery(params, c.CtQB64)
+		if err != nil {
+			return fmt.Errorf("case %d: ct_q: %w", c.Index, err)
+		}
+		sel, err := decrypt(params, sk, ctQ)
+		if err != nil {
+			return err
+		}
+		if fmt.Sprint(sel) != fmt.Sprint(c.SelectorSlots) {
+			return fmt.Errorf("case %d: ct_q does not decrypt to selector_slots", c.Index)
+		}
+		ctR, err := eval.MulNew(ctQ, mDB)
+		if err != nil {
+			return err
+		}
+		ctRRaw, _ := ctR.MarshalBinary()
+		if b64(ctRRaw) != c.CtRB64 {
+			return fmt.Errorf("case %d: ct_q × m_DB differs from ct_r", c.Index)
+		}
+		slots, err := decrypt(params, sk, ctR)
+		if err != nil {
+			return err
+		}
+		if fmt.Sprint(slots[start:end]) != fmt.Sprint(c.ResultWindow) {
+			return fmt.Errorf("case %d: ct_r does not decrypt to result_window", c.Index)
+		}
+		if got := string(utils.TrimPadding(windowBytes(c.ResultWindow))); got != c.Record || got != vf.Records[c.Index] {
+			return fmt.Errorf("case %d: record %q, want %q", c.Index, got, vf.Records[c.Index])
+		}
+	}
+	return nil
+}
+
+func encode(params bgv.Parameters, slots []uint64) (*rlwe.Plaintext, error) {
+	pt := bgv.NewPlaintext(params, params.MaxLevel())
+	if err := bgv.NewEncoder(params).Encode(slots, pt); err != nil {
+		return nil, err
+	}
+	return pt, nil
+}
+
+func decrypt(params bgv.Parameters, sk *rlwe.SecretKey, ct *rlwe.Ciphertext) ([]uint64, error) {
+	out := make([]uint64, params.MaxSlots())
+	pt := rlwe.NewDecryptor(params, sk).DecryptNew(ct)
+	if err := bgv.NewEncoder(params).Decode(pt, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func windowBytes(slots []uint64) []byte {
+	out := make([]byte, len(slots))
+	for i, v := range slots {
+		out[i] = byte(v)
+	}
+	return out
+}
+
+func b64(b []byte) string { return base64.StdEncoding.EncodeToString(b) }
+
+func unb64(s string, unmarshal func([]byte) error) error {
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return unmarshal(raw)
+}
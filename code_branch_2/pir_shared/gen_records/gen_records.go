@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"log"
 
-	"off-chain-pir-server/internal/utils"
+	"pir_shared/utils"
 )
 
 // CTIRecordMini для channel_mini: компактные записи.
@@ -43,7 +43,20 @@ var malwareClasses = []string{"Trojan", "Worm", "Ransomware", "Backdoor", "Spywa
 var malwareFamilies = []string{"Emotet", "WannaCry", "Ryuk", "AgentTesla", "Pegasus"}
 var threatLevels = []string{"Low", "Medium", "High", "Critical"}
 
+// ThreatLevels and MalwareFamilies are the values GenerateRecords draws
+// from: the public bucket domain of GetPublicStats.
+func ThreatLevels() []string    { return append([]string{}, threatLevels...) }
+func MalwareFamilies() []string { return append([]string{}, malwareFamilies...) }
+
 func GenerateRecords(n int, logN int, maxJsonLength int) ([][]byte, error) {
+	return GenerateRecordsSeeded(n, logN, maxJsonLength, "")
+}
+
+// GenerateRecordsSeeded is GenerateRecords with the hashes and padding
+// drawn from seed (utils.SeededFakeHash). The records depend on the
+// arguments only, in index order, so every endorsing peer generates the
+// same bytes; seed "" is GenerateRecords.
+func GenerateRecordsSeeded(n int, logN int, maxJsonLength int, seed string) ([][]byte, error) {
 	// 1. Checking allowed values of maxJsonLength
 	validLengths := []int{64, 128, 224, 256, 384, 512}
 	valid := false
@@ -70,21 +83,21 @@ func GenerateRecords(n int, logN int, maxJsonLength int) ([][]byte, error) {
 	log.Printf("[INFO] Generating %d records for logN=%d with target max JSON length: %d bytes", n, logN, maxJsonLength)
 
 	// 3. Determine record type based on logN
-	var generateFunc func(int, int, int) ([]byte, error)
+	var generateFunc func(int, int, int, string) ([]byte, error)
 	switch logN {
 	case 13:
 		generateFunc = generateMiniRecord
 	case 14:
 		generateFunc = generateMidRecord
-	case 15:
+	case 15, 16:
 		generateFunc = generateRichRecord
 	default:
-		return nil, fmt.Errorf("unsupported logN value: %d. Supported values: 13, 14, 15", logN)
+		return nil, fmt.Errorf("unsupported logN value: %d. Supported values: 13, 14, 15, 16", logN)
 	}
 
 	// 4. Generating records based on the logN parameter
 	for i := 0; i < n; i++ {
-		recBytes, err := generateFunc(i, maxJsonLength, n)
+		recBytes, err := generateFunc(i, maxJsonLength, n, seed)
 		if err != nil {
 			return nil, fmt.Errorf("failed to generate record %d: %w", i, err)
 		}
@@ -99,7 +112,7 @@ func GenerateRecords(n int, logN int, maxJsonLength int) ([][]byte, error) {
 	return records, nil
 }
 
-func generateRichRecord(i int, maxJsonLength int, total int) ([]byte, error) {
+func generateRichRecord(i int, maxJsonLength int, total int, seed string) ([]byte, error) {
 	baseRec := CTIRecordRich{
 		MalwareClass:  malwareClasses[i%len(malwareClasses)],
 		MalwareFamily: malwareFamilies[i%len(malwareFamilies)],
@@ -123,13 +136,13 @@ func generateRichRecord(i int, maxJsonLength int, total int) ([]byte, error) {
 	shaLen := 64
 
 	finalRec := CTIRecordRich{
-		MD5:           utils.FakeHash("md5", i, md5Len),
-		SHA256:        utils.FakeHash("sha", i, shaLen),
+		MD5:           utils.SeededFakeHash(seed, "md5", i, md5Len),
+		SHA256:        utils.SeededFakeHash(seed, "sha", i, shaLen),
 		MalwareClass:  baseRec.MalwareClass,
 		MalwareFamily: baseRec.MalwareFamily,
 		AVDetects:     baseRec.AVDetects,
 		ThreatLevel:   baseRec.ThreatLevel,
-		Padding:       utils.FakeHash("pad", i, remaining),
+		Padding:       utils.SeededFakeHash(seed, "pad", i, remaining),
 	}
 	recBytes, err := json.Marshal(finalRec)
 	if err != nil {
@@ -138,7 +151,7 @@ func generateRichRecord(i int, maxJsonLength int, total int) ([]byte, error) {
 	return recBytes, nil
 }
 
-func generateMidRecord(i int, maxJsonLength int, total int) ([]byte, error) {
+func generateMidRecord(i int, maxJsonLength int, total int, seed string) ([]byte, error) {
 	baseRec := CTIRecordMid{
 		MalwareClass:  malwareClasses[i%len(malwareClasses)],
 		MalwareFamily: malwareFamilies[i%len(malwareFamilies)],
@@ -166,13 +179,13 @@ func generateMidRecord(i int, maxJsonLength int, total int) ([]byte, error) {
 	shaShortLen := 16
 
 	finalRec := CTIRecordMid{
-		MD5:           utils.FakeHash("md5", i, md5Len),
-		SHA256Short:   utils.FakeHash("sha_short", i, shaShortLen),
+		MD5:           utils.SeededFakeHash(seed, "md5", i, md5Len),
+		SHA256Short:   utils.SeededFakeHash(seed, "sha_short", i, shaShortLen),
 		MalwareClass:  baseRec.MalwareClass,
 		MalwareFamily: baseRec.MalwareFamily,
 		AVDetects:     baseRec.AVDetects,
 		ThreatLevel:   baseRec.ThreatLevel,
-		Padding:       utils.FakeHash("pad", i, remaining),
+		Padding:       utils.SeededFakeHash(seed, "pad", i, remaining),
 	}
 	recBytes, err := json.Marshal(finalRec)
 	if err != nil {
@@ -181,7 +194,7 @@ func generateMidRecord(i int, maxJsonLength int, total int) ([]byte, error) {
 	return recBytes, nil
 }
 
-func generateMiniRecord(i int, maxJsonLength int, total int) ([]byte, error) {
+func generateMiniRecord(i int, maxJsonLength int, total int, seed string) ([]byte, error) {
 	baseRec := CTIRecordMini{
 		MalwareFamily: malwareFamilies[i%len(malwareFamilies)],
 		ThreatLevel:   threatLevels[i%len(threatLevels)],
@@ -202,10 +215,10 @@ func generateMiniRecord(i int, maxJsonLength int, total int) ([]byte, error) {
 	md5Len := 32
 
 	finalRec := CTIRecordMini{
-		MD5:           utils.FakeHash("md5", i, md5Len),
+		MD5:           utils.SeededFakeHash(seed, "md5", i, md5Len),
 		MalwareFamily: baseRec.MalwareFamily,
 		ThreatLevel:   baseRec.ThreatLevel,
-		Padding:       utils.FakeHash("pad", i, remaining),
+		Padding:       utils.SeededFakeHash(seed, "pad", i, remaining),
 	}
 	recBytes, err := json.Marshal(finalRec)
 	if err != nil {
@@ -0,0 +1,114 @@
+package gen_records
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"pir_shared/utils"
+)
+
+/********* СХЕМА ЗАПИСЕЙ ****************************************************/
+
+// schemaFor returns an empty record of the schema GenerateRecords uses for
+// logN (mini / mid / rich).
+func schemaFor(logN int) (interface{}, error) {
+	switch logN {
+	case 13:
+		return &CTIRecordMini{}, nil
+	case 14:
+		return &CTIRecordMid{}, nil
+	case 15, 16:
+		return &CTIRecordRich{}, nil
+	}
+	return nil, fmt.Errorf("unsupported logN value: %d. Supported values: 13, 14, 15, 16", logN)
+}
+
+// ValidateRecord checks rec against the logN schema: a single JSON object
+// with no unknown fields, every non-padding string field set and
+// av_detects >= 0. It returns the decoded record.
+func ValidateRecord(logN int, rec []byte) (interface{}, error) {
+	v, err := schemaFor(logN)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(rec))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return nil, fmt.Errorf("record does not match the logN=%d schema: %w", logN, err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("record has trailing data")
+	}
+
+	var missing string
+	switch r := v.(type) {
+	case *CTIRecordMini:
+		missing = firstEmpty(map[string]string{"md5": r.MD5, "malware_family": r.MalwareFamily, "threat_level": r.ThreatLevel})
+	case *CTIRecordMid:
+		missing = firstEmpty(map[string]string{"md5": r.MD5, "sha256_short": r.SHA256Short,
+			"malware_class": r.MalwareClass, "malware_family": r.MalwareFamily, "threat_level": r.ThreatLevel})
+		if r.AVDetects < 0 {
+			return nil, fmt.Errorf("field \"av_detects\" must be >= 0")
+		}
+	case *CTIRecordRich:
+		missing = firstEmpty(map[string]string{"md5": r.MD5, "sha256": r.SHA256,
+			"malware_class": r.MalwareClass, "malware_family": r.MalwareFamily, "threat_level": r.ThreatLevel})
+		if r.AVDetects < 0 {
+			return nil, fmt.Errorf("field \"av_detects\" must be >= 0")
+		}
+	}
+	if missing != "" {
+		return nil, fmt.Errorf("required field %q is empty", missing)
+	}
+	return v, nil
+}
+
+func firstEmpty(fields map[string]string) string {
+	for _, k := range []string{"md5", "sha256", "sha256_short", "malware_class", "malware_family", "threat_level"} {
+		if v, ok := fields[k]; ok && v == "" {
+			return k
+		}
+	}
+	return ""
+}
+
+// PadRecord validates rec (ValidateRecord) and re-emits it in schema field
+// order with its padding field recomputed so that the record is exactly
+// size bytes, as GenerateRecords would have produced it for index i.
+// Records that cannot fit size are rejected; records too close to size to
+// hold a padding field are returned unpadded.
+func PadRecord(logN int, rec []byte, size int, i int) ([]byte, error) {
+	v, err := ValidateRecord(logN, rec)
+	if err != nil {
+		return nil, err
+	}
+	setPadding(v, "")
+	base, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(base) > size {
+		return nil, fmt.Errorf("record is %d bytes, window is %d", len(base), size)
+	}
+
+	// padding is omitempty, so base has none; adding it costs its value
+	// plus the `,"padding":""` wrapper.
+	need := size - len(base) - len(`,"padding":""`)
+	if need <= 0 {
+		return base, nil
+	}
+	setPadding(v, utils.FakeHash("pad", i, need))
+	return json.Marshal(v)
+}
+
+func setPadding(v interface{}, p string) {
+	switch r := v.(type) {
+	case *CTIRecordMini:
+		r.Padding = p
+	case *CTIRecordMid:
+		r.Padding = p
+	case *CTIRecordRich:
+		r.Padding = p
+	}
+}
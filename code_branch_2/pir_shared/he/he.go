@@ -0,0 +1,126 @@
+// Package he is the homomorphic-encryption surface of the PIR protocol:
+// BGV params from a utils.BGVParamHint, slot encoding, key generation,
+// encryption, the ct × pt product and (un)marshalling. Callers hold the
+// opaque Params / Plaintext / Ciphertext / key handles an Engine returns
+// and never import Lattigo themselves, so the library version is a build
+// choice: Lattigo v6 by default, v5 with -tags lattigo_v5 for networks
+// whose peers pin the older dependency set. Both engines build the same
+// ring from the same hint, but v5 and v6 serialise the element metadata
+// (the scale) differently, so ct_q, ct_r and m_DB bytes only round-trip
+// between builds of the same engine; a mismatch fails to unmarshal rather
+// than decoding wrong values. The hint's Scheme picks BGV, BFV or CKKS on
+// that ring (utils/scheme.go); the handles of each scheme go through the
+// same Engine methods, and SchemeOf tells them apart.
+package he
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"pir_shared/utils"
+)
+
+// Params are the parameters of one ring under one HE scheme.
+type Params interface {
+	LogN() int
+	N() int
+	MaxSlots() int
+	MaxLevel() int
+	LogQi() []int
+	LogPi() []int
+	PlaintextModulus() uint64
+}
+
+// Plaintext is an encoded slot vector.
+type Plaintext interface {
+	Level() int
+	MarshalBinary() ([]byte, error)
+}
+
+// Ciphertext is an RLWE ciphertext of the params' scheme.
+type Ciphertext interface {
+	Level() int
+	Degree() int
+	MarshalBinary() ([]byte, error)
+}
+
+// SecretKey and PublicKey are an Engine's key pair.
+type (
+	SecretKey interface{ MarshalBinary() ([]byte, error) }
+	PublicKey interface{ MarshalBinary() ([]byte, error) }
+)
+
+// Engine is one HE library version. Handles passed to an Engine must come
+// from the same Engine. Plaintexts and ciphertexts are at MaxLevel unless
+// stated otherwise; Unmarshal* recover from panics on crafted input and
+// check the decoded shape against p.
+type Engine interface {
+	// Name identifies the library, e.g. "lattigo/v6".
+	Name() string
+	// Schemes lists the utils.Scheme* NewParams builds.
+	Schemes() []string
+	NewParams(h utils.BGVParamHint) (Params, error)
+
+	// Encode and Decode map p.MaxSlots() slots; under CKKS the values are
+	// encoded as reals and Decode rounds them back to integers.
+	Encode(p Params, slots []uint64) (Plaintext, error)
+	Decode(p Params, pt Plaintext) ([]uint64, error)
+	UnmarshalPlaintext(p Params, raw []byte) (Plaintext, error)
+	// UnmarshalCiphertext accepts any level up to MaxLevel.
+	UnmarshalCiphertext(p Params, raw []byte, degree int) (Ciphertext, error)
+	// CiphertextBytes is the marshalled size of a degree-1 ciphertext at
+	// MaxLevel, i.e. of a well-formed ct_q.
+	CiphertextBytes(p Params) int
+
+	// MulPlain is the PIR evaluation: ct × pt.
+	MulPlain(p Params, ct Ciphertext, pt Plaintext) (Ciphertext, error)
+	// Mul is ct × ct without relinearization (no evaluation keys): the
+	// result's degree is the sum of the operands'. 2D queries combine
+	// their two selectors with it.
+	Mul(p Params, a, b Ciphertext) (Ciphertext, error)
+	// ModSwitch switches ct down to level (0 <= level <= ct.Level()),
+	// dropping the moduli above it, which shrinks its marshalled size to
+	// (level+1)/(ct.Level()+1). It decrypts to the same slots under the
+	// same key. BGV only; ct may be modified in place.
+	ModSwitch(p Params, ct Ciphertext, level int) (Ciphertext, error)
+
+	GenKeyPair(p Params) (SecretKey, PublicKey, error)
+	Encrypt(p Params, pk PublicKey, pt Plaintext) (Ciphertext, error)
+	Decrypt(p Params, sk SecretKey, ct Ciphertext) (Plaintext, error)
+}
+
+// Default is the engine this binary was built with.
+var Default Engine = defaultEngine
+
+// SchemeOf returns the utils.Scheme* p was built for.
+func SchemeOf(p Params) string {
+	if s, ok := p.(interface{ Scheme() string }); ok {
+		return s.Scheme()
+	}
+	return utils.SchemeBGV
+}
+
+// DecodeQuery parses a Base64 ct_q as PIRQuery receives it: a degree-1
+// ciphertext at p.MaxLevel(). raw is the decoded bytes. A ct_q declaring
+// another shape is rejected with a *utils.QueryShapeError before it is
+// decoded.
+func DecodeQuery(e Engine, p Params, encQueryB64 string) (ct Ciphertext, raw []byte, err error) {
+	if raw, err = base64.StdEncoding.DecodeString(encQueryB64); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode base64 query: %w", err)
+	}
+	if err = utils.CheckQueryShape(raw, utils.QueryShape(p.LogN(), p.MaxLevel())); err != nil {
+		return nil, nil, err
+	}
+	if ct, err = e.UnmarshalCiphertext(p, raw, 1); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal query ciphertext: %w", err)
+	}
+	if ct.Level() != p.MaxLevel() {
+		return nil, nil, fmt.Errorf("query ciphertext at level %d, want %d", ct.Level(), p.MaxLevel())
+	}
+	return ct, raw, nil
+}
+
+// errHandle reports a handle that did not come from engine.
+func errHandle(engine, what string, v interface{}) error {
+	return fmt.Errorf("%s: %s handle of type %T belongs to another engine", engine, what, v)
+}
@@ -0,0 +1,256 @@
+//go:build lattigo_v5
+
+package he
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/schemes/bgv"
+
+	"pir_shared/utils"
+)
+
+// lattigoV5 is the engine of -tags lattigo_v5 builds. v5 and v6 share the
+// core/rlwe + schemes/bgv layout and the wire format, so this mirrors
+// lattigo_v6.go; parsing carries its own copy of the utils/ciphertext.go
+// guards, which are typed on v6.
+type lattigoV5 struct{}
+
+var defaultEngine Engine = lattigoV5{}
+
+func (lattigoV5) Name() string { return "lattigo/v5" }
+
+// Schemes: the v5 engine builds BGV only.
+func (lattigoV5) Schemes() []string { return []string{utils.SchemeBGV} }
+
+func (e lattigoV5) NewParams(h utils.BGVParamHint) (Params, error) {
+	h, err := h.Resolved()
+	if err != nil {
+		return nil, err
+	}
+	if h.Scheme != utils.SchemeBGV {
+		return nil, fmt.Errorf("%s: scheme %s is not built into this engine", e.Name(), h.Scheme)
+	}
+	p, err := bgv.NewParametersFromLiteral(bgv.ParametersLiteral{
+		LogN:             h.LogN,
+		LogQ:             h.LogQi,
+		LogP:             h.LogPi,
+		PlaintextModulus: h.T,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (e lattigoV5) Encode(p Params, slots []uint64) (Plaintext, error) {
+	params, err := e.params(p)
+	if err != nil {
+		return nil, err
+	}
+	pt := bgv.NewPlaintext(params, params.MaxLevel())
+	if err := bgv.NewEncoder(params).Encode(slots, pt); err != nil {
+		return nil, err
+	}
+	return pt, nil
+}
+
+func (e lattigoV5) Decode(p Params, pt Plaintext) ([]uint64, error) {
+	params, err := e.params(p)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := pt.(*rlwe.Plaintext)
+	if !ok {
+		return nil, errHandle(e.Name(), "plaintext", pt)
+	}
+	slots := make([]uint64, params.MaxSlots())
+	if err := bgv.NewEncoder(params).Decode(v, slots); err != nil {
+		return nil, err
+	}
+	return slots, nil
+}
+
+func (e lattigoV5) UnmarshalPlaintext(p Params, raw []byte) (_ Plaintext, err error) {
+	params, err := e.params(p)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed plaintext: %v", r)
+		}
+	}()
+	pt := bgv.NewPlaintext(params, params.MaxLevel())
+	if err := readFrom(pt, raw); err != nil {
+		return nil, err
+	}
+	if pt.Level() != params.MaxLevel() || pt.N() != params.N() {
+		return nil, fmt.Errorf("plaintext has level=%d N=%d, params want level=%d N=%d",
+			pt.Level(), pt.N(), params.MaxLevel(), params.N())
+	}
+	return pt, nil
+}
+
+func (e lattigoV5) UnmarshalCiphertext(p Params, raw []byte, degree int) (_ Ciphertext, err error) {
+	params, err := e.params(p)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed ciphertext: %v", r)
+		}
+	}()
+	ct := rlwe.NewCiphertext(params, degree, params.MaxLevel())
+	if err := readFrom(ct, raw); err != nil {
+		return nil, fmt.Errorf("malformed ciphertext: %w", err)
+	}
+	if len(ct.Value) != degree+1 || ct.Degree() != degree {
+		return nil, fmt.Errorf("ciphertext degree %d, want %d", len(ct.Value)-1, degree)
+	}
+	if ct.Level() > params.MaxLevel() || ct.LogN() != params.LogN() {
+		return nil, fmt.Errorf("ciphertext LogN=%d level=%d does not match params LogN=%d max level=%d",
+			ct.LogN(), ct.Level(), params.LogN(), params.MaxLevel())
+	}
+	return ct, nil
+}
+
+func (e lattigoV5) CiphertextBytes(p Params) int {
+	params, err := e.params(p)
+	if err != nil {
+		return 0
+	}
+	return rlwe.NewCiphertext(params, 1, params.MaxLevel()).BinarySize()
+}
+
+func (e lattigoV5) MulPlain(p Params, ct Ciphertext, pt Plaintext) (Ciphertext, error) {
+	params, err := e.params(p)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := ct.(*rlwe.Ciphertext)
+	if !ok {
+		return nil, errHandle(e.Name(), "ciphertext", ct)
+	}
+	v, ok := pt.(*rlwe.Plaintext)
+	if !ok {
+		return nil, errHandle(e.Name(), "plaintext", pt)
+	}
+	res, err := bgv.NewEvaluator(params, nil).MulNew(c, v)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (e lattigoV5) Mul(p Params, a, b Ciphertext) (Ciphertext, error) {
+	params, err := e.params(p)
+	if err != nil {
+		return nil, err
+	}
+	x, ok := a.(*rlwe.Ciphertext)
+	if !ok {
+		return nil, errHandle(e.Name(), "ciphertext", a)
+	}
+	y, ok := b.(*rlwe.Ciphertext)
+	if !ok {
+		return nil, errHandle(e.Name(), "ciphertext", b)
+	}
+	res, err := bgv.NewEvaluator(params, nil).MulNew(x, y)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (e lattigoV5) ModSwitch(p Params, ct Ciphertext, level int) (Ciphertext, error) {
+	params, err := e.params(p)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := ct.(*rlwe.Ciphertext)
+	if !ok {
+		return nil, errHandle(e.Name(), "ciphertext", ct)
+	}
+	if level < 0 || level > c.Level() {
+		return nil, fmt.Errorf("cannot switch a level-%d ciphertext to level %d", c.Level(), level)
+	}
+	eval := bgv.NewEvaluator(params, nil)
+	for c.Level() > level {
+		if err := eval.Rescale(c, c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (e lattigoV5) GenKeyPair(p Params) (SecretKey, PublicKey, error) {
+	params, err := e.params(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	sk, pk := rlwe.NewKeyGenerator(params).GenKeyPairNew()
+	return sk, pk, nil
+}
+
+func (e lattigoV5) Encrypt(p Params, pk PublicKey, pt Plaintext) (Ciphertext, error) {
+	params, err := e.params(p)
+	if err != nil {
+		return nil, err
+	}
+	k, ok := pk.(*rlwe.PublicKey)
+	if !ok {
+		return nil, errHandle(e.Name(), "public key", pk)
+	}
+	v, ok := pt.(*rlwe.Plaintext)
+	if !ok {
+		return nil, errHandle(e.Name(), "plaintext", pt)
+	}
+	ct, err := rlwe.NewEncryptor(params, k).EncryptNew(v)
+	if err != nil {
+		return nil, err
+	}
+	return ct, nil
+}
+
+func (e lattigoV5) Decrypt(p Params, sk SecretKey, ct Ciphertext) (Plaintext, error) {
+	params, err := e.params(p)
+	if err != nil {
+		return nil, err
+	}
+	k, ok := sk.(*rlwe.SecretKey)
+	if !ok {
+		return nil, errHandle(e.Name(), "secret key", sk)
+	}
+	c, ok := ct.(*rlwe.Ciphertext)
+	if !ok {
+		return nil, errHandle(e.Name(), "ciphertext", ct)
+	}
+	return rlwe.NewDecryptor(params, k).DecryptNew(c), nil
+}
+
+func (e lattigoV5) params(p Params) (bgv.Parameters, error) {
+	params, ok := p.(bgv.Parameters)
+	if !ok {
+		return bgv.Parameters{}, errHandle(e.Name(), "params", p)
+	}
+	return params, nil
+}
+
+// readFrom decodes raw into v through a bufio.Reader; v5 has the same
+// buffer.Buffer recursion as v6 (see utils/ciphertext.go).
+func readFrom(v io.ReaderFrom, raw []byte) error {
+	n, err := v.ReadFrom(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return err
+	}
+	if int(n) != len(raw) {
+		return fmt.Errorf("%d trailing bytes", len(raw)-int(n))
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+//go:build !lattigo_v5
+
+package he
+
+import (
+	"fmt"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+
+	"pir_shared/utils"
+)
+
+// lattigoV6 is the default engine. Parsing goes through the utils helpers
+// (UnmarshalCiphertext, UnmarshalPlaintext) the v6 clients use directly.
+// BGV params are plain bgv.Parameters, as the v6 clients build them; BFV
+// and CKKS params are the wrappers of schemes_v6.go.
+type lattigoV6 struct{}
+
+var defaultEngine Engine = lattigoV6{}
+
+func (lattigoV6) Name() string { return "lattigo/v6" }
+
+func (lattigoV6) Schemes() []string { return utils.Schemes }
+
+func (lattigoV6) NewParams(h utils.BGVParamHint) (Params, error) {
+	h, err := h.Resolved()
+	if err != nil {
+		return nil, err
+	}
+	switch h.Scheme {
+	case utils.SchemeBFV:
+		return newBFVParams(h)
+	case utils.SchemeCKKS:
+		return newCKKSParams(h)
+	}
+	p, err := utils.BuildParamsFromHint(h)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (e lattigoV6) Encode(p Params, slots []uint64) (Plaintext, error) {
+	switch params := p.(type) {
+	case bgv.Parameters:
+		pt := bgv.NewPlaintext(params, params.MaxLevel())
+		if err := bgv.NewEncoder(params).Encode(slots, pt); err != nil {
+			return nil, err
+		}
+		return pt, nil
+	case bfvParams:
+		pt := bgv.NewPlaintext(params.Parameters, params.MaxLevel())
+		if err := bgv.NewEncoder(params.Parameters).Encode(slots, pt); err != nil {
+			return nil, err
+		}
+		return pt, nil
+	case ckksParams:
+		return params.encode(slots)
+	}
+	return nil, errHandle(e.Name(), "params", p)
+}
+
+func (e lattigoV6) Decode(p Params, pt Plaintext) ([]uint64, error) {
+	v, ok := pt.(*rlwe.Plaintext)
+	if !ok {
+		return nil, errHandle(e.Name(), "plaintext", pt)
+	}
+	switch params := p.(type) {
+	case bgv.Parameters:
+		slots := make([]uint64, params.MaxSlots())
+		if err := bgv.NewEncoder(params).Decode(v, slots); err != nil {
+			return nil, err
+		}
+		return slots, nil
+	case bfvParams:
+		slots := make([]uint64, params.MaxSlots())
+		if err := bgv.NewEncoder(params.Parameters).Decode(v, slots); err != nil {
+			return nil, err
+		}
+		return slots, nil
+	case ckksParams:
+		return params.decode(v)
+	}
+	return nil, errHandle(e.Name(), "params", p)
+}
+
+func (e lattigoV6) UnmarshalPlaintext(p Params, raw []byte) (Plaintext, error) {
+	params, err := e.params(p)
+	if err != nil {
+		return nil, err
+	}
+	pt, err := utils.UnmarshalPlaintext(params, raw)
+	if err != nil {
+		return nil, err
+	}
+	return pt, nil
+}
+
+func (e lattigoV6) UnmarshalCiphertext(p Params, raw []byte, degree int) (Ciphertext, error) {
+	params, err := e.params(p)
+	if err != nil {
+		return nil, err
+	}
+	ct, err := utils.UnmarshalCiphertext(params, raw, degree)
+	if err != nil {
+		return nil, err
+	}
+	return ct, nil
+}
+
+func (e lattigoV6) CiphertextBytes(p Params) int {
+	params, err := e.params(p)
+	if err != nil {
+		return 0
+	}
+	return rlwe.NewCiphertext(params, 1, p.MaxLevel()).BinarySize()
+}
+
+func (e lattigoV6) MulPlain(p Params, ct Ciphertext, pt Plaintext) (Ciphertext, error) {
+	c, ok := ct.(*rlwe.Ciphertext)
+	if !ok {
+		return nil, errHandle(e.Name(), "ciphertext", ct)
+	}
+	v, ok := pt.(*rlwe.Plaintext)
+	if !ok {
+		return nil, errHandle(e.Name(), "plaintext", pt)
+	}
+	return e.mul(p, c, v)
+}
+
+func (e lattigoV6) Mul(p Params, a, b Ciphertext) (Ciphertext, error) {
+	x, ok := a.(*rlwe.Ciphertext)
+	if !ok {
+		return nil, errHandle(e.Name(), "ciphertext", a)
+	}
+	y, ok := b.(*rlwe.Ciphertext)
+	if !ok {
+		return nil, errHandle(e.Name(), "ciphertext", b)
+	}
+	return e.mul(p, x, y)
+}
+
+func (e lattigoV6) ModSwitch(p Params, ct Ciphertext, level int) (Ciphertext, error) {
+	c, ok := ct.(*rlwe.Ciphertext)
+	if !ok {
+		return nil, errHandle(e.Name(), "ciphertext", ct)
+	}
+	if level < 0 || level > c.Level() {
+		return nil, fmt.Errorf("cannot switch a level-%d ciphertext to level %d", c.Level(), level)
+	}
+	params, ok := p.(bgv.Parameters)
+	if !ok {
+		// BFV's scale-invariant ciphertexts have no rescale, and a CKKS
+		// rescale would change the scale Decode divides by
+		return nil, fmt.Errorf("modulus switching is supported under %s only, not %s", utils.SchemeBGV, SchemeOf(p))
+	}
+	if err := utils.EvaluatorPoolFor(params).RescaleTo(c, level); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// mul is the scheme's MulNew without evaluation keys: BGV's, on an
+// evaluator from the shared utils.EvaluatorPoolFor, the scale-invariant
+// BGV evaluator for BFV, or CKKS's, which multiplies the scales and
+// leaves rescaling out (Decode divides by the product scale).
+func (e lattigoV6) mul(p Params, a *rlwe.Ciphertext, b interface{}) (Ciphertext, error) {
+	var res *rlwe.Ciphertext
+	var err error
+	switch params := p.(type) {
+	case bgv.Parameters:
+		res, err = utils.EvaluatorPoolFor(params).MulNew(a, b)
+	case bfvParams:
+		res, err = bgv.NewEvaluator(params.Parameters, nil, true).MulNew(a, b)
+	case ckksParams:
+		res, err = ckks.NewEvaluator(params.Parameters, nil).MulNew(a, b)
+	default:
+		return nil, errHandle(e.Name(), "params", p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (e lattigoV6) GenKeyPair(p Params) (SecretKey, PublicKey, error) {
+	params, err := e.params(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	sk, pk := rlwe.NewKeyGenerator(params).GenKeyPairNew()
+	return sk, pk, nil
+}
+
+func (e lattigoV6) Encrypt(p Params, pk PublicKey, pt Plaintext) (Ciphertext, error) {
+	params, err := e.params(p)
+	if err != nil {
+		return nil, err
+	}
+	k, ok := pk.(*rlwe.PublicKey)
+	if !ok {
+		return nil, errHandle(e.Name(), "public key", pk)
+	}
+	v, ok := pt.(*rlwe.Plaintext)
+	if !ok {
+		return nil, errHandle(e.Name(), "plaintext", pt)
+	}
+	ct, err := rlwe.NewEncryptor(params, k).EncryptNew(v)
+	if err != nil {
+		return nil, err
+	}
+	return ct, nil
+}
+
+func (e lattigoV6) Decrypt(p Params, sk SecretKey, ct Ciphertext) (Plaintext, error) {
+	params, err := e.params(p)
+	if err != nil {
+		return nil, err
+	}
+	k, ok := sk.(*rlwe.SecretKey)
+	if !ok {
+		return nil, errHandle(e.Name(), "secret key", sk)
+	}
+	c, ok := ct.(*rlwe.Ciphertext)
+	if !ok {
+		return nil, errHandle(e.Name(), "ciphertext", ct)
+	}
+	return rlwe.NewDecryptor(params, k).DecryptNew(c), nil
+}
+
+// params returns the RLWE parameters behind any scheme's Params, for the
+// scheme-independent steps (keys, encryption, parsing).
+func (e lattigoV6) params(p Params) (rlwe.ParameterProvider, error) {
+	switch params := p.(type) {
+	case bgv.Parameters:
+		return params, nil
+	case bfvParams:
+		return params.Parameters, nil
+	case ckksParams:
+		return params.Parameters, nil
+	}
+	return nil, errHandle(e.Name(), "params", p)
+}
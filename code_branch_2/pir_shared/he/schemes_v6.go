@@ -0,0 +1,122 @@
+//go:build !lattigo_v5
+
+package he
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+
+	"pir_shared/utils"
+)
+
+// BFV and CKKS params of the v6 engine. They wrap the Lattigo types so
+// SchemeOf can tell them from bgv.Parameters and so CKKS, which has no
+// plaintext modulus, still satisfies Params.
+
+// bfvParams are BFV params: BGV's ring and encoding with the
+// scale-invariant multiplication. Lattigo v6 ships BFV as a mode of the
+// BGV evaluator (bgv.NewEvaluator(..., true)), not as a package of its
+// own, so the wrapper holds bgv.Parameters.
+type bfvParams struct{ bgv.Parameters }
+
+func (bfvParams) Scheme() string { return utils.SchemeBFV }
+
+func newBFVParams(h utils.BGVParamHint) (Params, error) {
+	p, err := bgv.NewParametersFromLiteral(bgv.ParametersLiteral{
+		LogN:             h.LogN,
+		LogQ:             h.LogQi,
+		LogP:             h.LogPi,
+		PlaintextModulus: h.T,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bfvParams{p}, nil
+}
+
+// ckksParams are CKKS params on the hint's ring; MaxSlots is N/2.
+type ckksParams struct{ ckks.Parameters }
+
+func (ckksParams) Scheme() string { return utils.SchemeCKKS }
+
+// PlaintextModulus is 0: CKKS works modulo Q at a scale, not modulo T.
+func (ckksParams) PlaintextModulus() uint64 { return 0 }
+
+// CKKS scale bounds. The PIR product holds 8-bit record bytes at twice
+// the scale, which must stay below Q/2 with room for the noise, and the
+// decrypted noise divided by the scale must stay well under the 0.5
+// Decode rounds away: about 2^22 for a fresh ciphertext. The scale is
+// capped so the third factor of a 2D query still fits utils.Min2DLogQ.
+const (
+	ckksMinLogScale = 22
+	ckksMaxLogScale = 30
+	ckksHeadroom    = 10 // bits of Q above scale² for the byte values and noise
+)
+
+// ckksLogScale is the default scale of a CKKS ring with moduli logQi:
+// (log Q - ckksHeadroom)/2, capped at ckksMaxLogScale.
+func ckksLogScale(logQi []int) (int, error) {
+	logQ := 0
+	for _, q := range logQi {
+		logQ += q
+	}
+	s := min((logQ-ckksHeadroom)/2, ckksMaxLogScale)
+	if s < ckksMinLogScale {
+		return 0, fmt.Errorf("ckks: log Q = %d leaves a %d-bit scale, need log Q >= %d",
+			logQ, s, 2*ckksMinLogScale+ckksHeadroom)
+	}
+	return s, nil
+}
+
+func newCKKSParams(h utils.BGVParamHint) (Params, error) {
+	logScale, err := ckksLogScale(h.LogQi)
+	if err != nil {
+		return nil, err
+	}
+	p, err := ckks.NewParametersFromLiteral(ckks.ParametersLiteral{
+		LogN:            h.LogN,
+		LogQ:            h.LogQi,
+		LogP:            h.LogPi,
+		LogDefaultScale: logScale,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ckksParams{p}, nil
+}
+
+// encode encodes slots as reals at the default scale.
+func (p ckksParams) encode(slots []uint64) (Plaintext, error) {
+	if len(slots) > p.MaxSlots() {
+		return nil, fmt.Errorf("ckks: %d values for %d slots", len(slots), p.MaxSlots())
+	}
+	values := make([]float64, p.MaxSlots())
+	for i, v := range slots {
+		values[i] = float64(v)
+	}
+	pt := ckks.NewPlaintext(p.Parameters, p.MaxLevel())
+	if err := ckks.NewEncoder(p.Parameters).Encode(values, pt); err != nil {
+		return nil, err
+	}
+	return pt, nil
+}
+
+// decode decodes pt at its own scale and rounds each slot to the nearest
+// non-negative integer.
+func (p ckksParams) decode(pt *rlwe.Plaintext) ([]uint64, error) {
+	values := make([]float64, p.MaxSlots())
+	if err := ckks.NewEncoder(p.Parameters).Decode(pt, values); err != nil {
+		return nil, err
+	}
+	slots := make([]uint64, len(values))
+	for i, v := range values {
+		if r := math.Round(v); r > 0 {
+			slots[i] = uint64(r)
+		}
+	}
+	return slots, nil
+}
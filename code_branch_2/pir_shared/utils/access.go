@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+/********* ACCESS STATISTICS ***************************************/
+
+// A server sees every PIR query arrive but, by construction, not which
+// record it selects, so the only demand signal encrypted queries give is
+// their number. AccessStats keeps that number per UTC day. PublicQuery
+// reads name their key in the clear anyway, so they are counted per key.
+// Nothing finer is kept: no caller, no time of day, no query bytes.
+
+// UsageDays is how many UTC days of PIR query counts AccessStats keeps.
+const UsageDays = 90
+
+// AccessStats counts the PIR queries and public reads of one dataset.
+type AccessStats struct {
+	mtx     sync.Mutex
+	since   time.Time
+	queries uint64
+	days    map[string]uint64 // "2006-01-02" → PIR queries
+	public  map[string]uint64 // record key → PublicQuery reads
+}
+
+// DayCount is the number of PIR queries answered on one UTC day.
+type DayCount struct {
+	Day     string `json:"day"` // 2006-01-02
+	Queries uint64 `json:"queries"`
+}
+
+// AccessSnapshot is the JSON view of AccessStats.
+type AccessSnapshot struct {
+	Since       time.Time         `json:"since"`   // first counted call; zero if none
+	Queries     uint64            `json:"queries"` // all PIR queries since Since
+	Days        []DayCount        `json:"days"`    // the last UsageDays days with queries, oldest first
+	PublicReads map[string]uint64 `json:"public_reads"`
+}
+
+// UsageStats is the GetUsageStats response: the PIR cost per client
+// identity and the access counters of the transaction's dataset.
+type UsageStats struct {
+	Clients []ClientUsage  `json:"clients"`
+	Access  AccessSnapshot `json:"access"`
+}
+
+// AddQuery counts one PIR query answered at at.
+func (s *AccessStats) AddQuery(at time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.touch(at)
+	if s.days == nil {
+		s.days = map[string]uint64{}
+	}
+	s.queries++
+	s.days[at.UTC().Format(time.DateOnly)]++
+	for len(s.days) > UsageDays {
+		oldest := ""
+		for d := range s.days {
+			if oldest == "" || d < oldest {
+				oldest = d
+			}
+		}
+		delete(s.days, oldest)
+	}
+}
+
+// AddPublicRead counts one PublicQuery of key at at.
+func (s *AccessStats) AddPublicRead(key string, at time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.touch(at)
+	if s.public == nil {
+		s.public = map[string]uint64{}
+	}
+	s.public[key]++
+}
+
+// touch starts the statistics at at if nothing was counted yet. Callers
+// hold s.mtx.
+func (s *AccessStats) touch(at time.Time) {
+	if s.since.IsZero() {
+		s.since = at.UTC()
+	}
+}
+
+// Snapshot returns the counters.
+func (s *AccessStats) Snapshot() AccessSnapshot {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	out := AccessSnapshot{Since: s.since, Queries: s.queries, Days: make([]DayCount, 0, len(s.days)), PublicReads: map[string]uint64{}}
+	for d, n := range s.days {
+		out.Days = append(out.Days, DayCount{Day: d, Queries: n})
+	}
+	sort.Slice(out.Days, func(i, j int) bool { return out.Days[i].Day < out.Days[j].Day })
+	for k, n := range s.public {
+		out.PublicReads[k] = n
+	}
+	return out
+}
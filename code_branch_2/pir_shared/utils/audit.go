@@ -0,0 +1,26 @@
+package utils
+
+import "encoding/json"
+
+// AuditFlow ties a PIRQuerySubmit to an evaluation that already ran
+// elsewhere, e.g. a PIRQuery evaluated at one org whose audit record is
+// then endorsed by several. The client sends it in the transient map under
+// "auditFlow"; the chaincode checks that it is auditing the same ct_q and,
+// ct × m_DB being deterministic, that it computed the same ct_r, and
+// stores it in the audit record.
+type AuditFlow struct {
+	ID           string `json:"id"`            // client-chosen, shared by both steps
+	EvalMSP      string `json:"eval_msp"`      // org whose peer answered the evaluation
+	QuerySHA256  string `json:"query_sha256"`  // of the Base64 ct_q
+	ResultSHA256 string `json:"result_sha256"` // of the Base64 ct_r it returned
+}
+
+// PublicReceipt is PublicQuerySubmit's result: the record read (as JSON,
+// or a JSON string when it is not JSON) and where its audit entry was
+// committed, for GetAuditRecord(TxID).
+type PublicReceipt struct {
+	Record    json.RawMessage `json:"record"`
+	AuditKey  string          `json:"audit_key"` // "audit:public:<tx_id>"
+	TxID      string          `json:"tx_id"`
+	Timestamp string          `json:"timestamp"` // tx timestamp, RFC 3339, UTC
+}
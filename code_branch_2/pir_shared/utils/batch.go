@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+/********* BATCH PIR *************************************************/
+
+// PIRQueryBatch takes the ct_q of several records in one call, so a client
+// retrieving k records pays the transaction and endorsement overhead once
+// instead of k times. Every ct_q is still multiplied with the whole m_DB:
+// a batch saves round trips, not HE work, and the server learns k and
+// nothing about the indices.
+
+// MaxBatchQueries is the default cap on ct_q per PIRQueryBatch call.
+const MaxBatchQueries = 16
+
+// ParseBatchQueries decodes the PIRQueryBatch argument, a JSON array of
+// Base64 ct_q, holding 1..max of them.
+func ParseBatchQueries(encQueriesJSON string, max int) ([]string, error) {
+	var queries []string
+	if err := json.Unmarshal([]byte(encQueriesJSON), &queries); err != nil {
+		return nil, fmt.Errorf("encQueriesJSON must be a JSON array of Base64 ciphertexts: %w", err)
+	}
+	switch {
+	case len(queries) == 0:
+		return nil, fmt.Errorf("empty batch")
+	case max > 0 && len(queries) > max:
+		return nil, fmt.Errorf("batch of %d queries exceeds the limit of %d", len(queries), max)
+	}
+	return queries, nil
+}
+
+// EncodeBatchResults is the PIRQueryBatch response: a JSON array holding,
+// in request order, each ct_q's PIRQuery response (EncodeShardResults).
+func EncodeBatchResults(results []string) string {
+	out, _ := json.Marshal(results)
+	return string(out)
+}
+
+// DecodeBatchResults is the inverse of EncodeBatchResults for a batch of
+// want queries; each entry decodes with DecodeShardResults.
+func DecodeBatchResults(resp string, want int) ([]string, error) {
+	var results []string
+	if err := json.Unmarshal([]byte(strings.TrimSpace(resp)), &results); err != nil {
+		return nil, fmt.Errorf("parse batch results: %w", err)
+	}
+	if len(results) != want {
+		return nil, fmt.Errorf("parse batch results: %d results for %d queries", len(results), want)
+	}
+	return results, nil
+}
@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+	"runtime"
+	"sync"
+)
+
+/********* BLAKE3 ***************************************************/
+
+// BLAKE3 (unkeyed hash mode, 32-byte output) for DigestBLAKE3. The input
+// is split into 1 KiB chunks whose chaining values are independent, so
+// inputs of more than blake3ParallelChunks chunks (the multi-MB m_DB) are
+// hashed on every CPU; the chunk values are then merged in BLAKE3's
+// left-balanced binary tree. No SIMD: the speed-up over SHA-256 comes
+// from the parallel chunks.
+
+const (
+	blake3BlockLen = 64
+	blake3ChunkLen = 1024
+
+	blake3ChunkStart = 1 << 0
+	blake3ChunkEnd   = 1 << 1
+	blake3Parent     = 1 << 2
+	blake3Root       = 1 << 3
+
+	// blake3ParallelChunks is the chunk count from which Blake3Sum fans
+	// out to goroutines.
+	blake3ParallelChunks = 256
+)
+
+var blake3IV = [8]uint32{
+	0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A, 0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19,
+}
+
+var blake3Permutation = [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+
+func blake3G(s *[16]uint32, a, b, c, d int, mx, my uint32) {
+	s[a] = s[a] + s[b] + mx
+	s[d] = bits.RotateLeft32(s[d]^s[a], -16)
+	s[c] = s[c] + s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -12)
+	s[a] = s[a] + s[b] + my
+	s[d] = bits.RotateLeft32(s[d]^s[a], -8)
+	s[c] = s[c] + s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -7)
+}
+
+func blake3Compress(cv *[8]uint32, block *[16]uint32, counter uint64, blockLen, flags uint32) [16]uint32 {
+	s := [16]uint32{
+		cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7],
+		blake3IV[0], blake3IV[1], blake3IV[2], blake3IV[3],
+		uint32(counter), uint32(counter >> 32), blockLen, flags,
+	}
+	m := *block
+	for r := 0; r < 7; r++ {
+		blake3G(&s, 0, 4, 8, 12, m[0], m[1])
+		blake3G(&s, 1, 5, 9, 13, m[2], m[3])
+		blake3G(&s, 2, 6, 10, 14, m[4], m[5])
+		blake3G(&s, 3, 7, 11, 15, m[6], m[7])
+		blake3G(&s, 0, 5, 10, 15, m[8], m[9])
+		blake3G(&s, 1, 6, 11, 12, m[10], m[11])
+		blake3G(&s, 2, 7, 8, 13, m[12], m[13])
+		blake3G(&s, 3, 4, 9, 14, m[14], m[15])
+		if r < 6 {
+			var p [16]uint32
+			for i, j := range blake3Permutation {
+				p[i] = m[j]
+			}
+			m = p
+		}
+	}
+	for i := 0; i < 8; i++ {
+		s[i] ^= s[i+8]
+		s[i+8] ^= cv[i]
+	}
+	return s
+}
+
+// blake3Output is a compression not yet run: as a chaining value, or
+// with the root flag as the digest.
+type blake3Output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o *blake3Output) chainingValue() [8]uint32 {
+	s := blake3Compress(&o.cv, &o.block, o.counter, o.blockLen, o.flags)
+	return [8]uint32(s[:8])
+}
+
+func (o *blake3Output) root() [32]byte {
+	s := blake3Compress(&o.cv, &o.block, 0, o.blockLen, o.flags|blake3Root)
+	var out [32]byte
+	for i := 0; i < 8; i++ {
+		binary.LittleEndian.PutUint32(out[4*i:], s[i])
+	}
+	return out
+}
+
+// blake3Chunk compresses all but the last block of chunk (at most
+// blake3ChunkLen bytes, chunk number counter) and returns the last.
+func blake3Chunk(chunk []byte, counter uint64) blake3Output {
+	cv := blake3IV
+	flags := uint32(blake3ChunkStart)
+	for len(chunk) > blake3BlockLen {
+		block := blake3Words(chunk[:blake3BlockLen])
+		s := blake3Compress(&cv, &block, counter, blake3BlockLen, flags)
+		cv = [8]uint32(s[:8])
+		chunk, flags = chunk[blake3BlockLen:], 0
+	}
+	return blake3Output{cv: cv, block: blake3Words(chunk), counter: counter, blockLen: uint32(len(chunk)), flags: flags | blake3ChunkEnd}
+}
+
+// blake3Words reads up to one block, zero-padded, as little-endian words.
+func blake3Words(b []byte) [16]uint32 {
+	var buf [blake3BlockLen]byte
+	copy(buf[:], b)
+	var w [16]uint32
+	for i := range w {
+		w[i] = binary.LittleEndian.Uint32(buf[4*i:])
+	}
+	return w
+}
+
+func blake3ParentOutput(left, right [8]uint32) blake3Output {
+	o := blake3Output{cv: blake3IV, blockLen: blake3BlockLen, flags: blake3Parent}
+	copy(o.block[:8], left[:])
+	copy(o.block[8:], right[:])
+	return o
+}
+
+// blake3Subtree is the parent output over cvs (at least two chunk values,
+// in order): the left subtree holds the largest power of two of them
+// smaller than len(cvs).
+func blake3Subtree(cvs [][8]uint32) blake3Output {
+	split := 1 << (bits.Len(uint(len(cvs)-1)) - 1)
+	return blake3ParentOutput(blake3SubtreeCV(cvs[:split]), blake3SubtreeCV(cvs[split:]))
+}
+
+func blake3SubtreeCV(cvs [][8]uint32) [8]uint32 {
+	if len(cvs) == 1 {
+		return cvs[0]
+	}
+	o := blake3Subtree(cvs)
+	return o.chainingValue()
+}
+
+// Blake3Sum is the 32-byte BLAKE3 hash of data.
+func Blake3Sum(data []byte) [32]byte {
+	chunks := max(1, (len(data)+blake3ChunkLen-1)/blake3ChunkLen)
+	if chunks == 1 {
+		o := blake3Chunk(data, 0)
+		return o.root()
+	}
+	cvs := make([][8]uint32, chunks)
+	hashRange := func(from, to int) {
+		for i := from; i < to; i++ {
+			o := blake3Chunk(data[i*blake3ChunkLen:min((i+1)*blake3ChunkLen, len(data))], uint64(i))
+			cvs[i] = o.chainingValue()
+		}
+	}
+	if workers := min(runtime.GOMAXPROCS(0), chunks/blake3ParallelChunks); workers > 1 {
+		var wg sync.WaitGroup
+		per := (chunks + workers - 1) / workers
+		for from := 0; from < chunks; from += per {
+			wg.Add(1)
+			go func(from int) {
+				defer wg.Done()
+				hashRange(from, min(from+per, chunks))
+			}(from)
+		}
+		wg.Wait()
+	} else {
+		hashRange(0, chunks)
+	}
+	o := blake3Subtree(cvs)
+	return o.root()
+}
+
+// blake3Hash is hash.Hash over Blake3Sum: writes are buffered and hashed
+// at Sum, which keeps the chunks parallel.
+type blake3Hash struct{ buf []byte }
+
+// NewBlake3 returns a BLAKE3 hash.Hash (32-byte sums).
+func NewBlake3() hash.Hash { return &blake3Hash{} }
+
+func (h *blake3Hash) Write(p []byte) (int, error) {
+	h.buf = append(h.buf, p...)
+	return len(p), nil
+}
+
+func (h *blake3Hash) Sum(b []byte) []byte {
+	sum := Blake3Sum(h.buf)
+	return append(b, sum[:]...)
+}
+
+func (h *blake3Hash) Reset()         { h.buf = h.buf[:0] }
+func (h *blake3Hash) Size() int      { return 32 }
+func (h *blake3Hash) BlockSize() int { return blake3BlockLen }
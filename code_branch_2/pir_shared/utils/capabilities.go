@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ProtocolVersion is bumped whenever request/response shapes change
+// in a way older clients cannot read.
+const ProtocolVersion = 1
+
+// Feature is a bit in the GetCapabilities feature mask.
+type Feature uint64
+
+const (
+	FeatTimed        Feature = 1 << iota // *Timed envelope variants
+	FeatShards                           // m_DB split over several plaintexts
+	FeatBatchQuery                       // several ct_q per call
+	FeatCompression                      // reduced-size ct_r
+	FeatKeywordPIR                       // keyword → index lookup
+	FeatPacking2B                        // two bytes per slot packing
+	FeatFullDownload                     // GetFullDatasetChunk for small datasets
+	FeatDeltaPIR                         // GetDelta / PIRQueryDelta over changed records
+	FeatMetaAndQuery                     // MetaAndQuery: metadata + PIR response in one call
+	FeatWindowTable                      // GetWindowTable: selector windows for client-side caching
+	FeatReserved                         // reserved zero windows for appends (Metadata.ReservedFrom)
+	FeatChangeFeed                       // GetChangesSince: per-record change log
+	FeatQuery2D                          // PIRQuery2D: row × column selector (utils/grid.go)
+	FeatSchemes                          // SetScheme: BFV / CKKS datasets (Capabilities.Schemes)
+)
+
+var featureNames = []struct {
+	f    Feature
+	name string
+}{
+	{FeatTimed, "timed"},
+	{FeatShards, "shards"},
+	{FeatBatchQuery, "batch_query"},
+	{FeatCompression, "compression"},
+	{FeatKeywordPIR, "keyword_pir"},
+	{FeatPacking2B, "packing_2b"},
+	{FeatFullDownload, "full_download"},
+	{FeatDeltaPIR, "delta_pir"},
+	{FeatMetaAndQuery, "meta_and_query"},
+	{FeatWindowTable, "window_table"},
+	{FeatReserved, "reserved_indices"},
+	{FeatChangeFeed, "change_feed"},
+	{FeatQuery2D, "query_2d"},
+	{FeatSchemes, "he_schemes"},
+}
+
+// Capabilities is the GetCapabilities response.
+type Capabilities struct {
+	Version   int      `json:"version"`
+	Features  Feature  `json:"features"` // bitmask
+	Names     []string `json:"names"`    // same bits, human readable
+	Packing   []string `json:"packing_modes"`
+	MaxShards int      `json:"max_shards"`
+	LogN      []int    `json:"logN"` // supported ring sizes
+	// HE is the chaincode's HE library (he.Engine Name, e.g. "lattigo/v6").
+	// ct_q and m_DB uploads must be built with the same library; empty
+	// from servers that predate the field.
+	HE string `json:"he,omitempty"`
+	// Schemes are the HE schemes (utils.Scheme*) a dataset can be built
+	// under with SetScheme; empty from servers that only run BGV.
+	Schemes []string `json:"schemes,omitempty"`
+}
+
+// NewCapabilities fills Names from the feature mask.
+func NewCapabilities(features Feature, packing []string, maxShards int, logN []int) Capabilities {
+	c := Capabilities{
+		Version:   ProtocolVersion,
+		Features:  features,
+		Packing:   packing,
+		MaxShards: maxShards,
+		LogN:      logN,
+	}
+	for _, fn := range featureNames {
+		if features&fn.f != 0 {
+			c.Names = append(c.Names, fn.name)
+		}
+	}
+	return c
+}
+
+// LegacyCapabilities describes servers that predate GetCapabilities:
+// 1-byte packing, a single plaintext and logN 13..15.
+func LegacyCapabilities() Capabilities {
+	return Capabilities{Packing: []string{"1b"}, MaxShards: 1, LogN: []int{13, 14, 15}}
+}
+
+// Has reports whether every bit of f is advertised.
+func (c Capabilities) Has(f Feature) bool { return c.Features&f == f }
+
+// unknownFunctionErrors are the messages of a server without the called
+// function: Fabric's contract API ("Function X not found in contract Y")
+// and the off-chain server's /invoke ("unknown method").
+var unknownFunctionErrors = []string{"not found in contract", "unknown method"}
+
+// IsUnknownFunction reports whether err says the server does not have the
+// function that was called.
+func IsUnknownFunction(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, msg := range unknownFunctionErrors {
+		if strings.Contains(err.Error(), msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCapabilities decodes a GetCapabilities response. A call that failed
+// because the server does not know GetCapabilities is treated as an older
+// server and yields LegacyCapabilities; any other callErr is returned.
+func ParseCapabilities(raw []byte, callErr error) (Capabilities, error) {
+	if IsUnknownFunction(callErr) {
+		return LegacyCapabilities(), nil
+	}
+	if callErr != nil {
+		return Capabilities{}, fmt.Errorf("GetCapabilities: %w", callErr)
+	}
+	var c Capabilities
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return Capabilities{}, fmt.Errorf("parse capabilities: %w", err)
+	}
+	return c, nil
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Errors of a Probe or GetCapabilities call as the clients see them.
+var (
+	errNotInContract = errors.New("chaincode response 500, Function Probe not found in contract PIRChainCode")
+	errUnknownMethod = fmt.Errorf("invoke: %w", errors.New("unknown method"))
+	errUnavailable   = errors.New("rpc error: code = Unavailable desc = connection refused")
+)
+
+func TestParseCapabilities(t *testing.T) {
+	advertised := NewCapabilities(FeatTimed|FeatShards, []string{Packing1B, Packing2B}, 4, []int{13, 14})
+	raw, _ := json.Marshal(advertised)
+
+	cases := []struct {
+		name    string
+		raw     []byte
+		callErr error
+		want    Capabilities
+		wantErr string
+	}{
+		{"advertised", raw, nil, advertised, ""},
+		{"function not found in contract", nil, errNotInContract, LegacyCapabilities(), ""},
+		{"unknown method", nil, errUnknownMethod, LegacyCapabilities(), ""},
+		{"server unreachable", nil, errUnavailable, Capabilities{}, "connection refused"},
+		{"not JSON", []byte("oops"), nil, Capabilities{}, "parse capabilities"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := ParseCapabilities(c.raw, c.callErr)
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("error %v, want it to contain %q", err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseCapabilities: %v", err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("capabilities = %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseProbe(t *testing.T) {
+	caps := NewCapabilities(FeatTimed, []string{Packing1B}, 1, []int{13})
+	capsRaw, _ := json.Marshal(caps)
+	probeRaw, _ := json.Marshal(NewProbe(caps, &Metadata{LogN: 13, LogQi: []int{54}, LogPi: []int{55}, T: 65537}))
+
+	cases := []struct {
+		name       string
+		raw        []byte
+		callErr    error
+		capsErr    error
+		wantLegacy bool
+		wantErr    string
+	}{
+		{"probe answered", probeRaw, nil, nil, false, ""},
+		{"no Probe, GetCapabilities answered", nil, errNotInContract, nil, true, ""},
+		{"no Probe, no GetCapabilities", nil, errUnknownMethod, errUnknownMethod, true, ""},
+		{"server unreachable", nil, errUnavailable, nil, false, "connection refused"},
+		{"no Probe, GetCapabilities failed", nil, errNotInContract, errUnavailable, false, "connection refused"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := ParseProbe(c.raw, c.callErr, capsRaw, c.capsErr)
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("error %v, want it to contain %q", err, c.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseProbe: %v", err)
+			}
+			if p.Legacy != c.wantLegacy {
+				t.Errorf("Legacy = %v, want %v", p.Legacy, c.wantLegacy)
+			}
+		})
+	}
+}
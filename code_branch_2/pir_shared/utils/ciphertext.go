@@ -0,0 +1,149 @@
+//go:build !lattigo_v5
+
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
+)
+
+/********* CIPHERTEXT PARSING ****************************************/
+
+// ct_q reaches PIRQuery straight from the caller and ct_r reaches
+// DecryptResult straight from the peer, and rlwe's UnmarshalBinary can
+// panic on crafted input (e.g. a huge length prefix). Both entry points go
+// through UnmarshalCiphertext, which turns panics into errors and checks
+// the decoded shape against params; PutMDB uploads go through
+// UnmarshalPlaintext. fuzz_test.go fuzzes the unrecovered path.
+//
+// A length prefix larger than the remaining input sends lattigo's own
+// buffer.Buffer reader into unbounded recursion (ReadUint64Slice keeps
+// peeking the last < 8 bytes), a fatal stack overflow that recover cannot
+// catch. readFrom therefore parses through a bufio.Reader, whose Peek
+// reports io.EOF instead, and insists on consuming all of raw.
+
+// UnmarshalCiphertext decodes raw as a degree-`degree` ciphertext under
+// params at a level no higher than params.MaxLevel(). params may be of
+// any RLWE scheme (bgv, bfv, ckks).
+func UnmarshalCiphertext(params rlwe.ParameterProvider, raw []byte, degree int) (ct *rlwe.Ciphertext, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ct, err = nil, fmt.Errorf("malformed ciphertext: %v", r)
+		}
+	}()
+	return unmarshalCiphertext(params, raw, degree)
+}
+
+func unmarshalCiphertext(pp rlwe.ParameterProvider, raw []byte, degree int) (*rlwe.Ciphertext, error) {
+	params := pp.GetRLWEParameters()
+	ct := rlwe.NewCiphertext(params, degree, params.MaxLevel())
+	if err := readFrom(ct, raw); err != nil {
+		return nil, fmt.Errorf("malformed ciphertext: %w", err)
+	}
+	if err := checkShape(params, ct, degree); err != nil {
+		return nil, err
+	}
+	return ct, nil
+}
+
+// checkShape checks a decoded ciphertext against params and degree.
+func checkShape(params *rlwe.Parameters, ct *rlwe.Ciphertext, degree int) error {
+	if len(ct.Value) != degree+1 || ct.Degree() != degree {
+		return fmt.Errorf("ciphertext degree %d, want %d", len(ct.Value)-1, degree)
+	}
+	if ct.Level() > params.MaxLevel() || ct.LogN() != params.LogN() {
+		return fmt.Errorf("ciphertext LogN=%d level=%d does not match params LogN=%d max level=%d",
+			ct.LogN(), ct.Level(), params.LogN(), params.MaxLevel())
+	}
+	return nil
+}
+
+// DecodeQuery parses a Base64 ct_q as PIRQuery receives it: a degree-1
+// ciphertext at params.MaxLevel(). raw is the decoded bytes. A ct_q
+// declaring another shape is rejected with a *QueryShapeError before it
+// is decoded.
+func DecodeQuery(params bgv.Parameters, encQueryB64 string) (ct *rlwe.Ciphertext, raw []byte, err error) {
+	if raw, err = base64.StdEncoding.DecodeString(encQueryB64); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode base64 query: %w", err)
+	}
+	if err = CheckQueryShape(raw, QueryShape(params.LogN(), params.MaxLevel())); err != nil {
+		return nil, nil, err
+	}
+	if ct, err = UnmarshalCiphertext(params, raw, 1); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal query ciphertext: %w", err)
+	}
+	if ct.Level() != params.MaxLevel() {
+		return nil, nil, fmt.Errorf("query ciphertext at level %d, want %d", ct.Level(), params.MaxLevel())
+	}
+	return ct, raw, nil
+}
+
+// ReadQuery reads a raw ct_q off r, as the off-chain server's
+// /pir/stream receives it: DecodeQuery without the Base64 and without
+// buffering the bytes first. r must hold exactly one degree-1 ciphertext
+// at params.MaxLevel(); nothing past that ciphertext's size is read
+// before the trailing-bytes check. n is the bytes consumed.
+func ReadQuery(params bgv.Parameters, r io.Reader) (ct *rlwe.Ciphertext, n int64, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			ct, err = nil, fmt.Errorf("malformed ciphertext: %v", rec)
+		}
+	}()
+	ct = rlwe.NewCiphertext(params, 1, params.MaxLevel())
+	want := int64(ct.BinarySize())
+	if n, err = ct.ReadFrom(bufio.NewReader(io.LimitReader(r, want))); err != nil {
+		return nil, n, fmt.Errorf("malformed ciphertext: %w", err)
+	}
+	if n != want {
+		return nil, n, fmt.Errorf("query ciphertext of %d bytes, want %d", n, want)
+	}
+	if m, _ := io.ReadFull(r, make([]byte, 1)); m > 0 {
+		return nil, n, fmt.Errorf("trailing bytes after the %d-byte query ciphertext", want)
+	}
+	if err := checkShape(params.GetRLWEParameters(), ct, 1); err != nil {
+		return nil, n, err
+	}
+	if ct.Level() != params.MaxLevel() {
+		return nil, n, fmt.Errorf("query ciphertext at level %d, want %d", ct.Level(), params.MaxLevel())
+	}
+	return ct, n, nil
+}
+
+// UnmarshalPlaintext decodes raw (a PutMDB upload) as a max-level
+// plaintext of params, with the same panic recovery. The scheme's
+// encoding metadata (scale, batching) comes from raw.
+func UnmarshalPlaintext(pp rlwe.ParameterProvider, raw []byte) (pt *rlwe.Plaintext, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			pt, err = nil, fmt.Errorf("malformed plaintext: %v", r)
+		}
+	}()
+	params := pp.GetRLWEParameters()
+	pt = rlwe.NewPlaintext(params, params.MaxLevel())
+	if err := readFrom(pt, raw); err != nil {
+		return nil, err
+	}
+	if pt.Level() != params.MaxLevel() || pt.N() != params.N() {
+		return nil, fmt.Errorf("plaintext has level=%d N=%d, params want level=%d N=%d",
+			pt.Level(), pt.N(), params.MaxLevel(), params.N())
+	}
+	return pt, nil
+}
+
+// readFrom decodes raw into v (see the note on buffer.Buffer above).
+func readFrom(v io.ReaderFrom, raw []byte) error {
+	n, err := v.ReadFrom(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return err
+	}
+	if int(n) != len(raw) {
+		return fmt.Errorf("%d trailing bytes", len(raw)-int(n))
+	}
+	return nil
+}
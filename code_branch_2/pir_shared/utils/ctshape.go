@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+/********* QUERY SHAPE CHECK ***************************************/
+
+// A ct_q built for another dataset (another LogN, or a modulus chain of
+// another length) used to fail somewhere between the size guard and
+// MulNew, with a message that did not say which parameter was off, or
+// inside lattigo. The shape a ciphertext declares (degree, level, ring
+// dimension) can be read from its wire format without decoding it:
+//
+//	u8 has-metadata, [metadata JSON], u64 #polys,
+//	per poly: u64 #levels, per level: u64 N, N × u64
+//
+// v5 and v6 share the format. ParseCiphertextShape walks those headers
+// against the input length (nothing is allocated from a declared size),
+// and CheckQueryShape turns a mismatch with the dataset's params into a
+// QueryShapeError, whose message carries it as JSON for clients
+// (ParseQueryShapeError).
+
+// CiphertextShape is the degree, level and ring dimension of a
+// ciphertext.
+type CiphertextShape struct {
+	Degree int `json:"degree"`
+	Level  int `json:"level"`
+	LogN   int `json:"logN"`
+}
+
+// QueryShape is the shape of a well-formed ct_q under params of logN with
+// maxLevel as their max level.
+func QueryShape(logN, maxLevel int) CiphertextShape {
+	return CiphertextShape{Degree: 1, Level: maxLevel, LogN: logN}
+}
+
+// ParseCiphertextShape reads the shape raw declares. Every polynomial must
+// declare the same levels and every level the same power-of-two N, and
+// the declared sizes must account for raw exactly.
+func ParseCiphertextShape(raw []byte) (CiphertextShape, error) {
+	var shape CiphertextShape
+	if len(raw) == 0 {
+		return shape, fmt.Errorf("empty ciphertext")
+	}
+	off := 1
+	switch raw[0] {
+	case 0:
+	case 1:
+		dec := json.NewDecoder(bytes.NewReader(raw[1:]))
+		var meta json.RawMessage
+		if err := dec.Decode(&meta); err != nil {
+			return shape, fmt.Errorf("metadata: %w", err)
+		}
+		off += int(dec.InputOffset())
+	default:
+		return shape, fmt.Errorf("metadata flag %d, want 0 or 1", raw[0])
+	}
+
+	next := func(what string) (int, error) {
+		if len(raw)-off < 8 {
+			return 0, fmt.Errorf("truncated at %s (offset %d)", what, off)
+		}
+		v := binary.LittleEndian.Uint64(raw[off:])
+		off += 8
+		if v > uint64(len(raw)) {
+			return 0, fmt.Errorf("%s %d exceeds the %d input bytes", what, v, len(raw))
+		}
+		return int(v), nil
+	}
+
+	polys, err := next("polynomial count")
+	if err != nil {
+		return shape, err
+	}
+	if polys < 1 {
+		return shape, fmt.Errorf("no polynomials")
+	}
+	levels, n := -1, -1
+	for i := 0; i < polys; i++ {
+		l, err := next("level count")
+		if err != nil {
+			return shape, err
+		}
+		if l < 1 || (levels >= 0 && l != levels) {
+			return shape, fmt.Errorf("polynomial %d has %d levels, want %d", i, l, max(levels, 1))
+		}
+		levels = l
+		for j := 0; j < l; j++ {
+			c, err := next("coefficient count")
+			if err != nil {
+				return shape, err
+			}
+			if c < 1 || bits.OnesCount(uint(c)) != 1 || (n >= 0 && c != n) {
+				return shape, fmt.Errorf("polynomial %d level %d has %d coefficients", i, j, c)
+			}
+			n = c
+			if len(raw)-off < 8*c {
+				return shape, fmt.Errorf("truncated in polynomial %d level %d", i, j)
+			}
+			off += 8 * c
+		}
+	}
+	if off != len(raw) {
+		return shape, fmt.Errorf("%d trailing bytes", len(raw)-off)
+	}
+	return CiphertextShape{Degree: polys - 1, Level: levels - 1, LogN: bits.Len(uint(n)) - 1}, nil
+}
+
+// QueryShapeError is a ct_q rejected before decoding. Field is
+// "malformed" when no shape could be read, else the first of "logN",
+// "degree", "level" that differs.
+type QueryShapeError struct {
+	Field    string           `json:"field"`
+	Declared *CiphertextShape `json:"declared,omitempty"` // nil when malformed
+	Expected CiphertextShape  `json:"expected"`
+	Detail   string           `json:"detail,omitempty"`
+}
+
+// queryShapePrefix starts every QueryShapeError message.
+const queryShapePrefix = "query ciphertext rejected: "
+
+func (e *QueryShapeError) Error() string {
+	raw, _ := json.Marshal(e)
+	return queryShapePrefix + string(raw)
+}
+
+// CheckQueryShape checks the shape raw declares against want and returns
+// a *QueryShapeError on any difference.
+func CheckQueryShape(raw []byte, want CiphertextShape) error {
+	got, err := ParseCiphertextShape(raw)
+	if err != nil {
+		return &QueryShapeError{Field: "malformed", Expected: want, Detail: err.Error()}
+	}
+	for _, c := range []struct {
+		field     string
+		got, want int
+	}{
+		{"logN", got.LogN, want.LogN}, {"degree", got.Degree, want.Degree}, {"level", got.Level, want.Level},
+	} {
+		if c.got != c.want {
+			return &QueryShapeError{Field: c.field, Declared: &got, Expected: want,
+				Detail: fmt.Sprintf("%s %d, dataset wants %d", c.field, c.got, c.want)}
+		}
+	}
+	return nil
+}
+
+// ParseQueryShapeError finds a QueryShapeError in an error message, e.g.
+// the peer's message a gateway error carries.
+func ParseQueryShapeError(msg string) (*QueryShapeError, bool) {
+	i := strings.Index(msg, queryShapePrefix)
+	if i < 0 {
+		return nil, false
+	}
+	var e QueryShapeError
+	if err := json.NewDecoder(strings.NewReader(msg[i+len(queryShapePrefix):])).Decode(&e); err != nil {
+		return nil, false
+	}
+	return &e, true
+}
+
+// QueryValidation is the ValidateQueryCiphertext response.
+type QueryValidation struct {
+	Valid    bool             `json:"valid"`
+	Declared *CiphertextShape `json:"declared,omitempty"` // nil when malformed
+	Expected CiphertextShape  `json:"expected"`
+	Bytes    int              `json:"bytes"`
+	Error    *QueryShapeError `json:"error,omitempty"`
+}
+
+// ValidateQuery is CheckQueryShape as a report.
+func ValidateQuery(raw []byte, want CiphertextShape) QueryValidation {
+	v := QueryValidation{Expected: want, Bytes: len(raw)}
+	if err := CheckQueryShape(raw, want); err != nil {
+		v.Error = err.(*QueryShapeError)
+		v.Declared = v.Error.Declared
+		return v
+	}
+	v.Declared, v.Valid = &want, true
+	return v
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+)
+
+/********* DIGEST ALGORITHMS ***************************************/
+
+// The m_DB digest (m_DB_sha256, the audit records' mdb_sha256) is no
+// longer always SHA-256: hashing a multi-MB m_DB is a serial SHA-256 pass
+// on every rewrite, where BLAKE3 hashes its chunks on every CPU. The
+// algorithm is stored next to each digest by its ID; a digest stored
+// without one (every ledger before the choice existed) is SHA-256.
+// Record commitments (RecordHash, MerkleRoot) and upload digests are
+// computed by clients and stay SHA-256.
+
+const (
+	DigestSHA256 = "sha256"
+	DigestBLAKE3 = "blake3"
+)
+
+// ParseDigestAlg checks a digest algorithm ID; "" is DigestSHA256.
+func ParseDigestAlg(alg string) (string, error) {
+	switch alg {
+	case "", DigestSHA256:
+		return DigestSHA256, nil
+	case DigestBLAKE3:
+		return DigestBLAKE3, nil
+	default:
+		return "", fmt.Errorf("digest algorithm must be %q or %q, got %q", DigestSHA256, DigestBLAKE3, alg)
+	}
+}
+
+// NewDigest returns a hash of alg (an ID ParseDigestAlg accepts; unknown
+// IDs are SHA-256).
+func NewDigest(alg string) hash.Hash {
+	if alg == DigestBLAKE3 {
+		return NewBlake3()
+	}
+	return sha256.New()
+}
+
+// DigestHex is the hex digest under alg of parts, concatenated in order.
+func DigestHex(alg string, parts ...[]byte) string {
+	h := NewDigest(alg)
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+)
+
+// DPParams records how PublicStats were noised.
+type DPParams struct {
+	Mechanism   string  `json:"mechanism"` // "laplace"
+	Epsilon     float64 `json:"epsilon"`   // total budget of the response
+	Sensitivity float64 `json:"sensitivity"`
+	Scale       float64 `json:"scale"` // Laplace b = Sensitivity / Epsilon
+	Seed        string  `json:"seed"`  // what the noise was derived from (never the seed itself)
+}
+
+// PublicStats is the GetPublicStats response. Counts are rounded and
+// clamped at zero after noising, which is post-processing and costs no
+// budget. NRecords is exact: the record count is public (GetMetadata).
+type PublicStats struct {
+	NRecords      int            `json:"n"`
+	ThreatLevel   map[string]int `json:"threat_level"`
+	MalwareFamily map[string]int `json:"malware_family"`
+	DP            DPParams       `json:"dp"`
+}
+
+// LaplaceNoise is a Laplace(0, scale) sample derived from sha256(seed ||
+// key), so every endorser computes the same value for the same inputs.
+func LaplaceNoise(seed []byte, key string, scale float64) float64 {
+	sum := sha256.Sum256(append(append([]byte{}, seed...), key...))
+	// u uniform in (-0.5, 0.5), never exactly ±0.5.
+	u := (float64(binary.BigEndian.Uint64(sum[:8])>>11)+0.5)/(1<<53) - 0.5
+	if u < 0 {
+		return scale * math.Log(1+2*u)
+	}
+	return -scale * math.Log(1-2*u)
+}
+
+// OtherBucket collects values outside a histogram's public domain.
+const OtherBucket = "other"
+
+// NoisyHistogram counts values over the public domain (anything else goes
+// to OtherBucket), adds LaplaceNoise(seed, prefix+bucket, scale) to every
+// bucket, and rounds and clamps at zero. Every domain bucket is reported,
+// so which buckets appear reveals nothing about the data.
+func NoisyHistogram(values, domain []string, prefix string, seed []byte, scale float64) map[string]int {
+	counts := make(map[string]int, len(domain)+1)
+	for _, k := range domain {
+		counts[k] = 0
+	}
+	counts[OtherBucket] = 0
+	for _, v := range values {
+		if _, ok := counts[v]; ok && v != OtherBucket {
+			counts[v]++
+		} else {
+			counts[OtherBucket]++
+		}
+	}
+
+	out := make(map[string]int, len(counts))
+	for k, c := range counts {
+		out[k] = int(math.Max(0, math.Round(float64(c)+LaplaceNoise(seed, prefix+k, scale))))
+	}
+	return out
+}
@@ -0,0 +1,91 @@
+//go:build !lattigo_v5
+
+package utils
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
+)
+
+/********* EVALUATOR POOL ********************************************/
+
+// bgv.NewEvaluator allocates the evaluator's scratch polynomials (a few
+// ring elements per modulus of the chain), which at LogN=15 is as much
+// heap as the ct × pt product itself, and every PIR query used to build
+// one and drop it. An EvaluatorPool keeps evaluators of one parameter set
+// for reuse; an evaluator is not safe for concurrent use, so each one is
+// taken out for the duration of a call. The servers share one pool per
+// parameter set through EvaluatorPoolFor.
+
+// EvaluatorPool recycles BGV evaluators (no evaluation keys) of params.
+type EvaluatorPool struct {
+	params bgv.Parameters
+	pool   sync.Pool
+}
+
+// NewEvaluatorPool returns an empty pool; evaluators are built on demand.
+func NewEvaluatorPool(params bgv.Parameters) *EvaluatorPool {
+	p := &EvaluatorPool{params: params}
+	p.pool.New = func() any { return bgv.NewEvaluator(params, nil) }
+	return p
+}
+
+// Get takes an evaluator out of the pool; hand it back with Put.
+func (p *EvaluatorPool) Get() *bgv.Evaluator { return p.pool.Get().(*bgv.Evaluator) }
+
+// Put returns eval to the pool.
+func (p *EvaluatorPool) Put(eval *bgv.Evaluator) { p.pool.Put(eval) }
+
+// MulNew is bgv.Evaluator.MulNew on a pooled evaluator.
+func (p *EvaluatorPool) MulNew(ct *rlwe.Ciphertext, op interface{}) (*rlwe.Ciphertext, error) {
+	eval := p.Get()
+	defer p.Put(eval)
+	return eval.MulNew(ct, op)
+}
+
+// RescaleTo modulus-switches ct in place down to level, one modulus at a
+// time, on a pooled evaluator.
+func (p *EvaluatorPool) RescaleTo(ct *rlwe.Ciphertext, level int) error {
+	eval := p.Get()
+	defer p.Put(eval)
+	for ct.Level() > level {
+		if err := eval.Rescale(ct, ct); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxEvaluatorPools bounds the registry of EvaluatorPoolFor; a server sees
+// a new parameter set only on InitLedger, so the oldest one is dropped.
+const maxEvaluatorPools = 8
+
+var evalPools struct {
+	mtx   sync.Mutex
+	keys  []string
+	pools []*EvaluatorPool
+}
+
+// EvaluatorPoolFor returns the shared pool of params, creating it on first
+// use.
+func EvaluatorPoolFor(params bgv.Parameters) *EvaluatorPool {
+	key := fmt.Sprintf("%d/%v/%v/%d", params.LogN(), params.Q(), params.P(), params.PlaintextModulus())
+
+	evalPools.mtx.Lock()
+	defer evalPools.mtx.Unlock()
+	for i, k := range evalPools.keys {
+		if k == key {
+			return evalPools.pools[i]
+		}
+	}
+	p := NewEvaluatorPool(params)
+	if len(evalPools.keys) == maxEvaluatorPools {
+		evalPools.keys, evalPools.pools = evalPools.keys[1:], evalPools.pools[1:]
+	}
+	evalPools.keys = append(evalPools.keys, key)
+	evalPools.pools = append(evalPools.pools, p)
+	return p
+}
@@ -0,0 +1,109 @@
+package utils
+
+// DefaultFullDownloadBytes is the n*record_s size up to which shipping the
+// whole padded dataset is cheaper than a PIR round trip (ct_q alone is
+// several hundred KB at logN=13).
+const DefaultFullDownloadBytes = 64 * 1024
+
+// DatasetChunk is the GetFullDatasetChunk response: the RecordS-byte
+// windows of records Offset, Offset+1, … exactly as packed into m_DB
+// (zero padded), out of NRecords, as of m_DB_version Version.
+type DatasetChunk struct {
+	Version  int      `json:"version"`
+	Offset   int      `json:"offset"`
+	NRecords int      `json:"n"`
+	RecordS  int      `json:"record_s"`
+	Records  [][]byte `json:"records"` // base64 in JSON
+}
+
+// VersionDelta is stored per m_DB_version: the record indices that version
+// rewrote (including appended ones), or Full for a complete rewrite
+// (InitCommit, GenerateDataset, PutMDB).
+type VersionDelta struct {
+	N       int   `json:"n"`
+	RecordS int   `json:"record_s"`
+	Full    bool  `json:"full,omitempty"`
+	Changed []int `json:"changed,omitempty"`
+}
+
+// DeltaInfo is the GetDelta response: the indices below N that changed
+// between m_DB_version Since and Version, in ascending order. Position k of
+// Changed is window k of the PIRQueryDelta plaintext. Full means the
+// client cannot patch its copy and must download the dataset again.
+// LogN is the ring of the delta plaintext (DeltaParams).
+type DeltaInfo struct {
+	Since   int    `json:"since"`
+	Version int    `json:"version"`
+	N       int    `json:"n"`
+	RecordS int    `json:"record_s"`
+	Packing string `json:"packing,omitempty"` // Metadata.Packing of the dataset
+	LogN    int    `json:"logN,omitempty"`
+	Full    bool   `json:"full,omitempty"`
+	Changed []int  `json:"changed"`
+}
+
+// PlanDeltaLogN is the ring for a delta of n records: the cheapest single
+// plaintext that fits, never larger than the dataset's own mainLogN.
+func PlanDeltaLogN(n, recordS, mainLogN int) int {
+	plan, err := PlanLogN(n, recordS, PlanOptions{MaxShards: 1})
+	if err != nil || plan.LogN > mainLogN {
+		return mainLogN
+	}
+	return plan.LogN
+}
+
+// DeltaHint is the hint of the params PIRQueryDelta evaluates under: the
+// dataset's own (main) when the delta needs the same ring, otherwise the
+// defaults for info.LogN.
+func DeltaHint(info DeltaInfo, main Metadata) BGVParamHint {
+	if info.LogN == 0 || info.LogN == main.LogN {
+		return HintFromMetadata(main)
+	}
+	return BGVParamHint{LogN: info.LogN}
+}
+
+// Change-feed operations (RecordChange.Op).
+const (
+	ChangeAdd    = "add"    // record appended (or a reserved window filled)
+	ChangeUpdate = "update" // record rewritten in place
+	ChangeDelete = "delete" // record removed; its window is empty or reserved
+	ChangeReset  = "reset"  // whole dataset rewritten (Index -1): resync
+)
+
+// RecordChange is one change-feed entry: transaction TxID, with tx
+// timestamp Timestamp (Unix seconds), applied Op to record Index and
+// produced m_DB_version Version.
+type RecordChange struct {
+	Version   int    `json:"version"`
+	Index     int    `json:"index"`
+	Op        string `json:"op"`
+	TxID      string `json:"txid"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// ChangeFeed is the GetChangesSince response: the changes that produced
+// m_DB_versions Since+1..Until, oldest first. Until is the since of the
+// next call; More means later versions are waiting.
+type ChangeFeed struct {
+	Since   int            `json:"since"`
+	Until   int            `json:"until"`
+	More    bool           `json:"more,omitempty"`
+	Changes []RecordChange `json:"changes"`
+}
+
+// ChangedIndices returns the indices changes touch, in order and without
+// repeats, or nil when one of them is a ChangeReset.
+func ChangedIndices(changes []RecordChange) []int {
+	out := []int{}
+	seen := map[int]bool{}
+	for _, c := range changes {
+		if c.Op == ChangeReset {
+			return nil
+		}
+		if !seen[c.Index] {
+			seen[c.Index] = true
+			out = append(out, c.Index)
+		}
+	}
+	return out
+}
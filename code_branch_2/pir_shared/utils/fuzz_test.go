@@ -0,0 +1,58 @@
+//go:build !lattigo_v5
+
+package utils
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
+)
+
+// Fuzz targets for the ct_q parser behind PIRQuery (see the Makefile's
+// fuzz target; go test runs their seeds). They call the parser without
+// UnmarshalCiphertext's recover, so any panic fails the target.
+
+// fuzzQuery returns the params of the fuzz targets and a well-formed
+// ct_q under them (the zero ciphertext at max level).
+func fuzzQuery(f *testing.F) (bgv.Parameters, []byte) {
+	p, err := BuildParamsFromHint(BGVParamHint{LogN: MinLogN})
+	if err != nil {
+		f.Fatal(err)
+	}
+	raw, err := rlwe.NewCiphertext(p, 1, p.MaxLevel()).MarshalBinary()
+	if err != nil {
+		f.Fatal(err)
+	}
+	return p, raw
+}
+
+// FuzzUnmarshalQuery fuzzes the binary ct_q parser.
+func FuzzUnmarshalQuery(f *testing.F) {
+	p, raw := fuzzQuery(f)
+	f.Add(raw)
+	f.Add(raw[:len(raw)/2])
+	f.Add(raw[:7])
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = unmarshalCiphertext(p, data, 1)
+	})
+}
+
+// FuzzDecodeQuery fuzzes the Base64 + binary path with data as the
+// PIRQuery argument.
+func FuzzDecodeQuery(f *testing.F) {
+	p, raw := fuzzQuery(f)
+	f.Add(base64.StdEncoding.EncodeToString(raw))
+	f.Add(base64.StdEncoding.EncodeToString(raw[:len(raw)/2]))
+	f.Add("")
+	f.Add("not base64!")
+	f.Fuzz(func(t *testing.T, encQueryB64 string) {
+		raw, err := base64.StdEncoding.DecodeString(encQueryB64)
+		if err != nil {
+			return
+		}
+		_, _ = unmarshalCiphertext(p, raw, 1)
+	})
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+)
+
+/********* SQUARE-ROOT (2D) QUERIES ********************************/
+
+// A 2D query arranges the record windows of a shard as a grid of Cols
+// columns, window j at row j/Cols and column j%Cols, and selects a record
+// with two ciphertexts: the row selector covers every window of its row,
+// the column selector every window of its column. The server multiplies
+// them slot-wise (ct × ct, no relinearization), which leaves ones on the
+// target's window only, then multiplies that with every shard like a 1D
+// selector. Each selector is one of ~√n patterns instead of one of n, so
+// a client caches Rows + Cols selectors to reach any record. Both are
+// still ring-sized ciphertexts, so the query on the wire is two
+// ciphertexts, and the result is degree 2 (three polynomials, not two).
+
+// Min2DLogQ is the smallest total modulus (sum of LogQi) a 2D query
+// decrypts under: the ct × ct product roughly squares the fresh noise
+// before the plaintext multiplication adds the 1D query's growth on top.
+// The 54-bit DefaultLogQi is enough for 1D queries only.
+const Min2DLogQ = 100
+
+// Check2D rejects params too small for a 2D query (Min2DLogQ).
+func Check2D(logQi []int) error {
+	total := 0
+	for _, q := range logQi {
+		total += q
+	}
+	if total < Min2DLogQ {
+		return fmt.Errorf("2D query needs log Q >= %d bits, params have %d (logQi=%v)", Min2DLogQ, total, logQi)
+	}
+	return nil
+}
+
+// GridCell is a record's place in the 2D grid of its shard.
+type GridCell struct {
+	Index int `json:"index"`
+	Shard int `json:"shard"`
+	Row   int `json:"row"`
+	Col   int `json:"col"`
+}
+
+// windowsPerShard is the number of record windows in one shard.
+func (c IndexContract) windowsPerShard() int {
+	if perShard := c.Slots / c.Stride(); c.Slots > 0 && perShard > 0 {
+		return min(perShard, c.NRecords)
+	}
+	return c.NRecords
+}
+
+// Grid returns the rows and columns of the 2D layout of one shard:
+// ceil(√w) columns for w windows per shard.
+func (c IndexContract) Grid() (rows, cols int) {
+	w := max(c.windowsPerShard(), 1)
+	cols = int(math.Ceil(math.Sqrt(float64(w))))
+	return (w + cols - 1) / cols, cols
+}
+
+// Describe2D returns the grid cell of record index i.
+func (c IndexContract) Describe2D(i int) (GridCell, error) {
+	w, err := c.Describe(i)
+	if err != nil {
+		return GridCell{}, err
+	}
+	_, cols := c.Grid()
+	j := w.StartSlot / c.Stride()
+	return GridCell{Index: i, Shard: w.Shard, Row: j / cols, Col: j % cols}, nil
+}
+
+// RowSelector is the slot vector of the 2D row selector: ones over every
+// window of row.
+func (c IndexContract) RowSelector(row int) ([]uint64, error) {
+	rows, cols := c.Grid()
+	if row < 0 || row >= rows {
+		return nil, fmt.Errorf("row %d out of range 0..%d", row, rows-1)
+	}
+	return c.gridSelector(func(j int) bool { return j/cols == row })
+}
+
+// ColSelector is the slot vector of the 2D column selector: ones over
+// every window of col.
+func (c IndexContract) ColSelector(col int) ([]uint64, error) {
+	_, cols := c.Grid()
+	if col < 0 || col >= cols {
+		return nil, fmt.Errorf("column %d out of range 0..%d", col, cols-1)
+	}
+	return c.gridSelector(func(j int) bool { return j%cols == col })
+}
+
+// gridSelector sets the windows j of one shard for which in(j) holds.
+func (c IndexContract) gridSelector(in func(j int) bool) ([]uint64, error) {
+	if c.Slots <= 0 {
+		return nil, fmt.Errorf("index contract: Slots must be set for a 2D selector")
+	}
+	if err := c.ValidateSharded(); err != nil {
+		return nil, err
+	}
+	stride := c.Stride()
+	vec := make([]uint64, c.Slots)
+	for j := 0; j < c.windowsPerShard(); j++ {
+		if !in(j) {
+			continue
+		}
+		for s := j * stride; s < (j+1)*stride; s++ {
+			vec[s] = 1
+		}
+	}
+	return vec, nil
+}
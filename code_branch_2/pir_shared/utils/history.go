@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// HistoryEntry is one modification of a key in a GetHistoryForKey /
+// GetHistoryPage response. Value is the written value (as JSON if it
+// parses, else as a string) unless it is longer than the call's value
+// limit; then it is left out and Truncated is set, and ValueSHA256 still
+// identifies it.
+type HistoryEntry struct {
+	TxID        string          `json:"tx_id"`
+	IsDelete    bool            `json:"is_delete"`
+	Timestamp   string          `json:"timestamp"` // RFC 3339, UTC
+	ValueLength int             `json:"value_length"`
+	ValueSHA256 string          `json:"value_sha256,omitempty"`
+	Value       json.RawMessage `json:"value,omitempty"`
+	Truncated   bool            `json:"truncated,omitempty"`
+}
+
+// HistoryPage is one page of a key's history, newest first. While More is
+// set, passing Next as the resume token returns the following page.
+type HistoryPage struct {
+	Key     string         `json:"key"`
+	Entries []HistoryEntry `json:"entries"`
+	Next    string         `json:"next,omitempty"`
+	More    bool           `json:"more"`
+}
+
+// NewHistoryEntry describes one modification, leaving out a value longer
+// than maxValue.
+func NewHistoryEntry(txID string, isDelete bool, ts time.Time, value []byte, maxValue int) HistoryEntry {
+	e := HistoryEntry{
+		TxID:        txID,
+		IsDelete:    isDelete,
+		Timestamp:   ts.UTC().Format(time.RFC3339),
+		ValueLength: len(value),
+	}
+	if len(value) == 0 {
+		return e
+	}
+	sum := sha256.Sum256(value)
+	e.ValueSHA256 = hex.EncodeToString(sum[:])
+	if len(value) > maxValue {
+		e.Truncated = true
+		return e
+	}
+	// JSON values as they are, anything else as a string
+	if json.Valid(value) {
+		e.Value = json.RawMessage(value)
+	} else {
+		e.Value, _ = json.Marshal(string(value))
+	}
+	return e
+}
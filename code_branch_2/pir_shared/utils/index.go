@@ -0,0 +1,343 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// RecordKeyPrefix is the world-state key prefix of stored records.
+const RecordKeyPrefix = "record"
+
+// SlotAlign is the window granularity: record windows start on, and span a
+// multiple of, SlotAlign slots.
+const SlotAlign = 8
+
+// RoundRecordS rounds a record size up to the window size the packer and
+// the selector builder both use (a positive multiple of SlotAlign).
+func RoundRecordS(s int) int {
+	if s <= 0 {
+		return SlotAlign
+	}
+	return (s + SlotAlign - 1) / SlotAlign * SlotAlign
+}
+
+// IndexContract is the ordering contract shared by packers, metadata and
+// clients: record index i is stored under RecordKey(i) and occupies the
+// slot window [i*Stride(), (i+1)*Stride()) of the packed plaintext.
+// Every index in [0, NRecords) is valid; nothing else is. A RecordS that
+// is not a multiple of SlotAlign is rounded up by Stride on both sides, so
+// servers and clients given the same metadata agree on every window.
+// Indices from ReservedFrom on are reserved: valid, but packed as zero
+// windows until an append fills them.
+type IndexContract struct {
+	NRecords     int    // "n", reserved indices included
+	RecordS      int    // "record_s"
+	Slots        int    // plaintext slots (Metadata.Slots, the params' MaxSlots)
+	ReservedFrom int    // "reserved_from": first reserved index, 0 = none
+	Packing      string // "packing": Packing1B (or empty) or Packing2B
+}
+
+// NewIndexContract derives the contract from published metadata.
+func NewIndexContract(m Metadata) IndexContract {
+	return IndexContract{NRecords: m.NRecords, RecordS: m.RecordS, Slots: m.Slots(), ReservedFrom: m.ReservedFrom, Packing: m.Packing}
+}
+
+// ReservedFromLive returns the ReservedFrom of n indices whose first live
+// hold records: live, or 0 when nothing is left reserved.
+func ReservedFromLive(live, n int) int {
+	if live >= n {
+		return 0
+	}
+	return live
+}
+
+// Live returns the number of indices that hold a record.
+func (c IndexContract) Live() int {
+	if c.ReservedFrom > 0 {
+		return c.ReservedFrom
+	}
+	return c.NRecords
+}
+
+// IsReserved reports whether index i is a reserved (zero) window.
+func (c IndexContract) IsReserved(i int) bool {
+	return c.ReservedFrom > 0 && i >= c.ReservedFrom && i < c.NRecords
+}
+
+// RecordKey returns the world-state key of record i ("record%03d").
+func RecordKey(i int) string {
+	return fmt.Sprintf("%s%03d", RecordKeyPrefix, i)
+}
+
+// Stride is the window size: RecordS rounded up to SlotAlign.
+func (c IndexContract) Stride() int { return RoundRecordS(c.RecordS) }
+
+// Validate checks that every index has a full window inside the plaintext
+// (a single shard; see ValidateSharded).
+func (c IndexContract) Validate() error {
+	if err := c.ValidateSharded(); err != nil {
+		return err
+	}
+	if c.Slots > 0 && c.NRecords > c.Slots/c.Stride() {
+		return fmt.Errorf("index contract: n=%d × record_s=%d (window %d) needs %d slots, exceeds N=%d",
+			c.NRecords, c.RecordS, c.Stride(), c.NRecords*c.Stride(), c.Slots)
+	}
+	return nil
+}
+
+// Window returns the slot window [start, end) of record index i. It is an
+// error, not a truncated window, when i*Stride()+Stride() > Slots.
+func (c IndexContract) Window(i int) (start, end int, err error) {
+	if i < 0 || i >= c.NRecords {
+		return 0, 0, fmt.Errorf("index %d out of range 0..%d", i, c.NRecords-1)
+	}
+	if c.RecordS <= 0 {
+		return 0, 0, fmt.Errorf("index contract: record_s=%d must be positive", c.RecordS)
+	}
+	stride := c.Stride()
+	if c.Slots > 0 && i >= c.Slots/stride {
+		return 0, 0, fmt.Errorf("index %d: window [%d:%d) exceeds N=%d", i, i*stride, i*stride+stride, c.Slots)
+	}
+	start = i * stride
+	return start, start + stride, nil
+}
+
+// Errors of Index, and so of PublicQuery on both servers. Their text
+// survives the REST and gateway hops; IsInvalidKey and IsRecordNotFound
+// recognise it in a relayed message.
+var (
+	ErrInvalidKey     = errors.New("invalid record key")
+	ErrRecordNotFound = errors.New("record not found")
+)
+
+// IsInvalidKey reports whether msg is an ErrInvalidKey error text.
+func IsInvalidKey(msg string) bool { return strings.Contains(msg, ErrInvalidKey.Error()) }
+
+// IsRecordNotFound reports whether msg is an ErrRecordNotFound error text.
+func IsRecordNotFound(msg string) bool { return strings.Contains(msg, ErrRecordNotFound.Error()) }
+
+// Index resolves a world-state key back to its record index. Unlike
+// ParseRecordIndex it rejects keys that are not in canonical RecordKey
+// form (e.g. "record7" for index 7, "m_DB") with ErrInvalidKey, and keys
+// outside [0, NRecords) with ErrRecordNotFound.
+func (c IndexContract) Index(key string) (int, error) {
+	i, ok := ParseRecordIndex(key)
+	if !ok || i < 0 || RecordKey(i) != key {
+		return 0, fmt.Errorf("%w %q (want %s..%s)", ErrInvalidKey, key, RecordKey(0), RecordKey(max(c.NRecords-1, 0)))
+	}
+	if i >= c.NRecords {
+		return 0, fmt.Errorf("%w: %q, index %d out of range 0..%d", ErrRecordNotFound, key, i, c.NRecords-1)
+	}
+	return i, nil
+}
+
+// CheckGenerated verifies that a generator produced exactly the requested
+// number of records. A short result would leave callers that already
+// picked target indices from the requested n reading the wrong windows.
+func CheckGenerated(requested int, records [][]byte) error {
+	if len(records) != requested {
+		return fmt.Errorf("index contract: requested %d records, generator returned %d; indices >= %d would be missing",
+			requested, len(records), len(records))
+	}
+	return nil
+}
+
+// SelectorLayout is the DescribeSelector response: the slot window the
+// server packed record Index into. StartSlot/EndSlot are relative to Shard.
+type SelectorLayout struct {
+	Index     int    `json:"index"`
+	Key       string `json:"key"`
+	StartSlot int    `json:"start_slot"`
+	EndSlot   int    `json:"end_slot"` // exclusive
+	Shard     int    `json:"shard"`
+	Reserved  bool   `json:"reserved,omitempty"` // zero window, decodes to EmptyRecord
+}
+
+// Describe returns the selector layout of record index i. Records never
+// straddle shards, so shard k holds Slots/RecordS consecutive records;
+// with a single plaintext Shard is always 0 and the window equals Window(i).
+func (c IndexContract) Describe(i int) (SelectorLayout, error) {
+	if i < 0 || i >= c.NRecords {
+		return SelectorLayout{}, fmt.Errorf("index %d out of range 0..%d", i, c.NRecords-1)
+	}
+	if c.RecordS <= 0 {
+		return SelectorLayout{}, fmt.Errorf("index contract: record_s=%d must be positive", c.RecordS)
+	}
+	stride := c.Stride()
+	shard, pos := 0, i
+	if perShard := c.Slots / stride; c.Slots > 0 && perShard > 0 {
+		shard, pos = i/perShard, i%perShard
+	}
+	start := pos * stride
+	return SelectorLayout{
+		Index:     i,
+		Key:       RecordKey(i),
+		StartSlot: start,
+		EndSlot:   start + stride,
+		Shard:     shard,
+		Reserved:  c.IsReserved(i),
+	}, nil
+}
+
+// WindowTable is the GetWindowTable response: everything that fixes the
+// selector plaintext of every index, so clients can encode selectors ahead
+// of time and cache them under LayoutID. Windows follow Describe from the
+// generator fields; the expanded list is only sent on request.
+type WindowTable struct {
+	LayoutID string           `json:"layout_id"` // LayoutDigest
+	Version  int              `json:"version"`   // m_DB_version when served
+	NRecords int              `json:"n"`
+	RecordS  int              `json:"record_s"`
+	Stride   int              `json:"stride"`
+	Slots    int              `json:"slots"`
+	PerShard int              `json:"per_shard"`
+	Packing  string           `json:"packing"`
+	LogN     int              `json:"logN"`
+	T        uint64           `json:"t"`
+	LogQi    []int            `json:"logQi"`
+	Windows  []SelectorLayout `json:"windows,omitempty"`
+	// ReservedFrom does not change any selector, so it is not part of
+	// the layout digest.
+	ReservedFrom int `json:"reserved_from,omitempty"`
+}
+
+// NewWindowTable builds the window table of m at m_DB_version version,
+// with every index's window listed if expand is set.
+func NewWindowTable(m Metadata, version int, expand bool) (WindowTable, error) {
+	c := NewIndexContract(m)
+	if err := c.ValidateSharded(); err != nil {
+		return WindowTable{}, err
+	}
+	t := WindowTable{
+		Version:  version,
+		NRecords: c.NRecords,
+		RecordS:  c.RecordS,
+		Stride:   c.Stride(),
+		Slots:    c.Slots,
+		PerShard: c.Slots / c.Stride(),
+		Packing:  c.packingMode(),
+		LogN:     m.LogN,
+		T:        m.T,
+		LogQi:    m.LogQi,
+
+		ReservedFrom: c.ReservedFrom,
+	}
+	t.LayoutID = t.LayoutDigest()
+	if expand {
+		t.Windows = make([]SelectorLayout, c.NRecords)
+		for i := range t.Windows {
+			w, err := c.Describe(i)
+			if err != nil {
+				return WindowTable{}, err
+			}
+			t.Windows[i] = w
+		}
+	}
+	return t, nil
+}
+
+// LayoutDigest hashes the fields a selector plaintext depends on: two
+// tables with the same digest give every index the same plaintext, so it
+// survives m_DB updates that keep the layout (Version is not included).
+func (t WindowTable) LayoutDigest() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("n=%d;record_s=%d;stride=%d;slots=%d;per_shard=%d;packing=%s;logN=%d;t=%d;logQi=%v",
+		t.NRecords, t.RecordS, t.Stride, t.Slots, t.PerShard, t.Packing, t.LogN, t.T, t.LogQi)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Contract is the IndexContract the table was generated from.
+func (t WindowTable) Contract() IndexContract {
+	return IndexContract{NRecords: t.NRecords, RecordS: t.RecordS, Slots: t.Slots, ReservedFrom: t.ReservedFrom, Packing: t.Packing}
+}
+
+// Check verifies that the table is consistent with its own generator
+// fields: the digest, the derived stride and shard size, and every listed
+// window.
+func (t WindowTable) Check() error {
+	c := t.Contract()
+	if err := c.ValidateSharded(); err != nil {
+		return err
+	}
+	if t.Stride != c.Stride() || t.PerShard != c.Slots/c.Stride() {
+		return fmt.Errorf("window table: stride %d / per_shard %d do not follow from n=%d record_s=%d slots=%d",
+			t.Stride, t.PerShard, t.NRecords, t.RecordS, t.Slots)
+	}
+	if t.LayoutID != t.LayoutDigest() {
+		return fmt.Errorf("window table: layout_id %.12s does not match its fields (%.12s)", t.LayoutID, t.LayoutDigest())
+	}
+	if len(t.Windows) > 0 && len(t.Windows) != t.NRecords {
+		return fmt.Errorf("window table: %d windows for n=%d", len(t.Windows), t.NRecords)
+	}
+	for i, w := range t.Windows {
+		if want, _ := c.Describe(i); w != want {
+			return fmt.Errorf("window table: index %d listed at shard %d [%d:%d), want shard %d [%d:%d)",
+				i, w.Shard, w.StartSlot, w.EndSlot, want.Shard, want.StartSlot, want.EndSlot)
+		}
+	}
+	return nil
+}
+
+// Pack lays records out BytesPerSlot(Packing) bytes per slot, record i in
+// Window(i) of a Slots-long vector; reserved windows stay zero. A record
+// longer than RecordBytes() is an error (it would otherwise be cut short or spill into its
+// neighbour). Servers and data-owner clients (PutMDB) must pack with this
+// so m_DB is identical.
+func (c IndexContract) Pack(records [][]byte) ([]uint64, error) {
+	if c.Slots <= 0 {
+		return nil, fmt.Errorf("index contract: Slots must be set to pack")
+	}
+	if len(records) != c.Live() {
+		return nil, fmt.Errorf("index contract: %d records, n=%d (%d live)", len(records), c.NRecords, c.Live())
+	}
+	packed := make([]uint64, c.Slots)
+	for i, rec := range records {
+		start, _, err := c.Window(i)
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) > c.RecordBytes() {
+			return nil, fmt.Errorf("index contract: record %d is %d bytes, record_s=%d (%s)", i, len(rec), c.RecordS, c.packingMode())
+		}
+		c.PackWindow(packed[start:], rec)
+	}
+	return packed, nil
+}
+
+// Unpack is the inverse of Pack for records [offset, offset+count): each
+// window is returned as RecordBytes() bytes, padding included.
+func (c IndexContract) Unpack(slots []uint64, offset, count int) ([][]byte, error) {
+	if count < 0 || offset < 0 || offset+count > c.NRecords {
+		return nil, fmt.Errorf("index contract: records [%d:%d) out of range 0..%d", offset, offset+count, c.NRecords)
+	}
+	out := make([][]byte, count)
+	for k := range out {
+		start, end, err := c.Window(offset + k)
+		if err != nil {
+			return nil, err
+		}
+		if end > len(slots) {
+			return nil, fmt.Errorf("index contract: window [%d:%d) exceeds %d slots", start, end, len(slots))
+		}
+		out[k] = c.UnpackWindow(slots[start:end])
+	}
+	return out, nil
+}
+
+// EmptyRecord is what clients return for an all-zero window, i.e. a
+// reserved index. Live records are non-empty JSON, so it cannot be
+// mistaken for one the way an empty string could.
+const EmptyRecord = "null"
+
+// TrimPadding cuts a packed window at its first zero byte, the way
+// DecryptResult reads a record out of a PIR result.
+func TrimPadding(window []byte) []byte {
+	for i, b := range window {
+		if b == 0 {
+			return window[:i]
+		}
+	}
+	return window
+}
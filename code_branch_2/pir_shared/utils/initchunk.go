@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// InitChunk is the InitAddRecords argument: records Offset, Offset+1, …
+// of the dataset announced by InitBegin. Chunks must arrive in order; a
+// chunk whose Offset does not match the records received so far (staged +
+// rejected) is refused, so a resubmitted chunk cannot be staged twice.
+type InitChunk struct {
+	Offset  int               `json:"offset"`
+	Records []json.RawMessage `json:"records"`
+}
+
+// InitStatus is the InitBegin / InitAddRecords result. Records rejected by
+// the sanitize pipeline count towards n but are not staged; the next chunk
+// starts at Offset == Staged + Rejected.
+type InitStatus struct {
+	LogN       int         `json:"logN"`
+	NRecords   int         `json:"n"`                    // records announced by InitBegin
+	Staged     int         `json:"staged"`               // records accepted so far
+	Rejected   int         `json:"rejected"`             // records rejected so far
+	Rejections []Rejection `json:"rejections,omitempty"` // this chunk only
+}
+
+// MDBUpload is the PutMDB metadata: the params and layout a data owner
+// packed and encoded m_DB with (IndexContract.Pack), the SHA-256 of the
+// marshalled plaintext, and RecordHash of every record in index order.
+type MDBUpload struct {
+	LogN         int      `json:"logN"`
+	LogQi        []int    `json:"logQi"`
+	LogPi        []int    `json:"logPi"`
+	T            uint64   `json:"t"`
+	NRecords     int      `json:"n"`
+	RecordS      int      `json:"record_s"`
+	SHA256       string   `json:"sha256"`
+	RecordHashes []string `json:"record_hashes"`
+}
+
+// RecordHash is the hex SHA-256 of one record's JSON bytes.
+func RecordHash(rec []byte) string {
+	sum := sha256.Sum256(rec)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,106 @@
+//go:build !lattigo_v5
+
+package utils
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
+)
+
+/********* LATTIGO v6 HELPERS ****************************************/
+
+// Helpers typed on Lattigo v6. They are left out of -tags lattigo_v5
+// builds, which reach the HE library only through pir_shared/he.
+
+// BuildParamsFromHint builds bgv.Parameters from the hint,
+// applying defaults where the hint omits values. Hints for other schemes
+// go through pir_shared/he.
+func BuildParamsFromHint(h BGVParamHint) (bgv.Parameters, error) {
+	h, err := h.Resolved()
+	if err != nil {
+		return bgv.Parameters{}, err
+	}
+	if h.Scheme != SchemeBGV {
+		return bgv.Parameters{}, fmt.Errorf("scheme %s: BuildParamsFromHint builds BGV params only", h.Scheme)
+	}
+	return bgv.NewParametersFromLiteral(bgv.ParametersLiteral{
+		LogN:             h.LogN,
+		LogQ:             h.LogQi,
+		LogP:             h.LogPi,
+		PlaintextModulus: h.T,
+	})
+}
+
+// BuildParamsFromMetadata convenience: converts Metadata -> BGVParamHint -> bgv.Parameters.
+func BuildParamsFromMetadata(m Metadata) (bgv.Parameters, error) {
+	return BuildParamsFromHint(HintFromMetadata(m))
+}
+
+// (Optional) legacy helper kept for compatibility.
+func ParamsLiteral128(logN int) (bgv.Parameters, error) {
+	return BuildParamsFromHint(BGVParamHint{LogN: logN})
+}
+
+// DeltaParams builds DeltaHint(info, main).
+func DeltaParams(info DeltaInfo, main Metadata) (bgv.Parameters, error) {
+	return BuildParamsFromHint(DeltaHint(info, main))
+}
+
+// DebugPrintRecords prints debug information about the plaintext database
+func DebugPrintRecords(params bgv.Parameters, records [][]byte, slotsPerRec int, pt *rlwe.Plaintext) {
+	if pt == nil {
+		return
+	}
+
+	enc := bgv.NewEncoder(params)
+	vec := make([]uint64, params.MaxSlots())
+	if err := enc.Decode(pt, vec); err != nil {
+		log.Println("[ERROR] decode error in debugPrintRecords:", err)
+		return
+	}
+
+	totalRecs := len(records)
+	log.Printf("[INFO] Debugging PTDB: total records = %d, slotsPerRec = %d", totalRecs, slotsPerRec)
+	log.Println("--- BEGIN DEBUG DB CONTENT ---")
+
+	// Helper to decode a single record
+	ic := IndexContract{NRecords: totalRecs, RecordS: slotsPerRec, Slots: len(vec)}
+	printRecord := func(idx int) {
+		start, end, err := ic.Window(idx)
+		if err != nil {
+			log.Printf("[ERROR] rec %03d: %v", idx, err)
+			return
+		}
+
+		var buf []byte
+		for _, v := range vec[start:end] {
+			if v == 0 {
+				break
+			}
+			buf = append(buf, byte(v))
+		}
+		log.Printf("[DEBUG rec %03d | slots %d–%d] %s", idx, start, end-1, string(buf))
+	}
+
+	// Print first 3 records
+	for i := 0; i < 3 && i < totalRecs; i++ {
+		printRecord(i)
+	}
+
+	// Separator if there are many records
+	if totalRecs > 6 {
+		log.Println("... (skipping middle records) ...")
+	}
+
+	// Print last 3 records
+	for i := totalRecs - 3; i < totalRecs; i++ {
+		if i >= 3 { // Avoid duplicates for small DB sizes
+			printRecord(i)
+		}
+	}
+
+	log.Println("--- END DEBUG DB CONTENT ---")
+}
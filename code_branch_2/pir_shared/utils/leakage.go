@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+)
+
+/********* NEIGHBOUR LEAKAGE ***************************************/
+
+// The selector of index i is 1 on Describe(i)'s window and 0 elsewhere,
+// so shard k of a result decrypts to record i and zeros. A non-zero slot
+// outside the window, or a window that holds more than record i, means
+// the packer and the selector disagree (stride, alignment, packing mode)
+// and a client reads part of a neighbouring record. FindLeaks lists such
+// slots in a decrypted result (clients run it as a runtime diagnostic);
+// CheckPackingIsolation runs both checks on the plaintext model of the
+// product (selector × packed shard, slot by slot) for every packing mode,
+// without HE or a server, so a new packer is checked wherever Go runs
+// (gen-vectors -leakage).
+
+// Leak is one non-zero slot outside the queried window.
+type Leak struct {
+	Slot   int    `json:"slot"`
+	Value  uint64 `json:"value"`
+	Record int    `json:"record"` // index whose window holds Slot, -1 past the last window
+}
+
+// LeakReport is FindLeaks' result for the query of Index.
+type LeakReport struct {
+	Index int    `json:"index"`
+	Shard int    `json:"shard"`
+	Leaks []Leak `json:"leaks,omitempty"`
+}
+
+// Records lists the indices leaked from, in slot order.
+func (r LeakReport) Records() []int {
+	var out []int
+	for _, l := range r.Leaks {
+		if l.Record >= 0 && !slices.Contains(out, l.Record) {
+			out = append(out, l.Record)
+		}
+	}
+	return out
+}
+
+// Err is nil without leaks.
+func (r LeakReport) Err() error {
+	if len(r.Leaks) == 0 {
+		return nil
+	}
+	first := r.Leaks[0]
+	return fmt.Errorf("result of index %d leaks %d non-zero slots outside its window (records %v of shard %d; first slot %d = %d)",
+		r.Index, len(r.Leaks), r.Records(), r.Shard, first.Slot, first.Value)
+}
+
+// Selector is the slot vector of index i's query: 1 on Describe(i)'s
+// window, 0 elsewhere.
+func (c IndexContract) Selector(i int) ([]uint64, error) {
+	w, err := c.Describe(i)
+	if err != nil {
+		return nil, err
+	}
+	if c.Slots <= 0 || w.EndSlot > c.Slots {
+		return nil, fmt.Errorf("index contract: window [%d:%d) exceeds N=%d", w.StartSlot, w.EndSlot, c.Slots)
+	}
+	sel := make([]uint64, c.Slots)
+	for s := w.StartSlot; s < w.EndSlot; s++ {
+		sel[s] = 1
+	}
+	return sel, nil
+}
+
+// FindLeaks checks slots, the decrypted result of index's shard, for
+// non-zero values outside index's window.
+func (c IndexContract) FindLeaks(index int, slots []uint64) (LeakReport, error) {
+	w, err := c.Describe(index)
+	if err != nil {
+		return LeakReport{}, err
+	}
+	rep := LeakReport{Index: index, Shard: w.Shard}
+	stride := c.Stride()
+	perShard := len(slots) / stride
+	for s, v := range slots {
+		if v == 0 || (s >= w.StartSlot && s < w.EndSlot) {
+			continue
+		}
+		rec := -1
+		if pos := s / stride; pos < perShard && w.Shard*perShard+pos < c.NRecords {
+			rec = w.Shard*perShard + pos
+		}
+		rep.Leaks = append(rep.Leaks, Leak{Slot: s, Value: v, Record: rec})
+	}
+	return rep, nil
+}
+
+// CheckPackingIsolation packs records under every packing mode into
+// slots-slot shards and checks, for every index, that the modelled result
+// has no leaks and that its window holds the record and zeros only.
+func CheckPackingIsolation(records [][]byte, slots int) error {
+	for _, mode := range PackingModes {
+		ic := IndexContract{NRecords: len(records), RecordS: CalcSlotsPerRecPacked(records, mode), Slots: slots, Packing: mode}
+		shards, err := ic.PackShards(records)
+		if err != nil {
+			return fmt.Errorf("packing %s: %w", mode, err)
+		}
+		for i, rec := range records {
+			w, err := ic.Describe(i)
+			if err != nil {
+				return err
+			}
+			sel, err := ic.Selector(i)
+			if err != nil {
+				return err
+			}
+			res := make([]uint64, slots)
+			for s, v := range shards[w.Shard] {
+				res[s] = v * sel[s]
+			}
+			rep, err := ic.FindLeaks(i, res)
+			if err != nil {
+				return err
+			}
+			if err := rep.Err(); err != nil {
+				return fmt.Errorf("packing %s: %w", mode, err)
+			}
+			window := ic.UnpackWindow(res[w.StartSlot:w.EndSlot])
+			tail := res[w.StartSlot+ic.RecordS : w.EndSlot] // alignment slots past record_s
+			if !bytes.Equal(window[:len(rec)], rec) || !allZero(window[len(rec):]) || !allZero(tail) {
+				return fmt.Errorf("packing %s: window of record %d holds %q, want %q and padding", mode, i, TrimPadding(window), rec)
+			}
+		}
+	}
+	return nil
+}
+
+func allZero[T byte | uint64](s []T) bool {
+	for _, v := range s {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// leakTestRecords returns n JSON records of 1..maxLen bytes, the lengths
+// cycling so that windows end on every alignment.
+func leakTestRecords(n, maxLen int) [][]byte {
+	records := make([][]byte, n)
+	for i := range records {
+		rec := fmt.Sprintf(`{"id":%d,"v":"`, i)
+		rec += strings.Repeat("x", (i*7)%max(1, maxLen-len(rec)-2)) + `"}`
+		records[i] = []byte(rec)
+	}
+	return records
+}
+
+// modelResult is the plaintext model of index i's result: its selector
+// times the packed shard that holds i, slot by slot.
+func modelResult(t *testing.T, ic IndexContract, shards [][]uint64, sel []uint64, i int) []uint64 {
+	t.Helper()
+	w, err := ic.Describe(i)
+	if err != nil {
+		t.Fatalf("Describe(%d): %v", i, err)
+	}
+	res := make([]uint64, ic.Slots)
+	for s, v := range shards[w.Shard] {
+		res[s] = v * sel[s]
+	}
+	return res
+}
+
+func TestFindLeaksPackingModes(t *testing.T) {
+	cases := []struct {
+		name   string
+		n      int
+		maxLen int
+		slots  int
+	}{
+		{"one shard", 16, 100, 4096},
+		{"full shard", 32, 128, 4096},
+		{"several shards", 64, 120, 2048},
+		{"odd record length", 10, 61, 1024},
+	}
+	for _, mode := range []string{Packing1B, Packing2B} {
+		for _, c := range cases {
+			t.Run(mode+"/"+c.name, func(t *testing.T) {
+				records := leakTestRecords(c.n, c.maxLen)
+				ic := IndexContract{NRecords: c.n, RecordS: CalcSlotsPerRecPacked(records, mode), Slots: c.slots, Packing: mode}
+				shards, err := ic.PackShards(records)
+				if err != nil {
+					t.Fatalf("PackShards: %v", err)
+				}
+				for i := range records {
+					sel, err := ic.Selector(i)
+					if err != nil {
+						t.Fatalf("Selector(%d): %v", i, err)
+					}
+					rep, err := ic.FindLeaks(i, modelResult(t, ic, shards, sel, i))
+					if err != nil {
+						t.Fatalf("FindLeaks(%d): %v", i, err)
+					}
+					if err := rep.Err(); err != nil {
+						t.Errorf("packing %s: %v", mode, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestFindLeaksShiftedSelector checks FindLeaks does report a selector
+// that is off by one slot, so a clean run above means something.
+func TestFindLeaksShiftedSelector(t *testing.T) {
+	for _, mode := range []string{Packing1B, Packing2B} {
+		t.Run(mode, func(t *testing.T) {
+			records := leakTestRecords(8, 100)
+			ic := IndexContract{NRecords: 8, RecordS: CalcSlotsPerRecPacked(records, mode), Slots: 2048, Packing: mode}
+			shards, err := ic.PackShards(records)
+			if err != nil {
+				t.Fatalf("PackShards: %v", err)
+			}
+			const i = 3
+			w, err := ic.Describe(i)
+			if err != nil {
+				t.Fatalf("Describe(%d): %v", i, err)
+			}
+			sel := make([]uint64, ic.Slots)
+			for s := w.StartSlot; s < w.EndSlot; s++ {
+				sel[s+1] = 1 // reaches into record i+1's first slot
+			}
+			rep, err := ic.FindLeaks(i, modelResult(t, ic, shards, sel, i))
+			if err != nil {
+				t.Fatalf("FindLeaks: %v", err)
+			}
+			if rep.Err() == nil {
+				t.Fatalf("no leak reported for a selector shifted into record %d", i+1)
+			}
+			if got := rep.Records(); len(got) != 1 || got[0] != i+1 {
+				t.Errorf("leaked records %v, want [%d]", got, i+1)
+			}
+		})
+	}
+}
+
+func TestCheckPackingIsolation(t *testing.T) {
+	if err := CheckPackingIsolation(leakTestRecords(40, 128), 2048); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,127 @@
+//go:build !lattigo_v5
+
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
+)
+
+/********* WARM MASK POOL ********************************************/
+
+// Public-key encryption is an encryption of zero (sampling u, e0, e1 and
+// two polynomial products) plus the plaintext, and the first part does not
+// depend on the plaintext. A MaskPool precomputes those zero encryptions
+// in background goroutines, so encrypting a query costs one addition when
+// a mask is ready. Every mask is handed out once; when the pool is empty,
+// Encrypt falls back to a full encryption instead of waiting.
+
+// MaskPoolOptions sizes a MaskPool.
+type MaskPoolOptions struct {
+	Size    int // masks kept ready (<= 0: 4)
+	Workers int // background encryptors (<= 0: 1)
+}
+
+// MaskPoolStats counts how Encrypt calls were served.
+type MaskPoolStats struct {
+	Ready  int    `json:"ready"`
+	Hits   uint64 `json:"hits"`   // served from a mask
+	Misses uint64 `json:"misses"` // pool empty, encrypted inline
+}
+
+// MaskPool holds max-level zero encryptions under one public key.
+type MaskPool struct {
+	params bgv.Parameters
+	pk     *rlwe.PublicKey
+	masks  chan *rlwe.Ciphertext
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+
+	hits, misses atomic.Uint64
+}
+
+// NewMaskPool starts opt.Workers goroutines filling the pool; Close stops them.
+func NewMaskPool(params bgv.Parameters, pk *rlwe.PublicKey, opt MaskPoolOptions) *MaskPool {
+	if opt.Size <= 0 {
+		opt.Size = 4
+	}
+	if opt.Workers <= 0 {
+		opt.Workers = 1
+	}
+	p := &MaskPool{
+		params: params,
+		pk:     pk,
+		masks:  make(chan *rlwe.Ciphertext, opt.Size),
+		stop:   make(chan struct{}),
+	}
+	for i := 0; i < opt.Workers; i++ {
+		p.wg.Add(1)
+		go p.fill()
+	}
+	return p
+}
+
+// fill keeps the channel topped up with fresh masks until Close.
+func (p *MaskPool) fill() {
+	defer p.wg.Done()
+	enc := rlwe.NewEncryptor(p.params, p.pk)
+	for {
+		ct := rlwe.NewCiphertext(p.params, 1, p.params.MaxLevel())
+		if err := enc.EncryptZero(ct); err != nil {
+			return
+		}
+		select {
+		case p.masks <- ct:
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// Matches reports whether the pool encrypts under params and pk.
+func (p *MaskPool) Matches(params bgv.Parameters, pk *rlwe.PublicKey) bool {
+	return p != nil && p.pk == pk && p.params.Equal(&params)
+}
+
+// Encrypt returns an encryption of pt (at pt's level, at most MaxLevel)
+// distributed as rlwe.Encryptor.EncryptNew would produce it.
+func (p *MaskPool) Encrypt(pt *rlwe.Plaintext) (*rlwe.Ciphertext, error) {
+	var ct *rlwe.Ciphertext
+	select {
+	case ct = <-p.masks:
+	default:
+	}
+	if ct == nil || ct.IsNTT != pt.IsNTT {
+		p.misses.Add(1)
+		return rlwe.NewEncryptor(p.params, p.pk).EncryptNew(pt)
+	}
+	p.hits.Add(1)
+	if pt.Level() > ct.Level() {
+		return nil, fmt.Errorf("plaintext level %d above mask level %d", pt.Level(), ct.Level())
+	}
+	*ct.MetaData = *pt.MetaData
+	ct.Resize(ct.Degree(), pt.Level())
+	p.params.RingQ().AtLevel(pt.Level()).Add(ct.Value[0], pt.Value, ct.Value[0])
+	return ct, nil
+}
+
+// Stats reports the pool's fill level and hit rate.
+func (p *MaskPool) Stats() MaskPoolStats {
+	return MaskPoolStats{Ready: len(p.masks), Hits: p.hits.Load(), Misses: p.misses.Load()}
+}
+
+// Close stops the workers and drops the remaining masks.
+func (p *MaskPool) Close() {
+	p.once.Do(func() {
+		close(p.stop)
+		p.wg.Wait()
+		for len(p.masks) > 0 {
+			<-p.masks
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"fmt"
+)
+
+/********* m_DB HASH ***********************************************/
+
+// A dataset init commits only when every endorser wrote the same m_DB, and
+// m_DB_sha256 is the digest over the marshalled shards in shard order,
+// under the algorithm in m_DB_digest_alg (SHA-256 when absent; see
+// DigestHex).
+// VerifyMDBHash recomputes it on the peer it is evaluated on: from the
+// shards in world state, and for a generated dataset from dataset_spec
+// (records, packing and encoding redone on that peer). Evaluated on a
+// peer of every org, equal reports mean the peers would endorse the same
+// init.
+
+// MDBHash is the SHA-256 m_DB_sha256 of shards, the marshalled m_DB
+// shards in order.
+func MDBHash(shards [][]byte) string {
+	return MDBDigest(DigestSHA256, shards)
+}
+
+// MDBDigest is MDBHash under alg.
+func MDBDigest(alg string, shards [][]byte) string {
+	return DigestHex(alg, shards...)
+}
+
+// MDBHashReport is the VerifyMDBHash response. Empty hashes were not
+// computed.
+type MDBHashReport struct {
+	Committed   string   `json:"committed"`             // m_DB_sha256
+	Alg         string   `json:"alg"`                   // m_DB_digest_alg, for every hash here
+	Stored      string   `json:"stored"`                // recomputed from the stored shards
+	Regenerated string   `json:"regenerated,omitempty"` // m_DB rebuilt from dataset_spec on this peer
+	Expected    string   `json:"expected,omitempty"`    // the caller's
+	Seed        string   `json:"seed,omitempty"`
+	Match       bool     `json:"match"`
+	Mismatches  []string `json:"mismatches,omitempty"`
+}
+
+// Check compares every computed hash with Committed and sets Match and
+// Mismatches.
+func (r *MDBHashReport) Check() {
+	r.Mismatches = nil
+	for _, c := range []struct{ name, sum string }{
+		{"stored", r.Stored}, {"regenerated", r.Regenerated}, {"expected", r.Expected},
+	} {
+		if c.sum != "" && c.sum != r.Committed {
+			r.Mismatches = append(r.Mismatches, fmt.Sprintf("%s %s != committed %s", c.name, c.sum, r.Committed))
+		}
+	}
+	r.Match = r.Committed != "" && len(r.Mismatches) == 0
+}
+
+// Err is nil for a matching report.
+func (r MDBHashReport) Err() error {
+	switch {
+	case r.Match:
+		return nil
+	case r.Committed == "":
+		return fmt.Errorf("no m_DB committed")
+	default:
+		return fmt.Errorf("m_DB hash mismatch: %v", r.Mismatches)
+	}
+}
@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// MerkleRoot is the hex SHA-256 Merkle root over leaves (hex RecordHash
+// values, in index order). An odd node is paired with itself; an empty
+// set has the root of no data, sha256("").
+func MerkleRoot(leaves []string) string {
+	if len(leaves) == 0 {
+		sum := sha256.Sum256(nil)
+		return hex.EncodeToString(sum[:])
+	}
+	level := make([][]byte, len(leaves))
+	for i, l := range leaves {
+		b, err := hex.DecodeString(l)
+		if err != nil {
+			b = []byte(l)
+		}
+		level[i] = b
+	}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			right := level[i]
+			if i+1 < len(level) {
+				right = level[i+1]
+			}
+			sum := sha256.Sum256(append(append([]byte{}, level[i]...), right...))
+			next = append(next, sum[:])
+		}
+		level = next
+	}
+	return hex.EncodeToString(level[0])
+}
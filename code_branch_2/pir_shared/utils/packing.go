@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+/********* RECORD PACKING ******************************************/
+
+// Records are packed one byte per slot by default ("1b"). Under "2b" each
+// slot holds two bytes as a big-endian value b0<<8 | b1 < 65536, so a
+// record's window, RecordS, is half as many slots and the same LogN holds
+// twice the records. The plaintext modulus must exceed every slot value
+// (the default T = 65537 does); CKKS, which returns approximate values,
+// stays at "1b". The mode is fixed when the dataset is packed and
+// published in the metadata, where clients read it to decode results.
+
+// Packing modes.
+const (
+	Packing1B = "1b"
+	Packing2B = "2b"
+)
+
+// PackingModes lists the packing modes in the order they were added.
+var PackingModes = []string{Packing1B, Packing2B}
+
+// ParsePacking returns the canonical name of packing; empty is Packing1B.
+func ParsePacking(packing string) (string, error) {
+	p := strings.ToLower(strings.TrimSpace(packing))
+	if p == "" {
+		return Packing1B, nil
+	}
+	for _, known := range PackingModes {
+		if p == known {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown packing mode %q (want one of %s)", packing, strings.Join(PackingModes, ", "))
+}
+
+// BytesPerSlot is the number of record bytes one slot holds under packing.
+func BytesPerSlot(packing string) int {
+	if packing == Packing2B {
+		return 2
+	}
+	return 1
+}
+
+// CheckPacking verifies that packing can be used with an HE scheme and
+// plaintext modulus t: every slot value must stay below t, and CKKS only
+// packs single bytes.
+func CheckPacking(packing, scheme string, t uint64) error {
+	if packing != Packing2B {
+		return nil
+	}
+	if scheme == SchemeCKKS {
+		return fmt.Errorf("packing %s is not available under %s", packing, scheme)
+	}
+	if t <= 0xFFFF {
+		return fmt.Errorf("packing %s needs T > 65535, have T=%d", packing, t)
+	}
+	return nil
+}
+
+// PackingMode is m.Packing with the empty default resolved to Packing1B.
+func (m Metadata) PackingMode() string {
+	if m.Packing == "" {
+		return Packing1B
+	}
+	return m.Packing
+}
+
+// packingMode is c.Packing with the empty default resolved to Packing1B.
+func (c IndexContract) packingMode() string {
+	if c.Packing == "" {
+		return Packing1B
+	}
+	return c.Packing
+}
+
+// RecordBytes is the longest record a window holds: RecordS slots of
+// BytesPerSlot bytes.
+func (c IndexContract) RecordBytes() int {
+	return c.RecordS * BytesPerSlot(c.Packing)
+}
+
+// PackWindow writes rec into the window starting at dst[0], BytesPerSlot
+// bytes per slot; the slots past len(rec) are left as they are.
+func (c IndexContract) PackWindow(dst []uint64, rec []byte) {
+	if BytesPerSlot(c.Packing) == 1 {
+		for j, b := range rec {
+			dst[j] = uint64(b)
+		}
+		return
+	}
+	for j := 0; j < len(rec); j += 2 {
+		v := uint64(rec[j]) << 8
+		if j+1 < len(rec) {
+			v |= uint64(rec[j+1])
+		}
+		dst[j/2] = v
+	}
+}
+
+// UnpackWindow is the inverse of PackWindow for one window: the
+// RecordBytes() bytes held by its first RecordS slots, padding included.
+func (c IndexContract) UnpackWindow(slots []uint64) []byte {
+	if BytesPerSlot(c.Packing) == 1 {
+		rec := make([]byte, c.RecordS)
+		for j, v := range slots[:c.RecordS] {
+			rec[j] = byte(v)
+		}
+		return rec
+	}
+	rec := make([]byte, 2*c.RecordS)
+	for j, v := range slots[:c.RecordS] {
+		rec[2*j], rec[2*j+1] = byte(v>>8), byte(v)
+	}
+	return rec
+}
+
+// CalcSlotsPerRecPacked is CalcSlotsPerRec under packing: the window of
+// the longest record, rounded up to SlotAlign slots.
+func CalcSlotsPerRecPacked(records [][]byte, packing string) int {
+	if BytesPerSlot(packing) == 1 {
+		return CalcSlotsPerRec(records)
+	}
+	longest := 0
+	for _, rec := range records {
+		longest = max(longest, len(rec))
+	}
+	slotsPerRec := PackedSlots(longest, packing)
+	log.Printf("[DEBUG] Max actual JSON len = %d bytes, packing %s → slotsPerRec = %d", longest, packing, slotsPerRec)
+	return slotsPerRec
+}
+
+// WithPacking restricts c to layouts under packing (empty: Packing1B), for
+// NegotiateInit when the dataset's packing mode is fixed by the caller. It
+// is an error when the server does not offer packing.
+func (c Capabilities) WithPacking(packing string) (Capabilities, error) {
+	p, err := ParsePacking(packing)
+	if err != nil {
+		return c, err
+	}
+	if !slices.Contains(c.Packing, p) {
+		return c, fmt.Errorf("server does not offer packing %s (has %v)", p, c.Packing)
+	}
+	c.Packing = []string{p}
+	return c, nil
+}
@@ -0,0 +1,114 @@
+//go:build !lattigo_v5
+
+package utils
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/tuneinsight/lattigo/v6/schemes/bgv"
+)
+
+/********* PARAMETER CACHE *****************************************/
+
+// bgv.NewParametersFromLiteral generates the moduli and the NTT tables of
+// the ring, which for logN 15 takes longer than sampling a key pair. The
+// clients rebuild the same literal for every GenKeysFromMetadata call, so
+// benches timing keygen measured mostly that construction. A ParamCache
+// keeps the most recently used parameter objects by literal; keys are
+// still sampled on every call.
+
+// DefaultParamCacheSize is the number of literals the clients' caches
+// keep: a few channels, plus their delta and 2D parameters.
+const DefaultParamCacheSize = 8
+
+// ParamCache is an LRU of bgv.Parameters keyed by LiteralKey. It is safe
+// for concurrent use.
+type ParamCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // of *paramEntry, most recently used first
+	entries map[string]*list.Element
+	hits    int
+	misses  int
+}
+
+type paramEntry struct {
+	key    string
+	params bgv.Parameters
+}
+
+// NewParamCache returns an empty cache of size entries (<= 0 means
+// DefaultParamCacheSize).
+func NewParamCache(size int) *ParamCache {
+	if size <= 0 {
+		size = DefaultParamCacheSize
+	}
+	return &ParamCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// LiteralKey is the hex SHA-256 of every field of lit; equal literals build
+// equal parameters.
+func LiteralKey(lit bgv.ParametersLiteral) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%+v", lit)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Params returns the parameters of lit, built on the first request and
+// reused until size other literals have been used since.
+func (c *ParamCache) Params(lit bgv.ParametersLiteral) (bgv.Parameters, error) {
+	key := LiteralKey(lit)
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		c.hits++
+		c.mu.Unlock()
+		return e.Value.(*paramEntry).params, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	// built outside the lock, so hits on other literals do not wait; two
+	// concurrent misses on one literal build it twice
+	params, err := bgv.NewParametersFromLiteral(lit)
+	if err != nil {
+		return params, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.entries[key] = c.order.PushFront(&paramEntry{key: key, params: params})
+		for c.order.Len() > c.size {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*paramEntry).key)
+		}
+	}
+	return params, nil
+}
+
+// Len is the number of cached literals.
+func (c *ParamCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Stats is the number of Params calls answered from the cache and built.
+func (c *ParamCache) Stats() (hits, misses int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// Purge drops every cached literal; the counters are kept.
+func (c *ParamCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	clear(c.entries)
+}
@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+/********* FEASIBLE PARAMETERS *************************************/
+
+// ParamTable enumerates the (logN, record_s, max n) combinations the
+// servers accept, derived from the checks that enforce them rather than
+// kept by hand: max n is the largest n CheckCapacity passes (and n+1
+// fails), the auto range is where ChooseLogN picks the row's logN, and the
+// cost, size and memory columns come from EvalCostMS,
+// EstimateCiphertextBytes and EstimateInitMemory. pirctl params table
+// prints it as CSV or JSON.
+
+// ParamRow is one feasible layout: records of RecordBytes bytes packed
+// under Packing into rings of 2^LogN slots.
+type ParamRow struct {
+	LogN            int    `json:"logN"`
+	Packing         string `json:"packing"`
+	RecordBytes     int    `json:"record_bytes"`
+	RecordS         int    `json:"record_s"` // slots per record window (PackedSlots)
+	RecordsPerShard int    `json:"records_per_shard"`
+	// AutoFrom..AutoTo are the n for which ChooseLogN (single shard, no
+	// logN=16) picks LogN; 0, 0 when it never does.
+	AutoFrom int `json:"auto_from"`
+	AutoTo   int `json:"auto_to"`
+	Shards   int `json:"shards"` // at MaxN
+	MaxN     int `json:"max_n"`  // CheckCapacity bound with Shards shards
+	// EstEvalMS is a PIRQuery over MaxN records (Shards × EvalCostMS, an
+	// upper bound: see EvalCostMS).
+	EstEvalMS  float64 `json:"est_eval_ms"`
+	QueryBytes int     `json:"query_bytes"`  // ct_q, marshalled
+	ResultB64  int     `json:"result_b64"`   // ct_r per shard, Base64 as returned
+	InitMemMiB int64   `json:"init_mem_mib"` // EstimateInitMemory at MaxN
+}
+
+// ParamTableOptions bounds ParamTable.
+type ParamTableOptions struct {
+	RecordBytes []int // record sizes (max JSON length) to tabulate
+	Packing     []string
+	MaxShards   int  // <= 0 means 1
+	AllowLogN16 bool // add single-shard logN=16 rows
+	Levels      int  // Q moduli (len(logQi)); <= 0 means len(DefaultLogQi)
+}
+
+// EstimateCiphertextBytes is the marshalled size of a degree-degree
+// ciphertext of 2^logN slots at levels Q moduli: (degree+1) polynomials of
+// N uint64 coefficients per modulus. The few header bytes are ignored;
+// the sizes the benches measured match to within them.
+func EstimateCiphertextBytes(logN, levels, degree int) int {
+	return (degree + 1) * max(levels, 1) * (1 << logN) * 8
+}
+
+// ParamTable tabulates every feasible layout of opt, by record size, then
+// packing, then logN. It fails when ChooseLogN and CheckCapacity disagree
+// on a bound, which means the table and the enforcement have drifted.
+func ParamTable(opt ParamTableOptions) ([]ParamRow, error) {
+	levels := opt.Levels
+	if levels <= 0 {
+		levels = len(DefaultLogQi)
+	}
+	maxShards := max(opt.MaxShards, 1)
+	top := MaxLogN
+	if opt.AllowLogN16 {
+		top = LogNLarge
+	}
+	var rows []ParamRow
+	for _, recordBytes := range opt.RecordBytes {
+		if recordBytes <= 0 {
+			return nil, fmt.Errorf("record size %d: must be positive", recordBytes)
+		}
+		for _, packing := range opt.Packing {
+			if _, err := ParsePacking(packing); err != nil {
+				return nil, err
+			}
+			stride := PackedSlots(recordBytes, packing)
+			for logN := MinLogN; logN <= top; logN++ {
+				slots := SlotsAt(logN, DefaultT)
+				perShard := slots / stride
+				if perShard == 0 {
+					continue
+				}
+				shards := maxShards
+				if logN > MaxLogN {
+					shards = 1
+				}
+				maxN := perShard * shards
+				if CheckCapacity(maxN, stride, LogSlots(slots), shards) != nil || CheckCapacity(maxN+1, stride, LogSlots(slots), shards) == nil {
+					return nil, fmt.Errorf("logN=%d record_s=%d: CheckCapacity bound is not %d", logN, stride, maxN)
+				}
+				row := ParamRow{
+					LogN: logN, Packing: packing, RecordBytes: recordBytes, RecordS: stride,
+					RecordsPerShard: perShard, Shards: shards, MaxN: maxN,
+					EstEvalMS:  float64(shards) * EvalCostMS[logN],
+					QueryBytes: EstimateCiphertextBytes(logN, levels, 1),
+					ResultB64:  (EstimateCiphertextBytes(logN, levels, 1) + 2) / 3 * 4,
+					InitMemMiB: EstimateInitMemory(maxN, stride, logN, levels).Total >> 20,
+				}
+				from, to, err := autoRange(logN, stride, perShard)
+				if err != nil {
+					return nil, err
+				}
+				row.AutoFrom, row.AutoTo = from, to
+				rows = append(rows, row)
+			}
+		}
+	}
+	return rows, nil
+}
+
+// autoRange is the n range for which ChooseLogN picks logN, checked at
+// both ends and just past them.
+func autoRange(logN, stride, perShard int) (from, to int, err error) {
+	if logN > MaxLogN {
+		return 0, 0, nil
+	}
+	from = 1
+	if logN > MinLogN {
+		from = (1<<(logN-1))/stride + 1
+	}
+	if from > perShard {
+		return 0, 0, nil // a smaller ring already holds every n this one does
+	}
+	for _, n := range []int{from, perShard} {
+		if got, err := ChooseLogN(n, stride); err != nil || got != logN {
+			return 0, 0, fmt.Errorf("ChooseLogN(n=%d, record_s=%d) = %d (%v), table expects %d", n, stride, got, err, logN)
+		}
+	}
+	if got, err := ChooseLogN(perShard+1, stride); err == nil && got <= logN {
+		return 0, 0, fmt.Errorf("ChooseLogN(n=%d, record_s=%d) = %d, table expects more than %d", perShard+1, stride, got, logN)
+	}
+	return from, perShard, nil
+}
+
+// WriteParamTableCSV writes rows with a header row named like the JSON
+// fields.
+func WriteParamTableCSV(out io.Writer, rows []ParamRow) error {
+	w := csv.NewWriter(out)
+	_ = w.Write([]string{"logN", "packing", "record_bytes", "record_s", "records_per_shard", "auto_from", "auto_to",
+		"shards", "max_n", "est_eval_ms", "query_bytes", "result_b64", "init_mem_mib"})
+	for _, r := range rows {
+		_ = w.Write([]string{
+			strconv.Itoa(r.LogN), r.Packing, strconv.Itoa(r.RecordBytes), strconv.Itoa(r.RecordS),
+			strconv.Itoa(r.RecordsPerShard), strconv.Itoa(r.AutoFrom), strconv.Itoa(r.AutoTo),
+			strconv.Itoa(r.Shards), strconv.Itoa(r.MaxN), fmt.Sprintf("%.2f", r.EstEvalMS),
+			strconv.Itoa(r.QueryBytes), strconv.Itoa(r.ResultB64), strconv.FormatInt(r.InitMemMiB, 10),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,310 @@
+package utils
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"math/bits"
+	"slices"
+	"strings"
+)
+
+const (
+	MinLogN   = 13
+	MaxLogN   = 15
+	LogNLarge = 16 // single-shard only, opt-in via PlanOptions.AllowLogN16
+)
+
+// EvalCostMS is the cost (ms) of a whole PIRQuery transaction against a
+// single-plaintext dataset per logN: the ms_per_tx of the chaincode timing
+// runs (plots/tx_costs/batch_projection.csv), so ct_q decode and ct_r
+// marshal are included next to the one ct×pt product. Shards × EvalCostMS
+// (EstEvalMS) counts that overhead once per shard and overestimates a
+// multi-shard query; it ranks layouts, it does not time them.
+// logN=16 is extrapolated from the 14→15 growth factor.
+var EvalCostMS = map[int]float64{
+	13: 12.53,
+	14: 18.17,
+	15: 40.36,
+	16: 89.70,
+}
+
+// SlotsAt is the number of BGV/BFV plaintext slots of a ring of degree
+// N=2^logN under plaintext modulus t: the largest power of two n <= N with
+// t ≡ 1 mod 2n, the part of the ring the slots are packed over. It is N
+// for the default t=65537 (2^16+1) up to logN=15, but only 2^15 at
+// logN=16, so capacity is computed from the slots, never from N.
+func SlotsAt(logN int, t uint64) int {
+	n := 1 << logN
+	if t < 2 {
+		return n
+	}
+	return min(n, 1<<max(bits.TrailingZeros64(t-1)-1, 0))
+}
+
+// LogSlots is log2 of a power-of-two slot count (params MaxSlots), the
+// unit CheckCapacity takes.
+func LogSlots(slots int) int { return bits.Len(uint(slots)) - 1 }
+
+// PlanOptions bounds the search space of PlanLogN.
+type PlanOptions struct {
+	MaxShards   int  // upper bound on plaintext shards (<=0 means 1)
+	AllowLogN16 bool // allow a single logN=16 ring when nothing else fits
+}
+
+// LogNPlan is a (logN, shards) layout for a database of n records.
+type LogNPlan struct {
+	LogN            int     `json:"logN"`
+	Shards          int     `json:"shards"`
+	RecordsPerShard int     `json:"records_per_shard"`
+	EstEvalMS       float64 `json:"est_eval_ms"`
+}
+
+// Slots returns the number of slots of one shard under DefaultT.
+func (p LogNPlan) Slots() int { return SlotsAt(p.LogN, DefaultT) }
+
+// Capacity returns how many records the plan can hold in total.
+func (p LogNPlan) Capacity() int { return p.Shards * p.RecordsPerShard }
+
+// PlanLogN picks the (logN, shards) layout with the lowest estimated
+// evaluation cost that fits n records of slotsPerRec slots each.
+// Records never straddle a shard boundary. Ties go to fewer shards.
+func PlanLogN(n int, slotsPerRec int, opt PlanOptions) (LogNPlan, error) {
+	if n <= 0 || slotsPerRec <= 0 {
+		return LogNPlan{}, fmt.Errorf("invalid inputs: n=%d, slotsPerRec=%d", n, slotsPerRec)
+	}
+	slotsPerRec = RoundRecordS(slotsPerRec) // windows are SlotAlign-aligned
+	maxShards := opt.MaxShards
+	if maxShards <= 0 {
+		maxShards = 1
+	}
+
+	var best LogNPlan
+	found := false
+	for logN := MinLogN; logN <= MaxLogN; logN++ {
+		perShard := SlotsAt(logN, DefaultT) / slotsPerRec
+		if perShard == 0 {
+			continue
+		}
+		shards := (n + perShard - 1) / perShard
+		if shards > maxShards {
+			continue
+		}
+		cand := LogNPlan{
+			LogN:            logN,
+			Shards:          shards,
+			RecordsPerShard: perShard,
+			EstEvalMS:       float64(shards) * EvalCostMS[logN],
+		}
+		if !found || cand.EstEvalMS < best.EstEvalMS ||
+			(cand.EstEvalMS == best.EstEvalMS && cand.Shards < best.Shards) {
+			best, found = cand, true
+		}
+	}
+
+	// Larger ring only as a single-shard fallback for very large datasets.
+	if large := SlotsAt(LogNLarge, DefaultT); !found && opt.AllowLogN16 && n*slotsPerRec <= large {
+		best = LogNPlan{
+			LogN:            LogNLarge,
+			Shards:          1,
+			RecordsPerShard: large / slotsPerRec,
+			EstEvalMS:       EvalCostMS[LogNLarge],
+		}
+		found = true
+	}
+
+	if !found {
+		return LogNPlan{}, fmt.Errorf("cannot fit DB: requiredSlots=%d exceeds max supported capacity (%d shards × N=%d)",
+			n*slotsPerRec, maxShards, 1<<MaxLogN)
+	}
+	log.Printf("[INFO] PlanLogN: requiredSlots=%d, selected logN=%d (N=%d) shards=%d est_eval=%.2f ms",
+		n*slotsPerRec, best.LogN, best.Slots(), best.Shards, best.EstEvalMS)
+	return best, nil
+}
+
+// CheckCapacity verifies that n records of slotsPerRec slots (rounded up
+// to SlotAlign) fit into shards plaintexts of 2^logSlots slots without
+// straddling shard boundaries. logSlots is LogSlots of the params'
+// MaxSlots, which is below logN when T does not split the whole ring
+// (SlotsAt).
+func CheckCapacity(n, slotsPerRec, logSlots, shards int) error {
+	if shards <= 0 {
+		shards = 1
+	}
+	stride := RoundRecordS(slotsPerRec)
+	perShard := (1 << logSlots) / stride
+	if n > perShard*shards {
+		return fmt.Errorf("capacity exceeded: required=%d (n=%d × s=%d) > %d shard(s) × %d slots; try larger logN, more shards or smaller records",
+			n*stride, n, stride, shards, 1<<logSlots)
+	}
+	return nil
+}
+
+// InitPlan is one dataset layout the advisor can propose: a LogNPlan and
+// the packing mode ("1b": one byte per slot, "2b": two).
+type InitPlan struct {
+	LogNPlan
+	Packing string `json:"packing"`
+}
+
+func (p InitPlan) String() string {
+	return fmt.Sprintf("logN=%d shards=%d packing=%s (%d records/shard, est_eval=%.2f ms)",
+		p.LogN, p.Shards, p.Packing, p.RecordsPerShard, p.EstEvalMS)
+}
+
+// PackedSlots is the window, in slots, of a recordBytes-byte record under
+// packing.
+func PackedSlots(recordBytes int, packing string) int {
+	bps := BytesPerSlot(packing)
+	return RoundRecordS((recordBytes + bps - 1) / bps)
+}
+
+// AdvisePlans lists every layout caps allows that fits n records of
+// recordBytes bytes, cheapest first like PlanLogN (ties: fewer shards, then
+// caps.Packing order). logN=16 is only offered single-shard. PlanLogN's
+// pick is the first "1b" entry; the rest are what to fall back to when the
+// estimate was too small.
+func AdvisePlans(n, recordBytes int, caps Capabilities) []InitPlan {
+	if n <= 0 || recordBytes <= 0 {
+		return nil
+	}
+	maxShards := max(caps.MaxShards, 1)
+	var plans []InitPlan
+	for _, packing := range caps.Packing {
+		if packing != "1b" && !(packing == "2b" && caps.Has(FeatPacking2B)) {
+			continue
+		}
+		stride := PackedSlots(recordBytes, packing)
+		for _, logN := range caps.LogN {
+			cost, ok := EvalCostMS[logN]
+			perShard := SlotsAt(logN, DefaultT) / stride
+			if !ok || perShard == 0 {
+				continue
+			}
+			shards := (n + perShard - 1) / perShard
+			if shards > maxShards || (logN > MaxLogN && shards > 1) {
+				continue
+			}
+			plans = append(plans, InitPlan{
+				LogNPlan: LogNPlan{LogN: logN, Shards: shards, RecordsPerShard: perShard, EstEvalMS: float64(shards) * cost},
+				Packing:  packing,
+			})
+		}
+	}
+	slices.SortStableFunc(plans, func(a, b InitPlan) int {
+		return cmp.Or(cmp.Compare(a.EstEvalMS, b.EstEvalMS), cmp.Compare(a.Shards, b.Shards))
+	})
+	return plans
+}
+
+// IsCapacityError reports whether msg (an error text, possibly relayed by
+// a gateway) is a CheckCapacity or PlanLogN failure.
+func IsCapacityError(msg string) bool {
+	return strings.Contains(msg, "capacity exceeded") || strings.Contains(msg, "cannot fit DB")
+}
+
+// ParseCapacityError extracts the record window s the server actually
+// needed from a CheckCapacity message ("... (n=%d × s=%d) ..."). ok is
+// false for other messages, including PlanLogN's, which carry no s.
+func ParseCapacityError(msg string) (n, s int, ok bool) {
+	i := strings.Index(msg, "(n=")
+	if !strings.Contains(msg, "capacity exceeded") || i < 0 {
+		return 0, 0, false
+	}
+	if _, err := fmt.Sscanf(msg[i:], "(n=%d × s=%d)", &n, &s); err != nil {
+		return 0, 0, false
+	}
+	return n, s, true
+}
+
+// NegotiateInit runs try over the AdvisePlans layouts for n records of
+// recordBytes bytes until one succeeds, and returns that plan. A capacity
+// error that names the server's record window replans with the real
+// record size; plans already tried are never repeated. Any other error
+// stops the negotiation and is returned with the plan that caused it.
+func NegotiateInit(n, recordBytes int, caps Capabilities, try func(InitPlan) error) (InitPlan, error) {
+	plans := AdvisePlans(n, recordBytes, caps)
+	if len(plans) == 0 {
+		return InitPlan{}, fmt.Errorf("cannot fit DB: no layout for n=%d records of %d bytes within logN=%v, %d shard(s)",
+			n, recordBytes, caps.LogN, max(caps.MaxShards, 1))
+	}
+	var tried []InitPlan
+	var lastErr error
+	for len(plans) > 0 {
+		p := plans[0]
+		err := try(p)
+		if err == nil {
+			log.Printf("[INFO] NegotiateInit: %s (attempt %d)", p, len(tried)+1)
+			return p, nil
+		}
+		if !IsCapacityError(err.Error()) {
+			return p, err
+		}
+		log.Printf("[WARN] NegotiateInit: %s rejected: %v", p, err)
+		tried, lastErr = append(tried, p), err
+
+		if _, s, ok := ParseCapacityError(err.Error()); ok {
+			if p.Packing == "2b" {
+				s *= 2
+			}
+			recordBytes = max(recordBytes, s)
+			plans = AdvisePlans(n, recordBytes, caps)
+		}
+		plans = slices.DeleteFunc(plans, func(q InitPlan) bool {
+			return slices.ContainsFunc(tried, func(t InitPlan) bool { return t.LogN == q.LogN && t.Packing == q.Packing })
+		})
+	}
+	return tried[len(tried)-1], fmt.Errorf("no layout fits after %d attempt(s): %w", len(tried), lastErr)
+}
+
+// Heap cost of one ring's worth of work, in polynomials of N × |Q| uint64
+// coefficients, measured with runtime.MemStats on Lattigo v6 (logN 13–16,
+// one Q modulus).
+const (
+	memEncodePolys  = 6  // plaintext + encoder buffers (4.0–5.5 measured)
+	memMarshalPolys = 2  // MarshalBinary for PutState, unmarshal on reload
+	memEvalPolys    = 52 // bgv.NewEvaluator + MulNew, ct_q and ct_r (50–51.5)
+	memBaseline     = 32 << 20
+)
+
+// MemEstimate is the heap a dataset init (and the first query against it)
+// needs, in bytes.
+type MemEstimate struct {
+	Shards    int   `json:"shards"`
+	Records   int64 `json:"records"`   // JSON records + their world-state copies
+	Packed    int64 `json:"packed"`    // packed slot vectors
+	Plaintext int64 `json:"plaintext"` // encoded m_DB shards, marshalled copies
+	Eval      int64 `json:"eval"`      // one ct × pt evaluation
+	Total     int64 `json:"total"`     // the above + runtime/shim baseline
+}
+
+// EstimateInitMemory estimates MemEstimate for n records of recordS slots
+// in rings of degree 2^logN (SlotsAt slots) with levels Q moduli. Shards
+// are as many as the records need (see CheckCapacity).
+func EstimateInitMemory(n, recordS, logN, levels int) MemEstimate {
+	N := int64(1) << logN
+	stride := int64(RoundRecordS(recordS))
+	perShard := max(int64(SlotsAt(logN, DefaultT))/stride, 1)
+	shards := max((int64(n)+perShard-1)/perShard, 1)
+	poly := N * 8 * int64(max(levels, 1))
+
+	e := MemEstimate{
+		Shards:    int(shards),
+		Records:   2 * int64(n) * stride,
+		Packed:    shards * N * 8,
+		Plaintext: shards * poly * (memEncodePolys + memMarshalPolys),
+		Eval:      poly * memEvalPolys,
+	}
+	e.Total = memBaseline + e.Records + e.Packed + e.Plaintext + e.Eval
+	return e
+}
+
+// CheckBudget fails when the estimate exceeds budget bytes (<= 0: no limit).
+func (e MemEstimate) CheckBudget(budget int64) error {
+	if budget <= 0 || e.Total <= budget {
+		return nil
+	}
+	const mib = 1 << 20
+	return fmt.Errorf("estimated memory %d MiB exceeds budget %d MiB (%d shard(s): records %d MiB, packed %d MiB, plaintext %d MiB, eval %d MiB); use a smaller logN, fewer records or raise the budget",
+		(e.Total+mib-1)/mib, budget/mib, e.Shards, e.Records/mib, e.Packed/mib, e.Plaintext/mib, e.Eval/mib)
+}
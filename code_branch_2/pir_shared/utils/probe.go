@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+/********* COMPATIBILITY PROBE ***************************************/
+
+// A client built for another protocol version, HE library or set of
+// params than the server used to find out inside Lattigo: ct_q failed to
+// unmarshal on the peer, or ct_r panicked while decoding on the client.
+// Probe answers in one cheap call what a client must agree with before it
+// builds anything: the protocol version and feature mask (Capabilities)
+// plus a hash of the committed params. Clients call it on connect and stop
+// with CheckProbe's message instead of a stack trace.
+
+// MinProtocolVersion is the oldest server protocol this build still reads.
+const MinProtocolVersion = 1
+
+// Probe is the Probe response: the server's Capabilities and ParamsHash of
+// its committed params.
+type Probe struct {
+	Capabilities
+	// ParamsHash is ParamsHash of the committed params; empty before
+	// InitLedger and from servers that predate Probe.
+	ParamsHash string `json:"params_hash,omitempty"`
+	// Legacy is set when the server has no Probe and the answer was
+	// rebuilt from GetCapabilities.
+	Legacy bool `json:"-"`
+}
+
+// ParamsHash is the hex SHA-256 of the parameters a ciphertext is bound
+// to: logN, logQi, logPi, t and the scheme. Record counts and sizes are
+// left out, so appends and reservations do not change it.
+func ParamsHash(m Metadata) string {
+	raw, _ := json.Marshal(struct {
+		LogN   int    `json:"logN"`
+		LogQi  []int  `json:"logQi"`
+		LogPi  []int  `json:"logPi"`
+		T      uint64 `json:"t"`
+		Scheme string `json:"scheme"`
+	}{m.LogN, m.LogQi, m.LogPi, m.T, m.HEScheme()})
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// NewProbe is the Probe of a server with capabilities c; params is nil
+// before InitLedger.
+func NewProbe(c Capabilities, params *Metadata) Probe {
+	p := Probe{Capabilities: c}
+	if params != nil {
+		p.ParamsHash = ParamsHash(*params)
+	}
+	return p
+}
+
+// ParseProbe decodes a Probe response. A call that failed because the
+// server does not know Probe is treated as a server that predates it: the
+// probe is rebuilt from its GetCapabilities answer (capsRaw, capsErr),
+// with no params hash. Any other callErr is returned.
+func ParseProbe(raw []byte, callErr error, capsRaw []byte, capsErr error) (Probe, error) {
+	if IsUnknownFunction(callErr) {
+		c, err := ParseCapabilities(capsRaw, capsErr)
+		if err != nil {
+			return Probe{}, err
+		}
+		return Probe{Capabilities: c, Legacy: true}, nil
+	}
+	if callErr != nil {
+		return Probe{}, fmt.Errorf("Probe: %w", callErr)
+	}
+	var p Probe
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return Probe{}, fmt.Errorf("parse probe: %w", err)
+	}
+	return p, nil
+}
+
+// Check reports why a client of this build, using HE library he (he.Engine
+// Name; empty skips the check), cannot talk to the server, or nil.
+func (p Probe) Check(he string) error {
+	switch {
+	case p.Version > ProtocolVersion:
+		return fmt.Errorf("incompatible server: it speaks protocol v%d, this client only v%d - upgrade the client",
+			p.Version, ProtocolVersion)
+	case p.Version < MinProtocolVersion && !p.Legacy:
+		return fmt.Errorf("incompatible server: it speaks protocol v%d, this client needs v%d or later - upgrade the chaincode",
+			p.Version, MinProtocolVersion)
+	case p.HE != "" && he != "" && p.HE != he:
+		return fmt.Errorf("incompatible server: it runs HE library %s, this client %s - ct_q would not parse; rebuild the client for %s",
+			p.HE, he, p.HE)
+	}
+	return nil
+}
+
+// CheckParams reports whether meta, e.g. cached metadata or a saved
+// MetaHint, still describes the server's committed params. Servers without
+// a params hash always pass.
+func (p Probe) CheckParams(meta Metadata) error {
+	if p.ParamsHash == "" {
+		return nil
+	}
+	if got := ParamsHash(meta); got != p.ParamsHash {
+		return fmt.Errorf("incompatible params: server hash %.12s, client metadata (logN=%d, scheme=%s) hashes to %.12s - refetch GetMetadata and rebuild keys",
+			p.ParamsHash, meta.LogN, meta.HEScheme(), got)
+	}
+	return nil
+}
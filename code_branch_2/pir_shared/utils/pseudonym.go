@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Contributed records may carry fields naming their source (reporter org,
+// internal ticket IDs). Records are publicly readable (PublicQuery,
+// GetFullDatasetChunk), so a dataset can list such fields for
+// pseudonymization: SanitizeRecord then replaces their values with a keyed
+// HMAC before the record is staged or packed. The mapping is deterministic
+// under one key, so records from the same source still correlate, but
+// without the key a small value space (a few dozen org names) cannot be
+// enumerated back the way a plain hash could.
+
+// PseudonymPrefix marks pseudonymized values.
+const PseudonymPrefix = "ps_"
+
+// pseudonymHexLen is the number of HMAC hex chars kept (96 bits).
+const pseudonymHexLen = 24
+
+// DefaultPseudonymFields are the source-identifying fields a dataset
+// pseudonymizes when it enables the stage without naming fields.
+var DefaultPseudonymFields = []string{"reporter_org", "reporter_id", "internal_id", "source", "submitter"}
+
+// Pseudonym returns the pseudonym of value in field under key. The field
+// name is part of the MAC input, so equal values in different fields do not
+// link.
+func Pseudonym(key []byte, field, value string) string {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(field))
+	m.Write([]byte{0})
+	m.Write([]byte(value))
+	return PseudonymPrefix + hex.EncodeToString(m.Sum(nil))[:pseudonymHexLen]
+}
+
+// CheckPseudonymFields rejects field lists that would pseudonymize the
+// schema's hash fields or the padding, or that name a field twice.
+func CheckPseudonymFields(fields []string) error {
+	seen := map[string]bool{}
+	for _, f := range fields {
+		if _, isHash := HashFieldLens[f]; isHash || f == paddingField || f == "" {
+			return fmt.Errorf("field %q cannot be pseudonymized", f)
+		}
+		if seen[f] {
+			return fmt.Errorf("field %q listed twice", f)
+		}
+		seen[f] = true
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+package utils
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/********* LOAD-TEST REPLAY ********************************************/
+
+// A Trace is the shape of a client session, not its content: which method
+// was called when and how large its ct_q was. Indices, keys and
+// ciphertexts are never recorded, so traces can be shared with whoever
+// plans capacity. Replay re-issues the calls on the recorded schedule
+// (optionally sped up) against the off-chain server or a Fabric peer with
+// fresh queries of the same size, so runs against different targets or
+// hardware yield comparable latency traces.
+
+// TraceVersion is bumped when the Trace JSON changes incompatibly.
+const TraceVersion = 1
+
+// ReplayMethods are the calls a trace can hold; QueryMethods take a
+// Base64 ct_q as their only argument, the others no argument.
+var (
+	ReplayMethods = map[string]bool{
+		"GetCapabilities": true, "Probe": true, "GetMetadata": true, "GetMetadataTimed": true,
+		"PIRQuery": true, "PIRQueryTimed": true, "MetaAndQuery": true,
+	}
+	QueryMethods = map[string]bool{"PIRQuery": true, "PIRQueryTimed": true, "MetaAndQuery": true}
+)
+
+// TraceEvent is one call, AtMS after the session started.
+type TraceEvent struct {
+	AtMS       float64 `json:"at_ms"`
+	Method     string  `json:"method"`
+	QueryBytes int     `json:"query_bytes,omitempty"` // Base64 ct_q length
+}
+
+// Trace is a recorded or synthetic session, in AtMS order.
+type Trace struct {
+	Version int          `json:"version"`
+	Source  string       `json:"source"` // e.g. "recorded", "synthetic seed=1 rate=5/s"
+	Started time.Time    `json:"started"`
+	Events  []TraceEvent `json:"events"`
+}
+
+// TraceRecorder collects a Trace from a live client; Record is safe for
+// concurrent use. Calls outside ReplayMethods are ignored.
+type TraceRecorder struct {
+	mu    sync.Mutex
+	start time.Time
+	trace Trace
+}
+
+// NewTraceRecorder starts a session clock now.
+func NewTraceRecorder() *TraceRecorder {
+	now := time.Now()
+	return &TraceRecorder{start: now, trace: Trace{Version: TraceVersion, Source: "recorded", Started: now.UTC()}}
+}
+
+// Record notes a call of method whose largest argument was argBytes long.
+func (r *TraceRecorder) Record(method string, argBytes int) {
+	if !ReplayMethods[method] {
+		return
+	}
+	ev := TraceEvent{AtMS: float64(time.Since(r.start).Microseconds()) / 1000, Method: method}
+	if QueryMethods[method] {
+		ev.QueryBytes = argBytes
+	}
+	r.mu.Lock()
+	r.trace.Events = append(r.trace.Events, ev)
+	r.mu.Unlock()
+}
+
+// Trace returns a copy of what was recorded so far.
+func (r *TraceRecorder) Trace() Trace {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := r.trace
+	t.Events = append([]TraceEvent(nil), r.trace.Events...)
+	return t
+}
+
+// SyntheticTrace is n calls of method with Poisson arrivals at rate per
+// second, drawn from seed: the same arguments give the same trace.
+func SyntheticTrace(n int, rate float64, seed uint64, method string, queryBytes int) (Trace, error) {
+	if n <= 0 || rate <= 0 {
+		return Trace{}, fmt.Errorf("synthetic trace: n=%d and rate=%g must be positive", n, rate)
+	}
+	if !ReplayMethods[method] {
+		return Trace{}, fmt.Errorf("synthetic trace: method %q cannot be replayed", method)
+	}
+	rng := rand.New(rand.NewPCG(seed, seed))
+	t := Trace{Version: TraceVersion, Source: fmt.Sprintf("synthetic seed=%d rate=%g/s", seed, rate)}
+	at := 0.0
+	for i := 0; i < n; i++ {
+		ev := TraceEvent{AtMS: math.Round(at*1000) / 1000, Method: method}
+		if QueryMethods[method] {
+			ev.QueryBytes = queryBytes
+		}
+		t.Events = append(t.Events, ev)
+		at += rng.ExpFloat64() / rate * 1000
+	}
+	return t, nil
+}
+
+// SaveTrace writes t as indented JSON.
+func SaveTrace(path string, t Trace) error {
+	b, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+// LoadTrace reads a trace and checks its version and event order.
+func LoadTrace(path string) (Trace, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Trace{}, err
+	}
+	var t Trace
+	if err := json.Unmarshal(b, &t); err != nil {
+		return Trace{}, fmt.Errorf("parse trace %s: %w", path, err)
+	}
+	if t.Version != TraceVersion {
+		return Trace{}, fmt.Errorf("trace %s: version %d, want %d", path, t.Version, TraceVersion)
+	}
+	for i, ev := range t.Events {
+		if i > 0 && ev.AtMS < t.Events[i-1].AtMS {
+			return Trace{}, fmt.Errorf("trace %s: event %d at %.3f ms is before event %d", path, i, ev.AtMS, i-1)
+		}
+	}
+	return t, nil
+}
+
+// ReplayOptions controls Replay. Speedup divides every AtMS (<= 0: 1);
+// MaxInflight bounds concurrent calls (<= 0: 64), and an event due while
+// the bound is reached starts late, which shows up as its lag.
+type ReplayOptions struct {
+	Speedup     float64
+	MaxInflight int
+}
+
+// ReplayResult is one replayed call. ScheduledMS is when it was due after
+// the replay started, LagMS how late it actually started.
+type ReplayResult struct {
+	Seq         int
+	Method      string
+	QueryBytes  int
+	ScheduledMS float64
+	LagMS       float64
+	LatencyMS   float64
+	Err         error
+}
+
+// Replay issues the events of t on their (sped-up) schedule, open loop:
+// a slow call does not delay the next one unless MaxInflight is reached.
+// do performs one call. Results are returned in event order.
+func Replay(t Trace, opt ReplayOptions, do func(ev TraceEvent) error) []ReplayResult {
+	if opt.Speedup <= 0 {
+		opt.Speedup = 1
+	}
+	if opt.MaxInflight <= 0 {
+		opt.MaxInflight = 64
+	}
+	results := make([]ReplayResult, len(t.Events))
+	slots := make(chan struct{}, opt.MaxInflight)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i, ev := range t.Events {
+		due := time.Duration(ev.AtMS / opt.Speedup * float64(time.Millisecond))
+		time.Sleep(time.Until(start.Add(due)))
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, ev TraceEvent) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			began := time.Now()
+			err := do(ev)
+			results[i] = ReplayResult{
+				Seq:         i,
+				Method:      ev.Method,
+				QueryBytes:  ev.QueryBytes,
+				ScheduledMS: float64(due.Microseconds()) / 1000,
+				LagMS:       float64(began.Sub(start.Add(due)).Microseconds()) / 1000,
+				LatencyMS:   float64(time.Since(began).Microseconds()) / 1000,
+				Err:         err,
+			}
+		}(i, ev)
+	}
+	wg.Wait()
+	return results
+}
+
+// WriteReplayCSV writes one row per result:
+// seq,method,query_bytes,scheduled_ms,lag_ms,latency_ms,ok,error.
+func WriteReplayCSV(path string, results []ReplayResult) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	_ = w.Write([]string{"seq", "method", "query_bytes", "scheduled_ms", "lag_ms", "latency_ms", "ok", "error"})
+	for _, r := range results {
+		msg := ""
+		if r.Err != nil {
+			msg = r.Err.Error()
+		}
+		_ = w.Write([]string{
+			strconv.Itoa(r.Seq), r.Method, strconv.Itoa(r.QueryBytes),
+			fmt.Sprintf("%.3f", r.ScheduledMS), fmt.Sprintf("%.3f", r.LagMS), fmt.Sprintf("%.3f", r.LatencyMS),
+			strconv.FormatBool(r.Err == nil), msg,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// ReplaySummary condenses a replay: latency quantiles of the successful
+// calls, the worst start lag and the call rate achieved.
+type ReplaySummary struct {
+	Calls, Errors int
+	P50, P90, P99 float64
+	MaxMS, MaxLag float64
+	RatePerSec    float64
+}
+
+// Summarize computes the ReplaySummary of results.
+func Summarize(results []ReplayResult) ReplaySummary {
+	s := ReplaySummary{Calls: len(results)}
+	var lat []float64
+	var span float64
+	for _, r := range results {
+		if r.Err != nil {
+			s.Errors++
+		} else {
+			lat = append(lat, r.LatencyMS)
+		}
+		s.MaxLag = math.Max(s.MaxLag, r.LagMS)
+		span = math.Max(span, r.ScheduledMS+r.LagMS)
+	}
+	if len(lat) > 0 {
+		sort.Float64s(lat)
+		s.P50, s.P90, s.P99 = quantile(lat, 0.50), quantile(lat, 0.90), quantile(lat, 0.99)
+		s.MaxMS = lat[len(lat)-1]
+	}
+	if span > 0 {
+		s.RatePerSec = float64(len(results)) / span * 1000
+	}
+	return s
+}
+
+func (s ReplaySummary) String() string {
+	return fmt.Sprintf("calls=%d errors=%d latency p50=%.1fms p90=%.1fms p99=%.1fms max=%.1fms | max lag=%.1fms rate=%.2f/s",
+		s.Calls, s.Errors, s.P50, s.P90, s.P99, s.MaxMS, s.MaxLag, s.RatePerSec)
+}
+
+// ReplayCommand is the flag set shared by the replay commands of both
+// clients (pirctl replay, off_chain_pir_client/cmd/replay), so a trace
+// means the same thing against either target.
+type ReplayCommand struct {
+	TracePath string
+	Synth     int
+	Rate      float64
+	Seed      uint64
+	Method    string
+	SavePath  string
+	OutPath   string
+	Options   ReplayOptions
+}
+
+// Register adds the replay flags to fs; out is the default CSV path.
+func (c *ReplayCommand) Register(fs *flag.FlagSet, out string) {
+	fs.StringVar(&c.TracePath, "trace", "", "trace JSON to replay (see -synth to generate one)")
+	fs.IntVar(&c.Synth, "synth", 0, "without -trace: replay n synthetic calls with Poisson arrivals")
+	fs.Float64Var(&c.Rate, "rate", 1, "-synth arrival rate per second")
+	fs.Uint64Var(&c.Seed, "seed", 1, "-synth PRNG seed")
+	fs.StringVar(&c.Method, "method", "PIRQuery", "-synth method")
+	fs.StringVar(&c.SavePath, "save", "", "also write the trace replayed to this file")
+	fs.StringVar(&c.OutPath, "out", out, "latency trace CSV")
+	fs.Float64Var(&c.Options.Speedup, "speedup", 1, "divide recorded inter-arrival times by this factor")
+	fs.IntVar(&c.Options.MaxInflight, "max-inflight", 64, "concurrent calls at most")
+}
+
+// Trace loads -trace or generates the -synth trace, whose queries are
+// queryBytes long (the size the target's params give).
+func (c *ReplayCommand) Trace(queryBytes int) (Trace, error) {
+	var t Trace
+	var err error
+	switch {
+	case c.TracePath != "":
+		t, err = LoadTrace(c.TracePath)
+	case c.Synth > 0:
+		t, err = SyntheticTrace(c.Synth, c.Rate, c.Seed, c.Method, queryBytes)
+	default:
+		err = fmt.Errorf("replay: give -trace or -synth")
+	}
+	if err != nil {
+		return Trace{}, err
+	}
+	for i, ev := range t.Events {
+		if !ReplayMethods[ev.Method] {
+			return Trace{}, fmt.Errorf("replay: event %d: method %q cannot be replayed", i, ev.Method)
+		}
+	}
+	if c.SavePath != "" {
+		if err := SaveTrace(c.SavePath, t); err != nil {
+			return Trace{}, err
+		}
+	}
+	return t, nil
+}
+
+// Run replays t with call (method and its arguments) and writes the CSV.
+// ct_q is the one query every QueryMethods event sends; events recorded
+// with another size are still replayed and counted in the returned
+// warning, since the target's params decide the size.
+func (c *ReplayCommand) Run(t Trace, ctQ string, call func(method string, args ...string) error) (ReplaySummary, string, error) {
+	mismatch := 0
+	for _, ev := range t.Events {
+		if QueryMethods[ev.Method] && ev.QueryBytes != len(ctQ) {
+			mismatch++
+		}
+	}
+	results := Replay(t, c.Options, func(ev TraceEvent) error {
+		if QueryMethods[ev.Method] {
+			return call(ev.Method, ctQ)
+		}
+		return call(ev.Method)
+	})
+	if err := WriteReplayCSV(c.OutPath, results); err != nil {
+		return ReplaySummary{}, "", err
+	}
+	warn := ""
+	if mismatch > 0 {
+		warn = fmt.Sprintf("%d events were recorded with a ct_q size other than this target's %d bytes", mismatch, len(ctQ))
+	}
+	return Summarize(results), warn, nil
+}
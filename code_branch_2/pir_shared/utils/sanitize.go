@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SanitizeOptions configures the normalization applied to imported CTI
+// records before they are staged and packed. Zero values disable a step.
+type SanitizeOptions struct {
+	LowercaseHashes bool // lower-case HashFieldLens fields
+	ValidateHashes  bool // HashFieldLens fields must be hex of the listed length
+	StripControl    bool // drop control characters from string fields
+	MaxFieldLen     int  // clamp string fields to this many runes (padding exempt)
+	MaxRecordLen    int  // reject records longer than this after normalization
+
+	// PseudonymFields are replaced by Pseudonym(PseudonymKey, field, value);
+	// numbers are pseudonymized by their literal. Empty disables the step.
+	PseudonymFields []string
+	PseudonymKey    []byte
+}
+
+// DefaultSanitize is the pipeline the chaincode applies unless configured
+// otherwise. MaxRecordLen is set per dataset (maxJSON).
+var DefaultSanitize = SanitizeOptions{
+	LowercaseHashes: true,
+	ValidateHashes:  true,
+	StripControl:    true,
+	MaxFieldLen:     128,
+}
+
+// HashFieldLens are the hash fields of the gen_records schemas and their
+// hex lengths.
+var HashFieldLens = map[string]int{
+	"md5":          32,
+	"sha1":         40,
+	"sha256":       64,
+	"sha256_short": 16,
+}
+
+// paddingField is the generator's size filler; it is never clamped.
+const paddingField = "padding"
+
+// Rejection explains why an imported record was dropped. Index is the
+// record's position in the submitted batch.
+type Rejection struct {
+	Index  int    `json:"index"`
+	Reason string `json:"reason"`
+}
+
+// SanitizeRecord normalizes one JSON object record. Keys are re-emitted in
+// sorted order, without HTML escaping.
+func SanitizeRecord(rec []byte, opt SanitizeOptions) ([]byte, error) {
+	if !utf8.Valid(rec) {
+		return nil, fmt.Errorf("record is not valid UTF-8")
+	}
+	var obj map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(rec))
+	dec.UseNumber() // keep numbers byte-identical
+	if err := dec.Decode(&obj); err != nil || obj == nil || dec.More() {
+		return nil, fmt.Errorf("record is not a JSON object")
+	}
+
+	if len(opt.PseudonymFields) > 0 && len(opt.PseudonymKey) == 0 {
+		return nil, fmt.Errorf("pseudonymization enabled without a key")
+	}
+	for _, f := range opt.PseudonymFields {
+		switch v := obj[f].(type) {
+		case string:
+			obj[f] = Pseudonym(opt.PseudonymKey, f, v)
+		case json.Number:
+			obj[f] = Pseudonym(opt.PseudonymKey, f, v.String())
+		case nil:
+		default:
+			return nil, fmt.Errorf("field %q: only strings and numbers can be pseudonymized", f)
+		}
+	}
+
+	for k, v := range obj {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if opt.StripControl {
+			s = strings.Map(func(r rune) rune {
+				if unicode.IsControl(r) {
+					return -1
+				}
+				return r
+			}, s)
+		}
+		if want, isHash := HashFieldLens[k]; isHash {
+			if opt.LowercaseHashes {
+				s = strings.ToLower(strings.TrimSpace(s))
+			}
+			if opt.ValidateHashes {
+				if _, err := hex.DecodeString(s); err != nil || len(s) != want {
+					return nil, fmt.Errorf("field %q: want %d hex chars, got %q", k, want, s)
+				}
+			}
+		} else if opt.MaxFieldLen > 0 && k != paddingField && utf8.RuneCountInString(s) > opt.MaxFieldLen {
+			s = string([]rune(s)[:opt.MaxFieldLen])
+		}
+		obj[k] = s
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(obj); err != nil {
+		return nil, err
+	}
+	out := bytes.TrimRight(buf.Bytes(), "\n")
+	if opt.MaxRecordLen > 0 && len(out) > opt.MaxRecordLen {
+		return nil, fmt.Errorf("record is %d bytes after normalization, limit %d", len(out), opt.MaxRecordLen)
+	}
+	return out, nil
+}
+
+// SanitizeRecords runs SanitizeRecord over a batch, keeping the accepted
+// records in order and reporting the rest.
+func SanitizeRecords(recs [][]byte, opt SanitizeOptions) ([][]byte, []Rejection) {
+	var clean [][]byte
+	var rejected []Rejection
+	for i, rec := range recs {
+		out, err := SanitizeRecord(rec, opt)
+		if err != nil {
+			rejected = append(rejected, Rejection{Index: i, Reason: err.Error()})
+			continue
+		}
+		clean = append(clean, out)
+	}
+	return clean, rejected
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+/********* HE SCHEMES **********************************************/
+
+// The PIR evaluation is one ct × pt product per shard, which every RLWE
+// scheme offers, so a dataset can be built under BGV (the default), BFV
+// or CKKS on the same ring to compare their behaviour. BGV and BFV work
+// modulo the plaintext modulus T and return the records exactly; CKKS
+// encodes the bytes as approximate reals in N/2 slots and the client
+// rounds them back, trading half the slots and some noise margin for
+// scale-based rather than T-based arithmetic.
+
+// HE schemes a dataset can be built under.
+const (
+	SchemeBGV  = "bgv"
+	SchemeBFV  = "bfv"
+	SchemeCKKS = "ckks"
+)
+
+// Schemes lists the HE schemes in the order they were added.
+var Schemes = []string{SchemeBGV, SchemeBFV, SchemeCKKS}
+
+// ParseScheme returns the canonical name of scheme; empty is SchemeBGV.
+func ParseScheme(scheme string) (string, error) {
+	s := strings.ToLower(strings.TrimSpace(scheme))
+	if s == "" {
+		return SchemeBGV, nil
+	}
+	for _, known := range Schemes {
+		if s == known {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("unknown HE scheme %q (want one of %s)", scheme, strings.Join(Schemes, ", "))
+}
+
+// HEScheme is m.Scheme with the empty default resolved to SchemeBGV.
+func (m Metadata) HEScheme() string {
+	if m.Scheme == "" {
+		return SchemeBGV
+	}
+	return m.Scheme
+}
+
+// Slots is the number of plaintext slots of m's params: SlotCount as the
+// server published it, else N/2 under CKKS, whose slots hold complex
+// values, and SlotsAt(LogN, T) under BGV and BFV.
+func (m Metadata) Slots() int {
+	if m.SlotCount > 0 {
+		return m.SlotCount
+	}
+	if m.HEScheme() == SchemeCKKS {
+		return m.N / 2
+	}
+	if m.T == 0 {
+		return m.N
+	}
+	return min(m.N, SlotsAt(m.LogN, m.T))
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	crand "crypto/rand"
+	"crypto/sha256"
+	"io"
+	"math/rand/v2"
+	"sync"
+)
+
+/********* REPRODUCIBLE RANDOMNESS (RESEARCH ONLY) *******************/
+
+// Lattigo keys every PRNG it creates (key generation, the encryptor's
+// uniform, ternary and error samplers, mask pools) from crypto/rand.
+// SeedRandomness points crypto/rand.Reader at a ChaCha8 stream keyed by a
+// caller seed, so that keys, queries and therefore noise growth are
+// identical across machines and runs.
+//
+// RESEARCH ONLY: everything encrypted while a seed is installed is
+// predictable by anyone who knows the seed, and every other crypto/rand
+// user in the process (gateway TLS, audit nonces) draws from the same
+// stream. Runs are reproducible only when the encryption calls happen in
+// the same order, i.e. from one goroutine and without a mask pool.
+
+// ResearchSeedEnv names the environment variable the clients and benches
+// read a research seed from; unset means real randomness.
+const ResearchSeedEnv = "PIR_RESEARCH_SEED"
+
+// seededReader serializes reads from a ChaCha8 stream.
+type seededReader struct {
+	mu sync.Mutex
+	c  *rand.ChaCha8
+}
+
+func (r *seededReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.c.Read(p)
+}
+
+// NewSeededReader returns a deterministic io.Reader keyed by SHA-256(seed).
+func NewSeededReader(seed []byte) io.Reader {
+	return &seededReader{c: rand.NewChaCha8(sha256.Sum256(seed))}
+}
+
+// SeedRandomness installs r as crypto/rand.Reader and returns a function
+// that puts the previous reader back. See NewSeededReader for r.
+func SeedRandomness(r io.Reader) (restore func()) {
+	prev := crand.Reader
+	crand.Reader = r
+	return func() { crand.Reader = prev }
+}
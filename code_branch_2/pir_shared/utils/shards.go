@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+/********* SHARDED m_DB ********************************************/
+
+// A dataset larger than one plaintext is packed into Shards() plaintexts
+// of Slots each. Records never straddle two: shard k holds records
+// [k*perShard, (k+1)*perShard) with perShard = Slots/Stride(), at the
+// shard-relative windows Describe returns. A query is one selector over
+// the target's shard-relative window; the server multiplies it with every
+// shard and returns one result ciphertext per shard, of which the client
+// decrypts the one Describe names. With one shard everything reduces to
+// Validate, Pack, Unpack and a single result.
+
+// Shards is the number of plaintexts the records span (1 when Slots is
+// unset or no window fits).
+func (c IndexContract) Shards() int {
+	stride := c.Stride()
+	if c.Slots <= 0 || stride > c.Slots {
+		return 1
+	}
+	perShard := c.Slots / stride
+	return max((c.NRecords+perShard-1)/perShard, 1)
+}
+
+// ValidateSharded is Validate for a layout spread over Shards()
+// plaintexts: every index needs a full window inside its shard.
+func (c IndexContract) ValidateSharded() error {
+	if c.NRecords <= 0 || c.RecordS <= 0 {
+		return fmt.Errorf("index contract: n=%d and record_s=%d must be positive", c.NRecords, c.RecordS)
+	}
+	if c.ReservedFrom < 0 || (c.ReservedFrom > 0 && c.ReservedFrom >= c.NRecords) {
+		return fmt.Errorf("index contract: reserved_from=%d must be in 1..%d", c.ReservedFrom, c.NRecords-1)
+	}
+	if _, err := ParsePacking(c.Packing); err != nil {
+		return fmt.Errorf("index contract: %w", err)
+	}
+	if c.Slots < 0 || c.Slots%SlotAlign != 0 {
+		return fmt.Errorf("index contract: N=%d is not a multiple of %d", c.Slots, SlotAlign)
+	}
+	if c.Slots > 0 && c.Stride() > c.Slots {
+		return fmt.Errorf("index contract: record_s=%d (window %d) exceeds N=%d", c.RecordS, c.Stride(), c.Slots)
+	}
+	return nil
+}
+
+// PackShards is Pack over Shards() plaintexts: record i is laid out in
+// the window Describe(i) gives, in shard Describe(i).Shard. With one shard
+// the result is {Pack(records)}.
+func (c IndexContract) PackShards(records [][]byte) ([][]uint64, error) {
+	if c.Slots <= 0 {
+		return nil, fmt.Errorf("index contract: Slots must be set to pack")
+	}
+	if err := c.ValidateSharded(); err != nil {
+		return nil, err
+	}
+	if len(records) != c.Live() {
+		return nil, fmt.Errorf("index contract: %d records, n=%d (%d live)", len(records), c.NRecords, c.Live())
+	}
+	shards := make([][]uint64, c.Shards())
+	for k := range shards {
+		shards[k] = make([]uint64, c.Slots)
+	}
+	for i, rec := range records {
+		w, err := c.Describe(i)
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) > c.RecordBytes() {
+			return nil, fmt.Errorf("index contract: record %d is %d bytes, record_s=%d (%s)", i, len(rec), c.RecordS, c.packingMode())
+		}
+		c.PackWindow(shards[w.Shard][w.StartSlot:], rec)
+	}
+	return shards, nil
+}
+
+// UnpackShards is the inverse of PackShards for records [offset,
+// offset+count), like Unpack.
+func (c IndexContract) UnpackShards(shards [][]uint64, offset, count int) ([][]byte, error) {
+	if count < 0 || offset < 0 || offset+count > c.NRecords {
+		return nil, fmt.Errorf("index contract: records [%d:%d) out of range 0..%d", offset, offset+count, c.NRecords)
+	}
+	out := make([][]byte, count)
+	for k := range out {
+		w, err := c.Describe(offset + k)
+		if err != nil {
+			return nil, err
+		}
+		if w.Shard >= len(shards) || w.EndSlot > len(shards[w.Shard]) {
+			return nil, fmt.Errorf("index contract: record %d at shard %d [%d:%d) is outside the %d shard(s)",
+				offset+k, w.Shard, w.StartSlot, w.EndSlot, len(shards))
+		}
+		out[k] = c.UnpackWindow(shards[w.Shard][w.StartSlot:w.EndSlot])
+	}
+	return out, nil
+}
+
+// EncodeShardResults is the PIRQuery response for the Base64 result
+// ciphertexts of each shard, in shard order: the bare Base64 for a single
+// shard, so single-plaintext clients see no change, else a JSON array.
+func EncodeShardResults(results []string) string {
+	if len(results) == 1 {
+		return results[0]
+	}
+	out, _ := json.Marshal(results)
+	return string(out)
+}
+
+// DecodeShardResults is the inverse of EncodeShardResults.
+func DecodeShardResults(resp string) ([]string, error) {
+	resp = strings.TrimSpace(resp)
+	if !strings.HasPrefix(resp, "[") {
+		return []string{resp}, nil
+	}
+	var results []string
+	if err := json.Unmarshal([]byte(resp), &results); err != nil {
+		return nil, fmt.Errorf("parse shard results: %w", err)
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("parse shard results: no result ciphertext")
+	}
+	return results, nil
+}
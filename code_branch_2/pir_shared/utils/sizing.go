@@ -0,0 +1,245 @@
+package utils
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+)
+
+/********* PEER SIZING ********************************************/
+
+// A PIR evaluation runs on one core of one endorsing peer (MeasureEval
+// pins it to a thread), so the peers of co-located channels behave as one
+// pool of vCPUs serving every channel's queries. SizePeers treats that
+// pool as an M/M/c queue (Erlang C) with the rate-weighted mean service
+// time, and returns the fewest vCPUs whose wait at the given quantile
+// keeps every channel's latency (its own eval cost plus the wait) within
+// the SLO.
+
+// ChannelLoad is one channel's expected query traffic.
+type ChannelLoad struct {
+	Name       string  `json:"name"`
+	LogN       int     `json:"logN"`
+	RatePerSec float64 `json:"rate_per_sec"`
+	// CostMS is the CPU time of one query on one core; 0 takes it from
+	// the Calibration passed to SizePeers.
+	CostMS float64 `json:"cost_ms,omitempty"`
+}
+
+// SizingOptions is the SLO and the peer shape.
+type SizingOptions struct {
+	SLOMS        float64 // latency target per query, ms
+	Quantile     float64 // the SLO holds for this fraction of queries (0: 0.99)
+	VCPUsPerPeer int     // cores an endorsing peer gives PIR evaluation (0: 1)
+	MaxUtil      float64 // highest acceptable pool utilisation (0: 0.7)
+	MinPeers     int     // lower bound, e.g. for availability (0: 1)
+}
+
+// ChannelSizing is one channel's figures under a SizingPlan.
+type ChannelSizing struct {
+	ChannelLoad
+	CostSource string  `json:"cost_source"`
+	Erlangs    float64 `json:"erlangs"`    // RatePerSec × CostMS / 1000
+	LatencyMS  float64 `json:"latency_ms"` // at the plan's quantile
+}
+
+// SizingPlan is SizePeers' recommendation.
+type SizingPlan struct {
+	VCPUs       int             `json:"vcpus"`
+	Peers       int             `json:"peers"`
+	Utilization float64         `json:"utilization"`
+	WaitProb    float64         `json:"wait_prob"` // Erlang C: a query queues at all
+	WaitMS      float64         `json:"wait_ms"`   // queueing delay at the quantile
+	Quantile    float64         `json:"quantile"`
+	Channels    []ChannelSizing `json:"channels"`
+}
+
+// maxSizingVCPUs bounds the search; loads that need more are reported as
+// infeasible rather than planned.
+const maxSizingVCPUs = 4096
+
+// SizePeers recommends the vCPUs and endorsing peers that serve loads
+// within opt's SLO, taking per-query costs the loads leave at 0 from cal.
+func SizePeers(loads []ChannelLoad, cal Calibration, opt SizingOptions) (SizingPlan, error) {
+	if opt.SLOMS <= 0 {
+		return SizingPlan{}, fmt.Errorf("SLO must be positive, got %.1f ms", opt.SLOMS)
+	}
+	q := cmpOr(opt.Quantile, 0.99)
+	maxUtil := cmpOr(opt.MaxUtil, 0.7)
+	perPeer := max(opt.VCPUsPerPeer, 1)
+
+	plan := SizingPlan{Quantile: q}
+	var rate, erlangs float64
+	for _, l := range loads {
+		cs := ChannelSizing{ChannelLoad: l, CostSource: "given"}
+		if cs.CostMS <= 0 {
+			cs.CostMS, cs.CostSource = cal.CostMS(l.Name, l.LogN)
+		}
+		if cs.CostMS <= 0 {
+			return SizingPlan{}, fmt.Errorf("channel %s: no eval cost for logN=%d", l.Name, l.LogN)
+		}
+		if cs.CostMS > opt.SLOMS {
+			return SizingPlan{}, fmt.Errorf("channel %s: one evaluation takes %.1f ms, above the %.1f ms SLO whatever the peer count; use a smaller logN or shard the dataset",
+				l.Name, cs.CostMS, opt.SLOMS)
+		}
+		cs.Erlangs = l.RatePerSec * cs.CostMS / 1000
+		rate += l.RatePerSec
+		erlangs += cs.Erlangs
+		plan.Channels = append(plan.Channels, cs)
+	}
+	if rate <= 0 {
+		return SizingPlan{}, fmt.Errorf("no query traffic to size for")
+	}
+	meanMS := 1000 * erlangs / rate
+
+	for c := max(int(math.Ceil(erlangs/maxUtil)), 1); c <= maxSizingVCPUs; c++ {
+		if erlangs/float64(c) > maxUtil {
+			continue
+		}
+		pw := erlangC(c, erlangs)
+		wait := 0.0
+		if pw > 1-q {
+			// P(W > t) = pw · e^{-(c - a)t/mean}
+			wait = meanMS * math.Log(pw/(1-q)) / (float64(c) - erlangs)
+		}
+		fits := true
+		for _, cs := range plan.Channels {
+			fits = fits && cs.CostMS+wait <= opt.SLOMS
+		}
+		if !fits {
+			continue
+		}
+		plan.VCPUs, plan.WaitProb, plan.WaitMS = c, pw, wait
+		plan.Utilization = erlangs / float64(c)
+		plan.Peers = max((c+perPeer-1)/perPeer, opt.MinPeers, 1)
+		for i := range plan.Channels {
+			plan.Channels[i].LatencyMS = plan.Channels[i].CostMS + wait
+		}
+		return plan, nil
+	}
+	return SizingPlan{}, fmt.Errorf("no pool of up to %d vCPUs meets a %.1f ms SLO at %.1f Erlangs", maxSizingVCPUs, opt.SLOMS, erlangs)
+}
+
+// erlangC is the probability that a query waits in an M/M/c queue with
+// c servers and a Erlangs of offered load (a < c).
+func erlangC(c int, a float64) float64 {
+	b := 1.0 // Erlang B, by the recurrence B(k) = a·B(k-1) / (k + a·B(k-1))
+	for k := 1; k <= c; k++ {
+		b = a * b / (float64(k) + a*b)
+	}
+	return float64(c) * b / (float64(c) - a*(1-b))
+}
+
+func cmpOr(v, def float64) float64 {
+	if v > 0 {
+		return v
+	}
+	return def
+}
+
+// Calibration is measured per-query eval cost, by channel name (the
+// benches' "friendly" mini / mid / rich) and by logN. EvalCostMS is the
+// fallback for a logN nothing was measured for.
+type Calibration struct {
+	ByChannel map[string]float64 `json:"by_channel"`
+	ByLogN    map[int]float64    `json:"by_logN"`
+}
+
+// CostMS returns the cost for channel name at logN and where it came from.
+func (c Calibration) CostMS(name string, logN int) (float64, string) {
+	if v, ok := c.ByChannel[name]; ok {
+		return v, "channel " + name
+	}
+	if v, ok := c.ByLogN[logN]; ok {
+		return v, fmt.Sprintf("logN=%d", logN)
+	}
+	return EvalCostMS[logN], "EvalCostMS"
+}
+
+func (c *Calibration) set(name string, logN int, ms float64) {
+	if ms <= 0 {
+		return
+	}
+	if name != "" {
+		if c.ByChannel == nil {
+			c.ByChannel = map[string]float64{}
+		}
+		c.ByChannel[name] = ms
+	}
+	if logN > 0 {
+		if c.ByLogN == nil {
+			c.ByLogN = map[int]float64{}
+		}
+		c.ByLogN[logN] = ms
+	}
+}
+
+// LoadCSV reads one of the calibration CSVs the benches write: the
+// tx_costs projection (friendly, ms_per_tx; logN is the channel prefix),
+// or the chaincode "bench" output (logN, step, ns_op; the pirquery step,
+// else mul). Later rows override earlier ones.
+func (c *Calibration) LoadCSV(r io.Reader) error {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("empty calibration CSV")
+	}
+	col := map[string]int{}
+	for i, h := range rows[0] {
+		col[h] = i
+	}
+	get := func(row []string, name string) string {
+		if i, ok := col[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	switch {
+	case hasCols(col, "friendly", "ms_per_tx"):
+		for _, row := range rows[1:] {
+			ms, _ := strconv.ParseFloat(get(row, "ms_per_tx"), 64)
+			var logN int
+			fmt.Sscanf(get(row, "channel"), "%d_", &logN)
+			c.set(get(row, "friendly"), logN, ms)
+		}
+	case hasCols(col, "logN", "step", "ns_op"):
+		for _, step := range []string{"mul", "pirquery"} { // pirquery wins where both exist
+			for _, row := range rows[1:] {
+				if get(row, "step") != step {
+					continue
+				}
+				logN, _ := strconv.Atoi(get(row, "logN"))
+				ns, _ := strconv.ParseFloat(get(row, "ns_op"), 64)
+				c.set("", logN, ns/1e6)
+			}
+		}
+	default:
+		return fmt.Errorf("unrecognised calibration CSV header %v", rows[0])
+	}
+	return nil
+}
+
+func hasCols(col map[string]int, names ...string) bool {
+	return !slices.ContainsFunc(names, func(n string) bool { _, ok := col[n]; return !ok })
+}
+
+// LoadPerfStats takes per-logN costs from a GetPerfStats response: the
+// mean of every single-shard parameter set with queries.
+func (c *Calibration) LoadPerfStats(raw []byte) error {
+	var snaps []PerfSnapshot
+	if err := json.Unmarshal(raw, &snaps); err != nil {
+		return fmt.Errorf("parse GetPerfStats: %w", err)
+	}
+	for _, s := range snaps {
+		if s.Queries > 0 && s.Shards <= 1 {
+			c.set("", s.LogN, s.MeanMS)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+//go:build !lattigo_v5
+
+package utils
+
+import "testing"
+
+// TestSlotsAtMatchesParams checks SlotsAt against Lattigo's MaxSlots, which
+// the servers pack with: every supported logN under the default t, and
+// logN=16 under a t that splits the whole ring.
+func TestSlotsAtMatchesParams(t *testing.T) {
+	cases := []struct {
+		logN int
+		t    uint64
+	}{
+		{13, DefaultT}, {14, DefaultT}, {15, DefaultT}, {16, DefaultT},
+		{16, 786433}, // 3·2^18 + 1
+	}
+	for _, c := range cases {
+		p, err := BuildParamsFromHint(BGVParamHint{LogN: c.logN, T: c.t, LogQi: DefaultLogQi, LogPi: DefaultLogPi})
+		if err != nil {
+			t.Fatalf("logN=%d t=%d: %v", c.logN, c.t, err)
+		}
+		if got, want := SlotsAt(c.logN, c.t), p.MaxSlots(); got != want {
+			t.Errorf("SlotsAt(%d, %d) = %d, params have %d slots", c.logN, c.t, got, want)
+		}
+	}
+}
+
+// TestPlanLogN16Slots checks the logN=16 fallback counts the 2^15 slots
+// of the default t, not N=2^16: it then holds no more than logN=15, so
+// whatever logN=15 cannot fit is refused rather than planned at 16.
+func TestPlanLogN16Slots(t *testing.T) {
+	const s = 128
+	opt := PlanOptions{MaxShards: 1, AllowLogN16: true}
+	full := SlotsAt(LogNLarge, DefaultT) / s
+	plan, err := PlanLogN(full, s, opt)
+	if err != nil {
+		t.Fatalf("PlanLogN(%d): %v", full, err)
+	}
+	if err := CheckCapacity(full, s, LogSlots(plan.Slots()), plan.Shards); err != nil {
+		t.Errorf("plan %+v fails CheckCapacity: %v", plan, err)
+	}
+	if plan, err := PlanLogN(full+1, s, opt); err == nil {
+		t.Errorf("PlanLogN(%d) = %+v, but no ring holds more than %d records of %d slots", full+1, plan, full, s)
+	}
+}
+
+func TestMetadataSlots(t *testing.T) {
+	cases := []struct {
+		name string
+		m    Metadata
+		want int
+	}{
+		{"published", Metadata{LogN: 16, N: 1 << 16, T: DefaultT, SlotCount: 1 << 15}, 1 << 15},
+		{"bgv logN=16, older server", Metadata{LogN: 16, N: 1 << 16, T: DefaultT}, 1 << 15},
+		{"bgv logN=13", Metadata{LogN: 13, N: 1 << 13, T: DefaultT}, 1 << 13},
+		{"ckks", Metadata{LogN: 13, N: 1 << 13, Scheme: SchemeCKKS}, 1 << 12},
+	}
+	for _, c := range cases {
+		if got := c.m.Slots(); got != c.want {
+			t.Errorf("%s: Slots() = %d, want %d", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package utils
+
+import (
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"sort"
+	"sync"
+	"time"
+)
+
+/********* RESOURCE ACCOUNTING ************************************/
+
+// EvalUsage is the resource cost of one PIR evaluation.
+type EvalUsage struct {
+	WallMS     float64 `json:"wall_ms"`
+	CPUMS      float64 `json:"cpu_ms"`      // evaluating thread; -1 where unsupported
+	AllocBytes uint64  `json:"alloc_bytes"` // heap allocated while evaluating (process-wide)
+	Allocs     uint64  `json:"allocs"`      // heap objects allocated (process-wide)
+	// QueueMS is how long the evaluation waited for a worker before
+	// WallMS started (the chaincode's shard scheduler; 0 elsewhere).
+	QueueMS float64 `json:"queue_ms,omitempty"`
+
+	// Set by the servers' PIR queries so a bench can split WallMS between
+	// ring size and shard count: the parameter set, the query size and one
+	// entry per plaintext shard, in shard order.
+	LogN       int          `json:"logN,omitempty"`
+	QueryBytes int          `json:"query_bytes,omitempty"`
+	Shards     []ShardUsage `json:"shards,omitempty"`
+}
+
+// ShardUsage is the evaluation of one m_DB shard within an EvalUsage.
+type ShardUsage struct {
+	Shard       int     `json:"shard"`
+	EvalMS      float64 `json:"eval_ms"`      // ct × pt for this shard alone
+	ResultBytes int     `json:"result_bytes"` // marshalled result ciphertext
+	QueueMS     float64 `json:"queue_ms"`     // from submission until a worker took it
+	Worker      int     `json:"worker"`       // evaluation worker that ran it
+}
+
+var allocSamples = []metrics.Sample{
+	{Name: "/gc/heap/allocs:bytes"},
+	{Name: "/gc/heap/allocs:objects"},
+}
+
+func readAllocs() (bytes, objects uint64) {
+	s := make([]metrics.Sample, len(allocSamples))
+	copy(s, allocSamples)
+	metrics.Read(s)
+	return s[0].Value.Uint64(), s[1].Value.Uint64()
+}
+
+// MeasureEval runs f pinned to one OS thread and reports its wall time,
+// thread CPU time and heap allocations. Allocation counters are process
+// wide, so concurrent evaluations inflate each other's figures.
+func MeasureEval(f func() error) (EvalUsage, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	b0, o0 := readAllocs()
+	cpu0, cpuOK := threadCPUTime()
+	start := time.Now()
+
+	err := f()
+
+	u := EvalUsage{WallMS: msSince(start), CPUMS: -1}
+	if cpu1, ok := threadCPUTime(); ok && cpuOK {
+		u.CPUMS = float64((cpu1 - cpu0).Nanoseconds()) / 1e6
+	}
+	b1, o1 := readAllocs()
+	u.AllocBytes, u.Allocs = b1-b0, o1-o0
+	return u, err
+}
+
+// Merge adds v's wall time, CPU time and allocations to u. CPU time
+// stays -1 once either side could not measure it.
+func (u *EvalUsage) Merge(v EvalUsage) {
+	u.WallMS += v.WallMS
+	if u.CPUMS >= 0 && v.CPUMS >= 0 {
+		u.CPUMS += v.CPUMS
+	} else {
+		u.CPUMS = -1
+	}
+	u.AllocBytes += v.AllocBytes
+	u.Allocs += v.Allocs
+}
+
+func msSince(t time.Time) float64 { return float64(time.Since(t).Nanoseconds()) / 1e6 }
+
+// UsageTotals aggregates EvalUsage per client identity.
+type UsageTotals struct {
+	mtx sync.Mutex
+	by  map[string]*ClientUsage
+}
+
+// ClientUsage is the running total of one identity.
+type ClientUsage struct {
+	Client     string  `json:"client"`
+	Queries    uint64  `json:"queries"`
+	WallMS     float64 `json:"wall_ms"`
+	CPUMS      float64 `json:"cpu_ms"`
+	AllocBytes uint64  `json:"alloc_bytes"`
+	Allocs     uint64  `json:"allocs"`
+}
+
+// Add charges u to client ("" is recorded as "anonymous").
+func (t *UsageTotals) Add(client string, u EvalUsage) { t.AddQueries(client, 1, u) }
+
+// AddQueries charges u, the evaluation of a batch of queries, to client.
+func (t *UsageTotals) AddQueries(client string, queries int, u EvalUsage) {
+	if client == "" {
+		client = "anonymous"
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.by == nil {
+		t.by = map[string]*ClientUsage{}
+	}
+	c, ok := t.by[client]
+	if !ok {
+		c = &ClientUsage{Client: client}
+		t.by[client] = c
+	}
+	c.Queries += uint64(queries)
+	c.WallMS += u.WallMS
+	if u.CPUMS > 0 {
+		c.CPUMS += u.CPUMS
+	}
+	c.AllocBytes += u.AllocBytes
+	c.Allocs += u.Allocs
+}
+
+// Snapshot returns the totals sorted by client.
+func (t *UsageTotals) Snapshot() []ClientUsage {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	out := make([]ClientUsage, 0, len(t.by))
+	for _, c := range t.by {
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Client < out[j].Client })
+	return out
+}
+
+/********* LATENCY STATISTICS *************************************/
+
+// LatencyBucketsMS are the upper bounds of the PerfStats histogram; one
+// overflow bucket follows the last.
+var LatencyBucketsMS = []float64{5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000}
+
+// PerfWindow is how many recent evaluations the rolling figures cover.
+const PerfWindow = 256
+
+// PerfKey is a parameter set: ring size and the number of plaintext
+// shards one query is evaluated against.
+type PerfKey struct {
+	LogN   int
+	Shards int
+}
+
+// PerfStats keeps evaluation latency per PerfKey: a histogram and totals
+// since the first sample, and the last PerfWindow samples, whose mean
+// against the all-time mean shows drift.
+type PerfStats struct {
+	mtx sync.Mutex
+	by  map[PerfKey]*perfSeries
+}
+
+type perfSeries struct {
+	count, timeouts uint64
+	sum, min, max   float64
+	counts          []uint64
+	window          []float64 // ring buffer, next is the oldest once full
+	next            int
+	since           time.Time
+}
+
+// PerfSnapshot is the JSON view of one PerfKey.
+type PerfSnapshot struct {
+	LogN     int       `json:"logN"`
+	Shards   int       `json:"shards"`
+	Since    time.Time `json:"since"`
+	Queries  uint64    `json:"queries"`
+	Timeouts uint64    `json:"timeouts"`
+	MeanMS   float64   `json:"mean_ms"`
+	MinMS    float64   `json:"min_ms"`
+	MaxMS    float64   `json:"max_ms"`
+	// Counts[i] is the number of evaluations <= BucketsMS[i] (and above the
+	// previous bound); the last entry counts those above every bound.
+	BucketsMS []float64 `json:"buckets_le_ms"`
+	Counts    []uint64  `json:"counts"`
+	// Rolling figures over the last Window evaluations.
+	Window       int     `json:"window"`
+	WindowMeanMS float64 `json:"window_mean_ms"`
+	P50MS        float64 `json:"p50_ms"`
+	P90MS        float64 `json:"p90_ms"`
+	P99MS        float64 `json:"p99_ms"`
+	// DriftPct is WindowMeanMS relative to MeanMS, in percent.
+	DriftPct float64 `json:"drift_pct"`
+}
+
+func (s *PerfStats) series(k PerfKey) *perfSeries {
+	if s.by == nil {
+		s.by = map[PerfKey]*perfSeries{}
+	}
+	p, ok := s.by[k]
+	if !ok {
+		p = &perfSeries{counts: make([]uint64, len(LatencyBucketsMS)+1), since: time.Now()}
+		s.by[k] = p
+	}
+	return p
+}
+
+// Add records one evaluation of wallMS under k.
+func (s *PerfStats) Add(k PerfKey, wallMS float64) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	p := s.series(k)
+	if p.count == 0 || wallMS < p.min {
+		p.min = wallMS
+	}
+	p.max = max(p.max, wallMS)
+	p.count++
+	p.sum += wallMS
+	p.counts[sort.SearchFloat64s(LatencyBucketsMS, wallMS)]++
+	if len(p.window) < PerfWindow {
+		p.window = append(p.window, wallMS)
+	} else {
+		p.window[p.next] = wallMS
+		p.next = (p.next + 1) % PerfWindow
+	}
+}
+
+// AddTimeout records an evaluation under k that hit its deadline.
+func (s *PerfStats) AddTimeout(k PerfKey) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.series(k).timeouts++
+}
+
+// Snapshot returns every parameter set seen, sorted by LogN then Shards.
+func (s *PerfStats) Snapshot() []PerfSnapshot {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	out := make([]PerfSnapshot, 0, len(s.by))
+	for k, p := range s.by {
+		snap := PerfSnapshot{
+			LogN: k.LogN, Shards: k.Shards, Since: p.since,
+			Queries: p.count, Timeouts: p.timeouts, MinMS: p.min, MaxMS: p.max,
+			BucketsMS: LatencyBucketsMS, Counts: append([]uint64(nil), p.counts...),
+			Window: len(p.window),
+		}
+		if p.count > 0 {
+			snap.MeanMS = p.sum / float64(p.count)
+		}
+		if len(p.window) > 0 {
+			w := append([]float64(nil), p.window...)
+			sort.Float64s(w)
+			sum := 0.0
+			for _, v := range w {
+				sum += v
+			}
+			snap.WindowMeanMS = sum / float64(len(w))
+			snap.P50MS, snap.P90MS, snap.P99MS = quantile(w, 0.50), quantile(w, 0.90), quantile(w, 0.99)
+			if snap.MeanMS > 0 {
+				snap.DriftPct = 100 * (snap.WindowMeanMS - snap.MeanMS) / snap.MeanMS
+			}
+		}
+		out = append(out, snap)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].LogN != out[j].LogN {
+			return out[i].LogN < out[j].LogN
+		}
+		return out[i].Shards < out[j].Shards
+	})
+	return out
+}
+
+// quantile is the nearest-rank q-quantile of sorted.
+func quantile(sorted []float64, q float64) float64 {
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	return sorted[min(max(i, 0), len(sorted)-1)]
+}
@@ -0,0 +1,358 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var Debug = true
+
+type response struct {
+	Response string `json:"response,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Metadata mirrors the server's GetMetadata response.
+type Metadata struct {
+	NRecords int    `json:"n"`
+	RecordS  int    `json:"record_s"`
+	LogN     int    `json:"logN"`
+	N        int    `json:"N"`
+	T        uint64 `json:"t"`
+	LogQi    []int  `json:"logQi"`
+	LogPi    []int  `json:"logPi"`
+	// ReservedFrom, if > 0, is the first reserved index: n counts the
+	// windows held empty for future appends, records exist for
+	// [0, ReservedFrom) only. Absent from servers without reservations.
+	ReservedFrom int `json:"reserved_from,omitempty"`
+	// Scheme is the HE scheme of the params (SchemeBGV, SchemeBFV,
+	// SchemeCKKS); absent from servers that only run BGV.
+	Scheme string `json:"scheme,omitempty"`
+	// Packing is the record packing mode (Packing1B, Packing2B); absent
+	// from servers that only pack one byte per slot.
+	Packing string `json:"packing,omitempty"`
+	// SlotCount is the params' MaxSlots, the slots records are packed
+	// into; absent from older servers (see Slots).
+	SlotCount int `json:"slots,omitempty"`
+}
+
+// Live returns the number of indices that hold a record.
+func (m Metadata) Live() int { return NewIndexContract(m).Live() }
+
+// IsReserved reports whether index i is a reserved (empty) window.
+func (m Metadata) IsReserved(i int) bool { return NewIndexContract(m).IsReserved(i) }
+
+// UnmarshalJSON accepts every metadata shape deployed so far: the flat
+// object, the {result: …} and older {metadata: …} envelopes (with
+// execution_time_ms), and the mini-chaincode names numRecords/slotsPerRec.
+func (m *Metadata) UnmarshalJSON(b []byte) error {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(b, &probe); err != nil {
+		return err
+	}
+	for _, k := range []string{"result", "metadata"} {
+		if inner, ok := probe[k]; ok && len(inner) > 0 && inner[0] == '{' {
+			return m.UnmarshalJSON(inner)
+		}
+	}
+
+	type plain Metadata // drops the UnmarshalJSON method
+	var v struct {
+		plain
+		NumRecords  int `json:"numRecords"`
+		SlotsPerRec int `json:"slotsPerRec"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*m = Metadata(v.plain)
+	if m.NRecords == 0 {
+		m.NRecords = v.NumRecords
+	}
+	if m.RecordS == 0 {
+		m.RecordS = v.SlotsPerRec
+	}
+	if m.N == 0 && m.LogN > 0 {
+		m.N = 1 << m.LogN
+	}
+	return nil
+}
+
+// Equal reports whether m and o describe the same layout and params, i.e.
+// whether a ct_q built from one is answered correctly under the other.
+func (m Metadata) Equal(o Metadata) bool {
+	return m.NRecords == o.NRecords && m.RecordS == o.RecordS && m.LogN == o.LogN &&
+		m.T == o.T && slices.Equal(m.LogQi, o.LogQi) && slices.Equal(m.LogPi, o.LogPi) &&
+		m.HEScheme() == o.HEScheme() && m.PackingMode() == o.PackingMode()
+}
+
+// MetaQuery is the MetaAndQuery response: the metadata and capabilities a
+// cold-start client would fetch with GetMetadata and GetCapabilities, and
+// the PIRQuery response to the ct_q it sent along, all read in one
+// transaction. Result is empty when no ct_q was sent or when it does not
+// fit the committed params (QueryError says why); the client then builds
+// ct_q from Metadata and queries again.
+type MetaQuery struct {
+	Metadata     Metadata     `json:"metadata"`
+	Capabilities Capabilities `json:"capabilities"`
+	Result       string       `json:"result,omitempty"` // Base64 ct_r
+	QueryError   string       `json:"query_error,omitempty"`
+}
+
+// BGVParamHint: optional inputs for building bgv.Parameters.
+// Any empty field falls back to a sensible default.
+type BGVParamHint struct {
+	LogN  int
+	LogQi []int
+	LogPi []int
+	T     uint64
+	// Scheme selects the HE scheme built on the same ring (ParseScheme);
+	// empty is SchemeBGV. T is ignored under SchemeCKKS.
+	Scheme string
+}
+
+// Defaults filled in by BGVParamHint.Resolved.
+const DefaultT = 65537
+
+var (
+	DefaultLogQi = []int{54}
+	DefaultLogPi = []int{54}
+)
+
+// Resolved checks LogN and fills the omitted moduli with the defaults, so
+// every HE backend builds the same ring from the same hint.
+func (h BGVParamHint) Resolved() (BGVParamHint, error) {
+	if h.LogN <= 0 {
+		return h, fmt.Errorf("LogN must be set (>0) in BGVParamHint")
+	}
+	scheme, err := ParseScheme(h.Scheme)
+	if err != nil {
+		return h, err
+	}
+	h.Scheme = scheme
+	if h.T == 0 {
+		h.T = DefaultT
+	}
+	if len(h.LogQi) == 0 {
+		h.LogQi = DefaultLogQi
+	}
+	if len(h.LogPi) == 0 {
+		h.LogPi = DefaultLogPi
+	}
+	return h, nil
+}
+
+// HintFromMetadata is the hint that rebuilds the params behind m.
+func HintFromMetadata(m Metadata) BGVParamHint {
+	return BGVParamHint{LogN: m.LogN, LogQi: m.LogQi, LogPi: m.LogPi, T: m.T, Scheme: m.Scheme}
+}
+
+// ChooseLogN selects the smallest feasible logN such that
+// n * slotsPerRec <= 2^logN. Returns error if no feasible logN found.
+// It is the single-shard special case of PlanLogN.
+func ChooseLogN(n int, slotsPerRec int) (int, error) {
+	plan, err := PlanLogN(n, slotsPerRec, PlanOptions{MaxShards: 1})
+	if err != nil {
+		return 0, err
+	}
+	return plan.LogN, nil
+}
+
+// CalcSlotsPerRec calculates slots per record based on the actual records
+func CalcSlotsPerRec(records [][]byte) int {
+	max := 0
+	for _, recBytes := range records {
+		if len(recBytes) > max {
+			max = len(recBytes)
+		}
+	}
+	slotsPerRec := RoundRecordS(max)
+
+	log.Printf("[DEBUG] Max actual JSON len = %d bytes", max)
+	log.Printf("[DEBUG] slotsPerRec calculated = %d  ( = %d × 8-byte blocks)",
+		slotsPerRec, slotsPerRec/8)
+
+	return slotsPerRec
+}
+
+/********* UTILS *************************************************/
+func ShouldPrintDebug(i, total int) bool {
+	// Print first 3 and last 3 records
+	return i < 3 || i >= total-3
+}
+
+func FakeHash(prefix string, i int, length int) string {
+	if length <= 0 {
+		return ""
+	}
+	base := prefix + strconv.Itoa(i)
+	hash := sha256.Sum256([]byte(base))
+	hexStr := hex.EncodeToString(hash[:])
+
+	for len(hexStr) < length {
+		base += "x"
+		h := sha256.Sum256([]byte(base))
+		hexStr += hex.EncodeToString(h[:])
+	}
+	return hexStr[:length]
+}
+
+// SeededFakeHash is FakeHash under seed: the same for the same seed on
+// every machine, different across seeds. An empty seed is FakeHash, so
+// unseeded datasets keep their records.
+func SeededFakeHash(seed, prefix string, i int, length int) string {
+	if seed == "" {
+		return FakeHash(prefix, i, length)
+	}
+	return FakeHash(seed+"/"+prefix, i, length)
+}
+
+// parseRecordIndex extracts the numeric index from keys like "record013" → 13.
+// Returns (idx, true) on success, or (0, false) if the key doesn't match.
+func ParseRecordIndex(key string) (int, bool) {
+	const pfx = "record"
+	if !strings.HasPrefix(key, pfx) {
+		return 0, false
+	}
+	s := key[len(pfx):]
+	idx, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, false
+	}
+	return idx, true
+}
+
+// hexHead returns up to the first n bytes of b as a hex string (no "0x"),
+// with "..." suffix if truncated.
+func HexHead(b []byte, n int) string {
+	if len(b) == 0 {
+		return ""
+	}
+	if len(b) <= n {
+		return hex.EncodeToString(b)
+	}
+	return hex.EncodeToString(b[:n]) + "..."
+}
+
+func WriteOK(w http.ResponseWriter, resp string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response{Response: resp})
+}
+func WriteErr(w http.ResponseWriter, err error) {
+	WriteErrStatus(w, http.StatusBadRequest, err)
+}
+
+// WriteErrStatus is WriteErr with an explicit HTTP status (401, 404, ...).
+func WriteErrStatus(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response{Error: err.Error()})
+}
+
+/********* TIMED RESPONSES ****************************************/
+
+// TimedResponse is the envelope returned by the *Timed server functions:
+// the plain function's payload under "result" plus server-side wall time.
+type TimedResponse struct {
+	Result          json.RawMessage `json:"result"`
+	ExecutionTimeMS float64         `json:"execution_time_ms"`
+	Usage           *EvalUsage      `json:"usage,omitempty"` // PIR evaluations only
+}
+
+// MarshalTimed wraps result into a TimedResponse measured from start.
+// []byte results are embedded verbatim when they are valid JSON
+// (e.g. stored records) and as a JSON string otherwise.
+func MarshalTimed(result interface{}, start time.Time) (string, error) {
+	return MarshalTimedUsage(result, start, nil)
+}
+
+// MarshalTimedUsage is MarshalTimed with the evaluation's resource usage.
+func MarshalTimedUsage(result interface{}, start time.Time, usage *EvalUsage) (string, error) {
+	return marshalEnvelope(result, float64(time.Since(start).Nanoseconds())/1e6, usage)
+}
+
+// MarshalUntimed is the TimedResponse of result with execution_time_ms 0,
+// for transactions whose endorsers must return identical responses (a
+// measured time differs from peer to peer).
+func MarshalUntimed(result interface{}) (string, error) {
+	return marshalEnvelope(result, 0, nil)
+}
+
+func marshalEnvelope(result interface{}, ms float64, usage *EvalUsage) (string, error) {
+	var raw []byte
+	var err error
+	if b, ok := result.([]byte); ok && json.Valid(b) {
+		raw = b
+	} else if ok {
+		raw, err = json.Marshal(string(b))
+	} else {
+		raw, err = json.Marshal(result)
+	}
+	if err != nil {
+		return "", fmt.Errorf("marshal timed result: %w", err)
+	}
+	out, err := json.Marshal(TimedResponse{
+		Result:          raw,
+		ExecutionTimeMS: ms,
+		Usage:           usage,
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshal timed response: %w", err)
+	}
+	return string(out), nil
+}
+
+// legacyResultKeys hold the payload of the timed responses deployed before
+// TimedResponse: PIRQueryAuto's "encrypted_result" and InitLedger's
+// "status", each next to execution_time_ms.
+var legacyResultKeys = []string{"encrypted_result", "status"}
+
+// UnwrapTimed splits a TimedResponse, or one of the legacy timed shapes,
+// into its payload and execution time. ok is false when raw is not an
+// envelope (plain function output).
+func UnwrapTimed(raw []byte) (result json.RawMessage, execMS float64, ok bool) {
+	var probe map[string]json.RawMessage
+	if json.Unmarshal(raw, &probe) != nil {
+		return nil, 0, false
+	}
+	t, hasT := probe["execution_time_ms"]
+	res, hasRes := probe["result"]
+	for _, k := range legacyResultKeys {
+		if hasRes || !hasT {
+			break
+		}
+		res, hasRes = probe[k]
+	}
+	if !hasRes {
+		return nil, 0, false
+	}
+	if hasT {
+		_ = json.Unmarshal(t, &execMS)
+	}
+	return res, execMS, true
+}
+
+// ParseMetadata decodes GetMetadata output in any shape accepted by
+// Metadata.UnmarshalJSON. execMS is -1 when the server did not report
+// a timing.
+func ParseMetadata(raw []byte) (meta Metadata, execMS float64, err error) {
+	execMS = -1
+	var probe struct {
+		ExecutionTimeMS *float64 `json:"execution_time_ms"`
+	}
+	if json.Unmarshal(raw, &probe) == nil && probe.ExecutionTimeMS != nil {
+		execMS = *probe.ExecutionTimeMS
+	}
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return Metadata{}, execMS, fmt.Errorf("parse metadata: %w", err)
+	}
+	return meta, execMS, nil
+}
@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestMarshalTimedRoundTrip(t *testing.T) {
+	meta := Metadata{NRecords: 64, RecordS: 128, LogN: 13, N: 8192, T: 65537, LogQi: []int{54}, LogPi: []int{55}}
+	metaJSON, _ := json.Marshal(meta)
+
+	cases := []struct {
+		name string
+		in   interface{}
+		want string
+	}{
+		{"string", "success", `"success"`},
+		{"bytes, valid JSON", []byte(`{"id":"r1"}`), `{"id":"r1"}`},
+		{"bytes, not JSON", []byte("Y3RfcQ=="), `"Y3RfcQ=="`},
+		{"struct", meta, string(metaJSON)},
+		{"map", map[string]int{"received": 1, "total": 3}, `{"received":1,"total":3}`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			out, err := MarshalTimed(c.in, time.Now())
+			if err != nil {
+				t.Fatalf("MarshalTimed: %v", err)
+			}
+			res, ms, ok := UnwrapTimed([]byte(out))
+			if !ok {
+				t.Fatalf("UnwrapTimed(%s): not an envelope", out)
+			}
+			if string(res) != c.want {
+				t.Errorf("result = %s, want %s", res, c.want)
+			}
+			if ms < 0 {
+				t.Errorf("execution_time_ms = %v, want >= 0", ms)
+			}
+		})
+	}
+}
+
+func TestMarshalUntimed(t *testing.T) {
+	out, err := MarshalUntimed("success")
+	if err != nil {
+		t.Fatalf("MarshalUntimed: %v", err)
+	}
+	if want := `{"result":"success","execution_time_ms":0}`; out != want {
+		t.Errorf("MarshalUntimed = %s, want %s", out, want)
+	}
+}
+
+func TestUnwrapTimed(t *testing.T) {
+	cases := []struct {
+		name   string
+		raw    string
+		want   string
+		wantMS float64
+		ok     bool
+	}{
+		{"envelope", `{"result":{"n":3},"execution_time_ms":1.5}`, `{"n":3}`, 1.5, true},
+		{"envelope without time", `{"result":"x"}`, `"x"`, 0, true},
+		{"PIRQueryAuto legacy", `{"encrypted_result":"Y3Rfcg==","execution_time_ms":2}`, `"Y3Rfcg=="`, 2, true},
+		{"InitLedger legacy", `{"status":"success","execution_time_ms":3.25}`, `"success"`, 3.25, true},
+		{"result wins over legacy key", `{"result":"new","status":"old","execution_time_ms":1}`, `"new"`, 1, true},
+		{"plain object", `{"n":3,"record_s":128}`, "", 0, false},
+		{"plain status without time", `{"status":"ok"}`, "", 0, false},
+		{"plain string", `"success"`, "", 0, false},
+		{"not JSON", `Y3Rfcg==`, "", 0, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, ms, ok := UnwrapTimed([]byte(c.raw))
+			if ok != c.ok {
+				t.Fatalf("ok = %v, want %v", ok, c.ok)
+			}
+			if !ok {
+				return
+			}
+			if string(res) != c.want || ms != c.wantMS {
+				t.Errorf("got (%s, %v), want (%s, %v)", res, ms, c.want, c.wantMS)
+			}
+		})
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	want := Metadata{NRecords: 64, RecordS: 128, LogN: 13, N: 8192, T: 65537, LogQi: []int{54}, LogPi: []int{55}}
+	plain, _ := json.Marshal(want)
+	timed, _ := MarshalTimed(want, time.Now())
+	timedBytes, _ := MarshalTimed(plain, time.Now())
+
+	cases := []struct {
+		name   string
+		raw    string
+		timed  bool // execution_time_ms reported
+		wantMS float64
+	}{
+		{"plain", string(plain), false, -1},
+		{"envelope of struct", timed, true, 0},
+		{"envelope of []byte", timedBytes, true, 0},
+		{"metadata envelope", `{"metadata":` + string(plain) + `,"execution_time_ms":4}`, true, 4},
+		{"mini-chaincode names", `{"numRecords":64,"slotsPerRec":128,"logN":13,"t":65537,"logQi":[54],"logPi":[55]}`, false, -1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, ms, err := ParseMetadata([]byte(c.raw))
+			if err != nil {
+				t.Fatalf("ParseMetadata: %v", err)
+			}
+			if !got.Equal(want) || got.N != want.N {
+				t.Errorf("metadata = %+v, want %+v", got, want)
+			}
+			if c.timed && ms < 0 || !c.timed && ms != -1 {
+				t.Errorf("execution_time_ms = %v (timed %v)", ms, c.timed)
+			}
+			if c.wantMS > 0 && ms != c.wantMS {
+				t.Errorf("execution_time_ms = %v, want %v", ms, c.wantMS)
+			}
+		})
+	}
+
+	if _, _, err := ParseMetadata([]byte(`{"n":"sixty-four"}`)); err == nil {
+		t.Error("ParseMetadata accepted a string n")
+	}
+}